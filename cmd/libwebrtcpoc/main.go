@@ -0,0 +1,105 @@
+//go:build cgo
+
+// Command libwebrtcpoc is built as a C shared library exposing send and
+// receive sessions to programs in other languages:
+//
+//	go build -buildmode=c-shared -o libwebrtcpoc.so ./cmd/libwebrtcpoc
+//
+// which also writes libwebrtcpoc.h. Sessions are named by handles greater
+// than zero. Strings returned by the library are owned by the caller, who
+// releases them with wp_free.
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/developmeh/webrtc-poc/internal/ffi"
+)
+
+var (
+	errMu   sync.Mutex
+	lastErr string
+)
+
+// fail records err for wp_last_error and returns the value that reports it
+func fail(err error) C.longlong {
+	errMu.Lock()
+	defer errMu.Unlock()
+	lastErr = err.Error()
+	return -1
+}
+
+// wp_send_start serves file to receivers that post their offer to
+// http://addr/offer, waiting delay_ms between lines. It returns the
+// session's handle, or -1 on errors.
+//
+//export wp_send_start
+func wp_send_start(file, addr *C.char, delayMs C.int) C.longlong {
+	h, err := ffi.StartSend(C.GoString(file), C.GoString(addr), time.Duration(delayMs)*time.Millisecond)
+	if err != nil {
+		return fail(err)
+	}
+	return C.longlong(h)
+}
+
+// wp_receive_start receives the file of the sender whose offer endpoint is
+// url into output. It returns the session's handle, or -1 on errors.
+//
+//export wp_receive_start
+func wp_receive_start(url, output *C.char) C.longlong {
+	h, err := ffi.StartReceive(C.GoString(url), C.GoString(output))
+	if err != nil {
+		return fail(err)
+	}
+	return C.longlong(h)
+}
+
+// wp_status returns the status of a session as a JSON object, or NULL for
+// an unknown handle
+//
+//export wp_status
+func wp_status(h C.longlong) *C.char {
+	status, err := ffi.StatusJSON(int64(h))
+	if err != nil {
+		fail(err)
+		return nil
+	}
+	return C.CString(string(status))
+}
+
+// wp_stop ends a session, closing its connections, and releases its handle.
+// It returns 0, or -1 for an unknown handle.
+//
+//export wp_stop
+func wp_stop(h C.longlong) C.int {
+	if err := ffi.Stop(int64(h)); err != nil {
+		return C.int(fail(err))
+	}
+	return 0
+}
+
+// wp_last_error returns the message of the last error reported by -1 or
+// NULL
+//
+//export wp_last_error
+func wp_last_error() *C.char {
+	errMu.Lock()
+	defer errMu.Unlock()
+	return C.CString(lastErr)
+}
+
+// wp_free releases a string returned by the library
+//
+//export wp_free
+func wp_free(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+func main() {}
@@ -1,36 +1,283 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/charmbracelet/x/term"
+	"github.com/developmeh/webrtc-poc/internal/autostun"
+	"github.com/developmeh/webrtc-poc/internal/batch"
+	"github.com/developmeh/webrtc-poc/internal/bundle"
+	"github.com/developmeh/webrtc-poc/internal/checksum"
+	"github.com/developmeh/webrtc-poc/internal/client"
+	"github.com/developmeh/webrtc-poc/internal/config"
+	"github.com/developmeh/webrtc-poc/internal/deadline"
+	"github.com/developmeh/webrtc-poc/internal/dedup"
+	"github.com/developmeh/webrtc-poc/internal/diagnose"
+	"github.com/developmeh/webrtc-poc/internal/discovery"
+	"github.com/developmeh/webrtc-poc/internal/exports"
+	"github.com/developmeh/webrtc-poc/internal/fec"
+	"github.com/developmeh/webrtc-poc/internal/fips"
+	"github.com/developmeh/webrtc-poc/internal/flags"
+	"github.com/developmeh/webrtc-poc/internal/forward"
+	"github.com/developmeh/webrtc-poc/internal/groups"
+	"github.com/developmeh/webrtc-poc/internal/identity"
+	"github.com/developmeh/webrtc-poc/internal/interceptors"
+	"github.com/developmeh/webrtc-poc/internal/keyring"
 	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/maintenance"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+	"github.com/developmeh/webrtc-poc/internal/pacing"
+	"github.com/developmeh/webrtc-poc/internal/pathpolicy"
+	"github.com/developmeh/webrtc-poc/internal/peer"
+	"github.com/developmeh/webrtc-poc/internal/pipe"
+	"github.com/developmeh/webrtc-poc/internal/preflight"
+	"github.com/developmeh/webrtc-poc/internal/profiling"
+	"github.com/developmeh/webrtc-poc/internal/records"
+	"github.com/developmeh/webrtc-poc/internal/rendezvous"
+	"github.com/developmeh/webrtc-poc/internal/sample"
+	"github.com/developmeh/webrtc-poc/internal/schedule"
+	"github.com/developmeh/webrtc-poc/internal/seal"
+	"github.com/developmeh/webrtc-poc/internal/server"
+	"github.com/developmeh/webrtc-poc/internal/sessions"
+	"github.com/developmeh/webrtc-poc/internal/share"
+	"github.com/developmeh/webrtc-poc/internal/signaling"
+	"github.com/developmeh/webrtc-poc/internal/sink"
+	"github.com/developmeh/webrtc-poc/internal/source"
+	"github.com/developmeh/webrtc-poc/internal/subscription"
+	"github.com/developmeh/webrtc-poc/internal/trace"
+	"github.com/developmeh/webrtc-poc/internal/tunnel"
+	"github.com/developmeh/webrtc-poc/internal/update"
+	"github.com/developmeh/webrtc-poc/internal/upload"
+	"github.com/developmeh/webrtc-poc/internal/webui"
+	"github.com/developmeh/webrtc-poc/pkg/receiver"
+	"github.com/developmeh/webrtc-poc/pkg/sender"
+	"github.com/fsnotify/fsnotify"
 	"github.com/pion/webrtc/v3"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
+// Build metadata, set by goreleaser
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
 var (
 	cfgFile string
+	profile string
 
 	// Server command flags
 	serverAddr  string
 	serverFile  string
 	serverDelay int
-	stunServer  string
+	serverRate  string
+	serverICE   []string
+	serverToken string
+	serverAuto  bool
+	serverLocal bool
+	serverPace  bool
+	serverBy    string
+	serverKey   string
+	serverAllow []string
+	serverNoise bool
+	serverRelay string
+	serverMedia []string
+	serverLabel string
+	serverProto string
+	serverChan  uint16
+	serverNegot bool
+	serverRole  string
+	serverShare string
+	serverIndex string
+	serverMMap  bool
+	serverAhead string
+	serverLimit string
+	serverRaw   bool
+	serverFwd   string
+	serverDests []string
+	serverLossy bool
+	serverFEC   string
+	serverDupes string
+	serverAdmin string
+	serverUpDir string
+	serverHold  string
+	serverUpMax string
+	serverTypes []string
+	serverScan  string
+	serverClash string
+	serverPerID bool
+	serverFIPS  bool
+	serverLinks bool
+	serverStamp bool
+	serverQuick bool
+	serverBatch int
+	serverBatSz string
+	serverTries int
+	serverPause time.Duration
+	serverBurst []string
+	serverPeers []string
+	serverPool  int
+	serverMem   string
+	serverDebug string
+	serverSums  []string
+	serverMDNS  bool
+	serverMDNSA string
+	serverLogs  bool
+	serverTrace string
+	serverRecSz string
+	serverLong  string
+	serverIdle  time.Duration
+	serverConns int
+	serverTUI   bool
+	serverCast  bool
+	serverTail  bool
+	serverGrpOf string
+	serverKeep  time.Duration
+	serverKeepD string
+	serverWebUI bool
+	serverCORS  []string
+	serverCheck bool
+	serverIPCap int
+	serverIDCap int
+	serverCool  time.Duration
+	serverStall time.Duration
+	serverStuck string
+	serverSig   string
 
 	// Client command flags
-	clientServer string
-	clientOutput string
-	clientStun   string
+	clientServer  string
+	clientOutput  string
+	clientICE     []string
+	clientMetrics string
+	clientToken   string
+	clientAuto    bool
+	clientLocal   bool
+	clientDaemon  bool
+	clientName    string
+	clientFilter  string
+	clientState   string
+	clientKey     string
+	clientPeer    string
+	clientNoise   bool
+	clientRelay   string
+	clientCode    string
+	clientMedia   []string
+	clientLabel   string
+	clientProto   string
+	clientChan    uint16
+	clientNegot   bool
+	clientRole    string
+	clientSignal  string
+	clientFetch   []string
+	clientDir     string
+	clientList    string
+	clientJobs    int
+	clientCache   string
+	clientExec    string
+	clientRestart string
+	clientRetries int
+	clientFwd     string
+	clientUploads []string
+	clientFIPS    bool
+	clientLinks   bool
+	clientTmpl    string
+	clientRoll    string
+	clientBeat    time.Duration
+	clientConnTO  time.Duration
+	clientWrRate  string
+	clientWrBuf   string
+	clientWindow  int
+	clientTrickle bool
+	clientSums    []string
+	clientExport  string
+	clientLogs    bool
+	clientTrace   string
+	clientRecSz   string
+	clientLong    string
+	clientGroup   string
+	clientSince   time.Duration
+	clientSinceN  int
+	clientSeal    bool
+	clientSealKey string
+	clientTUI     bool
+	clientBatch   bool
+
+	// Identity command flags
+	identityFile string
+	identitySign string
+	identityVers string
+
+	// Signal command flags
+	signalAddr string
+	signalTTL  time.Duration
+
+	// Send and receive command flags
+	sendRelay    string
+	sendDelay    int
+	sendRate     string
+	receiveRelay string
+	receiveOut   string
+
+	// Diagnose command flags
+	diagnosePorts   []int
+	diagnoseTimeout time.Duration
+
+	// Discover command flags
+	discoverTimeout time.Duration
+
+	// Tunnel command flags
+	tunnelServer string
+	tunnelLocal  []string
+
+	// Pipe command flags
+	pipeAddr   string
+	pipeServer string
+
+	// Mirror command flags
+	mirrorServer   string
+	mirrorDest     string
+	mirrorInclude  []string
+	mirrorInterval time.Duration
+
+	// Profile command flags
+	profSocket   string
+	profDuration time.Duration
+	profOutput   string
+	profTop      int
+
+	// Self-update command flags
+	updateURL   string
+	updateKey   string
+	updateCheck bool
+	updateForce bool
+
+	// Trace command flags
+	traceWidth   int
+	traceSession []string
+
+	// Ctl command flags
+	ctlServer string
+	ctlToken  string
+	ctlRetry  time.Duration
+	ctlReason string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -48,6 +295,10 @@ var serverCmd = &cobra.Command{
 	Long: `Start the WebRTC file streaming server that will stream a file line by line.
 The server will listen for WebRTC connections and stream the specified file.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if serverCheck {
+			runServerCheck()
+			return
+		}
 		runServer()
 	},
 }
@@ -63,6 +314,274 @@ The client will connect to the specified server and receive the file line by lin
 	},
 }
 
+// identityCmd represents the identity command
+var identityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Print this peer's identity",
+	Long: `Print the identity of this peer, creating its Ed25519 key on first use.
+Add a client's identity to the server's --allow-identity list, and give the
+server's identity to clients with --server-identity.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runIdentity()
+	},
+}
+
+// keyringCmd represents the keyring command
+var keyringCmd = &cobra.Command{
+	Use:   "keyring",
+	Short: "Manage the secrets stored in the OS keyring",
+	Long: `Store and delete the secrets configuration values refer to as keyring:NAME,
+so auth tokens and keys are kept in the OS keychain instead of on disk.
+Identity and state keys referred to this way are created in the keyring on
+first use.`,
+}
+
+// keyringSetCmd represents the keyring set command
+var keyringSetCmd = &cobra.Command{
+	Use:   "set NAME",
+	Short: "Store a secret read from stdin",
+	Long: `Store the secret read from stdin under NAME, replacing the one stored before.
+On a terminal the secret is prompted for without echoing it. Trailing
+newlines are dropped, so a token or an identity key file can be piped in.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runKeyringSet(args[0])
+	},
+}
+
+// keyringDeleteCmd represents the keyring delete command
+var keyringDeleteCmd = &cobra.Command{
+	Use:   "delete NAME",
+	Short: "Delete a stored secret",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := keyring.Delete(args[0]); err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// signalCmd represents the signal command
+var signalCmd = &cobra.Command{
+	Use:   "signal",
+	Short: "Run a rendezvous server",
+	Long: `Run a rendezvous server on a public host so peers that cannot reach each
+other's signaling endpoint can exchange offers and answers through a short code.
+Start the server with --rendezvous to register a code, and connect clients
+with --code.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runSignal()
+	},
+}
+
+// sendCmd represents the send command
+var sendCmd = &cobra.Command{
+	Use:   "send FILE",
+	Short: "Send a file to one peer through a rendezvous server",
+	Long: `Register a code with a rendezvous server, print it and send FILE to the first
+peer that runs receive with the code, then exit. Only the offer and answer
+pass through the rendezvous server: the file travels over a direct peer
+connection, and neither peer listens on a port. The server's configuration
+is used for the ICE servers, identity and allowed client identities.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runSend(args[0])
+	},
+}
+
+// receiveCmd represents the receive command
+var receiveCmd = &cobra.Command{
+	Use:   "receive CODE",
+	Short: "Receive a file from a peer running send",
+	Long: `Receive the file a send command offers under CODE through the rendezvous
+server, write it to --output or stdout and exit once it arrived. The client's
+configuration is used for the ICE servers, identity and server identity.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runReceive(args[0])
+	},
+}
+
+// diagnoseCmd represents the diagnose command
+var diagnoseCmd = &cobra.Command{
+	Use:   "diagnose",
+	Short: "Check how likely direct connections are to succeed",
+	Long: `Probe the NAT in front of this host through the configured STUN servers, check
+that UDP gets through on the given ports, connect two local peers and print a
+report on how likely direct connections to other peers are to succeed.
+The client's ICE configuration is used.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runDiagnose()
+	},
+}
+
+// discoverCmd represents the discover command
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "List the servers announced on the local network",
+	Long: `Ask the local network for servers started with --announce over multicast DNS
+and list their offer URLs, identities and exports, so they can be connected
+to without exchanging URLs by hand.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runDiscover()
+	},
+}
+
+// tunnelCmd represents the tunnel command
+var tunnelCmd = &cobra.Command{
+	Use:   "tunnel",
+	Short: "Forward local ports to addresses behind the server",
+	Long: `Connect to a server and forward local ports to addresses the server can reach,
+like ssh -L. Every connection to a local port becomes a stream multiplexed
+over a single data channel, and the server connects it to the target if its
+--allow-tunnel list permits it. The client's configuration is used for
+everything else.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runTunnel()
+	},
+}
+
+// pipeCmd represents the pipe command
+var pipeCmd = &cobra.Command{
+	Use:   "pipe",
+	Short: "Join stdin and stdout of two hosts, like netcat",
+	Long: `Join two hosts with a full-duplex byte pipe over a data channel, like
+netcat: what one end reads from stdin the other writes to stdout, in both
+directions at once. Start one end with pipe listen and connect the other with
+pipe connect. Log messages go to stderr, so stdout only carries the pipe.`,
+}
+
+// pipeListenCmd represents the pipe listen command
+var pipeListenCmd = &cobra.Command{
+	Use:   "listen",
+	Short: "Wait for the other end of a pipe",
+	Long: `Answer the first offer posted to /offer on --addr and join stdin and stdout
+with the peer that sent it, exiting once both ends finished. The server's
+configuration is used for its ICE servers and auth token.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runPipeListen()
+	},
+}
+
+// pipeConnectCmd represents the pipe connect command
+var pipeConnectCmd = &cobra.Command{
+	Use:   "connect",
+	Short: "Connect to the other end of a pipe",
+	Long: `Connect to a pipe listen end and join stdin and stdout with it, exiting once
+both ends finished. The client's configuration is used for everything but
+the server URL.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runPipeConnect()
+	},
+}
+
+// mirrorCmd represents the mirror command
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Keep a directory in sync with a server's shared directory",
+	Long: `Copy the files of a server's --share-dir that match the --include patterns
+into the --dest directory, and again every --interval. Files are requested
+through a chunk cache, so unchanged chunks are not transferred again, a pass
+that is interrupted resumes with the chunks it already received, and every
+chunk is checked against the server's hash. A file is only replaced once it
+arrived complete and differs from the local copy. Failed passes are retried
+with a growing delay. Files deleted on the server are kept. The client's
+configuration is used for everything else.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runMirror()
+	},
+}
+
+// profileCmd represents the profile command
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Profile a running server",
+	Long: `Attach to a running server through its debug socket, profile its CPU for
+--duration and capture its heap, then write both as pprof files and print the
+functions that take the most CPU and memory. Start the server with
+--debug-socket to open the socket.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runProfile()
+	},
+}
+
+// selfUpdateCmd represents the self-update command
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Replace this binary with the latest release",
+	Long: `Download the checksums of the latest release, check that they are signed by
+the release identity together with the release version, and unless the
+release is newer than this binary or --force is given, stop there. Then
+download the archive for this platform, check it against its signed checksum
+and replace this binary with the one inside it. The new binary is renamed
+over the old one, so an interrupted update leaves the old binary in place.
+Restart running commands to use the new binary.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runSelfUpdate()
+	},
+}
+
+// traceCmd represents the trace command
+var traceCmd = &cobra.Command{
+	Use:   "trace",
+	Short: "Inspect trace files",
+	Long: `Inspect the trace files servers and clients started with --trace record:
+every signaling message, state transition and control frame of their
+sessions, one JSON object per line.`,
+}
+
+// traceViewCmd represents the trace view command
+var traceViewCmd = &cobra.Command{
+	Use:   "view TRACE [TRACE]",
+	Short: "Print one trace, or two side by side",
+	Long: `Print the events of a trace file in the order they happened. Given the
+traces of both peers, print them side by side, merged on their wall clock
+times, and keep only the sessions both of them recorded, so the trace of a
+server shows the sessions of the client next to it.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runTraceView(args)
+	},
+}
+
+// ctlCmd represents the ctl command
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Control a running server",
+	Long: `Control a running server through its control API. Without --server the
+server configured in this host's config file is addressed, with its
+control_token.`,
+}
+
+// maintenanceCmd represents the ctl maintenance command
+var maintenanceCmd = &cobra.Command{
+	Use:   "maintenance on|off|status",
+	Short: "Switch the server's maintenance mode",
+	Long: `Switch the server's maintenance mode on or off, or print its state. In
+maintenance mode transfers in progress continue, but new offers are answered
+with 503 Service Unavailable and a Retry-After header, and /readyz reports
+the server as not ready.`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"on", "off", "status"},
+	Run: func(cmd *cobra.Command, args []string) {
+		runMaintenance(args[0])
+	},
+}
+
+// pushCmd represents the ctl push command
+var pushCmd = &cobra.Command{
+	Use:   "push PEER FILE",
+	Short: "Push a file to a connected daemon mode client",
+	Long: `Push a file on the server's host to a daemon mode client connected under
+the peer name, through the server's POST /push API. The ID of the push is
+printed once the server queued it; the daemon fetches it on its next poll.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runPush(args[0], args[1])
+	},
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
@@ -73,9 +592,11 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	rootCmd.Version = fmt.Sprintf("%s (commit %s, built %s, %s/%s)", version, commit, date, runtime.GOOS, runtime.GOARCH)
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "named profile from the config file to apply over the base settings")
 
 	// Initialize logger
 	logger.Init()
@@ -83,26 +604,359 @@ func init() {
 	// Add commands
 	rootCmd.AddCommand(serverCmd)
 	rootCmd.AddCommand(clientCmd)
+	rootCmd.AddCommand(identityCmd)
+	rootCmd.AddCommand(keyringCmd)
+	keyringCmd.AddCommand(keyringSetCmd)
+	keyringCmd.AddCommand(keyringDeleteCmd)
+	rootCmd.AddCommand(signalCmd)
+	rootCmd.AddCommand(sendCmd)
+	rootCmd.AddCommand(receiveCmd)
+	rootCmd.AddCommand(diagnoseCmd)
+	rootCmd.AddCommand(discoverCmd)
+	rootCmd.AddCommand(tunnelCmd)
+	rootCmd.AddCommand(pipeCmd)
+	pipeCmd.AddCommand(pipeListenCmd)
+	pipeCmd.AddCommand(pipeConnectCmd)
+	rootCmd.AddCommand(mirrorCmd)
+	rootCmd.AddCommand(profileCmd)
+	rootCmd.AddCommand(selfUpdateCmd)
+	rootCmd.AddCommand(traceCmd)
+	traceCmd.AddCommand(traceViewCmd)
+	rootCmd.AddCommand(ctlCmd)
+	ctlCmd.AddCommand(maintenanceCmd)
+	ctlCmd.AddCommand(pushCmd)
 
 	// Server flags
 	serverCmd.Flags().StringVar(&serverAddr, "addr", ":8080", "HTTP service address")
-	serverCmd.Flags().StringVar(&serverFile, "file", "sample.txt", "File to stream")
-	serverCmd.Flags().IntVar(&serverDelay, "delay", 1000, "Delay between lines in milliseconds")
-	serverCmd.Flags().StringVar(&stunServer, "stun", "", "STUN server address (leave empty for direct connection)")
+	serverCmd.Flags().StringVar(&serverFile, "file", "sample.txt", "File, directory or glob pattern to stream")
+	serverCmd.Flags().IntVar(&serverDelay, "delay", 1000, "Delay between lines in milliseconds (legacy, see --rate)")
+	serverCmd.Flags().StringVar(&serverRate, "rate", "", "Rate to send at in bytes or lines per second, e.g. 1MB/s or 100lines/s, overriding --delay")
+	serverCmd.Flags().StringArrayVar(&serverICE, "ice-server", nil, "ICE server URL, repeatable (stun:host:port or turn:user:pass@host:port?transport=tcp; leave empty for direct connection)")
+	serverCmd.Flags().BoolVar(&serverAuto, "auto-stun", false, "Fall back to public STUN servers when no ICE servers are configured and direct connections fail")
+	serverCmd.Flags().BoolVar(&serverLocal, "no-internet", false, "Never contact STUN/TURN servers, overriding --auto-stun")
+	serverCmd.Flags().StringVar(&serverToken, "auth-token", "", "Token clients must present to connect (supports env:, file:, exec: and keyring: references)")
+	serverCmd.Flags().BoolVar(&serverPace, "adaptive-pacing", false, "Slow down sending when the connection quality score drops")
+	serverCmd.Flags().StringVar(&serverKey, "identity-file", "", "Ed25519 identity key, created if missing, or keyring:NAME (default <user config dir>/webrtc-poc/identity.key)")
+	serverCmd.Flags().StringArrayVar(&serverAllow, "allow-identity", nil, "Client identity allowed to connect, repeatable (leave empty to allow any client)")
+	serverCmd.Flags().BoolVar(&serverNoise, "require-noise", false, "Only accept offers sent over Noise secured signaling")
+	serverCmd.Flags().StringVar(&serverRelay, "rendezvous", "", "Rendezvous server URL to register a code with, so clients can connect with --code")
+	serverCmd.Flags().StringArrayVar(&serverMedia, "interceptor", interceptors.Default, "Pion interceptor for media tracks, repeatable (nack, rtcp_reports, twcc or none)")
+	serverCmd.Flags().StringVar(&serverLabel, "channel-label", "fileStream", "Label of the file stream data channel")
+	serverCmd.Flags().StringVar(&serverProto, "channel-protocol", "", "Subprotocol of the file stream data channel")
+	serverCmd.Flags().Uint16Var(&serverChan, "channel-id", 0, "ID of the file stream data channel with --negotiated-channel, must match the client's")
+	serverCmd.Flags().BoolVar(&serverNegot, "negotiated-channel", false, "Pre-negotiate the file stream data channel with --channel-id instead of announcing it in-band; clients need --negotiated-channel too")
+	serverCmd.Flags().StringVar(&serverRole, "offer-role", signaling.OfferRoleClient, "Side that creates the offer: client, or server for clients started with --offer-role server")
+	serverCmd.Flags().StringVar(&serverSig, "signal", signaling.SignalHTTP, "How offers reach the server: http, manual to paste them into its terminal and the answers back into the clients', or qr to also draw the answers as QR codes")
+	serverCmd.Flags().StringVar(&serverShare, "share-dir", "", "Directory clients may request more files from over their connection (leave empty to disable)")
+	serverCmd.Flags().StringVar(&serverIndex, "chunk-index", "", "File the chunk hashes of shared files are kept in across restarts (leave empty to keep them in memory)")
+	serverCmd.Flags().StringSliceVar(&serverSums, "checksum", nil, "Checksum algorithms deduplicated requests may hash chunks with besides sha256 (sha256, blake3, xxh3), the first indexed up front (default all, only sha256 in FIPS mode)")
+	serverCmd.Flags().BoolVar(&serverMMap, "mmap", false, "Read streamed files memory mapped instead of with read calls, for large files (a transfer fails if its file is truncated while it is streamed)")
+	serverCmd.Flags().StringVar(&serverAhead, "read-ahead", "8MiB", "How far ahead of the reader a memory mapped file is paged in")
+	serverCmd.Flags().StringVar(&serverLimit, "length", "", "Number of bytes to read from --file, required to bound devices (leave empty to read to the end)")
+	serverCmd.Flags().BoolVar(&serverRaw, "raw", false, "Stream --file in chunks of raw bytes instead of lines, as block devices always are, for binary files")
+	serverCmd.Flags().StringVar(&serverFwd, "forward", "", "Local socket the client's forward channels are connected to: tcp:HOST:PORT, udp:HOST:PORT, tcp-listen:PORT or udp-listen:PORT")
+	serverCmd.Flags().StringArrayVar(&serverDests, "allow-tunnel", nil, "HOST:PORT clients may open tunnels to, repeatable, with * wildcards (leave empty to refuse tunnels)")
+	serverCmd.Flags().BoolVar(&serverLossy, "unreliable", false, "Send the file stream unordered and without retransmissions, for live data where late lines are useless")
+	serverCmd.Flags().StringVar(&serverFEC, "fec", "", "Add Reed-Solomon parity to an --unreliable file stream, as DATA:PARITY shards per group (e.g. 10:2; leave empty to disable)")
+	serverCmd.Flags().StringVar(&serverUpDir, "upload-dir", "", "Directory the files clients upload are moved to once validated (leave empty to refuse uploads)")
+	serverCmd.Flags().StringVar(&serverHold, "quarantine-dir", "", "Directory uploads are written to until they are validated (default <upload-dir>/.quarantine)")
+	serverCmd.Flags().StringVar(&serverUpMax, "upload-max-size", "", "Largest accepted upload, with an optional KiB, MiB or GiB suffix (leave empty for no limit)")
+	serverCmd.Flags().StringArrayVar(&serverTypes, "upload-type", nil, "Sniffed content type uploads must have, repeatable, with * wildcards (e.g. text/*; leave empty to accept any)")
+	serverCmd.Flags().StringVar(&serverScan, "upload-scanner", "", "Command run with the path of each quarantined upload, which rejects it by exiting unsuccessfully (e.g. 'clamdscan --no-summary')")
+	serverCmd.Flags().StringVar(&serverClash, "upload-collision", "reject", "What to do with an upload whose name already exists: reject it, overwrite the file or rename the upload with a numeric suffix")
+	serverCmd.Flags().BoolVar(&serverFIPS, "fips", false, "Restrict the application-layer crypto to FIPS 140-3 approved algorithms (always on in fips builds)")
+	serverCmd.Flags().BoolVar(&serverStamp, "timestamps", false, "Send each line of the file stream with the time it was sent, so clients report the per-line latency")
+	serverCmd.Flags().IntVar(&serverTries, "send-retries", 3, "Most times a line that failed to send with an error that may pass, like the operating system running out of buffers, is retried before the transfer is aborted (0 to abort right away)")
+	serverCmd.Flags().DurationVar(&serverPause, "send-retry-backoff", 10*time.Millisecond, "Longest random wait before the first retry of a failed send, doubling for each retry after it up to 1s")
+	serverCmd.Flags().IntVar(&serverBatch, "batch", 0, "Send up to this many lines of the file stream in one message to clients that support it, for throughput on files of many short lines (0 to send every line on its own)")
+	serverCmd.Flags().StringVar(&serverBatSz, "batch-bytes", "16KiB", "Most bytes of a --batch message, up to 65000")
+	serverCmd.Flags().BoolVar(&serverQuick, "low-latency", false, "Tune the file stream for many tiny records instead of bulk transfer: timestamp every line and send it only once at most 4KiB are queued")
+	serverCmd.Flags().BoolVar(&serverLinks, "follow-symlinks", false, "Serve files through symlinks that lead out of --share-dir")
+	serverCmd.Flags().BoolVar(&serverPerID, "upload-per-identity", false, "Keep each client's uploads in a directory of --upload-dir named after its identity, refusing uploads from clients without one")
+	serverCmd.Flags().StringVar(&serverAdmin, "control-token", "", "Token the control API and ctl command must present (supports env:, file:, exec: and keyring: references; leave empty to only accept local requests)")
+	serverCmd.Flags().StringVar(&serverDupes, "duplicate-policy", "allow", "What to do when a client identity connects while it still has a session (allow, reject or takeover)")
+	serverCmd.Flags().StringVar(&serverBy, "complete-by", "", "Deadline for each transfer, as a duration from its start (10m) or an RFC 3339 time")
+	serverCmd.Flags().StringArrayVar(&serverPeers, "peer", nil, "Daemon mode peer name that may register for pushes triggered through POST /push, repeatable (peers named in schedules always may)")
+	serverCmd.Flags().BoolVar(&serverMDNS, "announce", false, "Announce the server on the local network over mDNS, so the discover command lists it")
+	serverCmd.Flags().StringVar(&serverMDNSA, "announce-name", "", "Name the server is announced as (default the host name)")
+	serverCmd.Flags().BoolVar(&serverTUI, "tui", false, "Show a live terminal UI of the active sessions with their throughput and queue depth, with keys to end one or quit")
+	serverCmd.Flags().BoolVar(&serverLogs, "remote-logs", false, "Forward the log lines about each session to clients started with --remote-logs")
+	serverCmd.Flags().StringVar(&serverRecSz, "max-record-size", "64KiB", "Largest line of the file stream sent as it is, with a KiB suffix; clients may ask for less (at most 64KiB, a data channel message)")
+	serverCmd.Flags().StringVar(&serverLong, "oversized", records.Abort, "What to do with longer lines, unless the client asks otherwise: truncate them with a marker, split them into several lines or abort the stream")
+	serverCmd.Flags().StringVar(&serverTrace, "trace", "", "Record the signaling messages, state transitions and control frames of every session into this trace file (leave empty to disable)")
+	serverCmd.Flags().IntVar(&serverConns, "max-connections", 0, "Most peer connections the server runs at once, answering further offers with 503 Service Unavailable (0 for no limit)")
+	serverCmd.Flags().IntVar(&serverIPCap, "max-sessions-per-ip", 0, "Most sessions one client IP address runs at once, answering further offers with 429 Too Many Requests (0 for no limit)")
+	serverCmd.Flags().IntVar(&serverIDCap, "max-sessions-per-identity", 0, "Most sessions one client identity runs at once, answering further offers with 429 Too Many Requests (0 for no limit)")
+	serverCmd.Flags().DurationVar(&serverCool, "session-cooldown", 0, "How long a client that went over --max-sessions-per-ip or --max-sessions-per-identity is turned away, even once its sessions ended")
+	serverCmd.Flags().DurationVar(&serverStall, "stall-timeout", 0, "How long a connected session's client may acknowledge no data before the session is declared stalled (0 to never)")
+	serverCmd.Flags().StringVar(&serverStuck, "stall-policy", "close", "What happens to a stalled session: close its connection to free the slot, or only report it in /sessions")
+	serverCmd.Flags().BoolVar(&serverTail, "follow", false, "Keep streaming the lines appended to the file once its end is reached, like tail -f, until the client disconnects")
+	serverCmd.Flags().BoolVar(&serverWebUI, "web-ui", false, "Serve a page at / that receives the file stream in a browser")
+	serverCmd.Flags().StringArrayVar(&serverCORS, "cors-origin", nil, "Origin whose pages may post offers, like https://example.com or * for any, repeatable")
+	serverCmd.Flags().BoolVar(&serverCheck, "check", false, "Check the configuration, the files to stream, the port and the ICE servers, print a report and exit without serving")
+	serverCmd.Flags().StringVar(&serverGrpOf, "group-offsets", "", "File the committed offsets of consumer groups are saved to (default <user config dir>/webrtc-poc/groups.json)")
+	serverCmd.Flags().DurationVar(&serverKeep, "retention", 0, "How long the arrival times of the lines appended to a followed --file are kept, for clients replaying them with --since (0 to keep none)")
+	serverCmd.Flags().StringVar(&serverKeepD, "retention-dir", "", "Directory the retention logs of followed files are saved to (default <user config dir>/webrtc-poc/retention)")
+	serverCmd.Flags().BoolVar(&serverCast, "broadcast", false, "Read the file once and send every connected client the same lines in lockstep, instead of one stream per client")
+	serverCmd.Flags().DurationVar(&serverIdle, "idle-timeout", 30*time.Second, "How long a peer connection may stay new or connecting before it is closed (0 to wait forever)")
+	serverCmd.Flags().StringVar(&serverDebug, "debug-socket", "", "Unix socket the runtime profiles are served on for the profile command, only to the user running the server (leave empty to disable)")
+	serverCmd.Flags().StringVar(&serverMem, "memory-limit", "", "Memory the server may use before it pauses request transfers, then the file stream, and turns new offers away, with a KiB, MiB or GiB suffix (empty for no limit)")
+	serverCmd.Flags().IntVar(&serverPool, "workers", 64, "Transfers, requests and uploads streamed at once, further ones wait for a free worker (0 for no limit)")
+	serverCmd.Flags().StringArrayVar(&serverBurst, "pacing-window", nil, "Daily window and the rate all transfers are limited to during it, as HH:MM-HH:MM=RATE or *=RATE, repeatable, first match wins (RATE is full or bytes/s with a KiB, MiB or GiB suffix)")
 
 	// Client flags
 	clientCmd.Flags().StringVar(&clientServer, "server", "http://localhost:8080/offer", "WebRTC server URL")
 	clientCmd.Flags().StringVar(&clientOutput, "output", "", "Output file (leave empty for stdout)")
-	clientCmd.Flags().StringVar(&clientStun, "stun", "", "STUN server address (leave empty for direct connection)")
+	clientCmd.Flags().DurationVar(&clientBeat, "heartbeat-interval", 5*time.Second, "How often to ping the server to estimate the offset between their clocks (0 to disable)")
+	clientCmd.Flags().StringVar(&clientWrRate, "write-rate", "", "Most bytes per second written to the output, with an optional KiB, MiB or GiB suffix, for slow storage (leave empty for no limit)")
+	clientCmd.Flags().StringVar(&clientWrBuf, "write-buffer", "4MiB", "How much received output --write-rate buffers before receiving slows down to the write rate")
+	clientCmd.Flags().DurationVar(&clientConnTO, "connect-timeout", 2*time.Minute, "How long the connection to the server may take to be established, signaling included, before the client gives up (0 to wait forever)")
+	clientCmd.Flags().BoolVar(&clientTrickle, "trickle-ice", true, "Send the offer right away and exchange ICE candidates as they are gathered, if the server supports it")
+	clientCmd.Flags().StringVar(&clientExport, "export", "", "Export of the server to stream instead of its --file")
+	clientCmd.Flags().StringVar(&clientGroup, "group", "", "Consumer group to join, sharing the server's --file with its other clients and carrying on after the lines the group wrote")
+	clientCmd.Flags().DurationVar(&clientSince, "since", 0, "Replay the server's followed --file from the lines that arrived in this last period, such as 2h, before going live (needs the server's --retention)")
+	clientCmd.Flags().IntVar(&clientSinceN, "since-seq", 0, "Replay the server's followed --file from the line after this many, its sequence number, before going live")
+	clientCmd.Flags().BoolVar(&clientBatch, "batch", false, "Accept several lines of the file stream per message from servers streaming with --batch")
+	clientCmd.Flags().BoolVar(&clientLogs, "remote-logs", false, "Show the server's log lines about this session, if it was started with --remote-logs")
+	clientCmd.Flags().StringVar(&clientRecSz, "max-record-size", "", "Largest line of the file stream to receive as it is, with a KiB suffix (leave empty for the server's)")
+	clientCmd.Flags().StringVar(&clientLong, "oversized", "", "What the server does with longer lines: truncate them with a marker, split them into several lines or abort the stream (leave empty for the server's)")
+	clientCmd.Flags().BoolVar(&clientTUI, "tui", false, "Show a live terminal UI of the connection, route, throughput and last lines received, with keys to pause or quit")
+	clientCmd.Flags().StringVar(&clientTrace, "trace", "", "Record the signaling messages, state transitions and control frames of the connection into this trace file (leave empty to disable)")
+	clientCmd.Flags().IntVar(&clientWindow, "receive-window", 0, "Most lines of the file stream the server may send ahead of the output, so a slow output holds the server back (0 for no limit)")
+	clientCmd.Flags().StringVar(&clientRoll, "roll", "", "Roll the --output file hourly or daily, naming each file with the strftime directives in --output (e.g. app-%Y%m%d%H.log)")
+	clientCmd.Flags().StringVar(&clientTmpl, "output-template", "", "Template of the file each stream is appended to, e.g. '{{.Host}}/{{.Topic}}/{{.Date}}.log'")
+	clientCmd.Flags().StringArrayVar(&clientICE, "ice-server", nil, "ICE server URL, repeatable (stun:host:port or turn:user:pass@host:port?transport=tcp; leave empty for direct connection)")
+	clientCmd.Flags().StringVar(&clientMetrics, "metrics-addr", "", "Address to expose metrics on (leave empty to disable)")
+	clientCmd.Flags().BoolVar(&clientAuto, "auto-stun", false, "Fall back to public STUN servers when no ICE servers are configured and direct connections fail")
+	clientCmd.Flags().BoolVar(&clientLocal, "no-internet", false, "Never contact STUN/TURN servers, overriding --auto-stun")
+	clientCmd.Flags().StringVar(&clientToken, "auth-token", "", "Token presented to the server (supports env:, file:, exec: and keyring: references)")
+	clientCmd.Flags().BoolVar(&clientDaemon, "daemon", false, "Stay connected and receive every push the server schedules for --name")
+	clientCmd.Flags().StringVar(&clientName, "name", "", "Peer name to register as in daemon mode")
+	clientCmd.Flags().StringVar(&clientFilter, "filter", "", "Only write lines matching this regular expression in daemon mode")
+	clientCmd.Flags().StringVar(&clientKey, "identity-file", "", "Ed25519 identity key, created if missing, or keyring:NAME (default <user config dir>/webrtc-poc/identity.key)")
+	clientCmd.Flags().StringVar(&clientPeer, "server-identity", "", "Identity the server must prove (leave empty to skip verification)")
+	clientCmd.Flags().BoolVar(&clientNoise, "noise", false, "Encrypt the offer and answer with a Noise handshake authenticated by the peer identities")
+	clientCmd.Flags().StringVar(&clientCode, "code", "", "Rendezvous code to connect through instead of --server")
+	clientCmd.Flags().StringVar(&clientRelay, "rendezvous", "", "Rendezvous server URL (default $"+rendezvous.EnvURL+")")
+	clientCmd.Flags().StringArrayVar(&clientMedia, "interceptor", interceptors.Default, "Pion interceptor for media tracks, repeatable (nack, rtcp_reports, twcc or none)")
+	clientCmd.Flags().StringVar(&clientLabel, "channel-label", "fileStream", "Label of the file stream data channel")
+	clientCmd.Flags().StringVar(&clientProto, "channel-protocol", "", "Subprotocol of the file stream data channel")
+	clientCmd.Flags().Uint16Var(&clientChan, "channel-id", 0, "ID of the file stream data channel with --negotiated-channel, must match the server's")
+	clientCmd.Flags().BoolVar(&clientNegot, "negotiated-channel", false, "Pre-negotiate the file stream data channel with --channel-id instead of accepting the one the server announces; the server needs --negotiated-channel too")
+	clientCmd.Flags().StringVar(&clientRole, "offer-role", signaling.OfferRoleClient, "Side that creates the offer: client, or server to fetch the offer from the server and answer it")
+	clientCmd.Flags().StringVar(&clientSignal, "signal", signaling.SignalHTTP, "How the offer reaches the server: http to --server, manual to print it for pasting into the server's terminal and read the answer pasted back, or qr to also draw it as a QR code")
+	clientCmd.Flags().StringArrayVar(&clientFetch, "request-file", nil, "File to request from the server's --share-dir over the same connection, repeatable")
+	clientCmd.Flags().StringVar(&clientDir, "output-dir", ".", "Directory requested files are written to")
+	clientCmd.Flags().StringVar(&clientList, "fetch-list", "", "File listing files to request, one per line with an optional output path")
+	clientCmd.Flags().IntVar(&clientJobs, "fetch-parallel", 1, "Number of requested files fetched at the same time")
+	clientCmd.Flags().StringVar(&clientExec, "exec", "", "Command whose stdin receives the streamed lines instead of --output, e.g. 'tar -x'")
+	clientCmd.Flags().StringVar(&clientRestart, "exec-restart", "never", "When to restart an --exec command that exits early: never, on-failure or always")
+	clientCmd.Flags().IntVar(&clientRetries, "exec-max-restarts", 3, "Maximum number of times the --exec command is restarted")
+	clientCmd.Flags().StringVar(&clientFwd, "forward", "", "Local socket to forward over the connection: tcp:HOST:PORT, udp:HOST:PORT, tcp-listen:PORT or udp-listen:PORT")
+	clientCmd.Flags().BoolVar(&clientFIPS, "fips", false, "Restrict the application-layer crypto to FIPS 140-3 approved algorithms (always on in fips builds)")
+	clientCmd.Flags().BoolVar(&clientLinks, "follow-symlinks", false, "Write requested files through symlinks that lead out of --output-dir")
+	clientCmd.Flags().StringArrayVar(&clientUploads, "upload-file", nil, "File to upload to the server's --upload-dir over the same connection, repeatable")
+	clientCmd.Flags().StringVar(&clientCache, "dedup-cache", "", "Directory caching the chunks of requested files, so only new chunks are transferred (leave empty to disable)")
+	clientCmd.Flags().StringSliceVar(&clientSums, "checksum", nil, "Checksum algorithms offered for the chunks of deduplicated requests, in order of preference (sha256, blake3, xxh3; default fastest first, only sha256 in FIPS mode)")
+	clientCmd.Flags().StringVar(&clientState, "state-file", "", "File the daemon mode subscription is saved to (default <user config dir>/webrtc-poc/subscription.json)")
+	clientCmd.Flags().BoolVar(&clientSeal, "encrypt-state", false, "Encrypt the daemon mode subscription and the chunks of --dedup-cache with a locally stored key")
+	clientCmd.Flags().StringVar(&clientSealKey, "state-key", "", "Key --encrypt-state encrypts with, created if missing, or keyring:NAME (default <user config dir>/webrtc-poc/state.key)")
+
+	// Identity flags
+	identityCmd.Flags().StringVar(&identityFile, "identity-file", "", "Ed25519 identity key, created if missing, or keyring:NAME (default <user config dir>/webrtc-poc/identity.key)")
+	identityCmd.Flags().StringVar(&identitySign, "sign", "", "Release checksums file to sign, writing the signature next to it with a .sig suffix")
+	identityCmd.Flags().StringVar(&identityVers, "sign-version", "", "Version of the release whose checksums --sign signs")
+
+	// Signal flags
+	signalCmd.Flags().StringVar(&signalAddr, "addr", ":9000", "HTTP service address")
+	signalCmd.Flags().DurationVar(&signalTTL, "ttl", 2*time.Minute, "How long idle codes and unanswered offers are kept")
+
+	// Diagnose flags
+	diagnoseCmd.Flags().IntSliceVar(&diagnosePorts, "port", nil, "Local UDP port to probe, repeatable")
+	diagnoseCmd.Flags().DurationVar(&diagnoseTimeout, "timeout", 3*time.Second, "How long to wait for each probe")
+
+	// Discover flags
+	discoverCmd.Flags().DurationVar(&discoverTimeout, "timeout", 2*time.Second, "How long to wait for servers to answer")
+
+	// Tunnel flags
+	tunnelCmd.Flags().StringVar(&tunnelServer, "server", "", "WebRTC server URL (default is the client's server)")
+	tunnelCmd.Flags().StringArrayVarP(&tunnelLocal, "local", "L", nil, "Forward [BIND:]PORT to HOST:HOSTPORT as seen from the server, repeatable")
+
+	// Send and receive flags
+	sendCmd.Flags().StringVar(&sendRelay, "rendezvous", "", "Rendezvous server URL (default is the server's rendezvous, then $"+rendezvous.EnvURL+")")
+	sendCmd.Flags().IntVar(&sendDelay, "delay", 0, "Delay between lines in milliseconds (legacy, see --rate)")
+	sendCmd.Flags().StringVar(&sendRate, "rate", "", "Rate to send at in bytes or lines per second, e.g. 1MB/s or 100lines/s, overriding --delay")
+	receiveCmd.Flags().StringVar(&receiveRelay, "rendezvous", "", "Rendezvous server URL (default is the client's rendezvous, then $"+rendezvous.EnvURL+")")
+	receiveCmd.Flags().StringVarP(&receiveOut, "output", "o", "", "File the lines are written to (leave empty for stdout)")
+
+	// Pipe flags
+	pipeListenCmd.Flags().StringVar(&pipeAddr, "addr", ":8080", "HTTP service address offers are posted to")
+	pipeConnectCmd.Flags().StringVar(&pipeServer, "server", "", "URL of the listening end's offer endpoint (default is the client's server)")
+
+	// Mirror flags
+	mirrorCmd.Flags().StringVar(&mirrorServer, "server", "", "WebRTC server URL (default is the client's server)")
+	mirrorCmd.Flags().StringVar(&mirrorDest, "dest", "", "Directory the files are mirrored to")
+	mirrorCmd.Flags().StringArrayVar(&mirrorInclude, "include", []string{"*"}, "Pattern of the shared files to mirror, repeatable")
+	mirrorCmd.Flags().DurationVar(&mirrorInterval, "interval", time.Minute, "How often to check for changes (0 mirrors once and exits)")
+
+	// Profile flags
+	profileCmd.Flags().StringVar(&profSocket, "socket", "", "Debug socket of the server (default is the configured debug_socket)")
+	profileCmd.Flags().DurationVar(&profDuration, "duration", 30*time.Second, "How long to profile the server's CPU for")
+	profileCmd.Flags().StringVarP(&profOutput, "output", "o", ".", "Directory the pprof files are written to")
+	profileCmd.Flags().IntVar(&profTop, "top", 10, "Number of functions listed in each summary")
+
+	// Self-update flags
+	selfUpdateCmd.Flags().StringVar(&updateURL, "url", "", "Base URL of the release (default is the configured update_url)")
+	selfUpdateCmd.Flags().StringVar(&updateKey, "key", "", "Identity that must sign the release checksums (default is the configured update_key)")
+	selfUpdateCmd.Flags().BoolVar(&updateCheck, "check", false, "Only report whether a newer release is available, downloading its signed checksums but not its archive")
+	selfUpdateCmd.Flags().BoolVar(&updateForce, "force", false, "Install the release even if it is not newer than this binary")
+
+	// Trace flags
+	traceViewCmd.Flags().IntVar(&traceWidth, "width", 60, "Width of each trace's column")
+	traceViewCmd.Flags().StringArrayVar(&traceSession, "session", nil, "Only print the events of this session, repeatable (default is the sessions both traces recorded)")
+
+	// Ctl flags
+	ctlCmd.PersistentFlags().StringVar(&ctlServer, "server", "", "Base URL of the server (default is http://localhost with the configured server address)")
+	ctlCmd.PersistentFlags().StringVar(&ctlToken, "token", "", "Control token of the server (default is the configured control_token; supports env:, file:, exec: and keyring: references)")
+	maintenanceCmd.Flags().DurationVar(&ctlRetry, "retry-after", maintenance.DefaultRetryAfter, "How long clients are asked to wait before retrying")
+	maintenanceCmd.Flags().StringVar(&ctlReason, "reason", "", "Reason reported to turned away clients")
+
+	// Keep the old single --stun flag working for existing scripts
+	flags.MustDeprecate(serverCmd.Flags(), "stun", "ice-server")
+	flags.MustDeprecate(clientCmd.Flags(), "stun", "ice-server")
 
 	// Bind flags to viper
 	viper.BindPFlag("server.addr", serverCmd.Flags().Lookup("addr"))
 	viper.BindPFlag("server.file", serverCmd.Flags().Lookup("file"))
 	viper.BindPFlag("server.delay", serverCmd.Flags().Lookup("delay"))
-	viper.BindPFlag("server.stun", serverCmd.Flags().Lookup("stun"))
+	viper.BindPFlag("server.rate", serverCmd.Flags().Lookup("rate"))
+	viper.BindPFlag("server.ice_servers", serverCmd.Flags().Lookup("ice-server"))
+	viper.BindPFlag("server.auto_stun", serverCmd.Flags().Lookup("auto-stun"))
+	viper.BindPFlag("server.no_internet", serverCmd.Flags().Lookup("no-internet"))
+	viper.BindPFlag("server.auth_token", serverCmd.Flags().Lookup("auth-token"))
+	viper.BindPFlag("server.adaptive_pacing", serverCmd.Flags().Lookup("adaptive-pacing"))
+	viper.BindPFlag("server.complete_by", serverCmd.Flags().Lookup("complete-by"))
+	viper.BindPFlag("server.pacing_windows", serverCmd.Flags().Lookup("pacing-window"))
+	viper.BindPFlag("server.peers", serverCmd.Flags().Lookup("peer"))
+	viper.BindPFlag("server.workers", serverCmd.Flags().Lookup("workers"))
+	viper.BindPFlag("server.memory_limit", serverCmd.Flags().Lookup("memory-limit"))
+	viper.BindPFlag("server.debug_socket", serverCmd.Flags().Lookup("debug-socket"))
+	viper.BindPFlag("server.identity_file", serverCmd.Flags().Lookup("identity-file"))
+	viper.BindPFlag("server.allowed_identities", serverCmd.Flags().Lookup("allow-identity"))
+	viper.BindPFlag("server.require_noise", serverCmd.Flags().Lookup("require-noise"))
+	viper.BindPFlag("server.rendezvous", serverCmd.Flags().Lookup("rendezvous"))
+	viper.BindPFlag("server.interceptors", serverCmd.Flags().Lookup("interceptor"))
+	viper.BindPFlag("server.channel_label", serverCmd.Flags().Lookup("channel-label"))
+	viper.BindPFlag("server.channel_protocol", serverCmd.Flags().Lookup("channel-protocol"))
+	viper.BindPFlag("server.channel_id", serverCmd.Flags().Lookup("channel-id"))
+	viper.BindPFlag("server.negotiated_channel", serverCmd.Flags().Lookup("negotiated-channel"))
+	viper.BindPFlag("server.offer_role", serverCmd.Flags().Lookup("offer-role"))
+	viper.BindPFlag("server.signal", serverCmd.Flags().Lookup("signal"))
+	viper.BindPFlag("server.share_dir", serverCmd.Flags().Lookup("share-dir"))
+	viper.BindPFlag("server.chunk_index", serverCmd.Flags().Lookup("chunk-index"))
+	viper.BindPFlag("server.checksums", serverCmd.Flags().Lookup("checksum"))
+	viper.BindPFlag("server.announce", serverCmd.Flags().Lookup("announce"))
+	viper.BindPFlag("server.announce_name", serverCmd.Flags().Lookup("announce-name"))
+	viper.BindPFlag("server.remote_logs", serverCmd.Flags().Lookup("remote-logs"))
+	viper.BindPFlag("server.trace", serverCmd.Flags().Lookup("trace"))
+	viper.BindPFlag("server.max_record_size", serverCmd.Flags().Lookup("max-record-size"))
+	viper.BindPFlag("server.oversized", serverCmd.Flags().Lookup("oversized"))
+	viper.BindPFlag("server.idle_timeout", serverCmd.Flags().Lookup("idle-timeout"))
+	viper.BindPFlag("server.max_connections", serverCmd.Flags().Lookup("max-connections"))
+	viper.BindPFlag("server.max_sessions_per_ip", serverCmd.Flags().Lookup("max-sessions-per-ip"))
+	viper.BindPFlag("server.max_sessions_per_identity", serverCmd.Flags().Lookup("max-sessions-per-identity"))
+	viper.BindPFlag("server.session_cooldown", serverCmd.Flags().Lookup("session-cooldown"))
+	viper.BindPFlag("server.stall_timeout", serverCmd.Flags().Lookup("stall-timeout"))
+	viper.BindPFlag("server.stall_policy", serverCmd.Flags().Lookup("stall-policy"))
+	viper.BindPFlag("server.tui", serverCmd.Flags().Lookup("tui"))
+	viper.BindPFlag("server.broadcast", serverCmd.Flags().Lookup("broadcast"))
+	viper.BindPFlag("server.follow", serverCmd.Flags().Lookup("follow"))
+	viper.BindPFlag("server.group_offsets", serverCmd.Flags().Lookup("group-offsets"))
+	viper.BindPFlag("server.retention", serverCmd.Flags().Lookup("retention"))
+	viper.BindPFlag("server.retention_dir", serverCmd.Flags().Lookup("retention-dir"))
+	viper.BindPFlag("server.web_ui", serverCmd.Flags().Lookup("web-ui"))
+	viper.BindPFlag("server.cors_origins", serverCmd.Flags().Lookup("cors-origin"))
+	viper.BindPFlag("server.mmap", serverCmd.Flags().Lookup("mmap"))
+	viper.BindPFlag("server.read_ahead", serverCmd.Flags().Lookup("read-ahead"))
+	viper.BindPFlag("server.length", serverCmd.Flags().Lookup("length"))
+	viper.BindPFlag("server.raw", serverCmd.Flags().Lookup("raw"))
+	viper.BindPFlag("server.forward", serverCmd.Flags().Lookup("forward"))
+	viper.BindPFlag("server.allow_tunnels", serverCmd.Flags().Lookup("allow-tunnel"))
+	viper.BindPFlag("server.unreliable", serverCmd.Flags().Lookup("unreliable"))
+	viper.BindPFlag("server.fec", serverCmd.Flags().Lookup("fec"))
+	viper.BindPFlag("server.duplicate_policy", serverCmd.Flags().Lookup("duplicate-policy"))
+	viper.BindPFlag("server.control_token", serverCmd.Flags().Lookup("control-token"))
+	viper.BindPFlag("server.upload_dir", serverCmd.Flags().Lookup("upload-dir"))
+	viper.BindPFlag("server.quarantine_dir", serverCmd.Flags().Lookup("quarantine-dir"))
+	viper.BindPFlag("server.upload_max_size", serverCmd.Flags().Lookup("upload-max-size"))
+	viper.BindPFlag("server.upload_types", serverCmd.Flags().Lookup("upload-type"))
+	viper.BindPFlag("server.upload_scanner", serverCmd.Flags().Lookup("upload-scanner"))
+	viper.BindPFlag("server.upload_collision", serverCmd.Flags().Lookup("upload-collision"))
+	viper.BindPFlag("server.upload_per_identity", serverCmd.Flags().Lookup("upload-per-identity"))
+	viper.BindPFlag("server.fips", serverCmd.Flags().Lookup("fips"))
+	viper.BindPFlag("server.follow_symlinks", serverCmd.Flags().Lookup("follow-symlinks"))
+	viper.BindPFlag("server.timestamps", serverCmd.Flags().Lookup("timestamps"))
+	viper.BindPFlag("server.low_latency", serverCmd.Flags().Lookup("low-latency"))
+	viper.BindPFlag("server.batch", serverCmd.Flags().Lookup("batch"))
+	viper.BindPFlag("server.batch_bytes", serverCmd.Flags().Lookup("batch-bytes"))
+	viper.BindPFlag("server.send_retries", serverCmd.Flags().Lookup("send-retries"))
+	viper.BindPFlag("server.send_retry_backoff", serverCmd.Flags().Lookup("send-retry-backoff"))
 	viper.BindPFlag("client.server", clientCmd.Flags().Lookup("server"))
 	viper.BindPFlag("client.output", clientCmd.Flags().Lookup("output"))
-	viper.BindPFlag("client.stun", clientCmd.Flags().Lookup("stun"))
+	viper.BindPFlag("client.output_template", clientCmd.Flags().Lookup("output-template"))
+	viper.BindPFlag("client.roll", clientCmd.Flags().Lookup("roll"))
+	viper.BindPFlag("client.write_rate", clientCmd.Flags().Lookup("write-rate"))
+	viper.BindPFlag("client.write_buffer", clientCmd.Flags().Lookup("write-buffer"))
+	viper.BindPFlag("client.receive_window", clientCmd.Flags().Lookup("receive-window"))
+	viper.BindPFlag("client.export", clientCmd.Flags().Lookup("export"))
+	viper.BindPFlag("client.group", clientCmd.Flags().Lookup("group"))
+	viper.BindPFlag("client.since", clientCmd.Flags().Lookup("since"))
+	viper.BindPFlag("client.since_seq", clientCmd.Flags().Lookup("since-seq"))
+	viper.BindPFlag("client.batch", clientCmd.Flags().Lookup("batch"))
+	viper.BindPFlag("client.remote_logs", clientCmd.Flags().Lookup("remote-logs"))
+	viper.BindPFlag("client.trace", clientCmd.Flags().Lookup("trace"))
+	viper.BindPFlag("client.tui", clientCmd.Flags().Lookup("tui"))
+	viper.BindPFlag("client.max_record_size", clientCmd.Flags().Lookup("max-record-size"))
+	viper.BindPFlag("client.oversized", clientCmd.Flags().Lookup("oversized"))
+	viper.BindPFlag("client.trickle_ice", clientCmd.Flags().Lookup("trickle-ice"))
+	viper.BindPFlag("client.connect_timeout", clientCmd.Flags().Lookup("connect-timeout"))
+	viper.BindPFlag("client.heartbeat_interval", clientCmd.Flags().Lookup("heartbeat-interval"))
+	viper.BindPFlag("client.ice_servers", clientCmd.Flags().Lookup("ice-server"))
+	viper.BindPFlag("client.metrics_addr", clientCmd.Flags().Lookup("metrics-addr"))
+	viper.BindPFlag("client.auto_stun", clientCmd.Flags().Lookup("auto-stun"))
+	viper.BindPFlag("client.no_internet", clientCmd.Flags().Lookup("no-internet"))
+	viper.BindPFlag("client.auth_token", clientCmd.Flags().Lookup("auth-token"))
+	viper.BindPFlag("client.daemon", clientCmd.Flags().Lookup("daemon"))
+	viper.BindPFlag("client.name", clientCmd.Flags().Lookup("name"))
+	viper.BindPFlag("client.filter", clientCmd.Flags().Lookup("filter"))
+	viper.BindPFlag("client.state_file", clientCmd.Flags().Lookup("state-file"))
+	viper.BindPFlag("client.encrypt_state", clientCmd.Flags().Lookup("encrypt-state"))
+	viper.BindPFlag("client.state_key", clientCmd.Flags().Lookup("state-key"))
+	viper.BindPFlag("client.identity_file", clientCmd.Flags().Lookup("identity-file"))
+	viper.BindPFlag("client.server_identity", clientCmd.Flags().Lookup("server-identity"))
+	viper.BindPFlag("client.noise", clientCmd.Flags().Lookup("noise"))
+	viper.BindPFlag("client.rendezvous", clientCmd.Flags().Lookup("rendezvous"))
+	viper.BindPFlag("client.code", clientCmd.Flags().Lookup("code"))
+	viper.BindPFlag("client.interceptors", clientCmd.Flags().Lookup("interceptor"))
+	viper.BindPFlag("client.channel_label", clientCmd.Flags().Lookup("channel-label"))
+	viper.BindPFlag("client.channel_protocol", clientCmd.Flags().Lookup("channel-protocol"))
+	viper.BindPFlag("client.channel_id", clientCmd.Flags().Lookup("channel-id"))
+	viper.BindPFlag("client.negotiated_channel", clientCmd.Flags().Lookup("negotiated-channel"))
+	viper.BindPFlag("client.offer_role", clientCmd.Flags().Lookup("offer-role"))
+	viper.BindPFlag("client.signal", clientCmd.Flags().Lookup("signal"))
+	viper.BindPFlag("client.request_files", clientCmd.Flags().Lookup("request-file"))
+	viper.BindPFlag("client.upload_files", clientCmd.Flags().Lookup("upload-file"))
+	viper.BindPFlag("client.fips", clientCmd.Flags().Lookup("fips"))
+	viper.BindPFlag("client.follow_symlinks", clientCmd.Flags().Lookup("follow-symlinks"))
+	viper.BindPFlag("client.output_dir", clientCmd.Flags().Lookup("output-dir"))
+	viper.BindPFlag("client.fetch_list", clientCmd.Flags().Lookup("fetch-list"))
+	viper.BindPFlag("client.fetch_parallel", clientCmd.Flags().Lookup("fetch-parallel"))
+	viper.BindPFlag("client.dedup_cache", clientCmd.Flags().Lookup("dedup-cache"))
+	viper.BindPFlag("client.checksums", clientCmd.Flags().Lookup("checksum"))
+	viper.BindPFlag("client.exec", clientCmd.Flags().Lookup("exec"))
+	viper.BindPFlag("client.exec_restart", clientCmd.Flags().Lookup("exec-restart"))
+	viper.BindPFlag("client.exec_max_restarts", clientCmd.Flags().Lookup("exec-max-restarts"))
+	viper.BindPFlag("client.forward", clientCmd.Flags().Lookup("forward"))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -120,464 +974,2170 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
-	}
-}
-
-func runServer() {
-	// Get configuration from viper
-	addr := viper.GetString("server.addr")
-	filename := viper.GetString("server.file")
-	delay := viper.GetInt("server.delay")
-	stunServerURL := viper.GetString("server.stun")
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 
-	logger.Info("Starting WebRTC file streaming server on %s", addr)
-	logger.Info("Will stream file: %s with delay: %dms", filename, delay)
+		// Reject unknown keys and type errors instead of silently using defaults
+		if err := config.ValidateFile(viper.ConfigFileUsed()); err != nil {
+			configFailed(err)
+		}
+	}
 
-	// Ensure the file exists
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		logger.Error("File does not exist: %s", filename)
-		os.Exit(1)
+	// Overlay the selected profile on top of the base settings
+	if err := config.ApplyProfile(viper.GetViper(), profile); err != nil {
+		configFailed(err)
 	}
 
-	// Create a new SettingEngine
-	settingEngine := webrtc.SettingEngine{}
+	// The keyring commands store the secrets references resolve to, which
+	// may not exist yet
+	if cmd, _, err := rootCmd.Find(os.Args[1:]); err == nil && cmd.Parent() == keyringCmd {
+		return
+	}
 
-	// Configure ICE based on whether STUN server is provided
-	if stunServerURL == "" {
-		// No STUN server - use only local candidates
-		logger.Info("No STUN server provided, using direct connection only")
+	// Resolve env:, file:, exec: and keyring: references
+	if err := config.ResolveSecrets(viper.GetViper()); err != nil {
+		configFailed(err)
+	}
+}
 
-		// Disable mDNS
-		settingEngine.SetICEMulticastDNSMode(0) // 0 = Disabled
+// configErrs are the configuration errors server --check reports
+var configErrs []error
 
-		// Allow all interfaces for direct connection
-		settingEngine.SetInterfaceFilter(func(interfaceName string) bool {
-			return true // Allow all interfaces
-		})
-	} else {
-		logger.Info("Using STUN server: %s", stunServerURL)
+// configFailed exits with an invalid configuration's error, or keeps it for
+// the report of server --check
+func configFailed(err error) {
+	if serverCheck {
+		configErrs = append(configErrs, err)
+		return
 	}
+	logger.Error("%v", err)
+	os.Exit(1)
+}
 
-	// Create a new RTCPeerConnection configuration
-	config := webrtc.Configuration{}
-
-	// Add ICE servers if STUN server is provided
-	if stunServerURL != "" {
-		config.ICEServers = []webrtc.ICEServer{
-			{
-				URLs: []string{stunServerURL},
-			},
+func runServer() {
+	// Validate every setting up front, the same way server --check does, so
+	// the values parsed below are known to be valid. Approved mode limits the
+	// checksum algorithms, so it is settled by the check.
+	if errs := checkServerSettings(); len(errs) > 0 {
+		for _, err := range errs {
+			logger.Error("%v", err)
 		}
+		os.Exit(1)
 	}
 
-	// Create a new API with the custom settings
-	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
-
-	// Create a wait group to wait for all connections to complete
-	var wg sync.WaitGroup
+	// Get configuration from viper
+	cfg := server.Config{
+		Addr:              viper.GetString("server.addr"),
+		File:              viper.GetString("server.file"),
+		DelayMs:           viper.GetInt("server.delay"),
+		AuthToken:         viper.GetString("server.auth_token"),
+		ControlToken:      viper.GetString("server.control_token"),
+		AllowedIdentities: viper.GetStringSlice("server.allowed_identities"),
+		RequireNoise:      viper.GetBool("server.require_noise"),
+		OfferRole:         viper.GetString("server.offer_role"),
+		Signal:            viper.GetString("server.signal"),
+		Rendezvous:        viper.GetString("server.rendezvous"),
+		WebUI:             viper.GetBool("server.web_ui"),
+		CORSOrigins:       viper.GetStringSlice("server.cors_origins"),
+		Channel: peer.Channel{
+			Label:      viper.GetString("server.channel_label"),
+			Protocol:   viper.GetString("server.channel_protocol"),
+			Negotiated: viper.GetBool("server.negotiated_channel"),
+			ID:         uint16(viper.GetUint("server.channel_id")),
+			Unreliable: viper.GetBool("server.unreliable"),
+		},
+		Records:          records.Terms{Policy: viper.GetString("server.oversized")},
+		Source:           source.Options{MMap: viper.GetBool("server.mmap")},
+		Raw:              viper.GetBool("server.raw"),
+		Follow:           viper.GetBool("server.follow"),
+		Broadcast:        viper.GetBool("server.broadcast"),
+		GroupOffsets:     viper.GetString("server.group_offsets"),
+		Retention:        viper.GetDuration("server.retention"),
+		RetentionDir:     viper.GetString("server.retention_dir"),
+		AdaptivePacing:   viper.GetBool("server.adaptive_pacing"),
+		LowLatency:       viper.GetBool("server.low_latency"),
+		Timestamps:       viper.GetBool("server.timestamps"),
+		SendRetries:      viper.GetInt("server.send_retries"),
+		SendRetryBackoff: viper.GetDuration("server.send_retry_backoff"),
+		ShareDir:         viper.GetString("server.share_dir"),
+		FollowSymlinks:   viper.GetBool("server.follow_symlinks"),
+		ChunkIndex:       viper.GetString("server.chunk_index"),
+		AllowTunnels:     viper.GetStringSlice("server.allow_tunnels"),
+		Peers:            viper.GetStringSlice("server.peers"),
+		RemoteLogs:       viper.GetBool("server.remote_logs"),
+		IdleTimeout:      viper.GetDuration("server.idle_timeout"),
+		DuplicatePolicy:  sessions.Policy(viper.GetString("server.duplicate_policy")),
+		MaxConnections:   viper.GetInt("server.max_connections"),
+		Budget: sessions.Budget{
+			PerAddr:     viper.GetInt("server.max_sessions_per_ip"),
+			PerIdentity: viper.GetInt("server.max_sessions_per_identity"),
+			Cooldown:    viper.GetDuration("server.session_cooldown"),
+		},
+		StallTimeout: viper.GetDuration("server.stall_timeout"),
+		StallPolicy:  sessions.StallPolicy(viper.GetString("server.stall_policy")),
+		Workers:      viper.GetInt("server.workers"),
+		DebugSocket:  viper.GetString("server.debug_socket"),
+		Announce:     viper.GetBool("server.announce"),
+		AnnounceName: viper.GetString("server.announce_name"),
+		TUI:          viper.GetBool("server.tui"),
+	}
 
-	// Create a channel to signal shutdown
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	// The settings were checked, so they parse
+	if readAhead := viper.GetString("server.read_ahead"); readAhead != "" {
+		cfg.Source.ReadAhead, _ = source.ParseSize(readAhead)
+	}
+	if v := viper.GetString("server.max_record_size"); v != "" {
+		n, _ := source.ParseSize(v)
+		cfg.Records.MaxSize = int(n)
+	}
+	if limit := viper.GetString("server.length"); limit != "" {
+		cfg.Length, _ = source.ParseSize(limit)
+	}
+	if limit := viper.GetString("server.memory_limit"); limit != "" {
+		cfg.MemoryLimit, _ = source.ParseSize(limit)
+	}
+	if spec := viper.GetString("server.fec"); spec != "" {
+		cfg.FECData, cfg.FECParity, _ = fec.ParseRatio(spec)
+	}
+	cfg.Batch, _ = batchLimits()
+	cfg.CompleteBy, _ = deadline.Parse(viper.GetString("server.complete_by"))
+	if windows := viper.GetStringSlice("server.pacing_windows"); len(windows) > 0 {
+		cfg.Pacing, _ = pacing.Parse(windows)
+	}
+	spec := viper.GetString("server.rate")
+	if spec != "" {
+		cfg.Rate, _ = server.ParseRate(spec)
+	}
+	cfg.Checksums, _ = checksumsFor("server")
+	cfg.Interceptors = viper.GetStringSlice("server.interceptors")
+	if err := viper.UnmarshalKey("server.schedules", &cfg.Schedules); err != nil {
+		logger.Error("Invalid schedules: %v", err)
+		os.Exit(1)
+	}
 
-	// Handle HTTP requests
-	http.HandleFunc("/offer", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	// Record every session into the trace file, if requested
+	if path := viper.GetString("server.trace"); path != "" {
+		if err := trace.Open(path, "server"); err != nil {
+			logger.Error("Invalid --trace: %v", err)
+			os.Exit(1)
 		}
+		defer trace.Close()
+	}
 
-		// Read the raw offer from the request body
-		offerBytes, err := io.ReadAll(r.Body)
+	// Consumer groups commit their offsets next to the other state
+	if cfg.GroupOffsets == "" {
+		path, err := groups.DefaultPath()
 		if err != nil {
-			http.Error(w, "Failed to read offer: "+err.Error(), http.StatusBadRequest)
-			return
+			logger.Error("%v", err)
+			os.Exit(1)
 		}
+		cfg.GroupOffsets = path
+	}
 
-		// Log the raw offer for debugging
-		logger.Debug("Raw offer received: %s", string(offerBytes))
+	// Validate uploads in quarantine before accepting them, and connect the
+	// forward channels clients open to a local socket
+	var err error
+	if cfg.Uploads, err = uploadPipeline(); err != nil {
+		logger.Error("Invalid upload configuration: %v", err)
+		os.Exit(1)
+	}
+	if cfg.Forwarder, err = forwarderFor("server"); err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
 
-		// Parse the offer from the request
-		var offer webrtc.SessionDescription
-		if err := json.Unmarshal(offerBytes, &offer); err != nil {
-			http.Error(w, "Failed to parse offer: "+err.Error(), http.StatusBadRequest)
-			return
-		}
+	// Clients name the files of exports, which are loaded again whenever the
+	// config file changes
+	if cfg.Exports, err = loadExports(); err != nil {
+		logger.Error("Invalid exports: %v", err)
+		os.Exit(1)
+	}
 
-		// Log the parsed offer for debugging
-		logger.Debug("Parsed offer type: %s", offer.Type.String())
+	// Load the server's identity and resolve the configured ICE servers
+	if cfg.Identity, err = loadIdentity("server"); err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	if cfg.ICEServers, cfg.Fallback, err = iceServersFor("server"); err != nil {
+		logger.Error("Invalid ICE configuration: %v", err)
+		os.Exit(1)
+	}
 
-		// Log the parsed offer for debugging
-		offerJSON, _ := json.Marshal(offer)
-		logger.Debug("Parsed offer: %s", string(offerJSON))
+	logger.Info("Starting WebRTC file streaming server on %s", cfg.Addr)
+	if spec != "" {
+		logger.Info("Will stream file: %s at %s", cfg.File, spec)
+	} else {
+		logger.Info("Will stream file: %s with delay: %dms", cfg.File, cfg.DelayMs)
+	}
 
-		// Create a new peer connection
-		peerConnection, err := api.NewPeerConnection(config)
-		if err != nil {
-			http.Error(w, "Failed to create peer connection: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// Print the server's PID
+	fmt.Printf("SERVER_PID=%d\n", os.Getpid())
 
-		// Monitor connection state changes
-		peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
-			logger.Info("Connection state changed: %s", state.String())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := server.Run(ctx, cfg); err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+}
 
-			switch state {
-			case webrtc.PeerConnectionStateConnected:
-				logger.Info("WebRTC connection established successfully!")
-			case webrtc.PeerConnectionStateFailed:
-				logger.Error("WebRTC connection failed")
-			case webrtc.PeerConnectionStateClosed:
-				logger.Info("WebRTC connection closed")
-			}
-		})
+// preflightTimeout bounds how long server --check waits for each ICE server
+const preflightTimeout = 3 * time.Second
 
-		// Set the remote description
-		if err := peerConnection.SetRemoteDescription(offer); err != nil {
-			http.Error(w, "Failed to set remote description: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+// runServerCheck runs the checks of server --check and exits unsuccessfully
+// if any failed, without starting the server
+func runServerCheck() {
+	var report preflight.Report
 
-		// Create a data channel
-		dataChannel, err := peerConnection.CreateDataChannel("fileStream", nil)
-		if err != nil {
-			http.Error(w, "Failed to create data channel: "+err.Error(), http.StatusInternalServerError)
-			return
+	configFile := "none, flags and defaults only"
+	if used := viper.ConfigFileUsed(); used != "" {
+		configFile = used
+	}
+	report.Check("config file", errors.Join(configErrs...), configFile)
+	report.Check("settings", errors.Join(checkServerSettings()...), "valid")
+
+	// The streamed file and the exports must be readable by the server's user
+	readable := func(name, spec string) {
+		n, err := preflight.Readable(spec)
+		detail := "readable"
+		if n != 1 {
+			detail = fmt.Sprintf("%d files readable", n)
 		}
+		report.Check(name, err, detail)
+	}
+	if filename := viper.GetString("server.file"); sample.Builtin(filename) {
+		report.Check("file "+filename, nil, "built-in sample, extracted to the cache directory on start")
+	} else if filename != "" {
+		readable("file "+filename, filename)
+	}
+	list, err := configuredExports(viper.GetViper())
+	report.Check("exports", err, fmt.Sprintf("%d configured", len(list)))
+	for _, e := range list {
+		readable("export "+e.Name, e.Path)
+	}
 
-		// Set up data channel handlers
-		dataChannel.OnOpen(func() {
-			logger.Info("Data channel opened")
-
-			// Increment the wait group
-			wg.Add(1)
-
-			// Start streaming the file in a goroutine
-			go func() {
-				defer wg.Done()
-				defer dataChannel.Close()
+	detail, err := checkServerIdentity()
+	report.Check("identity", err, detail)
+	addr := viper.GetString("server.addr")
+	report.Check("port "+addr, preflight.Bindable(addr), "bindable")
+
+	// Contact the ICE servers clients will be told about, or the public ones
+	// the server falls back to
+	iceServers, fallback, err := iceServersFor("server")
+	var urls []string
+	switch {
+	case err != nil:
+		report.Check("ICE servers", err, "")
+	case viper.GetBool("server.no_internet"):
+		report.Skip("ICE servers", "--no-internet, only direct connections")
+	case fallback != nil:
+		urls = autostun.DefaultServers
+	case len(iceServers) == 0:
+		report.Skip("ICE servers", "none configured, only direct connections")
+	default:
+		urls = config.ICEServerURLs(iceServers)
+	}
+	for _, u := range urls {
+		detail, err = preflight.ICEServer(u, preflightTimeout)
+		report.Check("ICE server "+u, err, detail)
+	}
 
-				streamFile(dataChannel, filename, delay)
-			}()
-		})
+	// Signaling is plain HTTP; certificates belong to the proxy in front
+	report.Skip("TLS", "the server serves plain HTTP and has no certificate to check")
 
-		dataChannel.OnClose(func() {
-			logger.Info("Data channel closed")
-		})
+	report.Write(os.Stdout)
+	if report.Failed() > 0 {
+		os.Exit(1)
+	}
+}
 
-		// Create an answer
-		answer, err := peerConnection.CreateAnswer(nil)
+// checkServerSettings validates the settings runServer parses, returning
+// every problem at once. runServer refuses to start with any of them, and
+// server --check reports them.
+func checkServerSettings() []error {
+	var errs []error
+	invalid := func(flag string, err error) {
 		if err != nil {
-			http.Error(w, "Failed to create answer: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		// Set the local description
-		if err := peerConnection.SetLocalDescription(answer); err != nil {
-			http.Error(w, "Failed to set local description: "+err.Error(), http.StatusInternalServerError)
-			return
+			errs = append(errs, fmt.Errorf("invalid %s: %w", flag, err))
 		}
-
-		// Wait for ICE gathering to complete
-		logger.Info("Waiting for ICE gathering to complete...")
-		<-webrtc.GatheringCompletePromise(peerConnection)
-		logger.Info("ICE gathering complete")
-
-		// Get the local description after ICE gathering is complete
-		answer = *peerConnection.LocalDescription()
-
-		// Return the answer
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(answer); err != nil {
-			logger.Error("Failed to encode answer: %v", err)
+	}
+	conflict := func(conflicts bool, msg string) {
+		if conflicts {
+			errs = append(errs, errors.New(msg))
 		}
-	})
+	}
 
-	// Start the HTTP server
-	server := &http.Server{Addr: addr}
-	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Error("HTTP server error: %v", err)
+	for _, size := range []struct{ flag, key string }{
+		{"--read-ahead", "server.read_ahead"},
+		{"--length", "server.length"},
+		{"--memory-limit", "server.memory_limit"},
+	} {
+		if v := viper.GetString(size.key); v != "" {
+			_, err := source.ParseSize(v)
+			invalid(size.flag, err)
 		}
-	}()
-
-	// Print the server's PID
-	fmt.Printf("SERVER_PID=%d\n", os.Getpid())
+	}
+	terms := records.Terms{Policy: viper.GetString("server.oversized")}
+	if v := viper.GetString("server.max_record_size"); v != "" {
+		n, err := source.ParseSize(v)
+		invalid("--max-record-size", err)
+		terms.MaxSize = int(n)
+	}
+	invalid("--max-record-size or --oversized", terms.Validate())
+
+	enableFIPS("server")
+	_, err := checksumsFor("server")
+	invalid("--checksum", err)
+	if dir := viper.GetString("server.share_dir"); dir != "" {
+		_, err := pathpolicy.New(dir, viper.GetBool("server.follow_symlinks"))
+		invalid("--share-dir", err)
+	}
+	_, err = uploadPipeline()
+	invalid("upload configuration", err)
+	if spec := viper.GetString("server.forward"); spec != "" {
+		_, err := forward.ParseEndpoint(spec)
+		invalid("--forward", err)
+	}
 
-	// Wait for shutdown signal
-	<-shutdown
-	logger.Info("Shutting down server...")
+	unreliable := viper.GetBool("server.unreliable")
+	if spec := viper.GetString("server.fec"); spec != "" {
+		conflict(!unreliable, "--fec requires --unreliable")
+		_, _, err := fec.ParseRatio(spec)
+		invalid("--fec", err)
+	}
+	_, err = sessions.ParsePolicy(viper.GetString("server.duplicate_policy"))
+	invalid("--duplicate-policy", err)
+	if batching, err := batchLimits(); err != nil {
+		errs = append(errs, err)
+	} else if batching.Enabled() {
+		conflict(viper.GetBool("server.low_latency"), "--low-latency sends every line on its own, it cannot be combined with --batch")
+		conflict(viper.GetString("server.fec") != "", "--fec sends every line as shards, it cannot be combined with --batch")
+	}
+	conflict(viper.GetInt("server.max_connections") < 0, "invalid --max-connections: negative")
+	conflict(viper.GetInt("server.max_sessions_per_ip") < 0 || viper.GetInt("server.max_sessions_per_identity") < 0 || viper.GetDuration("server.session_cooldown") < 0,
+		"invalid --max-sessions-per-ip, --max-sessions-per-identity or --session-cooldown: negative")
+	_, err = sessions.ParseStallPolicy(viper.GetString("server.stall_policy"))
+	invalid("--stall-policy", err)
+	conflict(viper.GetDuration("server.stall_timeout") < 0, "invalid --stall-timeout: negative")
+
+	requireNoise := viper.GetBool("server.require_noise")
+	rendezvousURL := viper.GetString("server.rendezvous")
+	conflict(requireNoise && rendezvousURL != "", "--require-noise cannot be combined with --rendezvous")
+	offerRole, err := signaling.ParseOfferRole(viper.GetString("server.offer_role"))
+	invalid("--offer-role", err)
+	conflict(offerRole == signaling.OfferRoleServer && (requireNoise || rendezvousURL != ""),
+		"--offer-role server cannot be combined with --require-noise or --rendezvous")
+	corsOrigins := viper.GetStringSlice("server.cors_origins")
+	for _, origin := range corsOrigins {
+		invalid("--cors-origin", webui.ValidOrigin(origin))
+	}
+	browsers := viper.GetBool("server.web_ui") || len(corsOrigins) > 0
+	conflict(browsers && (requireNoise || offerRole == signaling.OfferRoleServer),
+		"--web-ui and --cors-origin cannot be combined with --require-noise or --offer-role server, which browsers cannot follow")
+
+	manual, err := signaling.ParseSignal(viper.GetString("server.signal"))
+	invalid("--signal", err)
+	conflict(manual && (requireNoise || offerRole == signaling.OfferRoleServer || viper.GetBool("server.tui")),
+		"--signal manual and qr cannot be combined with --require-noise, --offer-role server or --tui")
+
+	completeBy, err := deadline.Parse(viper.GetString("server.complete_by"))
+	invalid("--complete-by", err)
+	if windows := viper.GetStringSlice("server.pacing_windows"); len(windows) > 0 {
+		_, err := pacing.Parse(windows)
+		invalid("--pacing-window", err)
+	}
+	if spec := viper.GetString("server.rate"); spec != "" {
+		_, err := server.ParseRate(spec)
+		invalid("--rate", err)
+		conflict(viper.GetBool("server.adaptive_pacing"), "--adaptive-pacing follows the --delay between lines, it cannot be combined with --rate")
+	}
 
-	// Shutdown the HTTP server
-	if err := server.Close(); err != nil {
-		logger.Error("Error shutting down HTTP server: %v", err)
+	// Settings that need a single file to stream
+	filename := viper.GetString("server.file")
+	single := filename != "" && !bundle.IsBundle(filename)
+	conflict(unreliable && filename != "" && !single, "--unreliable cannot stream several files, their markers need an ordered channel")
+	conflict(viper.GetBool("server.follow") && !single, "--follow needs a single --file to stream")
+	conflict(viper.GetBool("server.follow") && !completeBy.IsZero(), "--complete-by needs the end of the file, which --follow never reaches")
+	conflict(viper.GetDuration("server.retention") < 0, "invalid --retention: negative")
+	conflict(viper.GetDuration("server.retention") > 0 && (!viper.GetBool("server.follow") || viper.GetBool("server.broadcast")),
+		"--retention keeps the arrival of the lines of a --follow stream, which --broadcast does not replay")
+	conflict(viper.GetBool("server.broadcast") && !single, "--broadcast needs a single --file to stream")
+	conflict(viper.GetBool("server.broadcast") && (!completeBy.IsZero() || viper.GetBool("server.adaptive_pacing")),
+		"--broadcast paces every client alike, it cannot follow --complete-by or --adaptive-pacing")
+	conflict(viper.GetBool("server.raw") && !single, "--raw needs a single --file to stream")
+	conflict(single && server.RawSource(filename, viper.GetBool("server.raw")) && (viper.GetBool("server.broadcast") || viper.GetBool("server.follow") || !completeBy.IsZero() || viper.GetString("server.fec") != ""),
+		"--raw files and block devices are sent in chunks of bytes, they cannot be combined with --broadcast, --follow, --complete-by or --fec, which need lines")
+
+	list, err := configuredExports(viper.GetViper())
+	if err == nil {
+		err = exports.NewSet().Load(list)
 	}
+	invalid("exports", err)
+	conflict(filename == "" && len(list) == 0, "no --file to stream and no exports configured")
 
-	// Wait for all connections to complete
-	wg.Wait()
-	logger.Info("Server shutdown complete")
+	invalid("interceptor configuration", checkInterceptors("server"))
+	var schedules []config.ScheduleConfig
+	if err := viper.UnmarshalKey("server.schedules", &schedules); err != nil {
+		invalid("schedules", err)
+	}
+	var sharePolicy *pathpolicy.Policy
+	if dir := viper.GetString("server.share_dir"); dir != "" {
+		sharePolicy, _ = pathpolicy.New(dir, viper.GetBool("server.follow_symlinks"))
+	}
+	scheduled := exports.NewSet()
+	scheduled.Load(list)
+	for _, s := range schedules {
+		_, err := schedule.NewEntry(s.Cron, s.File, s.Peers)
+		invalid("schedule", err)
+		_, err = server.PushPath(s.File, sharePolicy, scheduled, viper.GetBool("server.follow_symlinks"))
+		invalid("schedule", err)
+	}
+	return errs
 }
 
-func runClient() {
-	// Get configuration from viper
-	serverURL := viper.GetString("client.server")
-	output := viper.GetString("client.output")
-	stunServerURL := viper.GetString("client.stun")
-
-	logger.Info("Starting WebRTC file streaming client")
-	logger.Info("Connecting to server: %s", serverURL)
-
-	// Create a new SettingEngine
-	settingEngine := webrtc.SettingEngine{}
-
-	// Configure ICE based on whether STUN server is provided
-	if stunServerURL == "" {
-		// No STUN server - use only local candidates
-		logger.Info("No STUN server provided, using direct connection only")
-
-		// Disable mDNS
-		settingEngine.SetICEMulticastDNSMode(0) // 0 = Disabled
-
-		// Allow all interfaces for direct connection
-		settingEngine.SetInterfaceFilter(func(interfaceName string) bool {
-			return true // Allow all interfaces
-		})
-	} else {
-		logger.Info("Using STUN server: %s", stunServerURL)
+// checkServerIdentity checks that the server's identity key can be loaded and
+// returns its ID, without creating the key when it does not exist yet
+func checkServerIdentity() (string, error) {
+	path := viper.GetString("server.identity_file")
+	if path == "" {
+		var err error
+		if path, err = identity.DefaultPath(); err != nil {
+			return "", err
+		}
 	}
-
-	// Create a new RTCPeerConnection configuration
-	config := webrtc.Configuration{}
-
-	// Add ICE servers if STUN server is provided
-	if stunServerURL != "" {
-		config.ICEServers = []webrtc.ICEServer{
-			{
-				URLs: []string{stunServerURL},
-			},
+	missing := "not created yet, the server creates it at " + path
+	var data []byte
+	if name, ok := keyring.Name(path); ok {
+		secret, err := keyring.Get(name)
+		if errors.Is(err, keyring.ErrNotFound) {
+			return missing, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		data = []byte(secret)
+	} else {
+		var err error
+		if data, err = os.ReadFile(path); os.IsNotExist(err) {
+			return missing, nil
+		} else if err != nil {
+			return "", err
 		}
 	}
+	id, err := identity.Parse(data, path)
+	if err != nil {
+		return "", err
+	}
+	return id.ID(), nil
+}
 
-	// Create a new API with the custom settings
-	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
-
-	// Create a new peer connection
-	peerConnection, err := api.NewPeerConnection(config)
+// batchLimits returns the limits of a batch set by --batch and --batch-bytes
+func batchLimits() (batch.Limits, error) {
+	lines := viper.GetInt("server.batch")
+	if lines < 0 {
+		return batch.Limits{}, fmt.Errorf("invalid --batch: %d is negative", lines)
+	}
+	size, err := source.ParseSize(viper.GetString("server.batch_bytes"))
 	if err != nil {
-		logger.Error("Failed to create peer connection: %v", err)
-		os.Exit(1)
+		return batch.Limits{}, fmt.Errorf("invalid --batch-bytes: %w", err)
+	}
+	if size < 1 || size > batch.MaxBytes {
+		return batch.Limits{}, fmt.Errorf("invalid --batch-bytes: %d is not between 1 and %d bytes", size, batch.MaxBytes)
 	}
+	return batch.Limits{Lines: lines, Bytes: int(size)}, nil
+}
 
-	// Monitor connection state changes
-	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
-		logger.Info("Connection state changed: %s", state.String())
+// loadExports loads the server's exports and reloads them whenever the
+// config file changes, keeping them as they were if the file turns invalid
+func loadExports() (*exports.Set, error) {
+	shared := exports.NewSet()
+	list, err := configuredExports(viper.GetViper())
+	if err == nil {
+		err = shared.Load(list)
+	}
+	if err != nil {
+		return nil, err
+	}
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return shared, nil
+	}
 
-		switch state {
-		case webrtc.PeerConnectionStateConnected:
-			logger.Info("WebRTC connection established successfully!")
-		case webrtc.PeerConnectionStateFailed:
-			logger.Error("WebRTC connection failed")
-		case webrtc.PeerConnectionStateClosed:
-			logger.Info("WebRTC connection closed")
+	// Watch the file with a viper of its own, so reloading does not change
+	// settings the server is reading
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	v.OnConfigChange(func(fsnotify.Event) {
+		list, err := configuredExports(v)
+		if err == nil {
+			err = config.ValidateFile(path)
+		}
+		if err == nil {
+			err = shared.Load(list)
+		}
+		if err != nil {
+			logger.Error("Keeping the exports as they were: %v", err)
+			return
 		}
+		logger.Info("Reloaded exports: %s", strings.Join(shared.Names(), ", "))
 	})
+	v.WatchConfig()
+	return shared, nil
+}
 
-	// Create a channel to receive data
-	dataChan := make(chan string)
+// configuredExports returns the exports of v's server section
+func configuredExports(v *viper.Viper) ([]exports.Export, error) {
+	var configs []config.ExportConfig
+	if err := v.UnmarshalKey("server.exports", &configs); err != nil {
+		return nil, err
+	}
+	list := make([]exports.Export, 0, len(configs))
+	for _, c := range configs {
+		list = append(list, exports.Export{Name: c.Name, Path: c.Path, Mode: c.Mode, Allow: c.Allow, Rate: c.Rate, Watch: c.Watch})
+	}
+	return list, nil
+}
+
+// loadIdentity loads the identity key configured in the given section
+// ("server" or "client"), creating it on first use
+func loadIdentity(section string) (*identity.Identity, error) {
+	path := viper.GetString(section + ".identity_file")
+	if path == "" {
+		var err error
+		if path, err = identity.DefaultPath(); err != nil {
+			return nil, err
+		}
+	}
 
-	// Create a data channel to ensure media section in SDP
-	_, err = peerConnection.CreateDataChannel("initChannel", nil)
+	id, err := loadIdentityKey(path)
 	if err != nil {
-		logger.Error("Failed to create init data channel: %v", err)
-		os.Exit(1)
+		return nil, err
 	}
+	logger.Info("Peer identity: %s", id.ID())
+	return id, nil
+}
 
-	// Set up data channel handler
-	peerConnection.OnDataChannel(func(d *webrtc.DataChannel) {
-		logger.Info("New data channel: %s", d.Label())
+// loadIdentityKey loads the identity key at path, or stored in the keyring
+// for a keyring: reference, creating it on first use
+func loadIdentityKey(path string) (*identity.Identity, error) {
+	name, ok := keyring.Name(path)
+	if !ok {
+		return identity.LoadOrCreate(path)
+	}
+	data, err := keyring.GetOrCreate(name, func() (string, error) {
+		data, err := identity.Generate()
+		return string(data), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return identity.Parse([]byte(data), path)
+}
 
-		d.OnOpen(func() {
-			logger.Info("Data channel opened")
+// openChunkCache opens the client's chunk cache in dir, encrypted if the
+// client's state is
+func openChunkCache(dir string) (*dedup.Cache, error) {
+	cache, err := dedup.OpenCache(dir)
+	if err != nil {
+		return nil, err
+	}
+	key, err := loadStateKey()
+	if err != nil || key == nil {
+		return cache, err
+	}
+	return cache.Encrypt(key), nil
+}
+
+// loadStateKey loads the key the client's state is encrypted with, creating
+// it on first use, or returns nil if the state is kept in the clear
+func loadStateKey() (*seal.Key, error) {
+	if !viper.GetBool("client.encrypt_state") {
+		return nil, nil
+	}
+	path := viper.GetString("client.state_key")
+	if path == "" {
+		var err error
+		if path, err = seal.DefaultPath(); err != nil {
+			return nil, err
+		}
+	}
+	var key *seal.Key
+	var err error
+	if name, ok := keyring.Name(path); ok {
+		var text string
+		if text, err = keyring.GetOrCreate(name, seal.Generate); err == nil {
+			key, err = seal.Parse(text, path)
+		}
+	} else {
+		key, err = seal.LoadOrCreate(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Encrypting the client's state with the key in %s", path)
+	return key, nil
+}
+
+// clientCredentials loads the client's signaling credentials
+func clientCredentials() (client.Credentials, error) {
+	id, err := loadIdentity("client")
+	if err != nil {
+		return client.Credentials{}, err
+	}
+	creds := client.Credentials{
+		AuthToken:      viper.GetString("client.auth_token"),
+		Identity:       id,
+		ServerIdentity: viper.GetString("client.server_identity"),
+		Noise:          viper.GetBool("client.noise"),
+		Code:           viper.GetString("client.code"),
+		QR:             viper.GetString("client.signal") == signaling.SignalQR,
+	}
+
+	if creds.OfferRole, err = signaling.ParseOfferRole(viper.GetString("client.offer_role")); err != nil {
+		return client.Credentials{}, err
+	}
+	if creds.OfferRole == signaling.OfferRoleServer && (creds.Noise || creds.Code != "") {
+		return client.Credentials{}, errors.New("--offer-role server cannot be combined with --noise or --code")
+	}
+	if creds.Manual, err = signaling.ParseSignal(viper.GetString("client.signal")); err != nil {
+		return client.Credentials{}, err
+	}
+	if creds.Manual && (creds.Noise || creds.Code != "" || creds.OfferRole == signaling.OfferRoleServer) {
+		return client.Credentials{}, errors.New("--signal manual and qr cannot be combined with --noise, --code or --offer-role server")
+	}
+
+	// Connect through a rendezvous server when given a code
+	if creds.Code != "" {
+		if creds.Noise {
+			return client.Credentials{}, errors.New("--noise cannot be combined with --code")
+		}
+		rendezvousURL, err := rendezvous.Discover(viper.GetString("client.rendezvous"))
+		if err != nil {
+			return client.Credentials{}, err
+		}
+		logger.Info("Connecting through rendezvous server %s with code %s", rendezvousURL, creds.Code)
+		creds.Rendezvous = rendezvous.NewClient(rendezvousURL)
+	}
+	return creds, nil
+}
+
+func runIdentity() {
+	path := identityFile
+	if path == "" {
+		var err error
+		if path, err = identity.DefaultPath(); err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
+	}
+
+	id, err := loadIdentityKey(path)
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	if identitySign != "" {
+		checksums, err := os.ReadFile(identitySign)
+		if err != nil {
+			logger.Error("Failed to read checksums: %v", err)
+			os.Exit(1)
+		}
+		signature, err := update.Sign(id, identityVers, checksums)
+		if err != nil {
+			logger.Error("Failed to sign %s: pass the version of the release with --sign-version: %v", identitySign, err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(identitySign+".sig", signature, 0644); err != nil {
+			logger.Error("Failed to write signature: %v", err)
+			os.Exit(1)
+		}
+	}
+	fmt.Println(id.ID())
+}
+
+// runKeyringSet stores the secret read from stdin under name
+func runKeyringSet(name string) {
+	var secret []byte
+	var err error
+	if term.IsTerminal(os.Stdin.Fd()) {
+		fmt.Fprintf(os.Stderr, "Secret for %s: ", name)
+		secret, err = term.ReadPassword(os.Stdin.Fd())
+		fmt.Fprintln(os.Stderr)
+	} else {
+		secret, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		logger.Error("Failed to read the secret: %v", err)
+		os.Exit(1)
+	}
+	value := strings.TrimRight(string(secret), "\r\n")
+	if value == "" {
+		logger.Error("No secret given for %s", name)
+		os.Exit(1)
+	}
+	if err := keyring.Set(name, value); err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Stored %s, refer to it as %s%s\n", name, keyring.Prefix, name)
+}
+
+func runSelfUpdate() {
+	baseURL := updateURL
+	if baseURL == "" {
+		baseURL = viper.GetString("client.update_url")
+	}
+	key := updateKey
+	if key == "" {
+		key = viper.GetString("client.update_key")
+	}
+	if baseURL == "" || key == "" {
+		logger.Error("No release configured: pass the release URL with --url and the identity that signs it with --key")
+		os.Exit(1)
+	}
+
+	path, err := os.Executable()
+	if err == nil {
+		path, err = filepath.EvalSymlinks(path)
+	}
+	if err != nil {
+		logger.Error("Failed to find this binary: %v", err)
+		os.Exit(1)
+	}
+
+	// Compare the signed version of the release before downloading it, and
+	// only install an older or the same release when forced to, so a replayed
+	// release cannot roll the binary back
+	client := &http.Client{Timeout: 5 * time.Minute}
+	release, err := update.Latest(client, baseURL, key, runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		logger.Error("Failed to check for updates: %v", err)
+		os.Exit(1)
+	}
+	newer := update.Newer(release.Version, version)
+	if updateCheck {
+		if newer {
+			logger.Info("Update available: %s, this binary is %s (%s)", release.Version, version, release.URL)
+		} else {
+			logger.Info("Already up to date: the latest release is %s, this binary is %s", release.Version, version)
+		}
+		return
+	}
+	if !newer && !updateForce {
+		if !update.Newer(version, release.Version) {
+			logger.Info("Already up to date (%s)", version)
+			return
+		}
+		logger.Error("Refusing to replace %s with the older release %s, pass --force to install it anyway", version, release.Version)
+		os.Exit(1)
+	}
+	binary, err := release.Binary(client)
+	if err != nil {
+		logger.Error("Failed to download %s: %v", release.Archive, err)
+		os.Exit(1)
+	}
+	if err := update.Replace(path, binary); err != nil {
+		logger.Error("Failed to update %s: %v", path, err)
+		os.Exit(1)
+	}
+	logger.Info("Updated %s from %s to %s", path, version, release.Version)
+}
+
+func runTraceView(paths []string) {
+	var traces [][]trace.Event
+	for _, path := range paths {
+		events, err := trace.Read(path)
+		if err != nil {
+			logger.Error("Failed to read trace: %v", err)
+			os.Exit(1)
+		}
+		if len(traceSession) > 0 {
+			events = trace.Filter(events, traceSession...)
+		}
+		traces = append(traces, events)
+	}
+	if len(traces) == 2 && len(traceSession) == 0 {
+		traces[0], traces[1] = trace.Correlate(traces[0], traces[1])
+	}
+	trace.View(os.Stdout, traceWidth, traces...)
+}
+
+func runMaintenance(action string) {
+	method, body := http.MethodGet, []byte(nil)
+	switch action {
+	case "on", "off":
+		method = http.MethodPut
+		body, _ = json.Marshal(maintenance.Status{
+			Enabled:    action == "on",
+			RetryAfter: int(ctlRetry / time.Second),
+			Reason:     ctlReason,
 		})
+	case "status":
+	default:
+		logger.Error("Unknown maintenance action %q (expected on, off or status)", action)
+		os.Exit(1)
+	}
+
+	resp := controlRequest(method, "/control/maintenance", body, http.StatusOK)
+	defer resp.Body.Close()
+
+	var status maintenance.Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		logger.Error("Failed to parse the server's reply: %v", err)
+		os.Exit(1)
+	}
+	if !status.Enabled {
+		fmt.Println("maintenance: off")
+		return
+	}
+	fmt.Printf("maintenance: on since %s, retry after %v\n", status.Since.Format(time.RFC3339), time.Duration(status.RetryAfter)*time.Second)
+	if status.Reason != "" {
+		fmt.Printf("reason: %s\n", status.Reason)
+	}
+}
+
+func runPush(peer, file string) {
+	body, _ := json.Marshal(server.PushRequest{Peer: peer, File: file})
+	resp := controlRequest(http.MethodPost, "/push", body, http.StatusAccepted)
+	defer resp.Body.Close()
+
+	var push schedule.Push
+	if err := json.NewDecoder(resp.Body).Decode(&push); err != nil {
+		logger.Error("Failed to parse the server's reply: %v", err)
+		os.Exit(1)
+	}
+	fmt.Println(push.ID)
+}
+
+// controlRequest sends a request to the control API of the server --server
+// names, or of the one configured on this host, exiting unless it answers
+// with status
+func controlRequest(method, path string, body []byte, status int) *http.Response {
+	base := ctlServer
+	if base == "" {
+		addr := viper.GetString("server.addr")
+		if strings.HasPrefix(addr, ":") {
+			addr = "localhost" + addr
+		}
+		base = "http://" + addr
+	}
+	token := viper.GetString("server.control_token")
+	if ctlToken != "" {
+		var err error
+		if token, err = config.ResolveSecret(ctlToken); err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(base, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		logger.Error("Invalid server URL: %v", err)
+		os.Exit(1)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Error("Failed to reach the server: %v", err)
+		os.Exit(1)
+	}
+	if resp.StatusCode != status {
+		msg, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		logger.Error("Server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		os.Exit(1)
+	}
+	return resp
+}
+
+func runProfile() {
+	path := profSocket
+	if path == "" {
+		path = viper.GetString("server.debug_socket")
+	}
+	if path == "" {
+		logger.Error("No debug socket configured: start the server with --debug-socket and pass the same path with --socket")
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(profOutput, 0o755); err != nil {
+		logger.Error("Failed to create output directory: %v", err)
+		os.Exit(1)
+	}
+
+	client := profiling.NewClient(path)
+	ctx := context.Background()
+	stamp := time.Now().Format("20060102-150405")
+
+	logger.Info("Profiling the server's CPU for %v...", profDuration)
+	cpu, err := client.CPU(ctx, profDuration)
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	heap, err := client.Heap(ctx)
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+
+	for _, p := range []struct {
+		name, label string
+		data        []byte
+	}{{"cpu", "CPU", cpu}, {"heap", "Heap", heap}} {
+		file := filepath.Join(profOutput, fmt.Sprintf("webrtc-poc-%s-%s.pprof", p.name, stamp))
+		if err := os.WriteFile(file, p.data, 0o644); err != nil {
+			logger.Error("Failed to write %s profile: %v", p.name, err)
+			os.Exit(1)
+		}
+		parsed, err := profiling.Parse(p.data)
+		if err != nil {
+			logger.Error("Failed to read %s profile: %v", p.name, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s profile written to %s\n", p.label, file)
+		profiling.WriteSummary(os.Stdout, parsed, profTop)
+		fmt.Printf("Explore it with: go tool pprof -http=: %s\n\n", file)
+	}
+}
+
+func runSignal() {
+	logger.Info("Starting rendezvous server on %s", signalAddr)
+
+	server := &http.Server{Addr: signalAddr, Handler: rendezvous.NewRelay(signalTTL)}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTP server error: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Wait for shutdown signal
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	<-shutdown
+	logger.Info("Shutting down rendezvous server...")
+	if err := server.Close(); err != nil {
+		logger.Error("Error shutting down HTTP server: %v", err)
+	}
+}
+
+func runDiscover() {
+	servers, err := discovery.Browse(discoverTimeout)
+	if err != nil {
+		logger.Error("Discovery failed: %v", err)
+		os.Exit(1)
+	}
+	discovery.Write(os.Stdout, servers)
+}
+
+func runDiagnose() {
+	iceServers, fallback, err := iceServersFor("client")
+	if err != nil {
+		logger.Error("Invalid ICE configuration: %v", err)
+		os.Exit(1)
+	}
+	if err := checkInterceptors("client"); err != nil {
+		logger.Error("Invalid interceptor configuration: %v", err)
+		os.Exit(1)
+	}
+
+	// Probe the NAT with the configured STUN servers, or the public ones the
+	// client falls back to. Two servers are needed to tell NAT types apart.
+	var urls []string
+	for _, server := range iceServers {
+		urls = append(urls, server.URLs...)
+	}
+	if fallback != nil || (len(iceServers) > 0 && len(urls) < 2) {
+		urls = append(urls, autostun.DefaultServers...)
+	}
+	var stunServers []string
+	for _, u := range urls {
+		if addr, ok := diagnose.STUNAddress(u); ok {
+			stunServers = append(stunServers, addr)
+		}
+	}
+
+	logger.Info("Running connectivity diagnostics...")
+	report := diagnose.Run(diagnose.Options{
+		STUNServers: stunServers,
+		Ports:       diagnosePorts,
+		Timeout:     diagnoseTimeout,
+		API:         peer.NewAPI(iceServers, viper.GetStringSlice("client.interceptors")),
+		Config:      webrtc.Configuration{ICEServers: iceServers},
+	})
+	report.Write(os.Stdout)
+}
+
+func runClient() {
+	logger.Info("Starting WebRTC file streaming client")
+
+	// Record the connections into the trace file, if requested
+	if path := viper.GetString("client.trace"); path != "" {
+		if err := trace.Open(path, "client"); err != nil {
+			logger.Error("Invalid --trace: %v", err)
+			os.Exit(1)
+		}
+		defer trace.Close()
+	}
+
+	cfg, err := clientConfig()
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	cfg.Output = viper.GetString("client.output")
+	cfg.Group = viper.GetString("client.group")
+	cfg.Since = viper.GetDuration("client.since")
+	cfg.SinceSeq = viper.GetInt("client.since_seq")
+	cfg.Uploads = viper.GetStringSlice("client.upload_files")
+	cfg.Exec = viper.GetString("client.exec")
+	cfg.ExecOptions = sink.ExecOptions{
+		Restart:     viper.GetString("client.exec_restart"),
+		MaxRestarts: viper.GetInt("client.exec_max_restarts"),
+	}
+	cfg.OutputTemplate = viper.GetString("client.output_template")
+	cfg.Roll = viper.GetString("client.roll")
+	cfg.MetricsAddr = viper.GetString("client.metrics_addr")
+	cfg.TUI = viper.GetBool("client.tui")
+
+	// Daemon mode waits for pushes instead of connecting right away
+	if cfg.Fetches, err = clientFetches(); err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	if viper.GetBool("client.daemon") {
+		if cfg.Subscription, cfg.StatePath, cfg.StateKey, err = clientSubscription(); err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
+	}
+
+	// Check the write rate before connecting
+	if cfg.WriteRate, cfg.WriteBuffer, err = outputWriteLimit(); err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+
+	// Forward a local socket over the connection if requested
+	if cfg.Forwarder, err = forwarderFor("client"); err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+
+	// Print the client's PID
+	fmt.Printf("CLIENT_PID=%d\n", os.Getpid())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := client.Run(ctx, cfg); err != nil {
+		logger.Error("%v", err)
+		os.Exit(execStatus(err))
+	}
+}
+
+// clientConfig resolves the settings every command connecting to a server
+// as a client shares
+func clientConfig() (client.Config, error) {
+	cfg := client.Config{
+		ServerURL:      viper.GetString("client.server"),
+		ConnectTimeout: viper.GetDuration("client.connect_timeout"),
+		TrickleICE:     viper.GetBool("client.trickle_ice"),
+		Channel: peer.Channel{
+			Label:      viper.GetString("client.channel_label"),
+			Protocol:   viper.GetString("client.channel_protocol"),
+			Negotiated: viper.GetBool("client.negotiated_channel"),
+			ID:         uint16(viper.GetUint("client.channel_id")),
+		},
+		ReceiveWindow:     viper.GetInt("client.receive_window"),
+		Export:            viper.GetString("client.export"),
+		Records:           records.Terms{Policy: viper.GetString("client.oversized")},
+		Batch:             viper.GetBool("client.batch"),
+		RemoteLogs:        viper.GetBool("client.remote_logs"),
+		HeartbeatInterval: viper.GetDuration("client.heartbeat_interval"),
+		FetchParallel:     viper.GetInt("client.fetch_parallel"),
+		OutputDir:         viper.GetString("client.output_dir"),
+		FollowSymlinks:    viper.GetBool("client.follow_symlinks"),
+		Name:              viper.GetString("client.name"),
+	}
+
+	// Resolve the configured ICE servers
+	var err error
+	if cfg.ICEServers, cfg.Fallback, err = iceServersFor("client"); err != nil {
+		return cfg, fmt.Errorf("invalid ICE configuration: %w", err)
+	}
+	if err := checkInterceptors("client"); err != nil {
+		return cfg, fmt.Errorf("invalid interceptor configuration: %w", err)
+	}
+	cfg.Interceptors = viper.GetStringSlice("client.interceptors")
+	enableFIPS("client")
+	if cfg.Fallback != nil {
+		cfg.ICEServers = cfg.Fallback.ICEServers()
+	}
+
+	// Load the identity and token presented to the server
+	if cfg.Credentials, err = clientCredentials(); err != nil {
+		return cfg, err
+	}
+
+	// Ask for smaller records or another policy for longer lines
+	if v := viper.GetString("client.max_record_size"); v != "" {
+		n, err := source.ParseSize(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid --max-record-size: %w", err)
+		}
+		cfg.Records.MaxSize = int(n)
+	}
+	if err := cfg.Records.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid --max-record-size or --oversized: %w", err)
+	}
+
+	// Requested files reuse the chunks cached by earlier transfers, hashed
+	// with the checksum algorithms offered to the server
+	if dir := viper.GetString("client.dedup_cache"); dir != "" {
+		if cfg.Checksums, err = checksumsFor("client"); err != nil {
+			return cfg, fmt.Errorf("invalid --checksum: %w", err)
+		}
+		if cfg.Cache, err = openChunkCache(dir); err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// clientSubscription loads the saved subscription of a daemon mode client
+// and the path and key it is saved with. Settings given explicitly win over
+// the saved ones.
+func clientSubscription() (*subscription.State, string, *seal.Key, error) {
+	statePath := viper.GetString("client.state_file")
+	if statePath == "" {
+		var err error
+		if statePath, err = subscription.DefaultPath(); err != nil {
+			return nil, "", nil, err
+		}
+	}
+	key, err := loadStateKey()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	state, err := subscription.Load(statePath, key)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	serverURL, name, filter := state.Server, state.Name, state.Filter
+	if serverURL == "" || viper.IsSet("client.server") {
+		serverURL = viper.GetString("client.server")
+	}
+	if name == "" || viper.IsSet("client.name") {
+		name = viper.GetString("client.name")
+	}
+	if viper.IsSet("client.filter") {
+		filter = viper.GetString("client.filter")
+	}
+	if state.Name != "" {
+		logger.Info("Loaded subscription from %s", statePath)
+	}
+	state.Subscribe(serverURL, name, filter)
+	return state, statePath, key, nil
+}
+
+// outputWriteLimit parses --write-rate and --write-buffer, returning a rate
+// of 0 if writes are not throttled
+func outputWriteLimit() (int64, int, error) {
+	value := viper.GetString("client.write_rate")
+	if value == "" {
+		return 0, 0, nil
+	}
+	rate, err := source.ParseSize(strings.TrimSuffix(value, "/s"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid --write-rate: %w", err)
+	}
+	if rate == 0 {
+		return 0, 0, errors.New("--write-rate must be positive")
+	}
+	buffer, err := source.ParseSize(viper.GetString("client.write_buffer"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid --write-buffer: %w", err)
+	}
+	return rate, int(buffer), nil
+}
+
+// execStatus returns the exit status the client passes on for an --exec
+// command that failed
+func execStatus(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
+// scannerTimeout bounds how long the --upload-scanner may check an upload
+const scannerTimeout = 2 * time.Minute
+
+// uploadPipeline builds the pipeline uploads go through from the server
+// configuration, or returns nil if uploads are disabled
+func uploadPipeline() (*upload.Pipeline, error) {
+	dest := viper.GetString("server.upload_dir")
+	if dest == "" {
+		return nil, nil
+	}
+	collision, err := upload.ParseCollision(viper.GetString("server.upload_collision"))
+	if err != nil {
+		return nil, err
+	}
+	p := &upload.Pipeline{
+		Dest:       dest,
+		Quarantine: viper.GetString("server.quarantine_dir"),
+		Collision:  collision,
+		PerTenant:  viper.GetBool("server.upload_per_identity"),
+	}
+	if p.Quarantine == "" {
+		p.Quarantine = filepath.Join(dest, ".quarantine")
+	}
+	if size := viper.GetString("server.upload_max_size"); size != "" {
+		limit, err := source.ParseSize(size)
+		if err != nil {
+			return nil, err
+		}
+		p.Limit = limit
+	}
+	if types := viper.GetStringSlice("server.upload_types"); len(types) > 0 {
+		validate, err := upload.ContentTypes(types)
+		if err != nil {
+			return nil, err
+		}
+		p.Validators = append(p.Validators, validate)
+	}
+	if command := viper.GetString("server.upload_scanner"); command != "" {
+		validate, err := upload.Scanner(command, scannerTimeout)
+		if err != nil {
+			return nil, err
+		}
+		p.Validators = append(p.Validators, validate)
+	}
+	logger.Info("Accepting uploads into %s through quarantine %s", p.Dest, p.Quarantine)
+	return p, nil
+}
+
+// clientFetches collects the files the client requests with --request-file
+// and --fetch-list
+func clientFetches() ([]share.Fetch, error) {
+	policy, err := pathpolicy.New(viper.GetString("client.output_dir"), viper.GetBool("client.follow_symlinks"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid output directory: %w", err)
+	}
+
+	var fetches []share.Fetch
+	for _, name := range viper.GetStringSlice("client.request_files") {
+		fetch, err := share.NewFetch(name, policy)
+		if err != nil {
+			return nil, err
+		}
+		fetches = append(fetches, fetch)
+	}
+
+	if list := viper.GetString("client.fetch_list"); list != "" {
+		file, err := os.Open(list)
+		if err != nil {
+			return nil, fmt.Errorf("error opening fetch list: %w", err)
+		}
+		defer file.Close()
+
+		listed, err := share.ParseList(file, policy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fetch list %s: %w", list, err)
+		}
+		fetches = append(fetches, listed...)
+	}
+	return fetches, nil
+}
+
+func runSend(file string) {
+	url := sendRelay
+	if url == "" {
+		url = viper.GetString("server.rendezvous")
+	}
+	rendezvousURL, err := rendezvous.Discover(url)
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(file); err != nil {
+		logger.Error("Cannot send %s: %v", file, err)
+		os.Exit(1)
+	}
+	rate := server.DelayRate(sendDelay)
+	if sendRate != "" {
+		if rate, err = server.ParseRate(sendRate); err != nil {
+			logger.Error("Invalid --rate: %v", err)
+			os.Exit(1)
+		}
+	}
+	iceServers, fallback, err := iceServersFor("server")
+	if err != nil {
+		logger.Error("Invalid ICE configuration: %v", err)
+		os.Exit(1)
+	}
+	if err := checkInterceptors("server"); err != nil {
+		logger.Error("Invalid interceptor configuration: %v", err)
+		os.Exit(1)
+	}
+	enableFIPS("server")
+	if fallback != nil {
+		iceServers = fallback.ICEServers()
+	}
+	serverID, err := loadIdentity("server")
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	allowed := viper.GetStringSlice("server.allowed_identities")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sent := make(chan sender.Progress, 1)
+	snd, err := sender.New(sender.Options{
+		File:       file,
+		Pace:       func() func(n int) time.Duration { return server.NewLimiter(rate).Delay },
+		API:        peer.NewAPI(iceServers, viper.GetStringSlice("server.interceptors")),
+		ICEServers: iceServers,
+		OnState: func(id string, state webrtc.PeerConnectionState) {
+			logger.Info("Connection state changed: %s", state)
+		},
+		OnProgress: func(p sender.Progress) {
+			if p.Done {
+				sent <- p
+			}
+		},
+	})
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+
+	// The file goes to the first peer whose offer is answered; later ones
+	// are rejected through the rendezvous server
+	var claimed atomic.Bool
+	answer := func(offer webrtc.SessionDescription) ([]byte, error) {
+		if !claimed.CompareAndSwap(false, true) {
+			return nil, errors.New("the file is already being sent to another peer")
+		}
+		answer, err := snd.Answer(ctx, offer)
+		if err != nil {
+			claimed.Store(false)
+			return nil, err
+		}
+		return json.Marshal(answer)
+	}
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		server.ServeRendezvous(ctx, rendezvous.NewClient(rendezvousURL), serverID, allowed, answer)
+	}()
+	logger.Info("Sending %s, run receive with the code on the other peer", file)
+
+	var result sender.Progress
+	select {
+	case result = <-sent:
+	case <-ctx.Done():
+		result.Err = ctx.Err()
+	}
+	cancel()
+	snd.Wait()
+	<-served
+	if result.Err != nil {
+		logger.Error("Failed to send %s: %v", file, result.Err)
+		os.Exit(1)
+	}
+	logger.Info("Sent %d lines (%d bytes), the peer received all of them", result.Lines, result.Bytes)
+}
+
+func runReceive(code string) {
+	// stdout carries the file unless it goes to --output
+	if receiveOut == "" {
+		logger.SetOutput(os.Stderr)
+	}
+	if receiveRelay != "" {
+		viper.Set("client.rendezvous", receiveRelay)
+	}
+	viper.Set("client.code", code)
+
+	iceServers, fallback, err := iceServersFor("client")
+	if err != nil {
+		logger.Error("Invalid ICE configuration: %v", err)
+		os.Exit(1)
+	}
+	if err := checkInterceptors("client"); err != nil {
+		logger.Error("Invalid interceptor configuration: %v", err)
+		os.Exit(1)
+	}
+	enableFIPS("client")
+	if fallback != nil {
+		iceServers = fallback.ICEServers()
+	}
+	creds, err := clientCredentials()
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
 
-		d.OnMessage(func(msg webrtc.DataChannelMessage) {
-			data := string(msg.Data)
-			dataChan <- data
-		})
+	out := os.Stdout
+	if receiveOut != "" {
+		if out, err = os.Create(receiveOut); err != nil {
+			logger.Error("Failed to create output file: %v", err)
+			os.Exit(1)
+		}
+	}
 
-		d.OnClose(func() {
-			logger.Info("Data channel closed")
-			close(dataChan)
-		})
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	result, err := receiver.Receive(ctx, receiver.Options{
+		Signal: func(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+			var answer webrtc.SessionDescription
+			offerJSON, err := json.Marshal(offer)
+			if err != nil {
+				return answer, err
+			}
+			answerJSON, err := client.SendRelayedOffer(ctx, creds, offerJSON)
+			if err != nil {
+				return answer, err
+			}
+			if err := json.Unmarshal(answerJSON, &answer); err != nil {
+				return answer, fmt.Errorf("failed to parse answer: %w", err)
+			}
+			return answer, nil
+		},
+		API:        peer.NewAPI(iceServers, viper.GetStringSlice("client.interceptors")),
+		ICEServers: iceServers,
+		Output:     out,
+		OnState: func(state webrtc.PeerConnectionState) {
+			logger.Info("Connection state changed: %s", state)
+		},
 	})
+	if out != os.Stdout {
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write output: %w", closeErr)
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to receive: %v", err)
+		os.Exit(1)
+	}
+	logger.Info("Received %d lines (%d bytes) in %v", result.Lines, result.Bytes, result.Duration.Round(time.Millisecond))
+}
 
-	// Create an offer
-	offer, err := peerConnection.CreateOffer(nil)
+// iceServersFor resolves the ICE servers configured in the given section
+// ("server" or "client"). When no servers are configured and automatic STUN
+// fallback is enabled, a fallback rotating through public servers is returned.
+func iceServersFor(section string) ([]webrtc.ICEServer, *autostun.Fallback, error) {
+	stun := viper.GetString(section + ".stun")
+	if stun != "" {
+		logger.Info("%s.stun is deprecated, use %s.ice_servers instead", section, section)
+	}
+
+	specs := config.ICEServerSpecs(viper.GetStringSlice(section+".ice_servers"), stun)
+	iceServers, err := config.ParseICEServers(specs)
 	if err != nil {
-		logger.Error("Failed to create offer: %v", err)
+		return nil, nil, err
+	}
+
+	// Never contact servers outside the local network in strict offline mode
+	if viper.GetBool(section + ".no_internet") {
+		if len(iceServers) > 0 {
+			return nil, nil, fmt.Errorf("ICE servers cannot be used with --no-internet")
+		}
+		if viper.GetBool(section + ".auto_stun") {
+			logger.Info("--no-internet is set, automatic STUN fallback disabled")
+		}
+		return nil, nil, nil
+	}
+
+	if len(iceServers) == 0 && viper.GetBool(section+".auto_stun") {
+		logger.Info("Automatic STUN fallback enabled, public STUN servers are used if direct connections fail")
+		return nil, autostun.NewFallback(autostun.DefaultServers), nil
+	}
+
+	return iceServers, nil, nil
+}
+
+// checkInterceptors checks the interceptors configured in the given section
+// ("server" or "client"), which peer.NewAPI registers
+func checkInterceptors(section string) error {
+	return interceptors.Validate(viper.GetStringSlice(section + ".interceptors"))
+}
+
+// enableFIPS switches to FIPS 140-3 approved crypto if the given section
+// ("server" or "client") asks for it, and reports the algorithms in use,
+// including the DTLS cipher suites connections may negotiate
+func enableFIPS(section string) {
+	if viper.GetBool(section + ".fips") {
+		fips.Enable()
+	}
+	if !fips.Enabled() {
+		logger.Info("DTLS offers %s", strings.Join(fips.DTLSCipherSuites(), ", "))
+		return
+	}
+	logger.Info("FIPS mode enabled by the %s: Noise signaling uses %s, DTLS offers %s over P-256 and P-384",
+		fips.Source(), fips.NoiseSuite().Name(), strings.Join(fips.DTLSCipherSuites(), ", "))
+}
+
+// checksumsFor returns the checksum algorithms the given section ("server" or
+// "client") allows, in order of preference, defaulting to every algorithm
+// approved mode permits
+func checksumsFor(section string) ([]string, error) {
+	names := viper.GetStringSlice(section + ".checksums")
+	if len(names) == 0 {
+		return checksum.Algorithms(), nil
+	}
+	parsed, err := checksum.Parse(names)
+	if err != nil {
+		return nil, err
+	}
+	if len(parsed) == 0 {
+		return checksum.Algorithms(), nil
+	}
+	return parsed, nil
+}
+
+// runTunnel connects to the server and forwards the -L ports over a tunnel
+// channel until interrupted
+func runTunnel() {
+	var forwards []tunnel.Forward
+	for _, spec := range tunnelLocal {
+		f, err := tunnel.ParseForward(spec)
+		if err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
+		forwards = append(forwards, f)
+	}
+	if len(forwards) == 0 {
+		logger.Error("At least one -L forward is required")
 		os.Exit(1)
 	}
 
-	// Set the local description
-	if err := peerConnection.SetLocalDescription(offer); err != nil {
-		logger.Error("Failed to set local description: %v", err)
+	cfg, err := clientConfig()
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	if tunnelServer != "" {
+		cfg.ServerURL = tunnelServer
+	}
+
+	// Listen before connecting, so a port in use fails right away
+	listeners := make([]net.Listener, len(forwards))
+	for i, f := range forwards {
+		if listeners[i], err = net.Listen("tcp", f.Listen); err != nil {
+			logger.Error("Failed to listen on %s: %v", f.Listen, err)
+			os.Exit(1)
+		}
+		defer listeners[i].Close()
+	}
+
+	// Tunnels do not use the file stream, so its lines are dropped
+	dataChan := make(chan string)
+	go func() {
+		for range dataChan {
+			metrics.ClientPendingLines.Dec()
+		}
+	}()
+	failed := make(chan struct{}, 1)
+	peerConnection, err := client.Connect(context.Background(), cfg, dataChan, failed, client.Hooks{})
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+
+	protocol := tunnel.Protocol
+	dc, err := peerConnection.CreateDataChannel(tunnel.Label, &webrtc.DataChannelInit{Protocol: &protocol})
+	if err != nil {
+		logger.Error("Failed to create tunnel channel: %v", err)
 		os.Exit(1)
 	}
+	mux := tunnel.NewMux(dc.Send, nil)
+	opened := make(chan struct{})
+	closed := make(chan struct{})
+	dc.OnOpen(func() {
+		logger.Info("Tunnel open")
+		close(opened)
+	})
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		mux.Handle(msg.Data)
+	})
+	dc.OnClose(func() {
+		logger.Info("Tunnel closed")
+		mux.Close()
+		close(closed)
+	})
+
+	for i, f := range forwards {
+		logger.Info("Forwarding %s to %s", listeners[i].Addr(), f.Target)
+		go func(l net.Listener, target string) {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					<-opened
+					stream, err := mux.Open(target)
+					if err != nil {
+						logger.Error("Failed to open tunnel to %s: %v", target, err)
+						conn.Close()
+						return
+					}
+					sent, received := tunnel.Join(stream, conn)
+					logger.Info("Tunnel to %s closed: sent %d bytes, received %d bytes", target, sent, received)
+				}()
+			}
+		}(listeners[i], f.Target)
+	}
+
+	// Print the client's PID
+	fmt.Printf("CLIENT_PID=%d\n", os.Getpid())
 
-	// Wait for ICE gathering to complete
-	logger.Info("Waiting for ICE gathering to complete...")
-	<-webrtc.GatheringCompletePromise(peerConnection)
-	logger.Info("ICE gathering complete")
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-shutdown:
+	case <-closed:
+	case <-failed:
+		logger.Error("Connection to the server failed")
+	}
 
-	// Get the local description after ICE gathering is complete
-	offer = *peerConnection.LocalDescription()
+	logger.Info("Shutting down tunnel...")
+	if err := peerConnection.Close(); err != nil {
+		logger.Error("Error closing peer connection: %v", err)
+	}
+}
 
-	// Log the SDP for debugging
-	logger.Debug("Offer SDP: %s", offer.SDP)
+// runPipeListen answers the first offer for a pipe and joins it with stdin
+// and stdout
+func runPipeListen() {
+	// stdout carries the pipe
+	logger.SetOutput(os.Stderr)
 
-	// Send the offer to the server
-	offerJSON, err := json.Marshal(offer)
+	iceServers, fallback, err := iceServersFor("server")
 	if err != nil {
-		logger.Error("Failed to marshal offer: %v", err)
+		logger.Error("Invalid ICE configuration: %v", err)
+		os.Exit(1)
+	}
+	if err := checkInterceptors("server"); err != nil {
+		logger.Error("Invalid interceptor configuration: %v", err)
 		os.Exit(1)
 	}
+	enableFIPS("server")
+	if fallback != nil {
+		iceServers = fallback.ICEServers()
+	}
+	authToken := viper.GetString("server.auth_token")
+	api := peer.NewAPI(iceServers, viper.GetStringSlice("server.interceptors"))
+
+	// The handler hands the connection to the shutdown below, which closes
+	// it, or closes it itself once the pipe shut down
+	var claimed atomic.Bool
+	report, result := pipeOutcome()
+	var mu sync.Mutex
+	var peerConnection *webrtc.PeerConnection
+	shutDown := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/offer", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !server.Authorized(r, authToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, err := signaling.ReadOffer(w, r, "application/json")
+		if err != nil {
+			signaling.WriteError(w, err)
+			return
+		}
+		offer, err := signaling.ParseOffer(body)
+		if err != nil {
+			signaling.WriteError(w, err)
+			return
+		}
+		// A pipe has one other end
+		if !claimed.CompareAndSwap(false, true) {
+			http.Error(w, "The pipe is already connected", http.StatusConflict)
+			return
+		}
+
+		pc, err := api.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers})
+		if err != nil {
+			claimed.Store(false)
+			http.Error(w, "Failed to create peer connection: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		mu.Lock()
+		if shutDown {
+			mu.Unlock()
+			pc.Close()
+			http.Error(w, "The pipe shut down", http.StatusServiceUnavailable)
+			return
+		}
+		peerConnection = pc
+		mu.Unlock()
+		pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+			logger.Info("Pipe connection state: %s", state)
+			if state == webrtc.PeerConnectionStateFailed {
+				report(errors.New("connection failed"))
+			}
+		})
+		pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+			if dc.Label() != pipe.Label || dc.Protocol() != pipe.Protocol {
+				// The pipe has no file stream
+				dc.OnOpen(func() { dc.Close() })
+				return
+			}
+			joinPipe(dc, report)
+		})
 
-	// Log the raw offer for debugging
-	logger.Debug("Raw offer: %s", string(offerJSON))
+		answer, err := server.AnswerOffer(pc, offer, true)
+		if err != nil {
+			pc.Close()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			report(err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(answer)
+	})
 
-	resp, err := http.Post(serverURL, "application/json", strings.NewReader(string(offerJSON)))
+	listener, err := net.Listen("tcp", pipeAddr)
 	if err != nil {
-		logger.Error("Failed to send offer: %v", err)
+		logger.Error("Failed to listen on %s: %v", pipeAddr, err)
 		os.Exit(1)
 	}
-	defer resp.Body.Close()
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go httpServer.Serve(listener)
+	logger.Info("Waiting for the other end of the pipe on %s", listener.Addr())
 
-	// Check HTTP status code
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		logger.Error("Server returned non-OK status: %d %s, body: %s",
-			resp.StatusCode, resp.Status, string(bodyBytes))
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-result:
+	case <-shutdown:
+	}
+	httpServer.Close()
+	mu.Lock()
+	shutDown = true
+	if peerConnection != nil {
+		peerConnection.Close()
+	}
+	mu.Unlock()
+	if err != nil {
+		logger.Error("Pipe failed: %v", err)
 		os.Exit(1)
 	}
+}
 
-	// Read the answer
-	answerJSON, err := io.ReadAll(resp.Body)
+// pipeOutcome returns a function reporting the outcome of a pipe and the
+// channel receiving it. Only the first outcome is kept, so the connection
+// failing and the pipe ending never block each other.
+func pipeOutcome() (func(error), <-chan error) {
+	result := make(chan error, 1)
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { result <- err })
+	}, result
+}
+
+// runPipeConnect connects to a listening pipe and joins it with stdin and
+// stdout
+func runPipeConnect() {
+	// stdout carries the pipe
+	logger.SetOutput(os.Stderr)
+
+	serverURL := viper.GetString("client.server")
+	if pipeServer != "" {
+		serverURL = pipeServer
+	}
+	iceServers, fallback, err := iceServersFor("client")
 	if err != nil {
-		logger.Error("Failed to read answer: %v", err)
+		logger.Error("Invalid ICE configuration: %v", err)
+		os.Exit(1)
+	}
+	if err := checkInterceptors("client"); err != nil {
+		logger.Error("Invalid interceptor configuration: %v", err)
 		os.Exit(1)
 	}
+	enableFIPS("client")
+	if fallback != nil {
+		iceServers = fallback.ICEServers()
+	}
 
-	// Log the raw response for debugging
-	logger.Debug("Raw server response: %s", string(answerJSON))
+	peerConnection, err := peer.NewAPI(iceServers, viper.GetStringSlice("client.interceptors")).NewPeerConnection(webrtc.Configuration{ICEServers: iceServers})
+	if err != nil {
+		logger.Error("Failed to create peer connection: %v", err)
+		os.Exit(1)
+	}
+	defer peerConnection.Close()
+	report, result := pipeOutcome()
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		logger.Info("Pipe connection state: %s", state)
+		if state == webrtc.PeerConnectionStateFailed {
+			report(errors.New("connection failed"))
+		}
+	})
 
-	// Parse the answer
-	var answer webrtc.SessionDescription
-	if err := json.Unmarshal(answerJSON, &answer); err != nil {
-		logger.Error("Failed to parse answer: %v, raw response: %s", err, string(answerJSON))
+	protocol := pipe.Protocol
+	dc, err := peerConnection.CreateDataChannel(pipe.Label, &webrtc.DataChannelInit{Protocol: &protocol})
+	if err != nil {
+		logger.Error("Failed to create pipe channel: %v", err)
 		os.Exit(1)
 	}
+	joinPipe(dc, report)
 
-	// Set the remote description
+	// Offer the connection with every candidate in it
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		logger.Error("Failed to create offer: %v", err)
+		os.Exit(1)
+	}
+	gathered := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		logger.Error("Failed to set local description: %v", err)
+		os.Exit(1)
+	}
+	<-gathered
+	answer, err := postPipeOffer(serverURL, *peerConnection.LocalDescription())
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
 	if err := peerConnection.SetRemoteDescription(answer); err != nil {
 		logger.Error("Failed to set remote description: %v", err)
 		os.Exit(1)
 	}
 
-	// Print the client's PID
-	fmt.Printf("CLIENT_PID=%d\n", os.Getpid())
-
-	// Create a channel to signal shutdown
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-result:
+	case <-shutdown:
+	}
+	if err != nil {
+		logger.Error("Pipe failed: %v", err)
+		peerConnection.Close()
+		os.Exit(1)
+	}
+}
+
+// postPipeOffer posts the offer of a pipe to the listening end and returns
+// its answer
+func postPipeOffer(serverURL string, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	body, err := json.Marshal(offer)
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to encode offer: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, serverURL, bytes.NewReader(body))
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to create offer request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := viper.GetString("client.auth_token"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to send offer: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to read answer: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return webrtc.SessionDescription{}, fmt.Errorf("the listening end returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	var answer webrtc.SessionDescription
+	if err := json.Unmarshal(data, &answer); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to parse answer: %w", err)
+	}
+	return answer, nil
+}
+
+// joinPipe runs a pipe between stdin, stdout and the peer over dc once it
+// opens, reporting the outcome once both ends finished or the channel closed
+func joinPipe(dc *webrtc.DataChannel, report func(error)) {
+	p := pipe.New(dc.Send)
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		p.Handle(msg.Data)
+	})
+	dc.OnClose(p.Close)
+	dc.OnOpen(func() {
+		logger.Info("Pipe open")
+		go func() {
+			sent, received, err := p.Run(os.Stdin, os.Stdout)
+			logger.Info("Pipe closed: sent %d bytes, received %d bytes", sent, received)
+			dc.Close()
+			report(err)
+		}()
+	})
+}
+
+// Failed mirror passes are retried after mirrorRetry, doubling up to
+// mirrorRetryMax; mirroring once gives up after mirrorAttempts passes
+const (
+	mirrorRetry    = 5 * time.Second
+	mirrorRetryMax = 5 * time.Minute
+	mirrorAttempts = 5
+)
 
-	// Open the output file if specified
-	var outputFile *os.File
-	if output != "" {
-		outputFile, err = os.Create(output)
+func runMirror() {
+	if mirrorDest == "" {
+		logger.Error("--dest is required")
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(mirrorDest, 0755); err != nil {
+		logger.Error("Failed to create destination directory: %v", err)
+		os.Exit(1)
+	}
+	policy, err := pathpolicy.New(mirrorDest, viper.GetBool("client.follow_symlinks"))
+	if err != nil {
+		logger.Error("Invalid destination directory: %v", err)
+		os.Exit(1)
+	}
+	var fetches []share.Fetch
+	for _, include := range mirrorInclude {
+		fetch, err := share.NewFetch(include, policy)
 		if err != nil {
-			logger.Error("Failed to create output file: %v", err)
+			logger.Error("%v", err)
 			os.Exit(1)
 		}
-		defer outputFile.Close()
-		logger.Info("Writing output to file: %s", output)
-	} else {
-		logger.Info("Writing output to stdout")
+		fetches = append(fetches, fetch)
 	}
 
-	// Start receiving data
-	go func() {
-		lineCount := 0
-		startTime := time.Now()
+	// Files are always requested through a chunk cache, the configured one
+	// or one in the user's cache directory. The connection offers checksum
+	// algorithms for it as for the client's own cache.
+	if viper.GetString("client.dedup_cache") == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			logger.Error("Failed to find a cache directory, set dedup_cache: %v", err)
+			os.Exit(1)
+		}
+		viper.Set("client.dedup_cache", filepath.Join(base, "webrtc-poc", "chunks"))
+	}
+	cfg, err := clientConfig()
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	if mirrorServer != "" {
+		cfg.ServerURL = mirrorServer
+	}
 
-		for line := range dataChan {
-			lineCount++
+	// Print the client's PID
+	fmt.Printf("CLIENT_PID=%d\n", os.Getpid())
 
-			if outputFile != nil {
-				fmt.Fprintln(outputFile, line)
-			} else {
-				fmt.Println(line)
-			}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-			logger.Debug("Received line %d: %s", lineCount, line)
+	logger.Info("Mirroring %s to %s", cfg.ServerURL, policy.Root())
+	delay := mirrorRetry
+	for attempt := 1; ctx.Err() == nil; attempt++ {
+		changed, err := mirrorPass(ctx, cfg, fetches)
+		if ctx.Err() != nil {
+			break
 		}
 
-		elapsed := time.Since(startTime)
-		logger.Info("Received %d lines in %v (%.2f lines/sec)",
-			lineCount, elapsed, float64(lineCount)/elapsed.Seconds())
-	}()
-
-	// Wait for shutdown signal
-	<-shutdown
-	logger.Info("Shutting down client...")
+		wait := mirrorInterval
+		if err != nil {
+			logger.Error("Mirror pass failed: %v", err)
+			if mirrorInterval == 0 && attempt >= mirrorAttempts {
+				logger.Error("Giving up after %d attempts", attempt)
+				os.Exit(1)
+			}
+			// Try the next public STUN server if connections fail
+			if cfg.Fallback != nil {
+				if server, ok := cfg.Fallback.Failed(); ok {
+					logger.Info("Retrying with public STUN server %s", server)
+					cfg.ICEServers = cfg.Fallback.ICEServers()
+				}
+			}
+			wait, delay = delay, min(delay*2, mirrorRetryMax)
+			logger.Info("Retrying in %v", wait)
+		} else {
+			logger.Info("Mirror pass done, %d files changed", changed)
+			if mirrorInterval == 0 {
+				return
+			}
+			attempt, delay = 0, mirrorRetry
+		}
 
-	// Close the peer connection
-	if err := peerConnection.Close(); err != nil {
-		logger.Error("Error closing peer connection: %v", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
 	}
-
-	logger.Info("Client shutdown complete")
+	logger.Info("Shutting down mirror...")
 }
 
-// streamFile streams a file line by line over a data channel
-func streamFile(dataChannel *webrtc.DataChannel, filename string, delayMs int) {
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Error("Recovered from panic in streamFile: %v", r)
+// mirrorPass connects to the server, fetches the files of fetches into
+// partial files through the cache of cfg and moves those that differ from the
+// local copies over them. It returns how many files changed.
+func mirrorPass(ctx context.Context, cfg client.Config, fetches []share.Fetch) (int, error) {
+	// The mirror does not use the file stream, so its lines are dropped
+	dataChan := make(chan string)
+	go func() {
+		for range dataChan {
+			metrics.ClientPendingLines.Dec()
 		}
 	}()
-
-	file, err := os.Open(filename)
+	failed := make(chan struct{}, 1)
+	peerConnection, err := client.Connect(ctx, cfg, dataChan, failed, client.Hooks{})
 	if err != nil {
-		logger.Error("Failed to open file: %v", err)
-		return
+		return 0, err
 	}
-	defer file.Close()
+	defer peerConnection.Close()
 
-	scanner := bufio.NewScanner(file)
-	lineCount := 0
+	type result struct {
+		targets  []string
+		outcomes []share.Outcome
+		listed   int
+	}
+	done := make(chan result, 1)
+	go func() {
+		// Expand the patterns here, so every file is written to a partial
+		// file next to its target
+		var r result
+		var partials []share.Fetch
+		for _, fetch := range fetches {
+			matches := []share.Fetch{fetch}
+			if share.IsPattern(fetch.Name) {
+				var err error
+				if matches, err = client.ListFiles(peerConnection, fetch, cfg.FollowSymlinks); err != nil {
+					logger.Error("Failed to list %s: %v", fetch.Name, err)
+					r.listed++
+					continue
+				}
+			}
+			for _, match := range matches {
+				r.targets = append(r.targets, match.Output)
+				match.Output = filepath.Join(filepath.Dir(match.Output), "."+filepath.Base(match.Output)+".part")
+				partials = append(partials, match)
+			}
+		}
+		r.outcomes = client.FetchFiles(peerConnection, partials, cfg.FetchParallel, cfg.Cache, cfg.FollowSymlinks)
+		done <- r
+	}()
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineCount++
+	var r result
+	select {
+	case r = <-done:
+	case <-failed:
+		return 0, errors.New("connection to the server failed")
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
 
-		// Send the line over the data channel
-		if err := dataChannel.SendText(line); err != nil {
-			logger.Error("Failed to send line %d: %v", lineCount, err)
-			return
+	changed, failures := 0, r.listed+share.Failed(r.outcomes)
+	for i, o := range r.outcomes {
+		if o.Err != nil {
+			continue
+		}
+		same, err := sameContent(o.Output, r.targets[i])
+		if err != nil {
+			logger.Error("Failed to compare %s: %v", r.targets[i], err)
+		}
+		if same || err != nil {
+			os.Remove(o.Output)
+			if err != nil {
+				failures++
+			}
+			continue
+		}
+		if err := os.Rename(o.Output, r.targets[i]); err != nil {
+			logger.Error("Failed to replace %s: %v", r.targets[i], err)
+			os.Remove(o.Output)
+			failures++
+			continue
 		}
+		logger.Info("Updated %s", r.targets[i])
+		changed++
+	}
+	if failures > 0 {
+		return changed, fmt.Errorf("%d of %d files failed", failures, len(r.outcomes)+r.listed)
+	}
+	return changed, nil
+}
 
-		logger.Debug("Sent line %d: %s", lineCount, line)
+// sameContent reports whether two files hold the same bytes; a missing file b
+// differs from any file a
+func sameContent(a, b string) (bool, error) {
+	fb, err := os.Open(b)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
 
-		// Delay between lines
-		time.Sleep(time.Duration(delayMs) * time.Millisecond)
+	ia, err := fa.Stat()
+	if err != nil {
+		return false, err
+	}
+	ib, err := fb.Stat()
+	if err != nil {
+		return false, err
+	}
+	if ia.Size() != ib.Size() {
+		return false, nil
 	}
 
-	if err := scanner.Err(); err != nil {
-		logger.Error("Error reading file: %v", err)
+	bufA, bufB := make([]byte, 64<<10), make([]byte, 64<<10)
+	for {
+		n, err := io.ReadFull(fa, bufA)
+		if n > 0 {
+			if _, err := io.ReadFull(fb, bufB[:n]); err != nil {
+				return false, err
+			}
+			if !bytes.Equal(bufA[:n], bufB[:n]) {
+				return false, nil
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
 	}
+}
 
-	logger.Info("Finished streaming file, sent %d lines", lineCount)
+// forwarderFor returns the forwarder for the socket configured in the given
+// section ("server" or "client"), or nil if none is
+func forwarderFor(section string) (*forward.Forwarder, error) {
+	spec := viper.GetString(section + ".forward")
+	if spec == "" {
+		return nil, nil
+	}
+	endpoint, err := forward.ParseEndpoint(spec)
+	if err != nil {
+		return nil, err
+	}
+	return forward.NewForwarder(endpoint)
 }
 
 func main() {
@@ -0,0 +1,58 @@
+// Command channelreceiver receives a stream into a channel that the rest of
+// the program consumes at its own pace, here counting the lines and words of
+// the file. A consumer that falls behind holds the stream back once the
+// channel is full instead of losing lines.
+//
+//	go run ./examples/channelreceiver -url http://localhost:8080/offer
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/developmeh/webrtc-poc/pkg/receiver"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:8080/offer", "offer endpoint of the sender")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The channel is closed once the stream ended, with its result and
+	// error set
+	lines := make(chan string, 64)
+	var result receiver.Result
+	var err error
+	go func() {
+		defer close(lines)
+		result, err = receiver.Receive(ctx, receiver.Options{
+			URL:    *url,
+			Verify: true,
+			OnLine: func(line string) error {
+				select {
+				case lines <- line:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			},
+		})
+	}()
+
+	count, words := 0, 0
+	for line := range lines {
+		count++
+		words += len(strings.Fields(line))
+	}
+	if err != nil {
+		log.Fatalf("Receive failed after %d lines: %v", count, err)
+	}
+	log.Printf("Received %d lines and %d words in %s, digest verified: %v", count, words, result.Duration, result.Verified)
+}
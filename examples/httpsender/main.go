@@ -0,0 +1,91 @@
+// Command httpsender embeds a sender in an HTTP service of its own: the
+// service keeps its routes and mounts the sender's offer handler next to
+// them, so receivers fetch the file from the address it already serves.
+// /status lists the transfers so far.
+//
+//	go run ./examples/httpsender -file app.log
+//	go run ./examples/channelreceiver -url http://localhost:8080/offer
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/developmeh/webrtc-poc/pkg/sender"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	file := flag.String("file", "", "file to stream to every receiver")
+	flag.Parse()
+	if *file == "" {
+		log.Fatal("no -file to stream")
+	}
+
+	// Interrupting the service closes the connections of the transfers
+	// still running
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var mu sync.Mutex
+	transfers := make(map[string]sender.Progress)
+	s, err := sender.New(sender.Options{
+		File: *file,
+		OnProgress: func(p sender.Progress) {
+			mu.Lock()
+			transfers[p.ID] = p
+			mu.Unlock()
+			switch {
+			case p.Done && p.Err != nil:
+				log.Printf("Transfer %s failed: %v", p.ID, p.Err)
+			case p.Done:
+				log.Printf("Transfer %s done: %d of %d lines received", p.ID, p.Received, p.Lines)
+			}
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/offer", s.Handler(ctx))
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids := make([]string, 0, len(transfers))
+		for id := range transfers {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			p := transfers[id]
+			state := "running"
+			if p.Err != nil {
+				state = "failed: " + p.Err.Error()
+			} else if p.Done {
+				state = "done"
+			}
+			fmt.Fprintf(w, "%s\t%d lines\t%d bytes\t%s\n", id, p.Lines, p.Bytes, state)
+		}
+	})
+
+	server := &http.Server{Addr: *addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	log.Printf("Serving %s at http://%s/offer", *file, *addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	s.Wait()
+}
@@ -0,0 +1,60 @@
+package autostun
+
+import (
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// DefaultServers is the list of well-known public STUN servers used when
+// direct connections fail and automatic STUN fallback is enabled
+var DefaultServers = []string{
+	"stun:stun.l.google.com:19302",
+	"stun:stun1.l.google.com:19302",
+	"stun:stun.cloudflare.com:3478",
+	"stun:global.stun.twilio.com:3478",
+}
+
+// Fallback decides which ICE servers to use for the next connection attempt.
+// Connections start out direct (no ICE servers); every failure advances to
+// the next public STUN server in the list.
+type Fallback struct {
+	mu      sync.Mutex
+	servers []string
+	index   int
+}
+
+// NewFallback creates a fallback that rotates through the given servers
+func NewFallback(servers []string) *Fallback {
+	return &Fallback{servers: servers, index: -1}
+}
+
+// ICEServers returns the ICE servers for the next connection attempt
+func (f *Fallback) ICEServers() []webrtc.ICEServer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.index < 0 || len(f.servers) == 0 {
+		return nil
+	}
+	return []webrtc.ICEServer{{URLs: []string{f.servers[f.index]}}}
+}
+
+// Failed records a failed connection attempt and rotates to the next public
+// STUN server. It returns the server that will be used next and false once
+// every server has been tried, after which the rotation starts over.
+func (f *Fallback) Failed() (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.servers) == 0 {
+		return "", false
+	}
+
+	f.index++
+	exhausted := f.index >= len(f.servers)
+	if exhausted {
+		f.index = 0
+	}
+	return f.servers[f.index], !exhausted
+}
@@ -0,0 +1,47 @@
+package autostun
+
+import (
+	"testing"
+)
+
+func TestFallback(t *testing.T) {
+	f := NewFallback([]string{"stun:a.example.com", "stun:b.example.com"})
+
+	// Direct connection first
+	if servers := f.ICEServers(); len(servers) != 0 {
+		t.Errorf("Expected no ICE servers before a failure, got %v", servers)
+	}
+
+	// First failure switches to the first public server
+	server, ok := f.Failed()
+	if !ok || server != "stun:a.example.com" {
+		t.Errorf("Expected stun:a.example.com, got %s (ok=%v)", server, ok)
+	}
+	servers := f.ICEServers()
+	if len(servers) != 1 || servers[0].URLs[0] != "stun:a.example.com" {
+		t.Errorf("Expected stun:a.example.com to be used, got %v", servers)
+	}
+
+	// Second failure rotates to the next server
+	server, ok = f.Failed()
+	if !ok || server != "stun:b.example.com" {
+		t.Errorf("Expected stun:b.example.com, got %s (ok=%v)", server, ok)
+	}
+
+	// Third failure wraps around and reports the list as exhausted
+	server, ok = f.Failed()
+	if ok || server != "stun:a.example.com" {
+		t.Errorf("Expected rotation to wrap to stun:a.example.com, got %s (ok=%v)", server, ok)
+	}
+}
+
+func TestFallbackWithoutServers(t *testing.T) {
+	f := NewFallback(nil)
+
+	if _, ok := f.Failed(); ok {
+		t.Error("Expected Failed to report no servers")
+	}
+	if servers := f.ICEServers(); len(servers) != 0 {
+		t.Errorf("Expected no ICE servers, got %v", servers)
+	}
+}
@@ -0,0 +1,119 @@
+// Package batch coalesces the lines of the file stream into fewer data
+// channel messages. Sending every line in a message of its own costs an SCTP
+// message, a send call and a callback on the client per line, which for
+// files of many short lines caps the throughput well below what the
+// connection carries. A batch is its lines, each preceded by its length as
+// a uvarint so a line may hold any byte, and is sent as a control Batch
+// frame, so it cannot be mistaken for a line or a shard and only reaches
+// clients that asked for batches.
+package batch
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+)
+
+// MaxBytes is the largest byte budget of a batch, leaving room for the frame
+// header within the 65535 byte message limit
+const MaxBytes = 65000
+
+var (
+	// batchesTotal counts the batches sent
+	batchesTotal = metrics.NewCounter("webrtc_poc_batches_total",
+		"Batches of several lines sent in one message")
+	// batchedLinesTotal counts the lines sent in batches
+	batchedLinesTotal = metrics.NewCounter("webrtc_poc_batched_lines_total",
+		"Lines sent in batches of several lines")
+)
+
+// Limits bound a batch
+type Limits struct {
+	// Lines is the most lines of a batch; batching is off below 2
+	Lines int
+	// Bytes is the most bytes of a batch, including the lengths of its
+	// lines
+	Bytes int
+}
+
+// Enabled reports whether the limits let more than one line into a batch
+func (l Limits) Enabled() bool {
+	return l.Lines > 1 && l.Bytes > 0
+}
+
+// Batcher collects lines until a batch is full
+type Batcher struct {
+	limits Limits
+	lines  []string
+	size   int
+}
+
+// New creates a batcher of batches within limits
+func New(limits Limits) *Batcher {
+	return &Batcher{limits: limits}
+}
+
+// Fits reports whether line can join the batch without going over its
+// limits. A line that does not fit an empty batch is sent on its own.
+func (b *Batcher) Fits(line string) bool {
+	return len(b.lines) < b.limits.Lines && b.size+encodedLen(line) <= b.limits.Bytes
+}
+
+// Add adds line to the batch, which must fit it, and reports whether the
+// batch is full
+func (b *Batcher) Add(line string) bool {
+	b.lines = append(b.lines, line)
+	b.size += encodedLen(line)
+	return len(b.lines) >= b.limits.Lines
+}
+
+// Len returns the number of lines in the batch
+func (b *Batcher) Len() int {
+	return len(b.lines)
+}
+
+// Take returns the lines of the batch and starts a new one
+func (b *Batcher) Take() []string {
+	lines := b.lines
+	b.lines, b.size = nil, 0
+	if len(lines) > 1 {
+		batchesTotal.Inc()
+		batchedLinesTotal.Add(int64(len(lines)))
+	}
+	return lines
+}
+
+// ErrMalformed is returned for a payload that is not a batch of lines
+var ErrMalformed = errors.New("malformed batch")
+
+// encodedLen returns the bytes line takes in a batch
+func encodedLen(line string) int {
+	var length [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(length[:], uint64(len(line))) + len(line)
+}
+
+// Encode returns the payload of a batch of lines
+func Encode(lines []string) []byte {
+	var payload []byte
+	for _, line := range lines {
+		payload = binary.AppendUvarint(payload, uint64(len(line)))
+		payload = append(payload, line...)
+	}
+	return payload
+}
+
+// Decode returns the lines of a batch's payload
+func Decode(payload []byte) ([]string, error) {
+	var lines []string
+	for len(payload) > 0 {
+		length, n := binary.Uvarint(payload)
+		if n <= 0 || length > uint64(len(payload)-n) {
+			return nil, ErrMalformed
+		}
+		payload = payload[n:]
+		lines = append(lines, string(payload[:length]))
+		payload = payload[length:]
+	}
+	return lines, nil
+}
@@ -0,0 +1,89 @@
+package batch
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBatcher(t *testing.T) {
+	t.Run("Lines", func(t *testing.T) {
+		b := New(Limits{Lines: 3, Bytes: MaxBytes})
+		for i, line := range []string{"a", "b"} {
+			if !b.Fits(line) || b.Add(line) {
+				t.Fatalf("Expected line %d to fit a batch that is not full", i+1)
+			}
+		}
+		if !b.Add("c") || b.Fits("d") {
+			t.Error("Expected the batch to be full at 3 lines")
+		}
+		batches, lines := batchesTotal.Value(), batchedLinesTotal.Value()
+		if got := b.Take(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) || b.Len() != 0 {
+			t.Errorf("Expected the 3 lines and an empty batch, got %q and %d", got, b.Len())
+		}
+		if batchesTotal.Value()-batches != 1 || batchedLinesTotal.Value()-lines != 3 {
+			t.Error("Expected the batch and its lines to be counted")
+		}
+		if !b.Fits("d") {
+			t.Error("Expected a new batch after Take")
+		}
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		// The length of every line counts towards the budget
+		b := New(Limits{Lines: 100, Bytes: 8})
+		b.Add("abc")
+		if !b.Fits("abc") || b.Fits("abcd") {
+			t.Error("Expected the budget to fit 2 lines of 3 bytes and their lengths")
+		}
+		b.Take()
+		if b.Fits("abcdefgh") {
+			t.Error("Expected a line over the budget not to fit an empty batch")
+		}
+		batches := batchesTotal.Value()
+		b.Add("")
+		b.Take()
+		if batchesTotal.Value() != batches {
+			t.Error("Expected a single line not to count as a batch")
+		}
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		for limits, want := range map[Limits]bool{
+			{Lines: 2, Bytes: 1}:        true,
+			{Lines: 1, Bytes: MaxBytes}: false,
+			{Lines: 0, Bytes: MaxBytes}: false,
+			{Lines: 10, Bytes: 0}:       false,
+		} {
+			if limits.Enabled() != want {
+				t.Errorf("Expected %+v enabled to be %v", limits, want)
+			}
+		}
+	})
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, lines := range [][]string{
+		{"a", "b", "c"},
+		{"", "middle", ""},
+		{"tabs\tand spaces", "\x1e{\"ts\":1,\"line\":\"stamped\"}"},
+		{"a record\nof two lines", "\n", "crlf\r\n", "\x00"},
+		{strings.Repeat("x", 300), "after"},
+	} {
+		got, err := Decode(Encode(lines))
+		if err != nil {
+			t.Fatalf("Decode returned error: %v", err)
+		}
+		if !reflect.DeepEqual(got, lines) {
+			t.Errorf("Expected %q back, got %q", lines, got)
+		}
+	}
+	if got := string(Encode([]string{"x", "y"})); got != "\x01x\x01y" {
+		t.Errorf("Expected every line preceded by its length, got %q", got)
+	}
+	for _, payload := range []string{"\x05abc", "\x80", "\x01x\x02y"} {
+		if _, err := Decode([]byte(payload)); err != ErrMalformed {
+			t.Errorf("Expected ErrMalformed for %q, got %v", payload, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Package broadcast reads a stream once and fans its lines out to every
+// subscriber in lockstep: each line is handed to all subscribers before the
+// next one is read, so they all receive the same lines at the same pace
+// instead of each reading the stream on its own. A round of the broadcast
+// starts when the first subscriber joins and ends with the stream, or once
+// every subscriber left; subscribers joining while a round runs receive its
+// lines from then on.
+package broadcast
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLeft is returned by Join when the subscriber left before the round
+// ended
+var ErrLeft = errors.New("subscriber left the broadcast")
+
+// errIdle stops a round that has no subscribers left
+var errIdle = errors.New("no subscribers left")
+
+// Line is a line of the broadcast
+type Line struct {
+	Text string
+	// Oversized is set if the line was longer than the largest record and
+	// cut
+	Oversized bool
+}
+
+// Source reads one round of the broadcast, calling emit with every line in
+// order at the pace the lines are sent. It stops with the error emit
+// returns.
+type Source func(emit func(Line) error) error
+
+// subscriber receives the lines of a round
+type subscriber struct {
+	send func(Line) error
+	done chan error
+}
+
+// Hub runs the rounds of a broadcast for its subscribers
+type Hub struct {
+	source Source
+
+	mu      sync.Mutex
+	subs    map[*subscriber]struct{}
+	running bool
+	rounds  int
+}
+
+// New creates a hub broadcasting what source reads
+func New(source Source) *Hub {
+	return &Hub{source: source, subs: make(map[*subscriber]struct{})}
+}
+
+// Join subscribes send to the broadcast, starting a round if none runs, and
+// waits until the round ended, send failed or leave is closed. It returns
+// the error the round ended with, nil once the stream was read to the end,
+// the error of send, or ErrLeft.
+func (h *Hub) Join(send func(Line) error, leave <-chan struct{}) error {
+	s := &subscriber{send: send, done: make(chan error, 1)}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	if !h.running {
+		h.running = true
+		h.rounds++
+		go h.run()
+	}
+	h.mu.Unlock()
+
+	select {
+	case err := <-s.done:
+		return err
+	case <-leave:
+	}
+
+	// The round may have ended while leaving
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; !ok {
+		return <-s.done
+	}
+	delete(h.subs, s)
+	return ErrLeft
+}
+
+// Subscribers returns the number of subscribers of the current round
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Rounds returns the number of rounds started
+func (h *Hub) Rounds() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.rounds
+}
+
+// run reads a round and ends it for its subscribers, starting the next one
+// right away for those that joined as it ran out of subscribers
+func (h *Hub) run() {
+	for {
+		err := h.source(h.emit)
+
+		h.mu.Lock()
+		if errors.Is(err, errIdle) && len(h.subs) > 0 {
+			h.rounds++
+			h.mu.Unlock()
+			continue
+		}
+		for s := range h.subs {
+			s.done <- err
+			delete(h.subs, s)
+		}
+		h.running = false
+		h.mu.Unlock()
+		return
+	}
+}
+
+// emit hands line to every subscriber, dropping those whose send fails
+func (h *Hub) emit(line Line) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if err := s.send(line); err != nil {
+			s.done <- err
+			delete(h.subs, s)
+		}
+	}
+	if len(h.subs) == 0 {
+		return errIdle
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package broadcast
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedSource emits its lines one at a time, each once the test lets it
+type gatedSource struct {
+	lines []string
+	next  chan struct{}
+	err   error
+	// ended receives the error every round ended with
+	ended chan error
+}
+
+func newGatedSource(n int) *gatedSource {
+	s := &gatedSource{next: make(chan struct{}), ended: make(chan error, 10)}
+	for i := 1; i <= n; i++ {
+		s.lines = append(s.lines, fmt.Sprintf("line %d", i))
+	}
+	return s
+}
+
+func (s *gatedSource) read(emit func(Line) error) (err error) {
+	defer func() { s.ended <- err }()
+	for _, line := range s.lines {
+		<-s.next
+		if err := emit(Line{Text: line}); err != nil {
+			return err
+		}
+	}
+	<-s.next
+	return s.err
+}
+
+// recorder collects the lines a subscriber received
+type recorder struct {
+	mu    sync.Mutex
+	lines []string
+	fail  error
+}
+
+func (r *recorder) send(l Line) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail != nil {
+		return r.fail
+	}
+	r.lines = append(r.lines, l.Text)
+	return nil
+}
+
+func (r *recorder) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.lines...)
+}
+
+// join joins hub with r in the background, returning the channel its
+// result arrives on
+func join(hub *Hub, r *recorder, leave <-chan struct{}) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- hub.Join(r.send, leave) }()
+	return done
+}
+
+// waitSubscribers waits until hub has n subscribers
+func waitSubscribers(t *testing.T, hub *Hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for hub.Subscribers() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d subscribers, got %d", n, hub.Subscribers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func result(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("Expected Join to return")
+		return nil
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBroadcast(t *testing.T) {
+	src := newGatedSource(4)
+	hub := New(src.read)
+
+	first, second := &recorder{}, &recorder{}
+	firstDone := join(hub, first, nil)
+	waitSubscribers(t, hub, 1)
+	src.next <- struct{}{}
+	src.next <- struct{}{}
+	waitLines(t, first, 2)
+
+	// A subscriber joining the running round gets its lines from then on
+	secondDone := join(hub, second, nil)
+	waitSubscribers(t, hub, 2)
+	src.next <- struct{}{}
+	src.next <- struct{}{}
+	src.next <- struct{}{}
+
+	if err := result(t, firstDone); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := result(t, secondDone); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if got := first.received(); !equal(got, []string{"line 1", "line 2", "line 3", "line 4"}) {
+		t.Errorf("First subscriber received %q", got)
+	}
+	if got := second.received(); !equal(got, []string{"line 3", "line 4"}) {
+		t.Errorf("Second subscriber received %q", got)
+	}
+	if hub.Rounds() != 1 {
+		t.Errorf("Expected 1 round, got %d", hub.Rounds())
+	}
+}
+
+func TestBroadcastSendFails(t *testing.T) {
+	src := newGatedSource(3)
+	hub := New(src.read)
+
+	broken := errors.New("channel closed")
+	healthy, failing := &recorder{}, &recorder{fail: broken}
+	healthyDone := join(hub, healthy, nil)
+	failingDone := join(hub, failing, nil)
+	waitSubscribers(t, hub, 2)
+
+	src.next <- struct{}{}
+	if err := result(t, failingDone); !errors.Is(err, broken) {
+		t.Errorf("Expected the send error, got %v", err)
+	}
+	src.next <- struct{}{}
+	src.next <- struct{}{}
+	src.next <- struct{}{}
+	if err := result(t, healthyDone); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if got := healthy.received(); len(got) != 3 {
+		t.Errorf("Expected the other subscriber to receive 3 lines, got %q", got)
+	}
+}
+
+func TestBroadcastLeave(t *testing.T) {
+	src := newGatedSource(3)
+	hub := New(src.read)
+
+	r := &recorder{}
+	leave := make(chan struct{})
+	done := join(hub, r, leave)
+	waitSubscribers(t, hub, 1)
+	src.next <- struct{}{}
+	waitLines(t, r, 1)
+
+	close(leave)
+	if err := result(t, done); !errors.Is(err, ErrLeft) {
+		t.Errorf("Expected ErrLeft, got %v", err)
+	}
+	if hub.Subscribers() != 0 {
+		t.Errorf("Expected no subscribers, got %d", hub.Subscribers())
+	}
+
+	// The round stops at the next line, without anyone to send it to, and
+	// the next subscriber starts another one
+	src.next <- struct{}{}
+	select {
+	case err := <-src.ended:
+		if !errors.Is(err, errIdle) {
+			t.Errorf("Expected the round to stop idle, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the round to stop")
+	}
+	waitIdle(t, hub)
+	next := &recorder{}
+	nextDone := join(hub, next, nil)
+	waitSubscribers(t, hub, 1)
+	for range 4 {
+		src.next <- struct{}{}
+	}
+	if err := result(t, nextDone); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if got := next.received(); !equal(got, []string{"line 1", "line 2", "line 3"}) {
+		t.Errorf("Expected the next round from the start, got %q", got)
+	}
+	if hub.Rounds() != 2 {
+		t.Errorf("Expected 2 rounds, got %d", hub.Rounds())
+	}
+}
+
+func TestBroadcastError(t *testing.T) {
+	src := newGatedSource(1)
+	src.err = errors.New("read failed")
+	hub := New(src.read)
+
+	a, b := &recorder{}, &recorder{}
+	aDone, bDone := join(hub, a, nil), join(hub, b, nil)
+	waitSubscribers(t, hub, 2)
+	src.next <- struct{}{}
+	src.next <- struct{}{}
+
+	for _, done := range []<-chan error{aDone, bDone} {
+		if err := result(t, done); err == nil || err.Error() != "read failed" {
+			t.Errorf("Expected the read error, got %v", err)
+		}
+	}
+}
+
+// waitIdle waits until hub runs no round
+func waitIdle(t *testing.T, hub *Hub) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		hub.mu.Lock()
+		running := hub.running
+		hub.mu.Unlock()
+		if !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the round to end")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitLines waits until r received n lines
+func waitLines(t *testing.T, r *recorder, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(r.received()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d lines, got %d", n, len(r.received()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,184 @@
+// Package bundle streams the files of a directory or glob pattern over the
+// file stream as one transfer. The server sends a Manifest listing the files,
+// in the order they follow, then the lines of every file between a begin and
+// an end marker, and the client recreates the files below its output
+// directory from them. File names are slash separated paths relative to the
+// directory, or to the directory a pattern starts in, and are held to the
+// client's output directory like the paths of requested files.
+package bundle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/developmeh/webrtc-poc/internal/pathpolicy"
+	"github.com/developmeh/webrtc-poc/internal/share"
+)
+
+// ErrEmpty is returned when a directory or pattern names no files
+var ErrEmpty = errors.New("no files to stream")
+
+// File is one file of a bundle
+type File struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Path is where the server reads the file from
+	Path string `json:"-"`
+}
+
+// Manifest lists the files of a bundle in the order they are streamed
+type Manifest struct {
+	Files []File `json:"files"`
+}
+
+// Encode returns the message carrying the manifest
+func (m Manifest) Encode() []byte {
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// DecodeManifest parses a manifest message
+func DecodeManifest(data []byte) (Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("error parsing bundle manifest: %w", err)
+	}
+	return m, nil
+}
+
+// IsBundle reports whether spec names a directory or is a glob pattern
+func IsBundle(spec string) bool {
+	if share.IsPattern(spec) {
+		return true
+	}
+	info, err := os.Stat(spec)
+	return err == nil && info.IsDir()
+}
+
+// List returns the regular files below a directory, or those matching a glob
+// pattern, sorted by name. Symlinks to files are listed as the files, symlinks
+// to directories are not descended into.
+func List(spec string) ([]File, error) {
+	var files []File
+	add := func(base, path string) error {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Name: filepath.ToSlash(rel), Size: info.Size(), Path: path})
+		return nil
+	}
+
+	if share.IsPattern(spec) {
+		matches, err := filepath.Glob(spec)
+		if err != nil {
+			return nil, err
+		}
+		base := Base(spec)
+		for _, match := range matches {
+			if err := add(base, match); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		err := filepath.WalkDir(spec, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			return add(spec, path)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEmpty, spec)
+	}
+	slices.SortFunc(files, func(a, b File) int { return strings.Compare(a.Name, b.Name) })
+	return files, nil
+}
+
+// Base returns the directory a glob pattern starts in: its leading elements
+// without pattern characters
+func Base(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for share.IsPattern(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// Writer recreates the files of a manifest below a directory, one at a time
+type Writer struct {
+	paths []string
+	file  *os.File
+}
+
+// NewWriter checks that every file of the manifest stays inside dir and
+// prepares writing them there
+func NewWriter(dir string, followSymlinks bool, manifest Manifest) (*Writer, error) {
+	policy, err := pathpolicy.New(dir, followSymlinks)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{paths: make([]string, len(manifest.Files))}
+	for i, f := range manifest.Files {
+		if w.paths[i], err = share.Resolve(policy, f.Name); err != nil {
+			return nil, fmt.Errorf("cannot write %s: %w", f.Name, err)
+		}
+	}
+	return w, nil
+}
+
+// Begin closes the current file and creates file i, with the directories
+// leading to it
+func (w *Writer) Begin(i int) (string, error) {
+	if err := w.End(); err != nil {
+		return "", err
+	}
+	if i < 0 || i >= len(w.paths) {
+		return "", fmt.Errorf("file %d is not in the manifest of %d files", i, len(w.paths))
+	}
+	path := w.paths[i]
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("error creating output directory: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create output file: %w", err)
+	}
+	w.file = file
+	return path, nil
+}
+
+// Write writes to the current file
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.file == nil {
+		return 0, errors.New("no file of the bundle begun")
+	}
+	return w.file.Write(p)
+}
+
+// End closes the current file, if one is open
+func (w *Writer) End() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,142 @@
+package bundle
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/developmeh/webrtc-poc/internal/share"
+)
+
+// writeFiles creates files below dir from slash separated names
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// names returns the names of files
+func names(files []File) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"b.log":         "b\n",
+		"a.txt":         "a\n",
+		"logs/c.log":    "c\n",
+		"logs/deep/d.l": "dd\n",
+	})
+	if err := os.Symlink(filepath.Join(dir, "a.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Directory", func(t *testing.T) {
+		if !IsBundle(dir) {
+			t.Error("Expected a directory to be a bundle")
+		}
+		files, err := List(dir)
+		if err != nil {
+			t.Fatalf("List returned error: %v", err)
+		}
+		want := []string{"a.txt", "b.log", "link.txt", "logs/c.log", "logs/deep/d.l"}
+		if got := names(files); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+		if files[4].Size != 3 || files[4].Path != filepath.Join(dir, "logs", "deep", "d.l") {
+			t.Errorf("Expected the size and path of the file, got %+v", files[4])
+		}
+	})
+
+	t.Run("Pattern", func(t *testing.T) {
+		files, err := List(filepath.Join(dir, "*", "*.log"))
+		if err != nil {
+			t.Fatalf("List returned error: %v", err)
+		}
+		if got, want := names(files), []string{"logs/c.log"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+
+		files, err = List(filepath.Join(dir, "*.txt"))
+		if err != nil {
+			t.Fatalf("List returned error: %v", err)
+		}
+		if got, want := names(files), []string{"a.txt", "link.txt"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if _, err := List(filepath.Join(dir, "*.none")); !errors.Is(err, ErrEmpty) {
+			t.Errorf("Expected ErrEmpty, got %v", err)
+		}
+		if IsBundle(filepath.Join(dir, "a.txt")) {
+			t.Error("Expected a single file not to be a bundle")
+		}
+	})
+}
+
+func TestBase(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"*.log":             ".",
+		"logs/*.log":        "logs",
+		"/srv/logs/*/a.log": "/srv/logs",
+		"/srv/l?gs/*/a.log": "/srv",
+	} {
+		if got := Base(filepath.FromSlash(pattern)); got != filepath.FromSlash(want) {
+			t.Errorf("Expected %s for %s, got %s", want, pattern, got)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	dir := t.TempDir()
+	manifest, err := DecodeManifest(Manifest{Files: []File{{Name: "a.txt", Size: 2}, {Name: "logs/b.log", Size: 4}}}.Encode())
+	if err != nil {
+		t.Fatalf("DecodeManifest returned error: %v", err)
+	}
+	w, err := NewWriter(dir, false, manifest)
+	if err != nil {
+		t.Fatalf("NewWriter returned error: %v", err)
+	}
+	if _, err := w.Write([]byte("x\n")); err == nil {
+		t.Error("Expected writing before the first file to fail")
+	}
+	for i, content := range []string{"a\n", "b\nb\n"} {
+		if _, err := w.Begin(i); err != nil {
+			t.Fatalf("Begin returned error: %v", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+	}
+	if err := w.End(); err != nil {
+		t.Fatalf("End returned error: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "logs", "b.log")); err != nil || string(data) != "b\nb\n" {
+		t.Errorf("Expected the second file to be recreated, got %q, %v", data, err)
+	}
+	if _, err := w.Begin(2); err == nil {
+		t.Error("Expected a file outside the manifest to be refused")
+	}
+
+	// The server names the files, so they must stay inside the directory
+	_, err = NewWriter(dir, false, Manifest{Files: []File{{Name: "../escape.txt"}}})
+	if !errors.Is(err, share.ErrOutsideRoot) {
+		t.Errorf("Expected ErrOutsideRoot, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Package checksum names the hash algorithms chunks can be identified by and
+// picks the one both peers of a connection support. SHA-256 is the default
+// and the only algorithm in FIPS approved mode; BLAKE3 and the 128-bit XXH3
+// are much faster, which matters once hashing rather than the network limits
+// a transfer. XXH3 is not cryptographic: it detects corruption, not a peer
+// forging chunks, so it suits trusted networks only.
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+	"strings"
+
+	"github.com/developmeh/webrtc-poc/internal/fips"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+const (
+	// SHA256 is SHA-256, the default
+	SHA256 = "sha256"
+	// BLAKE3 is the 256-bit BLAKE3
+	BLAKE3 = "blake3"
+	// XXH3 is the 128-bit XXH3
+	XXH3 = "xxh3"
+)
+
+// ErrUnknown is returned for algorithms this build does not implement
+var ErrUnknown = errors.New("unknown checksum algorithm")
+
+// Algorithms returns the algorithms that may be used, fastest first
+func Algorithms() []string {
+	if fips.Enabled() {
+		return []string{SHA256}
+	}
+	return []string{XXH3, BLAKE3, SHA256}
+}
+
+// New returns a hash computing the algorithm name; an empty name is SHA-256
+func New(name string) (hash.Hash, error) {
+	switch name {
+	case SHA256, "":
+		return sha256.New(), nil
+	case BLAKE3:
+		if fips.Enabled() {
+			return nil, fmt.Errorf("checksum algorithm %s is not allowed in FIPS approved mode", name)
+		}
+		return blake3.New(), nil
+	case XXH3:
+		if fips.Enabled() {
+			return nil, fmt.Errorf("checksum algorithm %s is not allowed in FIPS approved mode", name)
+		}
+		return &xxh3128{xxh3.New()}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknown, name)
+}
+
+// Sum returns the hex encoded checksum of data
+func Sum(name string, data []byte) (string, error) {
+	h, err := New(name)
+	if err != nil {
+		return "", err
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Parse validates a list of algorithm names, in order of preference
+func Parse(names []string) ([]string, error) {
+	var parsed []string
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || slices.Contains(parsed, name) {
+			continue
+		}
+		if _, err := New(name); err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, name)
+	}
+	return parsed, nil
+}
+
+// Negotiate picks the first of the offered algorithms that is also allowed,
+// falling back to SHA-256, which every peer supports
+func Negotiate(offered, allowed []string) string {
+	for _, name := range offered {
+		if slices.Contains(allowed, name) {
+			return name
+		}
+	}
+	return SHA256
+}
+
+// xxh3128 makes the XXH3 hasher sum to its 128-bit value instead of the
+// 64-bit one
+type xxh3128 struct {
+	*xxh3.Hasher
+}
+
+func (h *xxh3128) Size() int { return 16 }
+
+func (h *xxh3128) Sum(b []byte) []byte {
+	sum := h.Sum128().Bytes()
+	return append(b, sum[:]...)
+}
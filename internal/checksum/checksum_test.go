@@ -0,0 +1,63 @@
+package checksum
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/developmeh/webrtc-poc/internal/fips"
+)
+
+func TestSum(t *testing.T) {
+	// Known answers for "abc"
+	for _, tc := range []struct{ name, sum string }{
+		{SHA256, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{BLAKE3, "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+		{XXH3, "06b05ab6733a618578af5f94892f3950"},
+	} {
+		sum, err := Sum(tc.name, []byte("abc"))
+		if err != nil || sum != tc.sum {
+			t.Errorf("Sum(%q) = %s, %v, expected %s", tc.name, sum, err, tc.sum)
+		}
+	}
+	if _, err := Sum("md5", nil); !errors.Is(err, ErrUnknown) {
+		t.Errorf("Expected ErrUnknown, got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	parsed, err := Parse([]string{"XXH3", " sha256", "", "xxh3"})
+	if err != nil || fmt.Sprint(parsed) != "[xxh3 sha256]" {
+		t.Errorf("Expected [xxh3 sha256], got %v, %v", parsed, err)
+	}
+	if _, err := Parse([]string{"sha256", "crc32"}); !errors.Is(err, ErrUnknown) {
+		t.Errorf("Expected ErrUnknown, got %v", err)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		offered, allowed []string
+		want             string
+	}{
+		{[]string{XXH3, BLAKE3, SHA256}, []string{BLAKE3, SHA256}, BLAKE3},
+		{[]string{BLAKE3, XXH3}, []string{XXH3, BLAKE3}, BLAKE3},
+		{[]string{XXH3}, []string{SHA256}, SHA256},
+		{nil, []string{XXH3}, SHA256},
+	} {
+		if got := Negotiate(tc.offered, tc.allowed); got != tc.want {
+			t.Errorf("Negotiate(%v, %v) = %s, expected %s", tc.offered, tc.allowed, got, tc.want)
+		}
+	}
+}
+
+func TestFIPS(t *testing.T) {
+	fips.Enable()
+	if got := Algorithms(); fmt.Sprint(got) != "[sha256]" {
+		t.Errorf("Expected only SHA-256 in approved mode, got %v", got)
+	}
+	if _, err := New(XXH3); err == nil {
+		t.Error("Expected XXH3 to be refused in approved mode")
+	}
+}
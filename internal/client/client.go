@@ -1,13 +1,689 @@
 package client
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/developmeh/webrtc-poc/internal/autostun"
+	"github.com/developmeh/webrtc-poc/internal/bundle"
+	"github.com/developmeh/webrtc-poc/internal/control"
+	"github.com/developmeh/webrtc-poc/internal/dedup"
+	"github.com/developmeh/webrtc-poc/internal/forward"
+	"github.com/developmeh/webrtc-poc/internal/groups"
 	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+	"github.com/developmeh/webrtc-poc/internal/peer"
+	"github.com/developmeh/webrtc-poc/internal/records"
+	"github.com/developmeh/webrtc-poc/internal/seal"
+	"github.com/developmeh/webrtc-poc/internal/share"
+	"github.com/developmeh/webrtc-poc/internal/sink"
+	"github.com/developmeh/webrtc-poc/internal/subscription"
+	"github.com/developmeh/webrtc-poc/internal/tui"
+	"github.com/pion/webrtc/v3"
 )
 
+// Config configures Run. Only ServerURL is required; the zero value of every
+// other field leaves its feature off.
+type Config struct {
+	// ServerURL is the offer endpoint of the server
+	ServerURL string
+	// Output is the file the lines are written to, stdout if empty
+	Output string
+	// ICEServers are the STUN and TURN servers, none for direct connections
+	ICEServers []webrtc.ICEServer
+	// Fallback, if set, retries failed connections through public STUN
+	// servers, starting with its first
+	Fallback *autostun.Fallback
+	// Interceptors names the media interceptors registered with the API
+	Interceptors []string
+	// API, if set, creates the peer connections instead of one for
+	// ICEServers with the Interceptors registered
+	API *webrtc.API
+	// Credentials are presented to the server when signaling
+	Credentials Credentials
+	// ConnectTimeout bounds how long a connection may take to be
+	// established, unless it is 0
+	ConnectTimeout time.Duration
+	// TrickleICE trickles the candidates if the server can
+	TrickleICE bool
+
+	// Channel describes the file stream channel, which the client only
+	// creates itself if it is negotiated
+	Channel peer.Channel
+	// ReceiveWindow is how many lines the server may send ahead of the
+	// output, unlimited if 0
+	ReceiveWindow int
+	// Group is the consumer group the client joins, if any
+	Group string
+	// Since and SinceSeq replay a followed file from the lines that arrived
+	// in the last Since, or from the line after the first SinceSeq, instead
+	// of from its start
+	Since    time.Duration
+	SinceSeq int
+	// Export names the export to stream instead of the server's file
+	Export string
+	// Records asks for smaller records or another policy for longer lines
+	Records records.Terms
+	// Batch accepts batches of lines
+	Batch bool
+	// RemoteLogs shows the server's log lines about the session
+	RemoteLogs bool
+	// HeartbeatInterval is how often the offset of the server's clock is
+	// measured, never if 0
+	HeartbeatInterval time.Duration
+
+	// Fetches are the files requested alongside the stream, up to
+	// FetchParallel at a time
+	Fetches       []share.Fetch
+	FetchParallel int
+	// Cache, if set, keeps the chunks of requested files, which are hashed
+	// with one of Checksums
+	Cache     *dedup.Cache
+	Checksums []string
+	// Uploads are the files uploaded alongside the stream
+	Uploads []string
+	// Forwarder, if set, forwards a local socket over the connection
+	Forwarder *forward.Forwarder
+
+	// Exec is the command the lines are piped into instead of the output
+	Exec        string
+	ExecOptions sink.ExecOptions
+	// OutputTemplate names the file the lines are appended to instead
+	OutputTemplate string
+	// Roll is the interval the output file is rolled at, if any
+	Roll string
+	// WriteRate throttles writes to the output to bytes per second,
+	// buffering up to WriteBuffer bytes, unless it is 0
+	WriteRate   int64
+	WriteBuffer int
+	// OutputDir is where the files of a stream of several files are written
+	// to, and requested files below
+	OutputDir      string
+	FollowSymlinks bool
+	// Name identifies the client in output templates and to the scheduler
+	Name string
+
+	// Subscription, if set, runs the client as a daemon receiving the pushes
+	// the server schedules for it, and is saved to StatePath with StateKey
+	Subscription *subscription.State
+	StatePath    string
+	StateKey     *seal.Key
+
+	// MetricsAddr is where the metrics are served, if set
+	MetricsAddr string
+	// TUI shows the terminal UI on stderr
+	TUI bool
+	// StopAtEnd returns once the stream ended instead of when ctx ends
+	StopAtEnd bool
+}
+
+// Run connects to the server and writes the lines it streams to the output
+// until ctx ends, or with StopAtEnd until the stream ended. It returns an
+// error if the client did not connect or a transfer failed, wrapping the
+// exit status of a failed Exec command. Every binary's client command runs
+// it.
+func Run(ctx context.Context, cfg Config) error {
+	serverURL, output := cfg.ServerURL, cfg.Output
+	if cfg.Credentials.Manual {
+		logger.Info("Signaling by hand, the offer and answer are pasted between the terminals")
+	} else {
+		logger.Info("Connecting to server: %s", serverURL)
+	}
+
+	// Expose internal health metrics if requested
+	if cfg.MetricsAddr != "" {
+		logger.Info("Serving metrics on %s/metrics", cfg.MetricsAddr)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go func() {
+			if err := http.ListenAndServe(cfg.MetricsAddr, mux); err != nil {
+				logger.Error("Metrics server error: %v", err)
+			}
+		}()
+	}
+
+	if cfg.Fallback != nil {
+		cfg.ICEServers = cfg.Fallback.ICEServers()
+	}
+
+	// The answer is pasted into stdin once, which the terminal UI reads keys
+	// from
+	daemon := cfg.Subscription != nil
+	if cfg.Credentials.Manual && (daemon || cfg.TUI) {
+		return errors.New("--signal manual and qr cannot be combined with daemon mode or --tui")
+	}
+
+	// Check the output template and roll pattern before connecting
+	tmpl, roller, err := outputFiles(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Offers relayed through a rendezvous server cannot name a group, and
+	// pushes are never shared
+	if cfg.Group != "" {
+		if err := groups.ValidName(cfg.Group); err != nil {
+			return fmt.Errorf("invalid --group: %w", err)
+		}
+		if cfg.Credentials.Code != "" || daemon {
+			return errors.New("--group cannot be combined with --code or daemon mode")
+		}
+	}
+	if cfg.Since != 0 || cfg.SinceSeq != 0 {
+		switch {
+		case cfg.Since < 0 || cfg.SinceSeq < 0:
+			return errors.New("--since and --since-seq cannot be negative")
+		case cfg.Since != 0 && cfg.SinceSeq != 0:
+			return errors.New("--since and --since-seq cannot be combined")
+		case cfg.Group != "" || cfg.Export != "":
+			return errors.New("--since and --since-seq cannot be combined with --group or --export")
+		case cfg.Credentials.Code != "" || daemon:
+			return errors.New("--since and --since-seq cannot be combined with --code or daemon mode")
+		}
+	}
+	if daemon {
+		if cfg.Credentials.Code != "" {
+			return errors.New("daemon mode cannot connect through a rendezvous code")
+		}
+		if len(cfg.Fetches) > 0 || len(cfg.Uploads) > 0 {
+			return errors.New("daemon mode cannot request or upload files")
+		}
+		if cfg.Forwarder != nil {
+			return errors.New("daemon mode cannot forward sockets")
+		}
+		if cfg.TUI {
+			return errors.New("daemon mode cannot show the terminal UI")
+		}
+		return runDaemon(ctx, cfg, tmpl, roller)
+	}
+
+	// Create a channel to receive data
+	dataChan := make(chan string)
+
+	// Signalled when a connection attempt fails before it was established
+	failed := make(chan struct{}, 1)
+
+	// The server's SHA-256 of the stream, checked against the output once
+	// the stream ended
+	digests := make(chan []byte, 1)
+
+	// The files of a stream of several files, handed to the output in order
+	// with their lines
+	type fileEvent struct {
+		kind     byte
+		manifest bundle.Manifest
+		index    int
+		lines    int
+	}
+	files := make(chan fileEvent)
+
+	// Set once the server sends raw chunks, which are written as they are
+	var raw atomic.Bool
+	hooks := Hooks{
+		Digest: func(sum []byte) {
+			select {
+			case digests <- sum:
+			default:
+			}
+		},
+		Manifest: func(m bundle.Manifest) { files <- fileEvent{kind: control.Manifest, manifest: m} },
+		Begin:    func(i int) { files <- fileEvent{kind: control.Begin, index: i} },
+		End:      func(lines int) { files <- fileEvent{kind: control.End, lines: lines} },
+		Raw:      func() { raw.Store(true) },
+	}
+
+	// A client of a consumer group commits the lines it wrote, only ever
+	// the latest count
+	var commits chan int
+	if cfg.Group != "" {
+		commits = make(chan int, 1)
+		hooks.Written = commits
+	}
+
+	// The terminal UI follows the connection from its first state on
+	var ui *tui.UI
+	if cfg.TUI {
+		ui = tui.New(serverURL)
+		hooks.ui = ui
+	}
+
+	// Connect to the server, within the connect timeout
+	connectCtx, cancelConnect := connectContext(ctx, cfg.ConnectTimeout)
+	defer func() { cancelConnect() }()
+	peerConnection, err := Connect(connectCtx, cfg, dataChan, failed, hooks)
+	if err != nil {
+		return connectError(connectCtx, err)
+	}
+
+	// Fetch the requested files and upload files alongside the stream, and
+	// stop once they are all done
+	pending := 0
+	fetched := make(chan []share.Outcome, 1)
+	if len(cfg.Fetches) > 0 {
+		pending++
+		go func() {
+			fetched <- FetchFiles(peerConnection, cfg.Fetches, cfg.FetchParallel, cfg.Cache, cfg.FollowSymlinks)
+		}()
+	}
+	uploaded := make(chan int, 1)
+	if len(cfg.Uploads) > 0 {
+		pending++
+		go func() {
+			uploaded <- uploadFiles(peerConnection, cfg.Uploads)
+		}()
+	}
+
+	// Open the forward channels alongside the stream
+	if cfg.Forwarder != nil {
+		defer cfg.Forwarder.Close()
+		go func() {
+			if err := cfg.Forwarder.Run(peerConnection); err != nil {
+				logger.Error("Forwarding stopped: %v", err)
+			}
+		}()
+	}
+
+	// Pipe the lines into the exec command, or open the output file if
+	// specified
+	command, err := openExec(cfg)
+	if err != nil {
+		peerConnection.Close()
+		return err
+	}
+	out := io.Writer(os.Stdout)
+	switch {
+	case command != nil:
+		out = command
+		logger.Info("Writing output to command: %s", cfg.Exec)
+	case tmpl != nil:
+		outputFile, err := tmpl.Open(streamFields(cfg, serverURL, cfg.Channel.Label))
+		if err != nil {
+			peerConnection.Close()
+			return fmt.Errorf("failed to open output file: %w", err)
+		}
+		defer outputFile.Close()
+		out = outputFile
+		logger.Info("Appending output to file: %s", outputFile.Name())
+	case roller != nil:
+		defer roller.Close()
+		out = roller
+		logger.Info("Rolling output %s: %s", cfg.Roll, output)
+	case sink.IsFIFO(output):
+		fifo, err := sink.OpenFIFO(output)
+		if err != nil {
+			peerConnection.Close()
+			return fmt.Errorf("failed to open output pipe: %w", err)
+		}
+		defer fifo.Close()
+		out = fifo
+		logger.Info("Writing output to named pipe: %s", output)
+	case output != "":
+		outputFile, err := os.Create(output)
+		if err != nil {
+			peerConnection.Close()
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer outputFile.Close()
+		out = outputFile
+		logger.Info("Writing output to file: %s", output)
+	case ui != nil && tui.IsTerminal(os.Stdout):
+		out = io.Discard
+		logger.Info("Showing the output in the terminal UI only")
+	default:
+		logger.Info("Writing output to stdout")
+	}
+
+	// Smooth writes out to the write rate, buffering bursts
+	var throttle *sink.Throttle
+	if cfg.WriteRate > 0 {
+		throttle = sink.NewThrottle(out, cfg.WriteRate, cfg.WriteBuffer)
+		out = throttle
+		logger.Info("Writing at most %d bytes/s, buffering up to %d bytes", cfg.WriteRate, cfg.WriteBuffer)
+	}
+
+	// Closed once the stream ended and the exec command exited
+	streamDone := make(chan struct{})
+
+	// Closed if the output does not match the server's digest
+	corrupted := make(chan struct{})
+
+	// Show the terminal UI on stderr, with the log inside it, until the
+	// client shuts down; keys are only read from a terminal
+	if ui != nil {
+		var keys io.Reader
+		if tui.IsTerminal(os.Stdin) {
+			keys = os.Stdin
+		}
+		logger.SetOutput(ui.Writer())
+		go func() {
+			if err := ui.Run(keys, os.Stderr); err != nil {
+				logger.Init()
+				logger.Error("Terminal UI failed: %v", err)
+			}
+		}()
+	}
+
+	// Start receiving data
+	go func() {
+		lineCount := 0
+		var byteCount int64
+		startTime := time.Now()
+		writeFailed := false
+		written := sha256.New()
+		failWrite := func(err error) {
+			if !writeFailed {
+				logger.Error("Failed to write output: %v", err)
+				writeFailed = true
+			}
+		}
+
+		// The files of a stream of several files are recreated below the
+		// output directory instead
+		dest := out
+		var bundled *bundle.Writer
+		var bundleThrottle *sink.Throttle
+		fileLines := 0
+		switchFile := func(ev fileEvent) {
+			if bundleThrottle != nil {
+				if err := bundleThrottle.Flush(); err != nil {
+					failWrite(err)
+				}
+			}
+			if ev.kind == control.Manifest {
+				w, err := bundle.NewWriter(cfg.OutputDir, cfg.FollowSymlinks, ev.manifest)
+				if err != nil {
+					failWrite(err)
+					dest = io.Discard
+					return
+				}
+				bundled, dest = w, w
+				if cfg.WriteRate > 0 {
+					bundleThrottle = sink.NewThrottle(w, cfg.WriteRate, cfg.WriteBuffer)
+					dest = bundleThrottle
+				}
+				logger.Info("Writing the %d files of the stream below %s", len(ev.manifest.Files), cfg.OutputDir)
+				return
+			}
+			if bundled == nil {
+				return
+			}
+			if ev.kind == control.Begin {
+				path, err := bundled.Begin(ev.index)
+				if err != nil {
+					failWrite(err)
+					return
+				}
+				fileLines = 0
+				logger.Info("Receiving %s", path)
+				return
+			}
+			if err := bundled.End(); err != nil {
+				failWrite(err)
+			}
+			if fileLines != ev.lines {
+				logger.Info("Warning: received %d of the %d lines the server sent of a file", fileLines, ev.lines)
+			}
+		}
+
+	receive:
+		for {
+			var line string
+			select {
+			case ev := <-files:
+				switchFile(ev)
+				continue
+			case l, ok := <-dataChan:
+				if !ok {
+					break receive
+				}
+				line = l
+			}
+			// Hold the stream back while the terminal UI is paused
+			ui.Wait()
+			lineCount++
+			fileLines++
+
+			// Chunks are written as they are, and are no lines to show
+			if raw.Load() {
+				if _, err := io.WriteString(dest, line); err != nil {
+					failWrite(err)
+				}
+				io.WriteString(written, line)
+				byteCount += int64(len(line))
+			} else {
+				if _, err := fmt.Fprintln(dest, line); err != nil {
+					failWrite(err)
+				}
+				io.WriteString(written, line)
+				written.Write([]byte{'\n'})
+				ui.Line(line)
+			}
+			if commits != nil && !writeFailed {
+				select {
+				case <-commits:
+				default:
+				}
+				commits <- lineCount
+			}
+
+			metrics.ClientPendingLines.Dec()
+			if raw.Load() {
+				logger.Debug("Received chunk %d of %d bytes", lineCount, len(line))
+			} else {
+				logger.Debug("Received line %d: %s", lineCount, line)
+			}
+		}
+		if bundled != nil {
+			switchFile(fileEvent{kind: control.End, lines: fileLines})
+		}
+
+		elapsed := time.Since(startTime)
+		if raw.Load() {
+			logger.Info("Received %d chunks, %d bytes in %v (%.2f bytes/sec)",
+				lineCount, byteCount, elapsed, float64(byteCount)/elapsed.Seconds())
+		} else {
+			logger.Info("Received %d lines in %v (%.2f lines/sec)",
+				lineCount, elapsed, float64(lineCount)/elapsed.Seconds())
+		}
+
+		// Let the throttled writes catch up
+		if throttle != nil {
+			if err := throttle.Flush(); err != nil && !writeFailed {
+				logger.Error("Failed to write output: %v", err)
+			}
+		}
+		if commits != nil {
+			close(commits)
+		}
+
+		// Check what was written against what the server sent
+		select {
+		case want := <-digests:
+			got := written.Sum(nil)
+			switch {
+			case writeFailed:
+				logger.Error("Output failed verification: not every line was written")
+				ui.Finish("failed verification")
+				close(corrupted)
+			case !bytes.Equal(got, want):
+				logger.Error("Output failed verification: its SHA-256 is %x, the server sent %x", got, want)
+				ui.Finish("failed verification")
+				close(corrupted)
+			default:
+				logger.Info("Output verified, SHA-256 %x", got)
+				ui.Finish("finished, verified")
+			}
+		default:
+			logger.Info("Warning: the server sent no digest of the stream, the output was not verified")
+			ui.Finish("finished")
+		}
+
+		// Let the command see the end of its input
+		if command != nil {
+			command.Close()
+		}
+		close(streamDone)
+	}()
+
+	// Only an exec command's exit or StopAtEnd end the client with the
+	// stream
+	var ended <-chan struct{}
+	if command != nil || cfg.StopAtEnd {
+		ended = streamDone
+	}
+
+	// Wait for ctx to end, retrying through public STUN servers if direct
+	// connections fail and automatic fallback is enabled
+	var outcomes []share.Outcome
+	uploadFailures := 0
+	for waiting := true; waiting; {
+		select {
+		case <-ctx.Done():
+			waiting = false
+		case outcomes = <-fetched:
+			share.WriteSummary(os.Stderr, outcomes)
+			pending--
+			waiting = pending > 0
+		case uploadFailures = <-uploaded:
+			pending--
+			waiting = pending > 0
+		case <-ended:
+			waiting = false
+		case <-corrupted:
+			waiting = false
+		case <-ui.Quit():
+			waiting = false
+		case <-failed:
+			// A connection not established in time is not retried
+			if errors.Is(connectCtx.Err(), context.DeadlineExceeded) {
+				ui.Close()
+				logger.Init()
+				peerConnection.Close()
+				return context.Cause(connectCtx)
+			}
+			if cfg.Fallback == nil {
+				continue
+			}
+
+			server, ok := cfg.Fallback.Failed()
+			if !ok {
+				logger.Error("Connection failed with every public STUN server")
+				continue
+			}
+			logger.Info("Connection failed, retrying with public STUN server %s", server)
+
+			if err := peerConnection.Close(); err != nil {
+				logger.Error("Error closing peer connection: %v", err)
+			}
+			cancelConnect()
+			cfg.ICEServers = cfg.Fallback.ICEServers()
+			connectCtx, cancelConnect = connectContext(ctx, cfg.ConnectTimeout)
+			peerConnection, err = Connect(connectCtx, cfg, dataChan, failed, hooks)
+			if err != nil {
+				ui.Close()
+				logger.Init()
+				return connectError(connectCtx, err)
+			}
+		}
+	}
+
+	// Give the terminal back before the last messages
+	if ui != nil {
+		ui.Close()
+		logger.Init()
+	}
+	logger.Info("Shutting down client...")
+
+	// Close the peer connection
+	if err := peerConnection.Close(); err != nil {
+		logger.Error("Error closing peer connection: %v", err)
+	}
+
+	// Write what the throttle still buffers without waiting for the rate
+	if throttle != nil {
+		if err := throttle.Close(); err != nil {
+			logger.Error("Failed to write output: %v", err)
+		}
+	}
+
+	logger.Info("Client shutdown complete")
+	if command != nil {
+		if err := command.Close(); err != nil {
+			return fmt.Errorf("--exec command failed: %w", err)
+		}
+	}
+	select {
+	case <-corrupted:
+		return errors.New("output failed verification")
+	default:
+	}
+	if failures := share.Failed(outcomes) + uploadFailures; failures > 0 {
+		return fmt.Errorf("%d transfers failed", failures)
+	}
+	return nil
+}
+
+// outputFiles parses the output template and the roll interval, if they are
+// configured
+func outputFiles(cfg Config) (*sink.Template, *sink.Roller, error) {
+	var tmpl *sink.Template
+	if cfg.OutputTemplate != "" {
+		if cfg.Output != "" || cfg.Exec != "" {
+			return nil, nil, errors.New("--output-template cannot be combined with --output or --exec")
+		}
+		var err error
+		if tmpl, err = sink.ParseTemplate(cfg.OutputTemplate); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.Roll == "" {
+		return tmpl, nil, nil
+	}
+	if cfg.Output == "" {
+		return nil, nil, errors.New("--roll requires --output")
+	}
+	roller, err := sink.NewRoller(cfg.Output, cfg.Roll)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tmpl, roller, nil
+}
+
+// openExec starts the command received lines are piped into, if one is
+// configured
+func openExec(cfg Config) (*sink.Exec, error) {
+	if cfg.Exec == "" {
+		return nil, nil
+	}
+	if cfg.Output != "" {
+		return nil, errors.New("--exec cannot be combined with --output")
+	}
+
+	e, err := sink.StartExec(cfg.Exec, cfg.ExecOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start --exec command: %w", err)
+	}
+	return e, nil
+}
+
+// streamFields describes a stream from serverURL to the output template
+func streamFields(cfg Config, serverURL, topic string) sink.Fields {
+	fields := sink.Fields{Topic: topic, Name: cfg.Name, Time: time.Now()}
+	if u, err := url.Parse(serverURL); err == nil {
+		fields.Host = u.Hostname()
+	}
+	return fields
+}
+
 // LineReceiver is an interface for receiving lines of text
 // This allows us to test the client functionality without using WebRTC
 type LineReceiver interface {
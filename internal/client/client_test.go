@@ -1,11 +1,16 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"io"
+	"net"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/server"
 )
 
 // MockLineReceiver is a mock implementation of the LineReceiver interface for testing
@@ -173,3 +178,55 @@ func TestProcessLines(t *testing.T) {
 		}
 	})
 }
+
+func TestRun(t *testing.T) {
+	// Pick a free port for the server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	content := "Line 1\nLine 2\n\nThis is a longer line with some special characters: !@#$%^&*()\n"
+	if err := os.WriteFile(input, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Run(ctx, server.Config{Addr: addr, File: input})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// The client writes every line and returns once the stream ended
+	output := filepath.Join(dir, "output.txt")
+	runCtx, runCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer runCancel()
+	if err := Run(runCtx, Config{ServerURL: "http://" + addr + "/offer", Output: output, StopAtEnd: true}); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read output file: %v", err)
+	}
+	if string(got) != content {
+		t.Errorf("Expected output %q, got %q", content, string(got))
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("Server returned error: %v", err)
+	}
+
+	// Test with a server that does not answer
+	t.Run("Server unreachable", func(t *testing.T) {
+		if err := Run(context.Background(), Config{ServerURL: "http://" + addr + "/offer"}); err == nil {
+			t.Error("Run should have returned an error when the server is unreachable")
+		}
+	})
+}
@@ -0,0 +1,663 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/batch"
+	"github.com/developmeh/webrtc-poc/internal/bundle"
+	"github.com/developmeh/webrtc-poc/internal/checksum"
+	"github.com/developmeh/webrtc-poc/internal/control"
+	"github.com/developmeh/webrtc-poc/internal/crash"
+	"github.com/developmeh/webrtc-poc/internal/fec"
+	"github.com/developmeh/webrtc-poc/internal/heartbeat"
+	"github.com/developmeh/webrtc-poc/internal/latency"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+	"github.com/developmeh/webrtc-poc/internal/peer"
+	"github.com/developmeh/webrtc-poc/internal/remotelog"
+	"github.com/developmeh/webrtc-poc/internal/signaling"
+	"github.com/developmeh/webrtc-poc/internal/trace"
+	"github.com/developmeh/webrtc-poc/internal/trickle"
+	"github.com/developmeh/webrtc-poc/internal/tui"
+	"github.com/pion/webrtc/v3"
+)
+
+// errUnknownPush is returned when the server no longer knows a push
+var errUnknownPush = errors.New("server does not know the push")
+
+var (
+	// ErrTooLong ends a stream the server aborted at a line longer than the
+	// largest record
+	ErrTooLong = errors.New("server aborted the stream at a line longer than the largest record")
+	// ErrSuperseded ends a stream a newer connection of the client's
+	// identity took over
+	ErrSuperseded = errors.New("a newer connection took over the session")
+)
+
+// Hooks follow the connection and are called from the file stream as it is
+// received, between the lines sent to its data channel; each one only if it
+// is set
+type Hooks struct {
+	// Connection is called with the peer connection once it was created,
+	// before the offer is made, to add handlers of its own
+	Connection func(pc *webrtc.PeerConnection)
+	// State is called with every state change of the connection
+	State func(state webrtc.PeerConnectionState)
+
+	// Restarted is called when the server sends a resumed file again from
+	// the start, before its first line
+	Restarted func()
+	// Digest asks the server for the SHA-256 of the stream, and is called
+	// with it before the stream ends
+	Digest func(sum []byte)
+	// Manifest is called with the files of a stream of several files before
+	// their lines
+	Manifest func(m bundle.Manifest)
+	// Begin is called before the lines of file i of the manifest
+	Begin func(i int)
+	// End is called after the lines of the current file, with the number the
+	// server sent
+	End func(lines int)
+	// Written, if set, joins the consumer group of the client and receives
+	// the number of lines written to the output, which are committed to the
+	// server
+	Written <-chan int
+	// Raw is called before every chunk of a stream of raw bytes, which is
+	// written as it is instead of as a line
+	Raw func()
+	// Cut is called when the server cut the next line, truncating or
+	// splitting it at the largest record
+	Cut func()
+	// Fin is called once the server finished the stream, with the number of
+	// lines it sent, before the stream ends. Servers of the first protocol
+	// finish it by closing it, having sent the lines received.
+	Fin func(sent int)
+	// Aborted is called with the reason the server ended the stream early,
+	// ErrTooLong or ErrSuperseded, before the stream ends
+	Aborted func(err error)
+	// Closed is called once the file stream channel closed, which the
+	// server does once the end of the stream was acknowledged
+	Closed func()
+
+	// ui follows the state of the connection
+	ui *tui.UI
+}
+
+// connectContext bounds how long a connection of the client may take to be
+// established by timeout, unless it is 0. The context's cause names the
+// timeout once it expired.
+func connectContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeoutCause(parent, timeout, fmt.Errorf("connection not established within --connect-timeout %s", timeout))
+}
+
+// connectError names the --connect-timeout in err if it expired during the
+// signaling, whose requests only report that their deadline passed
+func connectError(ctx context.Context, err error) error {
+	cause := context.Cause(ctx)
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, cause) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", cause, err)
+}
+
+// Connect creates a peer connection using the ICE servers of cfg and
+// exchanges the offer and answer with the server at cfg.ServerURL. Received lines are sent to
+// dataChan, and failed is signalled if the connection fails before it was
+// ever established. hooks are called in order with the lines. ctx bounds the
+// signaling and, once it returned, how long the connection may take to be
+// established: if its deadline passes first, the connection is closed and
+// failed is signalled. On error the connection is closed.
+func Connect(ctx context.Context, cfg Config, dataChan chan string, failed chan<- struct{}, hooks Hooks) (_ *webrtc.PeerConnection, err error) {
+	serverURL, creds := cfg.ServerURL, cfg.Credentials
+
+	// Create a new peer connection
+	api := cfg.API
+	if api == nil {
+		api = peer.NewAPI(cfg.ICEServers, cfg.Interceptors)
+	}
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{ICEServers: cfg.ICEServers})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create peer connection: %w", err)
+	}
+	if hooks.Connection != nil {
+		hooks.Connection(peerConnection)
+	}
+	// Record the connection into the trace under a session of its own
+	session := trace.NewSession()
+	trace.Bind(peerConnection, session)
+	trace.Watch(peerConnection)
+
+	// Give up on a connection not established by the deadline of ctx
+	var connected atomic.Bool
+	stopDeadline := context.AfterFunc(ctx, func() {
+		if connected.Load() || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+		peerConnection.Close()
+		select {
+		case failed <- struct{}{}:
+		default:
+		}
+	})
+	defer func() {
+		if err != nil {
+			stopDeadline()
+			peerConnection.Close()
+		}
+	}()
+
+	// Monitor connection state changes
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		logger.Info("Connection state changed: %s", state.String())
+		trace.Transition(session, "connection", state.String())
+		hooks.ui.State("connection", state.String())
+		if hooks.State != nil {
+			hooks.State(state)
+		}
+
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			connected.Store(true)
+			stopDeadline()
+			logger.Info("WebRTC connection established successfully!")
+			if pair, err := peerConnection.SCTP().Transport().ICETransport().GetSelectedCandidatePair(); err == nil {
+				hooks.ui.Route(pair)
+			}
+		case webrtc.PeerConnectionStateFailed:
+			logger.Error("WebRTC connection failed")
+			if !connected.Load() {
+				select {
+				case failed <- struct{}{}:
+				default:
+				}
+			}
+		case webrtc.PeerConnectionStateClosed:
+			stopDeadline()
+			logger.Info("WebRTC connection closed")
+		}
+	})
+	peerConnection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		trace.Transition(session, "ice", state.String())
+		hooks.ui.State("ice", state.String())
+	})
+
+	// Accept the file stream channel the server announces, opening a channel
+	// before the offer so it carries a data channel section and the server
+	// knows this client reads control messages. With --negotiated-channel
+	// the client creates the file stream channel itself, with the ID the
+	// server creates it with.
+	var d *webrtc.DataChannel
+	answered := func() bool { return true }
+	if cfg.Channel.Negotiated {
+		if d, err = peer.CreateFileChannel(peerConnection, cfg.Channel); err != nil {
+			return nil, fmt.Errorf("failed to create data channel: %w", err)
+		}
+	} else if answered, err = control.Greet(peerConnection); err != nil {
+		return nil, fmt.Errorf("failed to create data channel: %w", err)
+	}
+
+	// The offer's query carries the options the server applies from the
+	// start, set below and added to the server URL in one go
+	params := url.Values{}
+
+	// Pass the session to the server, so both traces name it the same
+	if trace.Enabled() {
+		params.Set(trace.Param, session)
+	}
+
+	// Advertise how many lines the server may send ahead of the output, with
+	// the offer and again once the channel opens for offers that cannot carry
+	// it, like relayed ones
+	window := cfg.ReceiveWindow
+	if window > 0 {
+		params.Set("window", strconv.Itoa(window))
+	}
+
+	// Join the consumer group, sharing the server's file with its other
+	// clients
+	if cfg.Group != "" && hooks.Written != nil {
+		params.Set("group", cfg.Group)
+	}
+
+	// Replay the followed file from a point in time or a sequence number
+	if cfg.Since > 0 {
+		params.Set("since", cfg.Since.String())
+	} else if cfg.SinceSeq > 0 {
+		params.Set("since_seq", strconv.Itoa(cfg.SinceSeq))
+	}
+
+	// Name the export to stream; it cannot be changed once the channel
+	// opens, so offers that cannot carry it stream the server's file
+	if cfg.Export != "" {
+		params.Set("export", cfg.Export)
+	}
+
+	// Ask for smaller records or another policy for longer lines; offers
+	// that cannot carry them get the server's
+	if cfg.Records.MaxSize > 0 {
+		params.Set("max_record", strconv.Itoa(cfg.Records.MaxSize))
+	}
+	if cfg.Records.Policy != "" {
+		params.Set("oversized", cfg.Records.Policy)
+	}
+
+	// Ask for the digest with the offer, or once the channel opens for offers
+	// relayed through a rendezvous server or pasted by hand, which cannot
+	// carry it. Servers that do not know the parameter never see a message
+	// they cannot read.
+	if hooks.Digest != nil {
+		params.Set("verify", checksum.SHA256)
+	}
+
+	// Accept batches of lines, which are split back into lines as they
+	// arrive; servers that do not know the parameter, and offers that cannot
+	// carry it, send every line on its own
+	if cfg.Batch {
+		params.Set("batch", "1")
+	}
+
+	// Offer the checksum algorithms the chunks of deduplicated requests may
+	// be hashed with; servers that do not know the parameter use SHA-256
+	if cfg.Cache != nil {
+		params.Set("checksums", strings.Join(cfg.Checksums, ","))
+	}
+
+	if len(params) > 0 {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server URL: %w", err)
+		}
+		query := u.Query()
+		for key, values := range params {
+			query[key] = values
+		}
+		u.RawQuery = query.Encode()
+		serverURL = u.String()
+	}
+
+	// Show the server's log lines about the session next to the client's
+	if cfg.RemoteLogs {
+		err := remotelog.Receive(peerConnection, func(line remotelog.Line) {
+			switch line.Level {
+			case remotelog.LevelError:
+				logger.Error("Server: %s", line.Msg)
+			case remotelog.LevelDebug:
+				logger.Debug("Server: %s", line.Msg)
+			default:
+				logger.Info("Server: %s", line.Msg)
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create log channel: %w", err)
+		}
+	}
+
+	// Estimate the offset of the server's clock, so line latencies are
+	// measured on one clock
+	var clock *heartbeat.Estimator
+	if cfg.HeartbeatInterval > 0 {
+		if clock, err = heartbeat.Start(peerConnection, cfg.HeartbeatInterval); err != nil {
+			return nil, fmt.Errorf("failed to create heartbeat channel: %w", err)
+		}
+	}
+
+	// The stream ends with the server's Fin, or when the channel closes
+	var mu sync.Mutex
+	var decoder *fec.Decoder
+	var latencies latency.Recorder
+	ended := false
+	received := 0
+	advertised := window
+	// push hands a line or chunk to the output
+	push := func(line string) {
+		received++
+		metrics.ClientPendingLines.Inc()
+		dataChan <- line
+
+		// The output took the line, so move the window along once half of
+		// it was used
+		if window > 0 && received+window-advertised >= max(window/2, 1) {
+			advertised = received + window
+			if err := control.Send(d, control.Window, advertised); err != nil {
+				logger.Debug("Failed to advertise the receive window: %v", err)
+			}
+		}
+	}
+	deliver := func(line string) {
+		// Lines from a server streaming with --timestamps carry their send time
+		if text, sent, ok := latency.Unwrap(line); ok {
+			d := time.Since(sent) + clock.Offset()
+			latencies.Add(d)
+			metrics.ClientLineLatency.Observe(d.Seconds())
+			line = text
+		}
+		push(line)
+	}
+	end := func(finished bool) {
+		if ended {
+			return
+		}
+		ended = true
+		if decoder != nil {
+			for _, line := range decoder.Flush() {
+				deliver(line)
+			}
+			recovered, lost := decoder.Stats()
+			logger.Info("FEC recovered %d lines, lost %d lines", recovered, lost)
+			if dupes := decoder.Duplicates(); dupes > 0 {
+				logger.Info("Dropped %d duplicate FEC shards", dupes)
+			}
+		}
+		if summary := latencies.Summary(); summary.Count > 0 {
+			logger.Info("Line latency: %v", summary)
+			for _, line := range latencies.Histogram().Lines() {
+				logger.Info("  %s", line)
+			}
+			if best, ok := clock.Best(); ok {
+				logger.Info("Corrected for a server clock offset of %v, measured with a %v round trip", best.Offset.Round(time.Microsecond), best.RoundTrip.Round(time.Microsecond))
+			} else {
+				logger.Info("Warning: no clock offset was measured, so the latency includes any difference between the hosts' clocks")
+			}
+		}
+		// Servers of the first protocol end the stream by closing it
+		if !finished && answered() {
+			logger.Info("Warning: the server closed the stream without finishing it")
+		} else if !finished && hooks.Fin != nil {
+			hooks.Fin(received)
+		}
+		close(dataChan)
+	}
+
+	// A panic while receiving closes the connection, ending the stream,
+	// instead of the whole client
+	isolate := func() { go peerConnection.Close() }
+
+	// Handle the file stream channel once it exists
+	listen := func() {
+		trace.Bind(d, session)
+		d.OnOpen(func() {
+			logger.Info("Data channel opened: %s", peer.ChannelName(d))
+			trace.Transition(session, "channel "+d.Label(), "open")
+			if window > 0 {
+				if err := control.Send(d, control.Window, window); err != nil {
+					logger.Error("Failed to advertise the receive window: %v", err)
+				}
+			}
+			if hooks.Digest != nil && !creds.carriesQuery() {
+				if err := control.Send(d, control.Verify, 0); err != nil {
+					logger.Error("Failed to ask for the digest of the stream: %v", err)
+				}
+			}
+			if hooks.Written != nil {
+				go func() {
+					for lines := range hooks.Written {
+						if err := control.Send(d, control.Commit, lines); err != nil {
+							logger.Debug("Failed to commit %d lines: %v", lines, err)
+						}
+					}
+				}()
+			}
+		})
+
+		// Lines arrive as text, in batches from a server streaming with --batch,
+		// or as shards from a server streaming with --fec; files that are not
+		// made of lines arrive in chunks
+		d.OnMessage(crash.Callback("client file channel", isolate, func(msg webrtc.DataChannelMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			if ended {
+				return
+			}
+			if kind, sent, ok := control.Decode(msg); ok {
+				trace.Frame(d, trace.Recv, control.Name(kind), strconv.Itoa(sent))
+				switch kind {
+				case control.Superseded:
+					logger.Info("Warning: a newer connection with this client's identity took over the session")
+					if hooks.Aborted != nil {
+						hooks.Aborted(ErrSuperseded)
+					}
+					end(true)
+				case control.Restart:
+					logger.Info("Warning: the file no longer starts with the %d lines received before, receiving it again from the start", sent)
+					if hooks.Restarted != nil {
+						hooks.Restarted()
+					}
+				case control.Truncated:
+					logger.Info("Warning: line %d is longer than the largest record and arrives truncated", sent)
+					if hooks.Cut != nil {
+						hooks.Cut()
+					}
+				case control.Split:
+					logger.Info("Warning: line %d is longer than the largest record and arrives split into several lines", sent)
+					if hooks.Cut != nil {
+						hooks.Cut()
+					}
+				case control.TooLong:
+					logger.Error("The server aborted the stream at line %d, which is longer than the largest record; ask for --oversized truncate or split to receive it", sent)
+					if hooks.Aborted != nil {
+						hooks.Aborted(fmt.Errorf("line %d: %w", sent, ErrTooLong))
+					}
+				case control.Begin:
+					if hooks.Begin != nil {
+						hooks.Begin(sent)
+					}
+				case control.End:
+					if hooks.End != nil {
+						hooks.End(sent)
+					}
+				case control.Fin:
+					if hooks.Fin != nil {
+						hooks.Fin(sent)
+					}
+					end(true)
+					if received != sent {
+						logger.Info("Warning: received %d of the %d lines the server sent", received, sent)
+					}
+					if err := control.Send(d, control.Ack, received); err != nil {
+						logger.Error("Failed to acknowledge the end of the stream: %v", err)
+					}
+				}
+				return
+			}
+			if kind, payload, ok := control.DecodeFrame(msg); ok {
+				trace.Frame(d, trace.Recv, control.Name(kind), fmt.Sprintf("%d bytes", len(payload)))
+				switch {
+				case kind == control.Batch:
+					lines, err := batch.Decode(payload)
+					if err != nil {
+						logger.Error("%v", err)
+						return
+					}
+					for _, line := range lines {
+						deliver(line)
+					}
+				case kind == control.Chunk:
+					if hooks.Raw != nil {
+						hooks.Raw()
+					}
+					push(string(payload))
+				case kind == control.Digest && hooks.Digest != nil:
+					hooks.Digest(payload)
+				case kind == control.Manifest && hooks.Manifest != nil:
+					manifest, err := bundle.DecodeManifest(payload)
+					if err != nil {
+						logger.Error("%v", err)
+						return
+					}
+					hooks.Manifest(manifest)
+				}
+				return
+			}
+			if msg.IsString {
+				deliver(string(msg.Data))
+				return
+			}
+			if decoder == nil {
+				decoder = fec.NewDecoder()
+			}
+			// Shards are keyed by their group and index, so one arriving again
+			// is dropped rather than written twice
+			dupes := decoder.Duplicates()
+			lines, err := decoder.Add(msg.Data)
+			if err != nil {
+				logger.Error("Dropping invalid FEC shard: %v", err)
+			}
+			if decoder.Duplicates() > dupes {
+				metrics.ClientDuplicateShards.Inc()
+				logger.Debug("Dropped a duplicate FEC shard")
+			}
+			for _, line := range lines {
+				deliver(line)
+			}
+		}))
+
+		d.OnClose(func() {
+			logger.Info("Data channel closed")
+			trace.Transition(session, "channel "+d.Label(), "closed")
+			mu.Lock()
+			end(false)
+			mu.Unlock()
+			if hooks.Closed != nil {
+				hooks.Closed()
+			}
+		})
+	}
+	if d != nil {
+		listen()
+	} else {
+		var accepted sync.Once
+		peerConnection.OnDataChannel(func(channel *webrtc.DataChannel) {
+			accepted.Do(func() {
+				d = channel
+				listen()
+			})
+		})
+	}
+
+	// Let the server make the offer and answer it
+	if creds.OfferRole == signaling.OfferRoleServer {
+		if err := answerServerOffer(ctx, peerConnection, serverURL, creds); err != nil {
+			return nil, err
+		}
+		return peerConnection, nil
+	}
+
+	// Trickle the candidates over plain signaling if the server can, checking
+	// while gathering starts; sealed and relayed offers carry them inside
+	var trickler *trickle.Client
+	supported := make(chan bool, 1)
+	if cfg.TrickleICE && creds.Code == "" && !creds.Noise && !creds.Manual && creds.Signal == nil {
+		base, err := url.Parse(serverURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server URL: %w", err)
+		}
+		trickler = trickle.NewClient(peerConnection, base.ResolveReference(&url.URL{Path: strings.TrimPrefix(trickle.Path, "/")}).String(), creds.sign)
+		go func() { supported <- trickler.Supported(ctx) }()
+	}
+
+	// Create an offer
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create offer: %w", err)
+	}
+
+	// Set the local description
+	if err := peerConnection.SetLocalDescription(offer); err != nil {
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	// Wait for ICE gathering to complete, unless the candidates trickle
+	if trickler != nil && !<-supported {
+		logger.Info("Server does not support trickle ICE, falling back to waiting for gathering")
+		trickler = nil
+	}
+	if trickler == nil {
+		if err := awaitGathering(ctx, peerConnection); err != nil {
+			return nil, err
+		}
+	}
+
+	// Get the local description after ICE gathering is complete
+	offer = *peerConnection.LocalDescription()
+	trace.Description(session, trace.Send, offer)
+
+	// Log the SDP for debugging
+	logger.Debug("Offer SDP: %s", offer.SDP)
+
+	// Send the offer to the server
+	offerJSON, err := json.Marshal(offer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal offer: %w", err)
+	}
+
+	// Log the raw offer for debugging
+	logger.Debug("Raw offer: %s", string(offerJSON))
+
+	var answerJSON []byte
+	var trickleID string
+	switch {
+	case creds.Signal != nil:
+		answerJSON, err = creds.Signal(ctx, offerJSON)
+	case creds.Code != "":
+		answerJSON, err = SendRelayedOffer(ctx, creds, offerJSON)
+	case creds.Manual:
+		answerJSON, err = pasteOffer(ctx, creds, offerJSON)
+	case creds.Noise:
+		answerJSON, err = sendSealedOffer(ctx, serverURL, creds, offerJSON)
+	default:
+		answerJSON, trickleID, err = sendOffer(ctx, serverURL, creds, offerJSON, trickler != nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the answer
+	var answer webrtc.SessionDescription
+	if err := json.Unmarshal(answerJSON, &answer); err != nil {
+		return nil, fmt.Errorf("failed to parse answer: %w, raw response: %s", err, string(answerJSON))
+	}
+	trace.Description(session, trace.Recv, answer)
+
+	// Set the remote description
+	if err := peerConnection.SetRemoteDescription(answer); err != nil {
+		return nil, fmt.Errorf("failed to set remote description: %w", err)
+	}
+
+	// Exchange the candidates, or rely on those in the descriptions if the
+	// server answered without trickling
+	if trickler != nil {
+		if trickleID == "" {
+			logger.Info("Warning: server answered without trickle ICE, connecting with the candidates gathered so far")
+		} else {
+			logger.Info("Trickling ICE candidates")
+			trickler.Start(trickleID)
+		}
+	}
+
+	return peerConnection, nil
+}
+
+// awaitGathering waits for ICE gathering to complete, or fails with the cause
+// of ctx once it ends first
+func awaitGathering(ctx context.Context, peerConnection *webrtc.PeerConnection) error {
+	logger.Info("Waiting for ICE gathering to complete...")
+	select {
+	case <-webrtc.GatheringCompletePromise(peerConnection):
+	case <-ctx.Done():
+		return fmt.Errorf("ICE gathering did not complete: %w", context.Cause(ctx))
+	}
+	logger.Info("ICE gathering complete")
+	return nil
+}
@@ -0,0 +1,275 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+	"github.com/developmeh/webrtc-poc/internal/schedule"
+	"github.com/developmeh/webrtc-poc/internal/signaling"
+	"github.com/developmeh/webrtc-poc/internal/sink"
+	"github.com/developmeh/webrtc-poc/internal/subscription"
+)
+
+// runDaemon registers with the server and receives every push the server
+// schedules for it until ctx ends, appending them to the output or, with a
+// template, to the file it renders for each push. The subscription is
+// persisted so a restarted daemon resubscribes with the same parameters and
+// resumes an interrupted push where it left off.
+func runDaemon(ctx context.Context, cfg Config, tmpl *sink.Template, roller *sink.Roller) error {
+	state, output, creds := cfg.Subscription, cfg.Output, cfg.Credentials
+	serverURL, name := state.Server, state.Name
+	if name == "" {
+		return errors.New("daemon mode requires --name")
+	}
+
+	var pattern *regexp.Regexp
+	if state.Filter != "" {
+		var err error
+		if pattern, err = regexp.Compile(state.Filter); err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+	}
+
+	registerURL, err := url.Parse(serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+	registerURL = registerURL.ResolveReference(&url.URL{Path: "register", RawQuery: url.Values{"name": {name}}.Encode()})
+
+	// Pipe the pushes into the exec command, or open the output file if
+	// specified
+	command, err := openExec(cfg)
+	if err != nil {
+		return err
+	}
+	out := io.Writer(os.Stdout)
+	if command != nil {
+		out = command
+		logger.Info("Writing pushes to command: %s", cfg.Exec)
+	} else if tmpl != nil {
+		logger.Info("Appending pushes to files from template: %s", cfg.OutputTemplate)
+	} else if roller != nil {
+		defer roller.Close()
+		out = roller
+		logger.Info("Rolling pushes %s: %s", cfg.Roll, output)
+	} else if sink.IsFIFO(output) {
+		fifo, err := sink.OpenFIFO(output)
+		if err != nil {
+			return fmt.Errorf("failed to open output pipe: %w", err)
+		}
+		defer fifo.Close()
+		out = fifo
+		logger.Info("Writing pushes to named pipe: %s", output)
+	} else if output != "" {
+		outputFile, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open output file: %w", err)
+		}
+		defer outputFile.Close()
+		out = outputFile
+		logger.Info("Appending pushes to file: %s", output)
+	}
+
+	saveState := func() {
+		if err := state.Save(cfg.StatePath, cfg.StateKey); err != nil {
+			logger.Error("%v", err)
+		}
+	}
+	saveState()
+
+	logger.Info("Registered as %s, waiting for pushes from %s", name, registerURL.Host)
+	for ctx.Err() == nil {
+		// Resume an interrupted push before waiting for new ones
+		if state.Push == nil {
+			push, err := pollPush(ctx, registerURL.String(), creds)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("Failed to poll for pushes: %v", err)
+					select {
+					case <-ctx.Done():
+					case <-time.After(5 * time.Second):
+					}
+				}
+				continue
+			}
+			if push == nil {
+				continue
+			}
+			state.Push = &subscription.Progress{ID: push.ID, File: push.File}
+			saveState()
+			logger.Info("Receiving push %s of %s", push.ID, push.File)
+		} else {
+			logger.Info("Resuming push %s of %s after line %d", state.Push.ID, state.Push.File, state.Push.Offset)
+		}
+
+		progress := state.Push
+		pushURL, _ := url.Parse(serverURL)
+		query := url.Values{"push": {progress.ID}}
+		if progress.Offset > 0 {
+			query.Set("offset", strconv.Itoa(progress.Offset))
+			// Let the server check the file still starts with these lines
+			if sum := progress.Sum(); sum != "" {
+				query.Set("prefix", sum)
+			}
+		}
+		pushURL.RawQuery = query.Encode()
+
+		// Every push is appended to the file the template names for it
+		pushOut := out
+		var pushFile *os.File
+		if tmpl != nil {
+			fields := streamFields(cfg, serverURL, filepath.Base(progress.File))
+			fields.Name = name
+			file, err := tmpl.Open(fields)
+			if err != nil {
+				logger.Error("Failed to open output file for push %s: %v", progress.ID, err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(5 * time.Second):
+				}
+				continue
+			}
+			logger.Info("Appending push %s to file: %s", progress.ID, file.Name())
+			pushOut, pushFile = file, file
+		}
+		var throttle *sink.Throttle
+		if cfg.WriteRate > 0 {
+			throttle = sink.NewThrottle(pushOut, cfg.WriteRate, cfg.WriteBuffer)
+			pushOut = throttle
+		}
+
+		pushCfg := cfg
+		pushCfg.ServerURL = pushURL.String()
+		lines, err := receivePush(ctx, pushCfg, func(line string) {
+			if pattern == nil || pattern.MatchString(line) {
+				fmt.Fprintln(pushOut, line)
+			}
+			progress.Add(line)
+			if progress.Offset%100 == 0 {
+				saveState()
+			}
+		}, func() {
+			progress.Restart()
+			saveState()
+		})
+		if throttle != nil {
+			// Let the output catch up before the push counts as written,
+			// unless shutting down
+			if ctx.Err() == nil {
+				throttle.Flush()
+			}
+			if err := throttle.Close(); err != nil {
+				logger.Error("Failed to write push %s: %v", progress.ID, err)
+			}
+		}
+		if pushFile != nil {
+			pushFile.Close()
+		}
+		if err != nil {
+			logger.Error("Push %s failed after line %d: %v", progress.ID, progress.Offset, err)
+			if errors.Is(err, errUnknownPush) {
+				// The server no longer knows the push, so it cannot be resumed
+				state.Push = nil
+			}
+			saveState()
+			continue
+		}
+
+		logger.Info("Received push %s of %s, %d lines", progress.ID, progress.File, lines)
+		state.Push = nil
+		saveState()
+	}
+
+	logger.Info("Client shutdown complete")
+	if command != nil {
+		if err := command.Close(); err != nil {
+			return fmt.Errorf("--exec command failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// pollPush waits for the server to schedule a push, returning nil if the
+// poll timed out without one
+func pollPush(ctx context.Context, registerURL string, creds Credentials) (*schedule.Push, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, registerURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	creds.sign(req, nil)
+
+	resp, err := creds.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read push: %w", err)
+		}
+		if err := creds.verify(resp, body); err != nil {
+			return nil, err
+		}
+		var push schedule.Push
+		if err := json.Unmarshal(body, &push); err != nil {
+			return nil, fmt.Errorf("failed to decode push: %w", err)
+		}
+		return &push, nil
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+}
+
+// receivePush connects for the single push at cfg.ServerURL and passes its
+// lines to handle until the server closes the data channel
+func receivePush(ctx context.Context, cfg Config, handle func(line string), restarted func()) (int, error) {
+	dataChan := make(chan string)
+	failed := make(chan struct{}, 1)
+
+	connectCtx, cancel := connectContext(ctx, cfg.ConnectTimeout)
+	defer cancel()
+	peerConnection, err := Connect(connectCtx, cfg, dataChan, failed, Hooks{Restarted: restarted})
+	if err != nil {
+		return 0, connectError(connectCtx, err)
+	}
+	defer peerConnection.Close()
+
+	lineCount := 0
+	for {
+		select {
+		case line, ok := <-dataChan:
+			if !ok {
+				return lineCount, nil
+			}
+			lineCount++
+			handle(line)
+			metrics.ClientPendingLines.Dec()
+		case <-failed:
+			if errors.Is(connectCtx.Err(), context.DeadlineExceeded) {
+				return lineCount, context.Cause(connectCtx)
+			}
+			return lineCount, signaling.ErrICEFailed
+		case <-ctx.Done():
+			return lineCount, ctx.Err()
+		}
+	}
+}
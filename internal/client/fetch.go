@@ -0,0 +1,304 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/crash"
+	"github.com/developmeh/webrtc-poc/internal/dedup"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/share"
+	"github.com/pion/webrtc/v3"
+)
+
+// FetchFiles requests files from the server over the existing connection,
+// up to parallel at a time, and returns their outcomes in order. Patterns are
+// expanded by the server first and fetched as the files they match, through
+// symlinks below the output directory if followSymlinks is set.
+func FetchFiles(peerConnection *webrtc.PeerConnection, requested []share.Fetch, parallel int, cache *dedup.Cache, followSymlinks bool) []share.Outcome {
+	var fetches []share.Fetch
+	var failures []share.Outcome
+	for _, fetch := range requested {
+		if !share.IsPattern(fetch.Name) {
+			fetches = append(fetches, fetch)
+			continue
+		}
+
+		matches, err := ListFiles(peerConnection, fetch, followSymlinks)
+		if err != nil {
+			logger.Error("Failed to list %s: %v", fetch.Name, err)
+			failures = append(failures, share.Outcome{Fetch: fetch, Err: err})
+			continue
+		}
+		logger.Info("Pattern %s matches %d files", fetch.Name, len(matches))
+		fetches = append(fetches, matches...)
+	}
+
+	outcomes := make([]share.Outcome, len(fetches))
+	slots := make(chan struct{}, max(parallel, 1))
+	var wg sync.WaitGroup
+	for i, fetch := range fetches {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			defer func() {
+				// A panic fails this fetch, not the others
+				if r := recover(); r != nil {
+					outcomes[i] = share.Outcome{Fetch: fetch, Err: crash.Recovered("client fetch", r)}
+				}
+			}()
+
+			start := time.Now()
+			result, err := fetchFile(peerConnection, fetch.Name, fetch.Output, cache)
+			outcomes[i] = share.Outcome{Fetch: fetch, Lines: result.Lines, Duration: time.Since(start), Err: err}
+			if err != nil {
+				logger.Error("Failed to fetch %s: %v", fetch.Name, err)
+				return
+			}
+			logger.Info("Fetched %s to %s, %d lines", fetch.Name, fetch.Output, result.Lines)
+		}()
+	}
+	wg.Wait()
+	return append(outcomes, failures...)
+}
+
+// ListFiles asks the server which files a pattern matches, and returns them
+// as fetches below the output directory of fetch
+func ListFiles(peerConnection *webrtc.PeerConnection, fetch share.Fetch, followSymlinks bool) ([]share.Fetch, error) {
+	protocol := share.ListProtocol
+	request, err := peerConnection.CreateDataChannel(fetch.Name, &webrtc.DataChannelInit{Protocol: &protocol})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data channel: %w", err)
+	}
+
+	// Both callbacks may fire, neither must block
+	results := make(chan share.Result, 1)
+	errs := make(chan error, 2)
+	request.OnMessage(func(msg webrtc.DataChannelMessage) {
+		result, err := share.DecodeResult(msg.Data)
+		if err != nil {
+			errs <- err
+			return
+		}
+		results <- result
+	})
+	request.OnClose(func() {
+		errs <- errors.New("channel closed without a result")
+	})
+
+	select {
+	case result := <-results:
+		if result.Error != "" {
+			return nil, errors.New(result.Error)
+		}
+		if len(result.Files) == 0 {
+			return nil, errors.New("no files match")
+		}
+		return fetch.Matches(result.Files, followSymlinks)
+	case err := <-errs:
+		return nil, err
+	}
+}
+
+// fetchFile requests one file on a new data channel and writes its lines to
+// path, removing the file again if the server could not send it. With a
+// cache only the chunks missing from it are transferred.
+func fetchFile(peerConnection *webrtc.PeerConnection, name, path string, cache *dedup.Cache) (result share.Result, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return result, fmt.Errorf("error creating output directory: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return result, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer func() {
+		file.Close()
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	protocol := share.Protocol
+	if cache != nil {
+		protocol = share.DedupProtocol
+	}
+	request, err := peerConnection.CreateDataChannel(name, &webrtc.DataChannelInit{Protocol: &protocol})
+	if err != nil {
+		return result, fmt.Errorf("failed to create data channel: %w", err)
+	}
+
+	// Handle the messages in order here, and keep draining them after an
+	// early return so the channel is never blocked
+	msgs := make(chan webrtc.DataChannelMessage, 16)
+	request.OnMessage(func(msg webrtc.DataChannelMessage) {
+		msgs <- msg
+	})
+	request.OnClose(func() {
+		close(msgs)
+	})
+	defer func() {
+		request.Close()
+		for range msgs {
+		}
+	}()
+
+	if cache != nil {
+		return receiveDeduplicated(request, msgs, file, cache)
+	}
+
+	// Lines arrive as text, the result as the last binary message
+	received := 0
+	for msg := range msgs {
+		if msg.IsString {
+			received++
+			fmt.Fprintln(file, string(msg.Data))
+			continue
+		}
+		return share.CheckResult(msg.Data, received)
+	}
+	return result, fmt.Errorf("channel closed after %d lines without a result", received)
+}
+
+// maxChunkRetransmits is how often a client asks for a chunk that failed its
+// CRC before giving up on the file
+const maxChunkRetransmits = 3
+
+// receiveDeduplicated answers the manifest of a requested file with the
+// chunks missing from the cache, and writes the file from the cached and the
+// received chunks, caching the latter. Received chunks that fail their CRC
+// are requested again right away and written in place once they arrive
+// intact.
+func receiveDeduplicated(request *webrtc.DataChannel, msgs <-chan webrtc.DataChannelMessage, w io.WriterAt, cache *dedup.Cache) (share.Result, error) {
+	msg, ok := <-msgs
+	if !ok || msg.IsString {
+		return share.Result{}, errors.New("server did not send a manifest")
+	}
+	manifest, err := share.DecodeManifest(msg.Data)
+	if err != nil {
+		return share.Result{}, err
+	}
+	if manifest.Error != "" {
+		return share.Result{}, errors.New(manifest.Error)
+	}
+	if cache, err = cache.With(manifest.Algorithm); err != nil {
+		return share.Result{}, fmt.Errorf("server hashed the chunks with an unsupported algorithm: %w", err)
+	}
+
+	missing := cache.Missing(manifest.Chunks)
+	if err := request.Send(share.Need{Chunks: missing, Verify: manifest.CRC}.Encode()); err != nil {
+		return share.Result{}, fmt.Errorf("failed to send needed chunks: %w", err)
+	}
+	logger.Info("Reusing %d of %d chunks of %s from the cache", len(manifest.Chunks)-len(missing), len(manifest.Chunks), request.Label())
+
+	needed := make(map[int]bool, len(missing))
+	for _, i := range missing {
+		needed[i] = true
+	}
+	offsets := make([]int64, len(manifest.Chunks))
+	for i := 1; i < len(offsets); i++ {
+		offsets[i] = offsets[i-1] + manifest.Chunks[i-1].Size
+	}
+
+	// receive collects the lines of chunk i, computing its CRC and hash as
+	// they arrive so the chunk is checked without another pass over it
+	received := 0
+	sum := cache.NewSum()
+	receive := func(i int) ([]byte, dedup.Chunk, error) {
+		var data bytes.Buffer
+		for range manifest.Chunks[i].Lines {
+			msg, ok := <-msgs
+			if !ok || !msg.IsString {
+				return nil, dedup.Chunk{}, fmt.Errorf("transfer ended inside chunk %d", i)
+			}
+			data.Write(msg.Data)
+			data.WriteByte('\n')
+			sum.Add(msg.Data)
+			received++
+		}
+		return data.Bytes(), sum.Chunk(), nil
+	}
+
+	// place writes and caches chunk i if it arrived intact, and otherwise
+	// asks for it again
+	var retransmits []int
+	attempts := make(map[int]int)
+	place := func(i int, data []byte, got dedup.Chunk) error {
+		chunk := manifest.Chunks[i]
+		if manifest.CRC && got.CRC != chunk.CRC {
+			if attempts[i]++; attempts[i] > maxChunkRetransmits {
+				return fmt.Errorf("%w: chunk %d, %d times", dedup.ErrChecksum, i, attempts[i])
+			}
+			logger.Info("Chunk %d of %s failed its CRC, requesting it again", i, request.Label())
+			if err := request.Send(share.Need{Chunks: []int{i}}.Encode()); err != nil {
+				return fmt.Errorf("failed to request chunk %d again: %w", i, err)
+			}
+			retransmits = append(retransmits, i)
+			return nil
+		}
+		if _, err := w.WriteAt(data, offsets[i]); err != nil {
+			return fmt.Errorf("failed to write chunk %d: %w", i, err)
+		}
+		if err := cache.PutHashed(chunk, got.Hash, data); err != nil {
+			logger.Error("Failed to cache chunk %s: %v", chunk.Hash, err)
+		}
+		return nil
+	}
+
+	total := 0
+	for i, chunk := range manifest.Chunks {
+		total += chunk.Lines
+		if !needed[i] {
+			data, err := cache.Get(chunk)
+			if err != nil {
+				return share.Result{}, err
+			}
+			if _, err := w.WriteAt(data, offsets[i]); err != nil {
+				return share.Result{}, fmt.Errorf("failed to write chunk %d: %w", i, err)
+			}
+			continue
+		}
+		data, got, err := receive(i)
+		if err != nil {
+			return share.Result{}, err
+		}
+		if err := place(i, data, got); err != nil {
+			return share.Result{}, err
+		}
+	}
+
+	// The server streams the requested chunks again after the others, in
+	// the order they were requested
+	for len(retransmits) > 0 {
+		i := retransmits[0]
+		retransmits = retransmits[1:]
+		data, got, err := receive(i)
+		if err != nil {
+			return share.Result{}, err
+		}
+		if err := place(i, data, got); err != nil {
+			return share.Result{}, err
+		}
+	}
+	if manifest.CRC {
+		// Every chunk checked out
+		if err := request.Send(share.Need{}.Encode()); err != nil {
+			return share.Result{}, fmt.Errorf("failed to confirm chunks: %w", err)
+		}
+	}
+
+	msg, ok = <-msgs
+	if !ok || msg.IsString {
+		return share.Result{}, fmt.Errorf("channel closed after %d lines without a result", received)
+	}
+	result, err := share.CheckResult(msg.Data, received)
+	result.Lines = total
+	return result, err
+}
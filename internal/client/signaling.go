@@ -0,0 +1,426 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/fips"
+	"github.com/developmeh/webrtc-poc/internal/identity"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/maintenance"
+	"github.com/developmeh/webrtc-poc/internal/noise"
+	"github.com/developmeh/webrtc-poc/internal/paste"
+	"github.com/developmeh/webrtc-poc/internal/rendezvous"
+	"github.com/developmeh/webrtc-poc/internal/signaling"
+	"github.com/developmeh/webrtc-poc/internal/trace"
+	"github.com/developmeh/webrtc-poc/internal/trickle"
+	"github.com/pion/webrtc/v3"
+)
+
+// Credentials is what a client presents to the server when signaling, what it
+// expects back, and how the signaling messages travel
+type Credentials struct {
+	// AuthToken is sent as a bearer token
+	AuthToken string
+	// Identity signs the signaling requests, if set
+	Identity *identity.Identity
+	// ServerIdentity is the identity the server must prove, if set
+	ServerIdentity string
+	// Noise seals the offer and answer under a Noise handshake
+	Noise bool
+	// Manual is set when the offer and answer are pasted by hand, as QR
+	// codes too if QR is set
+	Manual bool
+	QR     bool
+	// Rendezvous relays the offer to the peer that registered Code
+	Rendezvous *rendezvous.Client
+	Code       string
+	// OfferRole is the peer that makes the offer
+	OfferRole string
+	// Signal, if set, delivers the offer and returns the server's answer
+	// instead of any of the above
+	Signal func(ctx context.Context, offer []byte) ([]byte, error)
+	// HTTPClient sends the signaling requests (default http.DefaultClient)
+	HTTPClient *http.Client
+}
+
+// client returns the HTTP client of the signaling requests
+func (c Credentials) client() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+// sign adds the token and identity assertion to a signaling request
+func (c Credentials) sign(req *http.Request, body []byte) {
+	if c.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AuthToken)
+	}
+	if c.Identity != nil {
+		payload := identity.SignedRequest(req.Method, req.URL.RawQuery, body)
+		req.Header.Set(identity.Header, c.Identity.Sign(identity.RoleClient, payload, time.Now()))
+	}
+}
+
+// verify checks that a signaling response came from the expected server
+func (c Credentials) verify(resp *http.Response, body []byte) error {
+	if c.ServerIdentity == "" {
+		return nil
+	}
+
+	id, err := identity.Verify(resp.Header.Get(identity.Header), identity.RoleServer, body, time.Now())
+	if err != nil {
+		return fmt.Errorf("cannot verify server identity: %w", err)
+	}
+	return c.expect(id)
+}
+
+// expect checks a proven server identity against the expected one
+func (c Credentials) expect(id string) error {
+	if c.ServerIdentity != "" && id != c.ServerIdentity {
+		return fmt.Errorf("%w: server identity %s is not the expected %s", identity.ErrMismatch, id, c.ServerIdentity)
+	}
+	return nil
+}
+
+// carriesQuery reports whether the offer reaches the server with the query of
+// the server URL, which offers relayed through a rendezvous server, pasted
+// by hand or delivered by Signal do not
+func (c Credentials) carriesQuery() bool {
+	return c.Code == "" && !c.Manual && c.Signal == nil
+}
+
+// sendOffer posts the offer to the server and returns its answer, and the
+// session to trickle the candidates to if trickled is set and the server
+// agreed to it
+func sendOffer(ctx context.Context, serverURL string, creds Credentials, offerJSON []byte, trickled bool) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serverURL, strings.NewReader(string(offerJSON)))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create offer request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if trickled {
+		req.Header.Set(trickle.RequestHeader, "1")
+	}
+	creds.sign(req, offerJSON)
+
+	resp, err := creds.client().Do(req)
+	if err != nil {
+		return nil, "", &signaling.Error{Step: "send offer", Err: err}
+	}
+	defer resp.Body.Close()
+
+	// Check HTTP status code
+	if resp.StatusCode == http.StatusGone {
+		return nil, "", errUnknownPush
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		if err := maintenance.CheckResponse(resp, bodyBytes); err != nil {
+			return nil, "", err
+		}
+		return nil, "", signaling.NewStatusError("offer", resp, bodyBytes)
+	}
+
+	// Read the answer
+	answerJSON, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read answer: %w", err)
+	}
+
+	// Log the raw response for debugging
+	logger.Debug("Raw server response: %s", string(answerJSON))
+
+	// Make sure we reached the intended server
+	if err := creds.verify(resp, answerJSON); err != nil {
+		return nil, "", err
+	}
+
+	return answerJSON, resp.Header.Get(trickle.SessionHeader), nil
+}
+
+// answerServerOffer fetches an offer made by the server and sends back the
+// answer of peerConnection. The offer is requested from /server-offer next to
+// serverURL with the same query, so pushes and resumes work the same way.
+func answerServerOffer(ctx context.Context, peerConnection *webrtc.PeerConnection, serverURL string, creds Credentials) error {
+	base, err := url.Parse(serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+	offerURL := base.ResolveReference(&url.URL{Path: "server-offer", RawQuery: base.RawQuery})
+	answerURL := base.ResolveReference(&url.URL{Path: "answer"})
+
+	// Request the offer
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, offerURL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create offer request: %w", err)
+	}
+	creds.sign(req, nil)
+
+	resp, err := creds.client().Do(req)
+	if err != nil {
+		return &signaling.Error{Step: "request offer", Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return errUnknownPush
+	}
+	offerJSON, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read offer: %w", err)
+	}
+	if err := maintenance.CheckResponse(resp, offerJSON); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return signaling.NewStatusError("offer request", resp, offerJSON)
+	}
+	logger.Debug("Raw server offer: %s", string(offerJSON))
+
+	// Make sure the offer came from the intended server
+	if err := creds.verify(resp, offerJSON); err != nil {
+		return err
+	}
+
+	var offer webrtc.SessionDescription
+	if err := json.Unmarshal(offerJSON, &offer); err != nil {
+		return fmt.Errorf("failed to parse offer: %w, raw response: %s", err, string(offerJSON))
+	}
+	trace.Description(trace.SessionOf(peerConnection), trace.Recv, offer)
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		return fmt.Errorf("failed to set remote description: %w", err)
+	}
+
+	// Create the answer
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create answer: %w", err)
+	}
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		return fmt.Errorf("failed to set local description: %w", err)
+	}
+
+	// Wait for ICE gathering to complete
+	if err := awaitGathering(ctx, peerConnection); err != nil {
+		return err
+	}
+
+	trace.Description(trace.SessionOf(peerConnection), trace.Send, *peerConnection.LocalDescription())
+	answerJSON, err := json.Marshal(*peerConnection.LocalDescription())
+	if err != nil {
+		return fmt.Errorf("failed to marshal answer: %w", err)
+	}
+
+	// Send the answer back for the server's pending offer
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, answerURL.String(), bytes.NewReader(answerJSON))
+	if err != nil {
+		return fmt.Errorf("failed to create answer request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signaling.OfferSessionHeader, resp.Header.Get(signaling.OfferSessionHeader))
+	creds.sign(req, answerJSON)
+
+	answerResp, err := creds.client().Do(req)
+	if err != nil {
+		return &signaling.Error{Step: "send answer", Err: err}
+	}
+	defer answerResp.Body.Close()
+
+	if answerResp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(answerResp.Body)
+		return signaling.NewStatusError("answer", answerResp, body)
+	}
+	return nil
+}
+
+// sendSealedOffer runs a Noise handshake with the server and exchanges the
+// offer and answer encrypted under it. The handshake proves both identities,
+// so the server's identity is checked against the expected one directly.
+func sendSealedOffer(ctx context.Context, serverURL string, creds Credentials, offerJSON []byte) ([]byte, error) {
+	base, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
+	}
+	noiseURL := base.ResolveReference(&url.URL{Path: "noise"}).String()
+
+	suite := fips.NoiseSuite()
+	initiator, err := noise.NewInitiator(suite, creds.Identity, noise.Prologue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start Noise handshake: %w", err)
+	}
+
+	// Send the first handshake message
+	start := initiator.Start()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, noiseURL, bytes.NewReader(start))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create handshake request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(noise.ProtocolHeader, suite.Name())
+	creds.sign(req, start)
+
+	reply, sessionID, err := postSignaling(creds, req)
+	if err != nil {
+		return nil, fmt.Errorf("noise handshake failed: %w", err)
+	}
+
+	// Finish the handshake and make sure we reached the intended server
+	msg, session, err := initiator.Finish(reply)
+	if err != nil {
+		return nil, fmt.Errorf("noise handshake failed: %w", err)
+	}
+	if err := creds.expect(session.PeerIdentity); err != nil {
+		return nil, err
+	}
+	logger.Info("Noise handshake complete with server identity %s", session.PeerIdentity)
+
+	// Send the last handshake message along with the sealed offer
+	body := noise.Join(msg, session.Seal(offerJSON))
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, serverURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create offer request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(noise.SessionHeader, sessionID)
+	creds.sign(req, body)
+
+	sealed, _, err := postSignaling(creds, req)
+	if err != nil {
+		return nil, err
+	}
+	answerJSON, err := session.Open(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt answer: %w", err)
+	}
+	return answerJSON, nil
+}
+
+// rendezvousTimeout is how long a client waits for the answer to an offer
+// sent through a rendezvous server
+const rendezvousTimeout = 2 * time.Minute
+
+// SendRelayedOffer sends the offer through a rendezvous server to the peer
+// that registered the code and returns its answer
+func SendRelayedOffer(ctx context.Context, creds Credentials, offerJSON []byte) ([]byte, error) {
+	blob, err := signRelayed(creds, offerJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, rendezvousTimeout)
+	defer cancel()
+	reply, err := creds.Rendezvous.Exchange(ctx, creds.Code, blob)
+	if errors.Is(err, rendezvous.ErrUnknownCode) {
+		return nil, fmt.Errorf("%w %s, it has expired or was never registered", rendezvous.ErrUnknownCode, creds.Code)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange offer through rendezvous server: %w", err)
+	}
+	return openRelayed(creds, reply)
+}
+
+// pasteOffer prints the signed offer for the operator to paste into a
+// server started with --signal manual or qr, and reads the answer pasted back
+// until ctx ends
+func pasteOffer(ctx context.Context, creds Credentials, offerJSON []byte) ([]byte, error) {
+	blob, err := signRelayed(creds, offerJSON)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Paste this offer into the server started with --signal manual or qr:")
+	if err := paste.Print(os.Stdout, paste.Offer, blob, creds.QR); err != nil {
+		return nil, err
+	}
+	logger.Info("Paste the server's answer here:")
+
+	// Reading stdin cannot be interrupted, so it is left behind if ctx ends
+	type pasted struct {
+		reply []byte
+		err   error
+	}
+	read := make(chan pasted, 1)
+	go func() {
+		reply, err := paste.NewReader(os.Stdin).Read(paste.Answer)
+		read <- pasted{reply, err}
+	}()
+	var p pasted
+	select {
+	case p = <-read:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no answer was pasted: %w", context.Cause(ctx))
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("failed to read the pasted answer: %w", p.err)
+	}
+	return openRelayed(creds, p.reply)
+}
+
+// signRelayed wraps an offer sent through a third party with the client's
+// identity assertion
+func signRelayed(creds Credentials, offerJSON []byte) ([]byte, error) {
+	blob, err := json.Marshal(signaling.Relayed{
+		SDP:      offerJSON,
+		Identity: creds.Identity.Sign(identity.RoleClient, offerJSON, time.Now()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode offer: %w", err)
+	}
+	return blob, nil
+}
+
+// openRelayed unwraps an answer sent through a third party, checking that it
+// came from the intended server
+func openRelayed(creds Credentials, reply []byte) ([]byte, error) {
+	var relayed signaling.Relayed
+	if err := json.Unmarshal(reply, &relayed); err != nil {
+		return nil, fmt.Errorf("failed to parse relayed answer: %w", err)
+	}
+
+	// Make sure the answer came from the intended server, not the relay
+	if creds.ServerIdentity != "" {
+		id, err := identity.Verify(relayed.Identity, identity.RoleServer, relayed.SDP, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("cannot verify server identity: %w", err)
+		}
+		if err := creds.expect(id); err != nil {
+			return nil, err
+		}
+	}
+	return relayed.SDP, nil
+}
+
+// postSignaling sends a Noise signaling request and returns the response body
+// and the Noise session it belongs to
+func postSignaling(creds Credentials, req *http.Request) ([]byte, string, error) {
+	resp, err := creds.client().Do(req)
+	if err != nil {
+		return nil, "", &signaling.Error{Step: "send signaling request", Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return nil, "", errUnknownPush
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if err := maintenance.CheckResponse(resp, body); err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", signaling.NewStatusError("signaling request", resp, body)
+	}
+	return body, resp.Header.Get(noise.SessionHeader), nil
+}
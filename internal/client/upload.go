@@ -0,0 +1,143 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/control"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/sctperr"
+	"github.com/developmeh/webrtc-poc/internal/share"
+	"github.com/developmeh/webrtc-poc/internal/upload"
+	"github.com/pion/webrtc/v3"
+)
+
+// Uploads wait while more than uploadBufferHigh bytes are queued on their
+// channel, until the queue shrinks below uploadBufferLow
+const (
+	uploadBufferHigh = 1 << 20
+	uploadBufferLow  = 256 << 10
+)
+
+// uploadFiles uploads files to the server over the existing connection, one
+// after the other, and returns how many failed
+func uploadFiles(peerConnection *webrtc.PeerConnection, paths []string) int {
+	failures := 0
+	for _, path := range paths {
+		start := time.Now()
+		result, err := uploadFile(peerConnection, path)
+		if err != nil {
+			logger.Error("Failed to upload %s: %v", path, err)
+			failures++
+			continue
+		}
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if len(result.Files) == 1 && result.Files[0] != filepath.Base(path) {
+			logger.Info("Uploaded %s as %s, %d lines in %v", path, result.Files[0], result.Lines, elapsed)
+			continue
+		}
+		logger.Info("Uploaded %s, %d lines in %v", path, result.Lines, elapsed)
+	}
+	return failures
+}
+
+// uploadFile sends the lines of a file on a new data channel named after it,
+// followed by a Result with their number, and waits for the server to
+// accept or reject the file
+func uploadFile(peerConnection *webrtc.PeerConnection, path string) (share.Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return share.Result{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	protocol := upload.Protocol
+	request, err := peerConnection.CreateDataChannel(filepath.Base(path), &webrtc.DataChannelInit{Protocol: &protocol})
+	if err != nil {
+		return share.Result{}, fmt.Errorf("failed to create data channel: %w", err)
+	}
+	defer request.Close()
+
+	// None of the callbacks may block
+	opened := make(chan struct{})
+	closed := make(chan struct{})
+	results := make(chan []byte, 1)
+	low := make(chan struct{}, 1)
+	request.OnOpen(func() { close(opened) })
+	request.OnClose(func() { close(closed) })
+	request.OnMessage(func(msg webrtc.DataChannelMessage) {
+		select {
+		case results <- msg.Data:
+		default:
+		}
+	})
+	request.SetBufferedAmountLowThreshold(uploadBufferLow)
+	request.OnBufferedAmountLow(func() {
+		select {
+		case low <- struct{}{}:
+		default:
+		}
+	})
+
+	select {
+	case <-opened:
+	case <-closed:
+		return share.Result{}, errors.New("channel closed before it opened")
+	}
+
+	// The server may reject the upload before it is complete, so its result
+	// takes precedence over any error. Lines still queued are sent before
+	// the channel is closed, since closing resets the stream under them.
+	sent := 0
+	rejected := func(data []byte) (share.Result, error) {
+		control.Drain(request, closed, upload.Linger)
+		return share.CheckResult(data, sent)
+	}
+	answer := func(err error) (share.Result, error) {
+		select {
+		case data := <-results:
+			return share.CheckResult(data, sent)
+		default:
+			return share.Result{}, err
+		}
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		select {
+		case data := <-results:
+			return rejected(data)
+		default:
+		}
+		for request.BufferedAmount() > uploadBufferHigh {
+			select {
+			case <-low:
+			case data := <-results:
+				return rejected(data)
+			case <-closed:
+				return answer(fmt.Errorf("channel closed after %d lines", sent))
+			}
+		}
+		if err := request.SendText(scanner.Text()); err != nil {
+			return answer(fmt.Errorf("failed to send line %d: %w", sent+1, sctperr.Wrap(err)))
+		}
+		sent++
+	}
+	if err := scanner.Err(); err != nil {
+		return share.Result{}, fmt.Errorf("error reading file: %w", err)
+	}
+	if err := request.Send(share.Result{Lines: sent}.Encode()); err != nil {
+		return answer(fmt.Errorf("failed to finish upload: %w", err))
+	}
+
+	select {
+	case data := <-results:
+		return share.CheckResult(data, sent)
+	case <-closed:
+		return answer(errors.New("channel closed without a result"))
+	}
+}
@@ -1,6 +1,15 @@
 package cmd
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/developmeh/webrtc-poc/internal/client"
+	"github.com/developmeh/webrtc-poc/internal/config"
+	"github.com/developmeh/webrtc-poc/internal/flags"
+	"github.com/developmeh/webrtc-poc/internal/logger"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -9,7 +18,7 @@ var (
 	// Client command flags
 	clientServer string
 	clientOutput string
-	clientStun   string
+	clientICE    []string
 )
 
 // ClientCmd represents the client command
@@ -27,14 +36,35 @@ func init() {
 	// Client flags
 	ClientCmd.Flags().StringVar(&clientServer, "server", "http://localhost:8080/offer", "WebRTC server URL")
 	ClientCmd.Flags().StringVar(&clientOutput, "output", "", "Output file (leave empty for stdout)")
-	ClientCmd.Flags().StringVar(&clientStun, "stun", "", "STUN server address (leave empty for direct connection)")
+	ClientCmd.Flags().StringArrayVar(&clientICE, "ice-server", nil, "ICE server URL, repeatable (stun:host:port or turn:user:pass@host:port?transport=tcp; leave empty for direct connection)")
+
+	// Keep the old single --stun flag working for existing scripts
+	flags.MustDeprecate(ClientCmd.Flags(), "stun", "ice-server")
 
 	// Bind flags to viper
 	viper.BindPFlag("client.server", ClientCmd.Flags().Lookup("server"))
 	viper.BindPFlag("client.output", ClientCmd.Flags().Lookup("output"))
-	viper.BindPFlag("client.stun", ClientCmd.Flags().Lookup("stun"))
+	viper.BindPFlag("client.ice_servers", ClientCmd.Flags().Lookup("ice-server"))
 }
 
 func runClient() {
-	// This will be implemented later by refactoring the existing client code
-}
\ No newline at end of file
+	cfg := client.Config{
+		ServerURL: viper.GetString("client.server"),
+		Output:    viper.GetString("client.output"),
+		StopAtEnd: true,
+	}
+	iceServers, err := config.ParseICEServers(config.ICEServerSpecs(viper.GetStringSlice("client.ice_servers"), viper.GetString("client.stun")))
+	if err != nil {
+		logger.Error("Invalid ICE server: %v", err)
+		os.Exit(1)
+	}
+	cfg.ICEServers = iceServers
+
+	logger.Info("Starting WebRTC file streaming client")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := client.Run(ctx, cfg); err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+}
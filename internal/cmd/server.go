@@ -1,6 +1,15 @@
 package cmd
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/developmeh/webrtc-poc/internal/config"
+	"github.com/developmeh/webrtc-poc/internal/flags"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/server"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -10,7 +19,8 @@ var (
 	serverAddr  string
 	serverFile  string
 	serverDelay int
-	stunServer  string
+	serverRate  string
+	serverICE   []string
 )
 
 // serverCmd represents the server command
@@ -28,16 +38,51 @@ func init() {
 	// Server flags
 	ServerCmd.Flags().StringVar(&serverAddr, "addr", ":8080", "HTTP service address")
 	ServerCmd.Flags().StringVar(&serverFile, "file", "sample.txt", "File to stream")
-	ServerCmd.Flags().IntVar(&serverDelay, "delay", 1000, "Delay between lines in milliseconds")
-	ServerCmd.Flags().StringVar(&stunServer, "stun", "", "STUN server address (leave empty for direct connection)")
+	ServerCmd.Flags().IntVar(&serverDelay, "delay", 1000, "Delay between lines in milliseconds (legacy, see --rate)")
+	ServerCmd.Flags().StringVar(&serverRate, "rate", "", "Rate to send at in bytes or lines per second, e.g. 1MB/s or 100lines/s, overriding --delay")
+	ServerCmd.Flags().StringArrayVar(&serverICE, "ice-server", nil, "ICE server URL, repeatable (stun:host:port or turn:user:pass@host:port?transport=tcp; leave empty for direct connection)")
+
+	// Keep the old single --stun flag working for existing scripts
+	flags.MustDeprecate(ServerCmd.Flags(), "stun", "ice-server")
 
 	// Bind flags to viper
 	viper.BindPFlag("server.addr", ServerCmd.Flags().Lookup("addr"))
 	viper.BindPFlag("server.file", ServerCmd.Flags().Lookup("file"))
 	viper.BindPFlag("server.delay", ServerCmd.Flags().Lookup("delay"))
-	viper.BindPFlag("server.stun", ServerCmd.Flags().Lookup("stun"))
+	viper.BindPFlag("server.rate", ServerCmd.Flags().Lookup("rate"))
+	viper.BindPFlag("server.ice_servers", ServerCmd.Flags().Lookup("ice-server"))
 }
 
 func runServer() {
-	// This will be implemented later by refactoring the existing server code
+	cfg := server.Config{
+		Addr:    viper.GetString("server.addr"),
+		File:    viper.GetString("server.file"),
+		DelayMs: viper.GetInt("server.delay"),
+	}
+	iceServers, err := config.ParseICEServers(config.ICEServerSpecs(viper.GetStringSlice("server.ice_servers"), viper.GetString("server.stun")))
+	if err != nil {
+		logger.Error("Invalid ICE server: %v", err)
+		os.Exit(1)
+	}
+	cfg.ICEServers = iceServers
+	if rate := viper.GetString("server.rate"); rate != "" {
+		if cfg.Rate, err = server.ParseRate(rate); err != nil {
+			logger.Error("Invalid --rate: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	logger.Info("Starting WebRTC file streaming server on %s", cfg.Addr)
+	if cfg.Rate.PerSecond > 0 {
+		logger.Info("Will stream file: %s at %s", cfg.File, viper.GetString("server.rate"))
+	} else {
+		logger.Info("Will stream file: %s with delay: %dms", cfg.File, cfg.DelayMs)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := server.Run(ctx, cfg); err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,59 @@
+// Package cmdline splits the command lines of settings such as --exec into
+// arguments, quoted like in a POSIX shell but without expanding anything
+package cmdline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Split splits a command line into arguments at unquoted whitespace.
+// Single quotes keep their content as is, double quotes and backslashes
+// escape the next character as in a POSIX shell.
+func Split(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\' && i+1 < len(runes) && (quote == 0 || strings.ContainsRune(`"\$`+"`", runes[i+1])):
+			i++
+			arg.WriteRune(runes[i])
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, command)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,35 @@
+package cmdline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := map[string][]string{
+		"tar -x -C out":            {"tar", "-x", "-C", "out"},
+		"  psql   -d logs ":        {"psql", "-d", "logs"},
+		`sh -c 'cat > "out file"'`: {"sh", "-c", `cat > "out file"`},
+		`grep "a \"b\" c" x`:       {"grep", `a "b" c`, "x"},
+		`echo a\ b ''`:             {"echo", "a b", ""},
+		`printf '%s\n' "$HOME"`:    {"printf", `%s\n`, "$HOME"},
+		`echo \'quoted\'`:          {"echo", "'quoted'"},
+		"":                         nil,
+	}
+	for command, want := range tests {
+		got, err := Split(command)
+		if err != nil {
+			t.Errorf("Split(%q) returned error: %v", command, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") || len(got) != len(want) {
+			t.Errorf("Expected %q for %q, got %q", want, command, got)
+		}
+	}
+
+	for _, command := range []string{`echo 'open`, `echo "open`} {
+		if _, err := Split(command); err == nil {
+			t.Errorf("Expected an error for %q", command)
+		}
+	}
+}
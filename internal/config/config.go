@@ -16,21 +16,174 @@ type Config struct {
 
 // ServerConfig represents the server configuration
 type ServerConfig struct {
-	Addr  string
+	Addr              string
+	File              string
+	Delay             int
+	Rate              string
+	Stun              string
+	ICEServers        []string `mapstructure:"ice_servers"`
+	AutoStun          bool     `mapstructure:"auto_stun"`
+	NoInternet        bool     `mapstructure:"no_internet"`
+	AuthToken         string   `mapstructure:"auth_token"`
+	AdaptivePacing    bool     `mapstructure:"adaptive_pacing"`
+	CompleteBy        string   `mapstructure:"complete_by"`
+	PacingWindows     []string `mapstructure:"pacing_windows"`
+	Schedules         []ScheduleConfig
+	Exports           []ExportConfig
+	Peers             []string
+	IdentityFile      string   `mapstructure:"identity_file"`
+	AllowedIdentities []string `mapstructure:"allowed_identities"`
+	RequireNoise      bool     `mapstructure:"require_noise"`
+	Rendezvous        string
+	Interceptors      []string
+	ChannelLabel      string   `mapstructure:"channel_label"`
+	ChannelProtocol   string   `mapstructure:"channel_protocol"`
+	ChannelID         uint16   `mapstructure:"channel_id"`
+	Negotiated        bool     `mapstructure:"negotiated_channel"`
+	OfferRole         string   `mapstructure:"offer_role"`
+	Signal            string   `mapstructure:"signal"`
+	ShareDir          string   `mapstructure:"share_dir"`
+	ChunkIndex        string   `mapstructure:"chunk_index"`
+	MMap              bool     `mapstructure:"mmap"`
+	ReadAhead         string   `mapstructure:"read_ahead"`
+	Length            string   `mapstructure:"length"`
+	Raw               bool     `mapstructure:"raw"`
+	Forward           string   `mapstructure:"forward"`
+	AllowTunnels      []string `mapstructure:"allow_tunnels"`
+	Unreliable        bool
+	FEC               string   `mapstructure:"fec"`
+	DuplicatePolicy   string   `mapstructure:"duplicate_policy"`
+	ControlToken      string   `mapstructure:"control_token"`
+	UploadDir         string   `mapstructure:"upload_dir"`
+	QuarantineDir     string   `mapstructure:"quarantine_dir"`
+	UploadMaxSize     string   `mapstructure:"upload_max_size"`
+	UploadTypes       []string `mapstructure:"upload_types"`
+	UploadScanner     string   `mapstructure:"upload_scanner"`
+	UploadCollision   string   `mapstructure:"upload_collision"`
+	UploadPerIdentity bool     `mapstructure:"upload_per_identity"`
+	FIPS              bool     `mapstructure:"fips"`
+	FollowSymlinks    bool     `mapstructure:"follow_symlinks"`
+	Timestamps        bool     `mapstructure:"timestamps"`
+	LowLatency        bool     `mapstructure:"low_latency"`
+	SendRetries       int      `mapstructure:"send_retries"`
+	SendRetryBackoff  string   `mapstructure:"send_retry_backoff"`
+	Batch             int
+	BatchBytes        string `mapstructure:"batch_bytes"`
+	Workers           int
+	MemoryLimit       string `mapstructure:"memory_limit"`
+	DebugSocket       string `mapstructure:"debug_socket"`
+	Checksums         []string
+	Announce          bool
+	AnnounceName      string `mapstructure:"announce_name"`
+	RemoteLogs        bool   `mapstructure:"remote_logs"`
+	Trace             string
+	MaxRecordSize     string `mapstructure:"max_record_size"`
+	Oversized         string
+	IdleTimeout       string `mapstructure:"idle_timeout"`
+	MaxConnections    int    `mapstructure:"max_connections"`
+	MaxPerIP          int    `mapstructure:"max_sessions_per_ip"`
+	MaxPerIdentity    int    `mapstructure:"max_sessions_per_identity"`
+	SessionCooldown   string `mapstructure:"session_cooldown"`
+	StallTimeout      string `mapstructure:"stall_timeout"`
+	StallPolicy       string `mapstructure:"stall_policy"`
+	TUI               bool
+	Broadcast         bool
+	Follow            bool
+	Retention         string
+	GroupOffsets      string   `mapstructure:"group_offsets"`
+	RetentionDir      string   `mapstructure:"retention_dir"`
+	WebUI             bool     `mapstructure:"web_ui"`
+	CORSOrigins       []string `mapstructure:"cors_origins"`
+}
+
+// ScheduleConfig pushes a file to daemon mode clients on a cron schedule
+type ScheduleConfig struct {
+	Cron  string
 	File  string
-	Delay int
-	Stun  string
+	Peers []string
+}
+
+// ExportConfig is a file, directory or glob pattern clients request by name
+type ExportConfig struct {
+	Name  string
+	Path  string
+	Mode  string
+	Allow []string
+	Rate  string
+	Watch bool
 }
 
 // ClientConfig represents the client configuration
 type ClientConfig struct {
-	Server string
-	Output string
-	Stun   string
+	Server          string
+	Output          string
+	Stun            string
+	ICEServers      []string `mapstructure:"ice_servers"`
+	AutoStun        bool     `mapstructure:"auto_stun"`
+	NoInternet      bool     `mapstructure:"no_internet"`
+	MetricsAddr     string   `mapstructure:"metrics_addr"`
+	AuthToken       string   `mapstructure:"auth_token"`
+	Daemon          bool
+	Name            string
+	Filter          string
+	StateFile       string `mapstructure:"state_file"`
+	EncryptState    bool   `mapstructure:"encrypt_state"`
+	StateKey        string `mapstructure:"state_key"`
+	IdentityFile    string `mapstructure:"identity_file"`
+	ServerIdentity  string `mapstructure:"server_identity"`
+	Noise           bool
+	Rendezvous      string
+	Code            string
+	Interceptors    []string
+	ChannelLabel    string   `mapstructure:"channel_label"`
+	ChannelProtocol string   `mapstructure:"channel_protocol"`
+	ChannelID       uint16   `mapstructure:"channel_id"`
+	Negotiated      bool     `mapstructure:"negotiated_channel"`
+	OfferRole       string   `mapstructure:"offer_role"`
+	Signal          string   `mapstructure:"signal"`
+	RequestFiles    []string `mapstructure:"request_files"`
+	OutputDir       string   `mapstructure:"output_dir"`
+	FetchList       string   `mapstructure:"fetch_list"`
+	FetchParallel   int      `mapstructure:"fetch_parallel"`
+	DedupCache      string   `mapstructure:"dedup_cache"`
+	Exec            string   `mapstructure:"exec"`
+	ExecRestart     string   `mapstructure:"exec_restart"`
+	ExecMaxRestarts int      `mapstructure:"exec_max_restarts"`
+	Forward         string   `mapstructure:"forward"`
+	UploadFiles     []string `mapstructure:"upload_files"`
+	FIPS            bool     `mapstructure:"fips"`
+	FollowSymlinks  bool     `mapstructure:"follow_symlinks"`
+	OutputTemplate  string   `mapstructure:"output_template"`
+	Roll            string   `mapstructure:"roll"`
+	Heartbeat       string   `mapstructure:"heartbeat_interval"`
+	WriteRate       string   `mapstructure:"write_rate"`
+	WriteBuffer     string   `mapstructure:"write_buffer"`
+	ReceiveWindow   int      `mapstructure:"receive_window"`
+	SinceSeq        int      `mapstructure:"since_seq"`
+	TrickleICE      bool     `mapstructure:"trickle_ice"`
+	ConnectTimeout  string   `mapstructure:"connect_timeout"`
+	Since           string
+	Checksums       []string
+	Export          string
+	UpdateURL       string `mapstructure:"update_url"`
+	UpdateKey       string `mapstructure:"update_key"`
+	RemoteLogs      bool   `mapstructure:"remote_logs"`
+	Trace           string
+	MaxRecordSize   string `mapstructure:"max_record_size"`
+	Oversized       string
+	TUI             bool
+	Group           string
+	Batch           bool
 }
 
 // LoadConfig loads the configuration from the specified file
 func LoadConfig(configFile string) (*Config, error) {
+	return LoadConfigProfile(configFile, "")
+}
+
+// LoadConfigProfile loads the configuration from the specified file and
+// overlays the named profile on top of the base settings
+func LoadConfigProfile(configFile, profile string) (*Config, error) {
 	v := viper.New()
 
 	// Set default configuration values
@@ -56,6 +209,21 @@ func LoadConfig(configFile string) (*Config, error) {
 		// Config file not found, using defaults
 	} else {
 		fmt.Println("Using config file:", v.ConfigFileUsed())
+
+		// Reject unknown keys and type errors instead of silently using defaults
+		if err := ValidateFile(v.ConfigFileUsed()); err != nil {
+			return nil, err
+		}
+	}
+
+	// Overlay the selected profile
+	if err := ApplyProfile(v, profile); err != nil {
+		return nil, err
+	}
+
+	// Resolve env:, file:, exec: and keyring: references
+	if err := ResolveSecrets(v); err != nil {
+		return nil, err
 	}
 
 	// Parse the config
@@ -67,6 +235,25 @@ func LoadConfig(configFile string) (*Config, error) {
 	return &config, nil
 }
 
+// ApplyProfile merges the settings of profiles.<name> over the base settings
+// held by v. Values set through flags keep precedence over the profile.
+func ApplyProfile(v *viper.Viper, profile string) error {
+	if profile == "" {
+		return nil
+	}
+
+	key := "profiles." + profile
+	if !v.IsSet(key) {
+		return fmt.Errorf("profile %q not found in config", profile)
+	}
+
+	if err := v.MergeConfigMap(v.GetStringMap(key)); err != nil {
+		return fmt.Errorf("error applying profile %q: %w", profile, err)
+	}
+
+	return nil
+}
+
 // SaveConfig saves the configuration to the specified file
 func SaveConfig(config *Config, configFile string) error {
 	v := viper.New()
@@ -75,10 +262,140 @@ func SaveConfig(config *Config, configFile string) error {
 	v.Set("server.addr", config.Server.Addr)
 	v.Set("server.file", config.Server.File)
 	v.Set("server.delay", config.Server.Delay)
+	v.Set("server.rate", config.Server.Rate)
 	v.Set("server.stun", config.Server.Stun)
+	v.Set("server.ice_servers", config.Server.ICEServers)
+	v.Set("server.auto_stun", config.Server.AutoStun)
+	v.Set("server.no_internet", config.Server.NoInternet)
+	v.Set("server.auth_token", config.Server.AuthToken)
+	v.Set("server.adaptive_pacing", config.Server.AdaptivePacing)
+	v.Set("server.complete_by", config.Server.CompleteBy)
+	v.Set("server.pacing_windows", config.Server.PacingWindows)
+	v.Set("server.schedules", scheduleMaps(config.Server.Schedules))
+	v.Set("server.exports", exportMaps(config.Server.Exports))
+	v.Set("server.peers", config.Server.Peers)
+	v.Set("server.identity_file", config.Server.IdentityFile)
+	v.Set("server.allowed_identities", config.Server.AllowedIdentities)
+	v.Set("server.require_noise", config.Server.RequireNoise)
+	v.Set("server.rendezvous", config.Server.Rendezvous)
+	v.Set("server.interceptors", config.Server.Interceptors)
+	v.Set("server.channel_label", config.Server.ChannelLabel)
+	v.Set("server.channel_protocol", config.Server.ChannelProtocol)
+	v.Set("server.channel_id", config.Server.ChannelID)
+	v.Set("server.negotiated_channel", config.Server.Negotiated)
+	v.Set("server.offer_role", config.Server.OfferRole)
+	v.Set("server.signal", config.Server.Signal)
+	v.Set("server.share_dir", config.Server.ShareDir)
+	v.Set("server.chunk_index", config.Server.ChunkIndex)
+	v.Set("server.mmap", config.Server.MMap)
+	v.Set("server.read_ahead", config.Server.ReadAhead)
+	v.Set("server.length", config.Server.Length)
+	v.Set("server.raw", config.Server.Raw)
+	v.Set("server.forward", config.Server.Forward)
+	v.Set("server.allow_tunnels", config.Server.AllowTunnels)
+	v.Set("server.unreliable", config.Server.Unreliable)
+	v.Set("server.fec", config.Server.FEC)
+	v.Set("server.duplicate_policy", config.Server.DuplicatePolicy)
+	v.Set("server.control_token", config.Server.ControlToken)
+	v.Set("server.upload_dir", config.Server.UploadDir)
+	v.Set("server.quarantine_dir", config.Server.QuarantineDir)
+	v.Set("server.upload_max_size", config.Server.UploadMaxSize)
+	v.Set("server.upload_types", config.Server.UploadTypes)
+	v.Set("server.upload_scanner", config.Server.UploadScanner)
+	v.Set("server.upload_collision", config.Server.UploadCollision)
+	v.Set("server.upload_per_identity", config.Server.UploadPerIdentity)
+	v.Set("server.fips", config.Server.FIPS)
+	v.Set("server.follow_symlinks", config.Server.FollowSymlinks)
+	v.Set("server.timestamps", config.Server.Timestamps)
+	v.Set("server.low_latency", config.Server.LowLatency)
+	v.Set("server.send_retries", config.Server.SendRetries)
+	v.Set("server.send_retry_backoff", config.Server.SendRetryBackoff)
+	v.Set("server.batch", config.Server.Batch)
+	v.Set("server.batch_bytes", config.Server.BatchBytes)
+	v.Set("server.workers", config.Server.Workers)
+	v.Set("server.memory_limit", config.Server.MemoryLimit)
+	v.Set("server.debug_socket", config.Server.DebugSocket)
+	v.Set("server.checksums", config.Server.Checksums)
+	v.Set("server.announce", config.Server.Announce)
+	v.Set("server.announce_name", config.Server.AnnounceName)
+	v.Set("server.remote_logs", config.Server.RemoteLogs)
+	v.Set("server.trace", config.Server.Trace)
+	v.Set("server.max_record_size", config.Server.MaxRecordSize)
+	v.Set("server.oversized", config.Server.Oversized)
+	v.Set("server.idle_timeout", config.Server.IdleTimeout)
+	v.Set("server.max_connections", config.Server.MaxConnections)
+	v.Set("server.max_sessions_per_ip", config.Server.MaxPerIP)
+	v.Set("server.max_sessions_per_identity", config.Server.MaxPerIdentity)
+	v.Set("server.session_cooldown", config.Server.SessionCooldown)
+	v.Set("server.stall_timeout", config.Server.StallTimeout)
+	v.Set("server.stall_policy", config.Server.StallPolicy)
+	v.Set("server.tui", config.Server.TUI)
+	v.Set("server.broadcast", config.Server.Broadcast)
+	v.Set("server.follow", config.Server.Follow)
+	v.Set("server.group_offsets", config.Server.GroupOffsets)
+	v.Set("server.retention", config.Server.Retention)
+	v.Set("server.retention_dir", config.Server.RetentionDir)
+	v.Set("server.web_ui", config.Server.WebUI)
+	v.Set("server.cors_origins", config.Server.CORSOrigins)
 	v.Set("client.server", config.Client.Server)
 	v.Set("client.output", config.Client.Output)
 	v.Set("client.stun", config.Client.Stun)
+	v.Set("client.ice_servers", config.Client.ICEServers)
+	v.Set("client.auto_stun", config.Client.AutoStun)
+	v.Set("client.no_internet", config.Client.NoInternet)
+	v.Set("client.metrics_addr", config.Client.MetricsAddr)
+	v.Set("client.auth_token", config.Client.AuthToken)
+	v.Set("client.daemon", config.Client.Daemon)
+	v.Set("client.name", config.Client.Name)
+	v.Set("client.filter", config.Client.Filter)
+	v.Set("client.state_file", config.Client.StateFile)
+	v.Set("client.encrypt_state", config.Client.EncryptState)
+	v.Set("client.state_key", config.Client.StateKey)
+	v.Set("client.identity_file", config.Client.IdentityFile)
+	v.Set("client.server_identity", config.Client.ServerIdentity)
+	v.Set("client.noise", config.Client.Noise)
+	v.Set("client.rendezvous", config.Client.Rendezvous)
+	v.Set("client.code", config.Client.Code)
+	v.Set("client.interceptors", config.Client.Interceptors)
+	v.Set("client.channel_label", config.Client.ChannelLabel)
+	v.Set("client.channel_protocol", config.Client.ChannelProtocol)
+	v.Set("client.channel_id", config.Client.ChannelID)
+	v.Set("client.negotiated_channel", config.Client.Negotiated)
+	v.Set("client.offer_role", config.Client.OfferRole)
+	v.Set("client.signal", config.Client.Signal)
+	v.Set("client.request_files", config.Client.RequestFiles)
+	v.Set("client.output_dir", config.Client.OutputDir)
+	v.Set("client.fetch_list", config.Client.FetchList)
+	v.Set("client.fetch_parallel", config.Client.FetchParallel)
+	v.Set("client.dedup_cache", config.Client.DedupCache)
+	v.Set("client.exec", config.Client.Exec)
+	v.Set("client.exec_restart", config.Client.ExecRestart)
+	v.Set("client.exec_max_restarts", config.Client.ExecMaxRestarts)
+	v.Set("client.forward", config.Client.Forward)
+	v.Set("client.upload_files", config.Client.UploadFiles)
+	v.Set("client.fips", config.Client.FIPS)
+	v.Set("client.follow_symlinks", config.Client.FollowSymlinks)
+	v.Set("client.output_template", config.Client.OutputTemplate)
+	v.Set("client.roll", config.Client.Roll)
+	v.Set("client.heartbeat_interval", config.Client.Heartbeat)
+	v.Set("client.write_rate", config.Client.WriteRate)
+	v.Set("client.write_buffer", config.Client.WriteBuffer)
+	v.Set("client.receive_window", config.Client.ReceiveWindow)
+	v.Set("client.trickle_ice", config.Client.TrickleICE)
+	v.Set("client.connect_timeout", config.Client.ConnectTimeout)
+	v.Set("client.checksums", config.Client.Checksums)
+	v.Set("client.export", config.Client.Export)
+	v.Set("client.update_url", config.Client.UpdateURL)
+	v.Set("client.update_key", config.Client.UpdateKey)
+	v.Set("client.remote_logs", config.Client.RemoteLogs)
+	v.Set("client.trace", config.Client.Trace)
+	v.Set("client.max_record_size", config.Client.MaxRecordSize)
+	v.Set("client.oversized", config.Client.Oversized)
+	v.Set("client.tui", config.Client.TUI)
+	v.Set("client.group", config.Client.Group)
+	v.Set("client.since", config.Client.Since)
+	v.Set("client.since_seq", config.Client.SinceSeq)
+	v.Set("client.batch", config.Client.Batch)
 
 	// Create the directory if it doesn't exist
 	dir := filepath.Dir(configFile)
@@ -97,16 +414,176 @@ func SaveConfig(config *Config, configFile string) error {
 	return nil
 }
 
+// scheduleMaps converts schedules to plain maps so every config format writes
+// them with the same keys they are read with
+func scheduleMaps(schedules []ScheduleConfig) []map[string]interface{} {
+	maps := make([]map[string]interface{}, 0, len(schedules))
+	for _, s := range schedules {
+		maps = append(maps, map[string]interface{}{"cron": s.Cron, "file": s.File, "peers": s.Peers})
+	}
+	return maps
+}
+
+// exportMaps converts exports to plain maps like scheduleMaps
+func exportMaps(exports []ExportConfig) []map[string]interface{} {
+	maps := make([]map[string]interface{}, 0, len(exports))
+	for _, e := range exports {
+		maps = append(maps, map[string]interface{}{
+			"name": e.Name, "path": e.Path, "mode": e.Mode, "allow": e.Allow, "rate": e.Rate, "watch": e.Watch,
+		})
+	}
+	return maps
+}
+
+// ICEServerSpecs returns the ICE server specifications of a config section,
+// including the legacy single stun setting
+func ICEServerSpecs(specs []string, stun string) []string {
+	if stun == "" {
+		return specs
+	}
+	return append([]string{stun}, specs...)
+}
+
 // setDefaults sets the default configuration values
 func setDefaults(v *viper.Viper) {
 	// Server defaults
 	v.SetDefault("server.addr", ":8080")
 	v.SetDefault("server.file", "sample.txt")
 	v.SetDefault("server.delay", 1000)
+	v.SetDefault("server.rate", "")
 	v.SetDefault("server.stun", "")
+	v.SetDefault("server.ice_servers", []string{})
+	v.SetDefault("server.auto_stun", false)
+	v.SetDefault("server.no_internet", false)
+	v.SetDefault("server.auth_token", "")
+	v.SetDefault("server.adaptive_pacing", false)
+	v.SetDefault("server.complete_by", "")
+	v.SetDefault("server.pacing_windows", []string{})
+	v.SetDefault("server.schedules", []interface{}{})
+	v.SetDefault("server.exports", []interface{}{})
+	v.SetDefault("server.peers", []string{})
+	v.SetDefault("server.identity_file", "")
+	v.SetDefault("server.allowed_identities", []string{})
+	v.SetDefault("server.require_noise", false)
+	v.SetDefault("server.rendezvous", "")
+	v.SetDefault("server.interceptors", []string{"nack", "rtcp_reports", "twcc"})
+	v.SetDefault("server.channel_label", "fileStream")
+	v.SetDefault("server.channel_protocol", "")
+	v.SetDefault("server.channel_id", 0)
+	v.SetDefault("server.negotiated_channel", false)
+	v.SetDefault("server.offer_role", "client")
+	v.SetDefault("server.signal", "http")
+	v.SetDefault("server.share_dir", "")
+	v.SetDefault("server.chunk_index", "")
+	v.SetDefault("server.mmap", false)
+	v.SetDefault("server.read_ahead", "8MiB")
+	v.SetDefault("server.length", "")
+	v.SetDefault("server.raw", false)
+	v.SetDefault("server.forward", "")
+	v.SetDefault("server.allow_tunnels", []string{})
+	v.SetDefault("server.unreliable", false)
+	v.SetDefault("server.fec", "")
+	v.SetDefault("server.duplicate_policy", "allow")
+	v.SetDefault("server.control_token", "")
+	v.SetDefault("server.upload_dir", "")
+	v.SetDefault("server.quarantine_dir", "")
+	v.SetDefault("server.upload_max_size", "")
+	v.SetDefault("server.upload_types", []string{})
+	v.SetDefault("server.upload_scanner", "")
+	v.SetDefault("server.upload_collision", "reject")
+	v.SetDefault("server.upload_per_identity", false)
+	v.SetDefault("server.fips", false)
+	v.SetDefault("server.follow_symlinks", false)
+	v.SetDefault("server.timestamps", false)
+	v.SetDefault("server.low_latency", false)
+	v.SetDefault("server.send_retries", 3)
+	v.SetDefault("server.send_retry_backoff", "10ms")
+	v.SetDefault("server.batch", 0)
+	v.SetDefault("server.batch_bytes", "16KiB")
+	v.SetDefault("server.workers", 64)
+	v.SetDefault("server.memory_limit", "")
+	v.SetDefault("server.debug_socket", "")
+	v.SetDefault("server.checksums", []string{})
+	v.SetDefault("server.announce", false)
+	v.SetDefault("server.announce_name", "")
+	v.SetDefault("server.remote_logs", false)
+	v.SetDefault("server.trace", "")
+	v.SetDefault("server.max_record_size", "64KiB")
+	v.SetDefault("server.oversized", "abort")
+	v.SetDefault("server.idle_timeout", "30s")
+	v.SetDefault("server.max_connections", 0)
+	v.SetDefault("server.max_sessions_per_ip", 0)
+	v.SetDefault("server.max_sessions_per_identity", 0)
+	v.SetDefault("server.session_cooldown", "0s")
+	v.SetDefault("server.stall_timeout", "0s")
+	v.SetDefault("server.stall_policy", "close")
+	v.SetDefault("server.tui", false)
+	v.SetDefault("server.broadcast", false)
+	v.SetDefault("server.follow", false)
+	v.SetDefault("server.group_offsets", "")
+	v.SetDefault("server.retention", "0s")
+	v.SetDefault("server.retention_dir", "")
+	v.SetDefault("server.web_ui", false)
+	v.SetDefault("server.cors_origins", []string{})
 
 	// Client defaults
 	v.SetDefault("client.server", "http://localhost:8080/offer")
 	v.SetDefault("client.output", "")
 	v.SetDefault("client.stun", "")
+	v.SetDefault("client.ice_servers", []string{})
+	v.SetDefault("client.auto_stun", false)
+	v.SetDefault("client.no_internet", false)
+	v.SetDefault("client.metrics_addr", "")
+	v.SetDefault("client.auth_token", "")
+	v.SetDefault("client.daemon", false)
+	v.SetDefault("client.name", "")
+	v.SetDefault("client.filter", "")
+	v.SetDefault("client.state_file", "")
+	v.SetDefault("client.encrypt_state", false)
+	v.SetDefault("client.state_key", "")
+	v.SetDefault("client.identity_file", "")
+	v.SetDefault("client.server_identity", "")
+	v.SetDefault("client.noise", false)
+	v.SetDefault("client.rendezvous", "")
+	v.SetDefault("client.code", "")
+	v.SetDefault("client.interceptors", []string{"nack", "rtcp_reports", "twcc"})
+	v.SetDefault("client.channel_label", "fileStream")
+	v.SetDefault("client.channel_protocol", "")
+	v.SetDefault("client.channel_id", 0)
+	v.SetDefault("client.negotiated_channel", false)
+	v.SetDefault("client.offer_role", "client")
+	v.SetDefault("client.signal", "http")
+	v.SetDefault("client.request_files", []string{})
+	v.SetDefault("client.output_dir", ".")
+	v.SetDefault("client.fetch_list", "")
+	v.SetDefault("client.fetch_parallel", 1)
+	v.SetDefault("client.dedup_cache", "")
+	v.SetDefault("client.exec", "")
+	v.SetDefault("client.exec_restart", "never")
+	v.SetDefault("client.exec_max_restarts", 3)
+	v.SetDefault("client.forward", "")
+	v.SetDefault("client.upload_files", []string{})
+	v.SetDefault("client.fips", false)
+	v.SetDefault("client.follow_symlinks", false)
+	v.SetDefault("client.output_template", "")
+	v.SetDefault("client.roll", "")
+	v.SetDefault("client.write_rate", "")
+	v.SetDefault("client.write_buffer", "4MiB")
+	v.SetDefault("client.receive_window", 0)
+	v.SetDefault("client.trickle_ice", true)
+	v.SetDefault("client.connect_timeout", "2m")
+	v.SetDefault("client.checksums", []string{})
+	v.SetDefault("client.export", "")
+	v.SetDefault("client.update_url", "https://github.com/developmeh/webrtc-poc/releases/latest/download")
+	v.SetDefault("client.update_key", "")
+	v.SetDefault("client.remote_logs", false)
+	v.SetDefault("client.trace", "")
+	v.SetDefault("client.max_record_size", "")
+	v.SetDefault("client.oversized", "")
+	v.SetDefault("client.tui", false)
+	v.SetDefault("client.group", "")
+	v.SetDefault("client.since", "0s")
+	v.SetDefault("client.since_seq", 0)
+	v.SetDefault("client.batch", false)
+	v.SetDefault("client.heartbeat_interval", "5s")
 }
@@ -134,6 +134,90 @@ server:
 	})
 }
 
+func TestLoadConfigProfile(t *testing.T) {
+	// Create a temporary config file with profiles
+	tmpDir, err := os.MkdirTemp("", "config-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "config.yaml")
+	configContent := `
+server:
+  addr: ":9090"
+  delay: 500
+client:
+  server: "http://localhost:9090/offer"
+profiles:
+  lan:
+    server:
+      delay: 10
+  wan-turn:
+    server:
+      stun: "stun:stun.l.google.com:19302"
+    client:
+      server: "http://example.com:9090/offer"
+      stun: "stun:stun.l.google.com:19302"
+`
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	// Test that a profile overrides only the settings it declares
+	t.Run("Profile overrides base", func(t *testing.T) {
+		config, err := LoadConfigProfile(configFile, "lan")
+		if err != nil {
+			t.Fatalf("LoadConfigProfile returned error: %v", err)
+		}
+
+		if config.Server.Delay != 10 {
+			t.Errorf("Expected server.delay to be 10, got %d", config.Server.Delay)
+		}
+		if config.Server.Addr != ":9090" {
+			t.Errorf("Expected server.addr to be ':9090', got '%s'", config.Server.Addr)
+		}
+	})
+
+	// Test a profile overriding several sections
+	t.Run("Profile overrides multiple sections", func(t *testing.T) {
+		config, err := LoadConfigProfile(configFile, "wan-turn")
+		if err != nil {
+			t.Fatalf("LoadConfigProfile returned error: %v", err)
+		}
+
+		if config.Server.Stun != "stun:stun.l.google.com:19302" {
+			t.Errorf("Expected server.stun to be set by the profile, got '%s'", config.Server.Stun)
+		}
+		if config.Client.Server != "http://example.com:9090/offer" {
+			t.Errorf("Expected client.server to be set by the profile, got '%s'", config.Client.Server)
+		}
+		if config.Server.Delay != 500 {
+			t.Errorf("Expected server.delay to be 500, got %d", config.Server.Delay)
+		}
+	})
+
+	// Test that no profile leaves the base settings untouched
+	t.Run("No profile", func(t *testing.T) {
+		config, err := LoadConfigProfile(configFile, "")
+		if err != nil {
+			t.Fatalf("LoadConfigProfile returned error: %v", err)
+		}
+
+		if config.Server.Delay != 500 {
+			t.Errorf("Expected server.delay to be 500, got %d", config.Server.Delay)
+		}
+	})
+
+	// Test selecting a profile that does not exist
+	t.Run("Unknown profile", func(t *testing.T) {
+		_, err := LoadConfigProfile(configFile, "missing")
+		if err == nil {
+			t.Error("LoadConfigProfile should have returned an error for an unknown profile")
+		}
+	})
+}
+
 func TestSaveConfig(t *testing.T) {
 	// Test saving configuration to a file
 	t.Run("Save to file", func(t *testing.T) {
@@ -153,6 +237,12 @@ func TestSaveConfig(t *testing.T) {
 				File:  "test.txt",
 				Delay: 500,
 				Stun:  "stun:stun.l.google.com:19302",
+				Schedules: []ScheduleConfig{
+					{Cron: "0 * * * *", File: "hourly.txt", Peers: []string{"edge-1", "edge-2"}},
+				},
+				Exports: []ExportConfig{
+					{Name: "logs", Path: "/var/log/app", Mode: "loop", Allow: []string{"abc"}, Rate: "64KiB/s"},
+				},
 			},
 			Client: ClientConfig{
 				Server: "http://localhost:9090/offer",
@@ -200,6 +290,20 @@ func TestSaveConfig(t *testing.T) {
 		if loadedConfig.Client.Stun != config.Client.Stun {
 			t.Errorf("Expected client.stun to be '%s', got '%s'", config.Client.Stun, loadedConfig.Client.Stun)
 		}
+		if len(loadedConfig.Server.Schedules) != 1 {
+			t.Fatalf("Expected 1 schedule, got %d", len(loadedConfig.Server.Schedules))
+		}
+		schedule := loadedConfig.Server.Schedules[0]
+		if schedule.Cron != "0 * * * *" || schedule.File != "hourly.txt" || len(schedule.Peers) != 2 {
+			t.Errorf("Expected the saved schedule, got %+v", schedule)
+		}
+		if len(loadedConfig.Server.Exports) != 1 {
+			t.Fatalf("Expected 1 export, got %d", len(loadedConfig.Server.Exports))
+		}
+		export := loadedConfig.Server.Exports[0]
+		if export.Name != "logs" || export.Path != "/var/log/app" || export.Mode != "loop" || len(export.Allow) != 1 || export.Rate != "64KiB/s" || export.Watch {
+			t.Errorf("Expected the saved export, got %+v", export)
+		}
 	})
 
 	// Test saving to a directory that doesn't exist (should create it)
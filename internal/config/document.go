@@ -0,0 +1,248 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// nodeKind is the type of a value in a config document
+type nodeKind string
+
+const (
+	kindObject  nodeKind = "object"
+	kindArray   nodeKind = "array"
+	kindString  nodeKind = "string"
+	kindInteger nodeKind = "integer"
+	kindNumber  nodeKind = "number"
+	kindBoolean nodeKind = "boolean"
+	kindNull    nodeKind = "null"
+)
+
+// node is a format independent view of a config document that remembers
+// the line each value was defined on
+type node struct {
+	kind   nodeKind
+	line   int
+	keys   []string
+	fields map[string]*node
+	items  []*node
+}
+
+func newObject(line int) *node {
+	return &node{kind: kindObject, line: line, fields: map[string]*node{}}
+}
+
+// set adds a field to an object node, keeping the document order
+func (n *node) set(key string, value *node) {
+	if _, ok := n.fields[key]; !ok {
+		n.keys = append(n.keys, key)
+	}
+	n.fields[key] = value
+}
+
+// parseDocument parses a config file into a node tree based on its extension
+func parseDocument(filename string, data []byte) (*node, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".toml":
+		return parseTOML(data)
+	case ".json":
+		return parseJSON(data)
+	case ".yaml", ".yml":
+		return parseYAML(data)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q (use .yaml, .toml or .json)", filepath.Ext(filename))
+	}
+}
+
+// parseYAML parses a YAML document
+func parseYAML(data []byte) (*node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return newObject(1), nil
+	}
+	return fromYAML(doc.Content[0]), nil
+}
+
+// parseJSON parses a JSON document. JSON is valid YAML, so once the syntax has
+// been checked the YAML parser is used to recover line numbers.
+func parseJSON(data []byte) (*node, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			return nil, fmt.Errorf("line %d: %v", lineAt(data, int(syntaxErr.Offset)), err)
+		}
+		return nil, err
+	}
+	return parseYAML(data)
+}
+
+func fromYAML(y *yaml.Node) *node {
+	switch y.Kind {
+	case yaml.AliasNode:
+		return fromYAML(y.Alias)
+	case yaml.MappingNode:
+		n := newObject(y.Line)
+		for i := 0; i+1 < len(y.Content); i += 2 {
+			key := y.Content[i]
+			value := fromYAML(y.Content[i+1])
+			value.line = key.Line
+			n.set(strings.ToLower(key.Value), value)
+		}
+		return n
+	case yaml.SequenceNode:
+		n := &node{kind: kindArray, line: y.Line}
+		for _, item := range y.Content {
+			n.items = append(n.items, fromYAML(item))
+		}
+		return n
+	}
+
+	n := &node{line: y.Line}
+	switch y.ShortTag() {
+	case "!!int":
+		n.kind = kindInteger
+	case "!!float":
+		n.kind = kindNumber
+	case "!!bool":
+		n.kind = kindBoolean
+	case "!!null":
+		n.kind = kindNull
+	default:
+		n.kind = kindString
+	}
+	return n
+}
+
+// parseTOML parses a TOML document
+func parseTOML(data []byte) (*node, error) {
+	p := unstable.Parser{}
+	p.Reset(data)
+
+	root := newObject(1)
+	current := root
+
+	for p.NextExpression() {
+		expr := p.Expression()
+
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			current = root
+			keys := expr.Key()
+			for keys.Next() {
+				key := keys.Node()
+				line := p.Shape(key.Raw).Start.Line
+				if expr.Kind == unstable.ArrayTable && keys.IsLast() {
+					current = appendTable(current, string(key.Data), line)
+				} else {
+					current = childObject(current, string(key.Data), line)
+				}
+			}
+		case unstable.KeyValue:
+			target := current
+			keys := expr.Key()
+			for keys.Next() {
+				key := keys.Node()
+				line := p.Shape(key.Raw).Start.Line
+				if keys.IsLast() {
+					value := fromTOML(&p, expr.Value())
+					value.line = line
+					target.set(strings.ToLower(string(key.Data)), value)
+				} else {
+					target = childObject(target, string(key.Data), line)
+				}
+			}
+		}
+	}
+
+	if err := p.Error(); err != nil {
+		if perr, ok := err.(*unstable.ParserError); ok && len(perr.Highlight) > 0 {
+			return nil, fmt.Errorf("line %d: %s", p.Shape(p.Range(perr.Highlight)).Start.Line, perr.Message)
+		}
+		return nil, err
+	}
+
+	return root, nil
+}
+
+// childObject returns the object stored under key, creating it if needed
+func childObject(parent *node, key string, line int) *node {
+	key = strings.ToLower(key)
+	if child, ok := parent.fields[key]; ok && child.kind == kindObject {
+		return child
+	}
+	child := newObject(line)
+	parent.set(key, child)
+	return child
+}
+
+// appendTable appends a new object to the array of tables stored under key
+func appendTable(parent *node, key string, line int) *node {
+	key = strings.ToLower(key)
+	array, ok := parent.fields[key]
+	if !ok || array.kind != kindArray {
+		array = &node{kind: kindArray, line: line}
+		parent.set(key, array)
+	}
+	table := newObject(line)
+	array.items = append(array.items, table)
+	return table
+}
+
+func fromTOML(p *unstable.Parser, t *unstable.Node) *node {
+	switch t.Kind {
+	case unstable.InlineTable:
+		n := newObject(0)
+		children := t.Children()
+		for children.Next() {
+			kv := children.Node()
+			target := n
+			keys := kv.Key()
+			for keys.Next() {
+				key := keys.Node()
+				line := p.Shape(key.Raw).Start.Line
+				if n.line == 0 {
+					n.line = line
+				}
+				if keys.IsLast() {
+					value := fromTOML(p, kv.Value())
+					value.line = line
+					target.set(strings.ToLower(string(key.Data)), value)
+				} else {
+					target = childObject(target, string(key.Data), line)
+				}
+			}
+		}
+		return n
+	case unstable.Array:
+		n := &node{kind: kindArray}
+		children := t.Children()
+		for children.Next() {
+			n.items = append(n.items, fromTOML(p, children.Node()))
+		}
+		return n
+	case unstable.Integer:
+		return &node{kind: kindInteger}
+	case unstable.Float:
+		return &node{kind: kindNumber}
+	case unstable.Bool:
+		return &node{kind: kindBoolean}
+	default:
+		return &node{kind: kindString}
+	}
+}
+
+// lineAt returns the 1-based line number of the given byte offset
+func lineAt(data []byte, offset int) int {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	return strings.Count(string(data[:offset]), "\n") + 1
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+// ParseICEServer parses an ICE server specification into a webrtc.ICEServer.
+// Credentials for TURN servers are given in the user info part of the URL:
+//
+//	stun:stun.l.google.com:19302
+//	turn:user:pass@turn.example.com:3478?transport=tcp
+//	turns:user:env:TURN_PASSWORD@turn.example.com
+//
+// The password may be a secret reference (env:, file:, exec: or keyring:).
+func ParseICEServer(spec string) (webrtc.ICEServer, error) {
+	scheme, rest, ok := strings.Cut(spec, ":")
+	if !ok {
+		return webrtc.ICEServer{}, fmt.Errorf("invalid ICE server %q: missing scheme", spec)
+	}
+
+	var username, credential string
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		userinfo := rest[:at]
+		rest = rest[at+1:]
+
+		username, credential, _ = strings.Cut(userinfo, ":")
+		secret, err := ResolveSecret(credential)
+		if err != nil {
+			return webrtc.ICEServer{}, fmt.Errorf("invalid ICE server credential for %s: %w", username, err)
+		}
+		credential = secret
+	}
+
+	url := scheme + ":" + rest
+	parsed, err := ice.ParseURL(url)
+	if err != nil {
+		return webrtc.ICEServer{}, fmt.Errorf("invalid ICE server %q: %w", url, err)
+	}
+
+	isTURN := parsed.Scheme == ice.SchemeTypeTURN || parsed.Scheme == ice.SchemeTypeTURNS
+	if isTURN && (username == "" || credential == "") {
+		return webrtc.ICEServer{}, fmt.Errorf("invalid ICE server %q: TURN servers require user:pass@ credentials", url)
+	}
+	if !isTURN && username != "" {
+		return webrtc.ICEServer{}, fmt.Errorf("invalid ICE server %q: credentials are only supported for TURN servers", url)
+	}
+
+	server := webrtc.ICEServer{URLs: []string{url}}
+	if isTURN {
+		server.Username = username
+		server.Credential = credential
+		server.CredentialType = webrtc.ICECredentialTypePassword
+	}
+	return server, nil
+}
+
+// ParseICEServers parses a list of ICE server specifications
+func ParseICEServers(specs []string) ([]webrtc.ICEServer, error) {
+	servers := make([]webrtc.ICEServer, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		server, err := ParseICEServer(spec)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// ICEServerURLs returns the URLs of the given servers without credentials,
+// suitable for logging
+func ICEServerURLs(servers []webrtc.ICEServer) []string {
+	var urls []string
+	for _, server := range servers {
+		urls = append(urls, server.URLs...)
+	}
+	return urls
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseICEServer(t *testing.T) {
+	t.Setenv("WEBRTC_POC_TEST_TURN_PASSWORD", "from-env")
+
+	tests := []struct {
+		name       string
+		spec       string
+		url        string
+		username   string
+		credential string
+		wantErr    bool
+	}{
+		{name: "STUN", spec: "stun:stun.l.google.com:19302", url: "stun:stun.l.google.com:19302"},
+		{name: "STUN without port", spec: "stun:stun.example.com", url: "stun:stun.example.com"},
+		{
+			name:       "TURN with credentials",
+			spec:       "turn:alice:secret@turn.example.com:3478?transport=tcp",
+			url:        "turn:turn.example.com:3478?transport=tcp",
+			username:   "alice",
+			credential: "secret",
+		},
+		{
+			name:       "TURNS with secret reference",
+			spec:       "turns:alice:env:WEBRTC_POC_TEST_TURN_PASSWORD@turn.example.com",
+			url:        "turns:turn.example.com",
+			username:   "alice",
+			credential: "from-env",
+		},
+		{name: "TURN without credentials", spec: "turn:turn.example.com:3478", wantErr: true},
+		{name: "STUN with credentials", spec: "stun:alice:secret@stun.example.com", wantErr: true},
+		{name: "Unknown scheme", spec: "http://example.com", wantErr: true},
+		{name: "Missing scheme", spec: "stun.example.com", wantErr: true},
+		{name: "Unset secret", spec: "turn:alice:env:WEBRTC_POC_TEST_UNSET@turn.example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := ParseICEServer(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseICEServer(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(server.URLs) != 1 || server.URLs[0] != tt.url {
+				t.Errorf("Expected URLs [%s], got %v", tt.url, server.URLs)
+			}
+			if server.Username != tt.username {
+				t.Errorf("Expected username '%s', got '%s'", tt.username, server.Username)
+			}
+			var wantCredential interface{}
+			if tt.credential != "" {
+				wantCredential = tt.credential
+			}
+			if server.Credential != wantCredential {
+				t.Errorf("Expected credential '%v', got '%v'", wantCredential, server.Credential)
+			}
+		})
+	}
+}
+
+func TestParseICEServers(t *testing.T) {
+	servers, err := ParseICEServers([]string{
+		"stun:stun.l.google.com:19302",
+		"",
+		"turn:alice:secret@turn.example.com:3478?transport=udp",
+	})
+	if err != nil {
+		t.Fatalf("ParseICEServers returned error: %v", err)
+	}
+
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 servers, got %d", len(servers))
+	}
+
+	urls := ICEServerURLs(servers)
+	if urls[0] != "stun:stun.l.google.com:19302" || urls[1] != "turn:turn.example.com:3478?transport=udp" {
+		t.Errorf("Unexpected URLs: %v", urls)
+	}
+
+	if _, err := ParseICEServers([]string{"stun:ok.example.com", "bogus"}); err == nil {
+		t.Error("Expected an error for an invalid entry")
+	}
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//go:embed schema.json
+var schemaJSON []byte
+
+// schema is the subset of JSON Schema used to describe the config file layout
+type schema struct {
+	Type                 string             `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Ref                  string             `json:"$ref"`
+	Definitions          map[string]*schema `json:"definitions"`
+}
+
+// Issue is a single problem found while validating a config file
+type Issue struct {
+	Line    int
+	Path    string
+	Message string
+}
+
+// ValidationError reports every issue found in a config file
+type ValidationError struct {
+	File   string
+	Issues []Issue
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		lines = append(lines, fmt.Sprintf("%s:%d: %s: %s", e.File, issue.Line, issue.Path, issue.Message))
+	}
+	return "invalid config file:\n  " + strings.Join(lines, "\n  ")
+}
+
+// ValidateFile checks a YAML, TOML or JSON config file against the embedded
+// schema, reporting unknown keys and type errors with their line numbers
+func ValidateFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	return validate(filename, data)
+}
+
+// validate checks the content of a config file against the embedded schema
+func validate(filename string, data []byte) error {
+	doc, err := parseDocument(filename, data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var root schema
+	if err := json.Unmarshal(schemaJSON, &root); err != nil {
+		return fmt.Errorf("invalid embedded config schema: %w", err)
+	}
+
+	v := &validator{root: &root}
+	v.check(doc, &root, "", 1)
+
+	if len(v.issues) > 0 {
+		return &ValidationError{File: filename, Issues: v.issues}
+	}
+	return nil
+}
+
+// validator walks a document tree and collects schema violations
+type validator struct {
+	root   *schema
+	issues []Issue
+}
+
+// resolve follows a "#/definitions/<name>" reference
+func (v *validator) resolve(s *schema) *schema {
+	for s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/definitions/")
+		def, ok := v.root.Definitions[name]
+		if !ok {
+			return &schema{}
+		}
+		s = def
+	}
+	return s
+}
+
+func (v *validator) report(line int, path, format string, args ...interface{}) {
+	if path == "" {
+		path = "(root)"
+	}
+	v.issues = append(v.issues, Issue{Line: line, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) check(n *node, s *schema, path string, parentLine int) {
+	s = v.resolve(s)
+
+	line := n.line
+	if line == 0 {
+		line = parentLine
+	}
+
+	if s.Type != "" && !matches(n.kind, s.Type) {
+		v.report(line, path, "expected %s, got %s", s.Type, n.kind)
+		return
+	}
+
+	switch n.kind {
+	case kindObject:
+		for _, key := range n.keys {
+			child := n.fields[key]
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+
+			if prop, ok := s.Properties[key]; ok {
+				v.check(child, prop, childPath, line)
+			} else if s.AdditionalProperties != nil {
+				v.check(child, s.AdditionalProperties, childPath, line)
+			} else if s.Type == "object" {
+				childLine := child.line
+				if childLine == 0 {
+					childLine = line
+				}
+				v.report(childLine, childPath, "unknown key")
+			}
+		}
+	case kindArray:
+		if s.Items != nil {
+			for i, item := range n.items {
+				v.check(item, s.Items, fmt.Sprintf("%s[%d]", path, i), line)
+			}
+		}
+	}
+}
+
+// matches reports whether a document value satisfies a schema type
+func matches(kind nodeKind, schemaType string) bool {
+	if kind == kindNull {
+		// An empty value leaves the setting at its default
+		return true
+	}
+
+	switch schemaType {
+	case "number":
+		return kind == kindNumber || kind == kindInteger
+	default:
+		return string(kind) == schemaType
+	}
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		issues   []string
+	}{
+		{
+			name:     "Valid YAML",
+			filename: "config.yaml",
+			content: `
+server:
+  addr: ":9090"
+  delay: 500
+profiles:
+  lan:
+    server:
+      delay: 10
+`,
+		},
+		{
+			name:     "Unknown YAML key",
+			filename: "config.yaml",
+			content: `
+server:
+  addr: ":9090"
+  dealy: 500
+`,
+			issues: []string{"config.yaml:4: server.dealy: unknown key"},
+		},
+		{
+			name:     "YAML type error",
+			filename: "config.yaml",
+			content: `
+server:
+  delay: "not-a-number"
+`,
+			issues: []string{"config.yaml:3: server.delay: expected integer, got string"},
+		},
+		{
+			name:     "Unknown key in profile",
+			filename: "config.yaml",
+			content: `
+profiles:
+  lan:
+    sever:
+      delay: 10
+`,
+			issues: []string{"config.yaml:4: profiles.lan.sever: unknown key"},
+		},
+		{
+			name:     "Valid TOML",
+			filename: "config.toml",
+			content: `
+[server]
+addr = ":9090"
+delay = 500
+
+[profiles.lan.server]
+delay = 10
+`,
+		},
+		{
+			name:     "TOML errors",
+			filename: "config.toml",
+			content: `
+[server]
+addr = ":9090"
+delay = "slow"
+
+[client]
+colour = "blue"
+`,
+			issues: []string{
+				"config.toml:4: server.delay: expected integer, got string",
+				"config.toml:7: client.colour: unknown key",
+			},
+		},
+		{
+			name:     "TOML dotted keys",
+			filename: "config.toml",
+			content: `
+server.addr = ":9090"
+client = { server = "http://localhost:9090/offer", stun = 3 }
+`,
+			issues: []string{"config.toml:3: client.stun: expected string, got integer"},
+		},
+		{
+			name:     "Valid JSON",
+			filename: "config.json",
+			content: `{
+  "server": {"addr": ":9090", "delay": 500},
+  "client": {"output": "out.txt"}
+}`,
+		},
+		{
+			name:     "JSON errors",
+			filename: "config.json",
+			content: `{
+  "server": {"addr": 9090},
+  "extra": true
+}`,
+			issues: []string{
+				"config.json:2: server.addr: expected string, got integer",
+				"config.json:3: extra: unknown key",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate(tt.filename, []byte(tt.content))
+			if len(tt.issues) == 0 {
+				if err != nil {
+					t.Errorf("validate returned error: %v", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Expected a ValidationError, got %v", err)
+			}
+			if len(verr.Issues) != len(tt.issues) {
+				t.Errorf("Expected %d issues, got %d: %v", len(tt.issues), len(verr.Issues), err)
+			}
+			for _, want := range tt.issues {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		filename string
+		content  string
+		want     string
+	}{
+		{"config.json", "{\n  \"server\": {\n    \"addr\": \":9090\",\n  }\n}", "line 4"},
+		{"config.toml", "[server]\naddr = \n", "line 2"},
+		{"config.ini", "", "unsupported config format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			err := validate(tt.filename, []byte(tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "config-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"config.toml": "[server]\naddr = \":7070\"\ndelay = 250\n",
+		"config.json": `{"server": {"addr": ":7070", "delay": 250}}`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			configFile := filepath.Join(tmpDir, name)
+			if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write config file: %v", err)
+			}
+
+			config, err := LoadConfig(configFile)
+			if err != nil {
+				t.Fatalf("LoadConfig returned error: %v", err)
+			}
+			if config.Server.Addr != ":7070" {
+				t.Errorf("Expected server.addr to be ':7070', got '%s'", config.Server.Addr)
+			}
+			if config.Server.Delay != 250 {
+				t.Errorf("Expected server.delay to be 250, got %d", config.Server.Delay)
+			}
+		})
+	}
+}
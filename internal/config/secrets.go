@@ -0,0 +1,123 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/cmdline"
+	"github.com/developmeh/webrtc-poc/internal/keyring"
+	"github.com/spf13/viper"
+)
+
+// secretExecTimeout bounds how long an exec: reference may run
+const secretExecTimeout = 10 * time.Second
+
+// secretSettings are the settings that may hold a secret reference; any
+// other value is taken as it is, whatever it starts with. The TURN
+// credentials of ICE server URLs are resolved as the URLs are parsed.
+var secretSettings = []string{
+	"server.auth_token",
+	"server.control_token",
+	"client.auth_token",
+	"server.identity_file",
+	"client.identity_file",
+	"client.state_key",
+}
+
+// keySettings name key files rather than hold secrets. A keyring reference
+// in them is left to the code loading the key, which creates it in the
+// keyring on first use.
+var keySettings = map[string]bool{
+	"server.identity_file": true,
+	"client.identity_file": true,
+	"client.state_key":     true,
+}
+
+// IsSecretRef reports whether a config value is a secret reference
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, "env:") ||
+		strings.HasPrefix(value, "file:") ||
+		strings.HasPrefix(value, "exec:") ||
+		strings.HasPrefix(value, keyring.Prefix)
+}
+
+// ResolveSecret resolves a secret reference so credentials never have to be
+// written verbatim into the config file:
+//
+//	env:NAME       value of the environment variable NAME
+//	file:/path     content of the file, without trailing newlines
+//	exec:cmd args  standard output of the command, without trailing newlines;
+//	               its arguments are quoted like those of --exec
+//	keyring:name   secret stored under name in the OS keyring
+//
+// Any other value is returned unchanged.
+func ResolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+
+	case strings.HasPrefix(value, "file:"):
+		path := strings.TrimPrefix(value, "file:")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	case strings.HasPrefix(value, "exec:"):
+		args, err := cmdline.Split(strings.TrimPrefix(value, "exec:"))
+		if err != nil {
+			return "", fmt.Errorf("invalid exec: secret reference: %w", err)
+		}
+		if len(args) == 0 {
+			return "", fmt.Errorf("exec: secret reference has no command")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), secretExecTimeout)
+		defer cancel()
+
+		out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("error running secret command %s: %w", args[0], err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+
+	case strings.HasPrefix(value, keyring.Prefix):
+		name, _ := keyring.Name(value)
+		return keyring.Get(name)
+	}
+
+	return value, nil
+}
+
+// ResolveSecrets replaces the secret references held by the secret settings
+// of v with their resolved values. Settings of profiles that were not
+// selected are left untouched.
+func ResolveSecrets(v *viper.Viper) error {
+	for _, key := range secretSettings {
+		value, ok := v.Get(key).(string)
+		if !ok || !IsSecretRef(value) {
+			continue
+		}
+		if _, ok := keyring.Name(value); ok && keySettings[key] {
+			continue
+		}
+
+		secret, err := ResolveSecret(value)
+		if err != nil {
+			return fmt.Errorf("error resolving %s: %w", key, err)
+		}
+		v.Set(key, secret)
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/developmeh/webrtc-poc/internal/keyring"
+)
+
+func TestResolveSecret(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "config-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	secretFile := filepath.Join(tmpDir, "token")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+
+	t.Setenv("WEBRTC_POC_TEST_TOKEN", "from-env")
+
+	keyring.MockInit()
+	if err := keyring.Set("token", "from-keyring"); err != nil {
+		t.Fatalf("Failed to store secret: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"Plain value", "stun:stun.l.google.com:19302", "stun:stun.l.google.com:19302", false},
+		{"Environment variable", "env:WEBRTC_POC_TEST_TOKEN", "from-env", false},
+		{"Missing environment variable", "env:WEBRTC_POC_TEST_MISSING", "", true},
+		{"File", "file:" + secretFile, "from-file", false},
+		{"Missing file", "file:" + filepath.Join(tmpDir, "missing"), "", true},
+		{"Command", "exec:echo from-exec", "from-exec", false},
+		{"Quoted command arguments", `exec:printf '%s' "two  words"`, "two  words", false},
+		{"Unterminated quote", `exec:echo "from-exec`, "", true},
+		{"Failing command", "exec:false", "", true},
+		{"Empty command", "exec:", "", true},
+		{"Keyring", "keyring:token", "from-keyring", false},
+		{"Missing keyring secret", "keyring:missing", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveSecret(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveSecret(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveSecret(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigResolvesSecrets(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "config-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	t.Setenv("WEBRTC_POC_TEST_TOKEN", "s3cret")
+
+	configFile := filepath.Join(tmpDir, "config.yaml")
+	configContent := `
+server:
+  auth_token: "env:WEBRTC_POC_TEST_TOKEN"
+client:
+  auth_token: "env:WEBRTC_POC_TEST_TOKEN"
+  output: "env:WEBRTC_POC_TEST_TOKEN"
+profiles:
+  other:
+    client:
+      auth_token: "env:WEBRTC_POC_TEST_UNSET"
+`
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+
+	if config.Server.AuthToken != "s3cret" {
+		t.Errorf("Expected server.auth_token to be resolved, got '%s'", config.Server.AuthToken)
+	}
+	if config.Client.AuthToken != "s3cret" {
+		t.Errorf("Expected client.auth_token to be resolved, got '%s'", config.Client.AuthToken)
+	}
+	if config.Client.Output != "env:WEBRTC_POC_TEST_TOKEN" {
+		t.Errorf("Expected client.output, which holds no secret, to be kept, got '%s'", config.Client.Output)
+	}
+
+	// Selecting the profile pulls in its unresolvable reference
+	if _, err := LoadConfigProfile(configFile, "other"); err == nil {
+		t.Error("LoadConfigProfile should have returned an error for an unset environment variable")
+	}
+
+	// Keyring references to key files are left to the code loading them
+	keyring.MockInit()
+	if err := os.WriteFile(configFile, []byte("client:\n  identity_file: \"keyring:identity\"\n  auth_token: \"keyring:token\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := LoadConfig(configFile); err == nil {
+		t.Error("LoadConfig should have returned an error for a missing keyring secret")
+	}
+	if err := keyring.Set("token", "s3cret"); err != nil {
+		t.Fatalf("Failed to store secret: %v", err)
+	}
+	if config, err = LoadConfig(configFile); err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+	if config.Client.IdentityFile != "keyring:identity" || config.Client.AuthToken != "s3cret" {
+		t.Errorf("Expected the identity reference kept and the token resolved, got %q and %q", config.Client.IdentityFile, config.Client.AuthToken)
+	}
+}
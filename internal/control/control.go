@@ -0,0 +1,286 @@
+// Package control ends the file stream cleanly. Once the server has sent
+// every line it waits for the channel's send queue to drain, sends Fin with
+// the number of lines it sent and closes the channel only after the client
+// answered with Ack and the number of lines it received, so closing cannot
+// cut off the last lines no matter how fast they were sent, and either side
+// can tell when lines went missing. Control messages are five byte binary
+// messages, the type followed by the line count as a big endian uint32,
+// which neither a line (sent as text) nor an FEC shard (at least ten bytes)
+// can be mistaken for. The server also sends Superseded before closing a
+// session another connection of the same client identity took over, and a
+// client with a receive window sends Window with the number of lines it is
+// ready to accept in total, which the server never sends beyond. A server
+// resuming a push whose file no longer starts with the lines the client
+// already received sends Restart with their count before sending the file
+// again from its first line.
+//
+// A client that sends Verify, or asks for it with its offer, gets a Digest
+// before Fin: the SHA-256 of every line sent followed by a newline, which is
+// what the client writes, so it can check its output. Digest is a framed
+// message, the type and a marker followed by a payload. The marker ends in a
+// zero byte in the place of an FEC shard's data shard count, which is never
+// zero, and frames are longer than five bytes, so neither shards nor the
+// fixed size messages can be mistaken for them. A stream of several files
+// starts with a Manifest frame listing them, and the lines of every file
+// follow Begin with the file's index and precede End with their count.
+//
+// A line longer than the largest record is preceded by Truncated or Split
+// with its number, when it is cut, or ends the stream after TooLong. A
+// client of a consumer group sends Commit with the number of lines it wrote
+// to its output. A server streaming with --batch sends clients that asked
+// for batches several lines at once in a Batch frame. A file that is not made
+// of lines, like a block device, is sent in Chunk frames of raw bytes, which
+// the client writes as they are and counts like lines.
+package control
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/sctperr"
+	"github.com/developmeh/webrtc-poc/internal/trace"
+	"github.com/pion/webrtc/v3"
+)
+
+// Message types
+const (
+	// Fin tells the client every line was sent
+	Fin byte = iota + 1
+	// Ack confirms the client received everything up to Fin
+	Ack
+	// Superseded tells the client a newer session of its identity took over
+	Superseded
+	// Window tells the server how many lines the client accepts in total
+	Window
+	// Restart tells the client the lines it already received no longer
+	// match the file, which is sent again from its first line
+	Restart
+	// Verify asks the server for a Digest of the stream
+	Verify
+	// Digest carries the SHA-256 of the lines sent, as a frame
+	Digest
+	// Manifest lists the files of a stream of several files, as a frame
+	Manifest
+	// Begin starts the lines of a file of the manifest
+	Begin
+	// End follows the lines of a file of the manifest
+	End
+	// Truncated tells the client the next line was cut to the largest
+	// record, ending in a marker
+	Truncated
+	// Split tells the client the next lines are the parts of a line longer
+	// than the largest record
+	Split
+	// TooLong tells the client the stream ends because the next line is
+	// longer than the largest record
+	TooLong
+	// Commit tells the server how many lines the client of a consumer group
+	// wrote to its output
+	Commit
+	// Batch carries several lines of the file stream, as a frame
+	Batch
+	// Chunk carries raw bytes of the file stream, as a frame
+	Chunk
+)
+
+// names are the names of the message types, by type
+var names = [...]string{Fin: "Fin", Ack: "Ack", Superseded: "Superseded", Window: "Window", Restart: "Restart", Verify: "Verify", Digest: "Digest", Manifest: "Manifest", Begin: "Begin", End: "End", Truncated: "Truncated", Split: "Split", TooLong: "TooLong", Commit: "Commit", Batch: "Batch", Chunk: "Chunk"}
+
+// Name returns the name of a message type
+func Name(kind byte) string {
+	if int(kind) < len(names) && names[kind] != "" {
+		return names[kind]
+	}
+	return fmt.Sprintf("type %d", kind)
+}
+
+// frameMarker follows the type of a framed message
+const frameMarker = "\xffctl\x00"
+
+const (
+	messageSize = 5
+	frameHeader = 1 + len(frameMarker)
+	// pollInterval bounds the wait for the send queue, since its low
+	// callback only fires when the queue shrinks below the threshold
+	pollInterval = 100 * time.Millisecond
+)
+
+var (
+	// ErrClosed is returned when the channel closed before the stream finished
+	ErrClosed = errors.New("channel closed before the stream finished")
+	// ErrNoAck is returned when the client did not answer Fin in time
+	ErrNoAck = errors.New("client did not acknowledge the end of the stream")
+	// ErrTimeout is returned when the send queue did not drain in time
+	ErrTimeout = errors.New("timed out sending the queued messages")
+)
+
+// Decode returns the type and line count of a control message, reporting
+// false for any other message
+func Decode(msg webrtc.DataChannelMessage) (kind byte, lines int, ok bool) {
+	if msg.IsString || len(msg.Data) != messageSize {
+		return 0, 0, false
+	}
+	return msg.Data[0], int(binary.BigEndian.Uint32(msg.Data[1:])), true
+}
+
+// Send sends a control message with a line count
+func Send(dc *webrtc.DataChannel, kind byte, lines int) error {
+	msg := make([]byte, messageSize)
+	msg[0] = kind
+	binary.BigEndian.PutUint32(msg[1:], uint32(lines))
+	trace.Frame(dc, trace.Send, Name(kind), strconv.Itoa(lines))
+	return sctperr.Wrap(dc.Send(msg))
+}
+
+// DecodeFrame returns the type and payload of a framed message, reporting
+// false for any other message
+func DecodeFrame(msg webrtc.DataChannelMessage) (kind byte, payload []byte, ok bool) {
+	if msg.IsString || len(msg.Data) < frameHeader || string(msg.Data[1:frameHeader]) != frameMarker {
+		return 0, nil, false
+	}
+	return msg.Data[0], msg.Data[frameHeader:], true
+}
+
+// SendFrame sends a framed message with a payload
+func SendFrame(dc *webrtc.DataChannel, kind byte, payload []byte) error {
+	msg := make([]byte, frameHeader+len(payload))
+	msg[0] = kind
+	copy(msg[1:], frameMarker)
+	copy(msg[frameHeader:], payload)
+	trace.Frame(dc, trace.Send, Name(kind), fmt.Sprintf("%d bytes", len(payload)))
+	return sctperr.Wrap(dc.Send(msg))
+}
+
+// Finish waits until everything queued on dc was sent, sends Fin with the
+// number of lines sent and waits up to timeout for the Ack, whose line count
+// is passed on through acks and returned. closed must be closed when the
+// channel closes.
+func Finish(dc *webrtc.DataChannel, sent int, acks <-chan int, closed <-chan struct{}, timeout time.Duration) (int, error) {
+	if err := drain(dc, closed, nil); err != nil {
+		return 0, err
+	}
+	if err := Send(dc, Fin, sent); err != nil {
+		return 0, err
+	}
+	select {
+	case received := <-acks:
+		return received, nil
+	case <-closed:
+		return 0, ErrClosed
+	case <-time.After(timeout):
+		return 0, ErrNoAck
+	}
+}
+
+// Drain waits up to timeout until everything queued on dc was sent, so the
+// channel can be closed without losing it. closed must be closed when the
+// channel closes.
+func Drain(dc *webrtc.DataChannel, closed <-chan struct{}, timeout time.Duration) error {
+	return drain(dc, closed, time.After(timeout))
+}
+
+// drain waits until dc's send queue is empty, the channel closed or expired
+// fires, which a nil channel never does
+func drain(dc *webrtc.DataChannel, closed <-chan struct{}, expired <-chan time.Time) error {
+	drained := make(chan struct{}, 1)
+	dc.SetBufferedAmountLowThreshold(0)
+	dc.OnBufferedAmountLow(func() {
+		select {
+		case drained <- struct{}{}:
+		default:
+		}
+	})
+	for dc.BufferedAmount() > 0 {
+		select {
+		case <-drained:
+		case <-time.After(pollInterval):
+		case <-closed:
+			return ErrClosed
+		case <-expired:
+			return ErrTimeout
+		}
+	}
+	return nil
+}
+
+// WaitBelow waits until at most limit bytes are queued on dc, or until open
+// reports the channel closed, so the next message is not queued behind a
+// backlog of older ones. It reports whether it had to wait.
+func WaitBelow(dc *webrtc.DataChannel, limit uint64, open func() bool) bool {
+	if dc.BufferedAmount() <= limit {
+		return false
+	}
+	low := make(chan struct{}, 1)
+	dc.SetBufferedAmountLowThreshold(limit)
+	dc.OnBufferedAmountLow(func() {
+		select {
+		case low <- struct{}{}:
+		default:
+		}
+	})
+	for dc.BufferedAmount() > limit && open() {
+		select {
+		case <-low:
+		case <-time.After(pollInterval):
+		}
+	}
+	return true
+}
+
+// ReceiveWindow holds the lines a client is ready to accept, which only
+// grows as the client advertises more. A window nobody advertised does not
+// limit the stream, so clients without one are streamed to as before.
+type ReceiveWindow struct {
+	mu      sync.Mutex
+	edge    int
+	limited bool
+	changed chan struct{}
+}
+
+// NewReceiveWindow creates a window of initial lines, or an unlimited one
+// until the client advertises one if initial is 0
+func NewReceiveWindow(initial int) *ReceiveWindow {
+	return &ReceiveWindow{edge: initial, limited: initial > 0, changed: make(chan struct{})}
+}
+
+// Advertise records that the client accepts edge lines in total
+func (w *ReceiveWindow) Advertise(edge int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.limited && edge <= w.edge {
+		return
+	}
+	w.edge, w.limited = edge, true
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// Full reports whether the client accepts no more than sent lines
+func (w *ReceiveWindow) Full(sent int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.limited && sent >= w.edge
+}
+
+// Wait blocks until the client accepts more than sent lines, or open reports
+// that the channel closed. It reports whether it had to wait.
+func (w *ReceiveWindow) Wait(sent int, open func() bool) bool {
+	waited := false
+	for {
+		w.mu.Lock()
+		changed := w.changed
+		w.mu.Unlock()
+		if !w.Full(sent) || !open() {
+			return waited
+		}
+		waited = true
+		select {
+		case <-changed:
+		case <-time.After(pollInterval):
+		}
+	}
+}
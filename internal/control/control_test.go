@@ -0,0 +1,324 @@
+package control
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// pair connects two peers in the process and returns both ends of a
+// pre-negotiated channel, like the file stream channel
+func pair(t *testing.T) (server, client *webrtc.DataChannel) {
+	t.Helper()
+	offerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	t.Cleanup(func() { offerer.Close() })
+	answerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	t.Cleanup(func() { answerer.Close() })
+
+	negotiated, id := true, uint16(0)
+	init := &webrtc.DataChannelInit{Negotiated: &negotiated, ID: &id}
+	client, err = offerer.CreateDataChannel("fileStream", init)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	server, err = answerer.CreateDataChannel("fileStream", init)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	opened := make(chan struct{})
+	server.OnOpen(func() { close(opened) })
+
+	offer, err := offerer.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("CreateOffer returned error: %v", err)
+	}
+	offerer.SetLocalDescription(offer)
+	<-webrtc.GatheringCompletePromise(offerer)
+	answerer.SetRemoteDescription(*offerer.LocalDescription())
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("CreateAnswer returned error: %v", err)
+	}
+	answerer.SetLocalDescription(answer)
+	<-webrtc.GatheringCompletePromise(answerer)
+	offerer.SetRemoteDescription(*answerer.LocalDescription())
+
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the channel to open")
+	}
+	return server, client
+}
+
+func TestDecode(t *testing.T) {
+	server, client := pair(t)
+	messages := make(chan webrtc.DataChannelMessage, 1)
+	client.OnMessage(func(msg webrtc.DataChannelMessage) { messages <- msg })
+	if err := Send(server, Fin, 70000); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+	kind, lines, ok := Decode(<-messages)
+	if !ok || kind != Fin || lines != 70000 {
+		t.Errorf("Expected Fin with 70000 lines, got %d with %d lines, %v", kind, lines, ok)
+	}
+
+	for _, msg := range []webrtc.DataChannelMessage{
+		{IsString: true, Data: []byte{Fin, 0, 0, 0, 1}},
+		{Data: []byte{Fin}},
+		{Data: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{Data: nil},
+	} {
+		if _, _, ok := Decode(msg); ok {
+			t.Errorf("Expected %v not to be a control message", msg)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	for kind, want := range map[byte]string{Fin: "Fin", Window: "Window", End: "End", TooLong: "TooLong", Commit: "Commit", 0: "type 0", 200: "type 200"} {
+		if got := Name(kind); got != want {
+			t.Errorf("Expected %s for type %d, got %s", want, kind, got)
+		}
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	server, client := pair(t)
+	messages := make(chan webrtc.DataChannelMessage, 1)
+	client.OnMessage(func(msg webrtc.DataChannelMessage) { messages <- msg })
+	sum := []byte(strings.Repeat("\xab", 32))
+	if err := SendFrame(server, Digest, sum); err != nil {
+		t.Fatalf("SendFrame returned error: %v", err)
+	}
+	msg := <-messages
+	kind, payload, ok := DecodeFrame(msg)
+	if !ok || kind != Digest || string(payload) != string(sum) {
+		t.Errorf("Expected Digest with the sum, got %d with %x, %v", kind, payload, ok)
+	}
+	if _, _, ok := Decode(msg); ok {
+		t.Error("Expected a frame not to be a fixed size control message")
+	}
+
+	// An FEC shard never has a data shard count of 0, where the marker ends
+	shard := []byte{0, 0, 0, 1, 0, 10, 2, 0, 0, 6, 'h', 'e', 'l', 'l', 'o'}
+	for _, msg := range []webrtc.DataChannelMessage{
+		{IsString: true, Data: []byte("\x07\xffctl\x00")},
+		{Data: []byte{Fin, 0, 0, 0, 1}},
+		{Data: shard},
+		{Data: nil},
+	} {
+		if _, _, ok := DecodeFrame(msg); ok {
+			t.Errorf("Expected %v not to be a frame", msg)
+		}
+	}
+}
+
+// receive answers Fin like the client: it acknowledges the number of lines
+// that arrived before it and reports both counts
+func receive(server, client *webrtc.DataChannel) (acks chan int, counts chan [2]int) {
+	acks = make(chan int, 1)
+	server.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if kind, lines, ok := Decode(msg); ok && kind == Ack {
+			acks <- lines
+		}
+	})
+
+	counts = make(chan [2]int, 1)
+	received := 0
+	client.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if kind, sent, ok := Decode(msg); ok && kind == Fin {
+			Send(client, Ack, received)
+			counts <- [2]int{sent, received}
+			return
+		}
+		received++
+	})
+	return acks, counts
+}
+
+func TestFinish(t *testing.T) {
+	// Send as fast as possible, so the queue is full when Finish starts
+	for _, lines := range []int{0, 1, 100, 20000} {
+		t.Run(fmt.Sprintf("%d lines", lines), func(t *testing.T) {
+			server, client := pair(t)
+			acks, counts := receive(server, client)
+			for i := range lines {
+				if err := server.SendText(fmt.Sprintf("line %d", i)); err != nil {
+					t.Fatalf("SendText returned error: %v", err)
+				}
+			}
+			received, err := Finish(server, lines, acks, make(chan struct{}), 5*time.Second)
+			if err != nil {
+				t.Fatalf("Finish returned error: %v", err)
+			}
+			if received != lines {
+				t.Errorf("Expected the client to acknowledge %d lines, got %d", lines, received)
+			}
+			if c := <-counts; c != [2]int{lines, lines} {
+				t.Errorf("Expected Fin to follow all %d lines, got Fin with %d lines after %d", lines, c[0], c[1])
+			}
+		})
+	}
+
+	t.Run("Large lines", func(t *testing.T) {
+		server, client := pair(t)
+		acks, _ := receive(server, client)
+		line := strings.Repeat("x", 60000)
+		for range 200 {
+			if err := server.SendText(line); err != nil {
+				t.Fatalf("SendText returned error: %v", err)
+			}
+		}
+		if received, err := Finish(server, 200, acks, make(chan struct{}), 5*time.Second); err != nil || received != 200 {
+			t.Errorf("Expected 200 lines acknowledged, got %d, %v", received, err)
+		}
+	})
+
+	t.Run("Unacknowledged", func(t *testing.T) {
+		server, _ := pair(t)
+		start := time.Now()
+		if _, err := Finish(server, 0, make(chan int), make(chan struct{}), 200*time.Millisecond); err != ErrNoAck {
+			t.Errorf("Expected ErrNoAck without an Ack, got %v", err)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Error("Expected Finish to give up after the timeout")
+		}
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		server, _ := pair(t)
+		closed := make(chan struct{})
+		close(closed)
+		if _, err := Finish(server, 0, make(chan int), closed, time.Minute); err != ErrClosed {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+	})
+}
+
+func TestDrain(t *testing.T) {
+	server, _ := pair(t)
+	for i := range 5000 {
+		if err := server.SendText(fmt.Sprintf("line %d", i)); err != nil {
+			t.Fatalf("SendText returned error: %v", err)
+		}
+	}
+	if err := Drain(server, make(chan struct{}), 5*time.Second); err != nil {
+		t.Fatalf("Drain returned error: %v", err)
+	}
+	if n := server.BufferedAmount(); n != 0 {
+		t.Errorf("Expected an empty send queue, got %d bytes", n)
+	}
+}
+
+func TestWaitBelow(t *testing.T) {
+	server, _ := pair(t)
+	open := func() bool { return true }
+	if WaitBelow(server, 1024, open) {
+		t.Error("Expected an empty send queue not to wait")
+	}
+
+	line := strings.Repeat("x", 1000)
+	for range 1000 {
+		if err := server.SendText(line); err != nil {
+			t.Fatalf("SendText returned error: %v", err)
+		}
+	}
+	if !WaitBelow(server, 4096, open) {
+		t.Error("Expected a full send queue to wait")
+	}
+	if n := server.BufferedAmount(); n > 4096 {
+		t.Errorf("Expected at most 4096 bytes queued, got %d", n)
+	}
+
+	// A closed channel stops the wait
+	for range 1000 {
+		server.SendText(line)
+	}
+	if !WaitBelow(server, 0, func() bool { return false }) {
+		t.Error("Expected a full send queue to wait")
+	}
+}
+
+func TestReceiveWindow(t *testing.T) {
+	open := func() bool { return true }
+
+	t.Run("Unlimited", func(t *testing.T) {
+		w := NewReceiveWindow(0)
+		if w.Wait(1000, open) || w.Full(1000) {
+			t.Error("Expected a window nobody advertised not to limit the stream")
+		}
+	})
+
+	t.Run("Advertised", func(t *testing.T) {
+		w := NewReceiveWindow(10)
+		if w.Wait(9, open) {
+			t.Error("Expected lines inside the window to be sent right away")
+		}
+		if w.Full(9) || !w.Full(10) {
+			t.Error("Expected the window to be full at its edge")
+		}
+
+		done := make(chan bool)
+		go func() { done <- w.Wait(10, open) }()
+		select {
+		case <-done:
+			t.Fatal("Expected the stream to wait at the edge of the window")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		// A smaller edge never shrinks the window
+		w.Advertise(5)
+		w.Advertise(20)
+		select {
+		case waited := <-done:
+			if !waited {
+				t.Error("Expected Wait to report that it waited")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the stream to continue once the window grew")
+		}
+		if w.Wait(19, open) {
+			t.Error("Expected the grown window to hold 20 lines")
+		}
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		w := NewReceiveWindow(1)
+		start := time.Now()
+		w.Wait(1, func() bool { return false })
+		if time.Since(start) > time.Second {
+			t.Error("Expected Wait to return right away for a closed channel")
+		}
+	})
+
+	t.Run("Message", func(t *testing.T) {
+		server, client := pair(t)
+		received := make(chan int, 1)
+		client.OnMessage(func(msg webrtc.DataChannelMessage) {
+			if kind, lines, ok := Decode(msg); ok && kind == Window {
+				received <- lines
+			}
+		})
+		if err := Send(server, Window, 128); err != nil {
+			t.Fatalf("Failed to send window: %v", err)
+		}
+		select {
+		case lines := <-received:
+			if lines != 128 {
+				t.Errorf("Expected a window of 128 lines, got %d", lines)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the window")
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package control
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// HelloProtocol is the subprotocol of the channel a client opens with its
+// offer when the server announces the file stream channel in-band. The
+// offer needs a channel to carry a data channel section, and clients of the
+// first protocol open one without a subprotocol, so the server can tell
+// from it whether the client reads control messages. The server answers on
+// the channel with the protocol before the first line, so the client can
+// tell in turn whether the server finishes the stream with Fin.
+const HelloProtocol = "webrtc-poc-hello"
+
+// Hello is the server's end of the hello channel of one connection
+type Hello struct {
+	once    sync.Once
+	done    chan struct{}
+	current bool
+}
+
+// NewHello creates the server's end of a connection's hello channel
+func NewHello() *Hello {
+	return &Hello{done: make(chan struct{})}
+}
+
+// Opened is called with every channel the client opens, and reports whether
+// it was the hello channel, which is answered and closed. A channel without
+// a subprotocol is the one clients of the first protocol open.
+func (h *Hello) Opened(dc *webrtc.DataChannel) bool {
+	switch dc.Protocol() {
+	case HelloProtocol:
+		dc.OnOpen(func() {
+			dc.SendText(HelloProtocol)
+			dc.Close()
+		})
+		h.once.Do(func() {
+			h.current = true
+			close(h.done)
+		})
+	case "":
+		dc.OnOpen(func() { dc.Close() })
+		h.once.Do(func() { close(h.done) })
+	default:
+		return false
+	}
+	return true
+}
+
+// Current waits up to timeout for the client's hello channel and reports
+// whether the client reads control messages. Clients that opened no channel
+// by then are only sent lines.
+func (h *Hello) Current(timeout time.Duration) bool {
+	select {
+	case <-h.done:
+	case <-time.After(timeout):
+	}
+	h.once.Do(func() { close(h.done) })
+	return h.current
+}
+
+// Greet opens the client's hello channel on pc, which has to be done before
+// the offer is made, and returns a function reporting whether the server
+// answered it
+func Greet(pc *webrtc.PeerConnection) (answered func() bool, err error) {
+	protocol := HelloProtocol
+	dc, err := pc.CreateDataChannel("hello", &webrtc.DataChannelInit{Protocol: &protocol})
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	var ok bool
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if msg.IsString && string(msg.Data) == HelloProtocol {
+			mu.Lock()
+			ok = true
+			mu.Unlock()
+		}
+	})
+	return func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return ok
+	}, nil
+}
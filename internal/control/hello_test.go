@@ -0,0 +1,88 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// connectHello connects a client that opens its hello channel with open to
+// a server answering it with a Hello, which is returned
+func connectHello(t *testing.T, open func(pc *webrtc.PeerConnection) error) *Hello {
+	t.Helper()
+	offerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	t.Cleanup(func() { offerer.Close() })
+	answerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	t.Cleanup(func() { answerer.Close() })
+
+	hello := NewHello()
+	answerer.OnDataChannel(func(dc *webrtc.DataChannel) { hello.Opened(dc) })
+	if err := open(offerer); err != nil {
+		t.Fatalf("Failed to open the hello channel: %v", err)
+	}
+
+	offer, err := offerer.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("CreateOffer returned error: %v", err)
+	}
+	offerer.SetLocalDescription(offer)
+	<-webrtc.GatheringCompletePromise(offerer)
+	answerer.SetRemoteDescription(*offerer.LocalDescription())
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("CreateAnswer returned error: %v", err)
+	}
+	answerer.SetLocalDescription(answer)
+	<-webrtc.GatheringCompletePromise(answerer)
+	offerer.SetRemoteDescription(*answerer.LocalDescription())
+	return hello
+}
+
+func TestHello(t *testing.T) {
+	var answered func() bool
+	hello := connectHello(t, func(pc *webrtc.PeerConnection) (err error) {
+		answered, err = Greet(pc)
+		return err
+	})
+	if !hello.Current(10 * time.Second) {
+		t.Fatal("Expected the client to read control messages")
+	}
+	for deadline := time.Now().Add(10 * time.Second); !answered(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the server to answer the hello channel")
+		}
+	}
+}
+
+func TestHelloFirstProtocol(t *testing.T) {
+	// Clients of the first protocol open a channel without a subprotocol
+	hello := connectHello(t, func(pc *webrtc.PeerConnection) error {
+		_, err := pc.CreateDataChannel("initChannel", nil)
+		return err
+	})
+	start := time.Now()
+	if hello.Current(10 * time.Second) {
+		t.Fatal("Expected a client of the first protocol")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Expected the channel to tell without waiting for the timeout")
+	}
+}
+
+func TestHelloTimeout(t *testing.T) {
+	hello := NewHello()
+	if hello.Current(10 * time.Millisecond) {
+		t.Error("Expected a client that opened no channel to be sent only lines")
+	}
+	// Channels opened too late do not change the answer
+	if hello.Current(0) {
+		t.Error("Expected the answer to stay the same")
+	}
+}
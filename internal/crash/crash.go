@@ -0,0 +1,53 @@
+// Package crash keeps a panic in the handlers of one connection from taking
+// down the process. Handlers recover at their boundary, the panic is logged
+// with its stack and counted, and only the session it happened in is closed.
+package crash
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+)
+
+// ErrPanic is wrapped by the errors panics are turned into
+var ErrPanic = errors.New("recovered from panic")
+
+// panicsTotal counts recovered panics by the handler they happened in
+var panicsTotal = metrics.NewCounterVec("webrtc_poc_panics_total",
+	"Panics recovered in connection handlers, by handler", "handler")
+
+// Recovered logs and counts a value recovered from a panic in handler and
+// returns it as an error wrapping ErrPanic
+func Recovered(handler string, r any) error {
+	panicsTotal.Inc(handler)
+	err := fmt.Errorf("%w in %s: %v", ErrPanic, handler, r)
+	logger.Error("%v\n%s", err, debug.Stack())
+	return err
+}
+
+// Recover must be deferred by a handler. If the handler panics it logs and
+// counts the panic and calls isolate, which should close the session the
+// handler belongs to, instead of letting the panic end the process.
+func Recover(handler string, isolate func()) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	Recovered(handler, r)
+	if isolate != nil {
+		logger.Info("Closing the session %s panicked in", handler)
+		isolate()
+	}
+}
+
+// Callback wraps a callback taking one argument, such as a data channel
+// message handler, so a panic in it is recovered like in Recover
+func Callback[T any](handler string, isolate func(), f func(T)) func(T) {
+	return func(v T) {
+		defer Recover(handler, isolate)
+		f(v)
+	}
+}
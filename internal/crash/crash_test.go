@@ -0,0 +1,64 @@
+package crash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	t.Run("Panic", func(t *testing.T) {
+		before := panicsTotal.Value("test handler")
+		isolated := false
+		func() {
+			defer Recover("test handler", func() { isolated = true })
+			panic("boom")
+		}()
+		if !isolated {
+			t.Error("Expected the session to be isolated after a panic")
+		}
+		if got := panicsTotal.Value("test handler") - before; got != 1 {
+			t.Errorf("Expected the panic to be counted once, got %d", got)
+		}
+	})
+
+	t.Run("NoPanic", func(t *testing.T) {
+		isolated := false
+		func() {
+			defer Recover("quiet handler", func() { isolated = true })
+		}()
+		if isolated || panicsTotal.Value("quiet handler") != 0 {
+			t.Error("Expected nothing to happen without a panic")
+		}
+	})
+
+	t.Run("Callback", func(t *testing.T) {
+		isolated := false
+		callback := Callback("test callback", func() { isolated = true }, func(msg string) {
+			if msg == "bad" {
+				panic("bad message")
+			}
+		})
+		callback("good")
+		if isolated {
+			t.Error("Expected a callback that returns normally not to isolate the session")
+		}
+		callback("bad")
+		if !isolated {
+			t.Error("Expected a panicking callback to isolate the session")
+		}
+	})
+}
+
+func TestRecovered(t *testing.T) {
+	err := Recovered("streamFile", "index out of range")
+	if !errors.Is(err, ErrPanic) {
+		t.Errorf("Expected the error to match ErrPanic, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "in streamFile: index out of range") {
+		t.Errorf("Unexpected message: %v", err)
+	}
+	if panicsTotal.Value("streamFile") == 0 {
+		t.Error("Expected the panic to be counted")
+	}
+}
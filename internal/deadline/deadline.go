@@ -0,0 +1,111 @@
+package deadline
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+)
+
+// ErrMissed is returned when a transfer cannot finish before its deadline
+var ErrMissed = errors.New("transfer deadline cannot be met")
+
+// missedTotal counts transfers aborted because of their deadline
+var missedTotal = metrics.NewCounter("webrtc_poc_transfer_deadline_missed_total",
+	"Transfers aborted because they could not finish before --complete-by")
+
+// Spec is a parsed --complete-by value, either an absolute time or a duration
+// measured from the start of each transfer
+type Spec struct {
+	At     time.Time
+	Within time.Duration
+}
+
+// Parse parses a duration ("10m") or an RFC 3339 timestamp
+// ("2024-01-02T15:04:05Z"). An empty value means no deadline.
+func Parse(value string) (Spec, error) {
+	if value == "" {
+		return Spec{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return Spec{}, fmt.Errorf("deadline must be positive: %s", value)
+		}
+		return Spec{Within: d}, nil
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return Spec{}, fmt.Errorf("invalid deadline %q: expected a duration or RFC 3339 time", value)
+	}
+	return Spec{At: at}, nil
+}
+
+// IsZero reports whether no deadline was configured
+func (s Spec) IsZero() bool {
+	return s.At.IsZero() && s.Within == 0
+}
+
+// From returns the deadline for a transfer starting at start
+func (s Spec) From(start time.Time) time.Time {
+	if s.Within > 0 {
+		return start.Add(s.Within)
+	}
+	return s.At
+}
+
+// Planner paces a transfer of a known number of lines so it completes by a
+// deadline, sending faster than the configured delay when it has to
+type Planner struct {
+	at    time.Time
+	lines int
+	now   func() time.Time
+}
+
+// NewPlanner creates a planner for sending lines before at
+func NewPlanner(at time.Time, lines int) *Planner {
+	return &Planner{at: at, lines: lines, now: time.Now}
+}
+
+// Check reports whether the transfer fits the deadline at the configured
+// delay, logging a warning and the faster pacing that will be used if not.
+// It returns ErrMissed if the deadline has already passed.
+func (p *Planner) Check(delay time.Duration) error {
+	left := p.at.Sub(p.now())
+	if left <= 0 {
+		return p.missed(p.lines)
+	}
+	if p.lines == 0 {
+		return nil
+	}
+
+	required := (left / time.Duration(p.lines)).Round(time.Millisecond)
+	logger.Info("Deadline %s leaves %v for %d lines, at least one line every %v",
+		p.at.Format(time.RFC3339), left.Round(time.Millisecond), p.lines, required)
+	if delay > required {
+		logger.Info("Warning: a delay of %v would finish at %s, after the deadline; increasing pacing to one line every %v",
+			delay, p.now().Add(delay*time.Duration(p.lines)).Format(time.RFC3339), required)
+	}
+	return nil
+}
+
+// Delay returns the delay to wait before the next line given how many lines
+// are still to be sent, never longer than delay and zero once none are left.
+// It returns ErrMissed once the deadline has passed with lines remaining.
+func (p *Planner) Delay(delay time.Duration, remaining int) (time.Duration, error) {
+	if remaining <= 0 {
+		return 0, nil
+	}
+	left := p.at.Sub(p.now())
+	if left <= 0 {
+		return 0, p.missed(remaining)
+	}
+	return min(delay, left/time.Duration(remaining)), nil
+}
+
+// missed records an aborted transfer and describes it
+func (p *Planner) missed(remaining int) error {
+	missedTotal.Inc()
+	return fmt.Errorf("%w: %d of %d lines left at %s", ErrMissed, remaining, p.lines, p.at.Format(time.RFC3339))
+}
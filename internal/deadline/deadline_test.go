@@ -0,0 +1,94 @@
+package deadline
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		spec, err := Parse("")
+		if err != nil || !spec.IsZero() {
+			t.Errorf("Expected no deadline, got %+v (err=%v)", spec, err)
+		}
+	})
+
+	t.Run("Duration", func(t *testing.T) {
+		spec, err := Parse("10m")
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		start := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+		if got := spec.From(start); !got.Equal(start.Add(10 * time.Minute)) {
+			t.Errorf("Expected deadline 10m after start, got %v", got)
+		}
+	})
+
+	t.Run("Timestamp", func(t *testing.T) {
+		spec, err := Parse("2024-01-02T15:04:05Z")
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		want := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+		if got := spec.From(time.Now()); !got.Equal(want) {
+			t.Errorf("Expected deadline %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, value := range []string{"soon", "-5m", "0s"} {
+			if _, err := Parse(value); err == nil {
+				t.Errorf("Expected an error for %q", value)
+			}
+		}
+	})
+}
+
+func TestPlanner(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	p := NewPlanner(now.Add(10*time.Second), 20)
+	p.now = func() time.Time { return now }
+
+	t.Run("Check", func(t *testing.T) {
+		if err := p.Check(time.Second); err != nil {
+			t.Errorf("Expected the deadline to be reachable, got %v", err)
+		}
+	})
+
+	t.Run("SpeedsUp", func(t *testing.T) {
+		delay, err := p.Delay(time.Second, 20)
+		if err != nil || delay != 500*time.Millisecond {
+			t.Errorf("Expected 500ms, got %v (err=%v)", delay, err)
+		}
+	})
+
+	t.Run("KeepsConfiguredDelay", func(t *testing.T) {
+		delay, err := p.Delay(100*time.Millisecond, 20)
+		if err != nil || delay != 100*time.Millisecond {
+			t.Errorf("Expected 100ms, got %v (err=%v)", delay, err)
+		}
+	})
+
+	t.Run("Missed", func(t *testing.T) {
+		p.now = func() time.Time { return now.Add(11 * time.Second) }
+		before := missedTotal.Value()
+
+		if _, err := p.Delay(time.Second, 3); !errors.Is(err, ErrMissed) {
+			t.Errorf("Expected ErrMissed, got %v", err)
+		}
+		if err := p.Check(time.Second); !errors.Is(err, ErrMissed) {
+			t.Errorf("Expected ErrMissed from Check, got %v", err)
+		}
+		if got := missedTotal.Value() - before; got != 2 {
+			t.Errorf("Expected missed counter to increase by 2, got %d", got)
+		}
+	})
+
+	t.Run("NothingLeft", func(t *testing.T) {
+		delay, err := p.Delay(time.Second, 0)
+		if err != nil || delay != 0 {
+			t.Errorf("Expected no delay once all lines are sent, got %v (err=%v)", delay, err)
+		}
+	})
+}
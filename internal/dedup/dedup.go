@@ -0,0 +1,329 @@
+// Package dedup splits files into content-defined chunks of lines and keeps
+// a local content-addressed cache of them, so a client only has to receive
+// the chunks it has not seen before. Chunks are identified by the hash of
+// one of the checksum algorithms, SHA-256 unless the peers agreed otherwise.
+package dedup
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/developmeh/webrtc-poc/internal/checksum"
+	"github.com/developmeh/webrtc-poc/internal/seal"
+)
+
+const (
+	// boundaryMask ends a chunk after a line whose hash has these bits
+	// clear, about one line in 64. Boundaries depend on the content only, so
+	// an insertion early in a file does not shift every chunk after it.
+	boundaryMask = 63
+	// MaxChunkSize ends a chunk regardless of its content
+	MaxChunkSize = 256 * 1024
+)
+
+// castagnoli is the table of the CRC32C polynomial
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Chunk is a run of consecutive lines identified by the hash of its data,
+// every line followed by a newline
+type Chunk struct {
+	Hash  string `json:"hash"`
+	Lines int    `json:"lines"`
+	Size  int64  `json:"size"`
+	// CRC is the CRC32C of the data, cheap enough to check every chunk as
+	// soon as it arrived
+	CRC uint32 `json:"crc"`
+}
+
+// Check reports whether data matches the chunk's CRC
+func (c Chunk) Check(data []byte) bool {
+	return crc32.Checksum(data, castagnoli) == c.CRC
+}
+
+// Sum computes the hash, CRC and size of a run of lines as they are added,
+// so the lines never have to be read again to identify or check their chunk
+type Sum struct {
+	sum   hash.Hash
+	hash  []byte
+	crc   uint32
+	lines int
+	size  int64
+}
+
+// NewSum returns an empty sum hashing with algorithm
+func NewSum(algorithm string) (*Sum, error) {
+	sum, err := checksum.New(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &Sum{sum: sum}, nil
+}
+
+// Add appends a line, without its newline
+func (s *Sum) Add(line []byte) {
+	s.sum.Write(line)
+	s.sum.Write([]byte{'\n'})
+	s.crc = crc32.Update(s.crc, castagnoli, line)
+	s.crc = crc32.Update(s.crc, castagnoli, []byte{'\n'})
+	s.lines++
+	s.size += int64(len(line)) + 1
+}
+
+// Chunk returns the chunk of the lines added so far and starts over
+func (s *Sum) Chunk() Chunk {
+	s.hash = s.sum.Sum(s.hash[:0])
+	chunk := Chunk{Hash: hex.EncodeToString(s.hash), Lines: s.lines, Size: s.size, CRC: s.crc}
+	s.sum.Reset()
+	s.crc, s.lines, s.size = 0, 0, 0
+	return chunk
+}
+
+// Splitter cuts a stream of lines into chunks
+type Splitter struct {
+	chunks []Chunk
+	sum    *Sum
+}
+
+// NewSplitter returns an empty splitter hashing chunks with algorithm
+func NewSplitter(algorithm string) (*Splitter, error) {
+	sum, err := NewSum(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &Splitter{sum: sum}, nil
+}
+
+// Add appends a line, without its newline
+func (s *Splitter) Add(line string) {
+	s.sum.Add([]byte(line))
+
+	h := fnv.New32a()
+	h.Write([]byte(line))
+	if h.Sum32()&boundaryMask == 0 || s.sum.size >= MaxChunkSize {
+		s.cut()
+	}
+}
+
+// cut ends the current chunk
+func (s *Splitter) cut() {
+	if s.sum.lines == 0 {
+		return
+	}
+	s.chunks = append(s.chunks, s.sum.Chunk())
+}
+
+// Chunks ends the current chunk and returns all chunks so far
+func (s *Splitter) Chunks() []Chunk {
+	s.cut()
+	return s.chunks
+}
+
+// Split reads r line by line and returns its chunks, hashed with algorithm
+func Split(r io.Reader, algorithm string) ([]Chunk, error) {
+	s, err := NewSplitter(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		s.Add(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	return s.Chunks(), nil
+}
+
+// Cache stores chunks in a directory, one file per chunk named by its hash.
+// Chunks hashed with other algorithms than SHA-256 are kept in a subdirectory
+// named after the algorithm. An encrypted cache seals every chunk and names
+// it by a keyed hash of its hash instead.
+type Cache struct {
+	dir       string
+	algorithm string
+	key       *seal.Key
+}
+
+// ErrCorrupt is returned for cached chunks that no longer match their hash
+var ErrCorrupt = errors.New("cached chunk does not match its hash")
+
+// chunkLabel is the purpose chunks are sealed for
+const chunkLabel = "chunk"
+
+// ErrChecksum is returned for chunks that kept failing their CRC in transit
+var ErrChecksum = errors.New("chunk failed its CRC")
+
+// OpenCache opens the cache in dir, creating it if needed
+func OpenCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cache directory: %w", err)
+	}
+	return &Cache{dir: dir, algorithm: checksum.SHA256}, nil
+}
+
+// With returns the same cache for chunks hashed with algorithm, where an
+// empty algorithm is SHA-256
+func (c *Cache) With(algorithm string) (*Cache, error) {
+	if algorithm == "" {
+		algorithm = checksum.SHA256
+	}
+	if _, err := checksum.New(algorithm); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: c.dir, algorithm: algorithm, key: c.key}, nil
+}
+
+// Encrypt returns the same cache storing its chunks sealed with key. Chunks
+// stored in the clear are not found by the encrypted cache, and the other
+// way around.
+func (c *Cache) Encrypt(key *seal.Key) *Cache {
+	return &Cache{dir: c.dir, algorithm: c.algorithm, key: key}
+}
+
+// verify reports whether data matches a chunk's hash
+func (c *Cache) verify(chunk Chunk, data []byte) bool {
+	sum, err := checksum.Sum(c.algorithm, data)
+	return err == nil && sum == chunk.Hash
+}
+
+// NewSum returns an empty sum hashing with the cache's algorithm, to check
+// received chunks as their lines arrive
+func (c *Cache) NewSum() *Sum {
+	// The algorithm was checked when the cache was opened
+	sum, _ := NewSum(c.algorithm)
+	return sum
+}
+
+// path returns where a chunk is stored, spread over subdirectories by the
+// first byte of its hash
+func (c *Cache) path(hash string) string {
+	dir := c.dir
+	if c.algorithm != checksum.SHA256 {
+		dir = filepath.Join(dir, c.algorithm)
+	}
+	if c.key != nil {
+		hash = c.key.Name(hash)
+	}
+	if len(hash) < 2 {
+		return filepath.Join(dir, hash)
+	}
+	return filepath.Join(dir, hash[:2], hash)
+}
+
+// Has reports whether the cache holds a chunk
+func (c *Cache) Has(chunk Chunk) bool {
+	info, err := os.Stat(c.path(chunk.Hash))
+	size := chunk.Size
+	if c.key != nil {
+		size += int64(seal.Overhead)
+	}
+	return err == nil && info.Size() == size
+}
+
+// Get returns the data of a cached chunk, verifying it against its hash
+func (c *Cache) Get(chunk Chunk) ([]byte, error) {
+	data, err := os.ReadFile(c.path(chunk.Hash))
+	if err != nil {
+		return nil, fmt.Errorf("error reading cached chunk: %w", err)
+	}
+	if c.key != nil {
+		if data, err = c.key.Open(chunkLabel, data); err != nil {
+			os.Remove(c.path(chunk.Hash))
+			return nil, ErrCorrupt
+		}
+	}
+	if !c.verify(chunk, data) {
+		os.Remove(c.path(chunk.Hash))
+		return nil, ErrCorrupt
+	}
+	return data, nil
+}
+
+// Put stores the data of a chunk if it matches the chunk's hash
+func (c *Cache) Put(chunk Chunk, data []byte) error {
+	sum, err := checksum.Sum(c.algorithm, data)
+	if err != nil {
+		return err
+	}
+	return c.PutHashed(chunk, sum, data)
+}
+
+// PutHashed stores the data of a chunk whose hash was computed while it
+// arrived, such as with a Sum, if that hash is the chunk's
+func (c *Cache) PutHashed(chunk Chunk, hash string, data []byte) error {
+	if hash != chunk.Hash {
+		return ErrCorrupt
+	}
+
+	path := c.path(chunk.Hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating cache directory: %w", err)
+	}
+	if c.key != nil {
+		data = c.key.Seal(chunkLabel, data)
+	}
+
+	// Write to a temporary file first so readers never see a partial chunk
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".chunk-*")
+	if err != nil {
+		return fmt.Errorf("error writing cached chunk: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing cached chunk: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing cached chunk: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing cached chunk: %w", err)
+	}
+	return nil
+}
+
+// Missing returns the indexes of the chunks the cache does not hold
+func (c *Cache) Missing(chunks []Chunk) []int {
+	var missing []int
+	for i, chunk := range chunks {
+		if !c.Has(chunk) {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// Lines returns a predicate reporting whether a 1-based line number belongs
+// to one of the chunks in want. It must be called with increasing lines.
+func Lines(chunks []Chunk, want []int) func(line int) bool {
+	wanted := make(map[int]bool, len(want))
+	for _, i := range want {
+		wanted[i] = true
+	}
+
+	// ends[i] is the last line of chunk i
+	ends := make([]int, len(chunks))
+	total := 0
+	for i, chunk := range chunks {
+		total += chunk.Lines
+		ends[i] = total
+	}
+
+	chunk := 0
+	return func(line int) bool {
+		for chunk < len(ends) && line > ends[chunk] {
+			chunk++
+		}
+		return chunk < len(ends) && wanted[chunk]
+	}
+}
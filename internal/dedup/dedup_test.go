@@ -0,0 +1,385 @@
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/developmeh/webrtc-poc/internal/checksum"
+	"github.com/developmeh/webrtc-poc/internal/seal"
+)
+
+// numbered returns n numbered lines starting at from
+func numbered(from, n int) string {
+	var b strings.Builder
+	for i := from; i < from+n; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func TestSplit(t *testing.T) {
+	text := numbered(1, 2000)
+	chunks, err := Split(strings.NewReader(text), checksum.SHA256)
+	if err != nil {
+		t.Fatalf("Split returned error: %v", err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("Expected several chunks, got %d", len(chunks))
+	}
+
+	lines, size := 0, int64(0)
+	for _, c := range chunks {
+		lines += c.Lines
+		size += c.Size
+	}
+	if lines != 2000 || size != int64(len(text)) {
+		t.Errorf("Expected chunks to cover 2000 lines and %d bytes, got %d lines and %d bytes", len(text), lines, size)
+	}
+
+	t.Run("CRC", func(t *testing.T) {
+		offset := int64(0)
+		for i, c := range chunks {
+			data := []byte(text[offset : offset+c.Size])
+			offset += c.Size
+			if !c.Check(data) {
+				t.Errorf("Expected chunk %d to match its CRC", i)
+			}
+			data[0] ^= 1
+			if c.Check(data) {
+				t.Errorf("Expected a corrupted chunk %d to fail its CRC", i)
+			}
+		}
+	})
+
+	t.Run("ShiftResistant", func(t *testing.T) {
+		// Inserting a line at the start only changes the first chunk
+		shifted, err := Split(strings.NewReader("inserted\n"+text), checksum.SHA256)
+		if err != nil {
+			t.Fatalf("Split returned error: %v", err)
+		}
+		known := make(map[string]bool)
+		for _, c := range chunks {
+			known[c.Hash] = true
+		}
+		changed := 0
+		for _, c := range shifted {
+			if !known[c.Hash] {
+				changed++
+			}
+		}
+		if changed != 1 {
+			t.Errorf("Expected 1 changed chunk, got %d", changed)
+		}
+	})
+
+	t.Run("MaxChunkSize", func(t *testing.T) {
+		// Lines that never end a chunk are cut at the maximum size
+		s, err := NewSplitter(checksum.SHA256)
+		if err != nil {
+			t.Fatalf("NewSplitter returned error: %v", err)
+		}
+		line := strings.Repeat("x", 1023)
+		for range 1024 {
+			s.Add(line)
+		}
+		for _, c := range s.Chunks() {
+			if c.Size > MaxChunkSize {
+				t.Errorf("Expected chunks of at most %d bytes, got %d", MaxChunkSize, c.Size)
+			}
+		}
+	})
+}
+
+func TestCache(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dedup-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache, err := OpenCache(dir)
+	if err != nil {
+		t.Fatalf("OpenCache returned error: %v", err)
+	}
+
+	data := []byte(numbered(1, 10))
+	chunks, _ := Split(strings.NewReader(string(data)), checksum.SHA256)
+	other := Chunk{Hash: strings.Repeat("0", 64), Lines: 1, Size: 2}
+	chunk := chunks[0]
+
+	if missing := cache.Missing([]Chunk{chunk, other}); len(missing) != 2 {
+		t.Errorf("Expected both chunks to be missing, got %v", missing)
+	}
+	if err := cache.Put(chunk, data[:len(data)-1]); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for data not matching the hash, got %v", err)
+	}
+
+	if err := cache.Put(chunks[0], data[:chunk.Size]); err != nil {
+		t.Fatalf("Put returned error: %v", err)
+	}
+	if missing := cache.Missing([]Chunk{chunk, other}); len(missing) != 1 || missing[0] != 1 {
+		t.Errorf("Expected only the second chunk to be missing, got %v", missing)
+	}
+	got, err := cache.Get(chunk)
+	if err != nil || string(got) != string(data[:chunk.Size]) {
+		t.Errorf("Expected the cached data back, got %q, %v", got, err)
+	}
+
+	t.Run("Corrupt", func(t *testing.T) {
+		path := filepath.Join(dir, chunk.Hash[:2], chunk.Hash)
+		os.WriteFile(path, []byte(strings.Repeat("?", int(chunk.Size))), 0644)
+		if _, err := cache.Get(chunk); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected ErrCorrupt, got %v", err)
+		}
+		if cache.Has(chunk) {
+			t.Error("Expected the corrupt chunk to be removed")
+		}
+	})
+
+	t.Run("Algorithm", func(t *testing.T) {
+		// Chunks hashed with another algorithm are verified with it and
+		// kept apart from the SHA-256 ones
+		xxh3, err := cache.With(checksum.XXH3)
+		if err != nil {
+			t.Fatalf("With returned error: %v", err)
+		}
+		chunks, _ := Split(strings.NewReader(string(data)), checksum.XXH3)
+		chunk := chunks[0]
+		if len(chunk.Hash) != 32 {
+			t.Errorf("Expected a 128-bit hash, got %s", chunk.Hash)
+		}
+		if err := cache.Put(chunk, data[:chunk.Size]); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected the SHA-256 cache to refuse an XXH3 chunk, got %v", err)
+		}
+		if err := xxh3.Put(chunk, data[:chunk.Size]); err != nil {
+			t.Fatalf("Put returned error: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, checksum.XXH3, chunk.Hash[:2], chunk.Hash)); err != nil {
+			t.Errorf("Expected the chunk below the algorithm's directory: %v", err)
+		}
+		if got, err := xxh3.Get(chunk); err != nil || string(got) != string(data[:chunk.Size]) {
+			t.Errorf("Expected the cached data back, got %q, %v", got, err)
+		}
+		if _, err := cache.With("md5"); !errors.Is(err, checksum.ErrUnknown) {
+			t.Errorf("Expected ErrUnknown, got %v", err)
+		}
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		key, err := seal.New(make([]byte, 32))
+		if err != nil {
+			t.Fatalf("Failed to create key: %v", err)
+		}
+		sealed := cache.Encrypt(key)
+		if err := sealed.Put(chunk, data[:chunk.Size]); err != nil {
+			t.Fatalf("Put returned error: %v", err)
+		}
+		if !sealed.Has(chunk) {
+			t.Error("Expected the encrypted cache to hold the chunk")
+		}
+		if got, err := sealed.Get(chunk); err != nil || string(got) != string(data[:chunk.Size]) {
+			t.Errorf("Expected the cached data back, got %q, %v", got, err)
+		}
+
+		// Neither the chunk's hash nor its content is stored in the clear
+		path := filepath.Join(dir, key.Name(chunk.Hash)[:2], key.Name(chunk.Hash))
+		stored, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Expected the chunk under its keyed name: %v", err)
+		}
+		if strings.Contains(string(stored), "line 1") {
+			t.Error("Expected the chunk to be stored sealed")
+		}
+		if _, err := os.Stat(filepath.Join(dir, chunk.Hash[:2], chunk.Hash)); err == nil {
+			t.Error("Expected no file named by the chunk's hash")
+		}
+
+		// Another key neither finds nor opens the chunk
+		other, _ := seal.New([]byte(strings.Repeat("k", 32)))
+		if cache.Encrypt(other).Has(chunk) {
+			t.Error("Expected another key not to find the chunk")
+		}
+		xxh3, _ := sealed.With(checksum.XXH3)
+		if xxh3.key != key {
+			t.Error("Expected With to keep the key")
+		}
+	})
+}
+
+func TestSum(t *testing.T) {
+	// A sum of a chunk's lines identifies and checks it like the splitter
+	text := numbered(1, 500)
+	chunks, _ := Split(strings.NewReader(text), checksum.BLAKE3)
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+
+	sum, err := NewSum(checksum.BLAKE3)
+	if err != nil {
+		t.Fatalf("NewSum returned error: %v", err)
+	}
+	for _, chunk := range chunks {
+		for _, line := range lines[:chunk.Lines] {
+			sum.Add([]byte(line))
+		}
+		lines = lines[chunk.Lines:]
+		if got := sum.Chunk(); got != chunk {
+			t.Fatalf("Expected %+v, got %+v", chunk, got)
+		}
+	}
+
+	dir := t.TempDir()
+	cache, _ := OpenCache(dir)
+	chunk := chunks[0]
+	if err := cache.PutHashed(chunk, strings.Repeat("0", 64), []byte(text[:chunk.Size])); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for a hash that is not the chunk's, got %v", err)
+	}
+}
+
+func TestLines(t *testing.T) {
+	chunks := []Chunk{{Lines: 2}, {Lines: 3}, {Lines: 1}}
+	include := Lines(chunks, []int{1, 2})
+
+	var got []int
+	for line := 1; line <= 7; line++ {
+		if include(line) {
+			got = append(got, line)
+		}
+	}
+	if fmt.Sprint(got) != "[3 4 5 6]" {
+		t.Errorf("Expected lines [3 4 5 6], got %v", got)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "dedup-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "share", "a.txt")
+	os.MkdirAll(filepath.Dir(file), 0755)
+	os.WriteFile(file, []byte("one\ntwo\n"), 0644)
+	indexFile := filepath.Join(dir, "index.json")
+
+	ix, err := NewIndex(indexFile)
+	if err != nil {
+		t.Fatalf("NewIndex returned error: %v", err)
+	}
+	if read, err := ix.Warm(filepath.Join(dir, "share"), checksum.SHA256); err != nil || read != 1 {
+		t.Fatalf("Expected Warm to read 1 file, got %d, %v", read, err)
+	}
+	first, err := ix.Chunks(file, checksum.SHA256)
+	if err != nil {
+		t.Fatalf("Chunks returned error: %v", err)
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		// Same size and modification time, so the file is not read again
+		info, _ := os.Stat(file)
+		os.WriteFile(file, []byte("eno\nowt\n"), 0644)
+		os.Chtimes(file, info.ModTime(), info.ModTime())
+
+		chunks, err := ix.Chunks(file, checksum.SHA256)
+		if err != nil || chunks[0].Hash != first[0].Hash {
+			t.Errorf("Expected the indexed chunks, got %v, %v", chunks, err)
+		}
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		os.WriteFile(file, []byte("one\ntwo\nthree\n"), 0644)
+		chunks, err := ix.Chunks(file, checksum.SHA256)
+		if err != nil || chunks[0].Hash == first[0].Hash || chunks[0].Lines != 3 {
+			t.Errorf("Expected the file to be read again, got %v, %v", chunks, err)
+		}
+	})
+
+	t.Run("Persisted", func(t *testing.T) {
+		loaded, err := NewIndex(indexFile)
+		if err != nil {
+			t.Fatalf("NewIndex returned error: %v", err)
+		}
+		if read, err := loaded.Warm(filepath.Join(dir, "share"), checksum.SHA256); err != nil || read != 0 {
+			t.Errorf("Expected the saved index to be up to date, read %d, %v", read, err)
+		}
+	})
+
+	t.Run("OldFormat", func(t *testing.T) {
+		// Entries saved before chunks carried a CRC are split again
+		info, _ := os.Stat(file)
+		ix.entries[file] = indexEntry{Size: info.Size(), ModTime: info.ModTime(), Chunks: []Chunk{{Hash: "stale", Lines: 3}}}
+		chunks, err := ix.Chunks(file, checksum.SHA256)
+		if err != nil || chunks[0].Hash == "stale" || chunks[0].CRC == 0 {
+			t.Errorf("Expected the old entry to be replaced, got %v, %v", chunks, err)
+		}
+	})
+
+	t.Run("Algorithm", func(t *testing.T) {
+		// The same file is indexed separately per algorithm, with the same
+		// boundaries
+		chunks, err := ix.Chunks(file, checksum.BLAKE3)
+		if err != nil {
+			t.Fatalf("Chunks returned error: %v", err)
+		}
+		sha, _ := ix.Chunks(file, checksum.SHA256)
+		if len(chunks) != len(sha) || chunks[0].Lines != sha[0].Lines || chunks[0].CRC != sha[0].CRC || chunks[0].Hash == sha[0].Hash {
+			t.Errorf("Expected the same chunks with other hashes, got %v and %v", chunks, sha)
+		}
+		if len(ix.entries) != 2 {
+			t.Errorf("Expected an entry per algorithm, got %v", ix.entries)
+		}
+	})
+
+	t.Run("Recorded", func(t *testing.T) {
+		// Lines read anyway index the file without another read
+		os.WriteFile(file, []byte(numbered(1, 300)), 0644)
+		recorder := ix.Record(file, checksum.SHA256)
+		if recorder == nil {
+			t.Fatal("Expected a recorder for a changed file")
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(numbered(1, 300), "\n"), "\n") {
+			recorder.Add(line)
+		}
+		if err := recorder.Finish(); err != nil {
+			t.Fatalf("Finish returned error: %v", err)
+		}
+		if ix.Record(file, checksum.SHA256) != nil {
+			t.Error("Expected no recorder for an indexed file")
+		}
+		want, _ := Split(strings.NewReader(numbered(1, 300)), checksum.SHA256)
+		ix.mu.Lock()
+		got := ix.entries[file].Chunks
+		ix.mu.Unlock()
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected the recorded chunks to match splitting the file")
+		}
+
+		// A file that changed while it was read is not indexed
+		delete(ix.entries, indexKey(file, checksum.BLAKE3))
+		recorder = ix.Record(file, checksum.BLAKE3)
+		recorder.Add("line 1")
+		os.WriteFile(file, []byte("changed\n"), 0644)
+		if err := recorder.Finish(); err != nil {
+			t.Fatalf("Finish returned error: %v", err)
+		}
+		ix.mu.Lock()
+		_, ok := ix.entries[indexKey(file, checksum.BLAKE3)]
+		ix.mu.Unlock()
+		if ok {
+			t.Error("Expected a file changed while read not to be indexed")
+		}
+	})
+
+	t.Run("Pruned", func(t *testing.T) {
+		os.Remove(file)
+		if _, err := ix.Warm(filepath.Join(dir, "share"), checksum.SHA256); err != nil {
+			t.Fatalf("Warm returned error: %v", err)
+		}
+		if len(ix.entries) != 0 {
+			t.Errorf("Expected removed files to be forgotten, got %v", ix.entries)
+		}
+	})
+}
@@ -0,0 +1,244 @@
+package dedup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/checksum"
+)
+
+// Index caches the chunks of files so they are only read again after they
+// changed. An entry is valid while the file keeps its size and modification
+// time. Files are indexed separately for every algorithm their chunks are
+// hashed with.
+type Index struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]indexEntry
+	// saving serializes writes to the index file
+	saving sync.Mutex
+}
+
+// indexVersion is the format of the index entries. Entries written before
+// chunks carried a CRC have no version and are split again.
+const indexVersion = 1
+
+// indexEntry is the chunk list of one file and the state it was read in
+type indexEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Chunks  []Chunk   `json:"chunks"`
+	Version int       `json:"version,omitempty"`
+}
+
+// indexKey returns the key of a file's entry for chunks hashed with
+// algorithm. SHA-256 entries are keyed by the path alone, as they were before
+// there were other algorithms.
+func indexKey(file, algorithm string) string {
+	if algorithm == checksum.SHA256 || algorithm == "" {
+		return file
+	}
+	return file + "\x00" + algorithm
+}
+
+// indexedFile returns the path of the file an entry's key belongs to
+func indexedFile(key string) string {
+	file, _, _ := strings.Cut(key, "\x00")
+	return file
+}
+
+// NewIndex returns an index persisted to path, loading the entries saved
+// there before. An empty path keeps the index in memory only.
+func NewIndex(path string) (*Index, error) {
+	ix := &Index{path: path, entries: make(map[string]indexEntry)}
+	if path == "" {
+		return ix, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunk index: %w", err)
+	}
+	if err := json.Unmarshal(data, &ix.entries); err != nil {
+		return nil, fmt.Errorf("error parsing chunk index %s: %w", path, err)
+	}
+	return ix, nil
+}
+
+// Chunks returns the chunks of a file hashed with algorithm, splitting it
+// only if it is not indexed yet or changed since
+func (ix *Index) Chunks(file, algorithm string) ([]Chunk, error) {
+	chunks, changed, err := ix.chunks(file, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := ix.save(); err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// chunks looks a file up, reporting whether the index changed
+func (ix *Index) chunks(file, algorithm string) ([]Chunk, bool, error) {
+	file = filepath.Clean(file)
+	key := indexKey(file, algorithm)
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if entry, ok := ix.lookup(key, info); ok {
+		return entry.Chunks, false, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	chunks, err := Split(f, algorithm)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ix.store(key, info, chunks)
+	return chunks, true, nil
+}
+
+// lookup returns the entry of key if it is still valid for the file's
+// current info
+func (ix *Index) lookup(key string, info os.FileInfo) (indexEntry, bool) {
+	ix.mu.Lock()
+	entry, ok := ix.entries[key]
+	ix.mu.Unlock()
+	return entry, ok && entry.Version == indexVersion && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime())
+}
+
+// store sets the entry of key to the chunks of the file as it was at info
+func (ix *Index) store(key string, info os.FileInfo, chunks []Chunk) {
+	ix.mu.Lock()
+	ix.entries[key] = indexEntry{Size: info.Size(), ModTime: info.ModTime(), Chunks: chunks, Version: indexVersion}
+	ix.mu.Unlock()
+}
+
+// Recorder indexes a file from the lines of a read that happens anyway, such
+// as streaming it to a client, instead of reading it a second time to split
+// it
+type Recorder struct {
+	ix       *Index
+	key      string
+	file     string
+	info     os.FileInfo
+	splitter *Splitter
+}
+
+// Record returns a recorder for a file that is not indexed for algorithm yet
+// or changed since, or nil if its entry is up to date or the file cannot be
+// indexed. It must be called before the file is read.
+func (ix *Index) Record(file, algorithm string) *Recorder {
+	file = filepath.Clean(file)
+	key := indexKey(file, algorithm)
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	if _, ok := ix.lookup(key, info); ok {
+		return nil
+	}
+	splitter, err := NewSplitter(algorithm)
+	if err != nil {
+		return nil
+	}
+	return &Recorder{ix: ix, key: key, file: file, info: info, splitter: splitter}
+}
+
+// Add splits the next line read from the file, without its newline
+func (r *Recorder) Add(line string) {
+	r.splitter.Add(line)
+}
+
+// Finish indexes the file from every line it was read with, unless it
+// changed while it was read. It must only be called once the whole file was
+// read.
+func (r *Recorder) Finish() error {
+	info, err := os.Stat(r.file)
+	if err != nil || info.Size() != r.info.Size() || !info.ModTime().Equal(r.info.ModTime()) {
+		return nil
+	}
+	r.ix.store(r.key, r.info, r.splitter.Chunks())
+	return r.ix.save()
+}
+
+// Warm indexes every regular file below root for algorithm and forgets the
+// files that no longer exist, returning the number of files it had to read
+func (ix *Index) Warm(root, algorithm string) (int, error) {
+	read := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		_, changed, err := ix.chunks(path, algorithm)
+		if err == nil && changed {
+			read++
+		}
+		return nil
+	})
+	if err != nil {
+		return read, err
+	}
+
+	ix.mu.Lock()
+	pruned := 0
+	for key := range ix.entries {
+		if _, err := os.Stat(indexedFile(key)); errors.Is(err, os.ErrNotExist) {
+			delete(ix.entries, key)
+			pruned++
+		}
+	}
+	ix.mu.Unlock()
+
+	if read > 0 || pruned > 0 {
+		return read, ix.save()
+	}
+	return read, nil
+}
+
+// save writes the index to its file, if it has one
+func (ix *Index) save() error {
+	if ix.path == "" {
+		return nil
+	}
+	ix.saving.Lock()
+	defer ix.saving.Unlock()
+
+	ix.mu.Lock()
+	data, err := json.Marshal(ix.entries)
+	ix.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding chunk index: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ix.path), 0755); err != nil {
+		return fmt.Errorf("error creating chunk index directory: %w", err)
+	}
+	tmp := ix.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing chunk index: %w", err)
+	}
+	if err := os.Rename(tmp, ix.path); err != nil {
+		return fmt.Errorf("error writing chunk index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+// Package diagnose probes the network conditions that decide whether two
+// peers can connect to each other directly
+package diagnose
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/webrtc/v3"
+)
+
+// NAT behaviours reported by ClassifyNAT
+const (
+	NATNotProbed           = "not probed"
+	NATUnknown             = "unknown"
+	NATNone                = "none"
+	NATEndpointIndependent = "endpoint-independent"
+	NATSymmetric           = "symmetric"
+)
+
+// Mapping is the public address a STUN server saw a local socket as
+type Mapping struct {
+	Server string
+	Mapped *net.UDPAddr
+	Err    error
+}
+
+// PortResult is the outcome of probing one local UDP port
+type PortResult struct {
+	Port   int
+	Mapped *net.UDPAddr
+	Err    error
+}
+
+// LoopbackResult is the outcome of connecting two local peers
+type LoopbackResult struct {
+	Connected bool
+	Duration  time.Duration
+	Pair      string
+	Err       error
+}
+
+// Options configures a diagnosis
+type Options struct {
+	// STUNServers are host:port addresses; none means NAT probing is skipped
+	STUNServers []string
+	// Ports are local UDP ports to probe; none probes an ephemeral port
+	Ports []int
+	// Timeout bounds every single probe
+	Timeout time.Duration
+	// API and Config are used for the loopback connection
+	API    *webrtc.API
+	Config webrtc.Configuration
+}
+
+// Report collects the results of a diagnosis
+type Report struct {
+	Mappings []Mapping
+	NAT      string
+	Ports    []PortResult
+	Loopback LoopbackResult
+}
+
+// Run probes the NAT, the configured ports and a loopback connection
+func Run(opts Options) Report {
+	report := Report{NAT: NATNotProbed}
+
+	if len(opts.STUNServers) > 0 {
+		conn, err := net.ListenPacket("udp4", ":0")
+		if err != nil {
+			report.NAT = NATUnknown
+		} else {
+			for _, server := range opts.STUNServers {
+				mapped, err := Probe(conn, server, opts.Timeout)
+				report.Mappings = append(report.Mappings, Mapping{Server: server, Mapped: mapped, Err: err})
+			}
+			report.NAT = ClassifyNAT(conn.LocalAddr().(*net.UDPAddr).Port, report.Mappings)
+			conn.Close()
+		}
+	}
+
+	for _, port := range opts.Ports {
+		report.Ports = append(report.Ports, ProbePort(port, opts.STUNServers, opts.Timeout))
+	}
+
+	report.Loopback = Loopback(opts.API, opts.Config, opts.Timeout)
+	return report
+}
+
+// Probe sends a STUN binding request from conn to server and returns the
+// address the server saw the request coming from
+func Probe(conn net.PacketConn, server string, timeout time.Duration) (*net.UDPAddr, error) {
+	addr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := stun.Build(stun.TransactionID, stun.BindingRequest)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(request.Raw, addr); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	deadline := time.Now().Add(timeout)
+	for {
+		conn.SetReadDeadline(deadline)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip stray packets and late answers to earlier requests
+		response := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+		if err := response.Decode(); err != nil || response.TransactionID != request.TransactionID {
+			continue
+		}
+		var xor stun.XORMappedAddress
+		if err := xor.GetFrom(response); err != nil {
+			return nil, fmt.Errorf("no mapped address in STUN response: %w", err)
+		}
+		return &net.UDPAddr{IP: xor.IP, Port: xor.Port}, nil
+	}
+}
+
+// ClassifyNAT compares the mappings of one socket bound to localPort as seen
+// by different STUN servers
+func ClassifyNAT(localPort int, mappings []Mapping) string {
+	var seen []*net.UDPAddr
+	for _, m := range mappings {
+		if m.Err == nil && m.Mapped != nil {
+			seen = append(seen, m.Mapped)
+		}
+	}
+	if len(seen) == 0 {
+		return NATUnknown
+	}
+
+	for _, addr := range seen[1:] {
+		if !addr.IP.Equal(seen[0].IP) || addr.Port != seen[0].Port {
+			return NATSymmetric
+		}
+	}
+	if seen[0].Port == localPort && isLocalIP(seen[0].IP) {
+		return NATNone
+	}
+	return NATEndpointIndependent
+}
+
+// isLocalIP reports whether ip belongs to one of this host's interfaces
+func isLocalIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProbePort binds a UDP port and checks that STUN traffic gets through it,
+// reporting the public address it is mapped to
+func ProbePort(port int, servers []string, timeout time.Duration) PortResult {
+	result := PortResult{Port: port}
+	conn, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", port))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer conn.Close()
+
+	if len(servers) == 0 {
+		return result
+	}
+	for _, server := range servers {
+		if result.Mapped, result.Err = Probe(conn, server, timeout); result.Err == nil {
+			break
+		}
+	}
+	return result
+}
+
+// Loopback connects two peers inside this process and reports the candidate
+// pair they agreed on
+func Loopback(api *webrtc.API, config webrtc.Configuration, timeout time.Duration) (result LoopbackResult) {
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	offerer, err := api.NewPeerConnection(config)
+	if err != nil {
+		return LoopbackResult{Err: err}
+	}
+	defer offerer.Close()
+	answerer, err := api.NewPeerConnection(config)
+	if err != nil {
+		return LoopbackResult{Err: err}
+	}
+	defer answerer.Close()
+
+	opened := make(chan struct{})
+	channel, err := offerer.CreateDataChannel("diagnose", nil)
+	if err != nil {
+		return LoopbackResult{Err: err}
+	}
+	channel.OnOpen(func() { close(opened) })
+
+	if err := exchange(offerer, answerer); err != nil {
+		return LoopbackResult{Err: err}
+	}
+
+	select {
+	case <-opened:
+	case <-time.After(timeout):
+		return LoopbackResult{Err: errors.New("timed out waiting for the data channel to open")}
+	}
+
+	result.Connected = true
+	if pair, err := offerer.SCTP().Transport().ICETransport().GetSelectedCandidatePair(); err == nil && pair != nil {
+		result.Pair = pair.Local.Typ.String() + "/" + pair.Remote.Typ.String()
+	}
+	return result
+}
+
+// exchange runs a complete offer/answer exchange between two peers
+func exchange(offerer, answerer *webrtc.PeerConnection) error {
+	offer, err := offerer.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err := offerer.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	<-webrtc.GatheringCompletePromise(offerer)
+	if err := answerer.SetRemoteDescription(*offerer.LocalDescription()); err != nil {
+		return err
+	}
+
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := answerer.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	<-webrtc.GatheringCompletePromise(answerer)
+	return offerer.SetRemoteDescription(*answerer.LocalDescription())
+}
+
+// Verdict summarises how likely direct connections are to succeed
+func (r Report) Verdict() string {
+	if !r.Loopback.Connected {
+		return "Unlikely: even a connection between two local peers failed, check the firewall and ICE configuration"
+	}
+	switch r.NAT {
+	case NATNone:
+		return "Very likely: this host has a public address, so peers can connect to it directly"
+	case NATEndpointIndependent:
+		return "Likely: the NAT keeps one public address per socket, so STUN is enough for most peers"
+	case NATSymmetric:
+		return "Unlikely with STUN alone: the NAT uses a different public address for every peer, configure a TURN server"
+	case NATUnknown:
+		return "Local network only: no STUN server answered, so UDP may be blocked; use a TURN server over TCP to reach other networks"
+	default:
+		return "Local network only: no STUN servers are configured, so peers on other networks cannot learn this host's public address"
+	}
+}
+
+// Write prints the report in a readable form
+func (r Report) Write(w io.Writer) {
+	fmt.Fprintln(w, "WebRTC connectivity report")
+
+	if len(r.Mappings) > 0 {
+		fmt.Fprintln(w, "\nSTUN servers")
+		for _, m := range r.Mappings {
+			if m.Err != nil {
+				fmt.Fprintf(w, "  %-35s no response (%v)\n", m.Server, m.Err)
+			} else {
+				fmt.Fprintf(w, "  %-35s sees us as %s\n", m.Server, m.Mapped)
+			}
+		}
+	}
+	fmt.Fprintf(w, "\nNAT mapping: %s\n", r.NAT)
+
+	if len(r.Ports) > 0 {
+		fmt.Fprintln(w, "\nUDP ports")
+		for _, p := range r.Ports {
+			switch {
+			case p.Err != nil:
+				fmt.Fprintf(w, "  %-6d unreachable (%v)\n", p.Port, p.Err)
+			case p.Mapped == nil:
+				fmt.Fprintf(w, "  %-6d bound locally\n", p.Port)
+			case p.Mapped.Port == p.Port:
+				fmt.Fprintf(w, "  %-6d reachable as %s (port preserved)\n", p.Port, p.Mapped)
+			default:
+				fmt.Fprintf(w, "  %-6d reachable as %s\n", p.Port, p.Mapped)
+			}
+		}
+	}
+
+	fmt.Fprint(w, "\nLoopback connection: ")
+	if r.Loopback.Connected {
+		fmt.Fprintf(w, "connected in %v", r.Loopback.Duration.Round(time.Millisecond))
+		if r.Loopback.Pair != "" {
+			fmt.Fprintf(w, " over %s candidates", r.Loopback.Pair)
+		}
+		fmt.Fprintln(w)
+	} else {
+		fmt.Fprintf(w, "failed (%v)\n", r.Loopback.Err)
+	}
+
+	fmt.Fprintf(w, "\nVerdict: %s\n", r.Verdict())
+}
+
+// STUNAddress turns a stun: URL into the host:port address Probe expects,
+// returning false for other ICE server URLs
+func STUNAddress(url string) (string, bool) {
+	if !strings.HasPrefix(url, "stun:") {
+		return "", false
+	}
+	addr := strings.TrimPrefix(url, "stun:")
+	if i := strings.Index(addr, "?"); i >= 0 {
+		addr = addr[:i]
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "3478")
+	}
+	return addr, true
+}
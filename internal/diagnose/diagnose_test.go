@@ -0,0 +1,117 @@
+package diagnose
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/stuntest"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestProbe(t *testing.T) {
+	server := stuntest.Start(t)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	mapped, err := Probe(conn, server, time.Second)
+	if err != nil {
+		t.Fatalf("Probe returned error: %v", err)
+	}
+	if mapped.String() != conn.LocalAddr().String() {
+		t.Errorf("Expected mapped address %s, got %s", conn.LocalAddr(), mapped)
+	}
+
+	t.Run("NoAnswer", func(t *testing.T) {
+		silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		defer silent.Close()
+		if _, err := Probe(conn, silent.LocalAddr().String(), 100*time.Millisecond); err == nil {
+			t.Error("Expected an error when the server does not answer")
+		}
+	})
+}
+
+func TestClassifyNAT(t *testing.T) {
+	public := func(ip string, port int) Mapping {
+		return Mapping{Mapped: &net.UDPAddr{IP: net.ParseIP(ip), Port: port}}
+	}
+	failed := Mapping{Err: errors.New("timeout")}
+
+	tests := []struct {
+		name     string
+		mappings []Mapping
+		want     string
+	}{
+		{"NoAnswers", []Mapping{failed, failed}, NATUnknown},
+		{"LocalAddress", []Mapping{public("127.0.0.1", 4000)}, NATNone},
+		{"SameMapping", []Mapping{public("203.0.113.4", 5000), failed, public("203.0.113.4", 5000)}, NATEndpointIndependent},
+		{"DifferentPorts", []Mapping{public("203.0.113.4", 5000), public("203.0.113.4", 5001)}, NATSymmetric},
+		{"DifferentAddresses", []Mapping{public("203.0.113.4", 5000), public("203.0.113.5", 5000)}, NATSymmetric},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyNAT(4000, tt.mappings); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSTUNAddress(t *testing.T) {
+	tests := map[string]string{
+		"stun:stun.l.google.com:19302":   "stun.l.google.com:19302",
+		"stun:stun.example.com":          "stun.example.com:3478",
+		"stun:stun.example.com:3478?x=1": "stun.example.com:3478",
+	}
+	for url, want := range tests {
+		if got, ok := STUNAddress(url); !ok || got != want {
+			t.Errorf("Expected %s for %s, got %s", want, url, got)
+		}
+	}
+	if _, ok := STUNAddress("turn:user:pass@turn.example.com:3478"); ok {
+		t.Error("Expected TURN URLs to be skipped")
+	}
+}
+
+func TestRun(t *testing.T) {
+	server := stuntest.Start(t)
+
+	settings := webrtc.SettingEngine{}
+	settings.SetICEMulticastDNSMode(0)
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(settings))
+
+	report := Run(Options{
+		STUNServers: []string{server},
+		Ports:       []int{0},
+		Timeout:     5 * time.Second,
+		API:         api,
+	})
+
+	if report.NAT != NATNone {
+		t.Errorf("Expected no NAT behind a local STUN server, got %s", report.NAT)
+	}
+	if len(report.Ports) != 1 || report.Ports[0].Err != nil || report.Ports[0].Mapped == nil {
+		t.Errorf("Expected the port to be reachable, got %+v", report.Ports)
+	}
+	if !report.Loopback.Connected {
+		t.Errorf("Expected the loopback connection to succeed, got %v", report.Loopback.Err)
+	}
+
+	var out bytes.Buffer
+	report.Write(&out)
+	for _, want := range []string{"STUN servers", "NAT mapping: none", "UDP ports", "Loopback connection: connected", "Verdict: Very likely"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected report to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,479 @@
+// Package discovery announces servers on the local network with multicast DNS
+// service discovery (mDNS and DNS-SD, RFC 6762 and 6763) and finds them, so
+// clients on a home or office network need not be given a server URL.
+// Servers answer queries for the _webrtc-poc._tcp service with their port,
+// addresses and a TXT record describing them: the offer path, their identity
+// and offer role, whether clients need a token and the names of their
+// exports. Browsing asks with one-shot queries from an ephemeral port, which
+// responders answer directly, so it works next to a system mDNS daemon.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// Service is the DNS-SD service servers are announced as
+	Service = "_webrtc-poc._tcp.local."
+	// groupAddr is the mDNS multicast group and port
+	groupAddr = "224.0.0.251:5353"
+	// mdnsPort is the port mDNS responders and full queriers use
+	mdnsPort = 5353
+	// ttl is how many seconds the records may be cached
+	ttl = 120
+	// maxTXT is the longest string a TXT record can hold
+	maxTXT = 255
+	// maxMessage is the largest message read
+	maxMessage = 9000
+)
+
+// Info is what a server announces about itself
+type Info struct {
+	// Instance names the server on the network
+	Instance string
+	Port     int
+	// Path is the path offers are posted to
+	Path string
+	// Identity is the server's identity, for --server-identity
+	Identity string
+	// OfferRole is the side that creates the offer
+	OfferRole string
+	// Auth is set if clients must present a token
+	Auth bool
+	// Exports are the names of the server's exports, as many as fit
+	Exports []string
+}
+
+// Server is a server found on the network
+type Server struct {
+	Info
+	// Addrs are the server's IPv4 addresses, the one it answered from first
+	Addrs []net.IP
+}
+
+// URL returns the offer URL of the server at its first address
+func (s Server) URL() string {
+	host := ""
+	if len(s.Addrs) > 0 {
+		host = s.Addrs[0].String()
+	}
+	return (&url.URL{Scheme: "http", Host: net.JoinHostPort(host, strconv.Itoa(s.Port)), Path: s.Path}).String()
+}
+
+// txt encodes the info as the strings of a TXT record
+func (info Info) txt() []string {
+	txt := []string{"path=" + info.Path}
+	if info.Identity != "" {
+		txt = append(txt, "id="+info.Identity)
+	}
+	if info.OfferRole != "" {
+		txt = append(txt, "role="+info.OfferRole)
+	}
+	if info.Auth {
+		txt = append(txt, "auth=1")
+	}
+	if len(info.Exports) > 0 {
+		exports := "exports="
+		for i, name := range info.Exports {
+			if i > 0 {
+				name = "," + name
+			}
+			if len(exports)+len(name) > maxTXT {
+				break
+			}
+			exports += name
+		}
+		txt = append(txt, exports)
+	}
+	return txt
+}
+
+// parseTXT fills in the info from the strings of a TXT record
+func (info *Info) parseTXT(txt []string) {
+	for _, s := range txt {
+		key, value, _ := strings.Cut(s, "=")
+		switch key {
+		case "path":
+			info.Path = value
+		case "id":
+			info.Identity = value
+		case "role":
+			info.OfferRole = value
+		case "auth":
+			info.Auth = value == "1"
+		case "exports":
+			if value != "" {
+				info.Exports = strings.Split(value, ",")
+			}
+		}
+	}
+}
+
+// label turns s into a single DNS label
+func label(s string) string {
+	s = strings.ReplaceAll(s, ".", "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
+
+// records returns the PTR record pointing to the server's instance, and the
+// SRV, TXT and A records describing it
+func records(info Info, host string, addrs []net.IP, ttl uint32) (dnsmessage.Resource, []dnsmessage.Resource, error) {
+	service, err := dnsmessage.NewName(Service)
+	if err != nil {
+		return dnsmessage.Resource{}, nil, err
+	}
+	instance, err := dnsmessage.NewName(label(info.Instance) + "." + Service)
+	if err != nil {
+		return dnsmessage.Resource{}, nil, err
+	}
+	target, err := dnsmessage.NewName(label(host) + ".local.")
+	if err != nil {
+		return dnsmessage.Resource{}, nil, err
+	}
+	header := func(name dnsmessage.Name) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: ttl}
+	}
+
+	ptr := dnsmessage.Resource{Header: header(service), Body: &dnsmessage.PTRResource{PTR: instance}}
+	described := []dnsmessage.Resource{
+		{Header: header(instance), Body: &dnsmessage.SRVResource{Port: uint16(info.Port), Target: target}},
+		{Header: header(instance), Body: &dnsmessage.TXTResource{TXT: info.txt()}},
+	}
+	for _, addr := range addrs {
+		if ip4 := addr.To4(); ip4 != nil {
+			described = append(described, dnsmessage.Resource{Header: header(target), Body: &dnsmessage.AResource{A: [4]byte(ip4)}})
+		}
+	}
+	return ptr, described, nil
+}
+
+// respond answers the questions of a query that ask for the service or for
+// the server, and reports whether there were any
+func respond(query dnsmessage.Message, info Info, host string, addrs []net.IP) (dnsmessage.Message, bool, error) {
+	resp := dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+	ptr, described, err := records(info, host, addrs, ttl)
+	if err != nil {
+		return resp, false, err
+	}
+	instance := described[0].Header.Name.String()
+
+	for _, q := range query.Questions {
+		name := q.Name.String()
+		all := q.Type == dnsmessage.TypeALL
+		switch {
+		case strings.EqualFold(name, Service) && (all || q.Type == dnsmessage.TypePTR):
+			resp.Answers = append(resp.Answers, ptr)
+			resp.Additionals = described
+		case strings.EqualFold(name, instance) && (all || q.Type == dnsmessage.TypeSRV || q.Type == dnsmessage.TypeTXT):
+			resp.Answers = described
+		}
+	}
+	return resp, len(resp.Answers) > 0, nil
+}
+
+// parse returns the servers a response describes, with from, the address
+// it came from, as their first address
+func parse(resp dnsmessage.Message, from net.IP) []Server {
+	all := append(slices.Clone(resp.Answers), resp.Additionals...)
+	addrs := make(map[string][]net.IP)
+	for _, r := range all {
+		if a, ok := r.Body.(*dnsmessage.AResource); ok {
+			name := strings.ToLower(r.Header.Name.String())
+			addrs[name] = append(addrs[name], net.IP(a.A[:]))
+		}
+	}
+
+	var servers []Server
+	for _, r := range all {
+		ptr, ok := r.Body.(*dnsmessage.PTRResource)
+		if !ok || !strings.EqualFold(r.Header.Name.String(), Service) {
+			continue
+		}
+		name := ptr.PTR.String()
+		var s Server
+		s.Instance = strings.TrimSuffix(name, "."+Service)
+		found := false
+		for _, r := range all {
+			if !strings.EqualFold(r.Header.Name.String(), name) {
+				continue
+			}
+			switch body := r.Body.(type) {
+			case *dnsmessage.SRVResource:
+				s.Port = int(body.Port)
+				s.Addrs = slices.Clone(addrs[strings.ToLower(body.Target.String())])
+				found = true
+			case *dnsmessage.TXTResource:
+				s.parseTXT(body.TXT)
+			}
+		}
+		if !found {
+			continue
+		}
+		if from != nil {
+			s.Addrs = slices.DeleteFunc(s.Addrs, from.Equal)
+			s.Addrs = append([]net.IP{from}, s.Addrs...)
+		}
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+// Advertiser answers queries for the service on behalf of a server
+type Advertiser struct {
+	conn  *ipv4.PacketConn
+	group *net.UDPAddr
+	host  string
+	info  func() Info
+}
+
+// Advertise announces the server described by info on the local network
+// until Close. info is called for every answer, so it stays current.
+func Advertise(info func() Info) (*Advertiser, error) {
+	group, err := net.ResolveUDPAddr("udp4", groupAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Share the port with a system mDNS daemon
+	lc := net.ListenConfig{Control: reusePort}
+	c, err := lc.ListenPacket(context.Background(), "udp4", fmt.Sprintf("0.0.0.0:%d", mdnsPort))
+	if err != nil {
+		return nil, fmt.Errorf("error listening for mDNS queries: %w", err)
+	}
+	conn := ipv4.NewPacketConn(c)
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	joined := 0
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if err := conn.JoinGroup(&ifi, group); err == nil {
+			joined++
+		}
+	}
+	if joined == 0 {
+		c.Close()
+		return nil, fmt.Errorf("no interface could join the mDNS group %s", group)
+	}
+	conn.SetMulticastLoopback(true)
+
+	host, _ := os.Hostname()
+	host, _, _ = strings.Cut(host, ".")
+	a := &Advertiser{conn: conn, group: group, host: host, info: info}
+	go a.serve()
+
+	// Announce the server right away for queriers that listen for it
+	if err := a.announce(ttl); err != nil {
+		logger.Info("Warning: failed to announce the server over mDNS: %v", err)
+	}
+	return a, nil
+}
+
+// serve answers queries until the connection is closed
+func (a *Advertiser) serve() {
+	buf := make([]byte, maxMessage)
+	for {
+		n, _, from, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil || query.Response {
+			continue
+		}
+		resp, ok, err := respond(query, a.info(), a.host, localAddrs())
+		if err != nil {
+			logger.Error("Failed to answer mDNS query: %v", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		// One-shot queriers on other ports are answered directly, like a
+		// unicast DNS server would, everyone else over the group
+		to := net.Addr(a.group)
+		if udp, ok := from.(*net.UDPAddr); ok && udp.Port != mdnsPort {
+			resp.ID, resp.Questions, to = query.ID, query.Questions, from
+		}
+		packed, err := resp.Pack()
+		if err != nil {
+			logger.Error("Failed to answer mDNS query: %v", err)
+			continue
+		}
+		if _, err := a.conn.WriteTo(packed, nil, to); err != nil {
+			logger.Debug("Failed to send mDNS answer to %s: %v", to, err)
+		}
+	}
+}
+
+// announce sends the server's records to the group, with a TTL of 0 to
+// withdraw them
+func (a *Advertiser) announce(ttl uint32) error {
+	ptr, described, err := records(a.info(), a.host, localAddrs(), ttl)
+	if err != nil {
+		return err
+	}
+	msg := dnsmessage.Message{
+		Header:      dnsmessage.Header{Response: true, Authoritative: true},
+		Answers:     []dnsmessage.Resource{ptr},
+		Additionals: described,
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = a.conn.WriteTo(packed, nil, a.group)
+	return err
+}
+
+// Close withdraws the announcement and stops answering queries
+func (a *Advertiser) Close() error {
+	a.announce(0)
+	return a.conn.Close()
+}
+
+// localAddrs returns the IPv4 addresses of this host, loopback ones only if
+// there are no others
+func localAddrs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips, loopback []net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.IsLoopback() {
+			loopback = append(loopback, ipnet.IP)
+		} else {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return loopback
+	}
+	return ips
+}
+
+// query returns a one-shot query for the service
+func query() ([]byte, error) {
+	service, err := dnsmessage.NewName(Service)
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(time.Now().UnixNano())},
+		Questions: []dnsmessage.Question{{Name: service, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	return msg.Pack()
+}
+
+// Browse queries the local network for servers, collects answers for
+// timeout and returns the servers found, sorted by name
+func Browse(timeout time.Duration) ([]Server, error) {
+	group, err := net.ResolveUDPAddr("udp4", groupAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	packed, err := query()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(packed, group); err != nil {
+		return nil, fmt.Errorf("error sending mDNS query: %w", err)
+	}
+	// Ask again halfway in case the first query was lost
+	resend := time.AfterFunc(timeout/2, func() { conn.WriteToUDP(packed, group) })
+	defer resend.Stop()
+
+	found := make(map[string]Server)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, maxMessage)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		var resp dnsmessage.Message
+		if err := resp.Unpack(buf[:n]); err != nil || !resp.Response {
+			continue
+		}
+		for _, s := range parse(resp, from.IP) {
+			if seen, ok := found[s.Instance]; ok {
+				for _, addr := range s.Addrs {
+					if !slices.ContainsFunc(seen.Addrs, addr.Equal) {
+						seen.Addrs = append(seen.Addrs, addr)
+					}
+				}
+				s = seen
+			}
+			found[s.Instance] = s
+		}
+	}
+
+	servers := make([]Server, 0, len(found))
+	for _, s := range found {
+		servers = append(servers, s)
+	}
+	slices.SortFunc(servers, func(a, b Server) int { return strings.Compare(a.Instance, b.Instance) })
+	return servers, nil
+}
+
+// Write prints the servers found as a table
+func Write(w io.Writer, servers []Server) {
+	if len(servers) == 0 {
+		fmt.Fprintln(w, "No servers found")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tURL\tIDENTITY\tEXPORTS\tNOTES")
+	for _, s := range servers {
+		var notes []string
+		if s.Auth {
+			notes = append(notes, "token required")
+		}
+		if s.OfferRole != "" && s.OfferRole != "client" {
+			notes = append(notes, "offer role "+s.OfferRole)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Instance, s.URL(), dash(s.Identity), dash(strings.Join(s.Exports, ",")), dash(strings.Join(notes, ", ")))
+	}
+	tw.Flush()
+}
+
+// dash returns s, or - if it is empty
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
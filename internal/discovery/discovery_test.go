@@ -0,0 +1,120 @@
+package discovery
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// roundTrip packs and unpacks a message like the network would
+func roundTrip(t *testing.T, msg dnsmessage.Message) dnsmessage.Message {
+	t.Helper()
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("Pack returned error: %v", err)
+	}
+	var out dnsmessage.Message
+	if err := out.Unpack(packed); err != nil {
+		t.Fatalf("Unpack returned error: %v", err)
+	}
+	return out
+}
+
+func TestRespond(t *testing.T) {
+	info := Info{Instance: "office.lan", Port: 8080, Path: "/offer", Identity: "abc", OfferRole: "server", Auth: true, Exports: []string{"app", "site"}}
+	addrs := []net.IP{net.ParseIP("192.168.1.20"), net.ParseIP("10.0.0.5"), net.ParseIP("fe80::1")}
+
+	packed, err := query()
+	if err != nil {
+		t.Fatalf("query returned error: %v", err)
+	}
+	var q dnsmessage.Message
+	if err := q.Unpack(packed); err != nil {
+		t.Fatalf("Unpack returned error: %v", err)
+	}
+	resp, ok, err := respond(q, info, "nas", addrs)
+	if err != nil || !ok {
+		t.Fatalf("Expected an answer to the service query, got %v, %v", ok, err)
+	}
+
+	servers := parse(roundTrip(t, resp), net.ParseIP("10.0.0.5"))
+	if len(servers) != 1 {
+		t.Fatalf("Expected one server, got %+v", servers)
+	}
+	s := servers[0]
+	want := info
+	want.Instance = "office-lan"
+	if !reflect.DeepEqual(s.Info, want) {
+		t.Errorf("Expected %+v, got %+v", want, s.Info)
+	}
+	if len(s.Addrs) != 2 || !s.Addrs[0].Equal(net.ParseIP("10.0.0.5")) || !s.Addrs[1].Equal(net.ParseIP("192.168.1.20")) {
+		t.Errorf("Expected the answering address first and no IPv6 address, got %v", s.Addrs)
+	}
+	if got := s.URL(); got != "http://10.0.0.5:8080/offer" {
+		t.Errorf("Expected the URL at the answering address, got %s", got)
+	}
+
+	// Queries for other services are left to other responders
+	other, _ := dnsmessage.NewName("_http._tcp.local.")
+	q.Questions[0].Name = other
+	if _, ok, _ := respond(q, info, "nas", addrs); ok {
+		t.Error("Expected no answer for another service")
+	}
+}
+
+func TestTXT(t *testing.T) {
+	var exports []string
+	for i := 0; i < 100; i++ {
+		exports = append(exports, "export")
+	}
+	txt := Info{Path: "/offer", Exports: exports}.txt()
+	if len(txt[len(txt)-1]) > maxTXT {
+		t.Errorf("Expected the exports to be cut to fit, got %d bytes", len(txt[len(txt)-1]))
+	}
+
+	var info Info
+	info.parseTXT(append(txt, "unknown=1", "auth=0"))
+	if info.Path != "/offer" || info.Auth || len(info.Exports) == 0 || len(info.Exports) >= 100 {
+		t.Errorf("Expected the exports that fit, got %+v", info)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	Write(&buf, nil)
+	if !strings.Contains(buf.String(), "No servers found") {
+		t.Errorf("Expected a note that no servers were found, got %q", buf.String())
+	}
+
+	buf.Reset()
+	Write(&buf, []Server{{Info: Info{Instance: "nas", Port: 8080, Path: "/offer", Auth: true}, Addrs: []net.IP{net.ParseIP("10.0.0.5")}}})
+	for _, want := range []string{"NAME", "nas", "http://10.0.0.5:8080/offer", "token required"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %q in the table, got %q", want, buf.String())
+		}
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	a, err := Advertise(func() Info { return Info{Instance: "test-browse", Port: 8080, Path: "/offer"} })
+	if err != nil {
+		t.Skipf("Multicast is not available: %v", err)
+	}
+	defer a.Close()
+
+	servers, err := Browse(time.Second)
+	if err != nil {
+		t.Fatalf("Browse returned error: %v", err)
+	}
+	for _, s := range servers {
+		if s.Instance == "test-browse" {
+			return
+		}
+	}
+	t.Skipf("Multicast queries did not reach the advertiser, found %+v", servers)
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package discovery
+
+import "syscall"
+
+// reusePort leaves the socket as it is where ports cannot be shared the same
+// way; the port must then be free
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
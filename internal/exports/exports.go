@@ -0,0 +1,203 @@
+// Package exports lets one server stream several files, directories or glob
+// patterns that clients request by name, each with its own mode, allowed
+// client identities, rate limit and whether lines appended to it are
+// followed. The exports are configured in the server's config file and can be
+// replaced while the server runs; transfers already started keep the export
+// they started with.
+package exports
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/developmeh/webrtc-poc/internal/bundle"
+	"github.com/developmeh/webrtc-poc/internal/pacing"
+	"github.com/developmeh/webrtc-poc/internal/pathpolicy"
+)
+
+const (
+	// Once streams the export once, the default
+	Once = "once"
+	// Loop streams the export again from the start whenever it ends, until
+	// the client disconnects
+	Loop = "loop"
+)
+
+var (
+	// ErrUnknown is returned for exports that are not configured
+	ErrUnknown = errors.New("unknown export")
+	// ErrForbidden is returned for clients whose identity an export does
+	// not allow
+	ErrForbidden = errors.New("export not allowed")
+)
+
+// Export is a file, directory or glob pattern streamed under a name
+type Export struct {
+	Name string
+	Path string
+	// Mode is Once or Loop
+	Mode string
+	// Allow lists the client identities that may request the export, any
+	// client may if it is empty
+	Allow []string
+	// Rate limits the bytes per second sent by all transfers of the export
+	// together, with an optional KiB, MiB or GiB suffix and /s
+	Rate string
+	// Watch keeps streaming the lines appended to a single file until the
+	// client disconnects
+	Watch bool
+	// Bundle is set for directories and glob patterns
+	Bundle bool
+
+	limit *pacing.Pacer
+}
+
+// Allows reports whether a client with identity may request the export
+func (e *Export) Allows(identity string) bool {
+	return len(e.Allow) == 0 || slices.Contains(e.Allow, identity)
+}
+
+// Limit returns the pacer shared by the transfers of the export, or nil if
+// its rate is not limited
+func (e *Export) Limit() *pacing.Pacer {
+	return e.limit
+}
+
+// check validates an export and prepares its rate limit
+func (e *Export) check() error {
+	if e.Name == "" {
+		return errors.New("export without a name")
+	}
+	if e.Path == "" {
+		return fmt.Errorf("export %q has no path", e.Name)
+	}
+	switch e.Mode {
+	case "":
+		e.Mode = Once
+	case Once, Loop:
+	default:
+		return fmt.Errorf("export %q has unknown mode %q (expected %s or %s)", e.Name, e.Mode, Once, Loop)
+	}
+
+	e.Bundle = bundle.IsBundle(e.Path)
+	if e.Bundle {
+		if _, err := bundle.List(e.Path); err != nil {
+			return fmt.Errorf("export %q: %w", e.Name, err)
+		}
+	} else if _, err := os.Stat(e.Path); err != nil {
+		return fmt.Errorf("export %q: %w", e.Name, err)
+	}
+	if e.Watch && e.Bundle {
+		return fmt.Errorf("export %q: only single files can be watched", e.Name)
+	}
+	if e.Watch && e.Mode == Loop {
+		return fmt.Errorf("export %q: a watched file never ends, so it cannot loop", e.Name)
+	}
+
+	if e.Rate != "" {
+		schedule, err := pacing.Parse([]string{"*=" + e.Rate})
+		if err != nil {
+			return fmt.Errorf("export %q: invalid rate: %w", e.Name, err)
+		}
+		e.limit = pacing.NewPacer(schedule)
+	}
+	return nil
+}
+
+// Set holds the configured exports by name
+type Set struct {
+	mu      sync.RWMutex
+	exports map[string]*Export
+}
+
+// NewSet creates an empty set
+func NewSet() *Set {
+	return &Set{exports: make(map[string]*Export)}
+}
+
+// Load validates exports and replaces the set with them. If any of them is
+// invalid the set is left as it was. Exports whose configuration did not
+// change keep their rate limit, so reloading does not reset it.
+func (s *Set) Load(exports []Export) error {
+	loaded := make(map[string]*Export, len(exports))
+	for i := range exports {
+		e := exports[i]
+		if err := e.check(); err != nil {
+			return err
+		}
+		if _, ok := loaded[e.Name]; ok {
+			return fmt.Errorf("export %q is configured twice", e.Name)
+		}
+		loaded[e.Name] = &e
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, e := range loaded {
+		if old, ok := s.exports[name]; ok && old.Rate == e.Rate {
+			e.limit = old.limit
+		}
+	}
+	s.exports = loaded
+	return nil
+}
+
+// Lookup returns the export called name
+func (s *Set) Lookup(name string) (*Export, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.exports[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknown, name)
+	}
+	return e, nil
+}
+
+// Names returns the names of the exports, sorted
+func (s *Set) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.exports))
+	for name := range s.exports {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Holds reports whether path is the file of an export or inside the
+// directory of one, checked with the path policy of followSymlinks. Glob
+// patterns hold no path, since the files they match change.
+func (s *Set) Holds(path string, followSymlinks bool) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, e := range s.exports {
+		info, err := os.Stat(e.Path)
+		if err != nil {
+			continue
+		}
+		root := e.Path
+		if !info.IsDir() {
+			root = filepath.Dir(e.Path)
+		}
+		policy, err := pathpolicy.New(root, followSymlinks)
+		if err != nil {
+			continue
+		}
+		checked, err := policy.Check(path)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			return true
+		}
+		if file, err := pathpolicy.Canonical(e.Path); err == nil && checked == file {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package exports
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(file, []byte("line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSet()
+	err := s.Load([]Export{
+		{Name: "app", Path: file, Watch: true, Rate: "64KiB/s"},
+		{Name: "all", Path: filepath.Join(dir, "*.log"), Mode: Loop, Allow: []string{"abc"}},
+	})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if got, want := s.Names(), []string{"all", "app"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	app, err := s.Lookup("app")
+	if err != nil {
+		t.Fatalf("Lookup returned error: %v", err)
+	}
+	if app.Mode != Once || app.Bundle || app.Limit() == nil || !app.Allows("") {
+		t.Errorf("Expected a rate limited single file export any client may request, got %+v", app)
+	}
+	all, _ := s.Lookup("all")
+	if !all.Bundle || all.Limit() != nil || all.Allows("") || !all.Allows("abc") {
+		t.Errorf("Expected an unlimited bundle only abc may request, got %+v", all)
+	}
+	if _, err := s.Lookup("none"); !errors.Is(err, ErrUnknown) {
+		t.Errorf("Expected ErrUnknown, got %v", err)
+	}
+
+	t.Run("Reload", func(t *testing.T) {
+		if err := s.Load([]Export{{Name: "app", Path: file, Rate: "64KiB/s"}}); err != nil {
+			t.Fatalf("Load returned error: %v", err)
+		}
+		reloaded, _ := s.Lookup("app")
+		if reloaded.Limit() != app.Limit() {
+			t.Error("Expected an unchanged rate to keep its limit")
+		}
+		if _, err := s.Lookup("all"); !errors.Is(err, ErrUnknown) {
+			t.Errorf("Expected the removed export to be gone, got %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, exports := range map[string][]Export{
+			"no name":      {{Path: file}},
+			"no path":      {{Name: "a"}},
+			"missing":      {{Name: "a", Path: filepath.Join(dir, "none.log")}},
+			"empty glob":   {{Name: "a", Path: filepath.Join(dir, "*.none")}},
+			"mode":         {{Name: "a", Path: file, Mode: "twice"}},
+			"rate":         {{Name: "a", Path: file, Rate: "fast"}},
+			"watch bundle": {{Name: "a", Path: dir, Watch: true}},
+			"watch loop":   {{Name: "a", Path: file, Watch: true, Mode: Loop}},
+			"duplicate":    {{Name: "a", Path: file}, {Name: "a", Path: file}},
+		} {
+			if err := s.Load(exports); err == nil {
+				t.Errorf("Expected %s to be refused", name)
+			}
+		}
+		if got := s.Names(); !reflect.DeepEqual(got, []string{"app"}) {
+			t.Errorf("Expected an invalid configuration to leave the exports alone, got %v", got)
+		}
+	})
+}
+
+func TestHolds(t *testing.T) {
+	dir := t.TempDir()
+	logs := filepath.Join(dir, "logs")
+	os.Mkdir(logs, 0755)
+	for _, name := range []string{"app.log", "other.log", filepath.Join("logs", "a.log")} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("line\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Symlink(filepath.Join(dir, "other.log"), filepath.Join(logs, "link.log"))
+
+	s := NewSet()
+	err := s.Load([]Export{
+		{Name: "app", Path: filepath.Join(dir, "app.log")},
+		{Name: "logs", Path: logs},
+		{Name: "all", Path: filepath.Join(dir, "*.log")},
+	})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	for path, want := range map[string]bool{
+		filepath.Join(dir, "app.log"):          true,
+		filepath.Join(logs, "a.log"):           true,
+		filepath.Join(logs, "..", "app.log"):   true,
+		filepath.Join(dir, "other.log"):        false,
+		filepath.Join(logs, "..", "other.log"): false,
+		filepath.Join(logs, "link.log"):        false,
+		"/etc/passwd":                          false,
+	} {
+		if got := s.Holds(path, false); got != want {
+			t.Errorf("Holds(%s) = %v, expected %v", path, got, want)
+		}
+	}
+	if !s.Holds(filepath.Join(logs, "link.log"), true) {
+		t.Error("Expected a symlink in an exported directory to be held when symlinks are followed")
+	}
+}
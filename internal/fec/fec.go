@@ -0,0 +1,346 @@
+// Package fec adds Reed-Solomon forward error correction to a stream of lines
+// sent over an unreliable, unordered data channel. Lines are grouped, each
+// line is sent as a data shard of its group and every full group is followed
+// by parity shards, so a receiver can rebuild the lines of a group from any
+// of its shards as long as no more than the parity count went missing. Every
+// shard is one binary message: a header naming its group, its index in the
+// group, the group's data and parity counts and, for parity shards, how many
+// lines the group holds, followed by the shard. A data shard is the line's
+// length plus one as a big endian uint16 and the line; a parity shard is as
+// long as the longest data shard of its group.
+package fec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	headerSize = 8
+	// MaxLine is the longest line that fits a shard into one message
+	MaxLine = 65535 - headerSize - 2
+	// maxPending is how many groups a receiver waits for a group's missing
+	// shards before giving up on them
+	maxPending = 16
+)
+
+// ParseRatio parses a DATA:PARITY redundancy ratio such as 10:2, ten lines
+// protected by two parity shards
+func ParseRatio(spec string) (data, parity int, err error) {
+	d, p, ok := strings.Cut(spec, ":")
+	if ok {
+		data, err = strconv.Atoi(d)
+		if err == nil {
+			parity, err = strconv.Atoi(p)
+		}
+	}
+	if !ok || err != nil {
+		return 0, 0, fmt.Errorf("invalid FEC ratio %q (expected DATA:PARITY, e.g. 10:2)", spec)
+	}
+	if data < 1 || parity < 1 || data+parity > 255 {
+		return 0, 0, fmt.Errorf("invalid FEC ratio %q: need at least one data and one parity shard and at most 255 in total", spec)
+	}
+	return data, parity, nil
+}
+
+// frame prepends the shard header to a shard
+func frame(group uint32, index, data, parity, count int, shard []byte) []byte {
+	msg := make([]byte, headerSize+len(shard))
+	binary.BigEndian.PutUint32(msg, group)
+	msg[4], msg[5], msg[6], msg[7] = byte(index), byte(data), byte(parity), byte(count)
+	copy(msg[headerSize:], shard)
+	return msg
+}
+
+// Encoder turns lines into shards
+type Encoder struct {
+	code   *code
+	group  uint32
+	shards [][]byte
+}
+
+// NewEncoder returns an encoder that follows every group of data lines with
+// parity shards
+func NewEncoder(data, parity int) (*Encoder, error) {
+	if data < 1 || parity < 1 || data+parity > 255 {
+		return nil, fmt.Errorf("invalid FEC ratio %d:%d", data, parity)
+	}
+	return &Encoder{code: newCode(data, parity)}, nil
+}
+
+// Add encodes a line and returns the messages to send: its data shard and,
+// once the line completes a group, the group's parity shards
+func (e *Encoder) Add(line string) ([][]byte, error) {
+	if len(line) > MaxLine {
+		return nil, fmt.Errorf("line of %d bytes is too long for FEC (at most %d)", len(line), MaxLine)
+	}
+	shard := make([]byte, 2+len(line))
+	binary.BigEndian.PutUint16(shard, uint16(len(line)+1))
+	copy(shard[2:], line)
+
+	msgs := [][]byte{frame(e.group, len(e.shards), e.code.data, e.code.parity, 0, shard)}
+	e.shards = append(e.shards, shard)
+	if len(e.shards) == e.code.data {
+		msgs = append(msgs, e.finish()...)
+	}
+	return msgs, nil
+}
+
+// Flush returns the parity shards of the last group if it is not full
+func (e *Encoder) Flush() [][]byte {
+	if len(e.shards) == 0 {
+		return nil
+	}
+	return e.finish()
+}
+
+// finish returns the parity shards of the current group and starts the
+// next. Missing data shards of a short group count as zeros, which decode as
+// no line.
+func (e *Encoder) finish() [][]byte {
+	size := 0
+	for _, shard := range e.shards {
+		size = max(size, len(shard))
+	}
+	padded := make([][]byte, e.code.data)
+	for i := range padded {
+		padded[i] = make([]byte, size)
+		if i < len(e.shards) {
+			copy(padded[i], e.shards[i])
+		}
+	}
+
+	msgs := make([][]byte, 0, e.code.parity)
+	for i, shard := range e.code.encode(padded) {
+		msgs = append(msgs, frame(e.group, e.code.data+i, e.code.data, e.code.parity, len(e.shards), shard))
+	}
+	e.group++
+	e.shards = nil
+	return msgs
+}
+
+// ErrInvalidShard is returned for messages that cannot be a shard
+var ErrInvalidShard = errors.New("invalid FEC shard")
+
+// Decoder rebuilds lines from shards arriving in any order. A shard is keyed
+// by its group and index, so one arriving again is counted and dropped
+// instead of returning its line twice.
+type Decoder struct {
+	groups map[uint32]*group
+	// next is the group whose lines are returned next
+	next uint32
+	// returned marks the data shards whose lines were returned, for the
+	// last maxPending groups, to tell duplicates from late shards
+	returned map[uint32][]bool
+	// size is the data count of the groups seen, to estimate the lines of a
+	// group of which nothing arrived
+	size       int
+	recovered  int
+	lost       int
+	duplicates int
+}
+
+// group collects the shards of one group
+type group struct {
+	data, parity int
+	// count is the number of lines, once a parity shard told it
+	count  int
+	shards [][]byte
+	have   int
+	// lines are set once the group is decoded
+	lines []string
+	done  bool
+}
+
+// NewDecoder returns an empty decoder
+func NewDecoder() *Decoder {
+	return &Decoder{groups: make(map[uint32]*group), returned: make(map[uint32][]bool)}
+}
+
+// Add takes a shard and returns the lines that are now complete, in order.
+// A group still missing shards once maxPending later groups started is
+// given up on, returning the lines of the data shards that did arrive.
+func (d *Decoder) Add(msg []byte) ([]string, error) {
+	if len(msg) < headerSize {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidShard)
+	}
+	id := binary.BigEndian.Uint32(msg)
+	index, data, parity, count := int(msg[4]), int(msg[5]), int(msg[6]), int(msg[7])
+	if data < 1 || index >= data+parity || count > data {
+		return nil, fmt.Errorf("%w: invalid header", ErrInvalidShard)
+	}
+	if id < d.next {
+		// The group's lines were returned already, so the shard is either
+		// one of them again or too late to be of use
+		if seen := d.returned[id]; index < len(seen) && seen[index] {
+			d.duplicates++
+		}
+		return d.drain(id), nil
+	}
+
+	g := d.groups[id]
+	if g == nil {
+		g = &group{data: data, parity: parity, shards: make([][]byte, data+parity)}
+		d.groups[id] = g
+		d.size = max(d.size, data)
+	}
+	if g.data != data || g.parity != parity {
+		return nil, fmt.Errorf("shard of group %d does not match the group's ratio", id)
+	}
+	if g.shards[index] != nil {
+		d.duplicates++
+	} else if !g.done {
+		g.shards[index] = msg[headerSize:]
+		g.have++
+		if index >= data {
+			g.count = count
+		}
+		if err := d.decode(g); err != nil {
+			return nil, fmt.Errorf("error decoding group %d: %w", id, err)
+		}
+	}
+	return d.drain(id), nil
+}
+
+// Flush returns the lines of every remaining group at the end of the stream,
+// including what arrived of the groups that cannot be decoded
+func (d *Decoder) Flush() []string {
+	var lines []string
+	for len(d.groups) > 0 {
+		lines = append(lines, d.take()...)
+	}
+	return lines
+}
+
+// Stats returns the number of lines rebuilt from parity shards and the
+// number of lines lost
+func (d *Decoder) Stats() (recovered, lost int) {
+	return d.recovered, d.lost
+}
+
+// Duplicates returns the number of shards dropped because the same shard
+// arrived before
+func (d *Decoder) Duplicates() int {
+	return d.duplicates
+}
+
+// drain returns the lines of the decoded groups that are next in order, and
+// gives up on the groups too far behind latest
+func (d *Decoder) drain(latest uint32) []string {
+	var lines []string
+	for {
+		g := d.groups[d.next]
+		if (g == nil || !g.done) && latest < d.next+maxPending {
+			return lines
+		}
+		lines = append(lines, d.take()...)
+	}
+}
+
+// take returns the lines of the next group, decoded or not, and moves on
+func (d *Decoder) take() []string {
+	id := d.next
+	g := d.groups[id]
+	delete(d.groups, id)
+	delete(d.returned, id-maxPending)
+	d.next++
+
+	if g == nil {
+		d.lost += d.size
+		return nil
+	}
+	seen := make([]bool, g.data)
+	d.returned[id] = seen
+	if g.done {
+		for i := range seen {
+			seen[i] = true
+		}
+		return g.lines
+	}
+
+	// Keep the lines that arrived
+	var lines []string
+	for i, shard := range g.shards[:g.data] {
+		if line, ok := parseShard(shard); ok {
+			lines = append(lines, line)
+			seen[i] = true
+		}
+	}
+	expected := g.data
+	if g.count > 0 {
+		expected = g.count
+	}
+	d.lost += max(expected-len(lines), 0)
+	return lines
+}
+
+// decode rebuilds a group's missing data shards once enough shards arrived
+func (d *Decoder) decode(g *group) error {
+	// Data shards past the line count of a short group are never sent and
+	// known to be zeros
+	known := g.have
+	if g.count > 0 {
+		known += g.data - g.count
+	}
+	if known < g.data {
+		return nil
+	}
+
+	missing := 0
+	for i := range g.shards[:g.data] {
+		if g.shards[i] == nil && (g.count == 0 || i < g.count) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		size := 0
+		for i := g.data; i < len(g.shards); i++ {
+			if g.shards[i] != nil {
+				size = len(g.shards[i])
+				break
+			}
+		}
+		shards := make([][]byte, len(g.shards))
+		for i, shard := range g.shards {
+			if shard == nil {
+				if i >= g.count && i < g.data && g.count > 0 {
+					shards[i] = make([]byte, size)
+				}
+				continue
+			}
+			if len(shard) > size {
+				return errors.New("data shard longer than the parity shards")
+			}
+			shards[i] = make([]byte, size)
+			copy(shards[i], shard)
+		}
+		if err := newCode(g.data, g.parity).reconstruct(shards); err != nil {
+			return err
+		}
+		g.shards = shards
+		d.recovered += missing
+	}
+
+	for _, shard := range g.shards[:g.data] {
+		if line, ok := parseShard(shard); ok {
+			g.lines = append(g.lines, line)
+		}
+	}
+	g.done = true
+	return nil
+}
+
+// parseShard returns the line in a data shard, if it holds one
+func parseShard(shard []byte) (string, bool) {
+	if len(shard) < 2 {
+		return "", false
+	}
+	n := int(binary.BigEndian.Uint16(shard))
+	if n == 0 || n-1 > len(shard)-2 {
+		return "", false
+	}
+	return string(shard[2 : 2+n-1]), true
+}
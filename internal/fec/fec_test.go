@@ -0,0 +1,212 @@
+package fec
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseRatio(t *testing.T) {
+	data, parity, err := ParseRatio("10:2")
+	if err != nil || data != 10 || parity != 2 {
+		t.Errorf("Expected 10:2, got %d:%d, %v", data, parity, err)
+	}
+	for _, spec := range []string{"", "10", "10:", "x:2", "0:2", "10:0", "200:100"} {
+		if _, _, err := ParseRatio(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestCode(t *testing.T) {
+	c := newCode(4, 3)
+	data := [][]byte{[]byte("abcd"), []byte("efgh"), []byte("ijkl"), []byte("mnop")}
+	parity := c.encode(data)
+
+	// Every way of losing up to three shards is recoverable
+	for lost := 0; lost < 1<<7; lost++ {
+		shards := append(slices.Clone(data), parity...)
+		n := 0
+		for i := range shards {
+			if lost&(1<<i) != 0 {
+				shards[i] = nil
+				n++
+			}
+		}
+		if n > 3 {
+			continue
+		}
+		if err := c.reconstruct(shards); err != nil {
+			t.Fatalf("reconstruct returned error after losing %07b: %v", lost, err)
+		}
+		for i := range data {
+			if string(shards[i]) != string(data[i]) {
+				t.Errorf("Expected shard %d to be rebuilt after losing %07b, got %q", i, lost, shards[i])
+			}
+		}
+	}
+
+	if err := c.reconstruct([][]byte{data[0], nil, nil, nil, parity[0], nil, nil}); err == nil {
+		t.Error("Expected an error with fewer shards than data shards")
+	}
+}
+
+// encodeAll encodes lines and returns the messages in sending order
+func encodeAll(t *testing.T, lines []string, data, parity int) [][]byte {
+	enc, err := NewEncoder(data, parity)
+	if err != nil {
+		t.Fatalf("NewEncoder returned error: %v", err)
+	}
+	var msgs [][]byte
+	for _, line := range lines {
+		m, err := enc.Add(line)
+		if err != nil {
+			t.Fatalf("Add returned error: %v", err)
+		}
+		msgs = append(msgs, m...)
+	}
+	return append(msgs, enc.Flush()...)
+}
+
+// decodeAll feeds messages to a decoder and returns every line
+func decodeAll(t *testing.T, msgs [][]byte) ([]string, *Decoder) {
+	dec := NewDecoder()
+	var lines []string
+	for _, msg := range msgs {
+		got, err := dec.Add(msg)
+		if err != nil {
+			t.Fatalf("Add returned error: %v", err)
+		}
+		lines = append(lines, got...)
+	}
+	return append(lines, dec.Flush()...), dec
+}
+
+func TestStream(t *testing.T) {
+	// 103 lines make a short last group, and include an empty line
+	lines := make([]string, 103)
+	for i := range lines {
+		lines[i] = strings.Repeat(fmt.Sprint(i), i%7)
+	}
+	msgs := encodeAll(t, lines, 10, 3)
+	if len(msgs) != 103+11*3 {
+		t.Fatalf("Expected 136 messages, got %d", len(msgs))
+	}
+
+	t.Run("Lossless", func(t *testing.T) {
+		got, dec := decodeAll(t, msgs)
+		if !slices.Equal(got, lines) {
+			t.Errorf("Expected the lines back, got %q", got)
+		}
+		if recovered, lost := dec.Stats(); recovered != 0 || lost != 0 {
+			t.Errorf("Expected nothing recovered or lost, got %d, %d", recovered, lost)
+		}
+	})
+
+	t.Run("ReorderedWithLoss", func(t *testing.T) {
+		// Drop up to three shards of every group, including the last, and
+		// shuffle the rest within a few groups of their position
+		var kept [][]byte
+		for i, msg := range msgs {
+			if msg[4]%5 != 1 || i%2 == 0 {
+				kept = append(kept, msg)
+			}
+		}
+		r := rand.New(rand.NewSource(1))
+		for i := range kept {
+			j := min(i+r.Intn(30), len(kept)-1)
+			kept[i], kept[j] = kept[j], kept[i]
+		}
+
+		got, dec := decodeAll(t, kept)
+		if !slices.Equal(got, lines) {
+			t.Errorf("Expected the lines back, got %q", got)
+		}
+		if recovered, lost := dec.Stats(); recovered == 0 || lost != 0 {
+			t.Errorf("Expected lines to be recovered and none lost, got %d, %d", recovered, lost)
+		}
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		// Every shard arrives twice, some again right away and some once
+		// their group was returned, and every line still comes back once
+		var doubled [][]byte
+		for i, msg := range msgs {
+			doubled = append(doubled, msg)
+			if i%2 == 0 {
+				doubled = append(doubled, msg)
+			}
+		}
+		for i, msg := range msgs {
+			if i%2 == 1 {
+				doubled = append(doubled, msg)
+			}
+		}
+		got, dec := decodeAll(t, doubled)
+		if !slices.Equal(got, lines) {
+			t.Errorf("Expected the lines back once, got %q", got)
+		}
+		if recovered, lost := dec.Stats(); recovered != 0 || lost != 0 {
+			t.Errorf("Expected nothing recovered or lost, got %d, %d", recovered, lost)
+		}
+		// Parity shards arriving again once their group was returned are of
+		// no use, but do not repeat a line
+		if dupes := dec.Duplicates(); dupes != len(lines) {
+			t.Errorf("Expected %d duplicates, got %d", len(lines), dupes)
+		}
+	})
+
+	t.Run("LateShardOfRebuiltLine", func(t *testing.T) {
+		// A data shard arriving after its line was rebuilt from parity is a
+		// duplicate of that line
+		late := msgs[0]
+		got, dec := decodeAll(t, append(append([][]byte(nil), msgs[1:]...), late))
+		if !slices.Equal(got, lines) {
+			t.Errorf("Expected the lines back once, got %q", got)
+		}
+		if recovered, _ := dec.Stats(); recovered != 1 {
+			t.Errorf("Expected 1 recovered line, got %d", recovered)
+		}
+		if dupes := dec.Duplicates(); dupes != 1 {
+			t.Errorf("Expected 1 duplicate, got %d", dupes)
+		}
+	})
+
+	t.Run("TooMuchLoss", func(t *testing.T) {
+		// Losing four data shards of the first group loses them, but keeps
+		// the group's other lines and every later group
+		var kept [][]byte
+		for _, msg := range msgs {
+			if msg[3] == 0 && msg[4] < 4 {
+				continue
+			}
+			kept = append(kept, msg)
+		}
+		got, dec := decodeAll(t, kept)
+		if !slices.Equal(got, lines[4:]) {
+			t.Errorf("Expected all but the first four lines, got %q", got)
+		}
+		if _, lost := dec.Stats(); lost != 4 {
+			t.Errorf("Expected 4 lost lines, got %d", lost)
+		}
+	})
+}
+
+func TestLongLine(t *testing.T) {
+	enc, _ := NewEncoder(4, 1)
+	if _, err := enc.Add(strings.Repeat("x", MaxLine+1)); err == nil {
+		t.Error("Expected an error for a line that does not fit a message")
+	}
+}
+
+func TestInvalidShard(t *testing.T) {
+	dec := NewDecoder()
+	for _, msg := range [][]byte{{1, 2, 3}, {0, 0, 0, 1, 9, 4, 1, 4, 0, 0}} {
+		if _, err := dec.Add(msg); !errors.Is(err, ErrInvalidShard) {
+			t.Errorf("Expected ErrInvalidShard for %v, got %v", msg, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package fec
+
+import "errors"
+
+// GF(2^8) arithmetic with the polynomial x^8 + x^4 + x^3 + x^2 + 1
+var (
+	gfExp [510]byte
+	gfLog [256]int
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+// code is a systematic Reed-Solomon code: the data shards are sent as they
+// are, and each parity shard is a combination of them given by a row of a
+// Cauchy matrix. Every choice of data rows from the identity stacked on a
+// Cauchy matrix is invertible, so any data shards recover the others.
+type code struct {
+	data, parity int
+	// rows are the coefficients of the parity shards
+	rows [][]byte
+}
+
+func newCode(data, parity int) *code {
+	c := &code{data: data, parity: parity, rows: make([][]byte, parity)}
+	for i := range c.rows {
+		c.rows[i] = make([]byte, data)
+		for j := range c.rows[i] {
+			c.rows[i][j] = gfInv(byte(data+i) ^ byte(j))
+		}
+	}
+	return c
+}
+
+// row returns the coefficients of shard i, data or parity
+func (c *code) row(i int) []byte {
+	if i >= c.data {
+		return c.rows[i-c.data]
+	}
+	r := make([]byte, c.data)
+	r[i] = 1
+	return r
+}
+
+// encode computes the parity shards of equally long data shards
+func (c *code) encode(data [][]byte) [][]byte {
+	size := len(data[0])
+	parity := make([][]byte, c.parity)
+	for i, row := range c.rows {
+		parity[i] = make([]byte, size)
+		for j, shard := range data {
+			mulAdd(parity[i], shard, row[j])
+		}
+	}
+	return parity
+}
+
+// reconstruct fills in the missing data shards from any data of the
+// data+parity shards, which must all have the same length
+func (c *code) reconstruct(shards [][]byte) error {
+	var have []int
+	for i, shard := range shards {
+		if shard != nil {
+			have = append(have, i)
+		}
+		if len(have) == c.data {
+			break
+		}
+	}
+	if len(have) < c.data {
+		return errors.New("not enough shards to reconstruct")
+	}
+
+	m := make([][]byte, c.data)
+	for i, shard := range have {
+		m[i] = append([]byte(nil), c.row(shard)...)
+	}
+	inv, err := invert(m)
+	if err != nil {
+		return err
+	}
+
+	size := len(shards[have[0]])
+	for i := 0; i < c.data; i++ {
+		if shards[i] != nil {
+			continue
+		}
+		out := make([]byte, size)
+		for j, shard := range have {
+			mulAdd(out, shards[shard], inv[i][j])
+		}
+		shards[i] = out
+	}
+	return nil
+}
+
+// mulAdd adds in times c to out
+func mulAdd(out, in []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	for i, b := range in {
+		out[i] ^= gfMul(b, c)
+	}
+}
+
+// invert returns the inverse of a square matrix by Gauss-Jordan elimination
+func invert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && m[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("singular matrix")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		scale := gfInv(m[col][col])
+		for j := 0; j < n; j++ {
+			m[col][j] = gfMul(m[col][j], scale)
+			inv[col][j] = gfMul(inv[col][j], scale)
+		}
+		for row := 0; row < n; row++ {
+			if row == col || m[row][col] == 0 {
+				continue
+			}
+			f := m[row][col]
+			for j := 0; j < n; j++ {
+				m[row][j] ^= gfMul(f, m[col][j])
+				inv[row][j] ^= gfMul(f, inv[col][j])
+			}
+		}
+	}
+	return inv, nil
+}
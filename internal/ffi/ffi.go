@@ -0,0 +1,213 @@
+// Package ffi runs send and receive sessions behind integer handles, for the
+// C ABI of cmd/libwebrtcpoc. Programs in other languages start a session,
+// poll its status as JSON and stop it, without holding Go values across the
+// boundary. A send session serves the offer endpoint of a package sender
+// Sender over HTTP; a receive session posts its offer to one with package
+// receiver and writes the lines to a file.
+package ffi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/pkg/receiver"
+	"github.com/developmeh/webrtc-poc/pkg/sender"
+)
+
+// Kinds of sessions
+const (
+	Send    = "send"
+	Receive = "receive"
+)
+
+// States of a session
+const (
+	Running = "running"
+	Done    = "done"
+	Failed  = "failed"
+)
+
+// ErrUnknownHandle is returned for handles that name no session, such as
+// those of stopped sessions
+var ErrUnknownHandle = errors.New("unknown session handle")
+
+// Status describes a session, as the JSON the C ABI returns
+type Status struct {
+	Kind  string `json:"kind"`
+	State string `json:"state"`
+	// Addr is the address a send session serves its offer endpoint on
+	Addr string `json:"addr,omitempty"`
+	// Lines and Bytes count what was sent to every receiver so far, or
+	// received
+	Lines int64 `json:"lines"`
+	Bytes int64 `json:"bytes"`
+	// Transfers counts the transfers of a send session that ended, and
+	// Active those still running
+	Transfers int `json:"transfers,omitempty"`
+	Active    int `json:"active,omitempty"`
+	// Verified is set once a received stream matched the sender's digest
+	Verified bool   `json:"verified,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// session is one running send or receive session
+type session struct {
+	mu     sync.Mutex
+	status Status
+	// sent holds the progress of each transfer of a send session
+	sent map[string]sender.Progress
+	// stop ends the session and waits until it ended
+	stop func()
+}
+
+var (
+	mu       sync.Mutex
+	sessions = make(map[int64]*session)
+	next     int64
+)
+
+// add registers a session and returns its handle
+func add(s *session) int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	next++
+	sessions[next] = s
+	return next
+}
+
+// StartSend serves the offer endpoint of a sender of file at /offer on addr,
+// waiting delay between lines, and returns the session's handle. Every
+// receiver that posts an offer gets the whole file.
+func StartSend(file, addr string, delay time.Duration) (int64, error) {
+	if _, err := os.Stat(file); err != nil {
+		return 0, err
+	}
+	s := &session{status: Status{Kind: Send, State: Running}, sent: make(map[string]sender.Progress)}
+	snd, err := sender.New(sender.Options{File: file, Delay: delay, OnProgress: s.progress})
+	if err != nil {
+		return 0, err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	s.status.Addr = ln.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mux := http.NewServeMux()
+	mux.Handle("/offer", snd.Handler(ctx))
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	s.stop = func() {
+		cancel()
+		srv.Close()
+		snd.Wait()
+	}
+	return add(s), nil
+}
+
+// progress adds up the progress of a send session's transfers
+func (s *session) progress(p sender.Progress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent[p.ID] = p
+	s.status.Lines, s.status.Bytes = 0, 0
+	s.status.Transfers, s.status.Active = 0, 0
+	for _, t := range s.sent {
+		s.status.Lines += int64(t.Lines)
+		s.status.Bytes += t.Bytes
+		if t.Done {
+			s.status.Transfers++
+		} else {
+			s.status.Active++
+		}
+	}
+	if p.Err != nil {
+		s.status.Error = fmt.Sprintf("transfer %s: %v", p.ID, p.Err)
+	}
+}
+
+// StartReceive posts an offer to the sender at url, writes the lines it
+// streams to output and returns the session's handle. The session is done
+// once the sender finished the stream and its digest matched.
+func StartReceive(url, output string) (int64, error) {
+	f, err := os.Create(output)
+	if err != nil {
+		return 0, err
+	}
+	s := &session{status: Status{Kind: Receive, State: Running}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		result, err := receiver.Receive(ctx, receiver.Options{
+			URL:    url,
+			Output: f,
+			Verify: true,
+			OnProgress: func(p receiver.Progress) {
+				s.mu.Lock()
+				s.status.Lines, s.status.Bytes = int64(p.Lines), p.Bytes
+				s.mu.Unlock()
+			},
+		})
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write %s: %w", output, closeErr)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.status.Lines, s.status.Bytes = int64(result.Lines), result.Bytes
+		s.status.Verified = result.Verified
+		if err != nil {
+			s.status.State, s.status.Error = Failed, err.Error()
+		} else {
+			s.status.State = Done
+		}
+	}()
+	s.stop = func() {
+		cancel()
+		<-done
+	}
+	return add(s), nil
+}
+
+// Get returns the status of the session h
+func Get(h int64) (Status, error) {
+	mu.Lock()
+	s, ok := sessions[h]
+	mu.Unlock()
+	if !ok {
+		return Status{}, ErrUnknownHandle
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status, nil
+}
+
+// StatusJSON returns the status of the session h as JSON
+func StatusJSON(h int64) ([]byte, error) {
+	status, err := Get(h)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(status)
+}
+
+// Stop ends the session h, closing its connections, and forgets its handle
+func Stop(h int64) error {
+	mu.Lock()
+	s, ok := sessions[h]
+	delete(sessions, h)
+	mu.Unlock()
+	if !ok {
+		return ErrUnknownHandle
+	}
+	s.stop()
+	return nil
+}
@@ -0,0 +1,99 @@
+package ffi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitFor polls the status of h until done accepts it
+func waitFor(t *testing.T, h int64, done func(Status) bool) Status {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status, err := Get(h)
+		if err != nil {
+			t.Fatalf("Get returned error: %v", err)
+		}
+		if done(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting, last status %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSendReceive(t *testing.T) {
+	dir := t.TempDir()
+	var content strings.Builder
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	file := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(file, []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	send, err := StartSend(file, "127.0.0.1:0", 0)
+	if err != nil {
+		t.Fatalf("StartSend returned error: %v", err)
+	}
+	defer Stop(send)
+	status, _ := Get(send)
+	output := filepath.Join(dir, "out.txt")
+	recv, err := StartReceive("http://"+status.Addr+"/offer", output)
+	if err != nil {
+		t.Fatalf("StartReceive returned error: %v", err)
+	}
+
+	status = waitFor(t, recv, func(s Status) bool { return s.State != Running })
+	if status.State != Done || status.Lines != 20 || !status.Verified {
+		t.Errorf("Expected a verified stream of 20 lines, got %+v", status)
+	}
+	if got, _ := os.ReadFile(output); string(got) != content.String() {
+		t.Errorf("Expected the file received, got %q", got)
+	}
+	status = waitFor(t, send, func(s Status) bool { return s.Transfers == 1 })
+	if status.Lines != 20 || status.Active != 0 || status.Error != "" {
+		t.Errorf("Expected one finished transfer of 20 lines, got %+v", status)
+	}
+
+	var decoded Status
+	if data, err := StatusJSON(recv); err != nil || json.Unmarshal(data, &decoded) != nil || decoded.Kind != Receive {
+		t.Errorf("Expected the status as JSON, got %s, %v", data, err)
+	}
+
+	// Stopped sessions forget their handles
+	if err := Stop(recv); err != nil {
+		t.Errorf("Stop returned error: %v", err)
+	}
+	if _, err := Get(recv); !errors.Is(err, ErrUnknownHandle) {
+		t.Errorf("Expected ErrUnknownHandle, got %v", err)
+	}
+	if err := Stop(recv); !errors.Is(err, ErrUnknownHandle) {
+		t.Errorf("Expected ErrUnknownHandle, got %v", err)
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	if _, err := StartSend(filepath.Join(t.TempDir(), "missing.txt"), "127.0.0.1:0", 0); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file to fail, got %v", err)
+	}
+
+	// A receiver that cannot reach its sender fails
+	h, err := StartReceive("http://127.0.0.1:1/offer", filepath.Join(t.TempDir(), "out.txt"))
+	if err != nil {
+		t.Fatalf("StartReceive returned error: %v", err)
+	}
+	defer Stop(h)
+	if status := waitFor(t, h, func(s Status) bool { return s.State != Running }); status.State != Failed || status.Error == "" {
+		t.Errorf("Expected the session to fail, got %+v", status)
+	}
+}
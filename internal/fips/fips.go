@@ -0,0 +1,79 @@
+// Package fips restricts the optional application-layer crypto to FIPS 140-3
+// approved algorithms, for deployments in regulated environments. Approved
+// mode is on when the binary is built with the fips build tag, when Go runs
+// in FIPS 140-3 mode (GODEBUG=fips140=on, or a build with GOFIPS140) or when
+// the configuration enables it. In approved mode Noise secured signaling uses
+// ECDH over P-256 and AES-256-GCM instead of X25519 and ChaCha20-Poly1305, and
+// DTLS only agrees on keys over the P-256 and P-384 curves, and chunks are
+// only hashed with SHA-256. Peer identities (Ed25519) are approved either way.
+// The package also lists the DTLS cipher suites handshakes may negotiate.
+package fips
+
+import (
+	"crypto/fips140"
+	"sync/atomic"
+
+	"github.com/developmeh/webrtc-poc/internal/noise"
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/crypto/elliptic"
+)
+
+// enabled is set once the configuration asks for approved mode
+var enabled atomic.Bool
+
+// Enable switches approved mode on for the rest of the process
+func Enable() {
+	enabled.Store(true)
+}
+
+// Enabled reports whether approved mode is on
+func Enabled() bool {
+	return tagged || fips140.Enabled() || enabled.Load()
+}
+
+// Source describes what turned approved mode on, or returns an empty string
+// if it is off
+func Source() string {
+	switch {
+	case tagged:
+		return "fips build tag"
+	case fips140.Enabled():
+		return "Go FIPS 140-3 mode"
+	case enabled.Load():
+		return "configuration"
+	}
+	return ""
+}
+
+// NoiseSuite returns the suite Noise handshakes are started with
+func NoiseSuite() *noise.Suite {
+	if Enabled() {
+		return noise.Approved
+	}
+	return noise.Default
+}
+
+// Allowed reports whether a Noise handshake with suite may be answered
+func Allowed(suite *noise.Suite) bool {
+	return !Enabled() || suite == noise.Approved
+}
+
+// DTLSCurves returns the curves DTLS may agree on keys over, or nil for the
+// library's defaults, which start with X25519
+func DTLSCurves() []elliptic.Curve {
+	if Enabled() {
+		return []elliptic.Curve{elliptic.P256, elliptic.P384}
+	}
+	return nil
+}
+
+// DTLSCipherSuites returns the names of the cipher suites DTLS offers. Peers
+// authenticate with ECDSA P-256 certificates, which leaves the library's
+// ECDSA suites, all of them approved.
+func DTLSCipherSuites() []string {
+	return []string{
+		dtls.CipherSuiteName(dtls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256),
+		dtls.CipherSuiteName(dtls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA),
+		dtls.CipherSuiteName(dtls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384),
+	}
+}
@@ -0,0 +1,47 @@
+package fips
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/developmeh/webrtc-poc/internal/noise"
+	"github.com/pion/dtls/v2/pkg/crypto/elliptic"
+)
+
+func TestEnable(t *testing.T) {
+	if Enabled() {
+		t.Skipf("Approved mode is already on through the %s", Source())
+	}
+	if NoiseSuite() != noise.Default || !Allowed(noise.Default) || DTLSCurves() != nil || Source() != "" {
+		t.Error("Expected the default algorithms before Enable")
+	}
+
+	Enable()
+	if !Enabled() || Source() != "configuration" {
+		t.Errorf("Expected approved mode from the configuration, got %v, %q", Enabled(), Source())
+	}
+	if NoiseSuite() != noise.Approved {
+		t.Errorf("Expected %s, got %s", noise.Approved.Name(), NoiseSuite().Name())
+	}
+	if Allowed(noise.Default) || !Allowed(noise.Approved) {
+		t.Error("Expected only the approved Noise suite to be allowed")
+	}
+	curves := DTLSCurves()
+	if len(curves) != 2 || curves[0] != elliptic.P256 || curves[1] != elliptic.P384 {
+		t.Errorf("Expected P-256 and P-384, got %v", curves)
+	}
+}
+
+func TestDTLSCipherSuites(t *testing.T) {
+	// Peers authenticate with ECDSA certificates, so only the ECDSA suites
+	// can be negotiated, and the library knows each of them by name
+	suites := DTLSCipherSuites()
+	if len(suites) == 0 {
+		t.Fatal("Expected the DTLS cipher suites")
+	}
+	for _, suite := range suites {
+		if !strings.HasPrefix(suite, "TLS_ECDHE_ECDSA_WITH_AES_") {
+			t.Errorf("Expected an approved ECDSA suite, got %s", suite)
+		}
+	}
+}
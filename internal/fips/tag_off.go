@@ -0,0 +1,6 @@
+//go:build !fips
+
+package fips
+
+// tagged is set by the fips build tag
+const tagged = false
@@ -0,0 +1,72 @@
+package flags
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+)
+
+// aliasValue forwards values given through an alias to the flag it replaces
+type aliasValue struct {
+	target *pflag.Flag
+}
+
+// Set applies the value to the target flag and marks it as changed so that
+// viper bindings on the target flag see the value
+func (a *aliasValue) Set(value string) error {
+	if err := a.target.Value.Set(value); err != nil {
+		return err
+	}
+	a.target.Changed = true
+	return nil
+}
+
+// String returns the current value of the target flag
+func (a *aliasValue) String() string {
+	return a.target.Value.String()
+}
+
+// Type returns the type of the target flag
+func (a *aliasValue) Type() string {
+	return a.target.Value.Type()
+}
+
+// Alias registers old as an alternative name for the flag named target.
+// The alias is hidden from help output and values given through it are
+// applied to the target flag.
+func Alias(fs *pflag.FlagSet, old, target string) error {
+	t := fs.Lookup(target)
+	if t == nil {
+		return fmt.Errorf("cannot alias --%s: flag --%s does not exist", old, target)
+	}
+	if fs.Lookup(old) != nil {
+		return fmt.Errorf("cannot alias --%s: flag already exists", old)
+	}
+
+	fs.AddFlag(&pflag.Flag{
+		Name:     old,
+		Usage:    t.Usage,
+		Value:    &aliasValue{target: t},
+		DefValue: t.DefValue,
+		Hidden:   true,
+	})
+	return nil
+}
+
+// Deprecate registers old as a deprecated alias of the flag named target.
+// Scripts using the old name keep working but print a warning pointing at
+// the replacement.
+func Deprecate(fs *pflag.FlagSet, old, target string) error {
+	if err := Alias(fs, old, target); err != nil {
+		return err
+	}
+	return fs.MarkDeprecated(old, fmt.Sprintf("use --%s instead", target))
+}
+
+// MustDeprecate is like Deprecate but panics on error. It is intended for
+// use in init functions where a failure is a programming error.
+func MustDeprecate(fs *pflag.FlagSet, old, target string) {
+	if err := Deprecate(fs, old, target); err != nil {
+		panic(err)
+	}
+}
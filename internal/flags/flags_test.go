@@ -0,0 +1,85 @@
+package flags
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestAlias(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	target := fs.String("ice-server", "", "ICE server URL")
+
+	if err := Alias(fs, "stun", "ice-server"); err != nil {
+		t.Fatalf("Alias returned error: %v", err)
+	}
+
+	if err := fs.Parse([]string{"--stun", "stun:example.com:3478"}); err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	if *target != "stun:example.com:3478" {
+		t.Errorf("Expected target flag to be set through the alias, got '%s'", *target)
+	}
+	if !fs.Lookup("ice-server").Changed {
+		t.Error("Expected target flag to be marked as changed")
+	}
+	if !fs.Lookup("stun").Hidden {
+		t.Error("Expected alias to be hidden")
+	}
+}
+
+func TestAliasRepeatable(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	target := fs.StringArray("ice-server", nil, "ICE server URL")
+
+	if err := Alias(fs, "stun", "ice-server"); err != nil {
+		t.Fatalf("Alias returned error: %v", err)
+	}
+
+	args := []string{"--stun", "stun:a.example.com", "--ice-server", "stun:b.example.com"}
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	if len(*target) != 2 {
+		t.Errorf("Expected both values to be collected, got %v", *target)
+	}
+}
+
+func TestDeprecate(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	var out bytes.Buffer
+	fs.SetOutput(&out)
+	target := fs.String("ice-server", "", "ICE server URL")
+
+	if err := Deprecate(fs, "stun", "ice-server"); err != nil {
+		t.Fatalf("Deprecate returned error: %v", err)
+	}
+
+	if err := fs.Parse([]string{"--stun=stun:example.com:3478"}); err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	if *target != "stun:example.com:3478" {
+		t.Errorf("Expected target flag to be set through the deprecated alias, got '%s'", *target)
+	}
+	if !strings.Contains(out.String(), "use --ice-server instead") {
+		t.Errorf("Expected a deprecation warning, got '%s'", out.String())
+	}
+}
+
+func TestAliasErrors(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("ice-server", "", "ICE server URL")
+	fs.String("stun", "", "STUN server")
+
+	if err := Alias(fs, "old", "missing"); err == nil {
+		t.Error("Expected an error when aliasing a missing flag")
+	}
+	if err := Alias(fs, "stun", "ice-server"); err == nil {
+		t.Error("Expected an error when the alias name is already taken")
+	}
+}
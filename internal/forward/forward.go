@@ -0,0 +1,424 @@
+// Package forward tunnels byte streams and datagrams between local sockets
+// on both ends of a peer connection. Every forwarded connection is a data
+// channel the client opens with Protocol as its subprotocol: each message
+// carries a chunk of a TCP stream or one UDP datagram, and closing the
+// channel ends the connection on the other end.
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// Protocol marks the data channels that carry a forwarded connection
+	Protocol = "webrtc-poc-forward"
+	// Label is the label of forward data channels
+	Label = "forward"
+
+	// chunkSize is the most read from a socket per message: the largest
+	// UDP datagram, and below the 65535 bytes pion receives a message into
+	chunkSize = 65507
+	// highWater pauses reading from the socket while this many bytes are
+	// queued on the channel, lowWater resumes it
+	highWater = 1 << 20
+	lowWater  = 256 * 1024
+	// retryDelay separates the sessions of a connecting client endpoint
+	retryDelay = time.Second
+)
+
+// Endpoint is a local socket one end of the tunnel connects to or listens on
+type Endpoint struct {
+	// Network is tcp or udp
+	Network string
+	Addr    string
+	Listen  bool
+}
+
+// ParseEndpoint parses tcp:HOST:PORT or udp:HOST:PORT to connect to an
+// address, and tcp-listen:[HOST:]PORT or udp-listen:[HOST:]PORT to listen
+func ParseEndpoint(spec string) (Endpoint, error) {
+	kind, addr, ok := strings.Cut(spec, ":")
+	if !ok || addr == "" {
+		return Endpoint{}, fmt.Errorf("invalid forward endpoint %q (expected tcp:HOST:PORT, tcp-listen:PORT, udp:HOST:PORT or udp-listen:PORT)", spec)
+	}
+
+	var e Endpoint
+	switch kind {
+	case "tcp", "udp":
+		e = Endpoint{Network: kind, Addr: addr}
+	case "tcp-listen", "udp-listen":
+		e = Endpoint{Network: strings.TrimSuffix(kind, "-listen"), Addr: addr, Listen: true}
+		if !strings.Contains(addr, ":") {
+			e.Addr = ":" + addr
+		}
+	default:
+		return Endpoint{}, fmt.Errorf("invalid forward endpoint %q: unknown type %s", spec, kind)
+	}
+	if _, _, err := net.SplitHostPort(e.Addr); err != nil {
+		return Endpoint{}, fmt.Errorf("invalid forward endpoint %q: %w", spec, err)
+	}
+	return e, nil
+}
+
+func (e Endpoint) String() string {
+	if e.Listen {
+		return e.Network + "-listen:" + e.Addr
+	}
+	return e.Network + ":" + e.Addr
+}
+
+// Listener hands out the connections of a listening endpoint
+type Listener interface {
+	Accept() (io.ReadWriteCloser, error)
+	Close() error
+	Addr() net.Addr
+}
+
+// OpenListener opens a listening endpoint
+func (e Endpoint) OpenListener() (Listener, error) {
+	if !e.Listen {
+		return nil, fmt.Errorf("%s is not a listening endpoint", e)
+	}
+	if e.Network == "udp" {
+		conn, err := net.ListenPacket("udp", e.Addr)
+		if err != nil {
+			return nil, err
+		}
+		return newPacketListener(conn), nil
+	}
+	l, err := net.Listen("tcp", e.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return tcpListener{l}, nil
+}
+
+// Dial connects to a connecting endpoint
+func (e Endpoint) Dial() (io.ReadWriteCloser, error) {
+	return net.Dial(e.Network, e.Addr)
+}
+
+type tcpListener struct {
+	net.Listener
+}
+
+func (l tcpListener) Accept() (io.ReadWriteCloser, error) {
+	return l.Listener.Accept()
+}
+
+// packetListener shares one UDP socket between consecutive sessions. A
+// session receives the datagrams of every sender and replies to the last
+// one; the next session starts once it is closed.
+type packetListener struct {
+	conn net.PacketConn
+	// packets are the datagrams read from the socket, closed with it
+	packets chan packet
+	// free holds a token while no session uses the socket
+	free chan struct{}
+}
+
+// packet is a datagram and its sender
+type packet struct {
+	data []byte
+	addr net.Addr
+}
+
+func newPacketListener(conn net.PacketConn) *packetListener {
+	l := &packetListener{conn: conn, packets: make(chan packet, 64), free: make(chan struct{}, 1)}
+	l.free <- struct{}{}
+	go l.read()
+	return l
+}
+
+// read passes the datagrams on to the sessions until the socket is closed
+func (l *packetListener) read() {
+	defer close(l.packets)
+	buf := make([]byte, chunkSize)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		l.packets <- packet{data: append([]byte(nil), buf[:n]...), addr: addr}
+	}
+}
+
+func (l *packetListener) Accept() (io.ReadWriteCloser, error) {
+	<-l.free
+	return &packetSession{listener: l, done: make(chan struct{})}, nil
+}
+
+func (l *packetListener) Close() error {
+	return l.conn.Close()
+}
+
+func (l *packetListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// packetSession is one session on a shared UDP socket
+type packetSession struct {
+	listener *packetListener
+	mu       sync.Mutex
+	peer     net.Addr
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (s *packetSession) Read(p []byte) (int, error) {
+	select {
+	case pkt, ok := <-s.listener.packets:
+		if !ok {
+			return 0, net.ErrClosed
+		}
+		s.mu.Lock()
+		s.peer = pkt.addr
+		s.mu.Unlock()
+		return copy(p, pkt.data), nil
+	case <-s.done:
+		return 0, io.EOF
+	}
+}
+
+// Write replies to the last sender, dropping datagrams until there is one
+func (s *packetSession) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	peer := s.peer
+	s.mu.Unlock()
+	if peer == nil {
+		return len(p), nil
+	}
+	return s.listener.conn.WriteTo(p, peer)
+}
+
+// Close ends the session, keeping the socket open for the next one
+func (s *packetSession) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		s.listener.free <- struct{}{}
+	})
+	return nil
+}
+
+// Forwarder connects forward data channels to an endpoint
+type Forwarder struct {
+	endpoint Endpoint
+	listener Listener
+}
+
+// NewForwarder returns a forwarder for an endpoint, listening on it if it is
+// a listening endpoint
+func NewForwarder(endpoint Endpoint) (*Forwarder, error) {
+	f := &Forwarder{endpoint: endpoint}
+	if endpoint.Listen {
+		l, err := endpoint.OpenListener()
+		if err != nil {
+			return nil, fmt.Errorf("error listening on %s: %w", endpoint, err)
+		}
+		f.listener = l
+		logger.Info("Forwarding connections to %s", l.Addr())
+	}
+	return f, nil
+}
+
+// connect returns the next local connection: a new one for a connecting
+// endpoint, the next accepted one for a listening endpoint
+func (f *Forwarder) connect() (io.ReadWriteCloser, error) {
+	if f.listener != nil {
+		return f.listener.Accept()
+	}
+	return f.endpoint.Dial()
+}
+
+// Serve connects a forward channel the peer opened to the endpoint. It must
+// be called before the channel opens.
+func (f *Forwarder) Serve(dc *webrtc.DataChannel) {
+	s := newSession(dc)
+	go s.serve(f.connect)
+}
+
+// Run opens forward channels to the peer until the peer connection closes.
+// A listening endpoint gets a channel per accepted connection; a connecting
+// endpoint keeps one session at a time, starting the next one when it ends.
+func (f *Forwarder) Run(pc *webrtc.PeerConnection) error {
+	protocol := Protocol
+	for {
+		var conn io.ReadWriteCloser
+		if f.listener != nil {
+			var err error
+			if conn, err = f.listener.Accept(); err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return nil
+				}
+				return err
+			}
+		}
+
+		dc, err := pc.CreateDataChannel(Label, &webrtc.DataChannelInit{Protocol: &protocol})
+		if err != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			return fmt.Errorf("error opening forward channel: %w", err)
+		}
+		s := newSession(dc)
+
+		if conn != nil {
+			go s.serve(func() (io.ReadWriteCloser, error) { return conn, nil })
+			continue
+		}
+		if !s.serve(f.endpoint.Dial) {
+			return errors.New("peer connection closed")
+		}
+		time.Sleep(retryDelay)
+	}
+}
+
+// Close stops listening
+func (f *Forwarder) Close() error {
+	if f.listener == nil {
+		return nil
+	}
+	return f.listener.Close()
+}
+
+// session carries one forwarded connection over a data channel
+type session struct {
+	dc       *webrtc.DataChannel
+	opened   chan struct{}
+	incoming chan []byte
+	drained  chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// newSession registers the handlers of a forward channel, which must happen
+// before it opens so no message is missed
+func newSession(dc *webrtc.DataChannel) *session {
+	s := &session{
+		dc:       dc,
+		opened:   make(chan struct{}),
+		incoming: make(chan []byte, 64),
+		drained:  make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+	dc.OnOpen(func() { close(s.opened) })
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		select {
+		case s.incoming <- msg.Data:
+		case <-s.closed:
+		}
+	})
+	dc.OnClose(s.close)
+	dc.SetBufferedAmountLowThreshold(lowWater)
+	dc.OnBufferedAmountLow(func() {
+		select {
+		case s.drained <- struct{}{}:
+		default:
+		}
+	})
+	return s
+}
+
+func (s *session) close() {
+	s.once.Do(func() { close(s.closed) })
+}
+
+// serve waits for the channel to open, connects it to a local connection and
+// copies between them until either side closes. It reports whether the
+// channel opened.
+func (s *session) serve(connect func() (io.ReadWriteCloser, error)) bool {
+	select {
+	case <-s.opened:
+	case <-s.closed:
+		return false
+	}
+
+	conn, err := connect()
+	if err != nil {
+		logger.Error("Failed to connect forward channel: %v", err)
+		s.dc.Close()
+		return true
+	}
+	logger.Info("Forwarding connection over channel %d", *s.dc.ID())
+
+	// Channel to socket, including what arrived before the channel closed
+	received := make(chan int64, 1)
+	go func() {
+		var n int64
+		write := func(data []byte) bool {
+			if _, err := conn.Write(data); err != nil {
+				return false
+			}
+			n += int64(len(data))
+			return true
+		}
+		defer func() {
+			conn.Close()
+			received <- n
+		}()
+		for {
+			select {
+			case data := <-s.incoming:
+				if !write(data) {
+					return
+				}
+			case <-s.closed:
+				for {
+					select {
+					case data := <-s.incoming:
+						if !write(data) {
+							return
+						}
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	// Socket to channel
+	sent := s.pump(conn)
+	s.dc.Close()
+	conn.Close()
+	s.close()
+
+	logger.Info("Forwarded connection closed: sent %d bytes, received %d bytes", sent, <-received)
+	return true
+}
+
+// pump sends what is read from conn until it closes, pausing while the
+// channel has too much queued
+func (s *session) pump(conn io.Reader) int64 {
+	var sent int64
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			for s.dc.BufferedAmount() > highWater {
+				select {
+				case <-s.drained:
+				case <-s.closed:
+					return sent
+				}
+			}
+			if err := s.dc.Send(buf[:n]); err != nil {
+				return sent
+			}
+			sent += int64(n)
+		}
+		if err != nil {
+			return sent
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package forward
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	valid := map[string]Endpoint{
+		"tcp:localhost:5432":       {Network: "tcp", Addr: "localhost:5432"},
+		"udp:10.0.0.1:53":          {Network: "udp", Addr: "10.0.0.1:53"},
+		"tcp-listen:8443":          {Network: "tcp", Addr: ":8443", Listen: true},
+		"udp-listen:127.0.0.1:514": {Network: "udp", Addr: "127.0.0.1:514", Listen: true},
+	}
+	for spec, want := range valid {
+		got, err := ParseEndpoint(spec)
+		if err != nil {
+			t.Errorf("ParseEndpoint(%q) returned error: %v", spec, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Expected %+v for %q, got %+v", want, spec, got)
+		}
+	}
+
+	for _, spec := range []string{"", "tcp", "tcp:", "tcp:localhost", "sctp:host:1", "tcp-listen:a:b:c"} {
+		if _, err := ParseEndpoint(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+
+	if s := (Endpoint{Network: "udp", Addr: ":514", Listen: true}).String(); s != "udp-listen::514" {
+		t.Errorf("Expected udp-listen::514, got %s", s)
+	}
+}
+
+func TestTCPEndpoint(t *testing.T) {
+	l, err := Endpoint{Network: "tcp", Addr: "127.0.0.1:0", Listen: true}.OpenListener()
+	if err != nil {
+		t.Fatalf("OpenListener returned error: %v", err)
+	}
+	defer l.Close()
+
+	accepted := make(chan io.ReadWriteCloser, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("Accept returned error: %v", err)
+		}
+		accepted <- conn
+	}()
+
+	conn, err := Endpoint{Network: "tcp", Addr: l.Addr().String()}.Dial()
+	if err != nil {
+		t.Fatalf("Dial returned error: %v", err)
+	}
+	defer conn.Close()
+	server := <-accepted
+	defer server.Close()
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected ping, got %q, %v", buf, err)
+	}
+}
+
+func TestUDPListener(t *testing.T) {
+	l, err := Endpoint{Network: "udp", Addr: "127.0.0.1:0", Listen: true}.OpenListener()
+	if err != nil {
+		t.Fatalf("OpenListener returned error: %v", err)
+	}
+	defer l.Close()
+
+	session, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept returned error: %v", err)
+	}
+
+	// Replies are dropped until a datagram arrived
+	if n, err := session.Write([]byte("early")); err != nil || n != 5 {
+		t.Errorf("Expected an early reply to be dropped, got %d, %v", n, err)
+	}
+
+	client, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+	client.Write([]byte("query"))
+
+	buf := make([]byte, chunkSize)
+	n, err := session.Read(buf)
+	if err != nil || string(buf[:n]) != "query" {
+		t.Fatalf("Expected query, got %q, %v", buf[:n], err)
+	}
+	if _, err := session.Write([]byte("answer")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := client.Read(buf); err != nil || string(buf[:n]) != "answer" {
+		t.Errorf("Expected the reply to reach the last sender, got %q, %v", buf[:n], err)
+	}
+
+	// The next session starts once the first one is closed, which
+	// interrupts its reads
+	next := make(chan io.ReadWriteCloser, 1)
+	go func() {
+		s, _ := l.Accept()
+		next <- s
+	}()
+	select {
+	case <-next:
+		t.Fatal("Expected Accept to wait for the open session")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	readDone := make(chan error, 1)
+	go func() {
+		_, err := session.Read(buf)
+		readDone <- err
+	}()
+	session.Close()
+	if err := <-readDone; err == nil {
+		t.Error("Expected Close to interrupt a read")
+	}
+	select {
+	case s := <-next:
+		s.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the next session after Close")
+	}
+}
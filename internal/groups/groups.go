@@ -0,0 +1,309 @@
+// Package groups distributes the lines of the file stream across the clients
+// of a named consumer group, like a topic's partition is consumed by a Kafka
+// consumer group. Every line goes to one member of the group, in turn, and
+// members commit the lines they wrote. The group's committed offset, the
+// number of lines of the file before the first line a member has not
+// committed, is saved, so the group carries on after it once the server or
+// its clients restart. Lines a member received without committing them are
+// handed to the other members when it leaves, or read again by the next
+// round, so every line is delivered at least once.
+package groups
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxName is the longest group name
+	maxName = 64
+	// settlePoll is how often Settle checks the member's commits
+	settlePoll = 20 * time.Millisecond
+)
+
+// ErrLeft is returned by Wait when the member left before the round ended
+var ErrLeft = errors.New("member left the group")
+
+// errIdle stops a round that has no members left
+var errIdle = errors.New("no members left")
+
+// ValidName checks that name can name a group: 1 to 64 ASCII letters, digits,
+// dashes, underscores and dots
+func ValidName(name string) error {
+	if name == "" || len(name) > maxName {
+		return fmt.Errorf("invalid group name %q: expected 1 to %d characters", name, maxName)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return fmt.Errorf("invalid group name %q: only letters, digits, '-', '_' and '.' are allowed", name)
+		}
+	}
+	return nil
+}
+
+// Source reads one round of the stream, skipping its first offset lines and
+// calling emit with every following line in order at the pace the lines are
+// sent. A source waiting for more lines stops once more reports false. It
+// stops with the error emit returns.
+type Source func(offset int, more func() bool, emit func(line string) error) error
+
+// Groups holds the consumer groups of one file
+type Groups struct {
+	store  *Store
+	file   string
+	source Source
+
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+// New creates the consumer groups of file, reading it with source and
+// saving their offsets to store
+func New(store *Store, file string, source Source) *Groups {
+	return &Groups{store: store, file: file, source: source, groups: make(map[string]*group)}
+}
+
+// entry is a line handed to a member, numbered in the file
+type entry struct {
+	n    int
+	line string
+}
+
+// group is the state of one consumer group
+type group struct {
+	g    *Groups
+	name string
+
+	mu      sync.Mutex
+	members []*Member
+	// next is the member the next line goes to, in turn
+	next    int
+	running bool
+	// round counts the rounds, which read the file again from the
+	// committed offset
+	round int
+	// read is the number of the last line read, committed the group's
+	// offset, and pending the lines read but not committed yet
+	read      int
+	committed int
+	pending   map[int]bool
+	// queue are the lines waiting for a member, in order
+	queue []entry
+}
+
+// Member is a client of a consumer group
+type Member struct {
+	grp   *group
+	round int
+	send  func(line string) error
+	// delivered are the lines sent to the member it has not committed yet,
+	// in order, and committed the number of lines it committed
+	delivered []entry
+	committed int
+	done      chan error
+}
+
+// Join adds a member to the group name, which sends it lines with send,
+// starting a round from the group's committed offset if none runs. send
+// must not wait: every line is handed to one member before the next is read.
+func (gs *Groups) Join(name string, send func(line string) error) *Member {
+	gs.mu.Lock()
+	grp := gs.groups[name]
+	if grp == nil {
+		grp = &group{g: gs, name: name}
+		gs.groups[name] = grp
+	}
+	gs.mu.Unlock()
+
+	grp.mu.Lock()
+	defer grp.mu.Unlock()
+	if !grp.running {
+		grp.start()
+	}
+	m := &Member{grp: grp, round: grp.round, send: send, done: make(chan error, 1)}
+	grp.members = append(grp.members, m)
+	return m
+}
+
+// Committed returns the committed offset of the group name
+func (gs *Groups) Committed(name string) int {
+	gs.mu.Lock()
+	grp := gs.groups[name]
+	gs.mu.Unlock()
+	if grp == nil {
+		return gs.store.Get(name, gs.file)
+	}
+	grp.mu.Lock()
+	defer grp.mu.Unlock()
+	return grp.committed
+}
+
+// start starts a round reading the file after the saved offset. Lines that
+// were pending are read again.
+func (grp *group) start() {
+	grp.running = true
+	grp.round++
+	grp.committed = grp.g.store.Get(grp.name, grp.g.file)
+	grp.read = grp.committed
+	grp.pending = make(map[int]bool)
+	grp.queue = nil
+	go grp.run(grp.round, grp.committed)
+}
+
+// run reads a round and ends it for its members once the file ended,
+// starting the next one right away for those that joined as it ran out of
+// members
+func (grp *group) run(round, offset int) {
+	more := func() bool {
+		grp.mu.Lock()
+		defer grp.mu.Unlock()
+		return len(grp.members) > 0
+	}
+	err := grp.g.source(offset, more, func(line string) error { return grp.emit(round, line) })
+
+	grp.mu.Lock()
+	defer grp.mu.Unlock()
+	if errors.Is(err, errIdle) && len(grp.members) > 0 {
+		grp.start()
+		for _, m := range grp.members {
+			m.round = grp.round
+		}
+		return
+	}
+	// Members keep their lines to commit them as the stream finishes
+	for _, m := range grp.members {
+		m.done <- err
+	}
+	grp.members = nil
+	grp.running = false
+}
+
+// emit queues a line for the members and hands out the queue
+func (grp *group) emit(round int, line string) error {
+	grp.mu.Lock()
+	defer grp.mu.Unlock()
+	grp.read++
+	grp.pending[grp.read] = true
+	grp.queue = append(grp.queue, entry{grp.read, line})
+	grp.dispatch()
+	if len(grp.members) == 0 {
+		return errIdle
+	}
+	return nil
+}
+
+// dispatch hands the queued lines to the members in turn, dropping those
+// whose send fails
+func (grp *group) dispatch() {
+	for len(grp.queue) > 0 && len(grp.members) > 0 {
+		grp.next %= len(grp.members)
+		m := grp.members[grp.next]
+		e := grp.queue[0]
+		if err := m.send(e.line); err != nil {
+			grp.drop(m, err)
+			continue
+		}
+		grp.queue = grp.queue[1:]
+		m.delivered = append(m.delivered, e)
+		grp.next++
+	}
+}
+
+// drop removes a member, queueing the lines it did not commit again for the
+// others
+func (grp *group) drop(m *Member, err error) {
+	for i, member := range grp.members {
+		if member == m {
+			grp.members = append(grp.members[:i], grp.members[i+1:]...)
+			break
+		}
+	}
+	if len(m.delivered) > 0 {
+		grp.queue = append(m.delivered, grp.queue...)
+		sort.Slice(grp.queue, func(i, j int) bool { return grp.queue[i].n < grp.queue[j].n })
+		m.delivered = nil
+	}
+	m.done <- err
+}
+
+// Wait waits until the round ended, a send to the member failed or leave is
+// closed. It returns the error the round ended with, nil once the file was
+// read to the end, the error of send, or ErrLeft.
+func (m *Member) Wait(leave <-chan struct{}) error {
+	select {
+	case err := <-m.done:
+		return err
+	case <-leave:
+	}
+
+	grp := m.grp
+	grp.mu.Lock()
+	defer grp.mu.Unlock()
+	for _, member := range grp.members {
+		if member == m {
+			grp.drop(m, ErrLeft)
+			grp.dispatch()
+			break
+		}
+	}
+	return <-m.done
+}
+
+// Commit records that the member wrote the first lines it received, and
+// saves the group's offset if it moved
+func (m *Member) Commit(lines int) error {
+	grp := m.grp
+	grp.mu.Lock()
+	if m.round != grp.round {
+		// The lines are read again by a later round
+		grp.mu.Unlock()
+		return nil
+	}
+	n := min(lines-m.committed, len(m.delivered))
+	if n <= 0 {
+		grp.mu.Unlock()
+		return nil
+	}
+	for _, e := range m.delivered[:n] {
+		delete(grp.pending, e.n)
+	}
+	m.delivered = m.delivered[n:]
+	m.committed += n
+
+	committed := grp.read
+	for line := range grp.pending {
+		committed = min(committed, line-1)
+	}
+	moved := committed > grp.committed
+	if moved {
+		grp.committed = committed
+	}
+	grp.mu.Unlock()
+
+	if !moved {
+		return nil
+	}
+	return grp.g.store.Set(grp.name, grp.g.file, committed)
+}
+
+// Settle waits up to timeout until the member committed lines lines, since
+// its last commits may only follow the end of the stream, and reports
+// whether it did
+func (m *Member) Settle(lines int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		m.grp.mu.Lock()
+		committed := m.committed
+		m.grp.mu.Unlock()
+		if committed >= lines {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(settlePoll)
+	}
+}
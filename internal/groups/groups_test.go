@@ -0,0 +1,280 @@
+package groups
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedSource emits the lines after the offset one at a time, each once the
+// test lets it
+type gatedSource struct {
+	lines []string
+	next  chan struct{}
+	// offsets receives the offset every round started at
+	offsets chan int
+}
+
+func newGatedSource(n int) *gatedSource {
+	s := &gatedSource{next: make(chan struct{}), offsets: make(chan int, 10)}
+	for i := 1; i <= n; i++ {
+		s.lines = append(s.lines, fmt.Sprintf("line %d", i))
+	}
+	return s
+}
+
+func (s *gatedSource) read(offset int, more func() bool, emit func(string) error) error {
+	s.offsets <- offset
+	for _, line := range s.lines[min(offset, len(s.lines)):] {
+		<-s.next
+		if err := emit(line); err != nil {
+			return err
+		}
+	}
+	<-s.next
+	return nil
+}
+
+// step lets the source emit n lines
+func (s *gatedSource) step(n int) {
+	for range n {
+		s.next <- struct{}{}
+	}
+}
+
+// recorder collects the lines a member received
+type recorder struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *recorder) send(line string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, line)
+	return nil
+}
+
+func (r *recorder) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.lines...)
+}
+
+func openStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "groups.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open the store: %v", err)
+	}
+	return store, path
+}
+
+// wait waits for the result of m.Wait
+func wait(t *testing.T, m *Member, leave <-chan struct{}) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- m.Wait(leave) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("Expected Wait to return")
+		return nil
+	}
+}
+
+func TestValidName(t *testing.T) {
+	for _, name := range []string{"billing", "team-a_1.v2", strings.Repeat("g", 64)} {
+		if err := ValidName(name); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "a b", "a/b", "ü", strings.Repeat("g", 65)} {
+		if err := ValidName(name); err == nil {
+			t.Errorf("Expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestGroupsRoundRobin(t *testing.T) {
+	src := newGatedSource(5)
+	store, _ := openStore(t)
+	gs := New(store, "/data/orders.txt", src.read)
+
+	a, b := &recorder{}, &recorder{}
+	ma := gs.Join("billing", a.send)
+	mb := gs.Join("billing", b.send)
+	src.step(6)
+
+	if err := wait(t, ma, nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := wait(t, mb, nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if got := strings.Join(a.received(), ","); got != "line 1,line 3,line 5" {
+		t.Errorf("First member received %q", got)
+	}
+	if got := strings.Join(b.received(), ","); got != "line 2,line 4" {
+		t.Errorf("Second member received %q", got)
+	}
+}
+
+func TestGroupsCommit(t *testing.T) {
+	src := newGatedSource(4)
+	store, path := openStore(t)
+	gs := New(store, "/data/orders.txt", src.read)
+
+	a, b := &recorder{}, &recorder{}
+	ma := gs.Join("billing", a.send)
+	mb := gs.Join("billing", b.send)
+	src.step(5)
+	wait(t, ma, nil)
+	wait(t, mb, nil)
+
+	// The offset stops before line 2, which b has not committed
+	if err := ma.Commit(2); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if got := gs.Committed("billing"); got != 1 {
+		t.Errorf("Expected offset 1, got %d", got)
+	}
+	if err := mb.Commit(1); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if got := gs.Committed("billing"); got != 3 {
+		t.Errorf("Expected offset 3, got %d", got)
+	}
+	if !ma.Settle(2, 0) || mb.Settle(2, 0) {
+		t.Error("Expected only the first member to have committed all its lines")
+	}
+
+	// A restarted server carries on after the saved offset
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen the store: %v", err)
+	}
+	if got := reopened.Get("billing", "/data/orders.txt"); got != 3 {
+		t.Errorf("Expected the saved offset 3, got %d", got)
+	}
+	<-src.offsets
+	restarted := New(reopened, "/data/orders.txt", src.read)
+	c := &recorder{}
+	mc := restarted.Join("billing", c.send)
+	if offset := <-src.offsets; offset != 3 {
+		t.Errorf("Expected the round to start at 3, got %d", offset)
+	}
+	src.step(2)
+	wait(t, mc, nil)
+	if got := strings.Join(c.received(), ","); got != "line 4" {
+		t.Errorf("Expected the lines after the offset, got %q", got)
+	}
+}
+
+func TestGroupsLeave(t *testing.T) {
+	src := newGatedSource(4)
+	store, _ := openStore(t)
+	gs := New(store, "/data/orders.txt", src.read)
+
+	a, b := &recorder{}, &recorder{}
+	ma := gs.Join("billing", a.send)
+	mb := gs.Join("billing", b.send)
+	src.step(3)
+	waitLines(t, a, 2)
+
+	// The lines a member did not commit go to the others when it leaves
+	if err := ma.Commit(1); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	leave := make(chan struct{})
+	close(leave)
+	if err := wait(t, ma, leave); !errors.Is(err, ErrLeft) {
+		t.Errorf("Expected ErrLeft, got %v", err)
+	}
+	src.step(2)
+	if err := wait(t, mb, nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if got := strings.Join(a.received(), ","); got != "line 1,line 3" {
+		t.Errorf("Leaving member received %q", got)
+	}
+	if got := strings.Join(b.received(), ","); got != "line 2,line 3,line 4" {
+		t.Errorf("Remaining member received %q", got)
+	}
+}
+
+func TestGroupsIndependent(t *testing.T) {
+	src := newGatedSource(2)
+	store, _ := openStore(t)
+	gs := New(store, "/data/orders.txt", src.read)
+
+	a, b := &recorder{}, &recorder{}
+	ma := gs.Join("billing", a.send)
+	mb := gs.Join("audit", b.send)
+	src.step(6)
+	wait(t, ma, nil)
+	wait(t, mb, nil)
+	for _, r := range []*recorder{a, b} {
+		if got := strings.Join(r.received(), ","); got != "line 1,line 2" {
+			t.Errorf("Expected every group to get the whole file, got %q", got)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	store, path := openStore(t)
+	if err := store.Set("billing", "/data/orders.txt", 5); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	// An offset never moves back
+	if err := store.Set("billing", "/data/orders.txt", 3); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+	if got := store.Get("billing", "/data/orders.txt"); got != 5 {
+		t.Errorf("Expected offset 5, got %d", got)
+	}
+	// The offset of another file does not apply
+	if got := store.Get("billing", "/data/other.txt"); got != 0 {
+		t.Errorf("Expected no offset for another file, got %d", got)
+	}
+	if got := store.Get("audit", "/data/orders.txt"); got != 0 {
+		t.Errorf("Expected no offset for another group, got %d", got)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected the offsets file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file left, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Error("Expected an error for a corrupt offsets file")
+	}
+}
+
+// waitLines waits until r received n lines
+func waitLines(t *testing.T, r *recorder, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(r.received()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d lines, got %d", n, len(r.received()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
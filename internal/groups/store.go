@@ -0,0 +1,91 @@
+package groups
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Offset is the saved offset of a group
+type Offset struct {
+	// File is the file the offset counts the lines of; the offset of a
+	// group that consumed another file does not apply
+	File      string `json:"file"`
+	Committed int    `json:"committed"`
+}
+
+// Store saves the committed offsets of the groups to a file
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	offsets map[string]Offset
+}
+
+// DefaultPath returns the default location of the group offsets file
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding config directory: %w", err)
+	}
+	return filepath.Join(dir, "webrtc-poc", "groups.json"), nil
+}
+
+// Open reads the offsets saved at path, starting with none if there is no
+// file yet
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, offsets: make(map[string]Offset)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading group offsets: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.offsets); err != nil {
+		return nil, fmt.Errorf("error parsing group offsets %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Get returns the committed offset of the group name in file, 0 if it has
+// none
+func (s *Store) Get(name, file string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.offsets[name]; ok && o.File == file {
+		return o.Committed
+	}
+	return 0
+}
+
+// Set saves the committed offset of the group name in file, unless a later
+// one was saved already, replacing the file atomically so a crash never
+// leaves a truncated one behind
+func (s *Store) Set(name, file string, committed int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.offsets[name]; ok && o.File == file && o.Committed >= committed {
+		return nil
+	}
+	s.offsets[name] = Offset{File: file, Committed: committed}
+
+	data, err := json.MarshalIndent(s.offsets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding group offsets: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("error creating group offsets directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing group offsets: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("error writing group offsets: %w", err)
+	}
+	return nil
+}
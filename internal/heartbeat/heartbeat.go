@@ -0,0 +1,168 @@
+// Package heartbeat exchanges timestamps between the client and the server
+// over a data channel of their connection, to estimate the offset between
+// their clocks the way NTP does, without either host relying on NTP. The
+// client pings with the time it sent the ping, t1, and the server answers
+// with t1, the time the ping arrived, t2, and the time its answer left, t3.
+// With the time the answer arrived, t4, the offset of the server's clock is
+// ((t2-t1)+(t3-t4))/2 and the round trip (t4-t1)-(t3-t2). The offset is
+// exact when both directions take equally long and off by at most half the
+// round trip otherwise, so like NTP's clock filter the estimate is the
+// sample with the shortest round trip among the recent ones. Every message
+// is three big endian int64 timestamps in nanoseconds since the Unix epoch,
+// t2 and t3 being zero in a ping.
+package heartbeat
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// Protocol marks the data channel the client pings the server on
+	Protocol = "webrtc-poc-heartbeat"
+	// Label is the label of the heartbeat channel
+	Label = "heartbeat"
+
+	messageSize = 24
+	// window is the number of recent samples the estimate is taken from
+	window = 8
+)
+
+// Sample is the outcome of one ping
+type Sample struct {
+	// Offset is how far the server's clock is ahead of the client's
+	Offset time.Duration
+	// RoundTrip is the network delay of the ping and its answer, without
+	// the time the server took to answer
+	RoundTrip time.Duration
+}
+
+// NewSample computes a sample from the four timestamps of a ping
+func NewSample(t1, t2, t3, t4 time.Time) Sample {
+	return Sample{
+		Offset:    (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		RoundTrip: t4.Sub(t1) - t3.Sub(t2),
+	}
+}
+
+func encode(t1, t2, t3 time.Time) []byte {
+	msg := make([]byte, messageSize)
+	for i, t := range []time.Time{t1, t2, t3} {
+		if !t.IsZero() {
+			binary.BigEndian.PutUint64(msg[i*8:], uint64(t.UnixNano()))
+		}
+	}
+	return msg
+}
+
+func decode(msg webrtc.DataChannelMessage) (t1, t2, t3 time.Time, ok bool) {
+	if msg.IsString || len(msg.Data) != messageSize {
+		return t1, t2, t3, false
+	}
+	at := func(i int) time.Time {
+		return time.Unix(0, int64(binary.BigEndian.Uint64(msg.Data[i*8:])))
+	}
+	return at(0), at(1), at(2), true
+}
+
+// Serve answers the pings on a heartbeat channel
+func Serve(dc *webrtc.DataChannel) {
+	serve(dc, time.Now)
+}
+
+// serve answers pings with the time now returns
+func serve(dc *webrtc.DataChannel, now func() time.Time) {
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		t2 := now()
+		t1, _, _, ok := decode(msg)
+		if !ok {
+			return
+		}
+		dc.Send(encode(t1, t2, now()))
+	})
+}
+
+// Estimator tracks the offset of the server's clock from the recent samples
+type Estimator struct {
+	mu      sync.Mutex
+	samples []Sample
+}
+
+// Add records a sample, dropping the oldest once the window is full
+func (e *Estimator) Add(s Sample) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.samples) == window {
+		e.samples = e.samples[1:]
+	}
+	e.samples = append(e.samples, s)
+}
+
+// Best returns the recent sample with the shortest round trip, reporting
+// false before the first one arrived. A nil estimator has no samples.
+func (e *Estimator) Best() (Sample, bool) {
+	if e == nil {
+		return Sample{}, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.samples) == 0 {
+		return Sample{}, false
+	}
+	best := e.samples[0]
+	for _, s := range e.samples[1:] {
+		if s.RoundTrip < best.RoundTrip {
+			best = s
+		}
+	}
+	return best, true
+}
+
+// Offset returns the estimated offset of the server's clock, zero until the
+// first sample arrived
+func (e *Estimator) Offset() time.Duration {
+	best, _ := e.Best()
+	return best.Offset
+}
+
+// Start opens a heartbeat channel on pc, pings the server as soon as it
+// opens and every interval after that until it closes, and returns the
+// estimator the answers are added to. A server that does not answer leaves
+// the estimator without samples.
+func Start(pc *webrtc.PeerConnection, interval time.Duration) (*Estimator, error) {
+	protocol := Protocol
+	dc, err := pc.CreateDataChannel(Label, &webrtc.DataChannelInit{Protocol: &protocol})
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Estimator{}
+	closed := make(chan struct{})
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		t4 := time.Now()
+		if t1, t2, t3, ok := decode(msg); ok {
+			e.Add(NewSample(t1, t2, t3, t4))
+		}
+	})
+	dc.OnClose(func() { close(closed) })
+	dc.OnOpen(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				if err := dc.Send(encode(time.Now(), time.Time{}, time.Time{})); err != nil {
+					return
+				}
+				select {
+				case <-ticker.C:
+				case <-closed:
+					return
+				}
+			}
+		}()
+	})
+	return e, nil
+}
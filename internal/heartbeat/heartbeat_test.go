@@ -0,0 +1,107 @@
+package heartbeat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestNewSample(t *testing.T) {
+	// The server's clock is 10s ahead, the ping takes 30ms, the answer 10ms
+	// and the server takes 5ms to answer
+	t1 := time.Unix(1000, 0)
+	t2 := t1.Add(10*time.Second + 30*time.Millisecond)
+	t3 := t2.Add(5 * time.Millisecond)
+	t4 := t1.Add(45 * time.Millisecond)
+
+	s := NewSample(t1, t2, t3, t4)
+	if s.RoundTrip != 40*time.Millisecond {
+		t.Errorf("Expected a 40ms round trip, got %v", s.RoundTrip)
+	}
+	// The asymmetry puts the estimate half the difference of the two
+	// directions off, within half the round trip
+	if s.Offset != 10*time.Second+10*time.Millisecond {
+		t.Errorf("Expected an offset of 10.01s, got %v", s.Offset)
+	}
+}
+
+func TestEstimator(t *testing.T) {
+	var nilEstimator *Estimator
+	if _, ok := nilEstimator.Best(); ok || nilEstimator.Offset() != 0 {
+		t.Error("Expected a nil estimator to have no samples")
+	}
+
+	var e Estimator
+	if _, ok := e.Best(); ok {
+		t.Error("Expected no estimate before the first sample")
+	}
+	e.Add(Sample{Offset: 30 * time.Millisecond, RoundTrip: 50 * time.Millisecond})
+	e.Add(Sample{Offset: 12 * time.Millisecond, RoundTrip: 4 * time.Millisecond})
+	e.Add(Sample{Offset: -8 * time.Millisecond, RoundTrip: 20 * time.Millisecond})
+	if got := e.Offset(); got != 12*time.Millisecond {
+		t.Errorf("Expected the offset of the shortest round trip, got %v", got)
+	}
+
+	// The best sample ages out of the window
+	for range window {
+		e.Add(Sample{Offset: time.Millisecond, RoundTrip: 9 * time.Millisecond})
+	}
+	if got := e.Offset(); got != time.Millisecond {
+		t.Errorf("Expected old samples to be dropped, got %v", got)
+	}
+}
+
+func TestStart(t *testing.T) {
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer client.Close()
+	server, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer server.Close()
+
+	// The server's clock runs an hour ahead
+	skew := time.Hour
+	server.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Protocol() == Protocol {
+			serve(dc, func() time.Time { return time.Now().Add(skew) })
+		}
+	})
+	estimator, err := Start(client, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("CreateOffer returned error: %v", err)
+	}
+	client.SetLocalDescription(offer)
+	<-webrtc.GatheringCompletePromise(client)
+	server.SetRemoteDescription(*client.LocalDescription())
+	answer, err := server.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("CreateAnswer returned error: %v", err)
+	}
+	server.SetLocalDescription(answer)
+	<-webrtc.GatheringCompletePromise(server)
+	client.SetRemoteDescription(*server.LocalDescription())
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if best, ok := estimator.Best(); ok {
+			if diff := best.Offset - skew; diff < -best.RoundTrip || diff > best.RoundTrip {
+				t.Errorf("Expected an offset of %v within the %v round trip, got %v", skew, best.RoundTrip, best.Offset)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for a heartbeat answer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,207 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header carries a signed identity assertion in signaling requests and
+// responses
+const Header = "X-Peer-Identity"
+
+// MaxSkew is how far an assertion's timestamp may be from the local clock
+const MaxSkew = 5 * time.Minute
+
+// Roles sign with different contexts so a client assertion can never be
+// passed off as the server's or the other way around
+const (
+	RoleClient = "client"
+	RoleServer = "server"
+)
+
+var (
+	// ErrNoAssertion is returned by Verify when no assertion was presented
+	ErrNoAssertion = errors.New("no identity assertion presented")
+	// ErrMalformed is wrapped by the errors of assertions and key bindings
+	// that cannot be parsed
+	ErrMalformed = errors.New("malformed identity")
+	// ErrInvalidSignature is returned for assertions and key bindings whose
+	// signature does not verify
+	ErrInvalidSignature = errors.New("invalid identity signature")
+	// ErrExpired is wrapped by the error of assertions made too long ago, or
+	// too far in the future, to be accepted
+	ErrExpired = errors.New("identity assertion expired")
+	// ErrNotAllowed is wrapped by the error of a proven identity that is not
+	// allowed to connect
+	ErrNotAllowed = errors.New("identity not allowed")
+	// ErrMismatch is wrapped by the error of a proven identity other than
+	// the one expected
+	ErrMismatch = errors.New("identity does not match")
+)
+
+// Identity is a peer's long-term Ed25519 keypair
+type Identity struct {
+	key ed25519.PrivateKey
+}
+
+// DefaultPath returns the default location of the identity key
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding config directory: %w", err)
+	}
+	return filepath.Join(dir, "webrtc-poc", "identity.key"), nil
+}
+
+// LoadOrCreate loads the identity key at path, generating and saving a new
+// one if it does not exist yet
+func LoadOrCreate(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return create(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading identity key: %w", err)
+	}
+	return Parse(data, path)
+}
+
+// Parse parses a PEM encoded identity key, naming it source in errors
+func Parse(data []byte, source string) (*Identity, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("error reading identity key %s: no PEM private key found", source)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing identity key %s: %w", source, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("identity key %s is not an Ed25519 key", source)
+	}
+	return &Identity{key: key}, nil
+}
+
+// Generate generates a new identity and returns its PEM encoded key
+func Generate() ([]byte, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating identity key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding identity key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// create generates a new identity and saves it to path
+func create(path string) (*Identity, error) {
+	data, err := Generate()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating identity directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("error writing identity key: %w", err)
+	}
+	return Parse(data, path)
+}
+
+// ID returns the public identity of the peer, the base64url encoded public
+// key, which is what allowlists and expected identities refer to
+func (id *Identity) ID() string {
+	return base64.RawURLEncoding.EncodeToString(id.key.Public().(ed25519.PublicKey))
+}
+
+// Sign creates an assertion that this identity, acting as role, sent payload
+// at now
+func (id *Identity) Sign(role string, payload []byte, now time.Time) string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := ed25519.Sign(id.key, message(role, ts, payload))
+	return id.ID() + ";" + ts + ";" + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// Verify checks an assertion made by a peer acting as role over payload and
+// returns the peer's identity
+func Verify(assertion, role string, payload []byte, now time.Time) (string, error) {
+	if assertion == "" {
+		return "", ErrNoAssertion
+	}
+
+	parts := strings.Split(assertion, ";")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w assertion", ErrMalformed)
+	}
+	id, ts, sigText := parts[0], parts[1], parts[2]
+
+	pub, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("%w in assertion", ErrMalformed)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigText)
+	if err != nil {
+		return "", fmt.Errorf("%w signature", ErrMalformed)
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w timestamp", ErrMalformed)
+	}
+
+	if skew := now.Sub(time.Unix(unix, 0)); skew > MaxSkew || skew < -MaxSkew {
+		return "", fmt.Errorf("%w: its timestamp is %v off", ErrExpired, skew.Round(time.Second))
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), message(role, ts, payload), sig) {
+		return "", ErrInvalidSignature
+	}
+	return id, nil
+}
+
+// SignedRequest is the payload a client identity assertion over a signaling
+// request covers
+func SignedRequest(method, query string, body []byte) []byte {
+	return append([]byte(method+" ?"+query+"\n"), body...)
+}
+
+// BindingSize is the length of a key binding created by Bind
+const BindingSize = ed25519.PublicKeySize + ed25519.SignatureSize
+
+// Bind signs key under a context label, proving that this identity owns it.
+// The binding holds the public key followed by the signature.
+func (id *Identity) Bind(context string, key []byte) []byte {
+	sig := ed25519.Sign(id.key, append([]byte(context+"\n"), key...))
+	return append(append([]byte{}, id.key.Public().(ed25519.PublicKey)...), sig...)
+}
+
+// VerifyBinding checks a binding created by Bind and returns the identity
+// that owns key
+func VerifyBinding(context string, key, binding []byte) (string, error) {
+	if len(binding) != BindingSize {
+		return "", fmt.Errorf("%w key binding", ErrMalformed)
+	}
+	pub := ed25519.PublicKey(binding[:ed25519.PublicKeySize])
+	if !ed25519.Verify(pub, append([]byte(context+"\n"), key...), binding[ed25519.PublicKeySize:]) {
+		return "", fmt.Errorf("%w on key binding", ErrInvalidSignature)
+	}
+	return base64.RawURLEncoding.EncodeToString(pub), nil
+}
+
+// message builds the bytes that are signed for an assertion
+func message(role, ts string, payload []byte) []byte {
+	digest := sha256.Sum256(payload)
+	return []byte(fmt.Sprintf("webrtc-poc-identity-v1\n%s\n%s\n%x", role, ts, digest))
+}
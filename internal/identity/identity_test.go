@@ -0,0 +1,179 @@
+package identity
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestIdentity(t *testing.T, dir, name string) *Identity {
+	t.Helper()
+	id, err := LoadOrCreate(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("LoadOrCreate returned error: %v", err)
+	}
+	return id
+}
+
+func TestLoadOrCreate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "identity-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "keys", "identity.key")
+
+	t.Run("CreatesAndReloads", func(t *testing.T) {
+		created, err := LoadOrCreate(path)
+		if err != nil {
+			t.Fatalf("LoadOrCreate returned error: %v", err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Identity key was not saved: %v", err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("Expected key permissions 0600, got %v", info.Mode().Perm())
+		}
+
+		loaded, err := LoadOrCreate(path)
+		if err != nil {
+			t.Fatalf("LoadOrCreate returned error: %v", err)
+		}
+		if loaded.ID() != created.ID() {
+			t.Errorf("Expected the same identity after reloading, got %s and %s", created.ID(), loaded.ID())
+		}
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		bad := filepath.Join(tmpDir, "bad.key")
+		if err := os.WriteFile(bad, []byte("not a key"), 0600); err != nil {
+			t.Fatalf("Failed to write key: %v", err)
+		}
+		if _, err := LoadOrCreate(bad); err == nil {
+			t.Error("Expected an error for an invalid key file")
+		}
+	})
+
+	t.Run("GenerateAndParse", func(t *testing.T) {
+		// Keys kept elsewhere than in a file, such as the keyring
+		data, err := Generate()
+		if err != nil {
+			t.Fatalf("Generate returned error: %v", err)
+		}
+		first, err := Parse(data, "keyring:identity")
+		if err != nil {
+			t.Fatalf("Parse returned error: %v", err)
+		}
+		second, err := Parse([]byte(strings.TrimSpace(string(data))), "keyring:identity")
+		if err != nil || second.ID() != first.ID() {
+			t.Errorf("Expected the same identity without the trailing newline, got %v", err)
+		}
+		if _, err := Parse([]byte("not a key"), "keyring:identity"); err == nil || !strings.Contains(err.Error(), "keyring:identity") {
+			t.Errorf("Expected an error naming the source, got %v", err)
+		}
+	})
+}
+
+func TestSignAndVerify(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "identity-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	id := newTestIdentity(t, tmpDir, "a.key")
+	now := time.Now()
+	payload := []byte(`{"type":"offer","sdp":"v=0"}`)
+	assertion := id.Sign(RoleClient, payload, now)
+
+	t.Run("Valid", func(t *testing.T) {
+		got, err := Verify(assertion, RoleClient, payload, now)
+		if err != nil || got != id.ID() {
+			t.Errorf("Expected identity %s, got %s (err=%v)", id.ID(), got, err)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := Verify("", RoleClient, payload, now); !errors.Is(err, ErrNoAssertion) {
+			t.Errorf("Expected ErrNoAssertion, got %v", err)
+		}
+	})
+
+	t.Run("TamperedPayload", func(t *testing.T) {
+		if _, err := Verify(assertion, RoleClient, []byte(`{"type":"offer","sdp":"v=1"}`), now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected ErrInvalidSignature for a tampered payload, got %v", err)
+		}
+	})
+
+	t.Run("WrongRole", func(t *testing.T) {
+		if _, err := Verify(assertion, RoleServer, payload, now); err == nil {
+			t.Error("Expected a client assertion to be rejected as a server assertion")
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		if _, err := Verify(assertion, RoleClient, payload, now.Add(MaxSkew+time.Minute)); !errors.Is(err, ErrExpired) {
+			t.Errorf("Expected ErrExpired for an old assertion, got %v", err)
+		}
+	})
+
+	t.Run("ForeignKey", func(t *testing.T) {
+		other := newTestIdentity(t, tmpDir, "b.key")
+		parts := strings.Split(assertion, ";")
+		forged := other.ID() + ";" + parts[1] + ";" + parts[2]
+		if _, err := Verify(forged, RoleClient, payload, now); err == nil {
+			t.Error("Expected an error for a signature made by another key")
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, value := range []string{"abc", "a;b;c", id.ID() + ";soon;sig"} {
+			if _, err := Verify(value, RoleClient, payload, now); !errors.Is(err, ErrMalformed) {
+				t.Errorf("Expected ErrMalformed for %q, got %v", value, err)
+			}
+		}
+	})
+}
+
+func TestBind(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "identity-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	id := newTestIdentity(t, tmpDir, "a.key")
+	key := []byte("0123456789abcdef0123456789abcdef")
+	binding := id.Bind("test", key)
+
+	t.Run("Valid", func(t *testing.T) {
+		got, err := VerifyBinding("test", key, binding)
+		if err != nil || got != id.ID() {
+			t.Errorf("Expected identity %s, got %s (err=%v)", id.ID(), got, err)
+		}
+	})
+
+	t.Run("WrongContext", func(t *testing.T) {
+		if _, err := VerifyBinding("other", key, binding); err == nil {
+			t.Error("Expected an error for a binding made in another context")
+		}
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		if _, err := VerifyBinding("test", []byte("another key"), binding); err == nil {
+			t.Error("Expected an error for a binding of another key")
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		if _, err := VerifyBinding("test", key, binding[:10]); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Expected ErrMalformed for a truncated binding, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package integration
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// batchesSent reads how many batches the server at addr sent from its metrics
+func batchesSent(t *testing.T, addr string) int {
+	t.Helper()
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	m := regexp.MustCompile(`(?m)^webrtc_poc_batches_total (\d+)$`).FindSubmatch(body)
+	if m == nil {
+		t.Fatalf("No webrtc_poc_batches_total in metrics:\n%s", body)
+	}
+	n, _ := strconv.Atoi(string(m[1]))
+	return n
+}
+
+// TestBatchedStream streams a file to a client that accepts batches, with
+// lines that are empty, end in CRLF, and are split into several records,
+// and checks that the client writes every record once and in order
+func TestBatchedStream(t *testing.T) {
+	var lines, want []string
+	for i := 1; i <= 500; i++ {
+		switch {
+		case i%50 == 0:
+			// Split into records of 64 bytes, sent among the others
+			line := strings.Repeat(fmt.Sprintf("%d-", i), 60)
+			lines = append(lines, line)
+			for len(line) > 64 {
+				want = append(want, line[:64])
+				line = line[64:]
+			}
+			want = append(want, line)
+		case i%7 == 0:
+			lines = append(lines, "")
+			want = append(want, "")
+		case i%11 == 0:
+			lines = append(lines, fmt.Sprintf("crlf %d\r", i))
+			want = append(want, fmt.Sprintf("crlf %d", i))
+		default:
+			lines = append(lines, fmt.Sprintf("line %d\twith a tab", i))
+			want = append(want, lines[len(lines)-1])
+		}
+	}
+
+	addr := freeAddr(t)
+	input := writeLines(t, t.TempDir(), lines)
+	server, serverLog := startCurrent(t, "server", "--addr", addr, "--file", input, "--delay", "0",
+		"--batch", "40", "--batch-bytes", "1KiB", "--max-record-size", "64", "--oversized", "split")
+	defer stop(server)
+	waitReady(t, addr, serverLog)
+
+	output := filepath.Join(t.TempDir(), "output.txt")
+	client, clientLog := startCurrent(t, "client", "--server", "http://"+addr+"/offer", "--output", output, "--batch")
+	defer stop(client)
+	waitOutput(t, output, strings.Join(want, "\n")+"\n", clientLog)
+
+	if n := batchesSent(t, addr); n < 2 {
+		t.Errorf("Expected the stream to be sent in batches, the server sent %d", n)
+	}
+	if strings.Contains(clientLog.String(), "Warning: received") {
+		t.Errorf("Client counted fewer lines than the server sent:\n%s", clientLog)
+	}
+}
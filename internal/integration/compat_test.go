@@ -0,0 +1,399 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// The v1 wire protocol of the first release, frozen here on purpose instead
+// of importing the packages that implement it today, so a change that breaks
+// peers built against it fails these tests instead of going unnoticed:
+//
+//   - The client opens a data channel labelled v1InitLabel, without a
+//     subprotocol, only so its offer carries a data channel section, and
+//     POSTs the offer as a JSON session description to /offer, getting the
+//     answer back the same way with every candidate in it.
+//   - The server creates the file stream channel labelled
+//     v1FileChannelLabel, ordered and reliable, and announces it in-band.
+//     The client accepts whichever channel the server announces.
+//   - Every line is one text message, without its newline. The client
+//     writes every message it gets as a line, text or binary.
+//   - The server closes the channel once it sent the last line, which ends
+//     the stream. Neither side sends anything else, and the server ignores
+//     what it gets on the channel.
+const (
+	v1InitLabel        = "initChannel"
+	v1FileChannelLabel = "fileStream"
+)
+
+// compatCases are the files streamed in both directions
+var compatCases = map[string][]string{
+	"Plain":           {"first line", "second line", "third line"},
+	"EmptyAndSpecial": {"", "tab\tand \x00 nul", "", "ünïcödé ✓", ""},
+	"Long":            {strings.Repeat("a", 60000), strings.Repeat("b", 16384), "end"},
+	"Many":            numbered(2000),
+}
+
+// numbered returns n lines counting from 1
+func numbered(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d", i+1)
+	}
+	return lines
+}
+
+var (
+	buildOnce sync.Once
+	binPath   string
+	buildErr  error
+)
+
+// webrtcPOC builds the current webrtc-poc binary once for all tests
+func webrtcPOC(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("Skipping protocol compatibility tests in short mode")
+	}
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("Skipping protocol compatibility tests without a go command")
+	}
+	buildOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "webrtc-poc-compat-*")
+		if err != nil {
+			buildErr = err
+			return
+		}
+		binPath = filepath.Join(dir, "webrtc-poc")
+		out, err := exec.Command(goCmd, "build", "-o", binPath, "github.com/developmeh/webrtc-poc/cmd/webrtc-poc").CombinedOutput()
+		if err != nil {
+			buildErr = fmt.Errorf("%v: %s", err, out)
+		}
+	})
+	if buildErr != nil {
+		t.Fatalf("Failed to build webrtc-poc: %v", buildErr)
+	}
+	return binPath
+}
+
+// processLog collects the output of a process while it runs
+type processLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *processLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *processLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+// startCurrent starts the current binary with args in a directory of its
+// own, so it reads no config file and keeps its identity there
+func startCurrent(t *testing.T, args ...string) (*exec.Cmd, *processLog) {
+	t.Helper()
+	dir := t.TempDir()
+	log := &processLog{}
+	cmd := exec.Command(webrtcPOC(t), args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "HOME="+dir, "XDG_CONFIG_HOME="+dir)
+	cmd.Stdout, cmd.Stderr = log, log
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start webrtc-poc: %v", err)
+	}
+	return cmd, log
+}
+
+// stop interrupts a process started by startCurrent and waits for it
+func stop(cmd *exec.Cmd) {
+	cmd.Process.Signal(os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+// writeLines writes lines to a file in dir, each ending with a newline
+func writeLines(t *testing.T, dir string, lines []string) string {
+	t.Helper()
+	path := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	return path
+}
+
+// freeAddr returns a loopback address that was free a moment ago
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// v1Result is what a v1 fixture saw of a stream
+type v1Result struct {
+	lines      []string
+	unexpected []string
+	closed     bool
+}
+
+// runV1Client receives a stream from serverURL as a v1 client would
+func runV1Client(t *testing.T, serverURL string) v1Result {
+	t.Helper()
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer pc.Close()
+
+	if _, err := pc.CreateDataChannel(v1InitLabel, nil); err != nil {
+		t.Fatalf("Failed to create init channel: %v", err)
+	}
+
+	var mu sync.Mutex
+	var result v1Result
+	closed := make(chan struct{})
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			result.lines = append(result.lines, string(msg.Data))
+			if !msg.IsString {
+				result.unexpected = append(result.unexpected, fmt.Sprintf("%x", msg.Data))
+			}
+		})
+		dc.OnClose(func() { close(closed) })
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatalf("Failed to set local description: %v", err)
+	}
+	<-gathered
+
+	body, _ := json.Marshal(pc.LocalDescription())
+	resp, err := http.Post(serverURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to send offer: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Server answered the offer with %s", resp.Status)
+	}
+	var answer webrtc.SessionDescription
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		t.Fatalf("Failed to decode answer: %v", err)
+	}
+	if err := pc.SetRemoteDescription(answer); err != nil {
+		t.Fatalf("Failed to set remote description: %v", err)
+	}
+
+	select {
+	case <-closed:
+		result.closed = true
+	case <-time.After(30 * time.Second):
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return result
+}
+
+// v1Server streams lines to every client that connects, as a v1 server
+// would, recording what it saw of each stream
+type v1Server struct {
+	lines []string
+
+	mu      sync.Mutex
+	results []v1Result
+	closed  chan struct{}
+}
+
+func newV1Server(lines []string) *v1Server {
+	return &v1Server{lines: lines, closed: make(chan struct{}, 1)}
+}
+
+func (s *v1Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/offer" {
+		http.NotFound(w, r)
+		return
+	}
+	var offer webrtc.SessionDescription
+	if err := json.NewDecoder(r.Body).Decode(&offer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dc, err := pc.CreateDataChannel(v1FileChannelLabel, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var result v1Result
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		result.unexpected = append(result.unexpected, fmt.Sprintf("%q", msg.Data))
+	})
+	dc.OnOpen(func() {
+		go func() {
+			defer dc.Close()
+			for _, line := range s.lines {
+				if err := dc.SendText(line); err != nil {
+					return
+				}
+			}
+		}()
+	})
+	dc.OnClose(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		result.closed = true
+		s.results = append(s.results, result)
+		s.closed <- struct{}{}
+	})
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	<-gathered
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pc.LocalDescription())
+}
+
+// TestCompatCurrentServerV1Client streams from the current server to a v1
+// client fixture
+func TestCompatCurrentServerV1Client(t *testing.T) {
+	for name, lines := range compatCases {
+		t.Run(name, func(t *testing.T) {
+			addr := freeAddr(t)
+			input := writeLines(t, t.TempDir(), lines)
+			server, log := startCurrent(t, "server", "--addr", addr, "--file", input, "--delay", "0")
+			defer stop(server)
+			waitReady(t, addr, log)
+
+			result := runV1Client(t, "http://"+addr+"/offer")
+			if len(result.unexpected) > 0 {
+				t.Errorf("v1 client received binary messages it writes as lines: %v", result.unexpected)
+			}
+			if i := firstDifference(result.lines, lines); i >= 0 {
+				got := "(missing)"
+				if i < len(result.lines) {
+					got = fmt.Sprintf("%.80q", result.lines[i])
+				}
+				t.Errorf("v1 client received %d lines, expected %d; line %d is %s", len(result.lines), len(lines), i+1, got)
+			}
+			if !result.closed {
+				t.Errorf("Server did not close the file channel at the end of the file:\n%s", log)
+			}
+		})
+	}
+}
+
+// TestCompatCurrentClientV1Server streams from a v1 server fixture to the
+// current client
+func TestCompatCurrentClientV1Server(t *testing.T) {
+	for name, lines := range compatCases {
+		t.Run(name, func(t *testing.T) {
+			fixture := newV1Server(lines)
+			server := httptest.NewServer(fixture)
+			defer server.Close()
+
+			output := filepath.Join(t.TempDir(), "output.txt")
+			client, log := startCurrent(t, "client", "--server", server.URL+"/offer", "--output", output)
+			defer stop(client)
+			select {
+			case <-fixture.closed:
+			case <-time.After(30 * time.Second):
+				t.Fatalf("Client did not accept the stream:\n%s", log)
+			}
+			waitOutput(t, output, strings.Join(lines, "\n")+"\n", log)
+
+			fixture.mu.Lock()
+			results := fixture.results
+			fixture.mu.Unlock()
+			if len(results) != 1 {
+				t.Fatalf("Expected one stream, got %d", len(results))
+			}
+			if len(results[0].unexpected) > 0 {
+				t.Errorf("v1 server received messages it ignores: %v", results[0].unexpected)
+			}
+
+			// A v1 server sends no digest, which the client warns about;
+			// any other warning means it misread the stream
+			for _, line := range strings.Split(log.String(), "\n") {
+				if strings.Contains(line, "Warning: ") && !strings.Contains(line, "sent no digest") {
+					t.Errorf("Client warned about the v1 stream:\n%s", log)
+					break
+				}
+			}
+		})
+	}
+}
+
+// firstDifference returns the index of the first line that differs between
+// got and want, or -1 if they are equal
+func firstDifference(got, want []string) int {
+	for i := range got {
+		if i >= len(want) || got[i] != want[i] {
+			return i
+		}
+	}
+	if len(got) < len(want) {
+		return len(got)
+	}
+	return -1
+}
@@ -0,0 +1,88 @@
+package integration
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// waitConnectTimeout waits for a client started with --connect-timeout 1s to
+// give up on its connection, and checks that it exits with status 1 and names
+// the timeout
+func waitConnectTimeout(t *testing.T, client *exec.Cmd, log *processLog) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- client.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(20 * time.Second):
+		client.Process.Kill()
+		<-done
+		t.Fatalf("Client did not give up on the connection:\n%s", log)
+	}
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 1 {
+		t.Errorf("Expected the client to exit with status 1, got %v", err)
+	}
+	if !strings.Contains(log.String(), "connection not established within --connect-timeout 1s") {
+		t.Errorf("Expected the client to name the timeout:\n%s", log)
+	}
+}
+
+// TestConnectTimeoutSignaling points a client at a server that never answers
+// its offer
+func TestConnectTimeoutSignaling(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client, clientLog := startCurrent(t, "client", "--server", server.URL+"/offer", "--connect-timeout", "1s")
+	waitConnectTimeout(t, client, clientLog)
+}
+
+// TestConnectTimeoutICE answers the client's offer from a peer connection that
+// is closed right away, so the client's ICE checks never succeed
+func TestConnectTimeoutICE(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		var offer webrtc.SessionDescription
+		if err := json.NewDecoder(r.Body).Decode(&offer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer pc.Close()
+		if err := pc.SetRemoteDescription(offer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		answer, err := pc.CreateAnswer(nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pc.SetLocalDescription(answer)
+		<-webrtc.GatheringCompletePromise(pc)
+		json.NewEncoder(w).Encode(pc.LocalDescription())
+	}))
+	defer server.Close()
+
+	client, clientLog := startCurrent(t, "client", "--server", server.URL+"/offer", "--connect-timeout", "1s")
+	waitConnectTimeout(t, client, clientLog)
+}
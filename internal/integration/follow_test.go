@@ -0,0 +1,128 @@
+package integration
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitReady waits for a server started by startCurrent to listen on addr
+func waitReady(t *testing.T, addr string, log *processLog) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if resp, err := http.Get("http://" + addr + "/readyz"); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+	}
+	t.Fatalf("Server did not start:\n%s", log)
+}
+
+// waitOutput waits for the file at path to hold exactly want
+func waitOutput(t *testing.T, path, want string, log *processLog) {
+	t.Helper()
+	var got []byte
+	for deadline := time.Now().Add(15 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		got, _ = os.ReadFile(path)
+		if string(got) == want {
+			return
+		}
+	}
+	t.Fatalf("Client wrote %q, expected %q:\n%s", got, want, log)
+}
+
+// TestFollowAppendedLines streams a followed file and checks that lines
+// appended after the client caught up reach it over the same channel
+func TestFollowAppendedLines(t *testing.T) {
+	addr := freeAddr(t)
+	input := writeLines(t, t.TempDir(), []string{"one", "two"})
+	server, serverLog := startCurrent(t, "server", "--addr", addr, "--file", input, "--delay", "0", "--follow")
+	defer stop(server)
+	waitReady(t, addr, serverLog)
+
+	output := filepath.Join(t.TempDir(), "output.txt")
+	client, clientLog := startCurrent(t, "client", "--server", "http://"+addr+"/offer", "--output", output)
+	defer stop(client)
+	waitOutput(t, output, "one\ntwo\n", clientLog)
+
+	// Append a complete line and then one written in two parts, which must
+	// only be sent once its newline is
+	f, err := os.OpenFile(input, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Failed to open input: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("three\nfo"); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	waitOutput(t, output, "one\ntwo\nthree\n", clientLog)
+	time.Sleep(500 * time.Millisecond)
+	if _, err := f.WriteString("ur\n"); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	waitOutput(t, output, "one\ntwo\nthree\nfour\n", clientLog)
+
+	if strings.Contains(serverLog.String(), "Finished streaming") {
+		t.Errorf("Server stopped following the file:\n%s", serverLog)
+	}
+}
+
+// TestFollowBeyondWorkers follows a file with more clients than workers and
+// checks that every client gets its lines instead of waiting for a worker
+// that is never freed
+func TestFollowBeyondWorkers(t *testing.T) {
+	addr := freeAddr(t)
+	input := writeLines(t, t.TempDir(), []string{"one", "two"})
+	server, serverLog := startCurrent(t, "server", "--addr", addr, "--file", input, "--delay", "0", "--follow", "--workers", "1")
+	defer stop(server)
+	waitReady(t, addr, serverLog)
+
+	for i := range 2 {
+		output := filepath.Join(t.TempDir(), "output.txt")
+		client, clientLog := startCurrent(t, "client", "--server", "http://"+addr+"/offer", "--output", output)
+		defer stop(client)
+		waitOutput(t, output, "one\ntwo\n", clientLog)
+		if i == 1 && strings.Contains(serverLog.String(), "workers are busy") {
+			t.Errorf("Expected the followed transfers to run outside the worker pool:\n%s", serverLog)
+		}
+	}
+}
+
+// TestFollowReplay replays a followed file from a sequence number and from
+// the lines its retention log saw arrive since a time
+func TestFollowReplay(t *testing.T) {
+	addr := freeAddr(t)
+	input := writeLines(t, t.TempDir(), []string{"one", "two"})
+	server, serverLog := startCurrent(t, "server", "--addr", addr, "--file", input, "--delay", "0", "--follow",
+		"--retention", "1h", "--retention-dir", t.TempDir())
+	defer stop(server)
+	waitReady(t, addr, serverLog)
+
+	output := filepath.Join(t.TempDir(), "output.txt")
+	client, clientLog := startCurrent(t, "client", "--server", "http://"+addr+"/offer", "--output", output, "--since-seq", "1")
+	defer stop(client)
+	waitOutput(t, output, "two\n", clientLog)
+
+	// The lines there before the server started arrived at no known time,
+	// so a client replaying the last minute only gets the appended one
+	f, err := os.OpenFile(input, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Failed to open input: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("three\n"); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	waitOutput(t, output, "two\nthree\n", clientLog)
+	time.Sleep(time.Second)
+
+	recent := filepath.Join(t.TempDir(), "output.txt")
+	client, clientLog = startCurrent(t, "client", "--server", "http://"+addr+"/offer", "--output", recent, "--since", "1m")
+	defer stop(client)
+	waitOutput(t, recent, "three\n", clientLog)
+}
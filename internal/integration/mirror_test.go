@@ -0,0 +1,83 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMirrorReplacesChangedFiles mirrors a server's shared directory twice,
+// changing one file in between, and checks that only that file is replaced
+func TestMirrorReplacesChangedFiles(t *testing.T) {
+	share := t.TempDir()
+	for name, content := range map[string]string{"a.log": "a1\na2\n", "b.log": "b1\nb2\n", "c.txt": "c1\n"} {
+		if err := os.WriteFile(filepath.Join(share, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	addr := freeAddr(t)
+	server, serverLog := startCurrent(t, "server", "--addr", addr, "--file", filepath.Join(share, "c.txt"), "--delay", "0", "--share-dir", share)
+	defer stop(server)
+	waitReady(t, addr, serverLog)
+
+	dest := t.TempDir()
+	mirror := func() string {
+		t.Helper()
+		cmd, log := startCurrent(t, "mirror", "--server", "http://"+addr+"/offer", "--dest", dest, "--include", "*.log", "--interval", "0")
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Mirror failed: %v\n%s", err, log)
+			}
+		case <-time.After(30 * time.Second):
+			stop(cmd)
+			t.Fatalf("Mirror did not finish:\n%s", log)
+		}
+		return log.String()
+	}
+
+	log := mirror()
+	for name, want := range map[string]string{"a.log": "a1\na2\n", "b.log": "b1\nb2\n"} {
+		if got, _ := os.ReadFile(filepath.Join(dest, name)); string(got) != want {
+			t.Errorf("Expected %s to hold %q, got %q:\n%s", name, want, got, log)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "c.txt")); err == nil {
+		t.Error("Expected only the files matching --include to be mirrored")
+	}
+
+	// Date the copies back, so a file that is replaced shows in its
+	// modification time
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, name := range []string{"a.log", "b.log"} {
+		os.Chtimes(filepath.Join(dest, name), old, old)
+	}
+	if err := os.WriteFile(filepath.Join(share, "a.log"), []byte("a1\nchanged\n"), 0644); err != nil {
+		t.Fatalf("Failed to change a.log: %v", err)
+	}
+
+	log = mirror()
+	if !strings.Contains(log, "1 files changed") {
+		t.Errorf("Expected one changed file:\n%s", log)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dest, "a.log")); string(got) != "a1\nchanged\n" {
+		t.Errorf("Expected a.log to be replaced, got %q", got)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "a.log")); err != nil || info.ModTime().Equal(old) {
+		t.Errorf("Expected a.log to be replaced by a new file")
+	}
+	if info, err := os.Stat(filepath.Join(dest, "b.log")); err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("Expected the unchanged b.log to be left alone")
+	}
+	entries, _ := os.ReadDir(dest)
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".part") {
+			t.Errorf("Expected no partial files to be left, found %s", e.Name())
+		}
+	}
+}
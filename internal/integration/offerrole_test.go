@@ -0,0 +1,28 @@
+package integration
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestServerOffer streams a file with --offer-role server, where the client
+// fetches the offer from /server-offer and posts its answer to /answer
+func TestServerOffer(t *testing.T) {
+	lines := []string{"first line", "", "third line", strings.Repeat("x", 5000)}
+	input := writeLines(t, t.TempDir(), lines)
+
+	addr := freeAddr(t)
+	server, serverLog := startCurrent(t, "server", "--addr", addr, "--file", input, "--delay", "0", "--offer-role", "server")
+	defer stop(server)
+	waitReady(t, addr, serverLog)
+
+	output := filepath.Join(t.TempDir(), "output.txt")
+	client, clientLog := startCurrent(t, "client", "--server", "http://"+addr+"/offer", "--output", output, "--offer-role", "server")
+	defer stop(client)
+
+	waitOutput(t, output, strings.Join(lines, "\n")+"\n", clientLog)
+	if !strings.Contains(clientLog.String(), "Raw server offer") {
+		t.Errorf("Expected the client to answer the server's offer:\n%s", clientLog)
+	}
+}
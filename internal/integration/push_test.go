@@ -0,0 +1,46 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPushOutsideShareDir checks that POST /push only pushes files inside
+// --share-dir, however the path is written
+func TestPushOutsideShareDir(t *testing.T) {
+	dir := t.TempDir()
+	share := filepath.Join(dir, "share")
+	os.Mkdir(share, 0755)
+	inside := writeLines(t, share, []string{"shared"})
+	outside := writeLines(t, dir, []string{"private"})
+	os.Symlink(outside, filepath.Join(share, "link.txt"))
+
+	addr := freeAddr(t)
+	server, serverLog := startCurrent(t, "server", "--addr", addr, "--file", inside, "--share-dir", share, "--peer", "edge-1")
+	defer stop(server)
+	waitReady(t, addr, serverLog)
+
+	push := func(file string) int {
+		body, _ := json.Marshal(map[string]string{"peer": "edge-1", "file": file})
+		resp, err := http.Post("http://"+addr+"/push", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to post the push: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, file := range []string{outside, filepath.Join(share, "..", "input.txt"), filepath.Join(share, "link.txt"), "/etc/passwd"} {
+		if status := push(file); status != http.StatusForbidden {
+			t.Errorf("Expected the push of %s to be forbidden, got %d", file, status)
+		}
+	}
+	// The peer is not connected, so a file it may get is refused only after
+	// the path check passed
+	if status := push(inside); status != http.StatusConflict {
+		t.Errorf("Expected the push of %s to reach the peer check, got %d:\n%s", inside, status, serverLog)
+	}
+}
@@ -0,0 +1,63 @@
+package integration
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRawRoundTrip streams a binary file with --raw and checks that the
+// client writes it back byte for byte, with no lines imposed on it
+func TestRawRoundTrip(t *testing.T) {
+	// Runs of NUL bytes and newlines, a run longer than the line scanner
+	// accepts without a newline, a CR before the end of a chunk, and a last
+	// chunk that is not full
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 5*32<<10+1234)
+	rng.Read(data)
+	copy(data[1000:], bytes.Repeat([]byte{0}, 4096))
+	copy(data[8000:], "\n\n\r\n")
+	copy(data[32<<10-1:], "\r")
+	for i := 40 << 10; i < 120<<10; i++ {
+		if data[i] == '\n' {
+			data[i] = 'x'
+		}
+	}
+	input := filepath.Join(t.TempDir(), "image.bin")
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	addr := freeAddr(t)
+	server, serverLog := startCurrent(t, "server", "--addr", addr, "--file", input, "--delay", "0", "--raw")
+	defer stop(server)
+	waitReady(t, addr, serverLog)
+
+	output := filepath.Join(t.TempDir(), "image.out")
+	client, clientLog := startCurrent(t, "client", "--server", "http://"+addr+"/offer", "--output", output)
+	defer stop(client)
+
+	for deadline := time.Now().Add(15 * time.Second); !strings.Contains(clientLog.String(), "Output verified"); time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Client did not verify its output:\n%s", clientLog)
+		}
+	}
+	got, _ := os.ReadFile(output)
+	if !bytes.Equal(got, data) {
+		at := 0
+		for at < len(got) && at < len(data) && got[at] == data[at] {
+			at++
+		}
+		t.Fatalf("Client wrote %d bytes, expected %d, first difference at byte %d:\n%s", len(got), len(data), at, clientLog)
+	}
+	if !strings.Contains(serverLog.String(), "sent 6 chunks") {
+		t.Errorf("Expected the server to send 6 chunks:\n%s", serverLog)
+	}
+	if strings.Contains(clientLog.String(), "Warning: received") {
+		t.Errorf("Client counted fewer chunks than the server sent:\n%s", clientLog)
+	}
+}
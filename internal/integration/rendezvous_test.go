@@ -0,0 +1,83 @@
+package integration
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSendReceive sends a file through a rendezvous server started with
+// signal and checks that receive writes back the bytes that were sent
+func TestSendReceive(t *testing.T) {
+	var content strings.Builder
+	content.WriteString("first line\n\n\ttabbed line with trailing space \n")
+	content.WriteString(strings.Repeat("long line ", 2000) + "\n")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	input := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(input, []byte(content.String()), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	addr := freeAddr(t)
+	relay, relayLog := startCurrent(t, "signal", "--addr", addr)
+	defer stop(relay)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Rendezvous server did not start:\n%s", relayLog)
+		}
+	}
+
+	send, sendLog := startCurrent(t, "send", "--rendezvous", "http://"+addr, input)
+	defer stop(send)
+	codeLine := regexp.MustCompile(`RENDEZVOUS_CODE=(\S+)`)
+	var code string
+	for deadline := time.Now().Add(10 * time.Second); code == ""; time.Sleep(50 * time.Millisecond) {
+		if m := codeLine.FindStringSubmatch(sendLog.String()); m != nil {
+			code = m[1]
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Sender did not register a code:\n%s", sendLog)
+		}
+	}
+
+	output := filepath.Join(t.TempDir(), "output.txt")
+	receive, receiveLog := startCurrent(t, "receive", "--rendezvous", "http://"+addr, "--output", output, code)
+	defer stop(receive)
+
+	// Both peers exit on their own once the file arrived
+	wait := func(name string, cmd *exec.Cmd, log *processLog) {
+		t.Helper()
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%s failed: %v\n%s", name, err, log)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatalf("%s did not finish:\n%s", name, log)
+		}
+	}
+	wait("receive", receive, receiveLog)
+	wait("send", send, sendLog)
+
+	got, _ := os.ReadFile(output)
+	if !bytes.Equal(got, []byte(content.String())) {
+		t.Fatalf("Receiver wrote %d bytes, expected %d:\n%s", len(got), content.Len(), receiveLog)
+	}
+	if !strings.Contains(sendLog.String(), "the peer received all of them") {
+		t.Errorf("Expected the sender to report every line acknowledged:\n%s", sendLog)
+	}
+}
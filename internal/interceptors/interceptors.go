@@ -0,0 +1,82 @@
+// Package interceptors configures the pion interceptors registered on the
+// WebRTC API, so media tracks added to a connection get retransmissions,
+// RTCP reports and congestion control feedback
+package interceptors
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+)
+
+// Names of the interceptors that can be enabled
+const (
+	// NACK generates and answers negative acknowledgements for lost packets
+	NACK = "nack"
+	// RTCPReports generates sender and receiver reports, the basis of the
+	// inbound and outbound RTP statistics
+	RTCPReports = "rtcp_reports"
+	// TWCC generates transport-wide congestion control feedback
+	TWCC = "twcc"
+	// None disables every interceptor
+	None = "none"
+)
+
+// Default is the set of interceptors enabled unless configured otherwise
+var Default = []string{NACK, RTCPReports, TWCC}
+
+// configure registers one interceptor and the media engine settings it needs
+var configure = map[string]func(*webrtc.MediaEngine, *interceptor.Registry) error{
+	NACK: webrtc.ConfigureNack,
+	RTCPReports: func(_ *webrtc.MediaEngine, registry *interceptor.Registry) error {
+		return webrtc.ConfigureRTCPReports(registry)
+	},
+	TWCC: webrtc.ConfigureTWCCSender,
+}
+
+// Validate checks that every name is a known interceptor and that "none" is
+// not combined with others
+func Validate(names []string) error {
+	for _, name := range names {
+		if name == None {
+			if len(names) > 1 {
+				return fmt.Errorf("interceptor %q cannot be combined with others", None)
+			}
+			continue
+		}
+		if _, ok := configure[name]; !ok {
+			return fmt.Errorf("unknown interceptor %q (expected %s or %s)", name, strings.Join(Default, ", "), None)
+		}
+	}
+	return nil
+}
+
+// APIOptions returns the options that register the default codecs and the
+// named interceptors on a WebRTC API
+func APIOptions(names []string) ([]func(*webrtc.API), error) {
+	if err := Validate(names); err != nil {
+		return nil, err
+	}
+
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, fmt.Errorf("error registering codecs: %w", err)
+	}
+
+	registry := &interceptor.Registry{}
+	for _, name := range names {
+		if name == None {
+			continue
+		}
+		if err := configure[name](mediaEngine, registry); err != nil {
+			return nil, fmt.Errorf("error registering interceptor %s: %w", name, err)
+		}
+	}
+
+	return []func(*webrtc.API){
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(registry),
+	}, nil
+}
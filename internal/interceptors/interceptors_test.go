@@ -0,0 +1,78 @@
+package interceptors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestValidate(t *testing.T) {
+	valid := [][]string{nil, Default, {NACK}, {None}}
+	for _, names := range valid {
+		if err := Validate(names); err != nil {
+			t.Errorf("Expected %v to be valid, got %v", names, err)
+		}
+	}
+
+	invalid := [][]string{{"fec"}, {None, NACK}}
+	for _, names := range invalid {
+		if err := Validate(names); err == nil {
+			t.Errorf("Expected an error for %v", names)
+		}
+	}
+}
+
+// offerFor creates an offer with a video track using the given interceptors
+func offerFor(t *testing.T, names []string) string {
+	t.Helper()
+	options, err := APIOptions(names)
+	if err != nil {
+		t.Fatalf("APIOptions returned error: %v", err)
+	}
+
+	pc, err := webrtc.NewAPI(options...).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer pc.Close()
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "test")
+	if err != nil {
+		t.Fatalf("Failed to create track: %v", err)
+	}
+	if _, err := pc.AddTrack(track); err != nil {
+		t.Fatalf("Failed to add track: %v", err)
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("Failed to create offer: %v", err)
+	}
+	return offer.SDP
+}
+
+func TestAPIOptions(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		sdp := offerFor(t, Default)
+		for _, want := range []string{"nack", "transport-cc"} {
+			if !strings.Contains(sdp, want) {
+				t.Errorf("Expected the offer to advertise %s", want)
+			}
+		}
+	})
+
+	t.Run("None", func(t *testing.T) {
+		// The default codecs advertise NACK on their own, TWCC needs the
+		// interceptor
+		if strings.Contains(offerFor(t, []string{None}), "transport-cc") {
+			t.Error("Expected the offer not to advertise transport-cc")
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := APIOptions([]string{"fec"}); err == nil {
+			t.Error("Expected an error for an unknown interceptor")
+		}
+	})
+}
@@ -0,0 +1,93 @@
+// Package keyring stores secrets in the operating system's keychain: the
+// macOS Keychain, the Windows Credential Manager or a Secret Service provider
+// such as GNOME Keyring or KWallet. Configuration values refer to a stored
+// secret as keyring:name, so auth tokens and keys need not be written to disk
+// in the clear. Every secret is stored under the webrtc-poc service with its
+// name as the account.
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	gokeyring "github.com/zalando/go-keyring"
+)
+
+const (
+	// Prefix starts a reference to a secret in the keyring
+	Prefix = "keyring:"
+	// Service is the service secrets are stored under
+	Service = "webrtc-poc"
+)
+
+// ErrNotFound is returned for a name with no secret stored under it
+var ErrNotFound = errors.New("secret not found in the keyring")
+
+// Name returns the name a keyring reference refers to, and whether value is
+// one
+func Name(value string) (string, bool) {
+	if !strings.HasPrefix(value, Prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(value, Prefix), true
+}
+
+// Get returns the secret stored under name
+func Get(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("keyring reference has no name")
+	}
+	secret, err := gokeyring.Get(Service, name)
+	if errors.Is(err, gokeyring.ErrNotFound) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading %s from the keyring: %w", name, err)
+	}
+	return secret, nil
+}
+
+// Set stores secret under name, replacing the secret stored before
+func Set(name, secret string) error {
+	if name == "" {
+		return errors.New("keyring reference has no name")
+	}
+	if err := gokeyring.Set(Service, name, secret); err != nil {
+		return fmt.Errorf("error storing %s in the keyring: %w", name, err)
+	}
+	return nil
+}
+
+// Delete removes the secret stored under name
+func Delete(name string) error {
+	err := gokeyring.Delete(Service, name)
+	if errors.Is(err, gokeyring.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting %s from the keyring: %w", name, err)
+	}
+	return nil
+}
+
+// GetOrCreate returns the secret stored under name, storing the one generate
+// returns first if there is none yet, for keys created on first use
+func GetOrCreate(name string, generate func() (string, error)) (string, error) {
+	secret, err := Get(name)
+	if !errors.Is(err, ErrNotFound) {
+		return secret, err
+	}
+	if secret, err = generate(); err != nil {
+		return "", err
+	}
+	if err := Set(name, secret); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// MockInit replaces the keychain with one held in memory, for tests
+func MockInit() {
+	gokeyring.MockInit()
+}
@@ -0,0 +1,69 @@
+package keyring
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestName(t *testing.T) {
+	if name, ok := Name("keyring:auth-token"); !ok || name != "auth-token" {
+		t.Errorf("Expected the name auth-token, got %q, %v", name, ok)
+	}
+	if _, ok := Name("env:TOKEN"); ok {
+		t.Error("Expected no keyring reference")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	MockInit()
+
+	if _, err := Get("auth-token"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := Set("auth-token", "s3cret"); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+	if secret, err := Get("auth-token"); err != nil || secret != "s3cret" {
+		t.Errorf("Expected the stored secret, got %q, %v", secret, err)
+	}
+	if _, err := Get(""); err == nil {
+		t.Error("Expected an error for an empty name")
+	}
+
+	if err := Delete("auth-token"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := Get("auth-token"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound once deleted, got %v", err)
+	}
+	if err := Delete("auth-token"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestGetOrCreate(t *testing.T) {
+	MockInit()
+
+	calls := 0
+	generate := func() (string, error) {
+		calls++
+		return "generated", nil
+	}
+	for range 2 {
+		secret, err := GetOrCreate("state-key", generate)
+		if err != nil || secret != "generated" {
+			t.Errorf("Expected the generated secret, got %q, %v", secret, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the secret to be generated once, got %d", calls)
+	}
+
+	failing := func() (string, error) { return "", errors.New("no entropy") }
+	if _, err := GetOrCreate("other", failing); err == nil {
+		t.Error("Expected the error of generate")
+	}
+	if _, err := Get("other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected nothing stored when generate failed, got %v", err)
+	}
+}
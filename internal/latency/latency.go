@@ -0,0 +1,187 @@
+// Package latency timestamps streamed lines on the server and measures how
+// long they took to reach the client. A timestamped line is sent as a JSON
+// text sequence record (RFC 7464): the record separator 0x1E followed by a
+// JSON object holding the time the server sent the line, in nanoseconds
+// since the Unix epoch, and the line itself. Lines read from a file never
+// contain the separator in practice, so clients recognise timestamped lines
+// without being told, even when they arrive out of order.
+package latency
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recordSeparator = "\x1e"
+	// maxSamples bounds the latencies kept for the distribution; longer
+	// streams keep a uniform sample of them
+	maxSamples = 100000
+	// firstBound is the upper bound of the histogram's first bucket; each
+	// bucket's bound doubles that of the one before, up to the last bucket
+	// taking all longer latencies
+	firstBound = 100 * time.Microsecond
+	buckets    = 18
+	// barWidth is the width of the fullest bucket's bar
+	barWidth = 40
+)
+
+// envelope is the JSON object of a timestamped line
+type envelope struct {
+	Sent int64  `json:"ts"`
+	Line string `json:"line"`
+}
+
+// Wrap returns the record carrying line and the time it was sent
+func Wrap(line string, sent time.Time) string {
+	data, _ := json.Marshal(envelope{Sent: sent.UnixNano(), Line: line})
+	return recordSeparator + string(data)
+}
+
+// Unwrap returns the line and send time of a timestamped line, reporting
+// false for a plain line
+func Unwrap(msg string) (line string, sent time.Time, ok bool) {
+	if !strings.HasPrefix(msg, recordSeparator) {
+		return "", time.Time{}, false
+	}
+	var e envelope
+	if err := json.Unmarshal([]byte(msg[len(recordSeparator):]), &e); err != nil {
+		return "", time.Time{}, false
+	}
+	return e.Line, time.Unix(0, e.Sent), true
+}
+
+// Recorder collects the latencies of a stream
+type Recorder struct {
+	mu       sync.Mutex
+	count    int
+	sum      time.Duration
+	min, max time.Duration
+	samples  []time.Duration
+	counts   [buckets]int
+}
+
+// Add records the latency of one line
+func (r *Recorder) Add(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	r.sum += d
+	if r.count == 1 || d < r.min {
+		r.min = d
+	}
+	if r.count == 1 || d > r.max {
+		r.max = d
+	}
+
+	// The histogram counts every latency
+	i := 0
+	for bound := firstBound; i < buckets-1 && d > bound; bound *= 2 {
+		i++
+	}
+	r.counts[i]++
+
+	// Reservoir sampling keeps every latency equally likely to be sampled
+	if len(r.samples) < maxSamples {
+		r.samples = append(r.samples, d)
+	} else if i := rand.IntN(r.count); i < maxSamples {
+		r.samples[i] = d
+	}
+}
+
+// Summary describes the distribution of the recorded latencies
+type Summary struct {
+	Count                         int
+	Min, Mean, P50, P90, P99, Max time.Duration
+}
+
+// Summary returns the distribution of the latencies recorded so far
+func (r *Recorder) Summary() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.count == 0 {
+		return Summary{}
+	}
+	sorted := slices.Clone(r.samples)
+	slices.Sort(sorted)
+	quantile := func(q float64) time.Duration {
+		return sorted[int(q*float64(len(sorted)-1)+0.5)]
+	}
+	return Summary{
+		Count: r.count,
+		Min:   r.min,
+		Mean:  r.sum / time.Duration(r.count),
+		P50:   quantile(0.5),
+		P90:   quantile(0.9),
+		P99:   quantile(0.99),
+		Max:   r.max,
+	}
+}
+
+// String formats the summary for the log
+func (s Summary) String() string {
+	round := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+	return fmt.Sprintf("%d lines, min %v, mean %v, p50 %v, p90 %v, p99 %v, max %v",
+		s.Count, round(s.Min), round(s.Mean), round(s.P50), round(s.P90), round(s.P99), round(s.Max))
+}
+
+// Bucket counts the latencies up to its bound and above the bound of the
+// bucket before
+type Bucket struct {
+	// Bound is the longest latency in the bucket, 0 for the last bucket,
+	// which has no bound
+	Bound time.Duration
+	Count int
+}
+
+// Histogram counts latencies in buckets whose bounds double from 100µs
+type Histogram []Bucket
+
+// Histogram returns the histogram of every latency recorded so far, from the
+// first bucket holding any to the last
+func (r *Recorder) Histogram() Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var h Histogram
+	bound := firstBound
+	for i, count := range r.counts {
+		if i == buckets-1 {
+			bound = 0
+		}
+		h = append(h, Bucket{Bound: bound, Count: count})
+		bound *= 2
+	}
+	first := slices.IndexFunc(h, func(b Bucket) bool { return b.Count > 0 })
+	if first < 0 {
+		return nil
+	}
+	last := len(h) - 1
+	for h[last].Count == 0 {
+		last--
+	}
+	return h[first : last+1]
+}
+
+// Lines formats the histogram for the log, one bucket per line with a bar
+// scaled to the fullest one
+func (h Histogram) Lines() []string {
+	most := 0
+	for _, b := range h {
+		most = max(most, b.Count)
+	}
+	lines := make([]string, len(h))
+	for i, b := range h {
+		bound := "> " + (firstBound << (buckets - 2)).String()
+		if b.Bound > 0 {
+			bound = "<= " + b.Bound.String()
+		}
+		bar := strings.Repeat("#", (b.Count*barWidth+most-1)/most)
+		lines[i] = fmt.Sprintf("%-11s %8d %s", bound, b.Count, bar)
+	}
+	return lines
+}
@@ -0,0 +1,99 @@
+package latency
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrap(t *testing.T) {
+	sent := time.Unix(1718000000, 123456789)
+	for _, line := range []string{"plain line", "", `{"ts":1,"line":"json"}`, "tab\tand \"quotes\" and ünïcode"} {
+		msg := Wrap(line, sent)
+		got, at, ok := Unwrap(msg)
+		if !ok || got != line || !at.Equal(sent) {
+			t.Errorf("Expected %q sent at %v, got %q at %v, %v", line, sent, got, at, ok)
+		}
+	}
+
+	for _, msg := range []string{"plain line", `{"ts":1,"line":"json"}`, "\x1enot json", ""} {
+		if _, _, ok := Unwrap(msg); ok {
+			t.Errorf("Expected %q not to be a timestamped line", msg)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	var r Recorder
+	if s := r.Summary(); s.Count != 0 {
+		t.Errorf("Expected an empty summary, got %+v", s)
+	}
+
+	for i := 1; i <= 1000; i++ {
+		r.Add(time.Duration(i) * time.Millisecond)
+	}
+	s := r.Summary()
+	want := Summary{
+		Count: 1000,
+		Min:   time.Millisecond,
+		Mean:  500500 * time.Microsecond,
+		P50:   501 * time.Millisecond,
+		P90:   900 * time.Millisecond,
+		P99:   990 * time.Millisecond,
+		Max:   1000 * time.Millisecond,
+	}
+	if s != want {
+		t.Errorf("Expected %+v, got %+v", want, s)
+	}
+	if !strings.HasPrefix(s.String(), "1000 lines, min 1ms, mean 500.5ms, p50 501ms") {
+		t.Errorf("Unexpected summary %q", s.String())
+	}
+
+	t.Run("Histogram", func(t *testing.T) {
+		var r Recorder
+		if h := r.Histogram(); h != nil {
+			t.Errorf("Expected no buckets, got %v", h)
+		}
+		for _, d := range []time.Duration{150 * time.Microsecond, 200 * time.Microsecond, 300 * time.Microsecond, 900 * time.Microsecond, time.Minute} {
+			r.Add(d)
+		}
+		h := r.Histogram()
+		if len(h) != 17 {
+			t.Fatalf("Expected the buckets from 200µs to the last, got %v", h)
+		}
+		want := []Bucket{{200 * time.Microsecond, 2}, {400 * time.Microsecond, 1}, {800 * time.Microsecond, 0}, {1600 * time.Microsecond, 1}}
+		for i, b := range want {
+			if h[i] != b {
+				t.Errorf("Expected bucket %d to be %v, got %v", i, b, h[i])
+			}
+		}
+		if last := h[len(h)-1]; last.Bound != 0 || last.Count != 1 {
+			t.Errorf("Expected the minute in the unbounded bucket, got %v", last)
+		}
+
+		lines := h.Lines()
+		if lines[0] != "<= 200µs           2 "+strings.Repeat("#", 40) || lines[1] != "<= 400µs           1 "+strings.Repeat("#", 20) {
+			t.Errorf("Unexpected lines %q", lines[:2])
+		}
+		if lines[2] != "<= 800µs           0 " || lines[16] != "> 6.5536s          1 "+strings.Repeat("#", 20) {
+			t.Errorf("Unexpected lines %q", []string{lines[2], lines[16]})
+		}
+	})
+
+	t.Run("Sampled", func(t *testing.T) {
+		var r Recorder
+		for i := range 3 * maxSamples {
+			r.Add(time.Duration(i%100) * time.Millisecond)
+		}
+		s := r.Summary()
+		if s.Count != 3*maxSamples || len(r.samples) != maxSamples {
+			t.Fatalf("Expected %d lines and %d samples, got %d and %d", 3*maxSamples, maxSamples, s.Count, len(r.samples))
+		}
+		if s.P50 < 45*time.Millisecond || s.P50 > 55*time.Millisecond {
+			t.Errorf("Expected the sampled median near 50ms, got %v", s.P50)
+		}
+		if s.Max != 99*time.Millisecond {
+			t.Errorf("Expected the exact maximum, got %v", s.Max)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+// Package lifecycle closes the server's peer connections once nothing uses
+// them any more, so their ICE agents, transports and goroutines do not pile
+// up over the server's lifetime. A connection is closed once its file stream
+// ended and the other channels the client opened on it, like requests,
+// uploads and forwards, closed as well; when it failed, since the server
+// never restarts ICE; and when it is still new or connecting after the idle
+// timeout, for clients that went away in the middle of signaling.
+package lifecycle
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// pollInterval is how often a connection whose file stream ended checks
+// whether its other channels closed
+const pollInterval = time.Second
+
+// Peer is the peer connection a Connection closes
+type Peer interface {
+	ConnectionState() webrtc.PeerConnectionState
+	GetStats() webrtc.StatsReport
+	Close() error
+}
+
+// Options configures when a connection is closed
+type Options struct {
+	// IdleTimeout is how long a connection may stay new or connecting
+	// before it is closed (0 to wait forever)
+	IdleTimeout time.Duration
+	// Ignore are the protocols of channels that do not keep a connection
+	// open once its file stream ended, like heartbeats and logs
+	Ignore []string
+	// OnClose is called with the reason before the connection is closed
+	OnClose func(reason string)
+}
+
+// Connection closes one peer connection once it is no longer used
+type Connection struct {
+	peer Peer
+	opts Options
+
+	mu     sync.Mutex
+	timer  *time.Timer
+	closed bool
+	done   chan struct{}
+}
+
+// Watch starts watching peer, whose connection state changes must be
+// reported with State
+func Watch(peer Peer, opts Options) *Connection {
+	c := &Connection{peer: peer, opts: opts, done: make(chan struct{})}
+	if opts.IdleTimeout > 0 {
+		c.mu.Lock()
+		c.timer = time.AfterFunc(opts.IdleTimeout, c.idle)
+		c.mu.Unlock()
+	}
+	return c
+}
+
+// State reports a change of the connection state
+func (c *Connection) State(state webrtc.PeerConnectionState) {
+	switch state {
+	case webrtc.PeerConnectionStateConnected:
+		c.stopTimer()
+	case webrtc.PeerConnectionStateFailed:
+		go c.close("the connection failed")
+	case webrtc.PeerConnectionStateClosed:
+		c.stop()
+	}
+}
+
+// Finished reports that the file stream ended. The connection is closed
+// once the client's other channels closed too. A client may open its next
+// channel right after the last one closed, like the next file of a batch of
+// requests, so the connection is only closed once a whole poll interval
+// passed with no channel open and none opened.
+func (c *Connection) Finished() {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		quiet := -1
+		for {
+			open, seen := c.channels()
+			if open == 0 && seen == quiet {
+				c.close("the stream ended")
+				return
+			}
+			quiet = -1
+			if open == 0 {
+				quiet = seen
+			}
+			select {
+			case <-ticker.C:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// idle closes the connection if it is not connected by the idle timeout
+func (c *Connection) idle() {
+	switch c.peer.ConnectionState() {
+	case webrtc.PeerConnectionStateNew, webrtc.PeerConnectionStateConnecting:
+		c.close(fmt.Sprintf("it did not connect within %s", c.opts.IdleTimeout))
+	}
+}
+
+// channels returns the number of channels still open or opening and of all
+// channels the connection had, besides the ignored ones. Closed channels
+// stay in the connection's stats, so the second number only grows.
+func (c *Connection) channels() (open, seen int) {
+	for _, s := range c.peer.GetStats() {
+		stats, ok := s.(webrtc.DataChannelStats)
+		if !ok || c.ignored(stats.Protocol) {
+			continue
+		}
+		seen++
+		if stats.State == webrtc.DataChannelStateOpen || stats.State == webrtc.DataChannelStateConnecting {
+			open++
+		}
+	}
+	return open, seen
+}
+
+// ignored reports whether channels of protocol do not keep the connection
+// open
+func (c *Connection) ignored(protocol string) bool {
+	for _, p := range c.opts.Ignore {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// close closes the connection for reason, once
+func (c *Connection) close(reason string) {
+	if !c.stop() {
+		return
+	}
+	if c.opts.OnClose != nil {
+		c.opts.OnClose(reason)
+	}
+	c.peer.Close()
+}
+
+// stop stops watching the connection and reports whether it was still
+// watched
+func (c *Connection) stop() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	close(c.done)
+	return true
+}
+
+// stopTimer stops the idle timeout once the connection is connected
+func (c *Connection) stopTimer() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
@@ -0,0 +1,183 @@
+package lifecycle
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// fakePeer is a peer connection with settable state and channels
+type fakePeer struct {
+	mu       sync.Mutex
+	state    webrtc.PeerConnectionState
+	channels map[string]webrtc.DataChannelStats
+	closes   int
+	closed   chan struct{}
+}
+
+func newFakePeer() *fakePeer {
+	return &fakePeer{state: webrtc.PeerConnectionStateNew, channels: make(map[string]webrtc.DataChannelStats), closed: make(chan struct{})}
+}
+
+func (p *fakePeer) ConnectionState() webrtc.PeerConnectionState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+func (p *fakePeer) GetStats() webrtc.StatsReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	report := webrtc.StatsReport{}
+	for id, s := range p.channels {
+		report[id] = s
+	}
+	return report
+}
+
+func (p *fakePeer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closes++
+	if p.closes == 1 {
+		close(p.closed)
+	}
+	return nil
+}
+
+func (p *fakePeer) setState(state webrtc.PeerConnectionState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = state
+}
+
+func (p *fakePeer) setChannel(id, protocol string, state webrtc.DataChannelState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.channels[id] = webrtc.DataChannelStats{ID: id, Type: webrtc.StatsTypeDataChannel, Protocol: protocol, State: state}
+}
+
+// waitClosed fails the test unless peer is closed within timeout
+func waitClosed(t *testing.T, peer *fakePeer, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-peer.closed:
+	case <-time.After(timeout):
+		t.Fatal("Expected the connection to be closed")
+	}
+}
+
+// notClosed fails the test if peer is closed within wait
+func notClosed(t *testing.T, peer *fakePeer, wait time.Duration) {
+	t.Helper()
+	select {
+	case <-peer.closed:
+		t.Fatal("Expected the connection to stay open")
+	case <-time.After(wait):
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	peer := newFakePeer()
+	peer.setState(webrtc.PeerConnectionStateConnecting)
+	reasons := make(chan string, 1)
+	Watch(peer, Options{IdleTimeout: 50 * time.Millisecond, OnClose: func(reason string) { reasons <- reason }})
+
+	waitClosed(t, peer, time.Second)
+	if reason := <-reasons; reason != "it did not connect within 50ms" {
+		t.Errorf("Unexpected reason %q", reason)
+	}
+}
+
+func TestIdleTimeoutConnected(t *testing.T) {
+	peer := newFakePeer()
+	c := Watch(peer, Options{IdleTimeout: 50 * time.Millisecond})
+	peer.setState(webrtc.PeerConnectionStateConnected)
+	c.State(webrtc.PeerConnectionStateConnected)
+	notClosed(t, peer, 150*time.Millisecond)
+
+	// Without an idle timeout a connection may take forever
+	peer = newFakePeer()
+	Watch(peer, Options{})
+	notClosed(t, peer, 50*time.Millisecond)
+}
+
+func TestFailed(t *testing.T) {
+	peer := newFakePeer()
+	var reason string
+	c := Watch(peer, Options{OnClose: func(r string) { reason = r }})
+	c.State(webrtc.PeerConnectionStateFailed)
+	waitClosed(t, peer, time.Second)
+	if reason != "the connection failed" {
+		t.Errorf("Unexpected reason %q", reason)
+	}
+}
+
+func TestFinished(t *testing.T) {
+	peer := newFakePeer()
+	peer.setChannel("file", "", webrtc.DataChannelStateClosed)
+	peer.setChannel("heartbeat", "heartbeat", webrtc.DataChannelStateOpen)
+	var reason string
+	c := Watch(peer, Options{Ignore: []string{"heartbeat"}, OnClose: func(r string) { reason = r }})
+	c.State(webrtc.PeerConnectionStateConnected)
+	c.Finished()
+	waitClosed(t, peer, 3*pollInterval)
+	if reason != "the stream ended" {
+		t.Errorf("Unexpected reason %q", reason)
+	}
+}
+
+func TestFinishedBusy(t *testing.T) {
+	peer := newFakePeer()
+	peer.setChannel("file", "", webrtc.DataChannelStateClosed)
+	peer.setChannel("request", "request", webrtc.DataChannelStateOpen)
+	peer.setChannel("upload", "upload", webrtc.DataChannelStateConnecting)
+	c := Watch(peer, Options{})
+	c.Finished()
+	notClosed(t, peer, 100*time.Millisecond)
+
+	// The connection is closed once the other channels closed as well
+	peer.setChannel("request", "request", webrtc.DataChannelStateClosed)
+	peer.setChannel("upload", "upload", webrtc.DataChannelStateClosed)
+	waitClosed(t, peer, 3*pollInterval)
+}
+
+func TestFinishedNextChannel(t *testing.T) {
+	peer := newFakePeer()
+	peer.setChannel("file", "", webrtc.DataChannelStateClosed)
+	peer.setChannel("request-1", "request", webrtc.DataChannelStateClosed)
+	c := Watch(peer, Options{})
+	c.Finished()
+
+	// The next request opens and closes between two polls, which find no
+	// channel open
+	time.Sleep(pollInterval / 4)
+	peer.setChannel("request-2", "request", webrtc.DataChannelStateOpen)
+	time.Sleep(pollInterval / 4)
+	peer.setChannel("request-2", "request", webrtc.DataChannelStateClosed)
+	notClosed(t, peer, pollInterval)
+
+	// Once a poll interval passes without a new channel, it is closed
+	waitClosed(t, peer, 2*pollInterval)
+}
+
+func TestClosed(t *testing.T) {
+	peer := newFakePeer()
+	peer.setState(webrtc.PeerConnectionStateConnecting)
+	peer.setChannel("request", "request", webrtc.DataChannelStateOpen)
+	c := Watch(peer, Options{IdleTimeout: 50 * time.Millisecond})
+	c.Finished()
+
+	// A connection closed elsewhere is no longer watched
+	c.State(webrtc.PeerConnectionStateClosed)
+	c.State(webrtc.PeerConnectionStateFailed)
+	notClosed(t, peer, 150*time.Millisecond)
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.closes != 0 {
+		t.Errorf("Expected the connection not to be closed again, got %d closes", peer.closes)
+	}
+}
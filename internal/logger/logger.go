@@ -2,9 +2,12 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/metrics"
 )
 
 var (
@@ -20,12 +23,23 @@ func Init() {
 	debugLogger = log.New(os.Stdout, "[DEBUG] ", log.Ldate|log.Ltime)
 }
 
+// SetOutput sends every message to w, until Init restores the standard
+// outputs
+func SetOutput(w io.Writer) {
+	if infoLogger == nil {
+		Init()
+	}
+	infoLogger.SetOutput(w)
+	errorLogger.SetOutput(w)
+	debugLogger.SetOutput(w)
+}
+
 // Info logs an info message
 func Info(format string, v ...interface{}) {
 	if infoLogger == nil {
 		Init()
 	}
-	infoLogger.Output(2, fmt.Sprintf(format, v...))
+	output(infoLogger, fmt.Sprintf(format, v...))
 }
 
 // Error logs an error message
@@ -33,7 +47,7 @@ func Error(format string, v ...interface{}) {
 	if errorLogger == nil {
 		Init()
 	}
-	errorLogger.Output(2, fmt.Sprintf(format, v...))
+	output(errorLogger, fmt.Sprintf(format, v...))
 }
 
 // Debug logs a debug message
@@ -41,7 +55,14 @@ func Debug(format string, v ...interface{}) {
 	if debugLogger == nil {
 		Init()
 	}
-	debugLogger.Output(2, fmt.Sprintf(format, v...))
+	output(debugLogger, fmt.Sprintf(format, v...))
+}
+
+// output writes a message to l, counting it as dropped if the write fails
+func output(l *log.Logger, msg string) {
+	if err := l.Output(3, msg); err != nil {
+		metrics.LogDroppedMessages.Inc()
+	}
 }
 
 // Timer returns a function that logs the time elapsed since start
@@ -50,4 +71,4 @@ func Timer(name string) func() {
 	return func() {
 		Info("%s took %v", name, time.Since(start))
 	}
-}
\ No newline at end of file
+}
@@ -2,10 +2,13 @@ package logger
 
 import (
 	"bytes"
+	"log"
 	"os"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/metrics"
 )
 
 func TestInit(t *testing.T) {
@@ -150,3 +153,40 @@ func TestTimer(t *testing.T) {
 		t.Errorf("Expected output to contain 'test operation took', got %s", output)
 	}
 }
+
+// failingWriter is an io.Writer that always fails
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, os.ErrClosed
+}
+
+func TestDroppedMessages(t *testing.T) {
+	Init()
+	infoLogger = log.New(failingWriter{}, "[INFO] ", 0)
+	defer Init()
+
+	before := metrics.LogDroppedMessages.Value()
+	Info("this message cannot be written")
+
+	if got := metrics.LogDroppedMessages.Value() - before; got != 1 {
+		t.Errorf("Expected 1 dropped message, got %d", got)
+	}
+}
+
+func TestSetOutput(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer Init()
+
+	Info("info message")
+	Error("error message")
+	Debug("debug message")
+
+	output := buf.String()
+	for _, want := range []string{"[INFO] ", "info message", "[ERROR] ", "error message", "[DEBUG] ", "debug message"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got %s", want, output)
+		}
+	}
+}
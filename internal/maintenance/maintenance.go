@@ -0,0 +1,149 @@
+// Package maintenance holds the server's maintenance mode. While it is on,
+// transfers in progress continue but new connections are turned away with a
+// 503 Service Unavailable that tells clients why and when to retry, and the
+// server reports itself as not ready.
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+)
+
+// DefaultRetryAfter is the retry delay suggested when none is given
+const DefaultRetryAfter = 5 * time.Minute
+
+// Status is the maintenance state as the control API reports and sets it
+type Status struct {
+	Enabled bool `json:"enabled"`
+	// RetryAfter is the number of seconds clients are asked to wait
+	RetryAfter int       `json:"retry_after,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Since      time.Time `json:"since,omitzero"`
+}
+
+// Unavailable is the body of the 503 response to a request turned away, and
+// of the 429 response to a client over its connection budget
+type Unavailable struct {
+	Error      string `json:"error"`
+	Reason     string `json:"reason,omitempty"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+// Mode is the maintenance mode of one server
+type Mode struct {
+	mu     sync.Mutex
+	status Status
+}
+
+// Status returns the current state
+func (m *Mode) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// Set switches maintenance mode on or off. A zero RetryAfter falls back to
+// DefaultRetryAfter, and Since is set when the mode is switched on.
+func (m *Mode) Set(s Status) Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !s.Enabled {
+		m.status = Status{}
+		metrics.MaintenanceMode.Set(0)
+		return m.status
+	}
+	if s.RetryAfter <= 0 {
+		s.RetryAfter = int(DefaultRetryAfter / time.Second)
+	}
+	s.Since = m.status.Since
+	if !m.status.Enabled {
+		s.Since = time.Now().UTC()
+	}
+	m.status = s
+	metrics.MaintenanceMode.Set(1)
+	return m.status
+}
+
+// Reject answers a request with 503 Service Unavailable while maintenance
+// mode is on, reporting whether it did
+func (m *Mode) Reject(w http.ResponseWriter) bool {
+	s := m.Status()
+	if !s.Enabled {
+		return false
+	}
+	body, _ := json.Marshal(Unavailable{Error: "server in maintenance", Reason: s.Reason, RetryAfter: s.RetryAfter})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(s.RetryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(body)
+	return true
+}
+
+// ServeHTTP serves the control API: GET returns the state and PUT sets it
+// from a JSON Status
+func (m *Mode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var s Status
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, "Failed to parse maintenance state: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.Set(s)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Status())
+}
+
+// ErrUnavailable matches the errors of requests a server in maintenance
+// turned away
+var ErrUnavailable = errors.New("server in maintenance")
+
+// Error is a request a server in maintenance turned away
+type Error struct {
+	// Message is the error the server sent
+	Message string
+	// Reason is why the server is in maintenance, if it said
+	Reason string
+	// RetryAfter is how long the server asked clients to wait
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s, retry after %v", e.Message, e.RetryAfter)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// Is makes the error match ErrUnavailable
+func (e *Error) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// CheckResponse turns a 503 from a server in maintenance, or a 429 from one
+// the client asked too many sessions of, into an *Error that says when to
+// retry, and returns nil for any other response
+func CheckResponse(resp *http.Response, body []byte) error {
+	if resp.StatusCode != http.StatusServiceUnavailable && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	var u Unavailable
+	if err := json.Unmarshal(body, &u); err != nil || u.Error == "" {
+		return nil
+	}
+	return &Error{Message: u.Error, Reason: u.Reason, RetryAfter: time.Duration(u.RetryAfter) * time.Second}
+}
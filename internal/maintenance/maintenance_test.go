@@ -0,0 +1,110 @@
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+)
+
+func TestSet(t *testing.T) {
+	var m Mode
+	s := m.Set(Status{Enabled: true, Reason: "upgrade"})
+	if !s.Enabled || s.RetryAfter != 300 || s.Since.IsZero() {
+		t.Errorf("Expected maintenance with the default retry delay since now, got %+v", s)
+	}
+	if metrics.MaintenanceMode.Value() != 1 {
+		t.Errorf("Expected the gauge to be 1, got %d", metrics.MaintenanceMode.Value())
+	}
+
+	// Changing the retry delay keeps the start time
+	if again := m.Set(Status{Enabled: true, RetryAfter: 60}); !again.Since.Equal(s.Since) || again.RetryAfter != 60 {
+		t.Errorf("Expected the retry delay to change since %v, got %+v", s.Since, again)
+	}
+
+	if s := m.Set(Status{}); s.Enabled || !s.Since.IsZero() {
+		t.Errorf("Expected maintenance to be off, got %+v", s)
+	}
+	if metrics.MaintenanceMode.Value() != 0 {
+		t.Errorf("Expected the gauge to be 0, got %d", metrics.MaintenanceMode.Value())
+	}
+}
+
+func TestReject(t *testing.T) {
+	var m Mode
+	rec := httptest.NewRecorder()
+	if m.Reject(rec) {
+		t.Error("Expected no rejection outside maintenance")
+	}
+
+	m.Set(Status{Enabled: true, RetryAfter: 120, Reason: "database migration"})
+	rec = httptest.NewRecorder()
+	if !m.Reject(rec) {
+		t.Fatal("Expected a rejection in maintenance")
+	}
+	resp := rec.Result()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "120" {
+		t.Errorf("Expected Retry-After 120, got %q", got)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	err := CheckResponse(resp, body)
+	if err == nil || err.Error() != "server in maintenance, retry after 2m0s: database migration" {
+		t.Errorf("Expected a maintenance error, got %v", err)
+	}
+	var unavailable *Error
+	if !errors.Is(err, ErrUnavailable) || !errors.As(err, &unavailable) || unavailable.RetryAfter != 2*time.Minute {
+		t.Errorf("Expected the error to match ErrUnavailable and carry the retry delay, got %#v", err)
+	}
+	if err := CheckResponse(&http.Response{StatusCode: http.StatusServiceUnavailable}, []byte("busy")); err != nil {
+		t.Errorf("Expected other 503 responses to be left alone, got %v", err)
+	}
+	tooMany := []byte(`{"error":"client has reached its connection budget","reason":"4 of 4 sessions of address 192.0.2.1 active","retry_after":30}`)
+	if err := CheckResponse(&http.Response{StatusCode: http.StatusTooManyRequests}, tooMany); !errors.As(err, &unavailable) || unavailable.RetryAfter != 30*time.Second {
+		t.Errorf("Expected a 429 with a retry delay to be an *Error, got %v", err)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	var m Mode
+	server := httptest.NewServer(&m)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL, strings.NewReader(`{"enabled":true,"retry_after":30}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT returned error: %v", err)
+	}
+	resp.Body.Close()
+	if !m.Status().Enabled {
+		t.Error("Expected PUT to switch maintenance mode on")
+	}
+
+	resp, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET returned error: %v", err)
+	}
+	defer resp.Body.Close()
+	var s Status
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if !s.Enabled || s.RetryAfter != 30 {
+		t.Errorf("Expected maintenance with a 30 second retry delay, got %+v", s)
+	}
+
+	req, _ = http.NewRequest(http.MethodPut, server.URL, strings.NewReader(`{`))
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid body, got %v", err)
+	}
+	m.Set(Status{})
+}
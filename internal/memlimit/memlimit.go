@@ -0,0 +1,270 @@
+// Package memlimit keeps the server under a memory limit by shedding load.
+// It tracks the memory of the process and the send buffers of every session
+// streaming a file. Over the limit it pauses transfers one at a time, lowest
+// priority and largest buffer first, so their queued messages drain, and
+// turns new offers away with a structured reason. Once usage falls clearly
+// below the limit the paused transfers resume, highest priority first.
+package memlimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/maintenance"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+)
+
+const (
+	// resumeRatio is the share of the limit usage must fall below before
+	// paused transfers resume, so they do not flap around the limit
+	resumeRatio = 0.9
+	// retryAfter is the delay suggested to clients turned away
+	retryAfter = 30 * time.Second
+	// pollInterval bounds how long a paused transfer takes to notice that
+	// its channel closed
+	pollInterval = 250 * time.Millisecond
+)
+
+var (
+	// usageGauge reports the memory the process holds
+	usageGauge = metrics.NewGauge("webrtc_poc_memory_usage_bytes",
+		"Memory the process holds from the operating system, as compared against --memory-limit")
+	// bufferedGauge reports the bytes queued on the tracked sessions
+	bufferedGauge = metrics.NewGauge("webrtc_poc_session_buffered_bytes",
+		"Bytes queued for sending on all sessions streaming a file")
+	// pausedGauge reports the transfers paused to shed load
+	pausedGauge = metrics.NewGauge("webrtc_poc_transfers_paused",
+		"Transfers paused because the server is over --memory-limit")
+	// rejectedCounter counts the offers turned away over the limit
+	rejectedCounter = metrics.NewCounter("webrtc_poc_offers_shed_total",
+		"Offers turned away because the server is over --memory-limit")
+)
+
+// Priority orders transfers for shedding, lower ones are paused first
+type Priority int
+
+// Transfer priorities
+const (
+	// Low is the priority of files clients request over a connection
+	Low Priority = -1
+	// Normal is the priority of the file stream and pushes
+	Normal Priority = 0
+)
+
+// Usage returns the memory the process holds from the operating system:
+// everything the runtime obtained minus heap memory it returned
+func Usage() int64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.Sys - m.HeapReleased)
+}
+
+// Governor enforces a memory limit over the sessions it tracks
+type Governor struct {
+	limit int64
+	usage func() int64
+
+	mu        sync.Mutex
+	sessions  map[*Session]struct{}
+	seq       int
+	over      bool
+	lastUsage int64
+}
+
+// New creates a governor for limit bytes, which does nothing if limit is 0
+func New(limit int64) *Governor {
+	return &Governor{limit: limit, usage: Usage, sessions: make(map[*Session]struct{})}
+}
+
+// Session is a transfer the governor may pause
+type Session struct {
+	g        *Governor
+	name     string
+	priority Priority
+	buffered func() int64
+	seq      int
+
+	paused bool
+	resume chan struct{}
+}
+
+// Track starts tracking a transfer. buffered returns the bytes queued for
+// sending on its data channel.
+func (g *Governor) Track(name string, priority Priority, buffered func() int64) *Session {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.seq++
+	s := &Session{g: g, name: name, priority: priority, buffered: buffered, seq: g.seq}
+	g.sessions[s] = struct{}{}
+	return s
+}
+
+// Release stops tracking the session
+func (s *Session) Release() {
+	g := s.g
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.sessions, s)
+	if s.paused {
+		s.paused = false
+		close(s.resume)
+	}
+	g.reportPaused()
+}
+
+// Wait blocks while the session is paused, or until open reports that its
+// channel closed
+func (s *Session) Wait(open func() bool) {
+	for {
+		s.g.mu.Lock()
+		paused, resume := s.paused, s.resume
+		s.g.mu.Unlock()
+		if !paused || !open() {
+			return
+		}
+		select {
+		case <-resume:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Overloaded reports whether the last check found the process over the limit
+func (g *Governor) Overloaded() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.over
+}
+
+// Check compares the memory usage against the limit and pauses or resumes
+// one transfer accordingly
+func (g *Governor) Check() {
+	if g.limit <= 0 {
+		return
+	}
+	usage := g.usage()
+	usageGauge.Set(usage)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lastUsage = usage
+
+	var buffered int64
+	for s := range g.sessions {
+		buffered += s.buffered()
+	}
+	bufferedGauge.Set(buffered)
+
+	switch {
+	case usage > g.limit:
+		if !g.over {
+			logger.Info("Warning: using %d bytes of memory, over the %d byte limit, shedding load", usage, g.limit)
+		}
+		g.over = true
+		if s := g.victim(); s != nil {
+			s.paused, s.resume = true, make(chan struct{})
+			logger.Info("Pausing %s until memory usage falls below the limit", s.name)
+		}
+	case float64(usage) < resumeRatio*float64(g.limit):
+		if g.over {
+			logger.Info("Memory usage fell to %d bytes, accepting new offers again", usage)
+		}
+		g.over = false
+		if s := g.next(); s != nil {
+			s.paused = false
+			close(s.resume)
+			logger.Info("Resuming %s", s.name)
+		}
+	}
+	g.reportPaused()
+}
+
+// victim returns the running session to pause: the lowest priority one,
+// then the one with the most bytes queued, then the newest
+func (g *Governor) victim() *Session {
+	var best *Session
+	var bestBuffered int64
+	for s := range g.sessions {
+		if s.paused {
+			continue
+		}
+		b := s.buffered()
+		if best == nil || s.priority < best.priority ||
+			(s.priority == best.priority && (b > bestBuffered || (b == bestBuffered && s.seq > best.seq))) {
+			best, bestBuffered = s, b
+		}
+	}
+	return best
+}
+
+// next returns the paused session to resume: the highest priority one, then
+// the oldest
+func (g *Governor) next() *Session {
+	var best *Session
+	for s := range g.sessions {
+		if !s.paused {
+			continue
+		}
+		if best == nil || s.priority > best.priority || (s.priority == best.priority && s.seq < best.seq) {
+			best = s
+		}
+	}
+	return best
+}
+
+// reportPaused updates the paused gauge, with g.mu held
+func (g *Governor) reportPaused() {
+	paused := 0
+	for s := range g.sessions {
+		if s.paused {
+			paused++
+		}
+	}
+	pausedGauge.Set(int64(paused))
+}
+
+// Run checks the memory usage every interval until stop is closed
+func (g *Governor) Run(stop <-chan struct{}, interval time.Duration) {
+	if g.limit <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		g.Check()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reject answers a request with 503 Service Unavailable and a structured
+// reason if the server is over its memory limit, and reports whether it did
+func (g *Governor) Reject(w http.ResponseWriter) bool {
+	g.mu.Lock()
+	over, usage := g.over, g.lastUsage
+	g.mu.Unlock()
+	if !over {
+		return false
+	}
+	rejectedCounter.Inc()
+	seconds := int(retryAfter / time.Second)
+	body, _ := json.Marshal(maintenance.Unavailable{
+		Error:      "server over its memory limit",
+		Reason:     fmt.Sprintf("using %d of %d bytes", usage, g.limit),
+		RetryAfter: seconds,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(body)
+	return true
+}
@@ -0,0 +1,144 @@
+package memlimit
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/maintenance"
+)
+
+// newTestGovernor returns a governor whose usage is read from *usage
+func newTestGovernor(limit int64, usage *int64) *Governor {
+	g := New(limit)
+	g.usage = func() int64 { return *usage }
+	return g
+}
+
+func isPaused(s *Session) bool {
+	s.g.mu.Lock()
+	defer s.g.mu.Unlock()
+	return s.paused
+}
+
+func TestShedding(t *testing.T) {
+	usage := int64(500)
+	g := newTestGovernor(1000, &usage)
+	stream := g.Track("stream", Normal, func() int64 { return 100 })
+	small := g.Track("small request", Low, func() int64 { return 10 })
+	large := g.Track("large request", Low, func() int64 { return 5000 })
+	defer stream.Release()
+	defer small.Release()
+
+	g.Check()
+	if g.Overloaded() || isPaused(stream) || isPaused(small) || isPaused(large) {
+		t.Fatal("Expected nothing to be shed under the limit")
+	}
+
+	// Over the limit, the low priority transfer with the larger buffer is
+	// paused first, then the other one, and the stream last
+	usage = 2000
+	g.Check()
+	if !g.Overloaded() || !isPaused(large) || isPaused(small) {
+		t.Fatalf("Expected the large request to be paused first")
+	}
+	g.Check()
+	if !isPaused(small) || isPaused(stream) {
+		t.Fatalf("Expected the small request to be paused before the stream")
+	}
+	if got := pausedGauge.Value(); got != 2 {
+		t.Errorf("Expected 2 paused transfers, got %d", got)
+	}
+
+	// Just under the limit nothing resumes yet
+	usage = 950
+	g.Check()
+	if !isPaused(small) || !isPaused(large) {
+		t.Fatal("Expected transfers to stay paused until usage falls clearly below the limit")
+	}
+
+	// A released transfer stops waiting
+	done := make(chan struct{})
+	go func() {
+		large.Wait(func() bool { return true })
+		close(done)
+	}()
+	large.Release()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Wait to return once the session was released")
+	}
+
+	usage = 100
+	g.Check()
+	if g.Overloaded() || isPaused(small) {
+		t.Error("Expected the remaining transfer to resume below the limit")
+	}
+}
+
+func TestWait(t *testing.T) {
+	usage := int64(2000)
+	g := newTestGovernor(1000, &usage)
+	s := g.Track("transfer", Normal, func() int64 { return 0 })
+	defer s.Release()
+	g.Check()
+
+	// A transfer whose channel closed stops waiting
+	start := time.Now()
+	s.Wait(func() bool { return false })
+	if time.Since(start) > time.Second {
+		t.Error("Expected Wait to return right away for a closed channel")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Wait(func() bool { return true })
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected Wait to block while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	usage = 0
+	g.Check()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Wait to return once resumed")
+	}
+}
+
+func TestReject(t *testing.T) {
+	usage := int64(2000)
+	g := newTestGovernor(1000, &usage)
+
+	w := httptest.NewRecorder()
+	if g.Reject(w) {
+		t.Fatal("Expected offers to be accepted before the first check")
+	}
+	g.Check()
+	if !g.Reject(w) {
+		t.Fatal("Expected offers to be rejected over the limit")
+	}
+
+	resp := w.Result()
+	body, _ := io.ReadAll(resp.Body)
+	err := maintenance.CheckResponse(resp, body)
+	var unavailable *maintenance.Error
+	if !errors.As(err, &unavailable) || unavailable.RetryAfter != retryAfter || unavailable.Reason != "using 2000 of 1000 bytes" {
+		t.Errorf("Expected a structured reason clients understand, got %v", err)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	usage := int64(1 << 40)
+	g := newTestGovernor(0, &usage)
+	g.Check()
+	if g.Overloaded() || g.Reject(httptest.NewRecorder()) {
+		t.Error("Expected a governor without a limit to never shed load")
+	}
+}
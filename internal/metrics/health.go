@@ -0,0 +1,38 @@
+package metrics
+
+// Internal health gauges shared between the server, the client and the logger.
+// They make saturation visible before it turns into data loss.
+var (
+	// ClientPendingLines is the number of lines received by the client but not yet written to the output
+	ClientPendingLines = NewGauge("webrtc_poc_client_pending_lines",
+		"Lines received by the client that have not been written to the output yet")
+
+	// DataChannelBufferedAmount is the number of bytes queued on each data channel
+	DataChannelBufferedAmount = NewGaugeVec("webrtc_poc_datachannel_buffered_amount_bytes",
+		"Bytes queued for sending on a data channel", "channel")
+
+	// LogDroppedMessages is the number of log messages that could not be written
+	LogDroppedMessages = NewCounter("webrtc_poc_log_dropped_messages_total",
+		"Log messages that could not be written to their destination")
+
+	// ChunkRetransmits is the number of chunks streamed again because the client found them corrupted
+	ChunkRetransmits = NewCounter("webrtc_poc_chunk_retransmits_total",
+		"Chunks of deduplicated requests streamed again after failing their CRC")
+
+	// UploadsRejected is the number of uploads a validator rejected
+	UploadsRejected = NewCounter("webrtc_poc_uploads_rejected_total",
+		"Uploads rejected by the size limit, content type check or scanner")
+
+	// ClientDuplicateShards is the number of FEC shards the client dropped because they arrived before
+	ClientDuplicateShards = NewCounter("webrtc_poc_client_duplicate_shards_total",
+		"FEC shards dropped by the client because the same shard arrived before")
+
+	// ClientLineLatency is the time timestamped lines took from the server to the client
+	ClientLineLatency = NewHistogram("webrtc_poc_client_line_latency_seconds",
+		"Time from the server sending a timestamped line to the client receiving it",
+		[]float64{0.0001, 0.00025, 0.0005, 0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+
+	// MaintenanceMode is 1 while the server turns new connections away for maintenance
+	MaintenanceMode = NewGauge("webrtc_poc_maintenance_mode",
+		"Whether the server is in maintenance mode and turns new connections away")
+)
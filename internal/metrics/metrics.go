@@ -0,0 +1,293 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is implemented by every value that can be exposed through the registry
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	mu       sync.Mutex
+	registry = map[string]metric{}
+)
+
+// maxLabelValues bounds the label values of a labelled metric. Some come
+// from clients, like channel labels, so once a metric has this many the
+// values after them are counted under overflowLabel instead of growing the
+// exposition without bound.
+const maxLabelValues = 1000
+
+// overflowLabel is the label value of what is counted once a labelled metric
+// has maxLabelValues values
+const overflowLabel = "other"
+
+// labelEscaper escapes a label value for the text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeLabelled writes the samples of a labelled metric, sorted by label value
+func writeLabelled(w io.Writer, name, label string, values map[string]int64) {
+	labels := make([]string, 0, len(values))
+	for l := range values {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, labelEscaper.Replace(l), values[l])
+	}
+}
+
+// bounded returns labelValue, or overflowLabel if values has no room left
+// for it
+func bounded(values map[string]int64, labelValue string) string {
+	if _, ok := values[labelValue]; ok || len(values) < maxLabelValues {
+		return labelValue
+	}
+	return overflowLabel
+}
+
+// register adds a metric to the default registry, replacing any metric with the same name
+func register(name string, m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[name] = m
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+// NewCounter creates a counter and registers it in the default registry
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	register(name, c)
+	return c
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increments the counter by n
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.Value())
+}
+
+// CounterVec is a set of counters partitioned by a single label
+type CounterVec struct {
+	name   string
+	help   string
+	label  string
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+// NewCounterVec creates a labelled counter and registers it in the default registry
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{name: name, help: help, label: label, values: map[string]int64{}}
+	register(name, c)
+	return c
+}
+
+// Inc increments the counter for the given label value by one
+func (c *CounterVec) Inc(labelValue string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[bounded(c.values, labelValue)]++
+}
+
+// Value returns the counter for the given label value
+func (c *CounterVec) Value(labelValue string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[labelValue]
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	writeLabelled(w, c.name, c.label, c.values)
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+// NewGauge creates a gauge and registers it in the default registry
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	register(name, g)
+	return g
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v int64) {
+	g.value.Store(v)
+}
+
+// Inc increments the gauge by one
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+// Dec decrements the gauge by one
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+func (g *Gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, g.Value())
+}
+
+// GaugeVec is a set of gauges partitioned by a single label
+type GaugeVec struct {
+	name   string
+	help   string
+	label  string
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+// NewGaugeVec creates a labelled gauge and registers it in the default registry
+func NewGaugeVec(name, help, label string) *GaugeVec {
+	g := &GaugeVec{name: name, help: help, label: label, values: map[string]int64{}}
+	register(name, g)
+	return g
+}
+
+// Set sets the gauge for the given label value
+func (g *GaugeVec) Set(labelValue string, v int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[bounded(g.values, labelValue)] = v
+}
+
+// Delete removes the gauge for the given label value
+func (g *GaugeVec) Delete(labelValue string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.values, labelValue)
+}
+
+// Value returns the gauge for the given label value
+func (g *GaugeVec) Value(labelValue string) int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[labelValue]
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	writeLabelled(w, g.name, g.label, g.values)
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	name    string
+	help    string
+	mu      sync.Mutex
+	buckets []float64
+	counts  []int64
+	count   int64
+	sum     float64
+}
+
+// NewHistogram creates a histogram with the given ascending bucket upper
+// bounds and registers it in the default registry
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets, counts: make([]int64, len(buckets))}
+	register(name, h)
+	return h
+}
+
+// Observe adds an observation
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", h.name, bound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", h.name, h.count, h.name, h.sum, h.name, h.count)
+}
+
+// WriteTo writes all registered metrics in the Prometheus text exposition format
+func WriteTo(w io.Writer) {
+	mu.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	metrics := make([]metric, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, registry[name])
+	}
+	mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler returns an HTTP handler that serves all registered metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteTo(w)
+	})
+}
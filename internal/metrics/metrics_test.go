@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_counter_total", "A test counter")
+	c.Inc()
+	c.Add(4)
+
+	if c.Value() != 5 {
+		t.Errorf("Expected counter value 5, got %d", c.Value())
+	}
+}
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("test_counter_vec_total", "A test counter vector", "kind")
+	c.Inc("a")
+	c.Inc("a")
+	c.Inc("b")
+
+	if c.Value("a") != 2 || c.Value("b") != 1 || c.Value("c") != 0 {
+		t.Errorf("Unexpected counter values: a=%d b=%d c=%d", c.Value("a"), c.Value("b"), c.Value("c"))
+	}
+
+	var buf strings.Builder
+	c.write(&buf)
+	if !strings.Contains(buf.String(), "# TYPE test_counter_vec_total counter\ntest_counter_vec_total{kind=\"a\"} 2\ntest_counter_vec_total{kind=\"b\"} 1\n") {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	g := NewGaugeVec("test_escaped", "A gauge of client chosen labels", "channel")
+	g.Set("a\\b \"quoted\"\nnext", 1)
+
+	var buf strings.Builder
+	g.write(&buf)
+	if want := `test_escaped{channel="a\\b \"quoted\"\nnext"} 1` + "\n"; !strings.HasSuffix(buf.String(), want) {
+		t.Errorf("Expected %q, got:\n%s", want, buf.String())
+	}
+}
+
+func TestLabelValuesBounded(t *testing.T) {
+	c := NewCounterVec("test_bounded_total", "A counter of client chosen labels", "channel")
+	for i := 0; i < maxLabelValues+10; i++ {
+		c.Inc(fmt.Sprintf("channel-%d", i))
+	}
+	c.Inc("channel-0")
+
+	if c.Value("channel-0") != 2 || c.Value(overflowLabel) != 10 {
+		t.Errorf("Expected known values to count and new ones to overflow, got %d and %d", c.Value("channel-0"), c.Value(overflowLabel))
+	}
+	if c.Value(fmt.Sprintf("channel-%d", maxLabelValues)) != 0 {
+		t.Error("Expected no value past the bound")
+	}
+
+	g := NewGaugeVec("test_bounded", "A gauge of client chosen labels", "channel")
+	for i := 0; i < maxLabelValues; i++ {
+		g.Set(fmt.Sprintf("channel-%d", i), 1)
+	}
+	g.Delete("channel-0")
+	g.Set("new", 5)
+	if g.Value("new") != 5 {
+		t.Error("Expected a deleted value to make room for a new one")
+	}
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_gauge", "A test gauge")
+	g.Set(10)
+	g.Inc()
+	g.Dec()
+	g.Dec()
+
+	if g.Value() != 9 {
+		t.Errorf("Expected gauge value 9, got %d", g.Value())
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	g := NewGaugeVec("test_gauge_vec", "A test gauge vector", "channel")
+	g.Set("a", 1)
+	g.Set("b", 2)
+
+	if g.Value("a") != 1 || g.Value("b") != 2 {
+		t.Errorf("Unexpected gauge values: a=%d b=%d", g.Value("a"), g.Value("b"))
+	}
+
+	g.Delete("a")
+	if g.Value("a") != 0 {
+		t.Errorf("Expected deleted label to read as 0, got %d", g.Value("a"))
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_histogram_seconds", "A test histogram", []float64{0.1, 1})
+	for _, v := range []float64{0.05, 0.5, 0.5, 3} {
+		h.Observe(v)
+	}
+	if h.Count() != 4 {
+		t.Errorf("Expected 4 observations, got %d", h.Count())
+	}
+
+	var out bytes.Buffer
+	h.write(&out)
+	for _, want := range []string{
+		"# TYPE test_histogram_seconds histogram",
+		`test_histogram_seconds_bucket{le="0.1"} 1`,
+		`test_histogram_seconds_bucket{le="1"} 3`,
+		`test_histogram_seconds_bucket{le="+Inf"} 4`,
+		"test_histogram_seconds_sum 4.05",
+		"test_histogram_seconds_count 4",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected histogram output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	NewCounter("test_handler_total", "Counter exposed by the handler").Add(3)
+	NewGaugeVec("test_handler_vec", "Gauge vector exposed by the handler", "channel").Set("fileStream", 42)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE test_handler_total counter",
+		"test_handler_total 3",
+		`test_handler_vec{channel="fileStream"} 42`,
+		"webrtc_poc_client_pending_lines",
+		"webrtc_poc_log_dropped_messages_total",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics output to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestWriteToIsSorted(t *testing.T) {
+	NewGauge("test_sorted_b", "b")
+	NewGauge("test_sorted_a", "a")
+
+	var buf bytes.Buffer
+	WriteTo(&buf)
+
+	out := buf.String()
+	if strings.Index(out, "test_sorted_a") > strings.Index(out, "test_sorted_b") {
+		t.Error("Expected metrics to be written in name order")
+	}
+}
@@ -0,0 +1,405 @@
+package noise
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/developmeh/webrtc-poc/internal/identity"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// bindingContext labels the signature that ties a Noise static key to a peer
+// identity
+const bindingContext = "webrtc-poc-noise-static-key"
+
+// tagLen is the authentication tag size of both ciphers
+const tagLen = 16
+
+// ProtocolHeader names the suite of a handshake started on the signaling
+// server; handshakes without it use Default
+const ProtocolHeader = "X-Noise-Protocol"
+
+// Suite is the set of algorithms a handshake uses. Both use SHA-256 as the
+// hash; their names are at most 32 bytes long, so the zero padded name is
+// the initial hash.
+type Suite struct {
+	name  string
+	curve ecdh.Curve
+	// dhLen is the length of the curve's public keys
+	dhLen int
+	aead  func(key []byte) (cipher.AEAD, error)
+	// nonce encodes a message counter as the cipher's nonce
+	nonce func(n uint64) []byte
+}
+
+var (
+	// Default uses X25519 and ChaCha20-Poly1305
+	Default = &Suite{
+		name:  "Noise_XX_25519_ChaChaPoly_SHA256",
+		curve: ecdh.X25519(),
+		dhLen: 32,
+		aead:  chacha20poly1305.New,
+		nonce: func(n uint64) []byte {
+			nonce := make([]byte, chacha20poly1305.NonceSize)
+			binary.LittleEndian.PutUint64(nonce[4:], n)
+			return nonce
+		},
+	}
+	// Approved uses only FIPS 140-3 approved algorithms: ECDH over P-256
+	// and AES-256-GCM
+	Approved = &Suite{
+		name:  "Noise_XX_P256_AESGCM_SHA256",
+		curve: ecdh.P256(),
+		dhLen: 65,
+		aead: func(key []byte) (cipher.AEAD, error) {
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				return nil, err
+			}
+			return cipher.NewGCM(block)
+		},
+		nonce: func(n uint64) []byte {
+			nonce := make([]byte, 12)
+			binary.BigEndian.PutUint64(nonce[4:], n)
+			return nonce
+		},
+	}
+)
+
+// Name returns the Noise protocol name of the suite
+func (s *Suite) Name() string {
+	return s.name
+}
+
+// SuiteByName returns the suite with a Noise protocol name, or Default for
+// an empty name
+func SuiteByName(name string) (*Suite, error) {
+	switch name {
+	case "", Default.name:
+		return Default, nil
+	case Approved.name:
+		return Approved, nil
+	}
+	return nil, fmt.Errorf("noise: unsupported protocol %q", name)
+}
+
+var (
+	// ErrDecrypt is returned when a message fails authentication
+	ErrDecrypt = errors.New("noise: message authentication failed")
+	// ErrMalformed is returned for handshake messages that cannot be parsed
+	ErrMalformed = errors.New("noise: malformed handshake message")
+	// ErrTruncated is returned for framed messages cut short
+	ErrTruncated = errors.New("noise: truncated message")
+)
+
+// cipherState encrypts with a key and an incrementing nonce
+type cipherState struct {
+	suite  *Suite
+	key    [32]byte
+	hasKey bool
+	n      uint64
+}
+
+func (c *cipherState) encrypt(ad, plaintext []byte) []byte {
+	if !c.hasKey {
+		return append([]byte{}, plaintext...)
+	}
+	aead, _ := c.suite.aead(c.key[:])
+	out := aead.Seal(nil, c.suite.nonce(c.n), plaintext, ad)
+	c.n++
+	return out
+}
+
+func (c *cipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	if !c.hasKey {
+		return append([]byte{}, ciphertext...), nil
+	}
+	aead, _ := c.suite.aead(c.key[:])
+	out, err := aead.Open(nil, c.suite.nonce(c.n), ciphertext, ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	c.n++
+	return out, nil
+}
+
+// symmetricState holds the chaining key and handshake hash
+type symmetricState struct {
+	cs cipherState
+	ck [32]byte
+	h  [32]byte
+}
+
+func newSymmetricState(suite *Suite, prologue []byte) *symmetricState {
+	s := &symmetricState{cs: cipherState{suite: suite}}
+	copy(s.h[:], suite.name)
+	s.ck = s.h
+	s.mixHash(prologue)
+	return s
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h[:])
+	h.Write(data)
+	h.Sum(s.h[:0])
+}
+
+func (s *symmetricState) mixKey(ikm []byte) {
+	ck, key := hkdf(s.ck[:], ikm)
+	s.ck = ck
+	s.cs = cipherState{suite: s.cs.suite, key: key, hasKey: true}
+}
+
+func (s *symmetricState) encryptAndHash(plaintext []byte) []byte {
+	ciphertext := s.cs.encrypt(s.h[:], plaintext)
+	s.mixHash(ciphertext)
+	return ciphertext
+}
+
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := s.cs.decrypt(s.h[:], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+func (s *symmetricState) split() (*cipherState, *cipherState) {
+	k1, k2 := hkdf(s.ck[:], nil)
+	return &cipherState{suite: s.cs.suite, key: k1, hasKey: true}, &cipherState{suite: s.cs.suite, key: k2, hasKey: true}
+}
+
+// hkdf is the two-output HKDF defined by the Noise specification
+func hkdf(chainingKey, ikm []byte) ([32]byte, [32]byte) {
+	mac := func(key, data []byte) []byte {
+		m := hmac.New(sha256.New, key)
+		m.Write(data)
+		return m.Sum(nil)
+	}
+
+	temp := mac(chainingKey, ikm)
+	var out1, out2 [32]byte
+	copy(out1[:], mac(temp, []byte{0x01}))
+	copy(out2[:], mac(temp, append(out1[:], 0x02)))
+	return out1, out2
+}
+
+// dh computes a shared secret on the private key's curve
+func dh(priv *ecdh.PrivateKey, pub []byte) ([]byte, error) {
+	remote, err := priv.Curve().NewPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("noise: invalid public key: %w", err)
+	}
+	return priv.ECDH(remote)
+}
+
+// handshake is the state shared by both sides of an XX handshake
+type handshake struct {
+	suite *Suite
+	id    *identity.Identity
+	ss    *symmetricState
+	s     *ecdh.PrivateKey
+	e     *ecdh.PrivateKey
+	re    []byte
+	rs    []byte
+}
+
+// newHandshake generates the keys for one handshake. The Noise static key is
+// not long-term: it is bound to the peer's Ed25519 identity by a signature
+// sent as the handshake payload, so the identity is all a peer needs to keep.
+func newHandshake(suite *Suite, id *identity.Identity, prologue []byte) (*handshake, error) {
+	s, err := suite.curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	e, err := suite.curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &handshake{suite: suite, id: id, ss: newSymmetricState(suite, prologue), s: s, e: e}, nil
+}
+
+// mixDH mixes a Diffie-Hellman result into the chaining key
+func (hs *handshake) mixDH(priv *ecdh.PrivateKey, pub []byte) error {
+	secret, err := dh(priv, pub)
+	if err != nil {
+		return err
+	}
+	hs.ss.mixKey(secret)
+	return nil
+}
+
+// bindingSize is the size of the encrypted identity binding that follows
+// the static key as payload
+const bindingSize = identity.BindingSize + tagLen
+
+// staticSize is the size of an encrypted static key
+func (hs *handshake) staticSize() int {
+	return hs.suite.dhLen + tagLen
+}
+
+// readBinding decrypts the identity binding for the peer's static key and
+// returns the peer's identity
+func (hs *handshake) readBinding(ciphertext []byte) (string, error) {
+	binding, err := hs.ss.decryptAndHash(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return identity.VerifyBinding(bindingContext, hs.rs, binding)
+}
+
+// Initiator runs the client side of a Noise XX handshake
+type Initiator struct {
+	hs *handshake
+}
+
+// NewInitiator starts a handshake with suite proving id to the responder
+func NewInitiator(suite *Suite, id *identity.Identity, prologue []byte) (*Initiator, error) {
+	hs, err := newHandshake(suite, id, prologue)
+	if err != nil {
+		return nil, err
+	}
+	return &Initiator{hs: hs}, nil
+}
+
+// Start returns the first handshake message (-> e)
+func (i *Initiator) Start() []byte {
+	e := i.hs.e.PublicKey().Bytes()
+	i.hs.ss.mixHash(e)
+	// The empty payload is still hashed, as the specification requires
+	return append(append([]byte{}, e...), i.hs.ss.encryptAndHash(nil)...)
+}
+
+// Finish reads the responder's message (<- e, ee, s, es) and returns the last
+// handshake message (-> s, se) along with the established session
+func (i *Initiator) Finish(msg []byte) ([]byte, *Session, error) {
+	hs := i.hs
+	dhLen, staticSize := hs.suite.dhLen, hs.staticSize()
+	if len(msg) != dhLen+staticSize+bindingSize {
+		return nil, nil, ErrMalformed
+	}
+
+	hs.re = append([]byte{}, msg[:dhLen]...)
+	hs.ss.mixHash(hs.re)
+	if err := hs.mixDH(hs.e, hs.re); err != nil {
+		return nil, nil, err
+	}
+	rs, err := hs.ss.decryptAndHash(msg[dhLen : dhLen+staticSize])
+	if err != nil {
+		return nil, nil, err
+	}
+	hs.rs = rs
+	if err := hs.mixDH(hs.e, hs.rs); err != nil {
+		return nil, nil, err
+	}
+	peer, err := hs.readBinding(msg[dhLen+staticSize:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	static := hs.s.PublicKey().Bytes()
+	out := hs.ss.encryptAndHash(static)
+	if err := hs.mixDH(hs.s, hs.re); err != nil {
+		return nil, nil, err
+	}
+	out = append(out, hs.ss.encryptAndHash(hs.id.Bind(bindingContext, static))...)
+
+	initiatorToResponder, responderToInitiator := hs.ss.split()
+	return out, &Session{send: initiatorToResponder, recv: responderToInitiator, PeerIdentity: peer}, nil
+}
+
+// Responder runs the server side of a Noise XX handshake
+type Responder struct {
+	hs *handshake
+}
+
+// NewResponder prepares to answer a handshake with suite proving id to the
+// initiator
+func NewResponder(suite *Suite, id *identity.Identity, prologue []byte) (*Responder, error) {
+	hs, err := newHandshake(suite, id, prologue)
+	if err != nil {
+		return nil, err
+	}
+	return &Responder{hs: hs}, nil
+}
+
+// Respond reads the first message (-> e) and returns the second
+// (<- e, ee, s, es)
+func (r *Responder) Respond(msg []byte) ([]byte, error) {
+	hs := r.hs
+	if len(msg) != hs.suite.dhLen {
+		return nil, ErrMalformed
+	}
+
+	hs.re = append([]byte{}, msg...)
+	hs.ss.mixHash(hs.re)
+	if _, err := hs.ss.decryptAndHash(nil); err != nil {
+		return nil, err
+	}
+
+	e := hs.e.PublicKey().Bytes()
+	hs.ss.mixHash(e)
+	out := append([]byte{}, e...)
+	if err := hs.mixDH(hs.e, hs.re); err != nil {
+		return nil, err
+	}
+
+	static := hs.s.PublicKey().Bytes()
+	out = append(out, hs.ss.encryptAndHash(static)...)
+	if err := hs.mixDH(hs.s, hs.re); err != nil {
+		return nil, err
+	}
+	out = append(out, hs.ss.encryptAndHash(hs.id.Bind(bindingContext, static))...)
+	return out, nil
+}
+
+// Finish reads the last message (-> s, se) and returns the session
+func (r *Responder) Finish(msg []byte) (*Session, error) {
+	hs := r.hs
+	staticSize := hs.staticSize()
+	if len(msg) != staticSize+bindingSize {
+		return nil, ErrMalformed
+	}
+
+	rs, err := hs.ss.decryptAndHash(msg[:staticSize])
+	if err != nil {
+		return nil, err
+	}
+	hs.rs = rs
+	if err := hs.mixDH(hs.e, hs.rs); err != nil {
+		return nil, err
+	}
+	peer, err := hs.readBinding(msg[staticSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	initiatorToResponder, responderToInitiator := hs.ss.split()
+	return &Session{send: responderToInitiator, recv: initiatorToResponder, PeerIdentity: peer}, nil
+}
+
+// Session encrypts messages after a completed handshake
+type Session struct {
+	send, recv *cipherState
+	// PeerIdentity is the identity the peer proved during the handshake
+	PeerIdentity string
+}
+
+// Seal encrypts a message for the peer
+func (s *Session) Seal(plaintext []byte) []byte {
+	return s.send.encrypt(nil, plaintext)
+}
+
+// Open decrypts a message from the peer
+func (s *Session) Open(ciphertext []byte) ([]byte, error) {
+	return s.recv.decrypt(nil, ciphertext)
+}
@@ -0,0 +1,241 @@
+package noise
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/identity"
+)
+
+func newTestIdentity(t *testing.T, dir, name string) *identity.Identity {
+	t.Helper()
+	id, err := identity.LoadOrCreate(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("LoadOrCreate returned error: %v", err)
+	}
+	return id
+}
+
+// handshakeMessages runs the first two messages of a handshake
+func handshakeMessages(t *testing.T, suite *Suite, client, server *identity.Identity) (*Initiator, *Responder, []byte) {
+	t.Helper()
+	initiator, err := NewInitiator(suite, client, Prologue)
+	if err != nil {
+		t.Fatalf("NewInitiator returned error: %v", err)
+	}
+	responder, err := NewResponder(suite, server, Prologue)
+	if err != nil {
+		t.Fatalf("NewResponder returned error: %v", err)
+	}
+	msg2, err := responder.Respond(initiator.Start())
+	if err != nil {
+		t.Fatalf("Respond returned error: %v", err)
+	}
+	return initiator, responder, msg2
+}
+
+func TestHandshake(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "noise-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	client := newTestIdentity(t, tmpDir, "client.key")
+	server := newTestIdentity(t, tmpDir, "server.key")
+
+	for _, suite := range []*Suite{Default, Approved} {
+		t.Run(suite.Name(), func(t *testing.T) {
+			testHandshake(t, suite, client, server)
+		})
+	}
+
+	t.Run("SuiteMismatch", func(t *testing.T) {
+		initiator, err := NewInitiator(Approved, client, Prologue)
+		if err != nil {
+			t.Fatalf("NewInitiator returned error: %v", err)
+		}
+		responder, err := NewResponder(Default, server, Prologue)
+		if err != nil {
+			t.Fatalf("NewResponder returned error: %v", err)
+		}
+		if _, err := responder.Respond(initiator.Start()); err == nil {
+			t.Error("Expected an error when the suites differ")
+		}
+	})
+}
+
+// testHandshake runs the handshake tests with suite
+func testHandshake(t *testing.T, suite *Suite, client, server *identity.Identity) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		initiator, responder, msg2 := handshakeMessages(t, suite, client, server)
+		msg3, clientSession, err := initiator.Finish(msg2)
+		if err != nil {
+			t.Fatalf("Initiator Finish returned error: %v", err)
+		}
+		serverSession, err := responder.Finish(msg3)
+		if err != nil {
+			t.Fatalf("Responder Finish returned error: %v", err)
+		}
+
+		if clientSession.PeerIdentity != server.ID() {
+			t.Errorf("Expected server identity %s, got %s", server.ID(), clientSession.PeerIdentity)
+		}
+		if serverSession.PeerIdentity != client.ID() {
+			t.Errorf("Expected client identity %s, got %s", client.ID(), serverSession.PeerIdentity)
+		}
+
+		offer := []byte(`{"type":"offer","sdp":"v=0"}`)
+		opened, err := serverSession.Open(clientSession.Seal(offer))
+		if err != nil || !bytes.Equal(opened, offer) {
+			t.Errorf("Expected %q, got %q (err=%v)", offer, opened, err)
+		}
+		answer := []byte(`{"type":"answer","sdp":"v=0"}`)
+		opened, err = clientSession.Open(serverSession.Seal(answer))
+		if err != nil || !bytes.Equal(opened, answer) {
+			t.Errorf("Expected %q, got %q (err=%v)", answer, opened, err)
+		}
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		initiator, responder, msg2 := handshakeMessages(t, suite, client, server)
+		msg3, clientSession, err := initiator.Finish(msg2)
+		if err != nil {
+			t.Fatalf("Initiator Finish returned error: %v", err)
+		}
+		if _, err := responder.Finish(msg3); err != nil {
+			t.Fatalf("Responder Finish returned error: %v", err)
+		}
+
+		offer := []byte(`{"type":"offer","sdp":"v=0"}`)
+		if bytes.Contains(clientSession.Seal(offer), offer) {
+			t.Error("Expected the sealed message not to contain the plaintext")
+		}
+	})
+
+	t.Run("TamperedResponse", func(t *testing.T) {
+		initiator, _, msg2 := handshakeMessages(t, suite, client, server)
+		msg2[len(msg2)-1] ^= 0xff
+		if _, _, err := initiator.Finish(msg2); err == nil {
+			t.Error("Expected an error for a tampered handshake message")
+		}
+	})
+
+	t.Run("TamperedFinal", func(t *testing.T) {
+		initiator, responder, msg2 := handshakeMessages(t, suite, client, server)
+		msg3, _, err := initiator.Finish(msg2)
+		if err != nil {
+			t.Fatalf("Initiator Finish returned error: %v", err)
+		}
+		msg3[0] ^= 0xff
+		if _, err := responder.Finish(msg3); err == nil {
+			t.Error("Expected an error for a tampered handshake message")
+		}
+	})
+
+	t.Run("TamperedTransport", func(t *testing.T) {
+		initiator, responder, msg2 := handshakeMessages(t, suite, client, server)
+		msg3, clientSession, err := initiator.Finish(msg2)
+		if err != nil {
+			t.Fatalf("Initiator Finish returned error: %v", err)
+		}
+		serverSession, err := responder.Finish(msg3)
+		if err != nil {
+			t.Fatalf("Responder Finish returned error: %v", err)
+		}
+
+		sealed := clientSession.Seal([]byte("offer"))
+		sealed[0] ^= 0xff
+		if _, err := serverSession.Open(sealed); err != ErrDecrypt {
+			t.Errorf("Expected ErrDecrypt, got %v", err)
+		}
+	})
+
+	t.Run("PrologueMismatch", func(t *testing.T) {
+		initiator, err := NewInitiator(suite, client, []byte("other protocol"))
+		if err != nil {
+			t.Fatalf("NewInitiator returned error: %v", err)
+		}
+		responder, err := NewResponder(suite, server, Prologue)
+		if err != nil {
+			t.Fatalf("NewResponder returned error: %v", err)
+		}
+		msg2, err := responder.Respond(initiator.Start())
+		if err != nil {
+			t.Fatalf("Respond returned error: %v", err)
+		}
+		if _, _, err := initiator.Finish(msg2); err == nil {
+			t.Error("Expected an error when the prologues differ")
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		responder, err := NewResponder(suite, server, Prologue)
+		if err != nil {
+			t.Fatalf("NewResponder returned error: %v", err)
+		}
+		if _, err := responder.Respond([]byte("short")); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Expected ErrMalformed for a short first message, got %v", err)
+		}
+
+		initiator, _, _ := handshakeMessages(t, suite, client, server)
+		if _, _, err := initiator.Finish([]byte("short")); err == nil {
+			t.Error("Expected an error for a short second message")
+		}
+	})
+}
+
+func TestSuiteByName(t *testing.T) {
+	for name, want := range map[string]*Suite{
+		"":                                 Default,
+		"Noise_XX_25519_ChaChaPoly_SHA256": Default,
+		"Noise_XX_P256_AESGCM_SHA256":      Approved,
+	} {
+		if got, err := SuiteByName(name); err != nil || got != want {
+			t.Errorf("SuiteByName(%q) = %v, %v, expected %s", name, got, err, want.Name())
+		}
+	}
+	if _, err := SuiteByName("Noise_XX_448_AESGCM_SHA512"); err == nil {
+		t.Error("Expected an error for an unsupported suite")
+	}
+}
+
+func TestPending(t *testing.T) {
+	pending := NewPending(time.Minute)
+	responder := &Responder{}
+
+	id := pending.Add(responder)
+	if got, ok := pending.Take(id); !ok || got != responder {
+		t.Errorf("Expected the stored responder, got %v (ok=%v)", got, ok)
+	}
+	if _, ok := pending.Take(id); ok {
+		t.Error("Expected a responder to be taken only once")
+	}
+
+	expired := NewPending(-time.Second)
+	id = expired.Add(responder)
+	if _, ok := expired.Take(id); ok {
+		t.Error("Expected an expired responder not to be returned")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	joined := Join([]byte("handshake"), []byte("payload"))
+	handshake, payload, err := SplitJoined(joined)
+	if err != nil {
+		t.Fatalf("SplitJoined returned error: %v", err)
+	}
+	if string(handshake) != "handshake" || string(payload) != "payload" {
+		t.Errorf("Expected handshake and payload, got %q and %q", handshake, payload)
+	}
+
+	for _, data := range [][]byte{nil, {0}, {0, 5, 'a'}} {
+		if _, _, err := SplitJoined(data); !errors.Is(err, ErrTruncated) {
+			t.Errorf("Expected ErrTruncated for %v, got %v", data, err)
+		}
+	}
+}
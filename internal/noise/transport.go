@@ -0,0 +1,85 @@
+package noise
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Prologue binds handshakes to the signaling protocol they protect
+var Prologue = []byte("webrtc-poc signaling v1")
+
+// SessionHeader names the pending handshake a signaling request continues
+const SessionHeader = "X-Noise-Session"
+
+// Pending holds responders waiting for the initiator's last handshake
+// message, which arrives in a separate signaling request
+type Pending struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	responders map[string]pendingResponder
+}
+
+type pendingResponder struct {
+	responder *Responder
+	expires   time.Time
+}
+
+// NewPending creates a store that forgets handshakes after ttl
+func NewPending(ttl time.Duration) *Pending {
+	return &Pending{ttl: ttl, responders: make(map[string]pendingResponder)}
+}
+
+// Add stores a responder and returns the id the initiator continues with
+func (p *Pending) Add(r *Responder) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Drop handshakes that were never finished
+	now := time.Now()
+	for id, pending := range p.responders {
+		if now.After(pending.expires) {
+			delete(p.responders, id)
+		}
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	p.responders[id] = pendingResponder{responder: r, expires: now.Add(p.ttl)}
+	return id
+}
+
+// Take removes and returns the responder stored under id
+func (p *Pending) Take(id string) (*Responder, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending, ok := p.responders[id]
+	delete(p.responders, id)
+	if !ok || time.Now().After(pending.expires) {
+		return nil, false
+	}
+	return pending.responder, true
+}
+
+// Join packs the last handshake message and the first transport message into
+// one request body
+func Join(handshake, payload []byte) []byte {
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(handshake)))
+	return append(append(out, handshake...), payload...)
+}
+
+// SplitJoined unpacks a body created by Join
+func SplitJoined(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, ErrTruncated
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return nil, nil, ErrTruncated
+	}
+	return data[2 : 2+n], data[2+n:], nil
+}
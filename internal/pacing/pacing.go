@@ -0,0 +1,193 @@
+// Package pacing limits the rate transfers are sent at according to the
+// time of day, so large transfers run at full speed at night and leave room
+// for other traffic during the day.
+package pacing
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+	"github.com/developmeh/webrtc-poc/internal/source"
+)
+
+// Full is the rate of windows that send at full speed
+const Full = "full"
+
+// slack is how far the pacer lets a transfer fall behind its rate before
+// the bytes it did not send are forgotten, so oversleeping between small
+// lines is made up for instead of lowering the rate
+const slack = 50 * time.Millisecond
+
+// rateGauge reports the rate of the current window
+var rateGauge = metrics.NewGauge("webrtc_poc_pacing_rate_bytes",
+	"Bytes per second transfers are limited to by the current --pacing-window, 0 at full speed")
+
+// Window is a daily time range and the rate transfers are sent at during it
+type Window struct {
+	// From and To are the start and end of the window as offsets from
+	// midnight, local time; a window ending before it starts spans midnight
+	From, To time.Duration
+	// Always is set for the * window, which matches at any time
+	Always bool
+	// Rate is the limit in bytes per second, 0 for full speed
+	Rate int64
+
+	spec string
+}
+
+// ParseWindow parses a window written as HH:MM-HH:MM=RATE, or *=RATE for
+// any time, where RATE is full or bytes per second with an optional KiB, MiB
+// or GiB suffix and /s
+func ParseWindow(spec string) (Window, error) {
+	span, rate, ok := strings.Cut(strings.TrimSpace(spec), "=")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid pacing window %q: expected HH:MM-HH:MM=RATE", spec)
+	}
+
+	w := Window{spec: spec}
+	if rate = strings.TrimSpace(rate); rate != Full {
+		n, err := source.ParseSize(strings.TrimSuffix(rate, "/s"))
+		if err != nil {
+			return Window{}, fmt.Errorf("invalid pacing window %q: %w", spec, err)
+		}
+		if n == 0 {
+			return Window{}, fmt.Errorf("invalid pacing window %q: rate must be positive, or %s", spec, Full)
+		}
+		w.Rate = n
+	}
+
+	if span = strings.TrimSpace(span); span == "*" {
+		w.Always = true
+		return w, nil
+	}
+	from, to, ok := strings.Cut(span, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid pacing window %q: expected HH:MM-HH:MM or *", spec)
+	}
+	var err error
+	if w.From, err = parseClock(from); err != nil {
+		return Window{}, fmt.Errorf("invalid pacing window %q: %w", spec, err)
+	}
+	if w.To, err = parseClock(to); err != nil {
+		return Window{}, fmt.Errorf("invalid pacing window %q: %w", spec, err)
+	}
+	if w.From == w.To {
+		return Window{}, fmt.Errorf("invalid pacing window %q: starts and ends at the same time, use * for the whole day", spec)
+	}
+	return w, nil
+}
+
+// parseClock parses HH:MM into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls in the window
+func (w Window) Contains(t time.Time) bool {
+	if w.Always {
+		return true
+	}
+	h, m, s := t.Clock()
+	day := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if w.From < w.To {
+		return day >= w.From && day < w.To
+	}
+	return day >= w.From || day < w.To
+}
+
+// String returns the window as it was written
+func (w Window) String() string {
+	return w.spec
+}
+
+// Schedule is a list of windows, of which the first one containing the
+// current time applies
+type Schedule []Window
+
+// Parse parses a schedule from its windows, in order of precedence
+func Parse(specs []string) (Schedule, error) {
+	var s Schedule
+	for _, spec := range specs {
+		w, err := ParseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, w)
+	}
+	return s, nil
+}
+
+// Lookup returns the index of the window containing t, or -1 if none does
+func (s Schedule) Lookup(t time.Time) int {
+	for i, w := range s {
+		if w.Contains(t) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Pacer limits the bytes sent by every transfer sharing it to the rate of
+// the window that applies as they are sent
+type Pacer struct {
+	mu       sync.Mutex
+	schedule Schedule
+	current  int
+	next     time.Time
+	now      func() time.Time
+}
+
+// NewPacer creates a pacer following schedule
+func NewPacer(schedule Schedule) *Pacer {
+	return &Pacer{schedule: schedule, current: -2, now: time.Now}
+}
+
+// Delay accounts for n bytes that were just sent and returns how long to
+// wait before sending more to stay within the current window's rate. The
+// schedule is looked up on every call, so a transfer speeds up or slows down
+// as soon as a window starts or ends.
+func (p *Pacer) Delay(n int) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	i := p.schedule.Lookup(now)
+	if i != p.current {
+		p.current, p.next = i, now
+		p.enter(i)
+	}
+	if i < 0 || p.schedule[i].Rate == 0 {
+		p.next = now
+		return 0
+	}
+
+	if earliest := now.Add(-slack); p.next.Before(earliest) {
+		p.next = earliest
+	}
+	p.next = p.next.Add(time.Duration(int64(n) * int64(time.Second) / p.schedule[i].Rate))
+	return max(p.next.Sub(now), 0)
+}
+
+// enter logs and reports the window that started applying
+func (p *Pacer) enter(i int) {
+	if i < 0 {
+		rateGauge.Set(0)
+		logger.Info("Outside every pacing window, sending at full speed")
+		return
+	}
+	w := p.schedule[i]
+	rateGauge.Set(w.Rate)
+	if w.Rate == 0 {
+		logger.Info("Pacing window %s started, sending at full speed", w)
+	} else {
+		logger.Info("Pacing window %s started, sending at most %d bytes/s", w, w.Rate)
+	}
+}
@@ -0,0 +1,117 @@
+package pacing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		tests := []struct {
+			spec   string
+			from   time.Duration
+			to     time.Duration
+			always bool
+			rate   int64
+		}{
+			{"22:00-06:00=full", 22 * time.Hour, 6 * time.Hour, false, 0},
+			{"06:00-22:30=1MiB/s", 6 * time.Hour, 22*time.Hour + 30*time.Minute, false, 1 << 20},
+			{"*=512KiB", 0, 0, true, 512 << 10},
+			{" 09:15 - 17:45 = 1000 ", 9*time.Hour + 15*time.Minute, 17*time.Hour + 45*time.Minute, false, 1000},
+		}
+		for _, tt := range tests {
+			w, err := ParseWindow(tt.spec)
+			if err != nil {
+				t.Errorf("ParseWindow(%q) failed: %v", tt.spec, err)
+				continue
+			}
+			if w.From != tt.from || w.To != tt.to || w.Always != tt.always || w.Rate != tt.rate {
+				t.Errorf("ParseWindow(%q) = %+v", tt.spec, w)
+			}
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, spec := range []string{"", "22:00-06:00", "22:00=full", "25:00-06:00=full", "06:00-06:00=full", "*=0", "*=fast", "*=-1MiB"} {
+			if _, err := ParseWindow(spec); err == nil {
+				t.Errorf("Expected ParseWindow(%q) to fail", spec)
+			}
+		}
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	schedule, err := Parse([]string{"22:00-06:00=full", "12:00-13:00=4MiB", "*=1MiB"})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	at := func(h, m int) time.Time { return time.Date(2024, 1, 2, h, m, 0, 0, time.Local) }
+
+	tests := []struct {
+		t    time.Time
+		want int
+	}{
+		{at(23, 0), 0},
+		{at(0, 0), 0},
+		{at(5, 59), 0},
+		{at(6, 0), 2},
+		{at(12, 30), 1},
+		{at(13, 0), 2},
+		{at(22, 0), 0},
+	}
+	for _, tt := range tests {
+		if got := schedule.Lookup(tt.t); got != tt.want {
+			t.Errorf("Lookup(%s) = %d, expected %d", tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+
+	if got := schedule[:2].Lookup(at(9, 0)); got != -1 {
+		t.Errorf("Expected no window at 09:00, got %d", got)
+	}
+}
+
+func TestPacer(t *testing.T) {
+	schedule, err := Parse([]string{"22:00-06:00=full", "*=1000"})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	now := time.Date(2024, 1, 2, 21, 59, 0, 0, time.Local)
+	p := NewPacer(schedule)
+	p.now = func() time.Time { return now }
+
+	// Reservations add up within the limited window
+	if d := p.Delay(100); d != 100*time.Millisecond {
+		t.Errorf("Expected 100ms after 100 bytes at 1000 bytes/s, got %v", d)
+	}
+	if d := p.Delay(400); d != 500*time.Millisecond {
+		t.Errorf("Expected 500ms after 500 bytes at 1000 bytes/s, got %v", d)
+	}
+
+	// Oversleeping a little is made up for, but long pauses are not
+	now = now.Add(520 * time.Millisecond)
+	if d := p.Delay(10); d != 0 {
+		t.Errorf("Expected no delay while catching up, got %v", d)
+	}
+	now = now.Add(10 * time.Second)
+	if d := p.Delay(1000); d != 950*time.Millisecond {
+		t.Errorf("Expected at most %v of credit after a pause, got a %v delay", slack, d)
+	}
+
+	// The full speed window applies as soon as it starts
+	now = time.Date(2024, 1, 2, 22, 0, 0, 0, time.Local)
+	if d := p.Delay(1 << 20); d != 0 {
+		t.Errorf("Expected no delay at full speed, got %v", d)
+	}
+	if got := rateGauge.Value(); got != 0 {
+		t.Errorf("Expected the rate gauge to be 0 at full speed, got %d", got)
+	}
+
+	// And the limit again once it ends
+	now = time.Date(2024, 1, 3, 6, 0, 0, 0, time.Local)
+	if d := p.Delay(250); d != 250*time.Millisecond {
+		t.Errorf("Expected 250ms after 250 bytes at 1000 bytes/s, got %v", d)
+	}
+	if got := rateGauge.Value(); got != 1000 {
+		t.Errorf("Expected the rate gauge to be 1000, got %d", got)
+	}
+}
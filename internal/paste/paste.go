@@ -0,0 +1,111 @@
+// Package paste carries signaling messages through a terminal, for peers
+// whose operators copy the offer and the answer between them by hand. A
+// message is compressed, base64 encoded and wrapped into short lines between
+// BEGIN and END markers, like a PEM block, so it survives terminals that
+// limit the length of a line and can be pasted with the lines around it. A
+// block can also be drawn as a QR code, for a phone to scan and paste.
+package paste
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Kinds of messages
+const (
+	Offer  = "OFFER"
+	Answer = "ANSWER"
+)
+
+// MaxSize is the largest message a block may decode to
+const MaxSize = 128 * 1024
+
+// lineLength is how many characters of the encoded message go on a line
+const lineLength = 64
+
+// ErrTooLarge is returned for a block decoding to more than MaxSize
+var ErrTooLarge = errors.New("pasted message too large")
+
+func begin(kind string) string { return "-----BEGIN WEBRTC-POC " + kind + "-----" }
+func end(kind string) string   { return "-----END WEBRTC-POC " + kind + "-----" }
+
+// Write writes msg to w as a block of the given kind
+func Write(w io.Writer, kind string, msg []byte) error {
+	var compressed bytes.Buffer
+	zw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	zw.Write(msg)
+	zw.Close()
+	encoded := base64.StdEncoding.EncodeToString(compressed.Bytes())
+
+	var b strings.Builder
+	b.WriteString(begin(kind) + "\n")
+	for len(encoded) > lineLength {
+		b.WriteString(encoded[:lineLength] + "\n")
+		encoded = encoded[lineLength:]
+	}
+	b.WriteString(encoded + "\n")
+	b.WriteString(end(kind) + "\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Reader reads blocks pasted into a terminal or piped from a file
+type Reader struct {
+	sc *bufio.Scanner
+}
+
+// NewReader creates a Reader reading from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{sc: bufio.NewScanner(r)}
+}
+
+// Read returns the message of the next block of the given kind, skipping
+// the lines before it. It returns io.EOF once the input ends before a
+// block starts, and an error for a block that cannot be decoded, after
+// which the next one can be read.
+func (r *Reader) Read(kind string) ([]byte, error) {
+	inside := false
+	var encoded strings.Builder
+	for r.sc.Scan() {
+		line := strings.TrimSpace(r.sc.Text())
+		switch {
+		case line == begin(kind):
+			inside = true
+			encoded.Reset()
+		case !inside:
+		case line == end(kind):
+			return decode(encoded.String())
+		default:
+			encoded.WriteString(line)
+		}
+	}
+	if err := r.sc.Err(); err != nil {
+		return nil, err
+	}
+	if inside {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return nil, io.EOF
+}
+
+// decode reverses the encoding of Write
+func decode(encoded string) ([]byte, error) {
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pasted message: %w", err)
+	}
+	msg, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), MaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pasted message: %w", err)
+	}
+	if len(msg) > MaxSize {
+		return nil, ErrTooLarge
+	}
+	return msg, nil
+}
@@ -0,0 +1,120 @@
+package paste
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"rsc.io/qr"
+)
+
+func TestWriteRead(t *testing.T) {
+	msg := []byte(`{"type":"offer","sdp":"` + strings.Repeat("a=candidate:1 1 udp 2130706431 192.0.2.1 50000 typ host\r\n", 40) + `"}`)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, Offer, msg); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if lines[0] != "-----BEGIN WEBRTC-POC OFFER-----" || lines[len(lines)-1] != "-----END WEBRTC-POC OFFER-----" {
+		t.Errorf("Expected the block between markers, got:\n%s", buf.String())
+	}
+	for _, line := range lines[1 : len(lines)-1] {
+		if len(line) > lineLength {
+			t.Errorf("Expected lines of at most %d characters, got %d", lineLength, len(line))
+		}
+	}
+	if buf.Len() >= len(msg) {
+		t.Errorf("Expected the repetitive message to be compressed, got %d bytes for %d", buf.Len(), len(msg))
+	}
+
+	// Lines around the block and the indentation of a terminal are skipped
+	pasted := "[INFO] Waiting for the offer\n" + strings.ReplaceAll(buf.String(), "\n", "\n  ") + "\nmore\n"
+	r := NewReader(strings.NewReader(pasted))
+	got, err := r.Read(Offer)
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("Expected the message back, got %q, %v", got, err)
+	}
+	if _, err := r.Read(Offer); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last block, got %v", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	var offer, answer bytes.Buffer
+	Write(&offer, Offer, []byte("first"))
+	Write(&answer, Answer, []byte("answer"))
+
+	// Blocks of other kinds are skipped, and a broken block does not keep
+	// the next one from being read
+	input := answer.String() + "-----BEGIN WEBRTC-POC OFFER-----\n!!!\n-----END WEBRTC-POC OFFER-----\n" + offer.String()
+	r := NewReader(strings.NewReader(input))
+	if _, err := r.Read(Offer); err == nil {
+		t.Error("Expected an error for an invalid block")
+	}
+	if got, err := r.Read(Offer); err != nil || string(got) != "first" {
+		t.Errorf("Expected the next block, got %q, %v", got, err)
+	}
+
+	r = NewReader(strings.NewReader("-----BEGIN WEBRTC-POC ANSWER-----\nabc\n"))
+	if _, err := r.Read(Answer); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF for an unfinished block, got %v", err)
+	}
+
+	var large bytes.Buffer
+	Write(&large, Offer, make([]byte, MaxSize+1))
+	if _, err := NewReader(&large).Read(Offer); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestWriteQR(t *testing.T) {
+	msg := []byte(`{"sdp":{"type":"answer","sdp":"v=0\r\no=- 1 2 IN IP4 0.0.0.0\r\n"}}`)
+	var buf bytes.Buffer
+	if err := WriteQR(&buf, Answer, msg); err != nil {
+		t.Fatalf("WriteQR returned error: %v", err)
+	}
+
+	// Redraw the modules from the half blocks and compare them to the code
+	// of the block
+	var block strings.Builder
+	Write(&block, Answer, msg)
+	code, err := qr.Encode(block.String(), qr.L)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if want := (code.Size + 2*quietZone + 1) / 2; len(lines) != want {
+		t.Fatalf("Expected %d lines, got %d", want, len(lines))
+	}
+	for i, line := range lines {
+		row := []rune(line)
+		if len(row) != code.Size+2*quietZone {
+			t.Fatalf("Line %d: expected %d modules, got %d", i, code.Size+2*quietZone, len(row))
+		}
+		for j, r := range row {
+			x, y := j-quietZone, 2*i-quietZone
+			top := strings.ContainsRune("█▀", r)
+			bottom := strings.ContainsRune("█▄", r)
+			for _, m := range []struct {
+				y     int
+				light bool
+			}{{y, top}, {y + 1, bottom}} {
+				inside := x >= 0 && m.y >= 0 && x < code.Size && m.y < code.Size
+				if want := !inside || !code.Black(x, m.y); m.light != want {
+					t.Fatalf("Module (%d, %d): expected light %v", x, m.y, want)
+				}
+			}
+		}
+	}
+
+	// Random bytes do not compress
+	large := make([]byte, 4000)
+	rand.Read(large)
+	if err := WriteQR(io.Discard, Offer, large); err == nil {
+		t.Error("Expected a message too large for a QR code to fail")
+	}
+}
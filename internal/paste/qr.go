@@ -0,0 +1,67 @@
+package paste
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"rsc.io/qr"
+)
+
+// Print writes msg to w for the operator to paste, drawn as a QR code above
+// the block with withQR. A message too large for a QR code is only written as a
+// block.
+func Print(w io.Writer, kind string, msg []byte, withQR bool) error {
+	if withQR {
+		if err := WriteQR(w, kind, msg); err != nil {
+			logger.Info("Warning: %v, paste the block below instead", err)
+		}
+	}
+	return Write(w, kind, msg)
+}
+
+// quietZone is how many light modules surround a QR code, which scanners
+// need to find its edges
+const quietZone = 2
+
+// WriteQR writes msg to w as a QR code holding the block Write writes, so a
+// phone that scans it gets text to paste like a copied block. Two rows of
+// modules go on a line of half blocks, drawn light on a dark terminal. It
+// fails for messages too large for the largest QR code.
+func WriteQR(w io.Writer, kind string, msg []byte) error {
+	var block strings.Builder
+	if err := Write(&block, kind, msg); err != nil {
+		return err
+	}
+	code, err := qr.Encode(block.String(), qr.L)
+	if err != nil {
+		return fmt.Errorf("%d bytes of %s do not fit a QR code: %w", block.Len(), strings.ToLower(kind), err)
+	}
+
+	// Modules outside the code belong to the quiet zone
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return true
+		}
+		return !code.Black(x, y)
+	}
+	var b strings.Builder
+	for y := -quietZone; y < code.Size+quietZone; y += 2 {
+		for x := -quietZone; x < code.Size+quietZone; x++ {
+			switch top, bottom := light(x, y), light(x, y+1); {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
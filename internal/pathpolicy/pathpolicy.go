@@ -0,0 +1,114 @@
+// Package pathpolicy keeps the paths clients and fetch lists influence inside
+// a configured root. Paths are canonicalized to clean absolute paths, checked
+// against the root with filepath.Rel, and by default refused when a symlink
+// on the way leads out of the root, so neither a shared directory nor an
+// output directory can be left through a link planted inside it. A dangling
+// symlink is refused too, since where it leads cannot be checked.
+package pathpolicy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrOutsideRoot is returned for paths outside the root
+	ErrOutsideRoot = errors.New("path is outside the root directory")
+	// ErrSymlinkEscape is returned for paths that leave the root through a
+	// symlink
+	ErrSymlinkEscape = errors.New("path follows a symlink out of the root directory")
+)
+
+// Policy checks paths against a root directory
+type Policy struct {
+	root           string
+	followSymlinks bool
+}
+
+// New returns a policy for root, which need not exist yet. With
+// followSymlinks set, symlinks are followed wherever they lead.
+func New(root string, followSymlinks bool) (*Policy, error) {
+	root, err := Canonical(root)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{root: root, followSymlinks: followSymlinks}, nil
+}
+
+// Canonical returns the clean absolute form of path
+func Canonical(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("error canonicalizing %s: %w", path, err)
+	}
+	return abs, nil
+}
+
+// Root returns the canonical root directory
+func (p *Policy) Root() string {
+	return p.root
+}
+
+// Check returns the canonical form of path if it is inside the root, and
+// unless symlinks are followed, still inside it once the symlinks of its
+// existing part are resolved
+func (p *Policy) Check(path string) (string, error) {
+	path, err := Canonical(path)
+	if err != nil {
+		return "", err
+	}
+	if !within(p.root, path) {
+		return "", ErrOutsideRoot
+	}
+	if p.followSymlinks {
+		return path, nil
+	}
+
+	root, err := resolve(p.root)
+	if err != nil {
+		return "", err
+	}
+	real, err := resolve(path)
+	if err != nil {
+		return "", err
+	}
+	if !within(root, real) {
+		return "", ErrSymlinkEscape
+	}
+	return path, nil
+}
+
+// within reports whether path is root or below it
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolve evaluates the symlinks of the longest existing part of path and
+// appends the rest, so paths that are about to be created resolve too
+func resolve(path string) (string, error) {
+	rest := ""
+	for {
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if _, err := os.Lstat(path); err == nil {
+			return "", ErrSymlinkEscape
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(path, rest), nil
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
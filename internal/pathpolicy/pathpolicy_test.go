@@ -0,0 +1,126 @@
+package pathpolicy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tree creates a root with a file, a symlink to a directory outside it, a
+// symlink inside it and a dangling symlink
+func tree(t *testing.T) (root, outside string) {
+	t.Helper()
+	dir := t.TempDir()
+	root, outside = filepath.Join(dir, "root"), filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(root, "logs"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", d, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "logs", "a.log"), []byte("line\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	links := map[string]string{
+		"escape":   outside,
+		"current":  filepath.Join(root, "logs"),
+		"dangling": filepath.Join(dir, "missing"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("Cannot create symlinks: %v", err)
+		}
+	}
+	return root, outside
+}
+
+func TestCheck(t *testing.T) {
+	root, outside := tree(t)
+	policy, err := New(root, false)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	valid := map[string]string{
+		filepath.Join(root, "logs", "a.log"):           filepath.Join(root, "logs", "a.log"),
+		filepath.Join(root, "logs", "new", "b.log"):    filepath.Join(root, "logs", "new", "b.log"),
+		filepath.Join(root, "logs", "..", "c.log"):     filepath.Join(root, "c.log"),
+		filepath.Join(root, "current", "a.log"):        filepath.Join(root, "current", "a.log"),
+		filepath.Join(root, "current", "new", "d.log"): filepath.Join(root, "current", "new", "d.log"),
+		root: root,
+	}
+	for path, want := range valid {
+		got, err := policy.Check(path)
+		if err != nil {
+			t.Errorf("Check(%s) returned error: %v", path, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Expected %s for %s, got %s", want, path, got)
+		}
+	}
+
+	for _, path := range []string{outside, filepath.Join(root, "..", "outside", "a.log"), root + "-sibling"} {
+		if _, err := policy.Check(path); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("Expected ErrOutsideRoot for %s, got %v", path, err)
+		}
+	}
+
+	escapes := []string{
+		filepath.Join(root, "escape"),
+		filepath.Join(root, "escape", "a.log"),
+		filepath.Join(root, "escape", "new", "b.log"),
+		filepath.Join(root, "dangling"),
+	}
+	for _, path := range escapes {
+		if _, err := policy.Check(path); !errors.Is(err, ErrSymlinkEscape) {
+			t.Errorf("Expected ErrSymlinkEscape for %s, got %v", path, err)
+		}
+	}
+
+	t.Run("FollowSymlinks", func(t *testing.T) {
+		policy, err := New(root, true)
+		if err != nil {
+			t.Fatalf("New returned error: %v", err)
+		}
+		for _, path := range escapes {
+			if _, err := policy.Check(path); err != nil {
+				t.Errorf("Expected %s to be allowed, got %v", path, err)
+			}
+		}
+		if _, err := policy.Check(outside); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("Expected ErrOutsideRoot for %s, got %v", outside, err)
+		}
+	})
+
+	t.Run("Linked root", func(t *testing.T) {
+		link := filepath.Join(filepath.Dir(root), "link")
+		if err := os.Symlink(root, link); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+		policy, err := New(link, false)
+		if err != nil {
+			t.Fatalf("New returned error: %v", err)
+		}
+		if _, err := policy.Check(filepath.Join(link, "logs", "a.log")); err != nil {
+			t.Errorf("Expected a file below a linked root to be allowed, got %v", err)
+		}
+		if _, err := policy.Check(filepath.Join(link, "escape", "a.log")); !errors.Is(err, ErrSymlinkEscape) {
+			t.Errorf("Expected ErrSymlinkEscape below a linked root, got %v", err)
+		}
+	})
+}
+
+func TestCanonical(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd returned error: %v", err)
+	}
+	got, err := Canonical("out/./logs/../a.log")
+	if err != nil {
+		t.Fatalf("Canonical returned error: %v", err)
+	}
+	if want := filepath.Join(wd, "out", "a.log"); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,107 @@
+// Package peer creates the WebRTC APIs and the file stream data channel the
+// server and the client share, so both ends of a connection are set up the
+// same way whichever binary runs them.
+package peer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/developmeh/webrtc-poc/internal/config"
+	"github.com/developmeh/webrtc-poc/internal/fips"
+	"github.com/developmeh/webrtc-poc/internal/interceptors"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/sctperr"
+	"github.com/pion/webrtc/v3"
+)
+
+// DefaultLabel is the label of the file stream channel
+const DefaultLabel = "fileStream"
+
+// NewAPI creates a WebRTC API configured for the given ICE servers, with the
+// named media interceptors registered
+func NewAPI(iceServers []webrtc.ICEServer, interceptorNames []string) *webrtc.API {
+	// Create a new SettingEngine
+	settingEngine := webrtc.SettingEngine{}
+
+	// Configure ICE based on whether ICE servers are provided
+	if len(iceServers) == 0 {
+		// No ICE servers - use only local candidates
+		logger.Info("No ICE servers provided, using direct connection only")
+
+		// Disable mDNS
+		settingEngine.SetICEMulticastDNSMode(0) // 0 = Disabled
+
+		// Allow all interfaces for direct connection
+		settingEngine.SetInterfaceFilter(func(interfaceName string) bool {
+			return true // Allow all interfaces
+		})
+	} else {
+		logger.Info("Using ICE servers: %s", strings.Join(config.ICEServerURLs(iceServers), ", "))
+	}
+
+	// Keep DTLS to approved curves in FIPS mode
+	if curves := fips.DTLSCurves(); curves != nil {
+		settingEngine.SetDTLSEllipticCurves(curves...)
+	}
+
+	// Register the codecs and interceptors media tracks need
+	options, err := interceptors.APIOptions(interceptorNames)
+	if err != nil {
+		logger.Error("Failed to register interceptors: %v", err)
+	}
+
+	// Create a new API with the custom settings
+	return webrtc.NewAPI(append(options, webrtc.WithSettingEngine(settingEngine))...)
+}
+
+// Channel describes the file stream data channel. The server announces the
+// channel in-band and the client accepts it, unless Negotiated is set: then
+// both peers create it with ID, which only the client has to do before its
+// offer. Reliability applies to what a peer sends, so only the server's end
+// is ever Unreliable.
+type Channel struct {
+	// Label names the channel (default DefaultLabel)
+	Label string
+	// Protocol is the channel's subprotocol
+	Protocol string
+	// Negotiated pre-negotiates the channel with ID
+	Negotiated bool
+	ID         uint16
+	// Unreliable sends unordered and without retransmissions
+	Unreliable bool
+}
+
+// CreateFileChannel creates the file stream data channel on peerConnection.
+// Errors that end the channel, such as the peer aborting the SCTP
+// association, are logged with their kind.
+func CreateFileChannel(peerConnection *webrtc.PeerConnection, channel Channel) (*webrtc.DataChannel, error) {
+	protocol := channel.Protocol
+	options := &webrtc.DataChannelInit{Protocol: &protocol}
+	if channel.Negotiated {
+		negotiated := true
+		id := channel.ID
+		options.Negotiated, options.ID = &negotiated, &id
+	}
+	if channel.Unreliable {
+		ordered, retransmits := false, uint16(0)
+		options.Ordered, options.MaxRetransmits = &ordered, &retransmits
+	}
+	label := channel.Label
+	if label == "" {
+		label = DefaultLabel
+	}
+	dataChannel, err := peerConnection.CreateDataChannel(label, options)
+	if err != nil {
+		return nil, err
+	}
+	dataChannel.OnError(func(err error) {
+		logger.Error("Data channel %s failed: %v", ChannelName(dataChannel), sctperr.Wrap(err))
+	})
+	return dataChannel, nil
+}
+
+// ChannelName identifies a data channel in logs and metrics
+func ChannelName(dataChannel *webrtc.DataChannel) string {
+	return fmt.Sprintf("%s-%d", dataChannel.Label(), *dataChannel.ID())
+}
@@ -0,0 +1,216 @@
+// Package pipe joins the input and output of the two ends of a peer
+// connection over a single data channel, like netcat: what one end reads
+// from its input the other writes to its output, in both directions at once.
+// Every message on the channel is one frame: a type byte and a payload. Each
+// direction has a flow control window, so a slow output holds the other end
+// back instead of queueing without bound, and ends on its own once its input
+// did, so one end can finish sending and still receive the answer.
+package pipe
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// Protocol marks the data channel carrying the pipe
+	Protocol = "webrtc-poc-pipe"
+	// Label is the label of the pipe data channel
+	Label = "pipe"
+
+	// Window is how many bytes one end may send before the other confirms
+	// it wrote them
+	Window = 256 * 1024
+	// maxPayload is the most data carried by one frame
+	maxPayload = 16 * 1024
+)
+
+// Frame types
+const (
+	// frameData carries data read from the input
+	frameData byte = iota + 1
+	// frameWindow grants the sender the number of bytes in the payload
+	frameWindow
+	// frameEnd tells the peer the input ended
+	frameEnd
+)
+
+// ErrClosed is returned when the channel closed before the peer ended its
+// input
+var ErrClosed = errors.New("pipe closed by the peer")
+
+// Pipe runs one end of a pipe
+type Pipe struct {
+	send func([]byte) error
+	// sent counts the bytes sent
+	sent atomic.Int64
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// buf holds received data not written yet
+	buf []byte
+	// window is how much may still be sent, and pumped set once the input
+	// ended
+	window int
+	pumped bool
+	// ended is set once the peer's input ended, closed once the channel
+	// closed and failed if the peer broke the protocol
+	ended  bool
+	closed bool
+	failed error
+}
+
+// New returns a pipe sending frames with send
+func New(send func([]byte) error) *Pipe {
+	p := &Pipe{send: send, window: Window}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// sendFrame sends one frame
+func (p *Pipe) sendFrame(kind byte, payload []byte) error {
+	frame := make([]byte, 1+len(payload))
+	frame[0] = kind
+	copy(frame[1:], payload)
+	return p.send(frame)
+}
+
+// Handle processes a frame received from the peer. It never blocks, so it
+// can be called from the channel's message handler.
+func (p *Pipe) Handle(frame []byte) {
+	if len(frame) == 0 {
+		return
+	}
+	kind, payload := frame[0], frame[1:]
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch kind {
+	case frameData:
+		switch {
+		case p.ended:
+			p.failed, p.closed = errors.New("peer sent data after its input ended"), true
+		case len(p.buf)+len(payload) > Window:
+			p.failed, p.closed = errors.New("peer exceeded the flow control window"), true
+		default:
+			p.buf = append(p.buf, payload...)
+		}
+	case frameWindow:
+		if len(payload) == 4 {
+			p.window += int(binary.BigEndian.Uint32(payload))
+		}
+	case frameEnd:
+		p.ended = true
+	}
+	p.cond.Broadcast()
+}
+
+// Close ends the pipe, for when the channel closed. Data received before
+// is still written.
+func (p *Pipe) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// Run sends what is read from in to the peer and writes what the peer sends
+// to out, until both inputs ended and the peer wrote everything sent to it,
+// or the channel closed. It returns the bytes sent and received, and the
+// error writing to out, ErrClosed if the channel closed before the peer's
+// input ended, or the error of a peer breaking the protocol.
+func (p *Pipe) Run(in io.Reader, out io.Writer) (sent, received int64, err error) {
+	go func() {
+		p.pump(in)
+		p.mu.Lock()
+		p.pumped = true
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}()
+
+	received, err = p.drain(out)
+	if err != nil {
+		p.Close()
+	}
+
+	// Wait until the peer wrote everything sent to it, unless the channel
+	// closed, which may leave a read of the input blocked
+	p.mu.Lock()
+	for !p.closed && !(p.pumped && p.window == Window) {
+		p.cond.Wait()
+	}
+	p.mu.Unlock()
+	return p.sent.Load(), received, err
+}
+
+// pump sends what is read from in until it ends, then tells the peer
+func (p *Pipe) pump(in io.Reader) {
+	buf := make([]byte, maxPayload)
+	for {
+		n, err := in.Read(buf)
+		for data := buf[:n]; len(data) > 0; {
+			p.mu.Lock()
+			for p.window == 0 && !p.closed {
+				p.cond.Wait()
+			}
+			if p.closed {
+				p.mu.Unlock()
+				return
+			}
+			chunk := min(len(data), p.window)
+			p.window -= chunk
+			p.mu.Unlock()
+
+			if err := p.sendFrame(frameData, data[:chunk]); err != nil {
+				p.Close()
+				return
+			}
+			p.sent.Add(int64(chunk))
+			data = data[chunk:]
+		}
+		if err != nil {
+			if err := p.sendFrame(frameEnd, nil); err != nil {
+				p.Close()
+			}
+			return
+		}
+	}
+}
+
+// drain writes what the peer sends to out until its input ended, granting it
+// as much again once written
+func (p *Pipe) drain(out io.Writer) (int64, error) {
+	var received int64
+	for {
+		p.mu.Lock()
+		for len(p.buf) == 0 && !p.ended && !p.closed {
+			p.cond.Wait()
+		}
+		data := p.buf
+		p.buf = nil
+		ended, closed, failed := p.ended, p.closed, p.failed
+		p.mu.Unlock()
+
+		if len(data) > 0 {
+			if _, err := out.Write(data); err != nil {
+				return received, err
+			}
+			received += int64(len(data))
+			var grant [4]byte
+			binary.BigEndian.PutUint32(grant[:], uint32(len(data)))
+			p.sendFrame(frameWindow, grant[:])
+			continue
+		}
+		switch {
+		case failed != nil:
+			return received, failed
+		case ended:
+			return received, nil
+		case closed:
+			return received, ErrClosed
+		}
+	}
+}
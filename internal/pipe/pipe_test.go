@@ -0,0 +1,164 @@
+package pipe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// link connects two pipes as their channel would, delivering frames in
+// order
+func link() (a, b *Pipe) {
+	var toA, toB chan []byte = make(chan []byte, 1024), make(chan []byte, 1024)
+	a = New(func(frame []byte) error { toB <- frame; return nil })
+	b = New(func(frame []byte) error { toA <- frame; return nil })
+	go func() {
+		for frame := range toA {
+			a.Handle(frame)
+		}
+	}()
+	go func() {
+		for frame := range toB {
+			b.Handle(frame)
+		}
+	}()
+	return a, b
+}
+
+// result is what Run returned
+type result struct {
+	sent, received int64
+	err            error
+}
+
+func run(p *Pipe, in io.Reader, out io.Writer) <-chan result {
+	done := make(chan result, 1)
+	go func() {
+		sent, received, err := p.Run(in, out)
+		done <- result{sent, received, err}
+	}()
+	return done
+}
+
+func wait(t *testing.T, done <-chan result) result {
+	t.Helper()
+	select {
+	case r := <-done:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to return")
+		return result{}
+	}
+}
+
+// syncBuffer is a buffer safe to write from one goroutine and read from
+// another
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPipe(t *testing.T) {
+	a, b := link()
+
+	// More than the window, so the sender has to wait for grants
+	request := strings.Repeat("request\n", Window/4)
+	response := "response\n"
+	var atB, atA syncBuffer
+	aDone := run(a, strings.NewReader(request), &atA)
+	bDone := run(b, strings.NewReader(response), &atB)
+
+	ra, rb := wait(t, aDone), wait(t, bDone)
+	if ra.err != nil || rb.err != nil {
+		t.Fatalf("Unexpected errors: %v, %v", ra.err, rb.err)
+	}
+	if atB.String() != request || atA.String() != response {
+		t.Errorf("Expected the inputs to reach the other end, got %d and %d bytes", len(atB.String()), len(atA.String()))
+	}
+	if ra.sent != int64(len(request)) || ra.received != int64(len(response)) {
+		t.Errorf("Expected %d sent and %d received, got %+v", len(request), len(response), ra)
+	}
+	if rb.sent != int64(len(response)) || rb.received != int64(len(request)) {
+		t.Errorf("Expected %d sent and %d received, got %+v", len(response), len(request), rb)
+	}
+}
+
+func TestPipeHalfClose(t *testing.T) {
+	a, b := link()
+
+	// a's input ends right away, and it still receives what b sends later
+	r, w := io.Pipe()
+	var atA syncBuffer
+	aDone := run(a, strings.NewReader(""), &atA)
+	bDone := run(b, r, io.Discard)
+
+	w.Write([]byte("late answer"))
+	select {
+	case <-aDone:
+		t.Fatal("Expected a to wait for the other direction")
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.Close()
+	if res := wait(t, aDone); res.err != nil || atA.String() != "late answer" {
+		t.Errorf("Expected the late answer, got %q, %v", atA.String(), res.err)
+	}
+	if res := wait(t, bDone); res.err != nil {
+		t.Errorf("Unexpected error: %v", res.err)
+	}
+}
+
+func TestPipeClosed(t *testing.T) {
+	a, _ := link()
+
+	// The channel closing ends the pipe, even with the input still open
+	r, w := io.Pipe()
+	defer w.Close()
+	done := run(a, r, io.Discard)
+	a.Close()
+	if res := wait(t, done); !errors.Is(res.err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", res.err)
+	}
+}
+
+func TestPipeWindowExceeded(t *testing.T) {
+	p := New(func([]byte) error { return nil })
+	// The output blocks, so the frames pile up
+	release := make(chan struct{})
+	out := writerFunc(func(b []byte) (int, error) {
+		<-release
+		return len(b), nil
+	})
+	done := run(p, strings.NewReader(""), out)
+
+	frame := append([]byte{frameData}, make([]byte, maxPayload)...)
+	for range Window/maxPayload + 2 {
+		p.Handle(frame)
+	}
+	close(release)
+	if res := wait(t, done); res.err == nil || errors.Is(res.err, ErrClosed) {
+		t.Errorf("Expected a protocol error, got %v", res.err)
+	}
+}
+
+// writerFunc is a writer calling a function
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
@@ -0,0 +1,133 @@
+// Package pool runs the server's streaming tasks on a bounded number of
+// workers. Tasks beyond the limit wait in a queue instead of each getting a
+// goroutine of their own, so a flood of connections cannot stream more files
+// at once than the server was sized for, and the queue shows how far demand
+// outruns it.
+package pool
+
+import (
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+)
+
+var (
+	// sizeGauge reports the number of workers, 0 without a limit
+	sizeGauge = metrics.NewGauge("webrtc_poc_worker_pool_size",
+		"Tasks the worker pool runs at once, 0 without a limit")
+	// busyGauge reports the tasks running
+	busyGauge = metrics.NewGauge("webrtc_poc_worker_pool_busy",
+		"Streaming tasks running on a worker")
+	// queuedGauge reports the tasks waiting for a worker
+	queuedGauge = metrics.NewGauge("webrtc_poc_worker_pool_queued",
+		"Streaming tasks waiting for a free worker")
+	// spawnedGauge reports the tasks running outside the limit
+	spawnedGauge = metrics.NewGauge("webrtc_poc_worker_pool_spawned",
+		"Streaming tasks running outside the worker limit until their client leaves")
+	// waitHistogram reports how long tasks waited for a worker
+	waitHistogram = metrics.NewHistogram("webrtc_poc_worker_pool_wait_seconds",
+		"Time streaming tasks waited for a free worker",
+		[]float64{0.001, 0.01, 0.1, 1, 10, 60, 300, 1800})
+)
+
+// task is a queued function and what it is, for logs
+type task struct {
+	name   string
+	f      func()
+	queued time.Time
+}
+
+// Pool runs tasks on at most size workers at once, in the order they were
+// submitted. Workers are started as tasks arrive and exit once the queue is
+// empty, so an idle pool holds no goroutines.
+type Pool struct {
+	size int
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	queue   []task
+	running int
+}
+
+// New creates a pool of size workers, or without a limit if size is 0
+func New(size int) *Pool {
+	sizeGauge.Set(int64(size))
+	return &Pool{size: max(size, 0)}
+}
+
+// Go runs f on a free worker, or queues it until one is free. It never
+// blocks, so it can be called from connection callbacks.
+func (p *Pool) Go(name string, f func()) {
+	p.wg.Add(1)
+	t := task{name: name, f: f, queued: time.Now()}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.size == 0 || p.running < p.size {
+		p.running++
+		busyGauge.Set(int64(p.running))
+		go p.work(t)
+		return
+	}
+	p.queue = append(p.queue, t)
+	queuedGauge.Set(int64(len(p.queue)))
+	logger.Info("All %d workers are busy, %s waits behind %d queued tasks", p.size, name, len(p.queue)-1)
+}
+
+// Spawn runs f on a goroutine of its own right away, outside the limit, for
+// tasks that only end when their client leaves, like followed files. On a
+// worker they would hold it for good, and once every worker was held the
+// tasks queued behind them would wait forever. Wait waits for them too.
+func (p *Pool) Spawn(name string, f func()) {
+	p.wg.Add(1)
+	spawnedGauge.Inc()
+	go func() {
+		defer spawnedGauge.Dec()
+		p.run(task{name: name, f: f, queued: time.Now()})
+	}()
+}
+
+// Wait waits until every submitted task, queued ones included, finished
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+// Stats returns the number of running and queued tasks
+func (p *Pool) Stats() (running, queued int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running, len(p.queue)
+}
+
+// work runs t and then the queued tasks until the queue is empty
+func (p *Pool) work(t task) {
+	for {
+		wait := time.Since(t.queued)
+		waitHistogram.Observe(wait.Seconds())
+		if wait >= time.Second {
+			logger.Info("Starting %s after waiting %v for a worker", t.name, wait.Round(time.Millisecond))
+		}
+		p.run(t)
+
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			p.running--
+			busyGauge.Set(int64(p.running))
+			p.mu.Unlock()
+			return
+		}
+		t = p.queue[0]
+		p.queue[0] = task{}
+		p.queue = p.queue[1:]
+		queuedGauge.Set(int64(len(p.queue)))
+		p.mu.Unlock()
+	}
+}
+
+// run runs a task, marking it done even if it panics
+func (p *Pool) run(t task) {
+	defer p.wg.Done()
+	t.f()
+}
@@ -0,0 +1,132 @@
+package pool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	t.Run("Limit", func(t *testing.T) {
+		p := New(2)
+		release := make(chan struct{})
+		var active, peak atomic.Int32
+		for range 6 {
+			p.Go("task", func() {
+				n := active.Add(1)
+				for {
+					old := peak.Load()
+					if n <= old || peak.CompareAndSwap(old, n) {
+						break
+					}
+				}
+				<-release
+				active.Add(-1)
+			})
+		}
+
+		// Two tasks run, the others wait
+		deadline := time.Now().Add(5 * time.Second)
+		for active.Load() < 2 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if running, queued := p.Stats(); running != 2 || queued != 4 {
+			t.Errorf("Expected 2 running and 4 queued tasks, got %d and %d", running, queued)
+		}
+		if got := queuedGauge.Value(); got != 4 {
+			t.Errorf("Expected the queued gauge to be 4, got %d", got)
+		}
+
+		close(release)
+		p.Wait()
+		if got := peak.Load(); got != 2 {
+			t.Errorf("Expected at most 2 tasks at once, got %d", got)
+		}
+		if running, queued := p.Stats(); running != 0 || queued != 0 {
+			t.Errorf("Expected an idle pool, got %d running and %d queued tasks", running, queued)
+		}
+	})
+
+	t.Run("Order", func(t *testing.T) {
+		p := New(1)
+		var mu sync.Mutex
+		var order []int
+		for i := range 5 {
+			p.Go("task", func() {
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+			})
+		}
+		p.Wait()
+		for i, got := range order {
+			if got != i {
+				t.Fatalf("Expected tasks to run in the order they were submitted, got %v", order)
+			}
+		}
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		p := New(0)
+		var wg sync.WaitGroup
+		wg.Add(10)
+		for range 10 {
+			p.Go("task", func() {
+				// Every task must run at once for all of them to return
+				wg.Done()
+				wg.Wait()
+			})
+		}
+		done := make(chan struct{})
+		go func() {
+			p.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected a pool without a limit to run every task at once")
+		}
+	})
+	t.Run("Spawn", func(t *testing.T) {
+		p := New(1)
+		release := make(chan struct{})
+		started := make(chan struct{}, 3)
+		for range 2 {
+			p.Spawn("endless", func() {
+				started <- struct{}{}
+				<-release
+			})
+		}
+		// Spawned tasks hold no worker, so a task submitted after them runs
+		p.Go("task", func() { started <- struct{}{} })
+		for range 3 {
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Expected spawned tasks to leave the worker free")
+			}
+		}
+		if got := spawnedGauge.Value(); got != 2 {
+			t.Errorf("Expected the spawned gauge to be 2, got %d", got)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			p.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			t.Fatal("Expected Wait to wait for the spawned tasks")
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(release)
+		<-done
+		if got := spawnedGauge.Value(); got != 0 {
+			t.Errorf("Expected the spawned gauge to be 0, got %d", got)
+		}
+	})
+}
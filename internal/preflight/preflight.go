@@ -0,0 +1,206 @@
+// Package preflight checks that a server is ready to start before traffic is
+// sent to it: its settings are valid, the files it streams can be read, its
+// port can be bound and its ICE servers answer. The checks only look, they
+// neither create files nor keep the port, so they run next to the server
+// they are to replace.
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/bundle"
+	"github.com/developmeh/webrtc-poc/internal/diagnose"
+)
+
+// Outcomes of a check
+const (
+	Pass = "PASS"
+	Fail = "FAIL"
+	Skip = "SKIP"
+)
+
+// Result is the outcome of one check
+type Result struct {
+	Name   string
+	Status string
+	// Detail says what was found, why the check failed or why it was skipped
+	Detail string
+}
+
+// Report collects the results of the checks in the order they ran
+type Report struct {
+	Results []Result
+}
+
+// Check records a check that passed with detail, or failed with err. The
+// errors joined in err are listed on one line.
+func (r *Report) Check(name string, err error, detail string) {
+	if err != nil {
+		r.Results = append(r.Results, Result{Name: name, Status: Fail, Detail: oneLine(err.Error())})
+		return
+	}
+	r.Results = append(r.Results, Result{Name: name, Status: Pass, Detail: detail})
+}
+
+// oneLine joins the lines of an error message, continuing the ones that end
+// with a colon
+func oneLine(msg string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			if strings.HasSuffix(b.String(), ":") {
+				b.WriteString(" ")
+			} else {
+				b.WriteString("; ")
+			}
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// Skip records a check that does not apply, and why
+func (r *Report) Skip(name, reason string) {
+	r.Results = append(r.Results, Result{Name: name, Status: Skip, Detail: reason})
+}
+
+// Failed returns the number of checks that failed
+func (r *Report) Failed() int {
+	n := 0
+	for _, result := range r.Results {
+		if result.Status == Fail {
+			n++
+		}
+	}
+	return n
+}
+
+// Write prints the report in a readable form, one check per line
+func (r *Report) Write(w io.Writer) {
+	fmt.Fprintln(w, "Server preflight report")
+	fmt.Fprintln(w)
+	width := 0
+	for _, result := range r.Results {
+		width = max(width, len(result.Name))
+	}
+	for _, result := range r.Results {
+		line := fmt.Sprintf("  %s  %-*s", result.Status, width, result.Name)
+		if result.Detail != "" {
+			line += "  " + result.Detail
+		}
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+	fmt.Fprintln(w)
+	if failed := r.Failed(); failed > 0 {
+		fmt.Fprintf(w, "%d of %d checks failed\n", failed, len(r.Results))
+	} else {
+		fmt.Fprintf(w, "All %d checks passed\n", len(r.Results))
+	}
+}
+
+// Readable checks that the file, directory or glob pattern spec names files
+// that can be read, and returns how many it names. Files other than regular
+// ones, such as devices and named pipes, are only looked up, since opening
+// them may block or consume their data.
+func Readable(spec string) (int, error) {
+	if !bundle.IsBundle(spec) {
+		return 1, readable(spec)
+	}
+	files, err := bundle.List(spec)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		if err := readable(f.Path); err != nil {
+			return 0, err
+		}
+	}
+	return len(files), nil
+}
+
+// readable checks that the file at path can be read
+func readable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// Bindable checks that the TCP address addr can be listened on, releasing it
+// right away
+func Bindable(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
+
+// ICEServer checks that the ICE server at rawURL answers within timeout and
+// describes how. STUN servers and TURN servers over UDP must answer a STUN
+// binding request; TURN servers over TCP or TLS must accept a connection.
+// Credentials are not checked, which would take allocating a relay.
+func ICEServer(rawURL string, timeout time.Duration) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	host := u.Opaque
+
+	var port string
+	switch u.Scheme {
+	case "stun", "turn":
+		port = "3478"
+	case "stuns", "turns":
+		port = "5349"
+	default:
+		return "", fmt.Errorf("unsupported ICE server scheme %q", u.Scheme)
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, port)
+	}
+
+	if u.Scheme == "turns" || u.Scheme == "stuns" || u.Query().Get("transport") == "tcp" {
+		conn, err := net.DialTimeout("tcp", host, timeout)
+		if err != nil {
+			return "", err
+		}
+		conn.Close()
+		return "accepts TCP connections", nil
+	}
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	mapped, err := diagnose.Probe(conn, host, timeout)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "", fmt.Errorf("no answer to a STUN binding request within %v", timeout)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("answered a STUN binding request, sees us as %s", mapped), nil
+}
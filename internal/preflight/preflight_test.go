@@ -0,0 +1,134 @@
+package preflight
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/stuntest"
+)
+
+func TestReport(t *testing.T) {
+	var report Report
+	report.Check("file", nil, "readable")
+	report.Skip("TLS", "not configured")
+	if report.Failed() != 0 {
+		t.Errorf("Expected no failures, got %d", report.Failed())
+	}
+	report.Check("settings", errors.Join(errors.New("invalid config file:\n  config.yaml:2: unknown key"), errors.New("--fec requires --unreliable")), "valid")
+	if report.Failed() != 1 {
+		t.Errorf("Expected one failure, got %d", report.Failed())
+	}
+
+	var buf bytes.Buffer
+	report.Write(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"  PASS  file      readable\n",
+		"  SKIP  TLS       not configured\n",
+		"  FAIL  settings  invalid config file: config.yaml:2: unknown key; --fec requires --unreliable\n",
+		"1 of 3 checks failed",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in the report, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestReadable(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.txt": "a\n", "b.txt": "", "c.log": "c\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		spec string
+		n    int
+	}{
+		{filepath.Join(dir, "a.txt"), 1},
+		{filepath.Join(dir, "b.txt"), 1},
+		{dir, 3},
+		{filepath.Join(dir, "*.txt"), 2},
+	} {
+		if n, err := Readable(tt.spec); err != nil || n != tt.n {
+			t.Errorf("%s: expected %d readable files, got %d, %v", tt.spec, tt.n, n, err)
+		}
+	}
+
+	if _, err := Readable(filepath.Join(dir, "missing.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file to fail, got %v", err)
+	}
+	if _, err := Readable(filepath.Join(dir, "*.csv")); err == nil {
+		t.Error("Expected a pattern without files to fail")
+	}
+
+	// Root reads files regardless of their mode
+	if os.Geteuid() != 0 {
+		locked := filepath.Join(dir, "locked.txt")
+		if err := os.WriteFile(locked, []byte("x"), 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Readable(locked); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("Expected an unreadable file to fail, got %v", err)
+		}
+	}
+}
+
+func TestBindable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	if err := Bindable(addr); err == nil {
+		t.Error("Expected a port in use to fail")
+	}
+	ln.Close()
+	if err := Bindable(addr); err != nil {
+		t.Errorf("Expected a free port to be bindable, got %v", err)
+	}
+}
+
+func TestICEServer(t *testing.T) {
+	server := stuntest.Start(t)
+	detail, err := ICEServer("stun:"+server, time.Second)
+	if err != nil || !strings.Contains(detail, "sees us as") {
+		t.Errorf("Expected the STUN server to answer, got %q, %v", detail, err)
+	}
+	if _, err := ICEServer("turn:"+server+"?transport=udp", time.Second); err != nil {
+		t.Errorf("Expected the TURN server to answer a binding request, got %v", err)
+	}
+
+	// Nothing answers on a closed UDP socket
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	silent := conn.LocalAddr().String()
+	conn.Close()
+	if _, err := ICEServer("stun:"+silent, 100*time.Millisecond); err == nil {
+		t.Error("Expected a silent STUN server to fail")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	url := fmt.Sprintf("turn:%s?transport=tcp", ln.Addr())
+	if detail, err := ICEServer(url, time.Second); err != nil || detail != "accepts TCP connections" {
+		t.Errorf("Expected the TURN server to accept a connection, got %q, %v", detail, err)
+	}
+
+	if _, err := ICEServer("http://example.com", time.Second); err == nil {
+		t.Error("Expected an unsupported scheme to fail")
+	}
+}
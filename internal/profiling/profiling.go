@@ -0,0 +1,114 @@
+// Package profiling serves the Go runtime profiles of a running server on a
+// local debug socket and turns the profiles it captures into a short top-N
+// summary, so profiling a server takes neither an open network port nor
+// familiarity with the pprof tooling.
+package profiling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ErrNotServing is returned when no server listens on the debug socket
+var ErrNotServing = errors.New("no server listens on the debug socket")
+
+// Handler returns the handler serving the runtime profiles under
+// /debug/pprof/
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Serve serves the runtime profiles on a Unix socket at path until the
+// returned server is closed. Only the user running the server may connect.
+// A socket left behind by a server that is gone is replaced, one still in use
+// is not.
+func Serve(path string) (*http.Server, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("debug socket %s is in use by another server", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale debug socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on debug socket: %w", err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict debug socket: %w", err)
+	}
+
+	server := &http.Server{Handler: Handler()}
+	go server.Serve(listener)
+	return server, nil
+}
+
+// Client captures profiles from a server through its debug socket
+type Client struct {
+	path string
+	http *http.Client
+}
+
+// NewClient creates a client for the debug socket at path
+func NewClient(path string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &Client{path: path, http: &http.Client{Transport: transport}}
+}
+
+// CPU profiles the server's CPU for d, which is rounded up to whole seconds
+func (c *Client) CPU(ctx context.Context, d time.Duration) ([]byte, error) {
+	seconds := max(int((d+time.Second-1)/time.Second), 1)
+	return c.get(ctx, "/debug/pprof/profile?seconds="+strconv.Itoa(seconds))
+}
+
+// Heap captures the server's heap profile after a garbage collection, so it
+// shows the memory in use rather than garbage
+func (c *Client) Heap(ctx context.Context) ([]byte, error) {
+	return c.get(ctx, "/debug/pprof/heap?gc=1")
+}
+
+// get fetches a profile from the debug socket
+func (c *Client) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://debug"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return nil, fmt.Errorf("%w at %s: %w", ErrNotServing, c.path, err)
+		}
+		return nil, fmt.Errorf("failed to capture profile: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server refused the profile: %s: %s", resp.Status, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,121 @@
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// retained keeps the allocation of allocateRetained in use
+var retained [][]byte
+
+//go:noinline
+func allocateRetained() {
+	for range 64 {
+		retained = append(retained, make([]byte, 64<<10))
+	}
+}
+
+// spin burns CPU for d
+//
+//go:noinline
+func spin(d time.Duration) (n int) {
+	for start := time.Now(); time.Since(start) < d; {
+		for i := range 100000 {
+			n += i * i
+		}
+	}
+	return n
+}
+
+func TestProfile(t *testing.T) {
+	// The server end, on a socket in a temporary directory
+	path := filepath.Join(t.TempDir(), "debug.sock")
+	server, err := Serve(path)
+	if err != nil {
+		t.Fatalf("Failed to serve debug socket: %v", err)
+	}
+	defer server.Close()
+	if _, err := Serve(path); err == nil {
+		t.Error("Expected a debug socket in use to be refused")
+	}
+
+	client := NewClient(path)
+	ctx := context.Background()
+
+	t.Run("Heap", func(t *testing.T) {
+		allocateRetained()
+		runtime.GC()
+		data, err := client.Heap(ctx)
+		if err != nil {
+			t.Fatalf("Failed to capture heap profile: %v", err)
+		}
+		p, err := Parse(data)
+		if err != nil {
+			t.Fatalf("Failed to parse heap profile: %v", err)
+		}
+		if got := p.SampleTypes[p.Value()]; got.Type != "inuse_space" || got.Unit != "bytes" {
+			t.Errorf("Expected the summary to rank by inuse_space, got %+v", got)
+		}
+
+		var out bytes.Buffer
+		if err := WriteSummary(&out, p, 20); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "allocateRetained") {
+			t.Errorf("Expected the retained allocation in the summary, got:\n%s", out.String())
+		}
+	})
+
+	t.Run("CPU", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			spin(1500 * time.Millisecond)
+		}()
+		data, err := client.CPU(ctx, time.Second)
+		<-done
+		if err != nil {
+			t.Fatalf("Failed to capture CPU profile: %v", err)
+		}
+		p, err := Parse(data)
+		if err != nil {
+			t.Fatalf("Failed to parse CPU profile: %v", err)
+		}
+		if p.Duration < time.Second {
+			t.Errorf("Expected a profile of at least 1s, got %v", p.Duration)
+		}
+		top, total := p.Top(p.Value(), 5)
+		if total == 0 || len(top) == 0 {
+			t.Fatal("Expected CPU samples")
+		}
+		if !strings.Contains(top[0].Function, "spin") {
+			t.Errorf("Expected spin to take most CPU, got %+v", top)
+		}
+		for _, e := range top {
+			if e.Flat > e.Cum || e.Cum > total {
+				t.Errorf("Inconsistent entry %+v for a total of %d", e, total)
+			}
+		}
+	})
+}
+
+func TestNotServing(t *testing.T) {
+	_, err := NewClient(filepath.Join(t.TempDir(), "missing.sock")).Heap(context.Background())
+	if !errors.Is(err, ErrNotServing) {
+		t.Errorf("Expected ErrNotServing, got %v", err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	for _, data := range [][]byte{{0x1f, 0x8b, 0x00}, {0x0a, 0x05, 0x01}, {0x08}, {}} {
+		if _, err := Parse(data); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Expected ErrMalformed for %x, got %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package profiling
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// ErrMalformed is returned for data that is not a valid pprof profile
+var ErrMalformed = errors.New("malformed profile")
+
+// Field numbers of the profile.proto messages that the summary needs
+const (
+	fieldSampleType        = 1
+	fieldSample            = 2
+	fieldLocation          = 4
+	fieldFunction          = 5
+	fieldStringTable       = 6
+	fieldDurationNanos     = 10
+	fieldDefaultSampleType = 14
+
+	fieldValueTypeType = 1
+	fieldValueTypeUnit = 2
+
+	fieldSampleLocation = 1
+	fieldSampleValue    = 2
+
+	fieldLocationID   = 1
+	fieldLocationLine = 4
+	fieldLineFunction = 1
+
+	fieldFunctionID   = 1
+	fieldFunctionName = 2
+)
+
+// ValueType describes the values of a profile's samples
+type ValueType struct {
+	Type string
+	Unit string
+}
+
+// Entry is a function's share of a profile: Flat counts the samples taken in
+// the function itself, Cum also those taken in the functions it called
+type Entry struct {
+	Function string
+	Flat     int64
+	Cum      int64
+}
+
+// Profile is the part of a pprof profile the summary is built from
+type Profile struct {
+	// SampleTypes lists what each value of a sample measures
+	SampleTypes []ValueType
+	// Duration is how long the profile was captured for, if it was
+	Duration time.Duration
+
+	samples     []sample
+	locations   map[uint64][]uint64
+	functions   map[uint64]int64
+	strings     []string
+	defaultType int64
+}
+
+// sample is a stack, innermost location first, and its values
+type sample struct {
+	locations []uint64
+	values    []int64
+}
+
+// Parse decodes a pprof profile, gzip compressed as servers send them or not
+func Parse(data []byte) (*Profile, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+	}
+
+	p := &Profile{locations: make(map[uint64][]uint64), functions: make(map[uint64]int64)}
+	var sampleTypes [][2]int64
+	err := fields(data, func(field int, v uint64, b []byte) error {
+		switch field {
+		case fieldSampleType:
+			var t [2]int64
+			err := fields(b, func(field int, v uint64, _ []byte) error {
+				switch field {
+				case fieldValueTypeType:
+					t[0] = int64(v)
+				case fieldValueTypeUnit:
+					t[1] = int64(v)
+				}
+				return nil
+			})
+			sampleTypes = append(sampleTypes, t)
+			return err
+		case fieldSample:
+			var s sample
+			err := fields(b, func(field int, v uint64, packed []byte) error {
+				switch field {
+				case fieldSampleLocation:
+					return repeated(v, packed, func(v uint64) { s.locations = append(s.locations, v) })
+				case fieldSampleValue:
+					return repeated(v, packed, func(v uint64) { s.values = append(s.values, int64(v)) })
+				}
+				return nil
+			})
+			p.samples = append(p.samples, s)
+			return err
+		case fieldLocation:
+			var id uint64
+			var functions []uint64
+			err := fields(b, func(field int, v uint64, line []byte) error {
+				switch field {
+				case fieldLocationID:
+					id = v
+				case fieldLocationLine:
+					return fields(line, func(field int, v uint64, _ []byte) error {
+						if field == fieldLineFunction {
+							functions = append(functions, v)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			p.locations[id] = functions
+			return err
+		case fieldFunction:
+			var id uint64
+			var name int64
+			err := fields(b, func(field int, v uint64, _ []byte) error {
+				switch field {
+				case fieldFunctionID:
+					id = v
+				case fieldFunctionName:
+					name = int64(v)
+				}
+				return nil
+			})
+			p.functions[id] = name
+			return err
+		case fieldStringTable:
+			p.strings = append(p.strings, string(b))
+		case fieldDurationNanos:
+			p.Duration = time.Duration(v)
+		case fieldDefaultSampleType:
+			p.defaultType = int64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range sampleTypes {
+		p.SampleTypes = append(p.SampleTypes, ValueType{Type: p.str(t[0]), Unit: p.str(t[1])})
+	}
+	if len(p.SampleTypes) == 0 {
+		return nil, fmt.Errorf("%w: no sample types", ErrMalformed)
+	}
+	return p, nil
+}
+
+// str looks a string up in the string table
+func (p *Profile) str(i int64) string {
+	if i < 0 || i >= int64(len(p.strings)) {
+		return ""
+	}
+	return p.strings[i]
+}
+
+// Value returns the index of the value the summary ranks functions by: the
+// profile's default sample type, or its last one as pprof does
+func (p *Profile) Value() int {
+	if name := p.str(p.defaultType); name != "" {
+		for i, t := range p.SampleTypes {
+			if t.Type == name {
+				return i
+			}
+		}
+	}
+	return len(p.SampleTypes) - 1
+}
+
+// Top returns the n functions with the largest flat share of value i, and
+// the total of value i over all samples
+func (p *Profile) Top(i, n int) (top []Entry, total int64) {
+	flat := make(map[string]int64)
+	cum := make(map[string]int64)
+	for _, s := range p.samples {
+		if i >= len(s.values) {
+			continue
+		}
+		v := s.values[i]
+		total += v
+
+		seen := make(map[string]bool)
+		for depth, loc := range s.locations {
+			for j, fn := range p.locations[loc] {
+				name := p.str(p.functions[fn])
+				if depth == 0 && j == 0 {
+					flat[name] += v
+				}
+				if !seen[name] {
+					seen[name] = true
+					cum[name] += v
+				}
+			}
+		}
+	}
+
+	for name, c := range cum {
+		top = append(top, Entry{Function: name, Flat: flat[name], Cum: c})
+	}
+	sort.Slice(top, func(a, b int) bool {
+		if top[a].Flat != top[b].Flat {
+			return top[a].Flat > top[b].Flat
+		}
+		if top[a].Cum != top[b].Cum {
+			return top[a].Cum > top[b].Cum
+		}
+		return top[a].Function < top[b].Function
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top, total
+}
+
+// WriteSummary writes the n functions with the largest flat share of the
+// profile as a table, with each value in its unit
+func WriteSummary(w io.Writer, p *Profile, n int) error {
+	i := p.Value()
+	t := p.SampleTypes[i]
+	top, total := p.Top(i, n)
+
+	if total == 0 {
+		_, err := fmt.Fprintf(w, "No %s samples were taken\n", t.Type)
+		return err
+	}
+	fmt.Fprintf(w, "Top %d functions by %s, out of %s in total\n", len(top), t.Type, format(total, t.Unit))
+	fmt.Fprintf(w, "%10s %6s %10s %6s  %s\n", "flat", "flat%", "cum", "cum%", "function")
+	for _, e := range top {
+		fmt.Fprintf(w, "%10s %5.1f%% %10s %5.1f%%  %s\n",
+			format(e.Flat, t.Unit), percent(e.Flat, total),
+			format(e.Cum, t.Unit), percent(e.Cum, total), e.Function)
+	}
+	return nil
+}
+
+// percent returns v as a percentage of total
+func percent(v, total int64) float64 {
+	return float64(v) * 100 / float64(total)
+}
+
+// format formats a sample value in its unit
+func format(v int64, unit string) string {
+	switch unit {
+	case "nanoseconds":
+		return time.Duration(v).Round(time.Millisecond).String()
+	case "bytes":
+		switch {
+		case v >= 1<<30:
+			return fmt.Sprintf("%.2fGiB", float64(v)/(1<<30))
+		case v >= 1<<20:
+			return fmt.Sprintf("%.2fMiB", float64(v)/(1<<20))
+		case v >= 1<<10:
+			return fmt.Sprintf("%.2fKiB", float64(v)/(1<<10))
+		}
+		return fmt.Sprintf("%dB", v)
+	}
+	return fmt.Sprint(v)
+}
+
+// fields calls f with the number and value of each field of a protobuf
+// message: varints in v, length-delimited fields in b
+func fields(data []byte, f func(field int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("%w: invalid field key", ErrMalformed)
+		}
+		data = data[n:]
+
+		var v uint64
+		var b []byte
+		switch key & 7 {
+		case 0:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return fmt.Errorf("%w: invalid varint", ErrMalformed)
+			}
+			data = data[n:]
+		case 1, 5:
+			size := 8
+			if key&7 == 5 {
+				size = 4
+			}
+			if len(data) < size {
+				return fmt.Errorf("%w: truncated field", ErrMalformed)
+			}
+			data = data[size:]
+			continue
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return fmt.Errorf("%w: truncated field", ErrMalformed)
+			}
+			b = data[n : n+int(length)]
+			data = data[n+int(length):]
+		default:
+			return fmt.Errorf("%w: unsupported wire type %d", ErrMalformed, key&7)
+		}
+		if err := f(int(key>>3), v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repeated calls f with the values of a repeated varint field, which is
+// either a single value in v or packed into b
+func repeated(v uint64, packed []byte, f func(uint64)) error {
+	if packed == nil {
+		f(v)
+		return nil
+	}
+	for len(packed) > 0 {
+		v, n := binary.Uvarint(packed)
+		if n <= 0 {
+			return fmt.Errorf("%w: invalid packed varint", ErrMalformed)
+		}
+		f(v)
+		packed = packed[n:]
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package quality
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+	"github.com/pion/webrtc/v3"
+)
+
+// scoreGauge exports the current score of every monitored data channel
+var scoreGauge = metrics.NewGaugeVec("webrtc_poc_connection_quality_score",
+	"Rolling connection quality score between 0 (unusable) and 100 (perfect)", "channel")
+
+// Sample is a snapshot of a connection's health
+type Sample struct {
+	// RTT is the latest smoothed round-trip time
+	RTT time.Duration
+	// BufferedAmount is the number of bytes queued for sending
+	BufferedAmount uint64
+}
+
+// SampleFromStats extracts a sample from a peer connection stats report.
+// Pion reports no retransmissions for the data channel's SCTP association,
+// so only its round-trip time is taken from the report.
+func SampleFromStats(report webrtc.StatsReport, bufferedAmount uint64) Sample {
+	sample := Sample{BufferedAmount: bufferedAmount}
+
+	for _, s := range report {
+		switch stats := s.(type) {
+		case webrtc.SCTPTransportStats:
+			if stats.SmoothedRoundTripTime > 0 {
+				sample.RTT = time.Duration(stats.SmoothedRoundTripTime * float64(time.Second))
+			}
+		case webrtc.ICECandidatePairStats:
+			if !stats.Nominated {
+				continue
+			}
+			if sample.RTT == 0 && stats.CurrentRoundTripTime > 0 {
+				sample.RTT = time.Duration(stats.CurrentRoundTripTime * float64(time.Second))
+			}
+		}
+	}
+
+	return sample
+}
+
+// Scorer computes a rolling quality score from RTT and the growth of the
+// send buffer over a window of samples
+type Scorer struct {
+	window  int
+	samples []Sample
+	score   float64
+}
+
+// NewScorer creates a scorer that looks at the last window samples
+func NewScorer(window int) *Scorer {
+	if window < 2 {
+		window = 2
+	}
+	return &Scorer{window: window, score: 100}
+}
+
+// Add records a sample and returns the updated score
+func (s *Scorer) Add(sample Sample) int {
+	s.samples = append(s.samples, sample)
+	if len(s.samples) > s.window {
+		s.samples = s.samples[len(s.samples)-s.window:]
+	}
+
+	// Smooth the instantaneous score so a single bad sample doesn't swing pacing
+	s.score = 0.7*s.score + 0.3*s.instant()
+	return s.Score()
+}
+
+// Score returns the current score between 0 and 100
+func (s *Scorer) Score() int {
+	return int(s.score + 0.5)
+}
+
+// instant computes the score of the current window without smoothing
+func (s *Scorer) instant() float64 {
+	first := s.samples[0]
+	last := s.samples[len(s.samples)-1]
+	score := 100.0
+
+	// Round trips above 50ms cost a point per 10ms, up to 40 points
+	if rtt := last.RTT.Milliseconds(); rtt > 50 {
+		score -= min(float64(rtt-50)/10, 40)
+	}
+
+	// A growing send buffer means we are sending faster than the path drains,
+	// costing 10 points per 64KB of growth, up to 30 points
+	if last.BufferedAmount > first.BufferedAmount {
+		score -= min(float64(last.BufferedAmount-first.BufferedAmount)/(64*1024)*10, 30)
+	}
+
+	return max(score, 0)
+}
+
+// Pacer turns a quality score into a delay between sends
+type Pacer struct {
+	// Base is the configured delay used while the connection is healthy
+	Base time.Duration
+	// Step is the extra delay added per 20 points below a healthy score
+	Step time.Duration
+}
+
+// healthyScore is the score at or above which the base delay is used
+const healthyScore = 80
+
+// Delay returns the delay to use for the given score
+func (p Pacer) Delay(score int) time.Duration {
+	if score >= healthyScore {
+		return p.Base
+	}
+	step := max(p.Step, p.Base)
+	return p.Base + step*time.Duration(healthyScore-score)/20
+}
+
+// Level names a score range for logging
+func Level(score int) string {
+	switch {
+	case score >= healthyScore:
+		return "good"
+	case score >= 50:
+		return "fair"
+	default:
+		return "poor"
+	}
+}
+
+// Monitor periodically samples a connection, keeps its rolling score and
+// adapts the send delay to it
+type Monitor struct {
+	name   string
+	sample func() Sample
+	scorer *Scorer
+	pacer  Pacer
+	delay  atomic.Int64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// NewMonitor creates a monitor for the named channel using base as the delay
+// for a healthy connection
+func NewMonitor(name string, base time.Duration, sample func() Sample) *Monitor {
+	m := &Monitor{
+		name:   name,
+		sample: sample,
+		scorer: NewScorer(5),
+		pacer:  Pacer{Base: base, Step: 5 * time.Millisecond},
+		stop:   make(chan struct{}),
+	}
+	m.delay.Store(int64(base))
+	scoreGauge.Set(name, int64(m.scorer.Score()))
+	return m
+}
+
+// Start samples the connection every interval until Stop is called
+func (m *Monitor) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				m.update(m.sample())
+			}
+		}
+	}()
+}
+
+// update feeds a sample into the scorer and adapts the delay
+func (m *Monitor) update(sample Sample) {
+	before := m.scorer.Score()
+	score := m.scorer.Add(sample)
+	scoreGauge.Set(m.name, int64(score))
+
+	delay := m.pacer.Delay(score)
+	m.delay.Store(int64(delay))
+
+	if Level(before) != Level(score) {
+		logger.Info("Connection quality of %s changed from %s (%d) to %s (%d), pacing at %v per line",
+			m.name, Level(before), before, Level(score), score, delay)
+	}
+}
+
+// Delay returns the current delay between sends
+func (m *Monitor) Delay() time.Duration {
+	return time.Duration(m.delay.Load())
+}
+
+// Score returns the current quality score
+func (m *Monitor) Score() int {
+	return m.scorer.Score()
+}
+
+// Stop stops sampling and removes the channel's score from the metrics
+func (m *Monitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+		scoreGauge.Delete(m.name)
+	})
+}
@@ -0,0 +1,194 @@
+package quality
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestScorer(t *testing.T) {
+	t.Run("HealthyConnection", func(t *testing.T) {
+		s := NewScorer(5)
+		for i := 0; i < 10; i++ {
+			s.Add(Sample{RTT: 10 * time.Millisecond})
+		}
+		if score := s.Score(); score != 100 {
+			t.Errorf("Expected score 100, got %d", score)
+		}
+	})
+
+	t.Run("HighRTT", func(t *testing.T) {
+		s := NewScorer(5)
+		for i := 0; i < 30; i++ {
+			s.Add(Sample{RTT: 450 * time.Millisecond})
+		}
+		if score := s.Score(); score != 60 {
+			t.Errorf("Expected score 60, got %d", score)
+		}
+	})
+
+	t.Run("GrowingBuffer", func(t *testing.T) {
+		s := NewScorer(5)
+		var buffered uint64
+		for i := 0; i < 30; i++ {
+			buffered += 256 * 1024
+			s.Add(Sample{BufferedAmount: buffered})
+		}
+		if score := s.Score(); score != 70 {
+			t.Errorf("Expected score 70, got %d", score)
+		}
+	})
+
+	t.Run("Recovery", func(t *testing.T) {
+		s := NewScorer(5)
+		for i := 0; i < 10; i++ {
+			s.Add(Sample{RTT: time.Second})
+		}
+		low := s.Score()
+		for i := 0; i < 30; i++ {
+			s.Add(Sample{RTT: 10 * time.Millisecond})
+		}
+		if score := s.Score(); score <= low || score != 100 {
+			t.Errorf("Expected score to recover from %d to 100, got %d", low, score)
+		}
+	})
+}
+
+func TestPacer(t *testing.T) {
+	p := Pacer{Base: time.Second, Step: 5 * time.Millisecond}
+
+	tests := []struct {
+		score int
+		want  time.Duration
+	}{
+		{100, time.Second},
+		{80, time.Second},
+		{60, 2 * time.Second},
+		{0, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.Delay(tt.score); got != tt.want {
+			t.Errorf("Expected delay %v for score %d, got %v", tt.want, tt.score, got)
+		}
+	}
+
+	// Without a base delay the step still slows sending down
+	p = Pacer{Step: 5 * time.Millisecond}
+	if got := p.Delay(40); got != 10*time.Millisecond {
+		t.Errorf("Expected delay 10ms, got %v", got)
+	}
+}
+
+func TestSampleFromStats(t *testing.T) {
+	report := webrtc.StatsReport{
+		"sctp": webrtc.SCTPTransportStats{SmoothedRoundTripTime: 0.12},
+		"pair": webrtc.ICECandidatePairStats{Nominated: true, CurrentRoundTripTime: 0.5},
+	}
+
+	sample := SampleFromStats(report, 1024)
+	if sample.RTT != 120*time.Millisecond {
+		t.Errorf("Expected RTT 120ms, got %v", sample.RTT)
+	}
+	if sample.BufferedAmount != 1024 {
+		t.Errorf("Expected buffered amount 1024, got %d", sample.BufferedAmount)
+	}
+}
+
+// TestSampleFromStatsReport samples the stats report of a connection between
+// two peers in the process after a few round trips on its data channel
+func TestSampleFromStatsReport(t *testing.T) {
+	offerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer offerer.Close()
+	answerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer answerer.Close()
+
+	negotiated, id := true, uint16(0)
+	init := &webrtc.DataChannelInit{Negotiated: &negotiated, ID: &id}
+	sender, err := offerer.CreateDataChannel("fileStream", init)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	echo, err := answerer.CreateDataChannel("fileStream", init)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	echo.OnMessage(func(msg webrtc.DataChannelMessage) { echo.Send(msg.Data) })
+	replies := make(chan struct{}, 16)
+	sender.OnMessage(func(webrtc.DataChannelMessage) { replies <- struct{}{} })
+	opened := make(chan struct{})
+	sender.OnOpen(func() { close(opened) })
+
+	offer, err := offerer.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("CreateOffer returned error: %v", err)
+	}
+	offerer.SetLocalDescription(offer)
+	<-webrtc.GatheringCompletePromise(offerer)
+	answerer.SetRemoteDescription(*offerer.LocalDescription())
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("CreateAnswer returned error: %v", err)
+	}
+	answerer.SetLocalDescription(answer)
+	<-webrtc.GatheringCompletePromise(answerer)
+	offerer.SetRemoteDescription(*answerer.LocalDescription())
+
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the channel to open")
+	}
+	for i := 0; i < 10; i++ {
+		if err := sender.Send([]byte("ping")); err != nil {
+			t.Fatalf("Send returned error: %v", err)
+		}
+		select {
+		case <-replies:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a reply")
+		}
+	}
+
+	report := offerer.GetStats()
+	sample := SampleFromStats(report, sender.BufferedAmount())
+	if sample.RTT <= 0 {
+		t.Errorf("Expected a round-trip time from the report, got %v", sample.RTT)
+	}
+	if sample.BufferedAmount != 0 {
+		t.Errorf("Expected an empty send buffer, got %d bytes", sample.BufferedAmount)
+	}
+
+	// A healthy local connection scores at the top
+	s := NewScorer(5)
+	for i := 0; i < 10; i++ {
+		s.Add(SampleFromStats(offerer.GetStats(), sender.BufferedAmount()))
+	}
+	if score := s.Score(); score != 100 {
+		t.Errorf("Expected score 100 for a local connection, got %d", score)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	m := NewMonitor("test-1", time.Second, func() Sample { return Sample{} })
+	defer m.Stop()
+
+	for i := 0; i < 20; i++ {
+		m.update(Sample{RTT: time.Second})
+	}
+	if m.Score() >= healthyScore {
+		t.Errorf("Expected a degraded score, got %d", m.Score())
+	}
+	if m.Delay() <= time.Second {
+		t.Errorf("Expected delay above 1s, got %v", m.Delay())
+	}
+	if got := scoreGauge.Value("test-1"); got != int64(m.Score()) {
+		t.Errorf("Expected gauge %d, got %d", m.Score(), got)
+	}
+}
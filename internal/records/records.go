@@ -0,0 +1,198 @@
+// Package records reads the lines of a streamed file as records of at most
+// a maximum size, and applies a policy to longer lines: they are truncated
+// and end in a marker, split into several records, or abort the stream. A
+// data channel message carries at most 64 KiB, so no record is longer than
+// that. The server has its own terms, which a client may narrow with its
+// offer, asking for smaller records or another policy.
+package records
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Policies for lines longer than the maximum record size
+const (
+	// Truncate cuts the line to the maximum size, ending in Marker
+	Truncate = "truncate"
+	// Split sends the line as several records of at most the maximum size
+	Split = "split"
+	// Abort ends the stream with ErrTooLong
+	Abort = "abort"
+)
+
+const (
+	// Limit is the largest record, the largest data channel message
+	Limit = 64 << 10
+	// MinSize is the smallest maximum record size
+	MinSize = 64
+	// Marker ends a truncated record
+	Marker = "[truncated]"
+)
+
+// ErrTooLong is returned for a line longer than the maximum record size
+// under the Abort policy
+var ErrTooLong = errors.New("line longer than the maximum record size")
+
+// Terms are the maximum size of a record and the policy for longer lines.
+// The zero value aborts on lines longer than Limit.
+type Terms struct {
+	Policy  string
+	MaxSize int
+}
+
+// withDefaults fills in the terms left unset
+func (t Terms) withDefaults() Terms {
+	if t.Policy == "" {
+		t.Policy = Abort
+	}
+	if t.MaxSize == 0 {
+		t.MaxSize = Limit
+	}
+	return t
+}
+
+// Validate checks the policy and the maximum record size
+func (t Terms) Validate() error {
+	t = t.withDefaults()
+	switch t.Policy {
+	case Truncate, Split, Abort:
+	default:
+		return fmt.Errorf("unknown policy %q for oversized records (expected truncate, split or abort)", t.Policy)
+	}
+	if t.MaxSize < MinSize || t.MaxSize > Limit {
+		return fmt.Errorf("maximum record size must be between %d and %d bytes, got %d", MinSize, Limit, t.MaxSize)
+	}
+	return nil
+}
+
+// Negotiate returns the terms a client asked for with its offer: records no
+// larger than either side's maximum, and the client's policy if it named
+// one. maxSize 0 keeps the server's maximum.
+func (t Terms) Negotiate(maxSize int, policy string) (Terms, error) {
+	t = t.withDefaults()
+	if maxSize < 0 {
+		return t, fmt.Errorf("invalid maximum record size %d", maxSize)
+	}
+	if maxSize > 0 {
+		t.MaxSize = min(t.MaxSize, maxSize)
+	}
+	if policy != "" {
+		t.Policy = policy
+	}
+	return t, t.Validate()
+}
+
+// Scanner reads the records of a file like a bufio.Scanner reads its lines,
+// without their line endings
+type Scanner struct {
+	*bufio.Scanner
+	terms Terms
+	// records is the number of records read
+	records int
+	// oversized is set if the last record was cut from a longer line
+	oversized bool
+	// skipping is set while discarding the rest of a truncated line
+	skipping bool
+	// continued is set while the rest of a split line is read
+	continued bool
+}
+
+// NewScanner returns a scanner reading the records of r under terms
+func NewScanner(r io.Reader, terms Terms) *Scanner {
+	s := &Scanner{Scanner: bufio.NewScanner(r), terms: terms.withDefaults()}
+	// Room for a whole record with a CRLF after it, so anything that
+	// fills the buffer is too long
+	s.Buffer(make([]byte, 0, 4096), s.terms.MaxSize+2)
+	s.Split(s.split)
+	return s
+}
+
+// Oversized reports whether the last record was the first cut from a line
+// longer than the maximum record size, truncated or split
+func (s *Scanner) Oversized() bool {
+	return s.oversized
+}
+
+// split is the bufio.SplitFunc of the scanner
+func (s *Scanner) split(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	i := bytes.IndexByte(data, '\n')
+
+	// Discard the rest of a truncated line
+	if s.skipping {
+		if i < 0 {
+			return len(data), nil, nil
+		}
+		s.skipping = false
+		return i + 1, nil, nil
+	}
+
+	var line []byte
+	advance, whole := 0, true
+	switch {
+	case i >= 0:
+		line, advance = dropCR(data[:i]), i+1
+	case atEOF:
+		line, advance = dropCR(data), len(data)
+	case len(data) <= s.terms.MaxSize+1:
+		// Read more of the line
+		return 0, nil, nil
+	default:
+		line, whole = data, false
+	}
+
+	continued := s.continued
+	s.continued, s.oversized = false, false
+	if len(line) <= s.terms.MaxSize {
+		s.records++
+		return advance, line, nil
+	}
+
+	switch s.terms.Policy {
+	case Split:
+		// The rest of the line stays in the buffer for the next records
+		n := cut(line, s.terms.MaxSize)
+		s.records++
+		s.continued, s.oversized = true, !continued
+		return n, line[:n], nil
+	case Truncate:
+		n := cut(line, s.terms.MaxSize-len(Marker))
+		record := append(line[:n:n], Marker...)
+		s.records++
+		s.oversized = true
+		if !whole {
+			s.skipping = true
+			advance = len(data)
+		}
+		return advance, record, nil
+	default:
+		return 0, nil, fmt.Errorf("line %d is longer than %d bytes: %w", s.records+1, s.terms.MaxSize, ErrTooLong)
+	}
+}
+
+// dropCR drops the carriage return of a CRLF line ending, like
+// bufio.ScanLines
+func dropCR(data []byte) []byte {
+	if len(data) > 0 && data[len(data)-1] == '\r' {
+		return data[:len(data)-1]
+	}
+	return data
+}
+
+// cut returns where to cut b to at most n bytes, backing off to the start of
+// a UTF-8 sequence so multi-byte characters stay whole. b is longer than n.
+func cut(b []byte, n int) int {
+	for i := n; i > 0 && i > n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,141 @@
+package records
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// scan returns the records of input and which of them were oversized
+func scan(t *testing.T, input string, terms Terms) ([]string, []int, error) {
+	t.Helper()
+	s := NewScanner(strings.NewReader(input), terms)
+	var got []string
+	var oversized []int
+	for s.Scan() {
+		got = append(got, s.Text())
+		if s.Oversized() {
+			oversized = append(oversized, len(got))
+		}
+	}
+	return got, oversized, s.Err()
+}
+
+func TestScanner(t *testing.T) {
+	long := strings.Repeat("a", 150)
+	for _, tt := range []struct {
+		name      string
+		input     string
+		terms     Terms
+		want      []string
+		oversized []int
+	}{
+		{
+			name:  "Lines",
+			input: "first\r\nsecond\n\nlast",
+			terms: Terms{Policy: Split, MaxSize: MinSize},
+			want:  []string{"first", "second", "", "last"},
+		},
+		{
+			name:      "Truncate",
+			input:     "short\n" + long + "\nafter\n",
+			terms:     Terms{Policy: Truncate, MaxSize: 100},
+			want:      []string{"short", long[:100-len(Marker)] + Marker, "after"},
+			oversized: []int{2},
+		},
+		{
+			name:      "TruncateAtEOF",
+			input:     long,
+			terms:     Terms{Policy: Truncate, MaxSize: 100},
+			want:      []string{long[:100-len(Marker)] + Marker},
+			oversized: []int{1},
+		},
+		{
+			name:      "Split",
+			input:     "short\n" + long + "\r\nafter\n",
+			terms:     Terms{Policy: Split, MaxSize: 64},
+			want:      []string{"short", long[:64], long[64:128], long[128:], "after"},
+			oversized: []int{2},
+		},
+		{
+			name:      "SplitRunes",
+			input:     strings.Repeat("é", 40) + "\n",
+			terms:     Terms{Policy: Split, MaxSize: 65},
+			want:      []string{strings.Repeat("é", 32), strings.Repeat("é", 8)},
+			oversized: []int{1},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, oversized, err := scan(t, tt.input, tt.terms)
+			if err != nil {
+				t.Fatalf("Scan returned error: %v", err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+			if len(oversized) != len(tt.oversized) || (len(oversized) > 0 && oversized[0] != tt.oversized[0]) {
+				t.Errorf("Expected records %v oversized, got %v", tt.oversized, oversized)
+			}
+		})
+	}
+
+	t.Run("Abort", func(t *testing.T) {
+		got, _, err := scan(t, "short\n"+long+"\nafter\n", Terms{Policy: Abort, MaxSize: 100})
+		if !errors.Is(err, ErrTooLong) || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("Expected ErrTooLong for line 2, got %v", err)
+		}
+		if len(got) != 1 {
+			t.Errorf("Expected the records before the long line, got %q", got)
+		}
+	})
+
+	t.Run("LongerThanBuffer", func(t *testing.T) {
+		// Lines longer than the scanner's buffer are cut while they are read
+		huge := strings.Repeat("b", 3*Limit)
+		got, _, err := scan(t, huge+"\nafter\n", Terms{Policy: Truncate})
+		if err != nil || len(got) != 2 || len(got[0]) != Limit || got[1] != "after" {
+			t.Errorf("Expected a truncated record of %d bytes and the next line, got %d records, %v", Limit, len(got), err)
+		}
+		got, _, err = scan(t, huge+"\nafter\n", Terms{Policy: Split})
+		if err != nil || len(got) != 4 || got[3] != "after" {
+			t.Errorf("Expected three records and the next line, got %d records, %v", len(got), err)
+		}
+		if _, _, err := scan(t, huge, Terms{}); !errors.Is(err, ErrTooLong) {
+			t.Errorf("Expected the zero terms to abort, got %v", err)
+		}
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	server := Terms{Policy: Abort, MaxSize: 4096}
+	for _, tt := range []struct {
+		name    string
+		maxSize int
+		policy  string
+		want    Terms
+		wantErr bool
+	}{
+		{name: "ServerTerms", want: server},
+		{name: "Smaller", maxSize: 1024, policy: Split, want: Terms{Policy: Split, MaxSize: 1024}},
+		{name: "Larger", maxSize: Limit, policy: Truncate, want: Terms{Policy: Truncate, MaxSize: 4096}},
+		{name: "TooSmall", maxSize: MinSize - 1, wantErr: true},
+		{name: "UnknownPolicy", policy: "drop", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := server.Negotiate(tt.maxSize, tt.policy)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Expected %+v, got %+v, %v", tt.want, got, err)
+			}
+		})
+	}
+
+	if err := (Terms{MaxSize: Limit + 1}).Validate(); err == nil {
+		t.Error("Expected records larger than a data channel message to be refused")
+	}
+}
@@ -0,0 +1,158 @@
+// Package remotelog forwards the server's log lines about one session to the
+// client over a data channel of their connection, so a client debugging a
+// connection sees the server's half of the negotiation next to its own. The
+// client opens the channel; a server started with --remote-logs keeps the
+// lines it logs about the session from the offer on, and sends them once the
+// channel opens. Every message is a Line encoded as JSON.
+package remotelog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// Protocol marks the data channel the server's log lines are sent on
+	Protocol = "webrtc-poc-logs"
+	// Label is the label of the log channel
+	Label = "logs"
+
+	// Levels of the lines, named like the logger's prefixes
+	LevelInfo  = "INFO"
+	LevelError = "ERROR"
+	LevelDebug = "DEBUG"
+
+	// backlog is the number of lines kept until the channel opens, or while
+	// sending falls behind, before further lines are dropped
+	backlog = 256
+)
+
+// Line is one log line of the server
+type Line struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	Msg   string    `json:"msg"`
+}
+
+// Session logs the lines about one session and keeps them for the client.
+// A nil session only logs them.
+type Session struct {
+	lines    chan Line
+	dropped  atomic.Int64
+	attached atomic.Bool
+}
+
+// NewSession creates a session that keeps its lines until a log channel is
+// attached
+func NewSession() *Session {
+	return &Session{lines: make(chan Line, backlog)}
+}
+
+// Info logs an info message about the session
+func (s *Session) Info(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	logger.Info("%s", msg)
+	s.keep(LevelInfo, msg)
+}
+
+// Error logs an error message about the session
+func (s *Session) Error(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	logger.Error("%s", msg)
+	s.keep(LevelError, msg)
+}
+
+// Debug logs a debug message about the session
+func (s *Session) Debug(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	logger.Debug("%s", msg)
+	s.keep(LevelDebug, msg)
+}
+
+// keep queues a line for the client, dropping it if the backlog is full
+func (s *Session) keep(level, msg string) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.lines <- Line{Time: time.Now(), Level: level, Msg: msg}:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Attach sends the lines of the session over dc once it opens, the ones
+// kept so far first, until it closes. A session forwards to one channel
+// only, further ones are refused.
+func (s *Session) Attach(dc *webrtc.DataChannel) {
+	if s == nil {
+		Refuse(dc, "This server does not forward its logs, start it with --remote-logs")
+		return
+	}
+	if !s.attached.CompareAndSwap(false, true) {
+		Refuse(dc, "The logs of this session are already forwarded")
+		return
+	}
+
+	closed := make(chan struct{})
+	dc.OnClose(func() { close(closed) })
+	dc.OnOpen(func() {
+		go func() {
+			for {
+				select {
+				case line := <-s.lines:
+					if n := s.dropped.Swap(0); n > 0 {
+						if send(dc, Line{Time: line.Time, Level: LevelInfo, Msg: fmt.Sprintf("Warning: %d log lines dropped", n)}) != nil {
+							return
+						}
+					}
+					if send(dc, line) != nil {
+						return
+					}
+				case <-closed:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// Refuse sends the reason a log channel is not served as its only line and
+// closes it
+func Refuse(dc *webrtc.DataChannel, reason string) {
+	dc.OnOpen(func() {
+		send(dc, Line{Time: time.Now(), Level: LevelError, Msg: reason})
+		dc.Close()
+	})
+}
+
+// send sends one line
+func send(dc *webrtc.DataChannel, line Line) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	return dc.SendText(string(data))
+}
+
+// Receive opens a log channel on pc and calls handle with every line the
+// server sends over it. A server that does not know the channel sends none.
+func Receive(pc *webrtc.PeerConnection, handle func(Line)) error {
+	protocol := Protocol
+	dc, err := pc.CreateDataChannel(Label, &webrtc.DataChannelInit{Protocol: &protocol})
+	if err != nil {
+		return err
+	}
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var line Line
+		if json.Unmarshal(msg.Data, &line) == nil {
+			handle(line)
+		}
+	})
+	return nil
+}
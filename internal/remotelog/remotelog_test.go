@@ -0,0 +1,115 @@
+package remotelog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// connect connects a client and a server peer connection, after the client
+// opened its log channel
+func connect(t *testing.T, serve func(*webrtc.DataChannel)) <-chan Line {
+	t.Helper()
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	server.OnDataChannel(func(dc *webrtc.DataChannel) {
+		if dc.Protocol() == Protocol {
+			serve(dc)
+		}
+	})
+	lines := make(chan Line, backlog)
+	if err := Receive(client, func(line Line) { lines <- line }); err != nil {
+		t.Fatalf("Receive returned error: %v", err)
+	}
+
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("CreateOffer returned error: %v", err)
+	}
+	client.SetLocalDescription(offer)
+	<-webrtc.GatheringCompletePromise(client)
+	server.SetRemoteDescription(*client.LocalDescription())
+	answer, err := server.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("CreateAnswer returned error: %v", err)
+	}
+	server.SetLocalDescription(answer)
+	<-webrtc.GatheringCompletePromise(server)
+	client.SetRemoteDescription(*server.LocalDescription())
+	return lines
+}
+
+// next waits for the next line
+func next(t *testing.T, lines <-chan Line) Line {
+	t.Helper()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for a log line")
+		return Line{}
+	}
+}
+
+func TestSession(t *testing.T) {
+	t.Run("Forwards", func(t *testing.T) {
+		// Lines logged before the channel opens are sent once it does
+		s := NewSession()
+		s.Info("Client identity: %s", "abc")
+		s.Debug("Raw offer received")
+		lines := connect(t, s.Attach)
+		s.Error("Aborting transfer: %v", "broken pipe")
+
+		for _, want := range []Line{
+			{Level: LevelInfo, Msg: "Client identity: abc"},
+			{Level: LevelDebug, Msg: "Raw offer received"},
+			{Level: LevelError, Msg: "Aborting transfer: broken pipe"},
+		} {
+			got := next(t, lines)
+			if got.Level != want.Level || got.Msg != want.Msg || got.Time.IsZero() {
+				t.Errorf("Expected %s %q, got %+v", want.Level, want.Msg, got)
+			}
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		var s *Session
+		s.Info("only logged locally")
+		lines := connect(t, s.Attach)
+		if got := next(t, lines); got.Level != LevelError || got.Msg == "" {
+			t.Errorf("Expected the reason the logs are refused, got %+v", got)
+		}
+	})
+
+	t.Run("AttachedOnce", func(t *testing.T) {
+		s := NewSession()
+		s.attached.Store(true)
+		lines := connect(t, s.Attach)
+		if got := next(t, lines); got.Level != LevelError {
+			t.Errorf("Expected a second channel to be refused, got %+v", got)
+		}
+	})
+}
+
+func TestBacklog(t *testing.T) {
+	s := NewSession()
+	for i := 0; i < backlog+5; i++ {
+		s.Debug("line %d", i)
+	}
+	if got := s.dropped.Load(); got != 5 {
+		t.Errorf("Expected 5 lines dropped past the backlog, got %d", got)
+	}
+	if got := len(s.lines); got != backlog {
+		t.Errorf("Expected %d lines kept, got %d", backlog, got)
+	}
+}
@@ -0,0 +1,167 @@
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// EnvURL is the environment variable the rendezvous URL is discovered from
+// when none is configured
+const EnvURL = "WEBRTC_POC_RENDEZVOUS"
+
+var (
+	// ErrUnknownCode is returned when the relay does not know a code
+	ErrUnknownCode = errors.New("unknown rendezvous code")
+	// ErrRejected is returned when the registrant rejected an offer
+	ErrRejected = errors.New("offer rejected by the peer")
+)
+
+// Discover returns the rendezvous URL to use: the configured one, or the one
+// set in the environment
+func Discover(configured string) (string, error) {
+	if configured == "" {
+		configured = os.Getenv(EnvURL)
+	}
+	if configured == "" {
+		return "", fmt.Errorf("no rendezvous URL configured (set --rendezvous or %s)", EnvURL)
+	}
+	if _, err := url.Parse(configured); err != nil {
+		return "", fmt.Errorf("invalid rendezvous URL: %w", err)
+	}
+	return strings.TrimSuffix(configured, "/"), nil
+}
+
+// Client talks to a rendezvous relay
+type Client struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// NewClient creates a client for the relay at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{URL: strings.TrimSuffix(baseURL, "/"), HTTP: http.DefaultClient}
+}
+
+// do sends a request and returns the response body for 200, 201 and 202
+// responses, or nil for 204 No Content
+func (c *Client) do(ctx context.Context, method, path, token string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("rendezvous request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading rendezvous response: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted:
+		return data, nil
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusNotFound:
+		return nil, ErrUnknownCode
+	case http.StatusGone:
+		return nil, ErrRejected
+	default:
+		return nil, fmt.Errorf("rendezvous server returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+}
+
+// Register registers a new code that peers can send offers to
+func (c *Client) Register(ctx context.Context) (Registration, error) {
+	var reg Registration
+	data, err := c.do(ctx, http.MethodPost, "/v1/codes", "", nil)
+	if err != nil {
+		return reg, err
+	}
+	if err := json.Unmarshal(data, &reg); err != nil {
+		return reg, fmt.Errorf("error parsing registration: %w", err)
+	}
+	return reg, nil
+}
+
+// Release gives up a registered code
+func (c *Client) Release(ctx context.Context, reg Registration) error {
+	_, err := c.do(ctx, http.MethodDelete, "/v1/codes/"+url.PathEscape(reg.Code), reg.Token, nil)
+	return err
+}
+
+// NextOffer waits until a peer sends an offer to the registered code
+func (c *Client) NextOffer(ctx context.Context, reg Registration) (Offer, error) {
+	for {
+		data, err := c.do(ctx, http.MethodGet, "/v1/codes/"+url.PathEscape(reg.Code)+"/offers", reg.Token, nil)
+		if err != nil {
+			return Offer{}, err
+		}
+		if data == nil {
+			continue
+		}
+
+		var offer Offer
+		if err := json.Unmarshal(data, &offer); err != nil {
+			return Offer{}, fmt.Errorf("error parsing offer: %w", err)
+		}
+		return offer, nil
+	}
+}
+
+// Answer sends the answer to an offer back to the peer
+func (c *Client) Answer(ctx context.Context, reg Registration, id string, answer []byte) error {
+	_, err := c.do(ctx, http.MethodPut, offerPath(reg.Code, id)+"/answer", reg.Token, answer)
+	return err
+}
+
+// Reject tells the peer its offer will not be answered
+func (c *Client) Reject(ctx context.Context, reg Registration, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, offerPath(reg.Code, id), reg.Token, nil)
+	return err
+}
+
+// Exchange sends an offer to the peer that registered code and waits for its
+// answer
+func (c *Client) Exchange(ctx context.Context, code string, offer []byte) ([]byte, error) {
+	code = NormalizeCode(code)
+	data, err := c.do(ctx, http.MethodPost, "/v1/codes/"+url.PathEscape(code)+"/offers", "", offer)
+	if err != nil {
+		return nil, err
+	}
+	var sent struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &sent); err != nil {
+		return nil, fmt.Errorf("error parsing rendezvous response: %w", err)
+	}
+
+	for {
+		answer, err := c.do(ctx, http.MethodGet, offerPath(code, sent.ID)+"/answer", "", nil)
+		if err != nil {
+			return nil, err
+		}
+		if answer != nil {
+			return answer, nil
+		}
+	}
+}
+
+// offerPath returns the path of an exchange
+func offerPath(code, id string) string {
+	return "/v1/codes/" + url.PathEscape(code) + "/offers/" + url.PathEscape(id)
+}
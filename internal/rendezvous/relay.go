@@ -0,0 +1,362 @@
+// Package rendezvous implements a minimal relay that lets two peers exchange
+// signaling blobs through a short code, for peers that cannot reach each
+// other's signaling endpoint directly.
+//
+// The protocol is plain HTTP:
+//
+//	POST   /v1/codes                              register a code (registrant)
+//	GET    /v1/codes/{code}/offers?wait=30s       wait for the next offer (registrant)
+//	PUT    /v1/codes/{code}/offers/{id}/answer    answer an offer (registrant)
+//	DELETE /v1/codes/{code}/offers/{id}           reject an offer (registrant)
+//	DELETE /v1/codes/{code}                       release the code (registrant)
+//	POST   /v1/codes/{code}/offers                send an offer (peer)
+//	GET    /v1/codes/{code}/offers/{id}/answer?wait=30s  wait for the answer (peer)
+//
+// Registrant requests carry the token returned on registration as a bearer
+// token. Waiting requests answer 204 No Content when nothing arrived in time,
+// so clients simply poll again. Blobs are opaque to the relay.
+package rendezvous
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxBlobSize is the largest offer or answer the relay accepts
+	MaxBlobSize = 64 * 1024
+	// MaxWait is the longest a waiting request is held open
+	MaxWait = 30 * time.Second
+	// maxCodes bounds the number of codes registered at once
+	maxCodes = 4096
+)
+
+// codeAlphabet leaves out characters that are easily confused when a code is
+// read out or typed
+const codeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+// Registration is returned to the peer that registers a code
+type Registration struct {
+	Code  string `json:"code"`
+	Token string `json:"token"`
+}
+
+// Offer is an offer waiting for the registrant to answer it
+type Offer struct {
+	ID   string `json:"id"`
+	Blob []byte `json:"blob"`
+}
+
+// exchange is one offer and its answer
+type exchange struct {
+	id       string
+	offer    []byte
+	answer   []byte
+	rejected bool
+	created  time.Time
+	done     chan struct{}
+}
+
+// room holds the exchanges of one registered code
+type room struct {
+	token     string
+	seen      time.Time
+	queued    []*exchange
+	exchanges map[string]*exchange
+	notify    chan struct{}
+}
+
+// Relay is the rendezvous server. Codes are forgotten when their registrant
+// has not polled for ttl, and exchanges ttl after their offer was sent.
+type Relay struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	rooms map[string]*room
+	mux   *http.ServeMux
+}
+
+// NewRelay creates a relay that forgets idle codes and exchanges after ttl
+func NewRelay(ttl time.Duration) *Relay {
+	r := &Relay{ttl: ttl, rooms: make(map[string]*room), mux: http.NewServeMux()}
+	r.mux.HandleFunc("POST /v1/codes", r.register)
+	r.mux.HandleFunc("DELETE /v1/codes/{code}", r.release)
+	r.mux.HandleFunc("POST /v1/codes/{code}/offers", r.sendOffer)
+	r.mux.HandleFunc("GET /v1/codes/{code}/offers", r.waitOffer)
+	r.mux.HandleFunc("DELETE /v1/codes/{code}/offers/{id}", r.reject)
+	r.mux.HandleFunc("PUT /v1/codes/{code}/offers/{id}/answer", r.sendAnswer)
+	r.mux.HandleFunc("GET /v1/codes/{code}/offers/{id}/answer", r.waitAnswer)
+	return r
+}
+
+// ServeHTTP implements http.Handler
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+// prune forgets idle codes and old exchanges; the caller holds the lock
+func (r *Relay) prune(now time.Time) {
+	for code, rm := range r.rooms {
+		if now.Sub(rm.seen) > r.ttl {
+			delete(r.rooms, code)
+			continue
+		}
+		for id, ex := range rm.exchanges {
+			if now.Sub(ex.created) > r.ttl {
+				delete(rm.exchanges, id)
+			}
+		}
+		queued := rm.queued[:0]
+		for _, ex := range rm.queued {
+			if _, ok := rm.exchanges[ex.id]; ok {
+				queued = append(queued, ex)
+			}
+		}
+		rm.queued = queued
+	}
+}
+
+// lookup returns the room for a request's code, checking the registrant's
+// token if owner is set. It writes the error response itself.
+func (r *Relay) lookup(w http.ResponseWriter, req *http.Request, owner bool) *room {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.prune(now)
+	rm, ok := r.rooms[NormalizeCode(req.PathValue("code"))]
+	if !ok {
+		http.Error(w, "Unknown code", http.StatusNotFound)
+		return nil
+	}
+	if owner {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(rm.token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return nil
+		}
+		rm.seen = now
+	}
+	return rm
+}
+
+func (r *Relay) register(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.prune(time.Now())
+	if len(r.rooms) >= maxCodes {
+		r.mu.Unlock()
+		http.Error(w, "Too many codes registered", http.StatusServiceUnavailable)
+		return
+	}
+	code := newCode()
+	for r.rooms[code] != nil {
+		code = newCode()
+	}
+	reg := Registration{Code: code, Token: newID()}
+	r.rooms[code] = &room{
+		token:     reg.Token,
+		seen:      time.Now(),
+		exchanges: make(map[string]*exchange),
+		notify:    make(chan struct{}, 1),
+	}
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(reg)
+}
+
+func (r *Relay) release(w http.ResponseWriter, req *http.Request) {
+	if r.lookup(w, req, true) == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.rooms, NormalizeCode(req.PathValue("code")))
+	r.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Relay) sendOffer(w http.ResponseWriter, req *http.Request) {
+	blob, ok := readBlob(w, req)
+	if !ok {
+		return
+	}
+	rm := r.lookup(w, req, false)
+	if rm == nil {
+		return
+	}
+
+	ex := &exchange{id: newID(), offer: blob, created: time.Now(), done: make(chan struct{})}
+	r.mu.Lock()
+	rm.exchanges[ex.id] = ex
+	rm.queued = append(rm.queued, ex)
+	select {
+	case rm.notify <- struct{}{}:
+	default:
+	}
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		ID string `json:"id"`
+	}{ex.id})
+}
+
+func (r *Relay) waitOffer(w http.ResponseWriter, req *http.Request) {
+	rm := r.lookup(w, req, true)
+	if rm == nil {
+		return
+	}
+
+	timer := time.NewTimer(waitFor(req))
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		if len(rm.queued) > 0 {
+			ex := rm.queued[0]
+			rm.queued = rm.queued[1:]
+			rm.seen = time.Now()
+			r.mu.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(Offer{ID: ex.id, Blob: ex.offer})
+			return
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-rm.notify:
+		case <-timer.C:
+			r.mu.Lock()
+			rm.seen = time.Now()
+			r.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// finish records the registrant's answer or rejection of an exchange
+func (r *Relay) finish(w http.ResponseWriter, req *http.Request, answer []byte) {
+	rm := r.lookup(w, req, true)
+	if rm == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ex, ok := rm.exchanges[req.PathValue("id")]
+	if !ok {
+		http.Error(w, "Unknown offer", http.StatusNotFound)
+		return
+	}
+	select {
+	case <-ex.done:
+		http.Error(w, "Offer already answered", http.StatusConflict)
+		return
+	default:
+	}
+	ex.answer, ex.rejected = answer, answer == nil
+	close(ex.done)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Relay) sendAnswer(w http.ResponseWriter, req *http.Request) {
+	if blob, ok := readBlob(w, req); ok {
+		r.finish(w, req, blob)
+	}
+}
+
+func (r *Relay) reject(w http.ResponseWriter, req *http.Request) {
+	r.finish(w, req, nil)
+}
+
+func (r *Relay) waitAnswer(w http.ResponseWriter, req *http.Request) {
+	rm := r.lookup(w, req, false)
+	if rm == nil {
+		return
+	}
+	r.mu.Lock()
+	ex, ok := rm.exchanges[req.PathValue("id")]
+	r.mu.Unlock()
+	if !ok {
+		http.Error(w, "Unknown offer", http.StatusNotFound)
+		return
+	}
+
+	timer := time.NewTimer(waitFor(req))
+	defer timer.Stop()
+	select {
+	case <-ex.done:
+	case <-timer.C:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case <-req.Context().Done():
+		return
+	}
+
+	// The exchange is over once the peer has its answer
+	r.mu.Lock()
+	delete(rm.exchanges, ex.id)
+	r.mu.Unlock()
+	if ex.rejected {
+		http.Error(w, "Offer rejected", http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(ex.answer)
+}
+
+// readBlob reads a request body of at most MaxBlobSize bytes
+func readBlob(w http.ResponseWriter, req *http.Request) ([]byte, bool) {
+	blob, err := io.ReadAll(http.MaxBytesReader(w, req.Body, MaxBlobSize))
+	if err != nil {
+		http.Error(w, "Failed to read body: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	return blob, true
+}
+
+// waitFor returns how long a waiting request may be held open
+func waitFor(req *http.Request) time.Duration {
+	wait, err := time.ParseDuration(req.URL.Query().Get("wait"))
+	if err != nil || wait <= 0 || wait > MaxWait {
+		return MaxWait
+	}
+	return wait
+}
+
+// NormalizeCode makes codes case-insensitive and ignores surrounding space
+func NormalizeCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+// newCode generates a code such as "k7m2-x9pq"
+func newCode() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	code := make([]byte, 0, 9)
+	for i, c := range b {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		code = append(code, codeAlphabet[int(c)%len(codeAlphabet)])
+	}
+	return string(code)
+}
+
+// newID generates a random identifier
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
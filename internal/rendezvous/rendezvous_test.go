@@ -0,0 +1,120 @@
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExchange(t *testing.T) {
+	relay := httptest.NewServer(NewRelay(time.Minute))
+	defer relay.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	registrant := NewClient(relay.URL)
+	peer := NewClient(relay.URL + "/")
+
+	reg, err := registrant.Register(ctx)
+	if err != nil {
+		t.Fatalf("Register returned error: %v", err)
+	}
+	if len(reg.Code) != 9 || reg.Token == "" {
+		t.Fatalf("Expected a code and a token, got %+v", reg)
+	}
+
+	t.Run("AnswerOffer", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() {
+			offer, err := registrant.NextOffer(ctx, reg)
+			if err != nil {
+				done <- err
+				return
+			}
+			if string(offer.Blob) != "offer" {
+				t.Errorf("Expected offer blob, got %q", offer.Blob)
+			}
+			done <- registrant.Answer(ctx, reg, offer.ID, []byte("answer"))
+		}()
+
+		// Codes are case-insensitive
+		answer, err := peer.Exchange(ctx, " "+strings.ToUpper(reg.Code), []byte("offer"))
+		if err != nil {
+			t.Fatalf("Exchange returned error: %v", err)
+		}
+		if string(answer) != "answer" {
+			t.Errorf("Expected answer blob, got %q", answer)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("Answer returned error: %v", err)
+		}
+	})
+
+	t.Run("RejectOffer", func(t *testing.T) {
+		go func() {
+			offer, err := registrant.NextOffer(ctx, reg)
+			if err == nil {
+				registrant.Reject(ctx, reg, offer.ID)
+			}
+		}()
+		if _, err := peer.Exchange(ctx, reg.Code, []byte("offer")); !errors.Is(err, ErrRejected) {
+			t.Errorf("Expected ErrRejected, got %v", err)
+		}
+	})
+
+	t.Run("WrongToken", func(t *testing.T) {
+		stolen := Registration{Code: reg.Code, Token: "guess"}
+		if _, err := registrant.NextOffer(ctx, stolen); err == nil {
+			t.Error("Expected an error when polling with the wrong token")
+		}
+	})
+
+	t.Run("UnknownCode", func(t *testing.T) {
+		if _, err := peer.Exchange(ctx, "aaaa-aaaa", []byte("offer")); !errors.Is(err, ErrUnknownCode) {
+			t.Errorf("Expected ErrUnknownCode, got %v", err)
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		if err := registrant.Release(ctx, reg); err != nil {
+			t.Fatalf("Release returned error: %v", err)
+		}
+		if _, err := peer.Exchange(ctx, reg.Code, []byte("offer")); !errors.Is(err, ErrUnknownCode) {
+			t.Errorf("Expected ErrUnknownCode after release, got %v", err)
+		}
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	relay := httptest.NewServer(NewRelay(-time.Second))
+	defer relay.Close()
+
+	client := NewClient(relay.URL)
+	reg, err := client.Register(context.Background())
+	if err != nil {
+		t.Fatalf("Register returned error: %v", err)
+	}
+	if _, err := client.Exchange(context.Background(), reg.Code, []byte("offer")); !errors.Is(err, ErrUnknownCode) {
+		t.Errorf("Expected an idle code to expire, got %v", err)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	os.Unsetenv(EnvURL)
+	if _, err := Discover(""); err == nil {
+		t.Error("Expected an error when no URL is configured")
+	}
+
+	t.Setenv(EnvURL, "https://relay.example.com/")
+	if got, _ := Discover(""); got != "https://relay.example.com" {
+		t.Errorf("Expected the URL from the environment, got %s", got)
+	}
+	if got, _ := Discover("http://localhost:9000"); got != "http://localhost:9000" {
+		t.Errorf("Expected the configured URL to win, got %s", got)
+	}
+}
@@ -0,0 +1,214 @@
+// Package retention keeps a bounded log of when the lines of a followed file
+// arrived, so a client can replay the stream from a point in time before it
+// goes live. Lines are numbered by their sequence number, the number of
+// lines before them in the file, like the offsets of consumer groups. The
+// log marks the first line that arrived in every second, and drops the
+// marks older than the retention period. It is appended to as lines arrive
+// and rewritten once most of it was dropped, so it stays small however long
+// the file is followed.
+package retention
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Mark is the first line that arrived in a second
+type Mark struct {
+	Seq  int
+	Time time.Time
+}
+
+// Log is the retention log of one followed file
+type Log struct {
+	path      string
+	file      string
+	retention time.Duration
+
+	mu sync.Mutex
+	// marks are in order, the oldest first, and seen is the number of
+	// lines recorded
+	marks []Mark
+	seen  int
+	// dropped counts the marks in the file that expired since it was last
+	// written
+	dropped int
+	out     *os.File
+}
+
+// DefaultDir returns the default directory of the retention logs
+func DefaultDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding config directory: %w", err)
+	}
+	return filepath.Join(dir, "webrtc-poc", "retention"), nil
+}
+
+// Path returns the path of the retention log of file in dir, one per file
+func Path(dir, file string) string {
+	sum := sha256.Sum256([]byte(file))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".log")
+}
+
+// Open reads the retention log at path of file, which holds lines lines
+// now. A file with fewer lines than the log recorded was replaced, so its
+// log starts again. Lines appended while the log was closed count as
+// arriving at the time of its last mark.
+func Open(path, file string, retention time.Duration, lines int) (*Log, error) {
+	if retention <= 0 {
+		return nil, errors.New("retention must be positive")
+	}
+	l := &Log{path: path, file: file, retention: retention}
+	if err := l.read(); err != nil {
+		return nil, err
+	}
+	if len(l.marks) > 0 && lines < l.marks[len(l.marks)-1].Seq {
+		l.marks = nil
+	}
+	l.seen = lines
+	l.expire(time.Now())
+	if err := l.rewrite(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// read loads the marks saved at l.path, if they belong to l.file
+func (l *Log) read() error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading retention log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || scanner.Text() != l.file {
+		return scanner.Err()
+	}
+	for scanner.Scan() {
+		var m Mark
+		var millis int64
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d", &m.Seq, &millis); err != nil {
+			return fmt.Errorf("error parsing retention log %s: %w", l.path, err)
+		}
+		m.Time = time.UnixMilli(millis)
+		l.marks = append(l.marks, m)
+	}
+	return scanner.Err()
+}
+
+// Record notes that the file holds lines lines at the time at, marking the
+// first of the new ones unless a line already arrived in the same second. A
+// closed log records nothing.
+func (l *Log) Record(lines int, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lines <= l.seen || l.out == nil {
+		return nil
+	}
+	seq := l.seen
+	l.seen = lines
+	if n := len(l.marks); n > 0 && at.Truncate(time.Second).Equal(l.marks[n-1].Time.Truncate(time.Second)) {
+		return nil
+	}
+
+	m := Mark{Seq: seq, Time: at}
+	l.marks = append(l.marks, m)
+	l.expire(at)
+	if l.dropped > len(l.marks) {
+		return l.rewrite()
+	}
+	if _, err := fmt.Fprintf(l.out, "%d %d\n", m.Seq, m.Time.UnixMilli()); err != nil {
+		return fmt.Errorf("error writing retention log: %w", err)
+	}
+	return nil
+}
+
+// expire drops the marks older than the retention period. l.mu must be
+// held, or l not shared yet.
+func (l *Log) expire(now time.Time) {
+	cutoff := now.Add(-l.retention)
+	n := 0
+	for n < len(l.marks) && l.marks[n].Time.Before(cutoff) {
+		n++
+	}
+	l.marks = l.marks[n:]
+	l.dropped += n
+}
+
+// rewrite replaces the log with the marks kept, atomically so a crash never
+// leaves a truncated one behind, and appends to it from then on
+func (l *Log) rewrite() error {
+	if l.out != nil {
+		l.out.Close()
+		l.out = nil
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("error creating retention log directory: %w", err)
+	}
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing retention log: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, l.file)
+	for _, m := range l.marks {
+		fmt.Fprintf(w, "%d %d\n", m.Seq, m.Time.UnixMilli())
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing retention log: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing retention log: %w", err)
+	}
+	l.out, l.dropped = f, 0
+	return nil
+}
+
+// Since returns the sequence number of the first line that arrived at t or
+// later. Lines older than the retention period are not replayed, so a time
+// before the oldest mark starts at it. A time after every line starts with
+// the next line to arrive.
+func (l *Log) Since(t time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.marks {
+		if !m.Time.Before(t.Truncate(time.Second)) {
+			return m.Seq
+		}
+	}
+	return l.seen
+}
+
+// Marks returns the marks kept, the oldest first
+func (l *Log) Marks() []Mark {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Mark(nil), l.marks...)
+}
+
+// Close closes the log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.out == nil {
+		return nil
+	}
+	err := l.out.Close()
+	l.out = nil
+	return err
+}
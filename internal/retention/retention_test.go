@@ -0,0 +1,127 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	path := Path(t.TempDir(), "/var/log/app.log")
+	start := time.Now().Truncate(time.Second)
+	l, err := Open(path, "/var/log/app.log", time.Hour, 10)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+
+	// Lines 10 to 14 arrive in one second, 15 to 19 in the next and 20 a
+	// minute later
+	for _, r := range []struct {
+		lines int
+		at    time.Time
+	}{
+		{12, start},
+		{15, start.Add(500 * time.Millisecond)},
+		{20, start.Add(time.Second)},
+		{21, start.Add(time.Minute)},
+		{21, start.Add(2 * time.Minute)},
+	} {
+		if err := l.Record(r.lines, r.at); err != nil {
+			t.Fatalf("Record returned error: %v", err)
+		}
+	}
+	if marks := l.Marks(); len(marks) != 3 || marks[0].Seq != 10 || marks[1].Seq != 15 || marks[2].Seq != 20 {
+		t.Errorf("Expected a mark per second, got %+v", marks)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		since time.Time
+		want  int
+	}{
+		{"BeforeRetention", start.Add(-24 * time.Hour), 10},
+		{"FirstSecond", start.Add(300 * time.Millisecond), 10},
+		{"SecondSecond", start.Add(time.Second), 15},
+		{"Between", start.Add(30 * time.Second), 20},
+		{"Live", start.Add(time.Hour), 21},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := l.Since(tt.since); got != tt.want {
+				t.Errorf("Expected sequence number %d, got %d", tt.want, got)
+			}
+		})
+	}
+	l.Close()
+
+	// The marks survive a restart, and lines appended meanwhile count as
+	// arriving with the last mark
+	l, err = Open(path, "/var/log/app.log", time.Hour, 25)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if marks := l.Marks(); len(marks) != 3 {
+		t.Errorf("Expected the 3 marks saved, got %+v", marks)
+	}
+	if got := l.Since(start.Add(time.Hour)); got != 25 {
+		t.Errorf("Expected to go live at 25, got %d", got)
+	}
+	l.Close()
+
+	// A file with fewer lines than were recorded was replaced
+	l, err = Open(path, "/var/log/app.log", time.Hour, 3)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if marks := l.Marks(); len(marks) != 0 {
+		t.Errorf("Expected the marks of the replaced file to be dropped, got %+v", marks)
+	}
+	l.Close()
+
+	// The log of another file does not apply
+	other, err := Open(path, "/var/log/other.log", time.Hour, 25)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if marks := other.Marks(); len(marks) != 0 {
+		t.Errorf("Expected no marks for another file, got %+v", marks)
+	}
+	other.Close()
+}
+
+func TestLogRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	start := time.Now()
+	l, err := Open(path, "app.log", time.Minute, 0)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer l.Close()
+
+	// A line every second for ten minutes keeps a minute of marks
+	for i := 1; i <= 600; i++ {
+		if err := l.Record(i, start.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("Record returned error: %v", err)
+		}
+	}
+	marks := l.Marks()
+	if len(marks) > 61 || marks[len(marks)-1].Seq != 599 {
+		t.Errorf("Expected a minute of marks up to line 599, got %d up to %+v", len(marks), marks[len(marks)-1])
+	}
+	if got := l.Since(start); got != marks[0].Seq {
+		t.Errorf("Expected to replay from the oldest mark %d, got %d", marks[0].Seq, got)
+	}
+
+	// The file on disk stays bounded as well
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the log: %v", err)
+	}
+	if size := len(data); size > 3*61*len("599 1700000000000\n") {
+		t.Errorf("Expected the log to be compacted, got %d bytes", size)
+	}
+
+	if _, err := Open(path, "app.log", 0, 0); err == nil {
+		t.Error("Expected an error for a retention of zero")
+	}
+}
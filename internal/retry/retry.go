@@ -0,0 +1,83 @@
+// Package retry retries the sends of a transfer that failed for reasons that
+// may pass, like a full SCTP send buffer, instead of aborting the transfer on
+// the first error. Every retry waits a random time up to a backoff that
+// doubles with each retry, so transfers that failed together do not retry in
+// lockstep, and the sends that cannot succeed, like those on a closed
+// channel, are not retried at all.
+package retry
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+)
+
+var (
+	// retriesTotal counts the sends tried again
+	retriesTotal = metrics.NewCounter("webrtc_poc_send_retries_total",
+		"Sends tried again after failing with an error that may pass")
+	// exhaustedTotal counts the sends given up on once every retry failed
+	exhaustedTotal = metrics.NewCounter("webrtc_poc_send_retries_exhausted_total",
+		"Sends given up on after every retry failed")
+)
+
+// Policy bounds how often and how long a failed send is retried
+type Policy struct {
+	// Attempts is the most times a send is retried after it failed, 0 to
+	// never retry
+	Attempts int
+	// Backoff is the longest wait before the first retry, doubling for each
+	// one after it
+	Backoff time.Duration
+	// Max caps the longest wait, 0 for no cap
+	Max time.Duration
+	// Retryable reports whether a send that failed with an error may succeed
+	// when tried again; nil retries every error
+	Retryable func(err error) bool
+	// OnRetry, if set, is called before every retry with its number, from
+	// 1, and the error of the send before
+	OnRetry func(retry int, err error)
+}
+
+// sleep waits between retries, replaced in tests
+var sleep = time.Sleep
+
+// Do calls send until it succeeds, fails with an error that is not
+// retryable or failed Attempts more times. It returns the error of the last
+// send, saying how often it was retried if it was.
+func (p Policy) Do(send func() error) error {
+	err := send()
+	for retry := 1; err != nil && retry <= p.Attempts; retry++ {
+		if p.Retryable != nil && !p.Retryable(err) {
+			return err
+		}
+		retriesTotal.Inc()
+		if p.OnRetry != nil {
+			p.OnRetry(retry, err)
+		}
+		sleep(p.Delay(retry))
+		if err = send(); err != nil && retry == p.Attempts {
+			exhaustedTotal.Inc()
+			return fmt.Errorf("gave up after %d retries: %w", retry, err)
+		}
+	}
+	return err
+}
+
+// Delay returns the random wait before a retry, from 0 up to Backoff
+// doubled for every retry before it and capped at Max
+func (p Policy) Delay(retry int) time.Duration {
+	limit := p.Backoff
+	for i := 1; i < retry && (p.Max <= 0 || limit < p.Max); i++ {
+		limit *= 2
+	}
+	if p.Max > 0 {
+		limit = min(limit, p.Max)
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit + 1)
+}
@@ -0,0 +1,91 @@
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// failing returns a send failing with the errors in turn, then succeeding,
+// and the number of times it was called
+func failing(errs ...error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	}, &calls
+}
+
+func TestDo(t *testing.T) {
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	defer func() { sleep = time.Sleep }()
+
+	full := errors.New("buffer full")
+	closed := errors.New("closed")
+	p := Policy{
+		Attempts:  3,
+		Backoff:   time.Millisecond,
+		Retryable: func(err error) bool { return err != closed },
+	}
+
+	send, calls := failing(full, full)
+	retries := retriesTotal.Value()
+	var seen []int
+	p.OnRetry = func(retry int, err error) { seen = append(seen, retry) }
+	if err := p.Do(send); err != nil || *calls != 3 {
+		t.Errorf("Expected success on the third send, got %v after %d", err, *calls)
+	}
+	if got := retriesTotal.Value() - retries; got != 2 || len(seen) != 2 || seen[1] != 2 {
+		t.Errorf("Expected 2 retries counted and reported, got %d and %v", got, seen)
+	}
+	if len(waits) != 2 || waits[0] > time.Millisecond || waits[1] > 2*time.Millisecond {
+		t.Errorf("Expected waits within the doubling backoff, got %v", waits)
+	}
+	p.OnRetry = nil
+
+	// Errors that cannot pass are returned right away
+	send, calls = failing(full, closed, full)
+	if err := p.Do(send); err != closed || *calls != 2 {
+		t.Errorf("Expected the closed error on the second send, got %v after %d", err, *calls)
+	}
+
+	// Exhausting the retries gives up with the last error
+	exhausted := exhaustedTotal.Value()
+	send, calls = failing(full, full, full, full, full)
+	if err := p.Do(send); !errors.Is(err, full) || err.Error() != "gave up after 3 retries: buffer full" || *calls != 4 {
+		t.Errorf("Expected to give up after 3 retries, got %v after %d", err, *calls)
+	}
+	if exhaustedTotal.Value()-exhausted != 1 {
+		t.Error("Expected the exhausted send to be counted")
+	}
+
+	// No attempts never retries
+	send, calls = failing(full)
+	if err := (Policy{}).Do(send); err != full || *calls != 1 {
+		t.Errorf("Expected the first error without retries, got %v after %d", err, *calls)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := Policy{Backoff: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	for retry, limit := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 40 * time.Millisecond, 4: 50 * time.Millisecond, 60: 50 * time.Millisecond} {
+		var longest time.Duration
+		for range 1000 {
+			d := p.Delay(retry)
+			if d < 0 || d > limit {
+				t.Fatalf("Expected retry %d to wait up to %v, got %v", retry, limit, d)
+			}
+			longest = max(longest, d)
+		}
+		if longest < limit/2 {
+			t.Errorf("Expected retry %d to wait up to %v with jitter, longest %v", retry, limit, longest)
+		}
+	}
+	if d := (Policy{}).Delay(3); d != 0 {
+		t.Errorf("Expected no wait without a backoff, got %v", d)
+	}
+}
@@ -0,0 +1,66 @@
+// Package sample embeds the file the server streams by default, so a binary
+// installed with go install runs its demo without any files next to it.
+package sample
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Name is the file the server streams by default
+const Name = "sample.txt"
+
+// Content is the sample file
+//
+//go:embed sample.txt
+var Content []byte
+
+// Extract writes the sample into dir, unless it holds it already, and
+// returns the path of the copy
+func Extract(dir string) (string, error) {
+	path := filepath.Join(dir, Name)
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, Content) {
+		return path, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	// Write a temporary file first, so a server streaming the copy never
+	// reads it half written
+	f, err := os.CreateTemp(dir, Name+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(Content); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(f.Name(), path)
+}
+
+// Builtin reports whether filename names the default sample file while
+// there is none in the working directory, so the embedded one is streamed
+func Builtin(filename string) bool {
+	if filename != Name {
+		return false
+	}
+	_, err := os.Stat(filename)
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// ExtractCached writes the sample into the user's cache directory, or the
+// temporary directory without one, and returns the path of the copy
+func ExtractCached() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+	return Extract(filepath.Join(base, "webrtc-poc"))
+}
@@ -0,0 +1,49 @@
+package sample
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	if !bytes.HasPrefix(Content, []byte("This is line 1 of the sample file.\n")) {
+		t.Fatalf("Expected the sample file to be embedded, got %q", Content)
+	}
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	path, err := Extract(dir)
+	if err != nil {
+		t.Fatalf("Extract returned error: %v", err)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, Content) {
+		t.Fatalf("Expected the sample at %s, got %v", path, err)
+	}
+
+	// A modified copy is replaced, and nothing else is left in dir
+	os.WriteFile(path, []byte("changed\n"), 0644)
+	if _, err := Extract(dir); err != nil {
+		t.Fatalf("Extract returned error: %v", err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, Content) {
+		t.Errorf("Expected the modified copy to be replaced, got %q", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected only the sample in %s, got %d entries", dir, len(entries))
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if !Builtin(Name) {
+		t.Errorf("Expected %s to be the built-in sample without one in the working directory", Name)
+	}
+	if Builtin("other.txt") {
+		t.Error("Expected only the default file to fall back to the built-in sample")
+	}
+	os.WriteFile(Name, []byte("local\n"), 0644)
+	if Builtin(Name) {
+		t.Errorf("Expected the %s in the working directory to be streamed", Name)
+	}
+}
@@ -0,0 +1,178 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression. It supports the standard five fields
+// (minute, hour, day of month, month, day of week) with *, lists, ranges and
+// steps, the @hourly, @daily, @weekly, @monthly and @yearly shorthands, and
+// "@every <duration>" for fixed intervals.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record unrestricted day fields, which changes how
+	// the two day fields combine
+	domAny, dowAny bool
+	every          time.Duration
+}
+
+// fieldRange describes the allowed values of a cron field
+type fieldRange struct {
+	name     string
+	min, max int
+}
+
+var (
+	minuteRange = fieldRange{"minute", 0, 59}
+	hourRange   = fieldRange{"hour", 0, 23}
+	domRange    = fieldRange{"day of month", 1, 31}
+	monthRange  = fieldRange{"month", 1, 12}
+	dowRange    = fieldRange{"day of week", 0, 7}
+)
+
+var shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+
+	if rest, ok := strings.CutPrefix(expr, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid cron expression %q: bad interval", expr)
+		}
+		return &Cron{every: d}, nil
+	}
+	if full, ok := shorthands[expr]; ok {
+		expr = full
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	c := &Cron{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	for i, f := range []struct {
+		bits *uint64
+		r    fieldRange
+	}{
+		{&c.minute, minuteRange},
+		{&c.hour, hourRange},
+		{&c.dom, domRange},
+		{&c.month, monthRange},
+		{&c.dow, dowRange},
+	} {
+		if *f.bits, err = parseField(fields[i], f.r); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+	}
+
+	// Sunday may be written as 0 or 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseField parses one comma separated cron field into a bit set
+func parseField(field string, r fieldRange) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		spec, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step %q in %s field", stepText, r.name)
+			}
+		}
+
+		lo, hi := r.min, r.max
+		if spec != "*" {
+			loText, hiText, isRange := strings.Cut(spec, "-")
+			var err error
+			if lo, err = strconv.Atoi(loText); err != nil {
+				return 0, fmt.Errorf("bad value %q in %s field", spec, r.name)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiText); err != nil {
+					return 0, fmt.Errorf("bad value %q in %s field", spec, r.name)
+				}
+			} else if hasStep {
+				hi = r.max
+			}
+		}
+		if lo < r.min || hi > r.max || lo > hi {
+			return 0, fmt.Errorf("%s field %q out of range %d-%d", r.name, part, r.min, r.max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t that matches the expression
+func (c *Cron) Next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every)
+	}
+
+	// Start at the next whole minute and search at most five years ahead
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the cron rule that a day matches if either day field
+// matches when both are restricted
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
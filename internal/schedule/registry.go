@@ -0,0 +1,161 @@
+package schedule
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUnknownPeer is returned for peers that were not pre-registered
+var ErrUnknownPeer = errors.New("unknown peer")
+
+// Push is a file waiting to be sent to a daemon mode peer
+type Push struct {
+	ID   string `json:"id"`
+	Peer string `json:"peer"`
+	File string `json:"file"`
+}
+
+// peer holds the pushes queued for one daemon mode client
+type peer struct {
+	pending []Push
+	notify  chan struct{}
+	// polls is the number of polls waiting, and seen when the peer last
+	// polled or claimed a push
+	polls int
+	seen  time.Time
+}
+
+// Registry tracks the pre-registered daemon mode peers and the pushes
+// waiting for them. Peers poll with Wait and then connect to claim the push.
+type Registry struct {
+	mu     sync.Mutex
+	peers  map[string]*peer
+	claims map[string]Push
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{peers: make(map[string]*peer), claims: make(map[string]Push)}
+}
+
+// Register pre-registers a peer name so it can receive pushes
+func (r *Registry) Register(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.peers[name]; !ok {
+		r.peers[name] = &peer{notify: make(chan struct{}, 1)}
+	}
+}
+
+// Push queues file for the named peer
+func (r *Registry) Push(name, file string) (Push, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.peers[name]
+	if !ok {
+		return Push{}, ErrUnknownPeer
+	}
+
+	push := Push{ID: newID(), Peer: name, File: file}
+	p.pending = append(p.pending, push)
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+	return push, nil
+}
+
+// Wait blocks until a push is queued for the named peer or timeout expires.
+// A returned push can be claimed with Claim until it is completed.
+func (r *Registry) Wait(name string, timeout time.Duration) (Push, bool, error) {
+	r.mu.Lock()
+	p, ok := r.peers[name]
+	if ok {
+		p.polls++
+	}
+	r.mu.Unlock()
+	if !ok {
+		return Push{}, false, ErrUnknownPeer
+	}
+	defer func() {
+		r.mu.Lock()
+		p.polls--
+		p.seen = time.Now()
+		r.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		r.mu.Lock()
+		if len(p.pending) > 0 {
+			push := p.pending[0]
+			p.pending = p.pending[1:]
+			r.claims[push.ID] = push
+			r.mu.Unlock()
+			return push, true, nil
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-p.notify:
+		case <-timer.C:
+			return Push{}, false, nil
+		}
+	}
+}
+
+// Claim returns the push a peer was told about. A push can be claimed again
+// to resume an interrupted transfer until it is completed.
+func (r *Registry) Claim(id string) (Push, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	push, ok := r.claims[id]
+	if p := r.peers[push.Peer]; ok && p != nil {
+		p.seen = time.Now()
+	}
+	return push, ok
+}
+
+// Connected reports whether the named peer is polling for pushes, polled or
+// claimed a push within idle, or has a push that was not completed yet
+func (r *Registry) Connected(name string, idle time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.peers[name]
+	if !ok {
+		return false, ErrUnknownPeer
+	}
+	if p.polls > 0 || time.Since(p.seen) < idle {
+		return true, nil
+	}
+	for _, push := range r.claims {
+		if push.Peer == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Complete forgets a push once it has been delivered
+func (r *Registry) Complete(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.claims, id)
+}
+
+// newID returns a random push identifier that is hard to guess
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
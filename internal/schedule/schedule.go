@@ -0,0 +1,101 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+)
+
+// Entry pushes a file to a set of peers whenever its cron expression fires
+type Entry struct {
+	Cron  *Cron
+	Spec  string
+	File  string
+	Peers []string
+}
+
+// NewEntry parses spec and creates an entry for it
+func NewEntry(spec, file string, peers []string) (Entry, error) {
+	cron, err := ParseCron(spec)
+	if err != nil {
+		return Entry{}, err
+	}
+	if file == "" {
+		return Entry{}, fmt.Errorf("schedule %q has no file", spec)
+	}
+	if len(peers) == 0 {
+		return Entry{}, fmt.Errorf("schedule %q has no peers", spec)
+	}
+	return Entry{Cron: cron, Spec: spec, File: file, Peers: peers}, nil
+}
+
+// Scheduler queues pushes in a registry as entries come due
+type Scheduler struct {
+	entries  []Entry
+	registry *Registry
+	now      func() time.Time
+}
+
+// NewScheduler creates a scheduler and pre-registers every peer it pushes to
+func NewScheduler(entries []Entry, registry *Registry) *Scheduler {
+	for _, entry := range entries {
+		for _, name := range entry.Peers {
+			registry.Register(name)
+		}
+	}
+	return &Scheduler{entries: entries, registry: registry, now: time.Now}
+}
+
+// Run fires entries as they come due until stop is closed
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	if len(s.entries) == 0 {
+		return
+	}
+
+	next := make([]time.Time, len(s.entries))
+	for i, entry := range s.entries {
+		next[i] = entry.Cron.Next(s.now())
+		logger.Info("Scheduled push of %s to %v (%s), next at %s",
+			entry.File, entry.Peers, entry.Spec, next[i].Format(time.RFC3339))
+	}
+
+	for {
+		// Sleep until the earliest entry is due
+		earliest := 0
+		for i := range next {
+			if next[i].Before(next[earliest]) {
+				earliest = i
+			}
+		}
+
+		timer := time.NewTimer(time.Until(next[earliest]))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now := s.now()
+		for i, entry := range s.entries {
+			if next[i].After(now) {
+				continue
+			}
+			s.fire(entry)
+			next[i] = entry.Cron.Next(now)
+		}
+	}
+}
+
+// fire queues an entry's file for each of its peers
+func (s *Scheduler) fire(entry Entry) {
+	for _, name := range entry.Peers {
+		push, err := s.registry.Push(name, entry.File)
+		if err != nil {
+			logger.Error("Failed to push %s to %s: %v", entry.File, name, err)
+			continue
+		}
+		logger.Info("Queued scheduled push %s of %s to %s", push.ID, entry.File, name)
+	}
+}
@@ -0,0 +1,173 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 30, 0, time.UTC) // a Tuesday
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 2, 15, 5, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2024, 1, 2, 16, 0, 0, 0, time.UTC)},
+		{"*/15 9-17 * * *", time.Date(2024, 1, 2, 15, 15, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 1, 3, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 0", time.Date(2024, 1, 7, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2024, 1, 7, 12, 0, 0, 0, time.UTC)},
+		{"0 12 15 * 5", time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", start.Add(90 * time.Second)},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q) returned error: %v", tt.expr, err)
+			continue
+		}
+		if got := c.Next(start); !got.Equal(tt.want) {
+			t.Errorf("Expected %q to run next at %v, got %v", tt.expr, tt.want, got)
+		}
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every soon"} {
+			if _, err := ParseCron(expr); err == nil {
+				t.Errorf("Expected an error for %q", expr)
+			}
+		}
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Register("edge-1")
+
+	t.Run("UnknownPeer", func(t *testing.T) {
+		if _, err := r.Push("nobody", "file.txt"); !errors.Is(err, ErrUnknownPeer) {
+			t.Errorf("Expected ErrUnknownPeer, got %v", err)
+		}
+		if _, _, err := r.Wait("nobody", time.Millisecond); !errors.Is(err, ErrUnknownPeer) {
+			t.Errorf("Expected ErrUnknownPeer, got %v", err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		_, ok, err := r.Wait("edge-1", 10*time.Millisecond)
+		if err != nil || ok {
+			t.Errorf("Expected the poll to time out, got ok=%v err=%v", ok, err)
+		}
+	})
+
+	t.Run("QueuedPush", func(t *testing.T) {
+		pushed, err := r.Push("edge-1", "file.txt")
+		if err != nil {
+			t.Fatalf("Push returned error: %v", err)
+		}
+
+		push, ok, err := r.Wait("edge-1", time.Second)
+		if err != nil || !ok || push.ID != pushed.ID || push.File != "file.txt" {
+			t.Fatalf("Expected push %s, got %+v (ok=%v err=%v)", pushed.ID, push, ok, err)
+		}
+
+		// A push can be claimed again to resume it until it is completed
+		for i := 0; i < 2; i++ {
+			if claimed, ok := r.Claim(push.ID); !ok || claimed.File != "file.txt" {
+				t.Errorf("Expected to claim push %s, got %+v (ok=%v)", push.ID, claimed, ok)
+			}
+		}
+		r.Complete(push.ID)
+		if _, ok := r.Claim(push.ID); ok {
+			t.Error("Expected a completed push to be gone")
+		}
+	})
+
+	t.Run("WakesWaitingPeer", func(t *testing.T) {
+		done := make(chan Push)
+		go func() {
+			push, _, _ := r.Wait("edge-1", time.Second)
+			done <- push
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		r.Push("edge-1", "late.txt")
+
+		if push := <-done; push.File != "late.txt" {
+			t.Errorf("Expected late.txt, got %+v", push)
+		}
+	})
+
+	t.Run("Connected", func(t *testing.T) {
+		r := NewRegistry()
+		r.Register("edge-2")
+		if _, err := r.Connected("nobody", time.Minute); !errors.Is(err, ErrUnknownPeer) {
+			t.Errorf("Expected ErrUnknownPeer, got %v", err)
+		}
+		if ok, _ := r.Connected("edge-2", time.Minute); ok {
+			t.Error("Expected a peer that never polled to be disconnected")
+		}
+
+		// Connected while polling, and for idle after it
+		polled := make(chan Push)
+		go func() {
+			push, _, _ := r.Wait("edge-2", time.Second)
+			polled <- push
+		}()
+		time.Sleep(10 * time.Millisecond)
+		if ok, _ := r.Connected("edge-2", 0); !ok {
+			t.Error("Expected a polling peer to be connected")
+		}
+		r.Push("edge-2", "file.txt")
+		push := <-polled
+		if ok, _ := r.Connected("edge-2", time.Minute); !ok {
+			t.Error("Expected a peer that just polled to be connected")
+		}
+
+		// And while its push is not completed
+		time.Sleep(5 * time.Millisecond)
+		if ok, _ := r.Connected("edge-2", time.Millisecond); !ok {
+			t.Error("Expected a peer with a push in progress to be connected")
+		}
+		r.Complete(push.ID)
+		if ok, _ := r.Connected("edge-2", time.Millisecond); ok {
+			t.Error("Expected an idle peer to be disconnected")
+		}
+	})
+}
+
+func TestScheduler(t *testing.T) {
+	entry, err := NewEntry("@every 10ms", "file.txt", []string{"edge-1", "edge-2"})
+	if err != nil {
+		t.Fatalf("NewEntry returned error: %v", err)
+	}
+
+	r := NewRegistry()
+	s := NewScheduler([]Entry{entry}, r)
+
+	stop := make(chan struct{})
+	go s.Run(stop)
+	defer close(stop)
+
+	for _, name := range []string{"edge-1", "edge-2"} {
+		push, ok, err := r.Wait(name, time.Second)
+		if err != nil || !ok || push.File != "file.txt" {
+			t.Errorf("Expected a push of file.txt to %s, got %+v (ok=%v err=%v)", name, push, ok, err)
+		}
+	}
+
+	t.Run("InvalidEntry", func(t *testing.T) {
+		if _, err := NewEntry("@hourly", "", []string{"edge-1"}); err == nil {
+			t.Error("Expected an error for an entry without a file")
+		}
+		if _, err := NewEntry("@hourly", "file.txt", nil); err == nil {
+			t.Error("Expected an error for an entry without peers")
+		}
+	})
+}
@@ -0,0 +1,180 @@
+// Package sctperr turns the errors pion's SCTP and data channel stack
+// reports into a few kinds of typed errors, so a failed send or read says
+// whether the peer reset the channel, aborted the association or broke the
+// protocol instead of passing on whatever the lowest layer returned, and
+// counts them by kind.
+package sctperr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+	"github.com/pion/datachannel"
+	"github.com/pion/sctp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Kind is the kind of an SCTP error, also used as its metric label
+type Kind string
+
+// Error kinds
+const (
+	// Reset means the peer closed or reset the data channel's stream
+	Reset Kind = "reset"
+	// Aborted means the peer aborted the whole association
+	Aborted Kind = "abort"
+	// ProtocolViolation means either side received something SCTP or the
+	// data channel protocol does not allow and gave up on the association
+	ProtocolViolation Kind = "protocol_violation"
+	// Closed means the association or the peer connection is gone
+	Closed Kind = "closed"
+	// TooLarge means a message exceeded the maximum message size
+	TooLarge Kind = "too_large"
+	// Other is any other error
+	Other Kind = "other"
+)
+
+// errorsTotal counts the errors passed through Wrap by kind
+var errorsTotal = metrics.NewCounterVec("webrtc_poc_sctp_errors_total",
+	"SCTP and data channel errors by kind", "kind")
+
+// protocolViolations are the errors pion gives up on an association for
+// because the peer sent something it must not
+var protocolViolations = []error{
+	sctp.ErrChunkTypeUnhandled,
+	sctp.ErrInitChunkBundled,
+	sctp.ErrInitChunkVerifyTagNotZero,
+	sctp.ErrSCTPPacketSourcePortZero,
+	sctp.ErrSCTPPacketDestinationPortZero,
+	sctp.ErrProtocolViolationUnmarshal,
+	datachannel.ErrDataChannelMessageTooShort,
+	datachannel.ErrInvalidPayloadProtocolIdentifier,
+	datachannel.ErrInvalidMessageType,
+	datachannel.ErrUnexpectedDataChannelType,
+}
+
+// closed are the errors of sends on an association or connection that is
+// not established any more
+var closed = []error{
+	sctp.ErrPayloadDataStateNotExist,
+	sctp.ErrResetPacketInStateNotExist,
+	sctp.ErrAssociationClosedBeforeConn,
+	sctp.ErrShutdownNonEstablished,
+	webrtc.ErrConnectionClosed,
+	net.ErrClosed,
+}
+
+// transient are the errors of sends that failed for a moment: the operating
+// system running out of buffers or interrupting the write, or a write timing
+// out. Pion queues messages without blocking, so these only surface from the
+// layers under it.
+var transient = []error{
+	syscall.ENOBUFS,
+	syscall.EAGAIN,
+	syscall.EINTR,
+	os.ErrDeadlineExceeded,
+}
+
+// Classify returns the kind of err, which is the kind it was wrapped with if
+// it already is an *Error
+func Classify(err error) Kind {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e.Kind
+	case errors.Is(err, sctp.ErrChunk):
+		// Aborts carry their causes only in the message
+		if strings.Contains(err.Error(), "Protocol Violation") {
+			return ProtocolViolation
+		}
+		return Aborted
+	case isAny(err, protocolViolations):
+		return ProtocolViolation
+	case errors.Is(err, sctp.ErrStreamClosed), errors.Is(err, io.ErrClosedPipe), errors.Is(err, io.EOF):
+		return Reset
+	case isAny(err, closed):
+		return Closed
+	case errors.Is(err, sctp.ErrOutboundPacketTooLarge):
+		return TooLarge
+	}
+	return Other
+}
+
+// Transient reports whether a send that failed with err may succeed when
+// tried again, which only the errors known to pass do. A reset or aborted
+// channel, a closed connection, a protocol violation, a message too large
+// and any error not known to be transient are not.
+func Transient(err error) bool {
+	if Classify(err) != Other {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return isAny(err, transient)
+}
+
+// isAny reports whether err is any of targets
+func isAny(err error, targets []error) bool {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Error is an SCTP or data channel error with its kind
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+// Wrap classifies err and counts it, returning nil for nil and err itself if
+// it already is an *Error, so errors wrapped on their way up count once
+func Wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	e = &Error{Kind: Classify(err), Err: err}
+	errorsTotal.Inc(string(e.Kind))
+	return e
+}
+
+// Error explains the kind of the error before the error pion returned
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Kind.describe(), e.Err)
+}
+
+// Unwrap returns the error pion returned
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// describe explains what an error of the kind means for the transfer
+func (k Kind) describe() string {
+	switch k {
+	case Reset:
+		return "the peer closed the data channel"
+	case Aborted:
+		return "the peer aborted the SCTP association"
+	case ProtocolViolation:
+		return "the SCTP association failed on a protocol violation"
+	case Closed:
+		return "the connection to the peer is closed"
+	case TooLarge:
+		return "the message is larger than the peer accepts"
+	}
+	return "data channel error"
+}
@@ -0,0 +1,95 @@
+package sctperr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/pion/datachannel"
+	"github.com/pion/sctp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Kind
+	}{
+		{sctp.ErrStreamClosed, Reset},
+		{io.ErrClosedPipe, Reset},
+		{fmt.Errorf("[0xc000] %w: %s", sctp.ErrChunk, "(User Initiated Abort: bye)"), Aborted},
+		{fmt.Errorf("[0xc000] %w: %s", sctp.ErrChunk, "(Protocol Violation: bad chunk)"), ProtocolViolation},
+		{fmt.Errorf("%w: 12", sctp.ErrChunkTypeUnhandled), ProtocolViolation},
+		{datachannel.ErrInvalidPayloadProtocolIdentifier, ProtocolViolation},
+		{sctp.ErrPayloadDataStateNotExist, Closed},
+		{webrtc.ErrConnectionClosed, Closed},
+		{fmt.Errorf("%w: 65536", sctp.ErrOutboundPacketTooLarge), TooLarge},
+		{errors.New("something else"), Other},
+		{&Error{Kind: Aborted, Err: io.ErrClosedPipe}, Aborted},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.err); got != tt.want {
+			t.Errorf("Classify(%v) = %s, expected %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestTransient(t *testing.T) {
+	for _, err := range []error{
+		Wrap(fmt.Errorf("write udp: %w", syscall.ENOBUFS)),
+		syscall.EAGAIN,
+		fmt.Errorf("write: %w", os.ErrDeadlineExceeded),
+		&net.OpError{Op: "write", Err: timeoutError{}},
+	} {
+		if !Transient(err) {
+			t.Errorf("Expected %v to be transient", err)
+		}
+	}
+	// Errors of no known kind may be permanent, so they are not retried
+	for _, err := range []error{sctp.ErrStreamClosed, webrtc.ErrConnectionClosed, Wrap(sctp.ErrOutboundPacketTooLarge), Wrap(errors.New("unknown failure")), syscall.EACCES} {
+		if Transient(err) {
+			t.Errorf("Expected %v not to be transient", err)
+		}
+	}
+}
+
+// timeoutError is a network error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestWrap(t *testing.T) {
+	if err := Wrap(nil); err != nil {
+		t.Errorf("Expected Wrap(nil) to be nil, got %v", err)
+	}
+
+	before := errorsTotal.Value(string(Reset))
+	err := Wrap(sctp.ErrStreamClosed)
+
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != Reset {
+		t.Fatalf("Expected a reset error, got %v", err)
+	}
+	if !errors.Is(err, sctp.ErrStreamClosed) {
+		t.Error("Expected the wrapped error to match the error pion returned")
+	}
+	if !strings.HasPrefix(err.Error(), "the peer closed the data channel: ") {
+		t.Errorf("Unexpected message: %v", err)
+	}
+
+	// Wrapping again on the way up does not count the error twice
+	outer := fmt.Errorf("failed to send line 3: %w", err)
+	if Wrap(outer) != outer {
+		t.Error("Expected an error that already has a kind to be returned as is")
+	}
+	if got := errorsTotal.Value(string(Reset)) - before; got != 1 {
+		t.Errorf("Expected the error to be counted once, got %d", got)
+	}
+}
@@ -0,0 +1,174 @@
+// Package seal encrypts the state the client keeps on disk, its daemon mode
+// subscription with the progress of pushes and the chunks of its cache, with
+// a key stored locally, so on a shared device the files reveal neither the
+// files and offsets received nor the content and hashes of cached chunks.
+// Data is sealed with AES-256-GCM, which FIPS mode allows, behind a magic
+// prefix that tells sealed files from those saved before encryption was
+// enabled.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// magic starts every sealed file
+const magic = "WPSEAL1\n"
+
+// keySize is the size of the key in bytes
+const keySize = 32
+
+// Overhead is how much longer data gets once sealed
+const Overhead = len(magic) + 12 + 16
+
+// ErrOpen is returned for sealed data that was sealed with another key or
+// altered since
+var ErrOpen = errors.New("sealed data cannot be opened with this key")
+
+// Key seals and opens the client's state
+type Key struct {
+	aead cipher.AEAD
+	// names is the key hashes are turned into file names with
+	names []byte
+}
+
+// DefaultPath returns the default location of the key
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding config directory: %w", err)
+	}
+	return filepath.Join(dir, "webrtc-poc", "state.key"), nil
+}
+
+// LoadOrCreate loads the key at path, generating and saving a new one if it
+// does not exist yet
+func LoadOrCreate(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return create(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state key: %w", err)
+	}
+	return Parse(string(data), path)
+}
+
+// Parse parses a base64 encoded key, naming it source in errors
+func Parse(text, source string) (*Key, error) {
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(secret) != keySize {
+		return nil, fmt.Errorf("error reading state key %s: expected %d base64 encoded bytes", source, keySize)
+	}
+	return New(secret)
+}
+
+// Generate generates a new key and returns it base64 encoded
+func Generate() (string, error) {
+	secret := make([]byte, keySize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating state key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(secret), nil
+}
+
+// create generates a new key and saves it to path
+func create(path string) (*Key, error) {
+	text, err := Generate()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating state key directory: %w", err)
+	}
+	data := text + "\n"
+	// O_EXCL keeps two clients starting at once from each creating a key
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return LoadOrCreate(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error writing state key: %w", err)
+	}
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error writing state key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("error writing state key: %w", err)
+	}
+	return Parse(text, path)
+}
+
+// New returns the key of a 32 byte secret. The secret is only used to derive
+// the keys data is sealed and file names are hashed with.
+func New(secret []byte) (*Key, error) {
+	if len(secret) != keySize {
+		return nil, fmt.Errorf("state key must be %d bytes, got %d", keySize, len(secret))
+	}
+	block, err := aes.NewCipher(derive(secret, "seal"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{aead: aead, names: derive(secret, "names")}, nil
+}
+
+// derive derives the key for purpose from secret
+func derive(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("webrtc-poc state " + purpose))
+	return mac.Sum(nil)
+}
+
+// Sealed reports whether data was sealed, rather than saved in the clear
+func Sealed(data []byte) bool {
+	return strings.HasPrefix(string(data), magic)
+}
+
+// Seal encrypts data for the purpose label, which opening it must name
+// again, so data sealed for one purpose cannot be passed off as another's
+func (k *Key) Seal(label string, data []byte) []byte {
+	nonce := make([]byte, k.aead.NonceSize())
+	// crypto/rand.Read never fails
+	rand.Read(nonce)
+	out := make([]byte, 0, len(magic)+len(nonce)+len(data)+k.aead.Overhead())
+	out = append(out, magic...)
+	out = append(out, nonce...)
+	return k.aead.Seal(out, nonce, data, []byte(label))
+}
+
+// Open decrypts data sealed for the purpose label
+func (k *Key) Open(label string, data []byte) ([]byte, error) {
+	if !Sealed(data) || len(data) < Overhead {
+		return nil, ErrOpen
+	}
+	data = data[len(magic):]
+	size := k.aead.NonceSize()
+	plain, err := k.aead.Open(nil, data[:size], data[size:], []byte(label))
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return plain, nil
+}
+
+// Name returns the name a file identified by hash is stored under, which
+// only the holder of the key can link back to the hash
+func (k *Key) Name(hash string) string {
+	mac := hmac.New(sha256.New, k.names)
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
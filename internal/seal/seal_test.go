@@ -0,0 +1,105 @@
+package seal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	key, err := New(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	data := []byte(`{"file":"report.txt"}`)
+
+	sealed := key.Seal("state", data)
+	if !Sealed(sealed) || Sealed(data) {
+		t.Error("Expected only sealed data to be recognised")
+	}
+	if len(sealed) != len(data)+Overhead {
+		t.Errorf("Expected %d bytes, got %d", len(data)+Overhead, len(sealed))
+	}
+	if bytes.Contains(sealed, []byte("report.txt")) {
+		t.Error("Expected the sealed data not to contain the plaintext")
+	}
+	if bytes.Equal(sealed, key.Seal("state", data)) {
+		t.Error("Expected every seal to use a fresh nonce")
+	}
+
+	opened, err := key.Open("state", sealed)
+	if err != nil || !bytes.Equal(opened, data) {
+		t.Errorf("Expected the data back, got %q, %v", opened, err)
+	}
+	if _, err := key.Open("chunk", sealed); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen for another label, got %v", err)
+	}
+	other, _ := New(bytes.Repeat([]byte{2}, 32))
+	if _, err := other.Open("state", sealed); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen for another key, got %v", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := key.Open("state", sealed); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen for altered data, got %v", err)
+	}
+	if _, err := key.Open("state", []byte(magic)); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected ErrOpen for truncated data, got %v", err)
+	}
+
+	if key.Name("abc") != key.Name("abc") || key.Name("abc") == other.Name("abc") || key.Name("abc") == "abc" {
+		t.Error("Expected names to be stable per key and to hide the hash")
+	}
+	if _, err := New([]byte("short")); err == nil {
+		t.Error("Expected an error for a short secret")
+	}
+}
+
+func TestLoadOrCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "state.key")
+
+	created, err := LoadOrCreate(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreate returned error: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected the key to be saved: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	loaded, err := LoadOrCreate(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreate returned error: %v", err)
+	}
+	if _, err := loaded.Open("state", created.Seal("state", []byte("data"))); err != nil {
+		t.Errorf("Expected the loaded key to open what the created one sealed: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("not a key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrCreate(path); err == nil {
+		t.Error("Expected an error for an invalid key file")
+	}
+
+	// Keys kept elsewhere than in a file, such as the keyring
+	text, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	parsed, err := Parse(text, "keyring:state")
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	again, err := Parse(text+"\n", "keyring:state")
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if _, err := again.Open("state", parsed.Seal("state", []byte("data"))); err != nil {
+		t.Errorf("Expected the same key parsed twice: %v", err)
+	}
+}
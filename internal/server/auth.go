@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/exports"
+	"github.com/developmeh/webrtc-poc/internal/identity"
+	"github.com/developmeh/webrtc-poc/internal/noise"
+	"github.com/developmeh/webrtc-poc/internal/sessions"
+)
+
+// Authorized checks the bearer token of a request if one is required
+func Authorized(r *http.Request, authToken string) bool {
+	if authToken == "" {
+		return true
+	}
+	presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(authToken)) == 1
+}
+
+// clientAddr returns the IP address a request came from. Forwarding headers
+// are not trusted, so behind a proxy every client has the proxy's address.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// controlAuthorized checks the bearer token of a control API request, or
+// that it came from this host if no token is configured
+func controlAuthorized(r *http.Request, controlToken string) bool {
+	if controlToken != "" {
+		return Authorized(r, controlToken)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// connectionError answers a request whose connection could not be created,
+// telling a client over its budget when to retry
+func connectionError(w http.ResponseWriter, err error) {
+	var budgetErr *sessions.BudgetError
+	if errors.As(err, &budgetErr) {
+		budgetErr.Write(w)
+		return
+	}
+	http.Error(w, err.Error(), connectionStatus(err))
+}
+
+// connectionStatus is the HTTP status for an error creating a connection
+func connectionStatus(err error) int {
+	if errors.Is(err, sessions.ErrDuplicate) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, sessions.ErrFull) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, sessions.ErrOverBudget) {
+		return http.StatusTooManyRequests
+	}
+	if errors.Is(err, exports.ErrForbidden) {
+		return http.StatusForbidden
+	}
+	if errors.Is(err, exports.ErrUnknown) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// checkIdentity verifies the identity assertion of a signaling request with
+// the given body. With an allowlist the client must prove one of the allowed
+// identities; without one a valid identity is only reported.
+func checkIdentity(r *http.Request, body []byte, allowed []string) (string, error) {
+	return verifyClient(r.Header.Get(identity.Header), identity.SignedRequest(r.Method, r.URL.RawQuery, body), allowed)
+}
+
+// verifyClient verifies a client identity assertion over payload against the
+// allowlist, the same way checkIdentity does for signaling requests
+func verifyClient(assertion string, payload []byte, allowed []string) (string, error) {
+	id, err := identity.Verify(assertion, identity.RoleClient, payload, time.Now())
+	if len(allowed) == 0 {
+		if err != nil {
+			return "", nil
+		}
+		return id, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+	if err := allowedIdentity(id, allowed); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// allowedIdentity checks a client identity against the allowlist, allowing
+// any client when the list is empty
+func allowedIdentity(id string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, a := range allowed {
+		if a == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: client identity %s", identity.ErrNotAllowed, id)
+}
+
+// openSealedOffer finishes the Noise handshake an offer request continues and
+// decrypts the offer sent along with the last handshake message
+func openSealedOffer(handshakes *noise.Pending, sessionID string, body []byte) (*noise.Session, []byte, error) {
+	responder, ok := handshakes.Take(sessionID)
+	if !ok {
+		return nil, nil, errors.New("unknown or expired Noise session")
+	}
+	msg, sealed, err := noise.SplitJoined(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := responder.Finish(msg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("noise handshake failed: %w", err)
+	}
+	offer, err := session.Open(sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, offer, nil
+}
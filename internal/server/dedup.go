@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/checksum"
+	"github.com/developmeh/webrtc-poc/internal/dedup"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/memlimit"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+	"github.com/developmeh/webrtc-poc/internal/pathpolicy"
+	"github.com/developmeh/webrtc-poc/internal/share"
+	"github.com/pion/webrtc/v3"
+)
+
+// dedupNeedTimeout is how long the server waits for a client to answer the
+// manifest of a deduplicated request
+const dedupNeedTimeout = 30 * time.Second
+
+// messageQueue buffers the messages of a data channel without ever blocking
+// its message handler, which would stall the whole connection
+type messageQueue struct {
+	mu    sync.Mutex
+	msgs  []webrtc.DataChannelMessage
+	ready chan struct{}
+}
+
+func newMessageQueue() *messageQueue {
+	return &messageQueue{ready: make(chan struct{}, 1)}
+}
+
+// push queues a message
+func (q *messageQueue) push(msg webrtc.DataChannelMessage) {
+	q.mu.Lock()
+	q.msgs = append(q.msgs, msg)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for the next message until closed is closed or timeout fires;
+// a nil timeout waits as long as the channel is open
+func (q *messageQueue) next(closed <-chan struct{}, timeout <-chan time.Time) (webrtc.DataChannelMessage, error) {
+	for {
+		q.mu.Lock()
+		if len(q.msgs) > 0 {
+			msg := q.msgs[0]
+			q.msgs = q.msgs[1:]
+			q.mu.Unlock()
+			return msg, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-closed:
+			return webrtc.DataChannelMessage{}, errors.New("channel closed")
+		case <-timeout:
+			return webrtc.DataChannelMessage{}, errors.New("timed out")
+		}
+	}
+}
+
+// streamDeduplicated sends the manifest of a file's chunks from the index,
+// hashed with algorithm, waits for the client to name the chunks it needs and
+// streams only their lines. Chunks a verifying client finds corrupted are
+// streamed again until it confirms every chunk arrived intact.
+func streamDeduplicated(request *webrtc.DataChannel, path string, index *dedup.Index, algorithm string, needs *messageQueue, closed <-chan struct{}, stream func(*webrtc.DataChannel, string, streamOptions) (int, error)) (int, error) {
+	chunks, err := index.Chunks(path, algorithm)
+	if err != nil {
+		request.Send(share.Manifest{Error: requestError(err)}.Encode())
+		return 0, err
+	}
+	// SHA-256 manifests do not name it, as before there were other algorithms
+	manifest := share.Manifest{Chunks: chunks, CRC: true}
+	if algorithm != checksum.SHA256 {
+		manifest.Algorithm = algorithm
+	}
+	if err := request.Send(manifest.Encode()); err != nil {
+		return 0, fmt.Errorf("failed to send manifest: %w", err)
+	}
+
+	msg, err := needs.next(closed, time.After(dedupNeedTimeout))
+	if err != nil {
+		return 0, fmt.Errorf("client did not answer the manifest: %w", err)
+	}
+	need, err := share.DecodeNeed(msg.Data)
+	if err != nil {
+		return 0, err
+	}
+	logger.Info("Client needs %d of %d chunks of %s", len(need.Chunks), len(chunks), request.Label())
+
+	sent, err := stream(request, path, streamOptions{include: dedup.Lines(chunks, need.Chunks), priority: memlimit.Low})
+	if err != nil || !need.Verify {
+		return sent, err
+	}
+
+	// Retransmit the chunks that failed their CRC, in the order the client
+	// asked for them, until it sends an empty Need
+	for {
+		msg, err := needs.next(closed, nil)
+		if err != nil {
+			return sent, fmt.Errorf("client did not confirm the chunks: %w", err)
+		}
+		retry, err := share.DecodeNeed(msg.Data)
+		if err != nil {
+			return sent, err
+		}
+		if len(retry.Chunks) == 0 {
+			return sent, nil
+		}
+		logger.Info("Retransmitting chunks %v of %s", retry.Chunks, request.Label())
+		metrics.ChunkRetransmits.Add(int64(len(retry.Chunks)))
+		n, err := stream(request, path, streamOptions{include: dedup.Lines(chunks, retry.Chunks), priority: memlimit.Low})
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+}
+
+// requestError describes a failed file request to the client without
+// revealing the server's paths
+func requestError(err error) string {
+	switch {
+	case errors.Is(err, share.ErrDisabled), errors.Is(err, share.ErrOutsideRoot), errors.Is(err, pathpolicy.ErrSymlinkEscape),
+		errors.Is(err, filepath.ErrBadPattern):
+		return err.Error()
+	case errors.Is(err, os.ErrNotExist):
+		return "file not found"
+	case errors.Is(err, os.ErrPermission):
+		return "permission denied"
+	}
+	return "transfer failed"
+}
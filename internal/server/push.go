@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/developmeh/webrtc-poc/internal/exports"
+	"github.com/developmeh/webrtc-poc/internal/pathpolicy"
+)
+
+// errPushOutside is returned for pushes of files the server does not share
+var errPushOutside = errors.New("pushed files must be inside --share-dir or a configured export")
+
+// PushPath returns the canonical path of a file pushed to daemon mode
+// clients, through POST /push or a schedule, which must be inside
+// --share-dir, or be the file or inside the directory of an export
+func PushPath(file string, sharePolicy *pathpolicy.Policy, shared *exports.Set, followSymlinks bool) (string, error) {
+	if sharePolicy != nil {
+		if path, err := sharePolicy.Check(file); err == nil {
+			return path, nil
+		}
+	}
+	if shared.Holds(file, followSymlinks) {
+		return pathpolicy.Canonical(file)
+	}
+	return "", fmt.Errorf("%w: %s", errPushOutside, file)
+}
+
+// PushRequest is the body of POST /push
+type PushRequest struct {
+	Peer string `json:"peer"`
+	File string `json:"file"`
+}
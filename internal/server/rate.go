@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// burstWindow is how many seconds' worth of the rate a limiter saves up
+// while the transfer is held, so a receiver's window or a slow disk does not
+// lower the rate, without letting the transfer burst for long
+const burstWindow = 50 * time.Millisecond
+
+// Rate is how fast a transfer is sent, in bytes or lines per second
+type Rate struct {
+	// PerSecond is the bytes or lines sent per second, 0 for no limit
+	PerSecond float64
+	// Lines counts lines instead of bytes
+	Lines bool
+}
+
+// rateUnits are the units of a rate, longest suffix first
+var rateUnits = []struct {
+	suffix string
+	scale  float64
+	lines  bool
+}{
+	{"lines", 1, true}, {"line", 1, true},
+	{"GiB", 1 << 30, false}, {"MiB", 1 << 20, false}, {"KiB", 1 << 10, false},
+	{"GB", 1e9, false}, {"MB", 1e6, false}, {"KB", 1e3, false}, {"kB", 1e3, false},
+	{"B", 1, false},
+}
+
+// ParseRate parses a rate written as a number, a unit and /s, like 1MB/s,
+// 512KiB/s or 100lines/s. Byte units are B, KB, MB and GB in powers of 1000
+// or KiB, MiB and GiB in powers of 1024.
+func ParseRate(s string) (Rate, error) {
+	invalid := fmt.Errorf("invalid rate %q (expected bytes or lines per second, e.g. 1MB/s or 100lines/s)", s)
+	value, ok := strings.CutSuffix(strings.TrimSpace(s), "/s")
+	if !ok {
+		return Rate{}, invalid
+	}
+	for _, u := range rateUnits {
+		number, ok := strings.CutSuffix(value, u.suffix)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+		if err != nil || n <= 0 || math.IsInf(n, 0) {
+			return Rate{}, invalid
+		}
+		return Rate{PerSecond: n * u.scale, Lines: u.lines}, nil
+	}
+	return Rate{}, invalid
+}
+
+// DelayRate returns the rate of the legacy --delay between lines, in
+// milliseconds: a delay of 250 is 4lines/s, and 0 no limit
+func DelayRate(delayMs int) Rate {
+	if delayMs <= 0 {
+		return Rate{}
+	}
+	return Rate{PerSecond: 1000 / float64(delayMs), Lines: true}
+}
+
+// Limiter paces a transfer at a Rate with a token bucket. The bucket starts
+// empty, so the transfer starts at its rate instead of with a burst, and a
+// line larger than the bucket is let through on credit paid back by waiting.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   Rate
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewLimiter creates a limiter of rate
+func NewLimiter(rate Rate) *Limiter {
+	return &Limiter{
+		rate:  rate,
+		burst: max(rate.PerSecond*burstWindow.Seconds(), 1),
+		now:   time.Now,
+	}
+}
+
+// Delay takes the tokens of a line of n bytes that was just sent and returns
+// how long to wait before sending the next one to stay within the rate
+func (l *Limiter) Delay(n int) time.Duration {
+	if l.rate.PerSecond <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate.PerSecond, l.burst)
+	}
+	l.last = now
+	if l.rate.Lines {
+		l.tokens--
+	} else {
+		l.tokens -= float64(n)
+	}
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate.PerSecond * float64(time.Second))
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/identity"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/paste"
+	"github.com/developmeh/webrtc-poc/internal/rendezvous"
+	"github.com/developmeh/webrtc-poc/internal/signaling"
+	"github.com/pion/webrtc/v3"
+)
+
+// ServeRendezvous registers a code with a rendezvous server and answers the
+// offers peers send to it until ctx is done. A new code is registered if the
+// rendezvous server forgets the current one.
+func ServeRendezvous(ctx context.Context, rc *rendezvous.Client, serverID *identity.Identity, allowed []string, answer func(webrtc.SessionDescription) ([]byte, error)) {
+	var reg rendezvous.Registration
+	for ctx.Err() == nil {
+		if reg.Code == "" {
+			var err error
+			if reg, err = rc.Register(ctx); err != nil {
+				logger.Error("Failed to register with rendezvous server: %v", err)
+				sleepContext(ctx, 5*time.Second)
+				continue
+			}
+			logger.Info("Registered rendezvous code %s at %s", reg.Code, rc.URL)
+			fmt.Printf("RENDEZVOUS_CODE=%s\n", reg.Code)
+		}
+
+		offer, err := rc.NextOffer(ctx, reg)
+		if errors.Is(err, rendezvous.ErrUnknownCode) {
+			logger.Info("Rendezvous code %s expired, registering a new one", reg.Code)
+			reg = rendezvous.Registration{}
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("Rendezvous server error: %v", err)
+				sleepContext(ctx, 5*time.Second)
+			}
+			continue
+		}
+
+		go func(reg rendezvous.Registration) {
+			answerJSON, err := answerRelayedOffer(offer.Blob, serverID, allowed, answer)
+			if err != nil {
+				logger.Error("Rejected relayed offer: %v", err)
+				rc.Reject(ctx, reg, offer.ID)
+				return
+			}
+			if err := rc.Answer(ctx, reg, offer.ID, answerJSON); err != nil {
+				logger.Error("Failed to send relayed answer: %v", err)
+			}
+		}(reg)
+	}
+
+	if reg.Code != "" {
+		rc.Release(context.Background(), reg)
+	}
+}
+
+// answerRelayedOffer checks the client identity of an offer received through
+// a rendezvous server and returns the signed answer
+func answerRelayedOffer(blob []byte, serverID *identity.Identity, allowed []string, answer func(webrtc.SessionDescription) ([]byte, error)) ([]byte, error) {
+	var relayed signaling.Relayed
+	if err := json.Unmarshal(blob, &relayed); err != nil {
+		return nil, fmt.Errorf("failed to parse relayed offer: %w", err)
+	}
+	clientID, err := verifyClient(relayed.Identity, relayed.SDP, allowed)
+	if err != nil {
+		return nil, err
+	}
+	if clientID != "" {
+		logger.Info("Client identity: %s", clientID)
+	}
+
+	offer, err := signaling.ParseOffer(relayed.SDP)
+	if err != nil {
+		return nil, err
+	}
+	answerJSON, err := answer(offer)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(signaling.Relayed{
+		SDP:      answerJSON,
+		Identity: serverID.Sign(identity.RoleServer, answerJSON, time.Now()),
+	})
+}
+
+// servePasted answers the offers pasted into the server's terminal, signed
+// like relayed ones, printing each answer for the operator to paste back
+// into the client, drawn as a QR code too with qr, until stdin ends
+func servePasted(stdin io.Reader, qr bool, serverID *identity.Identity, allowed []string, answer func(webrtc.SessionDescription) ([]byte, error)) {
+	r := paste.NewReader(stdin)
+	for {
+		logger.Info("Paste a client's offer here:")
+		blob, err := r.Read(paste.Offer)
+		if errors.Is(err, io.EOF) {
+			logger.Info("Stdin ended, no more offers can be pasted")
+			return
+		}
+		if err == nil {
+			blob, err = answerRelayedOffer(blob, serverID, allowed, answer)
+		}
+		if err != nil {
+			logger.Error("Rejected pasted offer: %v", err)
+			continue
+		}
+		logger.Info("Paste this answer into the client:")
+		paste.Print(os.Stdout, paste.Answer, blob, qr)
+	}
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+	}
+}
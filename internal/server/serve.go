@@ -0,0 +1,1961 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/autostun"
+	"github.com/developmeh/webrtc-poc/internal/batch"
+	"github.com/developmeh/webrtc-poc/internal/broadcast"
+	"github.com/developmeh/webrtc-poc/internal/bundle"
+	"github.com/developmeh/webrtc-poc/internal/checksum"
+	"github.com/developmeh/webrtc-poc/internal/config"
+	"github.com/developmeh/webrtc-poc/internal/control"
+	"github.com/developmeh/webrtc-poc/internal/crash"
+	"github.com/developmeh/webrtc-poc/internal/deadline"
+	"github.com/developmeh/webrtc-poc/internal/dedup"
+	"github.com/developmeh/webrtc-poc/internal/discovery"
+	"github.com/developmeh/webrtc-poc/internal/exports"
+	"github.com/developmeh/webrtc-poc/internal/fec"
+	"github.com/developmeh/webrtc-poc/internal/fips"
+	"github.com/developmeh/webrtc-poc/internal/forward"
+	"github.com/developmeh/webrtc-poc/internal/groups"
+	"github.com/developmeh/webrtc-poc/internal/heartbeat"
+	"github.com/developmeh/webrtc-poc/internal/identity"
+	"github.com/developmeh/webrtc-poc/internal/lifecycle"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/maintenance"
+	"github.com/developmeh/webrtc-poc/internal/memlimit"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+	"github.com/developmeh/webrtc-poc/internal/noise"
+	"github.com/developmeh/webrtc-poc/internal/pacing"
+	"github.com/developmeh/webrtc-poc/internal/pathpolicy"
+	"github.com/developmeh/webrtc-poc/internal/peer"
+	"github.com/developmeh/webrtc-poc/internal/pool"
+	"github.com/developmeh/webrtc-poc/internal/profiling"
+	"github.com/developmeh/webrtc-poc/internal/quality"
+	"github.com/developmeh/webrtc-poc/internal/records"
+	"github.com/developmeh/webrtc-poc/internal/remotelog"
+	"github.com/developmeh/webrtc-poc/internal/rendezvous"
+	"github.com/developmeh/webrtc-poc/internal/retention"
+	"github.com/developmeh/webrtc-poc/internal/retry"
+	"github.com/developmeh/webrtc-poc/internal/sample"
+	"github.com/developmeh/webrtc-poc/internal/schedule"
+	"github.com/developmeh/webrtc-poc/internal/sctperr"
+	"github.com/developmeh/webrtc-poc/internal/sessions"
+	"github.com/developmeh/webrtc-poc/internal/share"
+	"github.com/developmeh/webrtc-poc/internal/signaling"
+	"github.com/developmeh/webrtc-poc/internal/source"
+	"github.com/developmeh/webrtc-poc/internal/trace"
+	"github.com/developmeh/webrtc-poc/internal/trickle"
+	"github.com/developmeh/webrtc-poc/internal/tui"
+	"github.com/developmeh/webrtc-poc/internal/tunnel"
+	"github.com/developmeh/webrtc-poc/internal/upload"
+	"github.com/developmeh/webrtc-poc/internal/webui"
+	"github.com/pion/webrtc/v3"
+)
+
+// Config configures New and Run. Its zero value streams nothing: set File
+// or Exports. Every other field has a working default, so a caller only sets
+// what it needs.
+type Config struct {
+	// Addr is the HTTP service address clients post their offers to
+	Addr string
+	// File is streamed to clients that do not name an export, a directory or
+	// glob pattern streaming every file it names
+	File string
+	// DelayMs is the delay between lines in milliseconds, the legacy form
+	// of Rate used when Rate is zero
+	DelayMs int
+	// Rate paces every transfer in bytes or lines per second
+	Rate Rate
+	// ICEServers are the STUN and TURN servers, none for direct connections
+	ICEServers []webrtc.ICEServer
+	// Fallback, if set, hands out public STUN servers once direct
+	// connections have failed
+	Fallback *autostun.Fallback
+	// Interceptors are the media interceptors registered with the WebRTC API
+	Interceptors []string
+
+	// AuthToken, if set, is the bearer token clients signal with
+	AuthToken string
+	// ControlToken, if set, is the bearer token of the control API, which
+	// otherwise only answers this host
+	ControlToken string
+	// Identity signs the server's answers; an ephemeral one is generated if
+	// nil
+	Identity *identity.Identity
+	// AllowedIdentities, if set, are the only client identities accepted
+	AllowedIdentities []string
+	// RequireNoise turns away offers not sent over Noise secured signaling
+	RequireNoise bool
+	// OfferRole is the side that creates the offer (default client)
+	OfferRole string
+	// Signal is how offers reach the server; manual and qr read them from
+	// stdin (default http)
+	Signal string
+	// Rendezvous, if set, is the rendezvous server offers are relayed through
+	Rendezvous string
+	// WebUI serves the page browsers receive the file stream with at /
+	WebUI bool
+	// CORSOrigins are the pages allowed to post offers from a browser
+	CORSOrigins []string
+
+	// Channel is the file stream data channel
+	Channel peer.Channel
+	// Records bounds the lines of the file stream; clients may ask for
+	// smaller records or another policy
+	Records records.Terms
+	// Source controls how the streamed files are read
+	Source source.Options
+	// Length, if set, is the number of bytes streamed from File
+	Length int64
+	// Raw sends File in chunks of bytes instead of lines
+	Raw bool
+	// Follow keeps streaming the lines appended to File until the client
+	// disconnects
+	Follow bool
+	// Broadcast sends every line of File to every client in lockstep
+	Broadcast bool
+	// GroupOffsets, if set, is where consumer groups commit their offsets;
+	// without it File serves no consumer groups
+	GroupOffsets string
+	// Retention, if set, is how long the arrival times of the lines appended
+	// to a followed File are kept, in a log in RetentionDir (default
+	// retention.DefaultDir), for clients replaying the lines since a time
+	Retention    time.Duration
+	RetentionDir string
+	// Exports are the files clients request by name
+	Exports *exports.Set
+
+	// AdaptivePacing follows the connection quality instead of the fixed
+	// delay between lines
+	AdaptivePacing bool
+	// CompleteBy, if set, is when every transfer should be done
+	CompleteBy deadline.Spec
+	// Pacing, if set, limits the rate of every transfer to that of its
+	// current window
+	Pacing pacing.Schedule
+	// LowLatency timestamps every line and keeps few bytes queued
+	LowLatency bool
+	// Timestamps sends every line with the time it was sent
+	Timestamps bool
+	// SendRetries is how often a send that may pass is retried, waiting up
+	// to SendRetryBackoff before the first retry
+	SendRetries      int
+	SendRetryBackoff time.Duration
+	// FECData and FECParity, if set, send the lines as shards with parity
+	FECData, FECParity int
+	// Batch limits the batches of lines sent to clients that accept them
+	Batch batch.Limits
+	// Checksums are the checksum algorithms allowed, in order of preference
+	// (default every algorithm approved mode permits)
+	Checksums []string
+
+	// ShareDir, if set, is the directory clients request files from
+	ShareDir string
+	// FollowSymlinks lets requested and pushed paths follow symlinks
+	FollowSymlinks bool
+	// ChunkIndex, if set, persists the chunks of the shared files
+	ChunkIndex string
+	// Uploads, if set, accepts the files clients upload
+	Uploads *upload.Pipeline
+	// Forwarder, if set, connects the forward channels clients open
+	Forwarder *forward.Forwarder
+	// AllowTunnels are the targets clients may tunnel to
+	AllowTunnels []string
+	// Schedules push files to daemon mode clients, of the Peers registered
+	Schedules []config.ScheduleConfig
+	Peers     []string
+
+	// RemoteLogs keeps the log lines of every session for its client
+	RemoteLogs bool
+	// IdleTimeout closes connections that stay new or connecting this long
+	// (0 to wait forever)
+	IdleTimeout time.Duration
+	// DuplicatePolicy decides what happens to a second session of a client
+	// identity (default allow)
+	DuplicatePolicy sessions.Policy
+	// MaxConnections bounds the peer connections run at once (0 for no limit)
+	MaxConnections int
+	// Budget bounds the sessions of each client
+	Budget sessions.Budget
+	// StallTimeout, if set, declares sessions whose client acknowledged no
+	// data this long stalled, and StallPolicy what happens to them
+	StallTimeout time.Duration
+	StallPolicy  sessions.StallPolicy
+	// Workers bounds the transfers, requests and uploads streamed at once
+	// (0 for no limit)
+	Workers int
+	// MemoryLimit, if set, sheds load above this many bytes of memory
+	MemoryLimit int64
+
+	// DebugSocket, if set, serves the runtime profiles to the profile command
+	DebugSocket string
+	// Announce announces the server over mDNS as AnnounceName (default the
+	// host name)
+	Announce     bool
+	AnnounceName string
+	// TUI shows the terminal UI on stderr until the user quits it
+	TUI bool
+
+	// API, if set, creates the peer connections instead of one for
+	// ICEServers with the Interceptors registered
+	API *webrtc.API
+	// Open, if set, opens what the file stream sends instead of File, which
+	// then only names it
+	Open func() (io.ReadCloser, error)
+	// Pace, if set, paces every transfer instead of Rate: it is called once
+	// per transfer and returns how long to wait after a line of n bytes
+	Pace func() func(n int) time.Duration
+	// FinishTimeout is how long clients have to acknowledge the end of the
+	// file stream (default 5s)
+	FinishTimeout time.Duration
+	// OnConnection, OnState and OnProgress, if set, follow every session,
+	// named by its trace session: its connection once created, before the
+	// offer is answered or made, every state of the connection, and how far
+	// its file stream got, after every line and once it ended
+	OnConnection func(id string, pc *webrtc.PeerConnection)
+	OnState      func(id string, state webrtc.PeerConnectionState)
+	OnProgress   func(Progress)
+}
+
+// Progress reports how far the file stream of a session got
+type Progress struct {
+	// ID names the session
+	ID string
+	// Lines and Bytes count what was sent so far
+	Lines int
+	Bytes int64
+	// Done is set once the stream ended, with Err if it failed and the
+	// number of lines the client acknowledged in Received
+	Done     bool
+	Received int
+	Err      error
+}
+
+// Server answers the offers of clients and streams to them. Its Handler
+// serves the signaling and control endpoints.
+type Server struct {
+	cfg       Config
+	mux       *http.ServeMux
+	offer     func(context.Context) http.Handler
+	answer    func(context.Context, webrtc.SessionDescription) ([]byte, error)
+	running   *sessions.Active
+	shared    *exports.Set
+	serverID  *identity.Identity
+	offerRole string
+	manual    bool
+	workers   *pool.Pool
+	stop      chan struct{}
+	closeOnce sync.Once
+	cleanup   []func()
+}
+
+// New sets up a server for cfg, without listening yet
+func New(cfg Config) (*Server, error) {
+	filename := cfg.File
+	if cfg.Open == nil && sample.Builtin(filename) {
+		path, err := sample.ExtractCached()
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract the built-in %s: %w", sample.Name, err)
+		}
+		logger.Info("No %s here, streaming the built-in sample from %s", sample.Name, path)
+		filename = path
+	}
+	s := &Server{cfg: cfg, mux: http.NewServeMux(), stop: make(chan struct{})}
+	delay := cfg.DelayMs
+	authToken := cfg.AuthToken
+	controlToken := cfg.ControlToken
+	adaptive := cfg.AdaptivePacing
+	requireNoise := cfg.RequireNoise
+	shareDir := cfg.ShareDir
+	remoteLogs := cfg.RemoteLogs
+	idleTimeout := cfg.IdleTimeout
+	follow := cfg.Follow
+	sourceOpts := cfg.Source
+	recordTerms := cfg.Records
+
+	checksums := cfg.Checksums
+	if len(checksums) == 0 {
+		checksums = checksum.Algorithms()
+	}
+
+	// Index the chunks of the shared files up front, with the preferred
+	// checksum algorithm, so deduplicated requests do not have to read them
+	// first
+	index, err := dedup.NewIndex(cfg.ChunkIndex)
+	if err != nil {
+		return nil, err
+	}
+	// Keep requested paths inside the shared directory
+	var sharePolicy *pathpolicy.Policy
+	if shareDir != "" {
+		sharePolicy, err = pathpolicy.New(shareDir, cfg.FollowSymlinks)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --share-dir: %w", err)
+		}
+		go func() {
+			start := time.Now()
+			read, err := index.Warm(shareDir, checksums[0])
+			if err != nil {
+				logger.Error("Failed to index %s: %v", shareDir, err)
+				return
+			}
+			logger.Info("Indexed %s in %v, read %d changed files", shareDir, time.Since(start).Round(time.Millisecond), read)
+		}()
+	}
+
+	uploads := cfg.Uploads
+	forwarder := cfg.Forwarder
+	allowTunnels := cfg.AllowTunnels
+	lowLatency := cfg.LowLatency
+	timestamps := cfg.Timestamps || lowLatency
+	unreliable := cfg.Channel.Unreliable
+	negotiatedChannel := cfg.Channel.Negotiated
+	if lowLatency {
+		logger.Info("Low-latency mode: timestamping every line and keeping at most %d bytes queued per channel", lowLatencyQueue)
+	}
+	sendRetry := retry.Policy{
+		Attempts:  cfg.SendRetries,
+		Backoff:   cfg.SendRetryBackoff,
+		Max:       sendRetryMax,
+		Retryable: sctperr.Transient,
+		OnRetry: func(n int, err error) {
+			logger.Debug("Retrying a failed send (retry %d): %v", n, err)
+		},
+	}
+
+	// Forward error correction only makes sense when lines can be lost
+	fecData, fecParity := cfg.FECData, cfg.FECParity
+
+	// Coalesce the lines of the file stream for clients that accept batches
+	batching := cfg.Batch
+	if batching.Enabled() {
+		logger.Info("Batching up to %d lines or %d bytes per message for clients that accept batches", batching.Lines, batching.Bytes)
+	}
+	// Track the session of each client identity to handle duplicates
+	policy, err := sessions.ParsePolicy(string(cfg.DuplicatePolicy))
+	if err != nil {
+		return nil, err
+	}
+	active := sessions.NewRegistry(policy)
+
+	// Bound the peer connections the server runs at once
+	running := sessions.NewActive(cfg.MaxConnections)
+	s.running = running
+
+	// Bound the sessions of each client, so one cannot take them all
+	running.SetBudget(cfg.Budget)
+
+	// Declare the sessions whose client stopped acknowledging data stalled,
+	// so they do not hold a slot forever
+	stallPolicy, err := sessions.ParseStallPolicy(string(cfg.StallPolicy))
+	if err != nil {
+		return nil, err
+	}
+	stallTimeout := cfg.StallTimeout
+	if stallTimeout > 0 {
+		s.cleanup = append(s.cleanup, running.Watch(stallTimeout))
+	}
+	offerRole, err := signaling.ParseOfferRole(cfg.OfferRole)
+	if err != nil {
+		return nil, err
+	}
+	s.offerRole = offerRole
+
+	// Browsers post their offers in the clear, like a client without --noise
+	webUI := cfg.WebUI
+	corsOrigins := cfg.CORSOrigins
+	browsers := webUI || len(corsOrigins) > 0
+
+	// Offers pasted into the terminal are signed like relayed ones, and
+	// stdin is theirs
+	if s.manual, err = signaling.ParseSignal(cfg.Signal); err != nil {
+		return nil, err
+	}
+
+	completeBy := cfg.CompleteBy
+	finishTimeout := cfg.FinishTimeout
+	if finishTimeout <= 0 {
+		finishTimeout = defaultFinishTimeout
+	}
+
+	// Limit the rate of every transfer to that of the current pacing window
+	var pacer *pacing.Pacer
+	if len(cfg.Pacing) > 0 {
+		pacer = pacing.NewPacer(cfg.Pacing)
+	}
+
+	// Pace every transfer at Rate, or at the rate of lines of the legacy
+	// delay between them, with the same token bucket. Adaptive pacing and
+	// CompleteBy shorten or lengthen the delay line by line instead, so
+	// they keep it.
+	rate := cfg.Rate
+	if rate.PerSecond <= 0 && !adaptive && completeBy.IsZero() {
+		rate = DelayRate(delay)
+	}
+	if rate.PerSecond > 0 {
+		delay = 0
+	}
+
+	// Ensure the file exists; devices are streamed as they are read, so
+	// only their length limits them
+	length := cfg.Length
+	// What Open opens is only sent in chunks of bytes with Raw
+	opened := cfg.Open != nil
+	// Block devices and --raw files are sent in chunks of bytes, not lines
+	raw := filename != "" && !bundle.IsBundle(filename) && RawSource(filename, cfg.Raw)
+	if opened {
+		raw = cfg.Raw
+	}
+	// A directory or glob pattern streams every file it names, listed again
+	// for every transfer
+	bundled := !opened && bundle.IsBundle(filename)
+	// Clients name the files of exports, which the caller may load again
+	// while the server runs
+	shared := cfg.Exports
+	if shared == nil {
+		shared = exports.NewSet()
+	}
+	s.shared = shared
+	if names := shared.Names(); len(names) > 0 {
+		logger.Info("Serving exports: %s", strings.Join(names, ", "))
+	}
+	if opened {
+		if cfg.Broadcast || cfg.GroupOffsets != "" {
+			return nil, errors.New("a broadcast and consumer groups read the file by name, which Open does not have")
+		}
+		if filename == "" {
+			filename = "the stream"
+		}
+		logger.Info("Streaming %s", filename)
+	} else if filename == "" {
+		logger.Info("No --file, clients must request one of the exports")
+	} else if bundled {
+		files, err := bundle.List(filename)
+		if err != nil {
+			return nil, fmt.Errorf("cannot stream %s: %w", filename, err)
+		}
+		logger.Info("Streaming the %d files of %s", len(files), filename)
+	} else {
+		info, err := os.Stat(filename)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("file does not exist: %s", filename)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot stream %s: %w", filename, err)
+		}
+		if !info.Mode().IsRegular() {
+			if !completeBy.IsZero() {
+				return nil, fmt.Errorf("--complete-by needs the size of %s, which is not a regular file", filename)
+			}
+			logger.Info("Streaming from %s without size detection", filename)
+			if length == 0 && info.Mode()&os.ModeCharDevice != 0 {
+				logger.Info("Warning: no --length given, %s is streamed until it ends", filename)
+			}
+		}
+		if raw {
+			logger.Info("Sending %s in raw chunks of %d bytes", filename, rawChunkSize)
+		}
+	}
+
+	// A followed file is streamed until the client disconnects, waiting for
+	// the lines appended to it at its end
+	if follow {
+		logger.Info("Following %s for appended lines", filename)
+	}
+
+	// Broadcasts and consumer groups read the file once for all their
+	// clients, at the configured delay
+	terms, err := recordTerms.Negotiate(0, "")
+	if err != nil {
+		return nil, err
+	}
+	sharedOptions := streamOptions{
+		pace:    func() time.Duration { return time.Duration(delay) * time.Millisecond },
+		pacer:   pacer,
+		rate:    NewLimiter(rate).Delay,
+		source:  sourceOpts,
+		length:  length,
+		records: terms,
+		follow:  follow,
+	}
+	sharedOptions.source.MMap = sourceOpts.MMap && !follow
+
+	// A broadcast sends every line to every client connected
+	var hub *broadcast.Hub
+	if cfg.Broadcast {
+		// A followed broadcast waits for more lines while it has clients
+		hub = broadcast.New(broadcastSource(filename, sharedOptions, func() bool { return hub.Subscribers() > 0 }))
+		logger.Info("Broadcasting %s to every connected client in lockstep", filename)
+	}
+
+	// Consumer groups share the file stream, every line going to one of
+	// their clients, and carry on after the offset they committed
+	var consumers *groups.Groups
+	if filename != "" && !bundled && hub == nil && cfg.GroupOffsets != "" {
+		store, err := groups.Open(cfg.GroupOffsets)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --group-offsets: %w", err)
+		}
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return nil, fmt.Errorf("cannot stream %s: %w", filename, err)
+		}
+		consumers = groups.New(store, abs, groupSource(filename, sharedOptions))
+	}
+
+	// A followed file keeps a log of when its lines arrived, so clients can
+	// replay the lines since a time before going live
+	var retained *retention.Log
+	if cfg.Retention > 0 {
+		if !follow || filename == "" || bundled || opened || raw || hub != nil {
+			return nil, errors.New("a retention log needs a single followed file, streamed to every client on its own")
+		}
+		dir := cfg.RetentionDir
+		if dir == "" {
+			if dir, err = retention.DefaultDir(); err != nil {
+				return nil, err
+			}
+		}
+		if retained, err = retain(filename, dir, cfg.Retention, sharedOptions, s.stop); err != nil {
+			return nil, fmt.Errorf("invalid --retention: %w", err)
+		}
+		s.cleanup = append(s.cleanup, func() { retained.Close() })
+	}
+
+	// The server's identity and the clients allowed to connect
+	serverID := cfg.Identity
+	if serverID == nil {
+		key, err := identity.Generate()
+		if err != nil {
+			return nil, err
+		}
+		if serverID, err = identity.Parse(key, "ephemeral identity"); err != nil {
+			return nil, err
+		}
+	}
+	s.serverID = serverID
+	allowed := cfg.AllowedIdentities
+	if len(allowed) > 0 {
+		logger.Info("Only accepting %d allowed client identities", len(allowed))
+		if browsers {
+			logger.Info("Warning: browsers cannot prove an identity, so --allow-identity turns them away")
+		}
+	}
+
+	// Create a new API with the configured ICE servers, unless the caller
+	// brings its own
+	iceServers, fallback := cfg.ICEServers, cfg.Fallback
+	api := cfg.API
+	if api == nil {
+		api = peer.NewAPI(iceServers, cfg.Interceptors)
+	}
+
+	// Set up scheduled pushes to daemon mode clients
+	var entries []schedule.Entry
+	for _, sc := range cfg.Schedules {
+		file, err := PushPath(sc.File, sharePolicy, shared, cfg.FollowSymlinks)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule: %w", err)
+		}
+		entry, err := schedule.NewEntry(sc.Cron, file, sc.Peers)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	registry := schedule.NewRegistry()
+	for _, name := range cfg.Peers {
+		registry.Register(name)
+	}
+	stopScheduler := s.stop
+	go schedule.NewScheduler(entries, registry).Run(stopScheduler)
+
+	// Stream on a bounded number of workers, queueing transfers and
+	// requests beyond them
+	workers := pool.New(cfg.Workers)
+	s.workers = workers
+
+	// Shed load when the process uses more memory than allowed
+	governor := memlimit.New(cfg.MemoryLimit)
+	go governor.Run(stopScheduler, time.Second)
+
+	// Handshakes started on /noise and finished by the offer that follows
+	handshakes := noise.NewPending(noiseHandshakeTimeout)
+
+	// Offers made by the server that wait for the client's answer
+	offers := newPendingOffers(offerAnswerTimeout)
+
+	// Candidates trickled to and from clients after the answer
+	trickles := trickle.NewSessions(offerAnswerTimeout)
+
+	// Expose internal health metrics
+	s.mux.Handle("/metrics", metrics.Handler())
+
+	// Maintenance mode turns new connections away and is toggled through the
+	// control API, which the ctl command talks to
+	var maint maintenance.Mode
+	s.mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if maint.Reject(w) || governor.Reject(w) || running.Reject(w) {
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+	s.mux.HandleFunc("/control/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		if !controlAuthorized(r, controlToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		before := maint.Status().Enabled
+		maint.ServeHTTP(w, r)
+		if after := maint.Status(); after.Enabled != before {
+			if after.Enabled {
+				logger.Info("Entered maintenance mode, turning new connections away")
+			} else {
+				logger.Info("Left maintenance mode")
+			}
+		}
+	})
+
+	// Operators list the active sessions, authorized like the control API
+	s.mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if !controlAuthorized(r, controlToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		running.ServeHTTP(w, r)
+	})
+
+	// sessionLog returns the log of a new session, which keeps its lines for
+	// the client with --remote-logs
+	sessionLog := func() *remotelog.Session {
+		if !remoteLogs {
+			return nil
+		}
+		return remotelog.NewSession()
+	}
+
+	// newConnection creates a peer connection that streams t over the file
+	// channel once it opens
+	newConnection := func(t transfer) (*webrtc.PeerConnection, error) {
+		// Only the identities an export allows may stream it, and without
+		// --file clients must name an export
+		if t.export != nil && !t.export.Allows(t.identity) {
+			return nil, fmt.Errorf("%w: %s", exports.ErrForbidden, t.export.Name)
+		}
+		if t.file == "" {
+			return nil, fmt.Errorf("%w: no --file to stream, name one of the exports", exports.ErrUnknown)
+		}
+
+		// Admit the session unless the server runs --max-connections
+		// already; it counts until its connection is closed
+		admitted, err := running.Admit(sessions.Info{Identity: t.identity, Addr: t.addr, File: t.file})
+		if err != nil {
+			return nil, err
+		}
+
+		// Use a public STUN server once direct connections have failed
+		pcAPI, pcServers := api, iceServers
+		if fallback != nil {
+			if servers := fallback.ICEServers(); len(servers) > 0 {
+				pcAPI, pcServers = peer.NewAPI(servers, cfg.Interceptors), servers
+			}
+		}
+
+		// Create a new peer connection
+		peerConnection, err := pcAPI.NewPeerConnection(webrtc.Configuration{ICEServers: pcServers})
+		if err != nil {
+			admitted.Release()
+			return nil, fmt.Errorf("failed to create peer connection: %w", err)
+		}
+		// A panic in one of the connection's handlers closes only this
+		// session. Closing blocks on the callbacks, so it runs on its own.
+		isolate := func() { go peerConnection.Close() }
+
+		// Let the caller add handlers of its own, and close the connection
+		// once the context it was answered in ends
+		if cfg.OnConnection != nil {
+			cfg.OnConnection(t.session, peerConnection)
+		}
+		stopContext := func() bool { return false }
+		if t.ctx != nil {
+			stopContext = context.AfterFunc(t.ctx, func() { peerConnection.Close() })
+		}
+
+		// Report how far the file stream got after every line, and once how
+		// it ended
+		var progressed func(n int)
+		finish := func(received int, err error) {}
+		if cfg.OnProgress != nil {
+			var mu sync.Mutex
+			var once sync.Once
+			progress := Progress{ID: t.session}
+			progressed = func(n int) {
+				mu.Lock()
+				progress.Lines++
+				progress.Bytes += int64(n)
+				p := progress
+				mu.Unlock()
+				cfg.OnProgress(p)
+			}
+			finish = func(received int, err error) {
+				once.Do(func() {
+					mu.Lock()
+					progress.Done, progress.Received, progress.Err = true, received, err
+					p := progress
+					mu.Unlock()
+					cfg.OnProgress(p)
+				})
+			}
+		}
+
+		// Close the connection once the client is done with it, or if it
+		// never connects; heartbeats and logs do not keep it open
+		life := lifecycle.Watch(peerConnection, lifecycle.Options{
+			IdleTimeout: idleTimeout,
+			Ignore:      []string{heartbeat.Protocol, remotelog.Protocol},
+			OnClose: func(reason string) {
+				t.log.Info("Closing the connection, %s", reason)
+			},
+		})
+
+		// Record the connection's signaling and state machines in the trace
+		trace.Bind(peerConnection, t.session)
+		trace.Watch(peerConnection)
+
+		// Monitor connection state changes, and forget the session of the
+		// client's identity once the connection is over
+		var connected bool
+		release := func() {}
+		peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+			t.log.Info("Connection state changed: %s", state.String())
+			trace.Transition(t.session, "connection", state.String())
+			life.State(state)
+			admitted.SetState(state.String())
+			if cfg.OnState != nil {
+				cfg.OnState(t.session, state)
+			}
+
+			switch state {
+			case webrtc.PeerConnectionStateConnected:
+				connected = true
+				t.log.Info("WebRTC connection established successfully!")
+				if pair, err := peerConnection.SCTP().Transport().ICETransport().GetSelectedCandidatePair(); err == nil && pair != nil {
+					t.log.Debug("Selected candidate pair: %s", pair)
+				}
+			case webrtc.PeerConnectionStateFailed:
+				t.log.Error("WebRTC connection failed")
+				if fallback != nil && !connected {
+					server, _ := fallback.Failed()
+					t.log.Info("Next connections will use public STUN server %s", server)
+				}
+				release()
+				trace.Unbind(peerConnection)
+				finish(0, errors.New("connection failed"))
+			case webrtc.PeerConnectionStateClosed:
+				t.log.Info("WebRTC connection closed")
+				release()
+				admitted.Release()
+				trace.Unbind(peerConnection)
+				stopContext()
+				finish(0, errors.New("connection closed"))
+			}
+		})
+
+		// Log how ICE checks the candidates, for clients debugging why the
+		// connection fails
+		peerConnection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+			t.log.Debug("ICE connection state changed: %s", state)
+			trace.Transition(t.session, "ice", state.String())
+		})
+
+		// Announce the file stream channel to the client, or create the one
+		// it created with the same ID
+		dataChannel, err := peer.CreateFileChannel(peerConnection, cfg.Channel)
+		if err != nil {
+			peerConnection.Close()
+			return nil, fmt.Errorf("failed to create data channel: %w", err)
+		}
+		trace.Bind(dataChannel, t.session)
+
+		// Clients accepting the channel in-band tell with the channel their
+		// offer carried whether they read control messages; clients of the
+		// first protocol only get the lines
+		var hello *control.Hello
+		if !negotiatedChannel {
+			hello = control.NewHello()
+		}
+
+		// Let operators follow the session and end it through /sessions
+		admitted.Attach(func() sessions.Stats {
+			stats := sessions.Stats{Buffered: dataChannel.BufferedAmount()}
+			for _, s := range peerConnection.GetStats() {
+				if channel, ok := s.(webrtc.DataChannelStats); ok {
+					stats.MessagesSent += uint64(channel.MessagesSent)
+					stats.BytesSent += channel.BytesSent
+				}
+			}
+			return stats
+		}, func() {
+			t.log.Info("Ending the session at an operator's request")
+			go peerConnection.Close()
+		})
+		admitted.OnStall(func() {
+			if stallPolicy == sessions.Report {
+				t.log.Info("Warning: the client acknowledged no data for %s, reporting the session as stalled", stallTimeout)
+				return
+			}
+			t.log.Info("Warning: the client acknowledged no data for %s, closing the connection", stallTimeout)
+			go peerConnection.Close()
+		})
+
+		// stream sends a file over one of the connection's data channels,
+		// pacing it like every other transfer
+		stream := func(dataChannel *webrtc.DataChannel, filename string, opts streamOptions) (int, error) {
+			// Pace at the configured delay, or follow the connection quality
+			base := time.Duration(delay) * time.Millisecond
+			pace := func() time.Duration { return base }
+			if adaptive {
+				monitor := quality.NewMonitor(peer.ChannelName(dataChannel), base, func() quality.Sample {
+					return quality.SampleFromStats(peerConnection.GetStats(), dataChannel.BufferedAmount())
+				})
+				monitor.Start(time.Second)
+				defer monitor.Stop()
+				pace = monitor.Delay
+			}
+
+			// Let the governor pause the transfer while over --memory-limit
+			opts.session = governor.Track(filename+" on "+peer.ChannelName(dataChannel), opts.priority, func() int64 {
+				return int64(dataChannel.BufferedAmount())
+			})
+			defer opts.session.Release()
+
+			opts.pace, opts.pacer, opts.source = pace, pacer, sourceOpts
+			opts.rate = NewLimiter(rate).Delay
+			if cfg.Pace != nil {
+				opts.rate = cfg.Pace()
+			}
+			// A followed file has no end to finish by, and a mapping would
+			// not grow with it
+			if opts.follow {
+				opts.source.MMap = false
+			} else {
+				opts.completeBy = completeBy
+			}
+			return streamFile(dataChannel, filename, opts)
+		}
+
+		// The client acknowledges the end of the stream with the number of
+		// lines it received, and may advertise how many it accepts and ask
+		// for a digest of the stream
+		acks := make(chan int, 1)
+		closed := make(chan struct{})
+		window := control.NewReceiveWindow(t.window)
+		var verify atomic.Bool
+		verify.Store(t.verify)
+		var member atomic.Pointer[groups.Member]
+		dataChannel.OnMessage(crash.Callback("server file channel", isolate, func(msg webrtc.DataChannelMessage) {
+			kind, lines, ok := control.Decode(msg)
+			if ok {
+				trace.Frame(dataChannel, trace.Recv, control.Name(kind), strconv.Itoa(lines))
+			}
+			switch {
+			case ok && kind == control.Ack:
+				select {
+				case acks <- lines:
+				default:
+				}
+			case ok && kind == control.Window:
+				window.Advertise(lines)
+			case ok && kind == control.Verify:
+				verify.Store(true)
+			case ok && kind == control.Commit:
+				// Saving the offset writes a file, which must not hold up
+				// the channel
+				if m := member.Load(); m != nil {
+					go func() {
+						if err := m.Commit(lines); err != nil {
+							t.log.Error("Failed to commit the offset of group %s: %v", t.group, err)
+						}
+					}()
+				}
+			}
+		}))
+
+		// Set up data channel handlers
+		dataChannel.OnOpen(func() {
+			t.log.Info("Data channel opened")
+			trace.Transition(t.session, "channel "+dataChannel.Label(), "open")
+
+			// Stream the file once a worker is free. Followed files, watched
+			// and looped exports only end when the client leaves, and
+			// broadcasts are read once for every client, which would miss
+			// their lines while queued, so they run outside the limit.
+			run := workers.Go
+			if t.pushID == "" && (t.export == nil && (follow || hub != nil) || t.export != nil && (t.export.Watch || t.export.Mode == exports.Loop)) {
+				run = workers.Spawn
+			}
+			run("transfer of "+t.file, func() {
+				defer dataChannel.Close()
+				defer crash.Recover("server transfer", isolate)
+
+				// --length bounds the --file stream, not pushed files or
+				// exports, which bring their own settings
+				opts := streamOptions{offset: t.offset, prefix: t.prefix, timestamps: timestamps, records: t.records, retry: sendRetry, closed: closed}
+				if lowLatency {
+					opts.queue = lowLatencyQueue
+				}
+				if t.batch {
+					opts.batch = batching
+				}
+				isBundle := bundled && t.pushID == ""
+				if t.export != nil {
+					isBundle = t.export.Bundle
+					opts.limit, opts.follow = t.export.Limit(), t.export.Watch
+				} else if t.pushID == "" {
+					opts.length, opts.follow, opts.raw = length, follow, raw
+					opts.open, opts.progress = cfg.Open, progressed
+				}
+				if fecData > 0 {
+					opts.fec, _ = fec.NewEncoder(fecData, fecParity)
+				}
+				// Lines lost on an unreliable channel never reach the client's
+				// output, so its window would only ever shrink and its digest
+				// would not match
+				var digest hash.Hash
+				if !unreliable {
+					opts.window = window
+					digest = sha256.New()
+					opts.digest = digest
+				}
+				// Clients of a broadcast join the stream every client gets
+				joined := hub != nil && t.export == nil && t.pushID == ""
+				if joined || t.group != "" {
+					opts.window = nil
+					opts.records = terms
+				}
+				if opts.raw && t.group != "" {
+					err := fmt.Errorf("consumer groups share lines, they cannot share the raw chunks of %s", t.file)
+					t.log.Error("Aborting transfer: %v", err)
+					finish(0, err)
+					return
+				}
+				// Clients of the first protocol write every message they get
+				// as a line, so they only get the lines, as they are
+				legacy := hello != nil && !hello.Current(helloTimeout)
+				if legacy {
+					t.log.Info("Client does not read control messages, sending it only the lines")
+					if isBundle {
+						err := errors.New("a stream of several files needs a client that reads control messages")
+						t.log.Error("Aborting transfer: %v", err)
+						finish(0, err)
+						return
+					}
+					if opts.raw {
+						err := errors.New("raw chunks need a client that reads control messages")
+						t.log.Error("Aborting transfer: %v", err)
+						finish(0, err)
+						return
+					}
+					opts.timestamps, opts.records, opts.fec, opts.window = false, records.Terms{}, nil, nil
+					digest, opts.digest = nil, nil
+				}
+				var sent int
+				for {
+					var n int
+					switch {
+					case joined:
+						n, err = joinBroadcast(dataChannel, hub, opts, closed)
+					case t.group != "":
+						n, err = joinGroup(dataChannel, consumers, t.group, opts, closed, &member)
+					case isBundle:
+						n, err = streamBundle(dataChannel, t.file, opts, stream)
+					default:
+						n, err = stream(dataChannel, t.file, opts)
+					}
+					sent += n
+
+					// Looped and watched exports and followed files only end
+					// when the client disconnects
+					if t.export != nil && (t.export.Mode == exports.Loop || t.export.Watch) && dataChannel.ReadyState() != webrtc.DataChannelStateOpen {
+						t.log.Info("Client left export %s after %d lines", t.export.Name, sent)
+						finish(0, nil)
+						return
+					}
+					if t.export == nil && opts.follow && dataChannel.ReadyState() != webrtc.DataChannelStateOpen {
+						t.log.Info("Client left %s after %d lines", t.file, sent)
+						finish(0, nil)
+						return
+					}
+					if err != nil || t.export == nil || t.export.Mode != exports.Loop {
+						break
+					}
+					opts.streamed = sent
+				}
+				if err != nil {
+					t.log.Error("Aborting transfer: %v", err)
+					if errors.Is(err, crash.ErrPanic) {
+						isolate()
+					}
+					// Let TooLong reach the client before the channel closes
+					if errors.Is(err, records.ErrTooLong) {
+						control.Drain(dataChannel, closed, tooLongTimeout)
+					}
+					finish(0, err)
+					return
+				}
+
+				// The first protocol ends the stream by closing the channel,
+				// once it sent everything queued on it
+				if legacy {
+					err := control.Drain(dataChannel, closed, finishTimeout)
+					if err != nil {
+						t.log.Info("Warning: %v", err)
+					}
+					finish(0, err)
+					return
+				}
+
+				// Let the client check its output against what was sent
+				if digest != nil && verify.Load() {
+					if err := control.SendFrame(dataChannel, control.Digest, digest.Sum(nil)); err != nil {
+						t.log.Error("Failed to send the digest of the stream: %v", err)
+					}
+				}
+
+				// Only close the channel once the client has everything
+				received, err := control.Finish(dataChannel, sent, acks, closed, finishTimeout)
+				if err != nil {
+					t.log.Info("Warning: %v", err)
+				} else if received != sent {
+					t.log.Info("Warning: the client received %d of %d lines", received, sent)
+				}
+				finish(received, err)
+
+				// The last lines a client of a consumer group wrote are
+				// committed after it acknowledged them
+				if m := member.Load(); m != nil && !m.Settle(sent, commitTimeout) {
+					t.log.Info("Warning: the client did not commit every line, group %s receives the rest again", t.group)
+				}
+
+				// The push has been delivered and cannot be resumed any more
+				if t.pushID != "" {
+					registry.Complete(t.pushID)
+				}
+			})
+		})
+
+		dataChannel.OnClose(func() {
+			t.log.Info("Data channel closed")
+			trace.Transition(t.session, "channel "+dataChannel.Label(), "closed")
+			trace.Unbind(dataChannel)
+			close(closed)
+			life.Finished()
+		})
+
+		// Apply the duplicate connection policy to the client's identity. A
+		// session taken over is told why before it is closed.
+		claimed, err := active.Claim(t.identity, func() {
+			t.log.Info("Ending the session of client %s, a new connection took over", t.identity)
+			if hello != nil && !hello.Current(helloTimeout) {
+				peerConnection.Close()
+				return
+			}
+			if err := control.Send(dataChannel, control.Superseded, 0); err == nil {
+				control.Drain(dataChannel, closed, supersedeTimeout)
+			}
+			peerConnection.Close()
+		})
+		if err != nil {
+			peerConnection.Close()
+			return nil, err
+		}
+		release = claimed
+
+		// Serve the files the client requests over the same connection, and
+		// expand the patterns it lists
+		peerConnection.OnDataChannel(crash.Callback("server data channel", isolate, func(request *webrtc.DataChannel) {
+			if hello != nil && hello.Opened(request) {
+				return
+			}
+			protocol := request.Protocol()
+			if protocol == forward.Protocol {
+				if forwarder == nil {
+					t.log.Error("Client opened a forward channel, but --forward is not set")
+					request.OnOpen(func() { request.Close() })
+					return
+				}
+				forwarder.Serve(request)
+				return
+			}
+			if protocol == tunnel.Protocol {
+				serveTunnel(request, allowTunnels)
+				return
+			}
+			if protocol == upload.Protocol {
+				serveUpload(request, uploads, t.identity, workers, isolate)
+				return
+			}
+			if protocol == heartbeat.Protocol {
+				heartbeat.Serve(request)
+				return
+			}
+			if protocol == remotelog.Protocol {
+				t.log.Attach(request)
+				return
+			}
+			if protocol != share.Protocol && protocol != share.ListProtocol && protocol != share.DedupProtocol {
+				return
+			}
+
+			// Deduplicated requests answer the manifest with the chunks
+			// they need, and may ask for corrupted chunks again
+			needs := newMessageQueue()
+			closed := make(chan struct{})
+			request.OnMessage(func(msg webrtc.DataChannelMessage) {
+				needs.push(msg)
+			})
+			request.OnClose(func() {
+				close(closed)
+			})
+
+			request.OnOpen(func() {
+				workers.Go("request for "+request.Label(), func() {
+					defer request.Close()
+					defer crash.Recover("server request", isolate)
+
+					var result share.Result
+					var err error
+					switch protocol {
+					case share.ListProtocol:
+						t.log.Info("Client listed %s", request.Label())
+						result.Files, err = share.Expand(sharePolicy, request.Label())
+					case share.DedupProtocol:
+						var path string
+						if path, err = share.Resolve(sharePolicy, request.Label()); err == nil {
+							algorithm := checksum.Negotiate(t.checksums, checksums)
+							t.log.Info("Client requested %s with deduplication, hashed with %s", request.Label(), algorithm)
+							result.Lines, err = streamDeduplicated(request, path, index, algorithm, needs, closed, stream)
+						}
+					default:
+						var path string
+						if path, err = share.Resolve(sharePolicy, request.Label()); err == nil {
+							t.log.Info("Client requested %s", request.Label())
+							// Index the file from the lines streamed, so a
+							// deduplicated request for it does not have to
+							// read it again
+							opts := streamOptions{priority: memlimit.Low}
+							recorder := index.Record(path, checksums[0])
+							if recorder != nil {
+								opts.tee = recorder.Add
+							}
+							result.Lines, err = stream(request, path, opts)
+							if err == nil && recorder != nil {
+								if err := recorder.Finish(); err != nil {
+									t.log.Error("Failed to index %s: %v", request.Label(), err)
+								}
+							}
+						}
+					}
+					if err != nil {
+						t.log.Error("Request for %s failed: %v", request.Label(), err)
+						result.Error = requestError(err)
+					}
+					if err := request.Send(result.Encode()); err != nil {
+						t.log.Error("Failed to send result of %s: %v", request.Label(), err)
+					}
+					if errors.Is(err, crash.ErrPanic) {
+						isolate()
+					}
+				})
+			})
+		}))
+
+		return peerConnection, nil
+	}
+
+	// answerOffer creates a peer connection for an offer and returns the answer
+	// once ICE gathering is complete
+	answerOffer := func(offer webrtc.SessionDescription, t transfer, trickled func(*webrtc.PeerConnection)) ([]byte, error) {
+		peerConnection, err := newConnection(t)
+		if err != nil {
+			return nil, err
+		}
+
+		// Trickle the candidates instead of waiting for them
+		if trickled != nil {
+			trickled(peerConnection)
+		}
+
+		// Answer, waiting for ICE gathering to complete unless the
+		// candidates trickle
+		trace.Description(t.session, trace.Recv, offer)
+		if trickled == nil {
+			t.log.Info("Waiting for ICE gathering to complete...")
+		}
+		answer, err := AnswerOffer(peerConnection, offer, trickled == nil)
+		if err != nil {
+			peerConnection.Close()
+			return nil, err
+		}
+		if trickled == nil {
+			t.log.Info("ICE gathering complete")
+		}
+		trace.Description(t.session, trace.Send, answer)
+
+		answerJSON, err := json.Marshal(answer)
+		if err != nil {
+			peerConnection.Close()
+			return nil, fmt.Errorf("failed to encode answer: %w", err)
+		}
+		return answerJSON, nil
+	}
+
+	// createOffer creates a peer connection and its offer, for clients that
+	// let the server make the offer
+	createOffer := func(t transfer) (*webrtc.PeerConnection, []byte, error) {
+		peerConnection, err := newConnection(t)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		offer, err := peerConnection.CreateOffer(nil)
+		if err != nil {
+			peerConnection.Close()
+			return nil, nil, fmt.Errorf("failed to create offer: %w", err)
+		}
+		if err := peerConnection.SetLocalDescription(offer); err != nil {
+			peerConnection.Close()
+			return nil, nil, fmt.Errorf("failed to set local description: %w", err)
+		}
+
+		// Wait for ICE gathering to complete
+		t.log.Info("Waiting for ICE gathering to complete...")
+		<-webrtc.GatheringCompletePromise(peerConnection)
+		t.log.Info("ICE gathering complete")
+
+		trace.Description(t.session, trace.Send, *peerConnection.LocalDescription())
+		offerJSON, err := json.Marshal(*peerConnection.LocalDescription())
+		if err != nil {
+			peerConnection.Close()
+			return nil, nil, fmt.Errorf("failed to encode offer: %w", err)
+		}
+		return peerConnection, offerJSON, nil
+	}
+
+	// claimTransfer decides what a connection request streams: the file of a
+	// scheduled push instead of the default one, resuming after the lines the
+	// peer already received. It writes the error response itself.
+	claimTransfer := func(w http.ResponseWriter, r *http.Request) (transfer, bool) {
+		t := transfer{file: filename, pushID: r.URL.Query().Get("push"), addr: clientAddr(r), log: sessionLog(), session: r.URL.Query().Get(trace.Param)}
+		if t.session == "" {
+			t.session = trace.NewSession()
+		}
+		maxRecord := 0
+		if v := r.URL.Query().Get("max_record"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				signaling.Reject(http.StatusBadRequest, signaling.CodeInvalidRecord, "Invalid max_record").Write(w)
+				return t, false
+			}
+			maxRecord = n
+		}
+		terms, err := recordTerms.Negotiate(maxRecord, r.URL.Query().Get("oversized"))
+		if err != nil {
+			signaling.Reject(http.StatusBadRequest, signaling.CodeInvalidRecord, "Invalid record terms: %v", err).Write(w)
+			return t, false
+		}
+		t.records = terms
+		if v := r.URL.Query().Get("window"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				signaling.Reject(http.StatusBadRequest, signaling.CodeInvalidWindow, "Invalid window").Write(w)
+				return t, false
+			}
+			t.window = n
+		}
+		if v := r.URL.Query().Get("checksums"); v != "" {
+			t.checksums = strings.Split(v, ",")
+		}
+		t.verify = r.URL.Query().Has("verify")
+		t.batch = r.URL.Query().Has("batch")
+		if name := r.URL.Query().Get("group"); name != "" {
+			if err := groups.ValidName(name); err != nil {
+				signaling.Reject(http.StatusBadRequest, signaling.CodeInvalidGroup, "%v", err).Write(w)
+				return t, false
+			}
+			if consumers == nil || t.pushID != "" || r.URL.Query().Has("export") {
+				signaling.Reject(http.StatusConflict, signaling.CodeGroupConflict, "Consumer groups share the server's --file, which is not streamed").Write(w)
+				return t, false
+			}
+			if unreliable {
+				signaling.Reject(http.StatusConflict, signaling.CodeGroupConflict, "Consumer groups need a reliable stream, which --unreliable is not").Write(w)
+				return t, false
+			}
+			t.group = name
+		}
+		// Replay the file stream from a sequence number, the number of lines
+		// before it, or from the lines that arrived since a time
+		if sinceSeq, since := r.URL.Query().Get("since_seq"), r.URL.Query().Get("since"); sinceSeq != "" || since != "" {
+			if t.pushID != "" || t.group != "" || r.URL.Query().Has("export") || hub != nil || filename == "" || bundled || opened || raw {
+				signaling.Reject(http.StatusConflict, signaling.CodeReplayConflict, "Only the lines of the --file stream of a single client are replayed").Write(w)
+				return t, false
+			}
+			switch {
+			case sinceSeq != "" && since != "":
+				signaling.Reject(http.StatusBadRequest, signaling.CodeInvalidSince, "since and since_seq cannot be combined").Write(w)
+				return t, false
+			case sinceSeq != "":
+				n, err := strconv.Atoi(sinceSeq)
+				if err != nil || n < 0 {
+					signaling.Reject(http.StatusBadRequest, signaling.CodeInvalidSince, "Invalid since_seq").Write(w)
+					return t, false
+				}
+				t.offset = n
+			default:
+				d, err := time.ParseDuration(since)
+				if err != nil || d <= 0 {
+					signaling.Reject(http.StatusBadRequest, signaling.CodeInvalidSince, "Invalid since").Write(w)
+					return t, false
+				}
+				if retained == nil {
+					signaling.Reject(http.StatusConflict, signaling.CodeReplayConflict, "The server keeps no --retention log to replay from").Write(w)
+					return t, false
+				}
+				t.offset = retained.Since(time.Now().Add(-d))
+			}
+			t.log.Info("Client replays %s from line %d", filename, t.offset)
+		}
+		if t.pushID == "" {
+			name := r.URL.Query().Get("export")
+			if name == "" {
+				return t, true
+			}
+			export, err := shared.Lookup(name)
+			if err != nil {
+				signaling.Reject(http.StatusNotFound, signaling.CodeUnknownExport, "Unknown export").Write(w)
+				return t, false
+			}
+			if export.Bundle && unreliable {
+				signaling.Reject(http.StatusConflict, signaling.CodeExportConflict, "Export streams several files, which --unreliable cannot").Write(w)
+				return t, false
+			}
+			t.log.Info("Client requested export %s", export.Name)
+			t.export, t.file = export, export.Path
+			return t, true
+		}
+
+		// A request that is refused leaves the push to be claimed again
+		if o := r.URL.Query().Get("offset"); o != "" {
+			n, err := strconv.Atoi(o)
+			if err != nil || n < 0 {
+				signaling.Reject(http.StatusBadRequest, signaling.CodeInvalidOffset, "Invalid offset").Write(w)
+				return t, false
+			}
+			t.offset = n
+			t.prefix = r.URL.Query().Get("prefix")
+		}
+		push, ok := registry.Claim(t.pushID)
+		if !ok {
+			signaling.Reject(http.StatusGone, signaling.CodeUnknownPush, "Unknown push").Write(w)
+			return t, false
+		}
+		t.log.Info("Peer %s connected for push %s of %s", push.Peer, push.ID, push.File)
+		t.file = push.File
+		return t, true
+	}
+
+	// Handle HTTP requests, letting the pages of the CORS origins post
+	// offers. The connections of the offers live until life ends.
+	s.offer = func(life context.Context) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			// Check the client's token if one is required
+			if !Authorized(r, authToken) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if maint.Reject(w) || governor.Reject(w) || running.Reject(w) {
+				return
+			}
+
+			// This server makes the offers itself
+			if offerRole == signaling.OfferRoleServer {
+				http.Error(w, "Server creates the offer, use --offer-role server", http.StatusConflict)
+				return
+			}
+
+			t, ok := claimTransfer(w, r)
+			if !ok {
+				return
+			}
+			t.ctx = life
+
+			// Read the raw offer from the request body, bounded and in the
+			// content type of a plain or a sealed offer
+			sessionID := r.Header.Get(noise.SessionHeader)
+			contentType := "application/json"
+			if sessionID != "" {
+				contentType = "application/octet-stream"
+			}
+			offerBytes, err := signaling.ReadOffer(w, r, contentType)
+			if err != nil {
+				t.log.Error("Rejected offer: %v", err)
+				signaling.WriteError(w, err)
+				return
+			}
+
+			// Decrypt an offer sent over Noise secured signaling, whose handshake
+			// already proved the client's identity
+			var session *noise.Session
+			var clientID string
+			if sessionID != "" {
+				session, offerBytes, err = openSealedOffer(handshakes, sessionID, offerBytes)
+				if err != nil {
+					t.log.Error("Rejected offer: %v", err)
+					signaling.Reject(http.StatusBadRequest, signaling.CodeInvalidSession, "Invalid Noise session").Write(w)
+					return
+				}
+				clientID = session.PeerIdentity
+			} else if requireNoise {
+				signaling.Reject(http.StatusForbidden, signaling.CodeNoiseRequired, "Noise secured signaling required").Write(w)
+				return
+			} else if clientID, err = checkIdentity(r, offerBytes, allowed); err != nil {
+				t.log.Error("Rejected offer: %v", err)
+				signaling.Reject(http.StatusForbidden, signaling.CodeForbidden, "Forbidden").Write(w)
+				return
+			}
+
+			// Check the client's identity against the allowlist
+			if session != nil {
+				if err := allowedIdentity(clientID, allowed); err != nil {
+					t.log.Error("Rejected offer: %v", err)
+					signaling.Reject(http.StatusForbidden, signaling.CodeForbidden, "Forbidden").Write(w)
+					return
+				}
+			}
+			if clientID != "" {
+				t.log.Info("Client identity: %s", clientID)
+			}
+			t.identity = clientID
+
+			// Log the raw offer for debugging
+			t.log.Debug("Raw offer received: %s", string(offerBytes))
+
+			// Parse the offer strictly instead of handing pion whatever was sent
+			offer, err := signaling.ParseOffer(offerBytes)
+			if err != nil {
+				t.log.Error("Rejected offer: %v", err)
+				signaling.WriteError(w, err)
+				return
+			}
+
+			// Log the parsed offer for debugging
+			t.log.Debug("Parsed offer type: %s", offer.Type.String())
+
+			// Log the parsed offer for debugging
+			offerJSON, _ := json.Marshal(offer)
+			t.log.Debug("Parsed offer: %s", string(offerJSON))
+
+			// Answer right away and trickle the candidates if the client asked
+			// to, unless the offer is sealed, whose candidates must stay secret
+			var trickleID string
+			var trickled func(*webrtc.PeerConnection)
+			if session == nil && r.Header.Get(trickle.RequestHeader) != "" {
+				trickled = func(pc *webrtc.PeerConnection) { trickleID = trickles.Add(pc) }
+			}
+
+			answerJSON, err := answerOffer(offer, t, trickled)
+			if err != nil {
+				t.log.Error("%v", err)
+				connectionError(w, err)
+				return
+			}
+			if trickleID != "" {
+				w.Header().Set(trickle.SessionHeader, trickleID)
+			}
+
+			// Return the answer, signed with the server's identity
+			if session != nil {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(session.Seal(answerJSON))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set(identity.Header, serverID.Sign(identity.RoleServer, answerJSON, time.Now()))
+			w.Write(answerJSON)
+		})
+	}
+	s.mux.Handle("/offer", webui.CORS(corsOrigins, s.offer(context.Background())))
+
+	// Clients that let the server make the offer fetch it here and send their
+	// answer to /answer
+	s.mux.HandleFunc("/server-offer", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !Authorized(r, authToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if maint.Reject(w) || governor.Reject(w) || running.Reject(w) {
+			return
+		}
+		if offerRole != signaling.OfferRoleServer {
+			http.Error(w, "Client creates the offer, use --offer-role client", http.StatusConflict)
+			return
+		}
+		clientID, err := checkIdentity(r, nil, allowed)
+		if err != nil {
+			logger.Error("Rejected offer request: %v", err)
+			signaling.Reject(http.StatusForbidden, signaling.CodeForbidden, "Forbidden").Write(w)
+			return
+		} else if clientID != "" {
+			logger.Info("Client identity: %s", clientID)
+		}
+
+		t, ok := claimTransfer(w, r)
+		if !ok {
+			return
+		}
+		t.identity = clientID
+		peerConnection, offerJSON, err := createOffer(t)
+		if err != nil {
+			t.log.Error("%v", err)
+			connectionError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(signaling.OfferSessionHeader, offers.add(peerConnection))
+		w.Header().Set(identity.Header, serverID.Sign(identity.RoleServer, offerJSON, time.Now()))
+		w.Write(offerJSON)
+	})
+
+	s.mux.HandleFunc("/answer", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !Authorized(r, authToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// Only the client that requested the offer knows its session
+		peerConnection, ok := offers.take(r.Header.Get(signaling.OfferSessionHeader))
+		if !ok {
+			http.Error(w, "Unknown or expired offer", http.StatusGone)
+			return
+		}
+
+		var answer webrtc.SessionDescription
+		if err := json.NewDecoder(r.Body).Decode(&answer); err != nil {
+			peerConnection.Close()
+			http.Error(w, "Failed to parse answer: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		trace.Description(trace.SessionOf(peerConnection), trace.Recv, answer)
+		if err := peerConnection.SetRemoteDescription(answer); err != nil {
+			peerConnection.Close()
+			http.Error(w, "Failed to set remote description: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Clients that asked for trickle ICE exchange candidates here after the
+	// answer
+	s.mux.HandleFunc(trickle.Path, func(w http.ResponseWriter, r *http.Request) {
+		if !Authorized(r, authToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		trickles.ServeHTTP(w, r)
+	})
+
+	// Noise secured signaling starts with a handshake before the offer
+	s.mux.HandleFunc("/noise", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !Authorized(r, authToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if maint.Reject(w) || governor.Reject(w) || running.Reject(w) {
+			return
+		}
+
+		msg, err := io.ReadAll(io.LimitReader(r.Body, 1024))
+		if err != nil {
+			http.Error(w, "Failed to read handshake: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Clients name the suite they started the handshake with
+		suite, err := noise.SuiteByName(r.Header.Get(noise.ProtocolHeader))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !fips.Allowed(suite) {
+			http.Error(w, fmt.Sprintf("Noise protocol %s is not FIPS approved, use %s", suite.Name(), noise.Approved.Name()), http.StatusBadRequest)
+			return
+		}
+		responder, err := noise.NewResponder(suite, serverID, noise.Prologue)
+		if err != nil {
+			http.Error(w, "Failed to start handshake: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reply, err := responder.Respond(msg)
+		if err != nil {
+			http.Error(w, "Invalid handshake: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(noise.SessionHeader, handshakes.Add(responder))
+		w.Write(reply)
+	})
+
+	// Daemon mode clients long-poll for scheduled pushes
+	s.mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		if !Authorized(r, authToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if _, err := checkIdentity(r, nil, allowed); err != nil {
+			logger.Error("Rejected registration: %v", err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		name := r.URL.Query().Get("name")
+		push, ok, err := registry.Wait(name, registerPollTimeout)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Peer %q is not registered", name), http.StatusNotFound)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		pushJSON, err := json.Marshal(push)
+		if err != nil {
+			logger.Error("Failed to encode push: %v", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(identity.Header, serverID.Sign(identity.RoleServer, pushJSON, time.Now()))
+		w.Write(pushJSON)
+	})
+
+	// Orchestration systems push files to connected daemons on demand,
+	// authorized like the control API
+	s.mux.HandleFunc("/push", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !controlAuthorized(r, controlToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if maint.Reject(w) || governor.Reject(w) {
+			return
+		}
+
+		var req PushRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil || req.Peer == "" || req.File == "" {
+			http.Error(w, "Expected a JSON body with a peer and a file", http.StatusBadRequest)
+			return
+		}
+		file, err := PushPath(req.File, sharePolicy, shared, cfg.FollowSymlinks)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		req.File = file
+		if info, err := os.Stat(req.File); err != nil || !info.Mode().IsRegular() {
+			http.Error(w, fmt.Sprintf("File %q is not a regular file", req.File), http.StatusBadRequest)
+			return
+		}
+		connected, err := registry.Connected(req.Peer, pushIdleTimeout)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Peer %q is not registered", req.Peer), http.StatusNotFound)
+			return
+		}
+		if !connected {
+			http.Error(w, fmt.Sprintf("Peer %q is not connected", req.Peer), http.StatusConflict)
+			return
+		}
+
+		push, err := registry.Push(req.Peer, req.File)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Queued push %s of %s to %s through the API", push.ID, push.File, push.Peer)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(push)
+	})
+
+	// Serve the page browsers receive the file stream with, gathering with
+	// the ICE servers that need no credentials, which the page would reveal
+	if webUI {
+		label := cfg.Channel.Label
+		if label == "" {
+			label = peer.DefaultLabel
+		}
+		settings := webui.Settings{
+			ChannelLabel:    label,
+			ChannelID:       cfg.Channel.ID,
+			ChannelProtocol: cfg.Channel.Protocol,
+			Negotiated:      negotiatedChannel,
+			ICEServers:      []string{},
+			Token:           authToken != "",
+		}
+		for _, ice := range iceServers {
+			if ice.Username == "" {
+				settings.ICEServers = append(settings.ICEServers, ice.URLs...)
+			}
+		}
+		s.mux.Handle("/", webui.Handler(settings))
+		logger.Info("Serving the web UI at / on %s", cfg.Addr)
+	}
+
+	// Offers relayed through a rendezvous server, pasted into the terminal
+	// or handed to Answer are turned away like those posted to /offer
+	s.answer = func(ctx context.Context, offer webrtc.SessionDescription) ([]byte, error) {
+		if maint.Status().Enabled {
+			return nil, errors.New("turning a relayed offer away in maintenance mode")
+		}
+		if governor.Overloaded() {
+			return nil, errors.New("turning a relayed offer away over the memory limit")
+		}
+		if running.Full() {
+			return nil, errors.New("turning a relayed offer away at the connection limit")
+		}
+		return answerOffer(offer, transfer{file: filename, log: sessionLog(), session: trace.NewSession(), records: recordTerms, ctx: ctx}, nil)
+	}
+	return s, nil
+}
+
+// Handler serves the signaling, control and metrics endpoints, and the web
+// UI if enabled
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// OfferHandler answers the offers posted to it like /offer of the Handler,
+// whatever its path, and closes the connections it starts once ctx ends
+func (s *Server) OfferHandler(ctx context.Context) http.Handler {
+	return s.offer(ctx)
+}
+
+// Answer answers an offer that reached the server some other way than
+// /offer, such as through a rendezvous server, and returns the answer once
+// ICE gathering is complete. The client's identity is not checked.
+func (s *Server) Answer(offer webrtc.SessionDescription) ([]byte, error) {
+	return s.answer(context.Background(), offer)
+}
+
+// AnswerContext is Answer for a connection that is closed once ctx ends
+func (s *Server) AnswerContext(ctx context.Context, offer webrtc.SessionDescription) ([]byte, error) {
+	return s.answer(ctx, offer)
+}
+
+// Close stops the scheduler and the background checks of the server. It
+// does not close the connections in progress; Wait waits for them.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		for _, stop := range s.cleanup {
+			stop()
+		}
+	})
+}
+
+// Wait waits for every transfer, queued ones included, to complete
+func (s *Server) Wait() {
+	s.workers.Wait()
+}
+
+// Run serves cfg.Addr until ctx is canceled or the user quits the terminal
+// UI, then stops accepting clients and waits for the transfers in progress
+// to end. Every binary's server command runs it.
+func Run(ctx context.Context, cfg Config) error {
+	s, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// Start the HTTP server
+	server := &http.Server{Addr: cfg.Addr, Handler: s.Handler()}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	// Serve the runtime profiles to the profile command
+	if path := cfg.DebugSocket; path != "" {
+		debugServer, err := profiling.Serve(path)
+		if err != nil {
+			server.Close()
+			return fmt.Errorf("failed to open debug socket: %w", err)
+		}
+		defer debugServer.Close()
+		logger.Info("Serving runtime profiles on debug socket %s", path)
+	}
+
+	// Let clients on the local network find the server
+	if cfg.Announce {
+		advertiser, err := announceServer(cfg.Addr, cfg.AnnounceName, s.shared, s.serverID, s.offerRole, cfg.AuthToken != "")
+		if err != nil {
+			server.Close()
+			return fmt.Errorf("failed to announce the server: %w", err)
+		}
+		defer advertiser.Close()
+	}
+
+	// Accept offers relayed through a rendezvous server, and those pasted
+	// into the terminal
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if cfg.Rendezvous != "" {
+		go ServeRendezvous(ctx, rendezvous.NewClient(cfg.Rendezvous), s.serverID, cfg.AllowedIdentities, s.Answer)
+	}
+	if s.manual {
+		go servePasted(os.Stdin, cfg.Signal == signaling.SignalQR, s.serverID, cfg.AllowedIdentities, s.Answer)
+	}
+
+	// Show the terminal UI on stderr, with the log inside it, until the
+	// server shuts down; keys are only read from a terminal
+	var serverUI *tui.Server
+	if cfg.TUI {
+		serverUI = tui.NewServer(cfg.Addr, s.running)
+		var keys io.Reader
+		if tui.IsTerminal(os.Stdin) {
+			keys = os.Stdin
+		}
+		logger.SetOutput(serverUI.Writer())
+		go func() {
+			if err := serverUI.Run(keys, os.Stderr); err != nil {
+				logger.Init()
+				logger.Error("Terminal UI failed: %v", err)
+			}
+		}()
+	}
+
+	// Wait for shutdown, for the user to quit the UI, or for the HTTP
+	// server to fail
+	select {
+	case <-ctx.Done():
+	case <-serverUI.Quit():
+	case err = <-serveErr:
+		err = fmt.Errorf("HTTP server error: %w", err)
+	}
+	if serverUI != nil {
+		serverUI.Close()
+		logger.Init()
+	}
+	logger.Info("Shutting down server...")
+	s.Close()
+	cancel()
+
+	// Shutdown the HTTP server
+	if closeErr := server.Close(); closeErr != nil && !errors.Is(closeErr, http.ErrServerClosed) {
+		logger.Error("Error shutting down HTTP server: %v", closeErr)
+	}
+
+	// Wait for all transfers, queued ones included, to complete
+	s.Wait()
+	logger.Info("Server shutdown complete")
+	return err
+}
+
+// announceServer announces the server listening on addr over mDNS as
+// instance, or the host name, with the names of its exports as they are when
+// asked
+func announceServer(addr, instance string, shared *exports.Set, serverID *identity.Identity, offerRole string, auth bool) (*discovery.Advertiser, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --addr: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --addr port: %w", err)
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		logger.Info("Warning: announcing a server that only listens on %s, other hosts cannot connect to it", host)
+	}
+	if instance == "" {
+		if instance, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+
+	advertiser, err := discovery.Advertise(func() discovery.Info {
+		return discovery.Info{
+			Instance:  instance,
+			Port:      port,
+			Path:      "/offer",
+			Identity:  serverID.ID(),
+			OfferRole: offerRole,
+			Auth:      auth,
+			Exports:   shared.Names(),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Announcing the server as %s on the local network", instance)
+	return advertiser, nil
+}
+
+// AnswerOffer sets offer as the remote description of peerConnection and
+// returns its answer, with every candidate once ICE gathering is complete if
+// gather is set
+func AnswerOffer(peerConnection *webrtc.PeerConnection, offer webrtc.SessionDescription, gather bool) (webrtc.SessionDescription, error) {
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to set remote description: %w", err)
+	}
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to create answer: %w", err)
+	}
+	gathered := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		return webrtc.SessionDescription{}, fmt.Errorf("failed to set local description: %w", err)
+	}
+	if gather {
+		<-gathered
+	}
+	return *peerConnection.LocalDescription(), nil
+}
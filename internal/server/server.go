@@ -8,7 +8,8 @@ import (
 	"github.com/developmeh/webrtc-poc/internal/logger"
 )
 
-// StreamFile streams a file line by line to the provided writer
+// StreamFile streams a file line by line to the provided writer, at the
+// rate of delayMs between lines
 // This is a testable version of the streamFile function from cmd/webrtc-poc/main.go
 func StreamFile(writer LineWriter, filename string, delayMs int) error {
 	defer func() {
@@ -25,6 +26,7 @@ func StreamFile(writer LineWriter, filename string, delayMs int) error {
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
+	limiter := NewLimiter(DelayRate(delayMs))
 	lineCount := 0
 
 	for scanner.Scan() {
@@ -40,7 +42,7 @@ func StreamFile(writer LineWriter, filename string, delayMs int) error {
 		logger.Debug("Sent line %d: %s", lineCount, line)
 
 		// Delay between lines
-		time.Sleep(time.Duration(delayMs) * time.Millisecond)
+		time.Sleep(limiter.Delay(len(line)))
 	}
 
 	if err := scanner.Err(); err != nil {
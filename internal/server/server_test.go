@@ -1,9 +1,16 @@
 package server
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/noise"
+	"github.com/developmeh/webrtc-poc/internal/signaling"
 )
 
 // MockLineWriter is a mock implementation of the LineWriter interface for testing
@@ -99,4 +106,170 @@ func TestStreamFile(t *testing.T) {
 			t.Errorf("StreamFile took %v, expected at least %v", elapsed, expectedMinTime)
 		}
 	})
-}
\ No newline at end of file
+}
+func TestRun(t *testing.T) {
+	// Test with a non-existent file
+	t.Run("File not found", func(t *testing.T) {
+		err := Run(context.Background(), Config{Addr: "127.0.0.1:0", File: "non-existent-file.txt"})
+		if err == nil {
+			t.Error("Run should have returned an error for non-existent file")
+		}
+	})
+
+	// Test that canceling the context shuts the server down
+	t.Run("Shutdown", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- Run(ctx, Config{Addr: "127.0.0.1:0", File: "server.go"})
+		}()
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Run returned error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run did not return after the context was canceled")
+		}
+	})
+}
+
+func TestParseRate(t *testing.T) {
+	for spec, want := range map[string]Rate{
+		"1MB/s":       {PerSecond: 1e6},
+		"512KiB/s":    {PerSecond: 512 * 1024},
+		"1.5 GB/s":    {PerSecond: 1.5e9},
+		"200B/s":      {PerSecond: 200},
+		"100lines/s":  {PerSecond: 100, Lines: true},
+		"0.5 lines/s": {PerSecond: 0.5, Lines: true},
+		"1line/s":     {PerSecond: 1, Lines: true},
+	} {
+		got, err := ParseRate(spec)
+		if err != nil || got != want {
+			t.Errorf("ParseRate(%q) = %+v, %v, expected %+v", spec, got, err, want)
+		}
+	}
+	for _, spec := range []string{"", "1MB", "100/s", "0lines/s", "-1MB/s", "fastMB/s", "1TB/s"} {
+		if _, err := ParseRate(spec); err == nil {
+			t.Errorf("Expected ParseRate(%q) to fail", spec)
+		}
+	}
+
+	if got := DelayRate(250); got != (Rate{PerSecond: 4, Lines: true}) {
+		t.Errorf("Expected a delay of 250ms to be 4 lines/s, got %+v", got)
+	}
+	if got := DelayRate(0); got.PerSecond != 0 {
+		t.Errorf("Expected no delay to be no limit, got %+v", got)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := func(rate Rate) *Limiter {
+		l := NewLimiter(rate)
+		l.now = func() time.Time { return now }
+		return l
+	}
+
+	// Bytes are paced by the size of every line
+	l := limiter(Rate{PerSecond: 1000})
+	if d := l.Delay(100); d != 100*time.Millisecond {
+		t.Errorf("Expected 100 bytes at 1000B/s to wait 100ms, got %v", d)
+	}
+	now = now.Add(100 * time.Millisecond)
+	if d := l.Delay(500); d != 500*time.Millisecond {
+		t.Errorf("Expected 500 bytes to wait 500ms, got %v", d)
+	}
+
+	// Oversleeping is made up for, up to the burst
+	now = now.Add(550 * time.Millisecond)
+	if d := l.Delay(40); d != 0 {
+		t.Errorf("Expected the 50ms overslept to cover 40 bytes, got %v", d)
+	}
+	now = now.Add(time.Hour)
+	if d := l.Delay(100); d != 50*time.Millisecond {
+		t.Errorf("Expected an idle limiter to save up only 50 bytes, got %v", d)
+	}
+
+	// Lines are paced regardless of their size
+	l = limiter(Rate{PerSecond: 4, Lines: true})
+	for _, n := range []int{1, 10000} {
+		if d := l.Delay(n); d != 250*time.Millisecond {
+			t.Errorf("Expected a line at 4 lines/s to wait 250ms, got %v", d)
+		}
+		now = now.Add(250 * time.Millisecond)
+	}
+
+	// No rate never waits
+	if d := limiter(Rate{}).Delay(1 << 20); d != 0 {
+		t.Errorf("Expected no limit to never wait, got %v", d)
+	}
+}
+
+// TestOfferErrors checks that offers refused for their query or credentials
+// are answered with a JSON error carrying a code, like malformed ones
+func TestOfferErrors(t *testing.T) {
+	open, err := New(Config{File: "server.go"})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	defer open.Close()
+	noiseOnly, err := New(Config{File: "server.go", RequireNoise: true})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	defer noiseOnly.Close()
+	allowlisted, err := New(Config{File: "server.go", AllowedIdentities: []string{"someone"}})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	defer allowlisted.Close()
+
+	for _, tt := range []struct {
+		name   string
+		server *Server
+		target string
+		header string
+		status int
+		code   string
+	}{
+		{"MaxRecord", open, "/offer?max_record=big", "", http.StatusBadRequest, signaling.CodeInvalidRecord},
+		{"Oversized", open, "/offer?oversized=drop", "", http.StatusBadRequest, signaling.CodeInvalidRecord},
+		{"Window", open, "/offer?window=-1", "", http.StatusBadRequest, signaling.CodeInvalidWindow},
+		{"GroupName", open, "/offer?group=a/b", "", http.StatusBadRequest, signaling.CodeInvalidGroup},
+		{"Group", open, "/offer?group=readers", "", http.StatusConflict, signaling.CodeGroupConflict},
+		{"Export", open, "/offer?export=missing", "", http.StatusNotFound, signaling.CodeUnknownExport},
+		{"Offset", open, "/offer?push=p1&offset=-1", "", http.StatusBadRequest, signaling.CodeInvalidOffset},
+		{"Push", open, "/offer?push=p1", "", http.StatusGone, signaling.CodeUnknownPush},
+		{"SinceSeq", open, "/offer?since_seq=-1", "", http.StatusBadRequest, signaling.CodeInvalidSince},
+		{"Since", open, "/offer?since=yesterday", "", http.StatusBadRequest, signaling.CodeInvalidSince},
+		{"SinceBoth", open, "/offer?since=1h&since_seq=3", "", http.StatusBadRequest, signaling.CodeInvalidSince},
+		{"SinceUnretained", open, "/offer?since=1h", "", http.StatusConflict, signaling.CodeReplayConflict},
+		{"SincePush", open, "/offer?push=p1&since_seq=3", "", http.StatusConflict, signaling.CodeReplayConflict},
+		{"NoiseSession", open, "/offer", "unknown", http.StatusBadRequest, signaling.CodeInvalidSession},
+		{"NoiseRequired", noiseOnly, "/offer", "", http.StatusForbidden, signaling.CodeNoiseRequired},
+		{"Forbidden", allowlisted, "/offer", "", http.StatusForbidden, signaling.CodeForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader("{}"))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				r.Header.Set(noise.SessionHeader, tt.header)
+				r.Header.Set("Content-Type", "application/octet-stream")
+			}
+			w := httptest.NewRecorder()
+			tt.server.OfferHandler(context.Background()).ServeHTTP(w, r)
+
+			if w.Code != tt.status || w.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Expected status %d with a JSON body, got %d %q: %s", tt.status, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+			}
+			resp := &http.Response{StatusCode: w.Code, Status: http.StatusText(w.Code)}
+			if err := signaling.NewStatusError("offer", resp, w.Body.Bytes()); err.Reason != tt.code {
+				t.Errorf("Expected the code %q, got %q in %s", tt.code, err.Reason, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,767 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/batch"
+	"github.com/developmeh/webrtc-poc/internal/broadcast"
+	"github.com/developmeh/webrtc-poc/internal/bundle"
+	"github.com/developmeh/webrtc-poc/internal/control"
+	"github.com/developmeh/webrtc-poc/internal/crash"
+	"github.com/developmeh/webrtc-poc/internal/deadline"
+	"github.com/developmeh/webrtc-poc/internal/fec"
+	"github.com/developmeh/webrtc-poc/internal/groups"
+	"github.com/developmeh/webrtc-poc/internal/latency"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/memlimit"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+	"github.com/developmeh/webrtc-poc/internal/pacing"
+	"github.com/developmeh/webrtc-poc/internal/peer"
+	"github.com/developmeh/webrtc-poc/internal/records"
+	"github.com/developmeh/webrtc-poc/internal/retention"
+	"github.com/developmeh/webrtc-poc/internal/retry"
+	"github.com/developmeh/webrtc-poc/internal/sctperr"
+	"github.com/developmeh/webrtc-poc/internal/source"
+	"github.com/pion/webrtc/v3"
+)
+
+// lowLatencyQueue is the most bytes --low-latency lets queue on a channel
+// before sending the next line, a few dozen tiny records
+const lowLatencyQueue = 4 * 1024
+
+// followPoll is how often the server checks a followed file or watched
+// export for lines appended at its end
+const followPoll = 250 * time.Millisecond
+
+// streamOptions controls how streamFile paces a transfer
+type streamOptions struct {
+	// pace returns the delay to wait between lines
+	pace func() time.Duration
+	// completeBy is the deadline of the transfer, if any
+	completeBy deadline.Spec
+	// pacer, if set, limits the rate of the transfer to that of the
+	// current pacing window
+	pacer *pacing.Pacer
+	// rate, if set, paces the transfer at --rate, returning how long to wait
+	// after n bytes
+	rate func(n int) time.Duration
+	// offset is the number of lines the receiver already has
+	offset int
+	// prefix, if set, is the SHA-256 of the lines the receiver already has,
+	// checked before resuming after them
+	prefix string
+	// include, if set, selects the 1-based lines to send
+	include func(line int) bool
+	// source controls how the file is read
+	source source.Options
+	// open, if set, opens what is streamed instead of the file
+	open func() (io.ReadCloser, error)
+	// length, if set, is the number of bytes read from the file
+	length int64
+	// raw sends the file in chunks of bytes instead of lines
+	raw bool
+	// fec, if set, sends the lines as shards with parity
+	fec *fec.Encoder
+	// timestamps sends every line with the time it was sent
+	timestamps bool
+	// queue, if set, is the most bytes queued on the channel when a line is
+	// sent
+	queue uint64
+	// retry retries the lines and shards that failed to send
+	retry retry.Policy
+	// batch, if enabled, sends the lines in batches within its limits
+	batch batch.Limits
+	// priority orders the transfer for load shedding
+	priority memlimit.Priority
+	// session, if set, pauses the transfer while the server sheds load
+	session *memlimit.Session
+	// window, if set, holds the transfer at the receiver's window
+	window *control.ReceiveWindow
+	// tee, if set, is called with every line read from the file, sent or
+	// not, so it can be hashed without reading the file again
+	tee func(line string)
+	// progress, if set, is called with the bytes of every line or chunk
+	// sent
+	progress func(n int)
+	// digest, if set, hashes every line sent followed by a newline, as the
+	// receiver writes it
+	digest hash.Hash
+	// streamed is the number of lines sent on the channel before the file,
+	// which the receiver's window counts too
+	streamed int
+	// limit, if set, is the rate limit of the export streamed, shared with
+	// its other transfers
+	limit *pacing.Pacer
+	// follow keeps reading the lines appended to the file until the channel
+	// closes
+	follow bool
+	// records bounds the lines sent. Set for the file stream, whose client
+	// is told about the lines that were too long.
+	records records.Terms
+	// closed, if set, is closed with the channel, cutting a wait between
+	// lines short
+	closed <-chan struct{}
+}
+
+// wait sleeps for d, or until closed is closed
+func wait(d time.Duration, closed <-chan struct{}) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-closed:
+	}
+}
+
+// streamFile streams a file line by line over a data channel, skipping the
+// first opts.offset lines, waiting opts.pace() between lines and speeding up
+// if needed to finish by opts.completeBy, but never beyond the rate of
+// opts.pacer or opts.rate. Clients that accept batches get the lines in
+// batches within opts.batch. It returns the number of lines sent.
+func streamFile(dataChannel *webrtc.DataChannel, filename string, opts streamOptions) (sent int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = crash.Recovered("streamFile", r)
+		}
+	}()
+
+	var file io.ReadCloser
+	if opts.open != nil {
+		file, err = opts.open()
+	} else {
+		file, err = source.Open(filename, opts.source)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// Read at most opts.length bytes, the only way to bound a device, and
+	// wait for more at the end of a followed file
+	open := func() bool { return dataChannel.ReadyState() == webrtc.DataChannelStateOpen }
+	reader := func() io.Reader {
+		var r io.Reader = file
+		if opts.follow {
+			r = source.Follow(r, followPoll, open)
+		}
+		if opts.length > 0 {
+			r = io.LimitReader(r, opts.length)
+		}
+		return r
+	}
+	if opts.raw {
+		return streamChunks(dataChannel, reader(), opts)
+	}
+
+	// Plan the pacing needed to finish before the deadline
+	var planner *deadline.Planner
+	total := 0
+	if !opts.completeBy.IsZero() {
+		if total, err = countLines(reader(), opts.records); err != nil {
+			return 0, fmt.Errorf("error reading file: %w", err)
+		}
+		if err := rewind(file); err != nil {
+			return 0, fmt.Errorf("error rewinding file: %w", err)
+		}
+		planner = deadline.NewPlanner(opts.completeBy.From(time.Now()), max(total-opts.offset, 0))
+		if err := planner.Check(opts.pace()); err != nil {
+			return 0, err
+		}
+	}
+
+	// Track the data channel's send queue for the lifetime of the stream
+	name := peer.ChannelName(dataChannel)
+	defer metrics.DataChannelBufferedAmount.Delete(name)
+
+	scanner := records.NewScanner(reader(), opts.records)
+	lineCount := 0
+	notify := opts.records != records.Terms{}
+
+	// Coalesce the lines into batches, waiting the delay of a batch's lines
+	// once it was sent. The lines of a followed file are sent as they are
+	// appended, and shards carry lines of their own.
+	var batcher *batch.Batcher
+	if opts.batch.Enabled() && opts.fec == nil && !opts.follow {
+		batcher = batch.New(opts.batch)
+	}
+	var owed time.Duration
+	flush := func() error {
+		if batcher == nil || batcher.Len() == 0 {
+			return nil
+		}
+		lines := batcher.Take()
+		if err := sendBatch(dataChannel, lines, opts.retry); err != nil {
+			sent -= len(lines)
+			return fmt.Errorf("failed to send a batch of %d lines: %w", len(lines), err)
+		}
+		logger.Debug("Sent a batch of %d lines", len(lines))
+		wait(owed, opts.closed)
+		owed = 0
+		return nil
+	}
+
+	if opts.offset > 0 {
+		logger.Info("Resuming transfer after line %d", opts.offset)
+	}
+
+	// Only resume if the file still starts with the lines the receiver has,
+	// and otherwise send it again from the start rather than splicing the
+	// changed file onto them
+	if opts.offset > 0 && opts.prefix != "" {
+		read, match, err := checkPrefix(scanner, opts.offset, opts.prefix)
+		if err != nil {
+			return 0, fmt.Errorf("error reading file: %w", err)
+		}
+		if match {
+			lineCount = read
+		} else {
+			logger.Info("Warning: %s no longer starts with the %d lines the receiver has, sending it again from the start", filename, opts.offset)
+			if err := control.Send(dataChannel, control.Restart, opts.offset); err != nil {
+				return 0, fmt.Errorf("failed to restart transfer: %w", err)
+			}
+			if err := rewind(file); err != nil {
+				return 0, fmt.Errorf("error rewinding file: %w", err)
+			}
+			scanner = records.NewScanner(reader(), opts.records)
+			opts.offset = 0
+			if planner != nil {
+				planner = deadline.NewPlanner(opts.completeBy.From(time.Now()), total)
+			}
+		}
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineCount++
+		if opts.tee != nil {
+			opts.tee(line)
+		}
+
+		// Skip lines the receiver already has
+		if lineCount <= opts.offset || (opts.include != nil && !opts.include(lineCount)) {
+			continue
+		}
+
+		// Hold the line while the server sheds load, or until the receiver
+		// accepts it
+		if opts.session != nil {
+			opts.session.Wait(open)
+		}
+		// The receiver cannot accept lines it never got, so the batch is sent
+		// before waiting for its window
+		if opts.window != nil && opts.window.Full(opts.streamed+sent) {
+			if err := flush(); err != nil {
+				return sent, err
+			}
+		}
+		if opts.window != nil && opts.window.Wait(opts.streamed+sent, open) {
+			logger.Debug("Held line %d until the receiver's window opened", lineCount)
+		}
+		if opts.queue > 0 && control.WaitBelow(dataChannel, opts.queue, open) {
+			logger.Debug("Held line %d until the send queue drained", lineCount)
+		}
+
+		// Tell the client a line longer than the largest record was cut
+		if notify && scanner.Oversized() {
+			kind := control.Truncated
+			if opts.records.Policy == records.Split {
+				kind = control.Split
+			}
+			logger.Info("Warning: line %d of %s is longer than %d bytes, applying the %s policy", lineCount, filename, opts.records.MaxSize, opts.records.Policy)
+			if err := flush(); err != nil {
+				return sent, err
+			}
+			if err := control.Send(dataChannel, kind, opts.streamed+sent+1); err != nil {
+				return sent, fmt.Errorf("failed to send line %d: %w", lineCount, err)
+			}
+		}
+
+		// Send the line over the data channel, or add it to the batch
+		msg := line
+		if opts.timestamps {
+			msg = latency.Wrap(line, time.Now())
+		}
+		if batcher != nil && !batcher.Fits(msg) {
+			if err := flush(); err != nil {
+				return sent, err
+			}
+		}
+		full := false
+		if batcher != nil && batcher.Fits(msg) {
+			full = batcher.Add(msg)
+		} else if err := sendLine(dataChannel, msg, opts.fec, opts.retry); err != nil {
+			return sent, fmt.Errorf("failed to send line %d: %w", lineCount, err)
+		}
+		sent++
+		if opts.digest != nil {
+			io.WriteString(opts.digest, line)
+			opts.digest.Write([]byte{'\n'})
+		}
+		if opts.progress != nil {
+			opts.progress(len(line))
+		}
+		metrics.DataChannelBufferedAmount.Set(name, int64(dataChannel.BufferedAmount()))
+
+		logger.Debug("Sent line %d: %s", lineCount, line)
+
+		// Delay between lines
+		delay := opts.pace()
+		if planner != nil {
+			if delay, err = planner.Delay(delay, total-lineCount); err != nil {
+				return sent, err
+			}
+		}
+		if opts.pacer != nil {
+			delay = max(delay, opts.pacer.Delay(len(msg)))
+		}
+		if opts.limit != nil {
+			delay = max(delay, opts.limit.Delay(len(msg)))
+		}
+		if opts.rate != nil {
+			delay = max(delay, opts.rate(len(msg)))
+		}
+		owed += delay
+		if batcher == nil || batcher.Len() == 0 {
+			wait(owed, opts.closed)
+			owed = 0
+		} else if full {
+			if err := flush(); err != nil {
+				return sent, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return sent, err
+	}
+
+	if err := scanner.Err(); err != nil {
+		if notify && errors.Is(err, records.ErrTooLong) {
+			control.Send(dataChannel, control.TooLong, opts.streamed+sent+1)
+		}
+		return sent, fmt.Errorf("error reading file: %w", err)
+	}
+	if opts.fec != nil {
+		if err := sendShards(dataChannel, opts.fec.Flush(), opts.retry); err != nil {
+			return sent, fmt.Errorf("failed to send parity: %w", err)
+		}
+	}
+
+	logger.Info("Finished streaming file, sent %d lines", sent)
+	return sent, nil
+}
+
+// rewind seeks file back to its start, which what Open opened may not
+// support
+func rewind(file io.Reader) error {
+	seeker, ok := file.(io.Seeker)
+	if !ok {
+		return errors.New("the stream cannot be read again from its start")
+	}
+	_, err := seeker.Seek(0, io.SeekStart)
+	return err
+}
+
+// rawChunkSize is the size of the chunks of a raw stream, but the last
+const rawChunkSize = 32 << 10
+
+// rawQueue is the most bytes queued on the channel before the next chunk is
+// read, so an image is not read into memory faster than it is sent
+const rawQueue = 1 << 20
+
+// RawSource reports whether the --file stream sends filename in chunks of
+// raw bytes: with raw, or if it is a block device, which holds an image
+// rather than lines
+func RawSource(filename string, raw bool) bool {
+	if raw {
+		return true
+	}
+	info, err := os.Stat(filename)
+	return err == nil && info.Mode()&os.ModeDevice != 0 && info.Mode()&os.ModeCharDevice == 0
+}
+
+// streamChunks streams r over a data channel in Chunk frames of
+// rawChunkSize bytes, which the receiver writes as they are. Chunks count
+// as lines, so the receiver's window, opts.offset, Fin and its Ack count
+// chunks, and opts.digest hashes the bytes alone. Options that act on lines
+// do not apply.
+func streamChunks(dataChannel *webrtc.DataChannel, r io.Reader, opts streamOptions) (sent int, err error) {
+	name := peer.ChannelName(dataChannel)
+	defer metrics.DataChannelBufferedAmount.Delete(name)
+	open := func() bool { return dataChannel.ReadyState() == webrtc.DataChannelStateOpen }
+
+	// Every chunk but the last is full, so the receiver's chunks end at a
+	// known byte
+	if opts.offset > 0 {
+		logger.Info("Resuming transfer after chunk %d", opts.offset)
+		if _, err := io.CopyN(io.Discard, r, int64(opts.offset)*rawChunkSize); err != nil && err != io.EOF {
+			return 0, fmt.Errorf("error reading file: %w", err)
+		}
+	}
+
+	queue := uint64(rawQueue)
+	if opts.queue > 0 {
+		queue = min(opts.queue, queue)
+	}
+	chunk := make([]byte, rawChunkSize)
+	var total int64
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			if opts.session != nil {
+				opts.session.Wait(open)
+			}
+			if opts.window != nil && opts.window.Wait(opts.streamed+sent, open) {
+				logger.Debug("Held chunk %d until the receiver's window opened", opts.offset+sent+1)
+			}
+			control.WaitBelow(dataChannel, queue, open)
+			if err := opts.retry.Do(func() error { return control.SendFrame(dataChannel, control.Chunk, chunk[:n]) }); err != nil {
+				return sent, fmt.Errorf("failed to send chunk %d: %w", opts.offset+sent+1, err)
+			}
+			sent++
+			total += int64(n)
+			if opts.digest != nil {
+				opts.digest.Write(chunk[:n])
+			}
+			if opts.progress != nil {
+				opts.progress(n)
+			}
+			metrics.DataChannelBufferedAmount.Set(name, int64(dataChannel.BufferedAmount()))
+
+			delay := opts.pace()
+			if opts.pacer != nil {
+				delay = max(delay, opts.pacer.Delay(n))
+			}
+			if opts.limit != nil {
+				delay = max(delay, opts.limit.Delay(n))
+			}
+			if opts.rate != nil {
+				delay = max(delay, opts.rate(n))
+			}
+			wait(delay, opts.closed)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return sent, fmt.Errorf("error reading file: %w", err)
+		}
+	}
+
+	logger.Info("Finished streaming file, sent %d chunks, %d bytes", sent, total)
+	return sent, nil
+}
+
+// readShared reads filename for all the clients of a broadcast or consumer
+// group, cut into records under opts.records, skipping its first offset
+// lines and waiting opts.pace() between the others but never beyond the rate
+// of opts.pacer or opts.rate. A followed file is read on at its end while more reports
+// that there are clients. It returns the number of lines read.
+func readShared(filename string, opts streamOptions, offset int, more func() bool, emit func(line string, oversized bool) error) (int, error) {
+	file, err := source.Open(filename, opts.source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if opts.follow {
+		r = source.Follow(r, followPoll, more)
+	}
+	if opts.length > 0 {
+		r = io.LimitReader(r, opts.length)
+	}
+	scanner := records.NewScanner(r, opts.records)
+	lineCount := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineCount++
+		if lineCount <= offset {
+			continue
+		}
+		if scanner.Oversized() {
+			logger.Info("Warning: line %d of %s is longer than %d bytes, applying the %s policy", lineCount, filename, opts.records.MaxSize, opts.records.Policy)
+		}
+		if err := emit(line, scanner.Oversized()); err != nil {
+			return lineCount, err
+		}
+		logger.Debug("Read line %d for every client: %s", lineCount, line)
+
+		delay := opts.pace()
+		if opts.pacer != nil {
+			delay = max(delay, opts.pacer.Delay(len(line)))
+		}
+		if opts.rate != nil {
+			delay = max(delay, opts.rate(len(line)))
+		}
+		time.Sleep(delay)
+	}
+	if err := scanner.Err(); err != nil {
+		return lineCount, fmt.Errorf("error reading file: %w", err)
+	}
+	return lineCount, nil
+}
+
+// broadcastSource reads filename once for every round of a broadcast. A
+// followed file is read on at its end while listening reports that the
+// broadcast has clients.
+func broadcastSource(filename string, opts streamOptions, listening func() bool) broadcast.Source {
+	return func(emit func(broadcast.Line) error) error {
+		read, err := readShared(filename, opts, 0, listening, func(line string, oversized bool) error {
+			return emit(broadcast.Line{Text: line, Oversized: oversized})
+		})
+		if err == nil {
+			logger.Info("Finished broadcasting file, read %d lines", read)
+		}
+		return err
+	}
+}
+
+// groupSource reads filename for every round of a consumer group, after the
+// lines the group committed
+func groupSource(filename string, opts streamOptions) groups.Source {
+	return func(offset int, more func() bool, emit func(string) error) error {
+		read, err := readShared(filename, opts, offset, more, func(line string, _ bool) error {
+			return emit(line)
+		})
+		if err == nil {
+			logger.Info("Finished streaming file to a consumer group, read %d lines after line %d", max(read-offset, 0), offset)
+		}
+		return err
+	}
+}
+
+// retain opens the retention log of the followed file filename in dir and
+// records the arrival of the lines appended to it until stop is closed
+func retain(filename, dir string, period time.Duration, opts streamOptions, stop <-chan struct{}) (*retention.Log, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	file, err := source.Open(filename, opts.source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	lines, err := countLines(file, opts.records)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count lines: %w", err)
+	}
+	retained, err := retention.Open(retention.Path(dir, abs), abs, period, lines)
+	if err != nil {
+		return nil, err
+	}
+
+	more := func() bool {
+		select {
+		case <-stop:
+			return false
+		default:
+			return true
+		}
+	}
+	go func() {
+		n := lines
+		_, err := readShared(filename, opts, lines, more, func(string, bool) error {
+			n++
+			return retained.Record(n, time.Now())
+		})
+		if err != nil {
+			logger.Error("Stopped recording the arrival of lines in %s: %v", filename, err)
+		}
+	}()
+	return retained, nil
+}
+
+// joinGroup sends the lines a consumer group hands the client over a data
+// channel until the end of the file or until closed is closed, setting
+// member to the client's membership to commit through. It returns the
+// number of lines sent.
+func joinGroup(dataChannel *webrtc.DataChannel, consumers *groups.Groups, name string, opts streamOptions, closed <-chan struct{}, member *atomic.Pointer[groups.Member]) (sent int, err error) {
+	channel := peer.ChannelName(dataChannel)
+	defer metrics.DataChannelBufferedAmount.Delete(channel)
+
+	logger.Info("Joining consumer group %s after line %d", name, consumers.Committed(name))
+	m := consumers.Join(name, func(line string) error {
+		msg := line
+		if opts.timestamps {
+			msg = latency.Wrap(line, time.Now())
+		}
+		if err := sendLine(dataChannel, msg, opts.fec, opts.retry); err != nil {
+			return fmt.Errorf("failed to send line %d: %w", sent+1, err)
+		}
+		sent++
+		if opts.digest != nil {
+			io.WriteString(opts.digest, line)
+			opts.digest.Write([]byte{'\n'})
+		}
+		metrics.DataChannelBufferedAmount.Set(channel, int64(dataChannel.BufferedAmount()))
+		return nil
+	})
+	member.Store(m)
+	if err := m.Wait(closed); err != nil {
+		return sent, err
+	}
+	if opts.fec != nil {
+		if err := sendShards(dataChannel, opts.fec.Flush(), opts.retry); err != nil {
+			return sent, fmt.Errorf("failed to send parity: %w", err)
+		}
+	}
+
+	logger.Info("Finished the stream of consumer group %s, sent %d lines", name, sent)
+	return sent, nil
+}
+
+// joinBroadcast sends the lines hub broadcasts over a data channel, from the
+// one read as it joins until the end of the round or until closed is
+// closed. It returns the number of lines sent.
+func joinBroadcast(dataChannel *webrtc.DataChannel, hub *broadcast.Hub, opts streamOptions, closed <-chan struct{}) (sent int, err error) {
+	name := peer.ChannelName(dataChannel)
+	defer metrics.DataChannelBufferedAmount.Delete(name)
+	notify := opts.records != records.Terms{}
+
+	// The hub sends every line to all of its clients before reading the
+	// next one, so this must not wait, not even to retry a failed send
+	opts.retry = retry.Policy{}
+	err = hub.Join(func(l broadcast.Line) error {
+		if notify && l.Oversized {
+			kind := control.Truncated
+			if opts.records.Policy == records.Split {
+				kind = control.Split
+			}
+			if err := control.Send(dataChannel, kind, opts.streamed+sent+1); err != nil {
+				return fmt.Errorf("failed to send line %d: %w", sent+1, err)
+			}
+		}
+		msg := l.Text
+		if opts.timestamps {
+			msg = latency.Wrap(l.Text, time.Now())
+		}
+		if err := sendLine(dataChannel, msg, opts.fec, opts.retry); err != nil {
+			return fmt.Errorf("failed to send line %d: %w", sent+1, err)
+		}
+		sent++
+		if opts.digest != nil {
+			io.WriteString(opts.digest, l.Text)
+			opts.digest.Write([]byte{'\n'})
+		}
+		metrics.DataChannelBufferedAmount.Set(name, int64(dataChannel.BufferedAmount()))
+		return nil
+	}, closed)
+	if err != nil {
+		if notify && errors.Is(err, records.ErrTooLong) {
+			control.Send(dataChannel, control.TooLong, opts.streamed+sent+1)
+		}
+		return sent, err
+	}
+	if opts.fec != nil {
+		if err := sendShards(dataChannel, opts.fec.Flush(), opts.retry); err != nil {
+			return sent, fmt.Errorf("failed to send parity: %w", err)
+		}
+	}
+
+	logger.Info("Finished the broadcast, sent %d lines", sent)
+	return sent, nil
+}
+
+// streamBundle streams the files a directory or glob pattern names, after a
+// manifest of them, with every file's lines between Begin and End. It
+// returns the number of lines sent in total.
+func streamBundle(dataChannel *webrtc.DataChannel, spec string, opts streamOptions, stream func(*webrtc.DataChannel, string, streamOptions) (int, error)) (int, error) {
+	files, err := bundle.List(spec)
+	if err != nil {
+		return 0, err
+	}
+	if err := control.SendFrame(dataChannel, control.Manifest, bundle.Manifest{Files: files}.Encode()); err != nil {
+		return 0, fmt.Errorf("failed to send manifest: %w", err)
+	}
+
+	streamed, total := opts.streamed, 0
+	for i, f := range files {
+		if err := control.Send(dataChannel, control.Begin, i); err != nil {
+			return total, fmt.Errorf("failed to begin %s: %w", f.Name, err)
+		}
+		opts.streamed = streamed + total
+		sent, err := stream(dataChannel, f.Path, opts)
+		total += sent
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if err := control.Send(dataChannel, control.End, sent); err != nil {
+			return total, fmt.Errorf("failed to end %s: %w", f.Name, err)
+		}
+	}
+	logger.Info("Finished streaming %d files, sent %d lines", len(files), total)
+	return total, nil
+}
+
+// checkPrefix reads the first n lines from scanner and reports how many it
+// read and whether their SHA-256 is prefix
+func checkPrefix(scanner *records.Scanner, n int, prefix string) (int, bool, error) {
+	h := sha256.New()
+	read := 0
+	for read < n && scanner.Scan() {
+		h.Write(scanner.Bytes())
+		h.Write([]byte{'\n'})
+		read++
+	}
+	if err := scanner.Err(); err != nil {
+		return read, false, err
+	}
+	return read, read == n && hex.EncodeToString(h.Sum(nil)) == prefix, nil
+}
+
+// sendLine sends a line as text, or as shards if enc is set, retrying every
+// message that failed to send under policy
+func sendLine(dataChannel *webrtc.DataChannel, line string, enc *fec.Encoder, policy retry.Policy) error {
+	if enc == nil {
+		return policy.Do(func() error { return sctperr.Wrap(dataChannel.SendText(line)) })
+	}
+	shards, err := enc.Add(line)
+	if err != nil {
+		return err
+	}
+	return sendShards(dataChannel, shards, policy)
+}
+
+// sendBatch sends lines in a Batch frame, or a single one as a line,
+// retrying under policy
+func sendBatch(dataChannel *webrtc.DataChannel, lines []string, policy retry.Policy) error {
+	if len(lines) == 1 {
+		return sendLine(dataChannel, lines[0], nil, policy)
+	}
+	payload := batch.Encode(lines)
+	return policy.Do(func() error { return control.SendFrame(dataChannel, control.Batch, payload) })
+}
+
+// sendShards sends FEC shards as binary messages, retrying each one under
+// policy
+func sendShards(dataChannel *webrtc.DataChannel, shards [][]byte, policy retry.Policy) error {
+	for _, shard := range shards {
+		if err := policy.Do(func() error { return sctperr.Wrap(dataChannel.Send(shard)) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countLines counts the records in r under terms
+func countLines(r io.Reader, terms records.Terms) (int, error) {
+	scanner := records.NewScanner(r, terms)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+	}
+	return lines, scanner.Err()
+}
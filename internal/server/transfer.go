@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/exports"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/records"
+	"github.com/developmeh/webrtc-poc/internal/remotelog"
+	"github.com/pion/webrtc/v3"
+)
+
+// registerPollTimeout is how long a daemon mode poll waits for a push
+const registerPollTimeout = 30 * time.Second
+
+// pushIdleTimeout is how long after its last poll a daemon still counts as
+// connected for POST /push, covering the moment between two polls
+const pushIdleTimeout = registerPollTimeout + 10*time.Second
+
+// noiseHandshakeTimeout is how long the server waits for the offer that
+// finishes a Noise handshake
+const noiseHandshakeTimeout = 30 * time.Second
+
+// defaultFinishTimeout is how long the server waits for the client to
+// acknowledge the end of the file stream before closing it anyway, unless
+// configured otherwise
+const defaultFinishTimeout = 5 * time.Second
+
+// helloTimeout is how long the server waits for the channel a client's offer
+// carried, which tells whether it reads control messages, before streaming
+// to it as to a client of the first protocol
+const helloTimeout = 2 * time.Second
+
+// commitTimeout is how long the server waits for the client of a consumer
+// group to commit the last lines of the stream
+const commitTimeout = 2 * time.Second
+
+// supersedeTimeout is how long the server tries to tell a session that was
+// taken over why it ends before closing it
+const supersedeTimeout = time.Second
+
+// sendRetryMax caps the wait before a retry of a failed send
+const sendRetryMax = time.Second
+
+// tooLongTimeout is how long the server tries to tell a client its stream
+// ends at a line longer than the largest record before closing the channel
+const tooLongTimeout = time.Second
+
+// offerAnswerTimeout is how long the server keeps an offer open for the
+// client's answer
+const offerAnswerTimeout = 30 * time.Second
+
+// transfer is what a peer connection streams once its channel opens
+type transfer struct {
+	file   string
+	pushID string
+	offset int
+	// prefix is the SHA-256 of the offset lines the peer already has, if it
+	// sent one
+	prefix string
+	// window is the receive window the client advertised with its offer,
+	// in lines
+	window int
+	// checksums are the checksum algorithms the client offered, in order of
+	// preference
+	checksums []string
+	// verify is set if the client asked for a digest of the stream with its
+	// offer
+	verify bool
+	// batch is set if the client accepts batches of lines with its offer
+	batch bool
+	// identity is the client's verified identity, if it proved one
+	identity string
+	// addr is the IP address the client signaled from, if it did over HTTP
+	addr string
+	// export is the export the client named, if any
+	export *exports.Export
+	// log logs the lines about the session, keeping them for the client
+	// with --remote-logs
+	log *remotelog.Session
+	// session names the session in the trace, as the client does if it
+	// passed its own
+	session string
+	// records are the largest line of the file stream and what happens to
+	// longer ones, as negotiated with the client
+	records records.Terms
+	// group is the consumer group the client joined, if any
+	group string
+	// ctx, if set, closes the connection once it ends
+	ctx context.Context
+}
+
+// pendingOffers holds the peer connections of offers made by the server until
+// the client answers them, closing those that are never answered
+type pendingOffers struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	conns map[string]*webrtc.PeerConnection
+}
+
+func newPendingOffers(ttl time.Duration) *pendingOffers {
+	return &pendingOffers{ttl: ttl, conns: make(map[string]*webrtc.PeerConnection)}
+}
+
+// add stores a peer connection and returns the session ID to answer it with
+func (p *pendingOffers) add(pc *webrtc.PeerConnection) string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+
+	p.mu.Lock()
+	p.conns[id] = pc
+	p.mu.Unlock()
+
+	time.AfterFunc(p.ttl, func() {
+		if pc, ok := p.take(id); ok {
+			logger.Info("Offer %s was not answered in time", id)
+			pc.Close()
+		}
+	})
+	return id
+}
+
+// take removes and returns the peer connection of a session
+func (p *pendingOffers) take(id string) (*webrtc.PeerConnection, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc, ok := p.conns[id]
+	delete(p.conns, id)
+	return pc, ok
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/tunnel"
+	"github.com/pion/webrtc/v3"
+)
+
+// tunnelDialTimeout limits how long the server tries to reach a tunnel target
+const tunnelDialTimeout = 10 * time.Second
+
+// serveTunnel connects the streams of a tunnel channel to the targets they
+// ask for, if allowed
+func serveTunnel(dc *webrtc.DataChannel, allowed []string) {
+	if len(allowed) == 0 {
+		logger.Error("Client opened a tunnel, but --allow-tunnel is not set")
+		dc.OnOpen(func() { dc.Close() })
+		return
+	}
+
+	mux := tunnel.NewMux(dc.Send, func(target string) (io.ReadWriteCloser, error) {
+		if !tunnel.Allowed(target, allowed) {
+			logger.Error("Refused tunnel to %s", target)
+			return nil, fmt.Errorf("tunnels to %s are not allowed", target)
+		}
+		logger.Info("Client opened a tunnel to %s", target)
+		return net.DialTimeout("tcp", target, tunnelDialTimeout)
+	})
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		mux.Handle(msg.Data)
+	})
+	dc.OnClose(mux.Close)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/crash"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+	"github.com/developmeh/webrtc-poc/internal/pool"
+	"github.com/developmeh/webrtc-poc/internal/share"
+	"github.com/developmeh/webrtc-poc/internal/upload"
+	"github.com/pion/webrtc/v3"
+)
+
+// serveUpload receives a file the client with identity uploads into
+// quarantine and answers with a Result once the pipeline accepted or
+// rejected it, on one of the workers
+func serveUpload(request *webrtc.DataChannel, pipeline *upload.Pipeline, identity string, workers *pool.Pool, isolate func()) {
+	msgs := newMessageQueue()
+	closed := make(chan struct{})
+	request.OnMessage(func(msg webrtc.DataChannelMessage) {
+		msgs.push(msg)
+	})
+	request.OnClose(func() {
+		close(closed)
+	})
+
+	request.OnOpen(func() {
+		workers.Go("upload of "+request.Label(), func() {
+			defer request.Close()
+			defer crash.Recover("server upload", isolate)
+
+			name := request.Label()
+			result, err := receiveUpload(identity, name, pipeline, msgs, closed)
+			if err != nil {
+				logger.Error("Upload of %s failed: %v", name, err)
+				if errors.Is(err, upload.ErrRejected) {
+					metrics.UploadsRejected.Inc()
+				}
+				result.Error = uploadError(err)
+			}
+			if err := request.Send(result.Encode()); err != nil {
+				logger.Error("Failed to send result of upload %s: %v", name, err)
+				return
+			}
+
+			// Leave closing to the client, which may still be sending the
+			// lines of a rejected upload
+			select {
+			case <-closed:
+			case <-time.After(upload.Linger):
+			}
+		})
+	})
+}
+
+// receiveUpload writes the lines of an upload into quarantine until the
+// client's Result arrives, then validates the file and moves it into place.
+// The Result names the file the upload was stored as.
+func receiveUpload(identity, name string, pipeline *upload.Pipeline, msgs *messageQueue, closed <-chan struct{}) (share.Result, error) {
+	u, err := pipeline.Begin(identity, name)
+	if err != nil {
+		return share.Result{}, err
+	}
+
+	received := 0
+	for {
+		msg, err := msgs.next(closed, nil)
+		if err != nil {
+			u.Abort()
+			return share.Result{}, fmt.Errorf("client did not finish the upload: %w", err)
+		}
+		if !msg.IsString {
+			if _, err := share.CheckResult(msg.Data, received); err != nil {
+				u.Abort()
+				return share.Result{}, err
+			}
+			break
+		}
+		if err := u.WriteLine(string(msg.Data)); err != nil {
+			u.Abort()
+			return share.Result{}, err
+		}
+		received++
+	}
+
+	path, err := u.Commit()
+	if err != nil {
+		return share.Result{}, err
+	}
+	logger.Info("Accepted upload %s to %s, %d lines", name, path, received)
+	return share.Result{Lines: received, Files: []string{filepath.Base(path)}}, nil
+}
+
+// uploadError describes a failed upload to the client without revealing the
+// server's paths
+func uploadError(err error) string {
+	switch {
+	case errors.Is(err, upload.ErrDisabled), errors.Is(err, upload.ErrRejected), errors.Is(err, upload.ErrExists),
+		errors.Is(err, upload.ErrInvalidName), errors.Is(err, upload.ErrNoTenant):
+		return err.Error()
+	}
+	return "upload failed"
+}
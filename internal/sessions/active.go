@@ -0,0 +1,241 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/maintenance"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+)
+
+// fullRetryAfter is how long clients turned away at the connection limit are
+// asked to wait
+const fullRetryAfter = 10 * time.Second
+
+var (
+	// activeGauge reports the peer connections the server runs
+	activeGauge = metrics.NewGauge("webrtc_poc_active_sessions",
+		"Peer connections the server runs, as compared against --max-connections")
+	// fullCounter counts the offers turned away at the limit
+	fullCounter = metrics.NewCounter("webrtc_poc_offers_over_limit_total",
+		"Offers turned away because the server runs --max-connections peer connections")
+)
+
+// ErrFull is returned when Admit turns a session away at the limit
+var ErrFull = errors.New("server has reached its connection limit")
+
+// Info describes an active session
+type Info struct {
+	ID string `json:"id"`
+	// Identity is the identity the client proved, if any
+	Identity string `json:"identity,omitempty"`
+	// Addr is the IP address the client signaled from, if it did over HTTP
+	Addr string `json:"addr,omitempty"`
+	// File is what the session streams
+	File string `json:"file,omitempty"`
+	// State is the state of the session's peer connection
+	State   string    `json:"state"`
+	Started time.Time `json:"started"`
+	// Stalled is set while the client acknowledges no bytes, once it did
+	// not for the stall timeout
+	Stalled bool `json:"stalled,omitempty"`
+	Stats
+}
+
+// Stats are the live counters of a session
+type Stats struct {
+	// MessagesSent and BytesSent count what the session's data channels
+	// sent
+	MessagesSent uint64 `json:"messages_sent"`
+	BytesSent    uint64 `json:"bytes_sent"`
+	// Buffered is the depth of the file stream's send queue, in bytes
+	Buffered uint64 `json:"buffered"`
+}
+
+// Active holds the sessions whose peer connections the server runs, and
+// bounds how many there are
+type Active struct {
+	mu       sync.Mutex
+	max      int
+	sessions map[string]*Session
+	// budget bounds the sessions of each client, which are turned away
+	// until their cooldown ends once they went over it
+	budget    Budget
+	cooldowns map[string]time.Time
+	now       func() time.Time
+}
+
+// NewActive creates an empty registry admitting at most max sessions, or
+// any number of them if max is 0
+func NewActive(max int) *Active {
+	return &Active{max: max, sessions: make(map[string]*Session), cooldowns: make(map[string]time.Time), now: time.Now}
+}
+
+// Session is an admitted session
+type Session struct {
+	a     *Active
+	info  Info
+	stats func() Stats
+	end   func()
+	once  sync.Once
+	// stalled is called once the session stalled, and acked and progress
+	// are the bytes its client acknowledged when they last grew
+	stalled  func()
+	acked    uint64
+	progress time.Time
+}
+
+// Admit registers a new session described by info, or fails with ErrFull
+// once the limit is reached and with a *BudgetError once its client's budget
+// is. Its ID and start time are filled in.
+func (a *Active) Admit(info Info) (*Session, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.max > 0 && len(a.sessions) >= a.max {
+		fullCounter.Inc()
+		return nil, ErrFull
+	}
+	if err := a.overBudget(info); err != nil {
+		budgetCounter.Inc()
+		return nil, err
+	}
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	info.ID = hex.EncodeToString(buf)
+	info.Started = time.Now().UTC()
+	if info.State == "" {
+		info.State = "new"
+	}
+	s := &Session{a: a, info: info}
+	a.sessions[info.ID] = s
+	activeGauge.Set(int64(len(a.sessions)))
+	return s, nil
+}
+
+// ID returns the session's ID
+func (s *Session) ID() string {
+	return s.info.ID
+}
+
+// SetState records the state of the session's peer connection
+func (s *Session) SetState(state string) {
+	s.a.mu.Lock()
+	defer s.a.mu.Unlock()
+	s.info.State = state
+}
+
+// Attach sets how the session's counters are read and how it is ended,
+// once its connection exists
+func (s *Session) Attach(stats func() Stats, end func()) {
+	s.a.mu.Lock()
+	defer s.a.mu.Unlock()
+	s.stats, s.end = stats, end
+}
+
+// Release forgets the session once its connection is closed
+func (s *Session) Release() {
+	s.once.Do(func() {
+		s.a.mu.Lock()
+		defer s.a.mu.Unlock()
+		delete(s.a.sessions, s.info.ID)
+		activeGauge.Set(int64(len(s.a.sessions)))
+	})
+}
+
+// List returns the active sessions with their current counters, oldest
+// first
+func (a *Active) List() []Info {
+	a.mu.Lock()
+	list := make([]Info, 0, len(a.sessions))
+	stats := make([]func() Stats, 0, len(a.sessions))
+	for _, s := range a.sessions {
+		list = append(list, s.info)
+		stats = append(stats, s.stats)
+	}
+	a.mu.Unlock()
+
+	// Read the counters outside the lock, they come from the connections
+	for i, read := range stats {
+		if read != nil {
+			list[i].Stats = read()
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Started.Equal(list[j].Started) {
+			return list[i].Started.Before(list[j].Started)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// End ends the session with the ID, reporting whether it is active and can
+// be ended. The session counts until its connection is closed.
+func (a *Active) End(id string) bool {
+	a.mu.Lock()
+	s, ok := a.sessions[id]
+	var end func()
+	if ok {
+		end = s.end
+	}
+	a.mu.Unlock()
+	if end == nil {
+		return false
+	}
+	end()
+	return true
+}
+
+// Full reports whether a new session would be turned away
+func (a *Active) Full() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.max > 0 && len(a.sessions) >= a.max
+}
+
+// Reject answers a request with 503 Service Unavailable and a structured
+// reason if the server runs as many sessions as it may, and reports whether
+// it did
+func (a *Active) Reject(w http.ResponseWriter) bool {
+	if !a.Full() {
+		return false
+	}
+	fullCounter.Inc()
+	seconds := int(fullRetryAfter / time.Second)
+	body, _ := json.Marshal(maintenance.Unavailable{
+		Error:      ErrFull.Error(),
+		Reason:     fmt.Sprintf("%d of %d connections active", a.max, a.max),
+		RetryAfter: seconds,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(body)
+	return true
+}
+
+// ServeHTTP serves the session manager API: GET lists the active sessions
+// as JSON and DELETE with an id parameter ends one
+func (a *Active) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.List())
+	case http.MethodDelete:
+		if !a.End(r.URL.Query().Get("id")) {
+			http.Error(w, "Unknown session", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
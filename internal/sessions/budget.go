@@ -0,0 +1,122 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/maintenance"
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+)
+
+// budgetCounter counts the sessions turned away over a client's budget
+var budgetCounter = metrics.NewCounter("webrtc_poc_offers_over_budget_total",
+	"Offers turned away because their client address or identity runs as many sessions as it may")
+
+// ErrOverBudget matches the errors of sessions turned away over their
+// client's budget
+var ErrOverBudget = errors.New("client has reached its connection budget")
+
+// Budget bounds the sessions a single client runs at once, so one
+// misconfigured client cannot take a shared server's connections
+type Budget struct {
+	// PerAddr is how many sessions one client IP address may run, 0 for
+	// any number
+	PerAddr int
+	// PerIdentity is how many sessions one client identity may run, 0 for
+	// any number. Sessions without an identity only count by address.
+	PerIdentity int
+	// Cooldown is how long a client that went over its budget is turned
+	// away, even once its sessions ended
+	Cooldown time.Duration
+}
+
+// BudgetError is a session turned away over its client's budget
+type BudgetError struct {
+	// Client is the address or identity that went over its budget
+	Client string
+	// Reason says which budget it went over
+	Reason string
+	// RetryAfter is how long the client should wait
+	RetryAfter time.Duration
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrOverBudget, e.Reason)
+}
+
+// Is makes the error match ErrOverBudget
+func (e *BudgetError) Is(target error) bool {
+	return target == ErrOverBudget
+}
+
+// Write answers the request with 429 Too Many Requests, a Retry-After header
+// and a JSON body like the one of maintenance mode
+func (e *BudgetError) Write(w http.ResponseWriter) {
+	seconds := int((e.RetryAfter + time.Second - 1) / time.Second)
+	body, _ := json.Marshal(maintenance.Unavailable{
+		Error:      ErrOverBudget.Error(),
+		Reason:     e.Reason,
+		RetryAfter: seconds,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(body)
+}
+
+// SetBudget bounds the sessions of each client from now on
+func (a *Active) SetBudget(b Budget) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.budget = b
+}
+
+// overBudget returns the error of a session described by info if its client
+// is cooling down or runs as many sessions as it may, starting its cooldown
+// in the latter case. It must be called with the lock held.
+func (a *Active) overBudget(info Info) error {
+	now := a.now()
+	for client, until := range a.cooldowns {
+		if !now.Before(until) {
+			delete(a.cooldowns, client)
+		}
+	}
+
+	for _, c := range []struct {
+		kind string
+		of   func(Info) string
+		max  int
+	}{
+		{"address", func(i Info) string { return i.Addr }, a.budget.PerAddr},
+		{"identity", func(i Info) string { return i.Identity }, a.budget.PerIdentity},
+	} {
+		client := c.of(info)
+		if c.max == 0 || client == "" {
+			continue
+		}
+		name := c.kind + " " + client
+		if until, ok := a.cooldowns[name]; ok {
+			return &BudgetError{Client: name, Reason: name + " is cooling down after going over its budget", RetryAfter: until.Sub(now)}
+		}
+		running := 0
+		for _, s := range a.sessions {
+			if c.of(s.info) == client {
+				running++
+			}
+		}
+		if running < c.max {
+			continue
+		}
+		retryAfter := fullRetryAfter
+		if a.budget.Cooldown > 0 {
+			a.cooldowns[name] = now.Add(a.budget.Cooldown)
+			retryAfter = a.budget.Cooldown
+		}
+		return &BudgetError{Client: name, Reason: fmt.Sprintf("%d of %d sessions of %s active", running, c.max, name), RetryAfter: retryAfter}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Package sessions tracks the connected session of each client identity, so
+// the server can apply a policy when the same identity connects again, for
+// example after a client crashed without closing its connection. It also
+// holds every active session of the server, to list them and to bound how
+// many peer connections run at once, in all and for each client.
+package sessions
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Policy decides what happens when an identity connects while it still has
+// a session
+type Policy string
+
+const (
+	// Allow lets any number of sessions share an identity
+	Allow Policy = "allow"
+	// Reject turns the new session away while the old one lasts
+	Reject Policy = "reject"
+	// Takeover ends the old session in favor of the new one
+	Takeover Policy = "takeover"
+)
+
+// ErrDuplicate is returned when Reject turns a session away
+var ErrDuplicate = errors.New("client identity already has a session")
+
+// ParsePolicy parses a duplicate connection policy, defaulting to Allow
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case "":
+		return Allow, nil
+	case Allow, Reject, Takeover:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid duplicate connection policy %q (expected allow, reject or takeover)", s)
+}
+
+// session is the current session of an identity
+type session struct {
+	end func()
+}
+
+// Registry holds the current session of each identity
+type Registry struct {
+	mu       sync.Mutex
+	policy   Policy
+	sessions map[string]*session
+}
+
+// NewRegistry creates an empty registry applying policy
+func NewRegistry(policy Policy) *Registry {
+	return &Registry{policy: policy, sessions: make(map[string]*session)}
+}
+
+// Claim registers a new session of identity, which end terminates. Under
+// Reject it fails with ErrDuplicate while identity has a session; under
+// Takeover it ends the old session in the background. The returned release
+// must be called once the session ended, and only forgets the session if it
+// was not taken over since. Sessions without an identity are never tracked.
+func (r *Registry) Claim(identity string, end func()) (release func(), err error) {
+	if identity == "" || r.policy == Allow {
+		return func() {}, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stale, ok := r.sessions[identity]; ok {
+		if r.policy == Reject {
+			return nil, ErrDuplicate
+		}
+		go stale.end()
+	}
+
+	s := &session{end: end}
+	r.sessions[identity] = s
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.sessions[identity] == s {
+			delete(r.sessions, identity)
+		}
+	}, nil
+}
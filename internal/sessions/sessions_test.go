@@ -0,0 +1,380 @@
+package sessions
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/maintenance"
+)
+
+func TestParsePolicy(t *testing.T) {
+	for input, want := range map[string]Policy{"": Allow, "allow": Allow, "reject": Reject, "takeover": Takeover} {
+		if got, err := ParsePolicy(input); err != nil || got != want {
+			t.Errorf("Expected %q to parse as %s, got %s, %v", input, want, got, err)
+		}
+	}
+	if _, err := ParsePolicy("kick"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestClaim(t *testing.T) {
+	never := func() { t.Error("Expected no session to be ended") }
+
+	t.Run("Allow", func(t *testing.T) {
+		r := NewRegistry(Allow)
+		for range 2 {
+			if _, err := r.Claim("alice", never); err != nil {
+				t.Errorf("Expected duplicates to be allowed, got %v", err)
+			}
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		r := NewRegistry(Reject)
+		release, err := r.Claim("alice", never)
+		if err != nil {
+			t.Fatalf("Claim returned error: %v", err)
+		}
+		if _, err := r.Claim("alice", never); err != ErrDuplicate {
+			t.Errorf("Expected ErrDuplicate, got %v", err)
+		}
+		if _, err := r.Claim("bob", never); err != nil {
+			t.Errorf("Expected another identity to connect, got %v", err)
+		}
+
+		// Once the session ended the identity may connect again
+		release()
+		if _, err := r.Claim("alice", never); err != nil {
+			t.Errorf("Expected a new session after release, got %v", err)
+		}
+	})
+
+	t.Run("Takeover", func(t *testing.T) {
+		r := NewRegistry(Takeover)
+		ended := func(name string, done chan struct{}) {
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatalf("Expected the %s session to be ended", name)
+			}
+		}
+
+		stale := make(chan struct{})
+		releaseStale, err := r.Claim("alice", func() { close(stale) })
+		if err != nil {
+			t.Fatalf("Claim returned error: %v", err)
+		}
+		current := make(chan struct{})
+		if _, err := r.Claim("alice", func() { close(current) }); err != nil {
+			t.Fatalf("Expected the new session to take over, got %v", err)
+		}
+		ended("stale", stale)
+
+		// Releasing the stale session must not forget the one that took over
+		releaseStale()
+		if _, err := r.Claim("alice", func() {}); err != nil {
+			t.Fatalf("Claim returned error: %v", err)
+		}
+		ended("current", current)
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		r := NewRegistry(Reject)
+		for range 2 {
+			if _, err := r.Claim("", never); err != nil {
+				t.Errorf("Expected sessions without an identity to be untracked, got %v", err)
+			}
+		}
+	})
+}
+
+func TestActive(t *testing.T) {
+	a := NewActive(2)
+	first, err := a.Admit(Info{Identity: "alice", File: "app.log"})
+	if err != nil {
+		t.Fatalf("Admit returned error: %v", err)
+	}
+	second, err := a.Admit(Info{File: "app.log"})
+	if err != nil {
+		t.Fatalf("Admit returned error: %v", err)
+	}
+	if first.ID() == "" || first.ID() == second.ID() {
+		t.Errorf("Expected distinct session IDs, got %q and %q", first.ID(), second.ID())
+	}
+	if !a.Full() {
+		t.Error("Expected the registry to be full")
+	}
+	if _, err := a.Admit(Info{}); err != ErrFull {
+		t.Errorf("Expected ErrFull, got %v", err)
+	}
+
+	first.SetState("connected")
+	list := a.List()
+	if len(list) != 2 || list[0].ID != first.ID() || list[1].ID != second.ID() {
+		t.Fatalf("Expected both sessions oldest first, got %+v", list)
+	}
+	if got := list[0]; got.Identity != "alice" || got.File != "app.log" || got.State != "connected" || got.Started.IsZero() {
+		t.Errorf("Unexpected session %+v", got)
+	}
+	if got := list[1].State; got != "new" {
+		t.Errorf("Expected a new session, got %q", got)
+	}
+
+	// Releasing a session frees its slot, once
+	first.Release()
+	first.Release()
+	if a.Full() || len(a.List()) != 1 {
+		t.Errorf("Expected one session left, got %+v", a.List())
+	}
+	if _, err := a.Admit(Info{}); err != nil {
+		t.Errorf("Expected a session to be admitted after a release, got %v", err)
+	}
+
+	// Without a limit every session is admitted
+	unbounded := NewActive(0)
+	for range 100 {
+		if _, err := unbounded.Admit(Info{}); err != nil {
+			t.Fatalf("Expected no limit, got %v", err)
+		}
+	}
+}
+
+func TestActiveReject(t *testing.T) {
+	a := NewActive(1)
+	rec := httptest.NewRecorder()
+	if a.Reject(rec) {
+		t.Fatal("Expected an empty registry to admit offers")
+	}
+
+	if _, err := a.Admit(Info{}); err != nil {
+		t.Fatalf("Admit returned error: %v", err)
+	}
+	if !a.Reject(rec) {
+		t.Fatal("Expected a full registry to turn offers away")
+	}
+	resp := rec.Result()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "10" {
+		t.Errorf("Expected 503 with Retry-After 10, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	err := maintenance.CheckResponse(resp, rec.Body.Bytes())
+	var unavailable *maintenance.Error
+	if !errors.As(err, &unavailable) || unavailable.Message != ErrFull.Error() || unavailable.Reason != "1 of 1 connections active" || unavailable.RetryAfter != 10*time.Second {
+		t.Errorf("Expected a structured connection limit error, got %v", err)
+	}
+}
+
+func TestActiveServeHTTP(t *testing.T) {
+	a := NewActive(0)
+	s, _ := a.Admit(Info{Identity: "alice"})
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sessions", nil))
+	var list []Info
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode sessions: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != s.ID() || list[0].Identity != "alice" {
+		t.Errorf("Expected the admitted session, got %+v", list)
+	}
+
+	// DELETE ends a session, which counts until it is released
+	ended := make(chan struct{})
+	s.Attach(nil, func() { close(ended) })
+	rec = httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/sessions?id="+s.ID(), nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rec.Code)
+	}
+	select {
+	case <-ended:
+	default:
+		t.Error("Expected the session to be ended")
+	}
+	rec = httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/sessions?id=unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown session, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sessions", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+}
+
+func TestActiveAttach(t *testing.T) {
+	a := NewActive(0)
+	s, _ := a.Admit(Info{})
+
+	// A session without a connection yet has no counters and cannot be
+	// ended
+	if got := a.List()[0].Stats; got != (Stats{}) {
+		t.Errorf("Expected no counters, got %+v", got)
+	}
+	if a.End(s.ID()) {
+		t.Error("Expected a session without a connection not to be ended")
+	}
+
+	sent := uint64(0)
+	ends := 0
+	s.Attach(func() Stats {
+		sent += 100
+		return Stats{MessagesSent: sent / 10, BytesSent: sent, Buffered: 42}
+	}, func() { ends++ })
+	if got := a.List()[0].Stats; got != (Stats{MessagesSent: 10, BytesSent: 100, Buffered: 42}) {
+		t.Errorf("Unexpected counters %+v", got)
+	}
+	if got := a.List()[0].BytesSent; got != 200 {
+		t.Errorf("Expected the counters to be read on every list, got %d bytes", got)
+	}
+
+	if !a.End(s.ID()) || ends != 1 {
+		t.Errorf("Expected the session to be ended once, got %d", ends)
+	}
+	s.Release()
+	if a.End(s.ID()) {
+		t.Error("Expected a released session not to be ended")
+	}
+}
+
+func TestActiveBudget(t *testing.T) {
+	a := NewActive(0)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	a.SetBudget(Budget{PerAddr: 2, PerIdentity: 1, Cooldown: time.Minute})
+
+	first, err := a.Admit(Info{Addr: "192.0.2.1", Identity: "alice"})
+	if err != nil {
+		t.Fatalf("Admit returned error: %v", err)
+	}
+	if _, err := a.Admit(Info{Addr: "192.0.2.2", Identity: "alice"}); !errors.Is(err, ErrOverBudget) {
+		t.Errorf("Expected the identity to be over its budget, got %v", err)
+	}
+	if _, err := a.Admit(Info{Addr: "192.0.2.1"}); err != nil {
+		t.Errorf("Expected a second session of the address, got %v", err)
+	}
+	_, err = a.Admit(Info{Addr: "192.0.2.1"})
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Client != "address 192.0.2.1" || budgetErr.RetryAfter != time.Minute {
+		t.Fatalf("Expected the address to be over its budget for a minute, got %v", err)
+	}
+	if _, err := a.Admit(Info{Addr: "192.0.2.3"}); err != nil {
+		t.Errorf("Expected other addresses to be admitted, got %v", err)
+	}
+
+	// The address cools down even once its sessions ended
+	first.Release()
+	now = now.Add(20 * time.Second)
+	if _, err := a.Admit(Info{Addr: "192.0.2.1"}); !errors.As(err, &budgetErr) || budgetErr.RetryAfter != 40*time.Second {
+		t.Errorf("Expected the address to cool down for 40s more, got %v", err)
+	}
+	now = now.Add(40 * time.Second)
+	if _, err := a.Admit(Info{Addr: "192.0.2.1"}); err != nil {
+		t.Errorf("Expected the address to be admitted after its cooldown, got %v", err)
+	}
+	if list := a.List(); len(list) != 3 || list[0].Addr != "192.0.2.1" {
+		t.Errorf("Expected the sessions with their addresses, got %+v", list)
+	}
+
+	// Without a cooldown the client may retry once a session ended
+	a.SetBudget(Budget{PerAddr: 1})
+	if _, err := a.Admit(Info{Addr: "192.0.2.3"}); !errors.As(err, &budgetErr) || budgetErr.RetryAfter != fullRetryAfter {
+		t.Errorf("Expected the address to be over its budget, got %v", err)
+	}
+	if _, err := a.Admit(Info{}); err != nil {
+		t.Errorf("Expected sessions without an address to be admitted, got %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	(&BudgetError{Reason: "2 of 2 sessions of address 192.0.2.1 active", RetryAfter: 1500 * time.Millisecond}).Write(rr)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected 429 with Retry-After 2, got %d %v", rr.Code, rr.Header())
+	}
+	var body maintenance.Unavailable
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body.Error != ErrOverBudget.Error() || body.RetryAfter != 2 {
+		t.Errorf("Unexpected body %s", rr.Body.String())
+	}
+}
+
+func TestStall(t *testing.T) {
+	for input, want := range map[string]StallPolicy{"": Close, "close": Close, "report": Report} {
+		if got, err := ParseStallPolicy(input); err != nil || got != want {
+			t.Errorf("Expected %q to parse as %s, got %s, %v", input, want, got, err)
+		}
+	}
+	if _, err := ParseStallPolicy("restart"); err == nil {
+		t.Error("Expected an error for an unknown stall policy")
+	}
+	if _, err := ParseStallPolicy("renegotiate"); err == nil || !strings.Contains(err.Error(), "connect again") {
+		t.Errorf("Expected renegotiation to be refused with the reason, got %v", err)
+	}
+
+	a := NewActive(0)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	s, _ := a.Admit(Info{})
+	idle, _ := a.Admit(Info{})
+	idle.Attach(func() Stats { return Stats{} }, func() {})
+
+	var counters Stats
+	s.Attach(func() Stats { return counters }, func() {})
+	stalls := 0
+	s.OnStall(func() { stalls++ })
+
+	// Sessions still connecting are not watched
+	now = now.Add(time.Minute)
+	a.check(time.Second)
+	if a.List()[0].Stalled || a.List()[1].Stalled {
+		t.Fatal("Expected sessions that are not connected not to stall")
+	}
+
+	s.SetState("connected")
+	counters = Stats{BytesSent: 100}
+	a.check(time.Second)
+	now = now.Add(900 * time.Millisecond)
+	a.check(time.Second)
+	if stalls != 0 {
+		t.Fatal("Expected the session not to stall within the timeout")
+	}
+	now = now.Add(time.Minute)
+	a.check(time.Second)
+	if stalls != 0 {
+		t.Fatal("Expected a session with nothing queued not to stall")
+	}
+
+	// Bytes still queued were not acknowledged by the client
+	counters = Stats{BytesSent: 200, Buffered: 100}
+	stalled := stalledCounter.Value()
+	now = now.Add(100 * time.Millisecond)
+	a.check(time.Second)
+	now = now.Add(time.Second)
+	a.check(time.Second)
+	if stalls != 1 || stalledCounter.Value()-stalled != 1 {
+		t.Fatalf("Expected the session to stall once, got %d", stalls)
+	}
+	if list := a.List(); !list[0].Stalled && !list[1].Stalled {
+		t.Errorf("Expected the session to be listed as stalled, got %+v", list)
+	}
+
+	// A session that makes progress again is no longer stalled, and may
+	// stall again
+	counters = Stats{BytesSent: 300, Buffered: 50}
+	a.check(time.Second)
+	for _, info := range a.List() {
+		if info.Stalled {
+			t.Errorf("Expected the session not to be stalled after progress, got %+v", info)
+		}
+	}
+	now = now.Add(time.Second)
+	a.check(time.Second)
+	if stalls != 2 {
+		t.Errorf("Expected the session to stall again, got %d", stalls)
+	}
+}
@@ -0,0 +1,109 @@
+package sessions
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/metrics"
+)
+
+// stalledCounter counts the sessions declared stalled
+var stalledCounter = metrics.NewCounter("webrtc_poc_sessions_stalled_total",
+	"Sessions whose client acknowledged no bytes for --stall-timeout")
+
+// StallPolicy decides what happens to a session that stalled. There is no
+// policy that renegotiates the session: only connected sessions are watched,
+// so ICE still reaches the client and the path is not what stalled, the
+// client is, and a client that stopped reading would not answer a new offer
+// either. The server also has no signaling channel to send an established
+// client one, so a client that should go on has to connect again.
+type StallPolicy string
+
+const (
+	// Close closes the stalled session's connection, freeing its slot
+	Close StallPolicy = "close"
+	// Report only marks the session as stalled in the session list until
+	// it makes progress again
+	Report StallPolicy = "report"
+)
+
+// ParseStallPolicy parses a stall policy, defaulting to Close
+func ParseStallPolicy(s string) (StallPolicy, error) {
+	switch p := StallPolicy(s); p {
+	case "":
+		return Close, nil
+	case Close, Report:
+		return p, nil
+	case "renegotiate":
+		return "", fmt.Errorf("invalid stall policy %q: a stalled session cannot be renegotiated, its client has to connect again (expected close or report)", s)
+	}
+	return "", fmt.Errorf("invalid stall policy %q (expected close or report)", s)
+}
+
+// OnStall sets what happens once the session stalled, which the session's
+// connection applies its policy with
+func (s *Session) OnStall(stalled func()) {
+	s.a.mu.Lock()
+	defer s.a.mu.Unlock()
+	s.stalled = stalled
+}
+
+// Watch declares the connected sessions whose client acknowledged no bytes,
+// the bytes sent less those still queued, for timeout as stalled, checking
+// them until stop is called. A session with nothing queued is idle, not
+// stalled, like one following a file nothing is appended to. A stalled
+// session is listed as such, and its OnStall function called once, until it
+// makes progress again.
+func (a *Active) Watch(timeout time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(max(min(timeout/4, time.Second), time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.check(timeout)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// check declares the sessions that made no progress for timeout as stalled
+func (a *Active) check(timeout time.Duration) {
+	a.mu.Lock()
+	watched := make([]*Session, 0, len(a.sessions))
+	stats := make([]func() Stats, 0, len(a.sessions))
+	for _, s := range a.sessions {
+		if s.stats != nil && s.info.State == "connected" {
+			watched = append(watched, s)
+			stats = append(stats, s.stats)
+		}
+	}
+	a.mu.Unlock()
+
+	// Read the counters outside the lock, they come from the connections
+	now := a.now()
+	for i, s := range watched {
+		counters := stats[i]()
+		acked := counters.BytesSent - min(counters.Buffered, counters.BytesSent)
+
+		a.mu.Lock()
+		var stalled func()
+		switch {
+		case s.progress.IsZero() || acked > s.acked || counters.Buffered == 0:
+			s.acked, s.progress = acked, now
+			s.info.Stalled = false
+		case !s.info.Stalled && now.Sub(s.progress) >= timeout:
+			s.info.Stalled = true
+			stalledCounter.Inc()
+			stalled = s.stalled
+		}
+		a.mu.Unlock()
+		if stalled != nil {
+			stalled()
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Package share lets a connected client request more files over the peer
+// connection it already has. Every request is a data channel the client opens
+// with Protocol as its subprotocol and the requested path as its label. The
+// server streams the file's lines as text messages, sends a binary Result as
+// the last message and closes the channel. Glob patterns are expanded by the
+// server first, on a channel with ListProtocol whose Result lists the
+// matching files. On a channel with DedupProtocol the server first sends a
+// Manifest of the file's chunks and waits for the client's Need, then only
+// streams the lines of the chunks the client does not have cached. Chunks are
+// hashed with the checksum algorithm negotiated with the connection's offer,
+// which the Manifest names. A client that checks the chunks' CRCs sends
+// another Need for every chunk that arrived corrupted, which the server
+// streams again once it sent the others, and an empty Need when every chunk
+// checked out. Clients read the files to request from fetch lists and
+// summarise the outcome of every fetch.
+package share
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/dedup"
+	"github.com/developmeh/webrtc-poc/internal/pathpolicy"
+)
+
+const (
+	// Protocol marks the data channels that request a file
+	Protocol = "webrtc-poc-request"
+	// ListProtocol marks the data channels that expand a glob pattern
+	ListProtocol = "webrtc-poc-list"
+	// DedupProtocol marks the data channels that request a file and
+	// exchange chunk hashes before its lines
+	DedupProtocol = "webrtc-poc-request-dedup"
+)
+
+var (
+	// ErrDisabled is returned when the server does not share any directory
+	ErrDisabled = errors.New("server does not share files")
+	// ErrOutsideRoot is returned for paths that leave the shared directory
+	ErrOutsideRoot = errors.New("path is outside the shared directory")
+)
+
+// Result is the outcome of a request, sent after the file's lines
+type Result struct {
+	Lines int    `json:"lines"`
+	Error string `json:"error,omitempty"`
+	// Files are the paths a pattern matched, relative to the shared
+	// directory, or the name an accepted upload was stored as
+	Files []string `json:"files,omitempty"`
+}
+
+// Manifest lists the chunks of a requested file
+type Manifest struct {
+	Chunks []dedup.Chunk `json:"chunks"`
+	Error  string        `json:"error,omitempty"`
+	// CRC is set when the chunks carry their CRC32C
+	CRC bool `json:"crc,omitempty"`
+	// Algorithm is the checksum algorithm the chunks are hashed with, empty
+	// for SHA-256
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+// Need lists the indexes of the chunks the client wants streamed
+type Need struct {
+	Chunks []int `json:"chunks"`
+	// Verify tells the server the client checks the chunks' CRCs, so it
+	// waits for retransmission requests until an empty Need
+	Verify bool `json:"verify,omitempty"`
+}
+
+// Encode returns the message carrying the result
+func (r Result) Encode() []byte {
+	data, _ := json.Marshal(r)
+	return data
+}
+
+// Encode returns the message carrying the manifest
+func (m Manifest) Encode() []byte {
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// DecodeManifest parses a manifest message
+func DecodeManifest(data []byte) (Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("error parsing manifest: %w", err)
+	}
+	return m, nil
+}
+
+// Encode returns the message carrying the needed chunks
+func (n Need) Encode() []byte {
+	data, _ := json.Marshal(n)
+	return data
+}
+
+// DecodeNeed parses a message listing the needed chunks
+func DecodeNeed(data []byte) (Need, error) {
+	var n Need
+	if err := json.Unmarshal(data, &n); err != nil {
+		return Need{}, fmt.Errorf("error parsing needed chunks: %w", err)
+	}
+	return n, nil
+}
+
+// DecodeResult parses a result message
+func DecodeResult(data []byte) (Result, error) {
+	var r Result
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Result{}, fmt.Errorf("error parsing request result: %w", err)
+	}
+	return r, nil
+}
+
+// CheckResult decodes the result that ends a request and checks it against
+// the lines received
+func CheckResult(data []byte, received int) (Result, error) {
+	result, err := DecodeResult(data)
+	switch {
+	case err != nil:
+		return result, err
+	case result.Error != "":
+		return result, errors.New(result.Error)
+	case result.Lines != received:
+		return result, fmt.Errorf("received %d of %d lines", received, result.Lines)
+	}
+	return result, nil
+}
+
+// Resolve maps a requested path to a file inside the policy's root. Requests
+// are always relative to the root, so a leading slash is ignored, paths that
+// climb out of it with ".." are rejected, and the policy refuses paths that
+// leave it through a symlink. A nil policy means nothing is shared.
+func Resolve(policy *pathpolicy.Policy, name string) (string, error) {
+	if policy == nil {
+		return "", ErrDisabled
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return "", ErrOutsideRoot
+		}
+	}
+	rel := filepath.Clean("/" + filepath.FromSlash(name))[1:]
+	if rel == "" {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+	return policy.Check(filepath.Join(policy.Root(), rel))
+}
+
+// IsPattern reports whether a requested path is a glob pattern
+func IsPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// Expand returns the regular files inside the policy's root that match a
+// glob pattern, as slash separated paths relative to the root. Like Resolve
+// it keeps the pattern inside the root, and skips the matches the policy
+// refuses.
+func Expand(policy *pathpolicy.Policy, pattern string) ([]string, error) {
+	path, err := Resolve(policy, pattern)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, match := range matches {
+		if _, err := policy.Check(match); err != nil {
+			continue
+		}
+		if info, err := os.Stat(match); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		rel, err := filepath.Rel(policy.Root(), match)
+		if err != nil {
+			continue
+		}
+		files = append(files, filepath.ToSlash(rel))
+	}
+	return files, nil
+}
+
+// Fetch is one file to request and the path to write it to. For a pattern
+// Output is the directory the matching files are written below.
+type Fetch struct {
+	Name   string
+	Output string
+}
+
+// ParseList reads a fetch list: one requested file per line, optionally
+// followed by the path to write it to. Files without an output path are
+// written below the policy's root under their requested path. Blank lines
+// and lines starting with # are skipped.
+func ParseList(r io.Reader, policy *pathpolicy.Policy) ([]Fetch, error) {
+	var fetches []Fetch
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected a file and an optional output path, got %q", line, text)
+		}
+		fetch, err := NewFetch(fields[0], policy)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(fields) == 2 {
+			if fetch.Output, err = pathpolicy.Canonical(fields[1]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		fetches = append(fetches, fetch)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading fetch list: %w", err)
+	}
+	return fetches, nil
+}
+
+// NewFetch requests name and writes it below the policy's root under its
+// requested path
+func NewFetch(name string, policy *pathpolicy.Policy) (Fetch, error) {
+	if IsPattern(name) {
+		if _, err := Resolve(policy, name); err != nil {
+			return Fetch{}, fmt.Errorf("cannot request %s: %w", name, err)
+		}
+		return Fetch{Name: name, Output: policy.Root()}, nil
+	}
+	output, err := Resolve(policy, name)
+	if err != nil {
+		return Fetch{}, fmt.Errorf("cannot request %s: %w", name, err)
+	}
+	return Fetch{Name: name, Output: output}, nil
+}
+
+// Matches returns a fetch for every file a pattern matched, written below
+// the pattern's output directory. The server names the files, so they are
+// held to the same policy as requested paths.
+func (f Fetch) Matches(files []string, followSymlinks bool) ([]Fetch, error) {
+	policy, err := pathpolicy.New(f.Output, followSymlinks)
+	if err != nil {
+		return nil, err
+	}
+	fetches := make([]Fetch, 0, len(files))
+	for _, name := range files {
+		output, err := Resolve(policy, name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot request %s: %w", name, err)
+		}
+		fetches = append(fetches, Fetch{Name: name, Output: output})
+	}
+	return fetches, nil
+}
+
+// Outcome is the result of one fetch
+type Outcome struct {
+	Fetch
+	Lines    int
+	Duration time.Duration
+	Err      error
+}
+
+// Failed counts the outcomes with an error
+func Failed(outcomes []Outcome) int {
+	n := 0
+	for _, o := range outcomes {
+		if o.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteSummary prints one line per fetch and the totals
+func WriteSummary(w io.Writer, outcomes []Outcome) {
+	lines := 0
+	for _, o := range outcomes {
+		if o.Err != nil {
+			fmt.Fprintf(w, "FAILED  %s: %v\n", o.Name, o.Err)
+			continue
+		}
+		lines += o.Lines
+		fmt.Fprintf(w, "OK      %s -> %s (%d lines in %v)\n", o.Name, o.Output, o.Lines, o.Duration.Round(time.Millisecond))
+	}
+	fmt.Fprintf(w, "Fetched %d of %d files, %d lines\n", len(outcomes)-Failed(outcomes), len(outcomes), lines)
+}
@@ -0,0 +1,218 @@
+package share
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/pathpolicy"
+)
+
+// newPolicy returns a policy for root that refuses symlink escapes
+func newPolicy(t *testing.T, root string) *pathpolicy.Policy {
+	t.Helper()
+	policy, err := pathpolicy.New(root, false)
+	if err != nil {
+		t.Fatalf("pathpolicy.New returned error: %v", err)
+	}
+	return policy
+}
+
+func TestResolve(t *testing.T) {
+	policy := newPolicy(t, filepath.Join("srv", "files"))
+	root := policy.Root()
+
+	valid := map[string]string{
+		"a.txt":         filepath.Join(root, "a.txt"),
+		"logs/b.log":    filepath.Join(root, "logs", "b.log"),
+		"/logs/b.log":   filepath.Join(root, "logs", "b.log"),
+		"./logs//b.log": filepath.Join(root, "logs", "b.log"),
+		"notes..txt":    filepath.Join(root, "notes..txt"),
+	}
+	for name, want := range valid {
+		got, err := Resolve(policy, name)
+		if err != nil {
+			t.Errorf("Resolve(%q) returned error: %v", name, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Expected %s for %q, got %s", want, name, got)
+		}
+	}
+
+	for _, name := range []string{"../secret", "logs/../../secret", "..", "logs/.."} {
+		if _, err := Resolve(policy, name); !errors.Is(err, ErrOutsideRoot) {
+			t.Errorf("Expected ErrOutsideRoot for %q, got %v", name, err)
+		}
+	}
+
+	if _, err := Resolve(policy, "/"); err == nil {
+		t.Error("Expected an error for an empty path")
+	}
+	if _, err := Resolve(nil, "a.txt"); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Expected ErrDisabled without a root, got %v", err)
+	}
+}
+
+func TestResult(t *testing.T) {
+	for _, r := range []Result{{Lines: 20}, {Error: "file not found"}, {Files: []string{"a.log", "b.log"}}} {
+		got, err := DecodeResult(r.Encode())
+		if err != nil {
+			t.Fatalf("DecodeResult returned error: %v", err)
+		}
+		if !reflect.DeepEqual(got, r) {
+			t.Errorf("Expected %+v, got %+v", r, got)
+		}
+	}
+
+	if _, err := DecodeResult([]byte("not json")); err == nil {
+		t.Error("Expected an error for a malformed result")
+	}
+}
+
+func TestParseList(t *testing.T) {
+	list := `# nightly logs
+logs/a.log
+
+  b.txt   /tmp/b-copy.txt
+`
+	policy := newPolicy(t, "out")
+	fetches, err := ParseList(strings.NewReader(list), policy)
+	if err != nil {
+		t.Fatalf("ParseList returned error: %v", err)
+	}
+
+	want := []Fetch{
+		{Name: "logs/a.log", Output: filepath.Join(policy.Root(), "logs", "a.log")},
+		{Name: "b.txt", Output: filepath.Clean("/tmp/b-copy.txt")},
+	}
+	if len(fetches) != len(want) {
+		t.Fatalf("Expected %d fetches, got %+v", len(want), fetches)
+	}
+	for i := range want {
+		if fetches[i] != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], fetches[i])
+		}
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, list := range []string{"a.txt b.txt c.txt", "ok.txt\n../secret"} {
+			if _, err := ParseList(strings.NewReader(list), policy); err == nil {
+				t.Errorf("Expected an error for %q", list)
+			}
+		}
+	})
+}
+
+func TestWriteSummary(t *testing.T) {
+	outcomes := []Outcome{
+		{Fetch: Fetch{Name: "a.log", Output: "out/a.log"}, Lines: 3, Duration: time.Second},
+		{Fetch: Fetch{Name: "b.log", Output: "out/b.log"}, Err: errors.New("file not found")},
+	}
+	if n := Failed(outcomes); n != 1 {
+		t.Errorf("Expected 1 failed fetch, got %d", n)
+	}
+
+	var out bytes.Buffer
+	WriteSummary(&out, outcomes)
+	for _, want := range []string{"OK      a.log -> out/a.log (3 lines", "FAILED  b.log: file not found", "Fetched 1 of 2 files, 3 lines"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	root, err := os.MkdirTemp("", "share-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, name := range []string{"logs/2024-06-01.log", "logs/2024-06-02.log", "logs/2024-07-01.log", "logs/2024-06-dir.log/x"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte("line\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	policy := newPolicy(t, root)
+	files, err := Expand(policy, "logs/2024-06-*.log")
+	if err != nil {
+		t.Fatalf("Expand returned error: %v", err)
+	}
+	want := []string{"logs/2024-06-01.log", "logs/2024-06-02.log"}
+	if strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, files)
+	}
+
+	if files, err := Expand(policy, "none-*.log"); err != nil || len(files) != 0 {
+		t.Errorf("Expected no matches, got %v, %v", files, err)
+	}
+	if _, err := Expand(policy, "../*"); !errors.Is(err, ErrOutsideRoot) {
+		t.Errorf("Expected ErrOutsideRoot, got %v", err)
+	}
+	if _, err := Expand(policy, "logs/[.log"); err == nil {
+		t.Error("Expected an error for a malformed pattern")
+	}
+
+	t.Run("Symlinks", func(t *testing.T) {
+		outside := filepath.Join(t.TempDir(), "secret.log")
+		if err := os.WriteFile(outside, []byte("secret\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", outside, err)
+		}
+		link := filepath.Join(root, "logs", "2024-06-link.log")
+		if err := os.Symlink(outside, link); err != nil {
+			t.Skipf("Cannot create symlinks: %v", err)
+		}
+		defer os.Remove(link)
+
+		if _, err := Resolve(policy, "logs/2024-06-link.log"); !errors.Is(err, pathpolicy.ErrSymlinkEscape) {
+			t.Errorf("Expected ErrSymlinkEscape, got %v", err)
+		}
+		files, err := Expand(policy, "logs/2024-06-*.log")
+		if err != nil || strings.Join(files, ",") != strings.Join(want, ",") {
+			t.Errorf("Expected %v without the escaping link, got %v, %v", want, files, err)
+		}
+
+		follow, err := pathpolicy.New(root, true)
+		if err != nil {
+			t.Fatalf("pathpolicy.New returned error: %v", err)
+		}
+		if files, err := Expand(follow, "logs/2024-06-*.log"); err != nil || len(files) != 3 {
+			t.Errorf("Expected the link to match when following symlinks, got %v, %v", files, err)
+		}
+	})
+}
+
+func TestPatternFetch(t *testing.T) {
+	if !IsPattern("logs/*.log") || IsPattern("logs/a.log") {
+		t.Error("Expected only paths with glob characters to be patterns")
+	}
+
+	policy := newPolicy(t, "out")
+	fetch, err := NewFetch("logs/*.log", policy)
+	if err != nil {
+		t.Fatalf("NewFetch returned error: %v", err)
+	}
+	if fetch.Output != policy.Root() {
+		t.Errorf("Expected a pattern to keep the output directory, got %s", fetch.Output)
+	}
+
+	matches, err := fetch.Matches([]string{"logs/a.log"}, false)
+	if err != nil {
+		t.Fatalf("Matches returned error: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Output != filepath.Join(policy.Root(), "logs", "a.log") {
+		t.Errorf("Expected the match to be written below the output directory, got %+v", matches)
+	}
+	if _, err := fetch.Matches([]string{"../a.log"}, false); err == nil {
+		t.Error("Expected an error for a match outside the output directory")
+	}
+}
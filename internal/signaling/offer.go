@@ -0,0 +1,148 @@
+package signaling
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Limits of the offers a server accepts
+const (
+	// MaxBodySize is the largest offer request body, sealed or not
+	MaxBodySize = 128 * 1024
+	// MaxSDPSize is the largest SDP an offer may carry, many times what a
+	// peer with every candidate in it sends
+	MaxSDPSize = 64 * 1024
+)
+
+// Codes of the rejected offers, for clients to tell them apart
+const (
+	CodeContentType = "unsupported_content_type"
+	CodeTooLarge    = "body_too_large"
+	CodeMalformed   = "malformed_json"
+	CodeType        = "not_an_offer"
+	CodeMissingSDP  = "missing_sdp"
+	CodeSDPTooLarge = "sdp_too_large"
+	CodeInvalidSDP  = "invalid_sdp"
+
+	// Codes of the offers whose query or credentials the server refused
+	CodeInvalidRecord  = "invalid_record_terms"
+	CodeInvalidWindow  = "invalid_window"
+	CodeInvalidGroup   = "invalid_group"
+	CodeInvalidOffset  = "invalid_offset"
+	CodeGroupConflict  = "group_unavailable"
+	CodeUnknownExport  = "unknown_export"
+	CodeExportConflict = "export_unavailable"
+	CodeUnknownPush    = "unknown_push"
+	CodeInvalidSince   = "invalid_since"
+	CodeReplayConflict = "replay_unavailable"
+	CodeInvalidSession = "invalid_noise_session"
+	CodeNoiseRequired  = "noise_required"
+	CodeForbidden      = "forbidden"
+)
+
+// OfferError is an offer rejected before it reached the peer connection. It
+// is sent as a JSON object with the message and the code.
+type OfferError struct {
+	// Status is the HTTP status the offer is answered with
+	Status  int    `json:"-"`
+	Message string `json:"error"`
+	Code    string `json:"code"`
+}
+
+func (e *OfferError) Error() string {
+	return e.Message
+}
+
+// Write answers the request with the error
+func (e *OfferError) Write(w http.ResponseWriter) {
+	body, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	w.Write(body)
+}
+
+// Reject returns the error of an offer answered with status
+func Reject(status int, code, format string, args ...any) *OfferError {
+	return &OfferError{Status: status, Message: fmt.Sprintf(format, args...), Code: code}
+}
+
+// invalid returns the error of an offer that cannot be used
+func invalid(code, format string, args ...any) *OfferError {
+	return Reject(http.StatusBadRequest, code, format, args...)
+}
+
+// ReadOffer reads the body of an offer request sent as contentType, which is
+// application/json for a plain offer, up to MaxBodySize
+func ReadOffer(w http.ResponseWriter, r *http.Request, contentType string) ([]byte, error) {
+	if got, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || got != contentType {
+		return nil, &OfferError{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("offer must be sent as %s", contentType),
+			Code:    CodeContentType,
+		}
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, &OfferError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("offer exceeds %d bytes", MaxBodySize),
+			Code:    CodeTooLarge,
+		}
+	}
+	if err != nil {
+		return nil, invalid(CodeMalformed, "failed to read offer: %v", err)
+	}
+	return body, nil
+}
+
+// ParseOffer parses a JSON session description strictly: an object with
+// the type "offer" and an SDP of at most MaxSDPSize that parses, and
+// nothing else
+func ParseOffer(data []byte) (webrtc.SessionDescription, error) {
+	var desc struct {
+		Type *string `json:"type"`
+		SDP  *string `json:"sdp"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&desc); err != nil {
+		return webrtc.SessionDescription{}, invalid(CodeMalformed, "failed to parse offer: %v", err)
+	}
+	if dec.More() {
+		return webrtc.SessionDescription{}, invalid(CodeMalformed, "failed to parse offer: data after the session description")
+	}
+	if desc.Type == nil || *desc.Type != webrtc.SDPTypeOffer.String() {
+		return webrtc.SessionDescription{}, invalid(CodeType, "session description must have the type %q", webrtc.SDPTypeOffer.String())
+	}
+	if desc.SDP == nil || *desc.SDP == "" {
+		return webrtc.SessionDescription{}, invalid(CodeMissingSDP, "offer has no SDP")
+	}
+	if len(*desc.SDP) > MaxSDPSize {
+		return webrtc.SessionDescription{}, invalid(CodeSDPTooLarge, "offer SDP exceeds %d bytes", MaxSDPSize)
+	}
+
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: *desc.SDP}
+	if _, err := offer.Unmarshal(); err != nil {
+		return webrtc.SessionDescription{}, invalid(CodeInvalidSDP, "invalid offer SDP: %v", err)
+	}
+	return offer, nil
+}
+
+// WriteError answers a request with the error of reading or parsing its
+// offer
+func WriteError(w http.ResponseWriter, err error) {
+	var offerErr *OfferError
+	if !errors.As(err, &offerErr) {
+		offerErr = invalid(CodeMalformed, "%v", err)
+	}
+	offerErr.Write(w)
+}
@@ -0,0 +1,54 @@
+package signaling
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Sides that can create the offer
+const (
+	OfferRoleClient = "client"
+	OfferRoleServer = "server"
+)
+
+// ParseOfferRole validates an --offer-role value
+func ParseOfferRole(role string) (string, error) {
+	switch role {
+	case OfferRoleClient, OfferRoleServer:
+		return role, nil
+	case "":
+		return OfferRoleClient, nil
+	}
+	return "", fmt.Errorf("invalid offer role %q (expected %s or %s)", role, OfferRoleClient, OfferRoleServer)
+}
+
+// Ways offers reach the server
+const (
+	SignalHTTP   = "http"
+	SignalManual = "manual"
+	SignalQR     = "qr"
+)
+
+// ParseSignal validates a --signal value, reporting whether offers are
+// pasted by hand
+func ParseSignal(mode string) (bool, error) {
+	switch mode {
+	case SignalHTTP, "":
+		return false, nil
+	case SignalManual, SignalQR:
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid signaling mode %q (expected %s, %s or %s)", mode, SignalHTTP, SignalManual, SignalQR)
+}
+
+// OfferSessionHeader carries the ID of an offer made by the server from
+// /server-offer to the /answer that completes it
+const OfferSessionHeader = "X-Offer-Session"
+
+// Relayed is an offer or answer sent through a rendezvous server, or pasted
+// between terminals. The relay is not trusted, so it carries an identity
+// assertion over the SDP.
+type Relayed struct {
+	SDP      json.RawMessage `json:"sdp"`
+	Identity string          `json:"identity"`
+}
@@ -0,0 +1,77 @@
+// Package signaling defines the errors of exchanging offers and answers
+// with a server and of establishing the connection they describe, so callers
+// can tell a server that refused the exchange from one that could not be
+// reached, and both from a connection that failed after signaling succeeded.
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrSignaling matches every error of the offer and answer exchange
+	ErrSignaling = errors.New("signaling failed")
+	// ErrICEFailed is returned when the peers exchanged their descriptions
+	// but ICE found no working path between them
+	ErrICEFailed = errors.New("ICE connection failed")
+)
+
+// Error is a step of the exchange that failed before the server answered,
+// such as sending the offer
+type Error struct {
+	// Step is what failed, for example "send offer"
+	Step string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("failed to %s: %v", e.Step, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes the error match ErrSignaling
+func (e *Error) Is(target error) bool {
+	return target == ErrSignaling
+}
+
+// StatusError is a signaling request the server answered with an error status
+type StatusError struct {
+	// Step is the request the server refused, for example "offer"
+	Step string
+	// Code is the HTTP status code of the response
+	Code int
+	// Status is the HTTP status line, for example "404 Not Found"
+	Status string
+	// Body is the response body, which usually explains the error
+	Body string
+	// Reason is the code of a rejected offer, such as sdp_too_large, when
+	// the body carries one
+	Reason string
+}
+
+// NewStatusError creates the error of a response to step with an error status
+func NewStatusError(step string, resp *http.Response, body []byte) *StatusError {
+	e := &StatusError{Step: step, Code: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(body))}
+	var rejected OfferError
+	if json.Unmarshal(body, &rejected) == nil {
+		e.Reason = rejected.Code
+	}
+	return e
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server rejected the %s: %s, body: %s", e.Step, e.Status, e.Body)
+}
+
+// Is makes the error match ErrSignaling
+func (e *StatusError) Is(target error) bool {
+	return target == ErrSignaling
+}
@@ -0,0 +1,118 @@
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestErrors(t *testing.T) {
+	sendErr := &Error{Step: "send offer", Err: errors.New("connection refused")}
+	if sendErr.Error() != "failed to send offer: connection refused" {
+		t.Errorf("Unexpected message: %v", sendErr)
+	}
+
+	resp := &http.Response{StatusCode: http.StatusForbidden, Status: "403 Forbidden"}
+	statusErr := NewStatusError("answer", resp, []byte("not allowed\n"))
+	if statusErr.Error() != "server rejected the answer: 403 Forbidden, body: not allowed" {
+		t.Errorf("Unexpected message: %v", statusErr)
+	}
+
+	for _, err := range []error{sendErr, statusErr, fmt.Errorf("connecting: %w", statusErr)} {
+		if !errors.Is(err, ErrSignaling) {
+			t.Errorf("Expected %v to match ErrSignaling", err)
+		}
+		if errors.Is(err, ErrICEFailed) {
+			t.Errorf("Expected %v not to match ErrICEFailed", err)
+		}
+	}
+
+	var status *StatusError
+	if !errors.As(fmt.Errorf("connecting: %w", statusErr), &status) || status.Code != http.StatusForbidden {
+		t.Errorf("Expected the status code to be available, got %v", status)
+	}
+}
+
+// validSDP is a complete SDP without media
+const validSDP = "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"
+
+func TestReadOffer(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+		code        string
+	}{
+		{"JSON", "application/json", "{}", http.StatusOK, ""},
+		{"Charset", "application/json; charset=utf-8", "{}", http.StatusOK, ""},
+		{"Missing", "", "{}", http.StatusUnsupportedMediaType, CodeContentType},
+		{"Other", "text/plain", "{}", http.StatusUnsupportedMediaType, CodeContentType},
+		{"TooLarge", "application/json", strings.Repeat(" ", MaxBodySize+1), http.StatusRequestEntityTooLarge, CodeTooLarge},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/offer", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			body, err := ReadOffer(w, r, "application/json")
+			if tt.code == "" {
+				if err != nil || string(body) != tt.body {
+					t.Errorf("Expected the body, got %q, %v", body, err)
+				}
+				return
+			}
+			WriteError(w, err)
+			checkRejected(t, w, tt.want, tt.code)
+		})
+	}
+}
+
+func TestParseOffer(t *testing.T) {
+	offer, err := ParseOffer([]byte(`{"type":"offer","sdp":` + strconv.Quote(validSDP) + `}`))
+	if err != nil || offer.Type != webrtc.SDPTypeOffer || offer.SDP != validSDP {
+		t.Errorf("Expected the offer, got %+v, %v", offer, err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		body string
+		code string
+	}{
+		{"NotJSON", "not json", CodeMalformed},
+		{"Array", `[]`, CodeMalformed},
+		{"UnknownField", `{"type":"offer","sdp":"v=0","extra":1}`, CodeMalformed},
+		{"Trailing", `{"type":"offer","sdp":` + strconv.Quote(validSDP) + `} {}`, CodeMalformed},
+		{"MissingType", `{"sdp":"v=0"}`, CodeType},
+		{"Answer", `{"type":"answer","sdp":"v=0"}`, CodeType},
+		{"MissingSDP", `{"type":"offer"}`, CodeMissingSDP},
+		{"EmptySDP", `{"type":"offer","sdp":""}`, CodeMissingSDP},
+		{"SDPTooLarge", `{"type":"offer","sdp":"` + strings.Repeat("a", MaxSDPSize+1) + `"}`, CodeSDPTooLarge},
+		{"InvalidSDP", `{"type":"offer","sdp":"v=0"}`, CodeInvalidSDP},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseOffer([]byte(tt.body))
+			w := httptest.NewRecorder()
+			WriteError(w, err)
+			checkRejected(t, w, http.StatusBadRequest, tt.code)
+		})
+	}
+}
+
+// checkRejected checks the response to a rejected offer, and that a client
+// gets its code back
+func checkRejected(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected status %d with a JSON body, got %d %q", status, w.Code, w.Header().Get("Content-Type"))
+	}
+	resp := &http.Response{StatusCode: w.Code, Status: http.StatusText(w.Code)}
+	if err := NewStatusError("offer", resp, w.Body.Bytes()); err.Reason != code {
+		t.Errorf("Expected the code %q, got %q in %s", code, err.Reason, w.Body.String())
+	}
+}
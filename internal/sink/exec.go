@@ -0,0 +1,163 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/developmeh/webrtc-poc/internal/cmdline"
+	"github.com/developmeh/webrtc-poc/internal/logger"
+)
+
+// Restart policies for subprocesses that exit while lines are still arriving
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// ExecOptions controls how a subprocess sink is run
+type ExecOptions struct {
+	// Restart is the restart policy, RestartNever by default
+	Restart string
+	// MaxRestarts limits how often the subprocess is restarted
+	MaxRestarts int
+}
+
+// Exec writes to the standard input of a subprocess. A subprocess that exits
+// early is restarted when the next write arrives, as far as the restart
+// policy allows.
+type Exec struct {
+	mu       sync.Mutex
+	args     []string
+	opts     ExecOptions
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	restarts int
+	closed   bool
+	// err is the exit error of the last subprocess
+	err error
+}
+
+// ParseRestart validates a restart policy, defaulting to RestartNever
+func ParseRestart(policy string) (string, error) {
+	switch policy {
+	case "":
+		return RestartNever, nil
+	case RestartNever, RestartOnFailure, RestartAlways:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid restart policy %q (expected never, on-failure or always)", policy)
+}
+
+// StartExec starts command, split into arguments like a shell would without
+// expanding anything, with the client's stdout and stderr
+func StartExec(command string, opts ExecOptions) (*Exec, error) {
+	args, err := cmdline.Split(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	if opts.Restart, err = ParseRestart(opts.Restart); err != nil {
+		return nil, err
+	}
+
+	e := &Exec{args: args, opts: opts}
+	if err := e.start(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// start runs a new subprocess
+func (e *Exec) start() error {
+	cmd := exec.Command(e.args[0], e.args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error creating subprocess pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting %s: %w", e.args[0], err)
+	}
+	logger.Info("Started %s (pid %d)", e.args[0], cmd.Process.Pid)
+	e.cmd, e.stdin = cmd, stdin
+	return nil
+}
+
+// wait reaps the subprocess and records how it exited
+func (e *Exec) wait() {
+	e.stdin.Close()
+	e.err = e.cmd.Wait()
+	if e.err != nil {
+		logger.Error("%s exited: %v", e.args[0], e.err)
+	} else {
+		logger.Info("%s exited successfully", e.args[0])
+	}
+	e.cmd = nil
+}
+
+// restart reports whether the policy allows another subprocess after the
+// last one exited
+func (e *Exec) restart() bool {
+	if e.restarts >= e.opts.MaxRestarts {
+		return false
+	}
+	switch e.opts.Restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return e.err != nil
+	}
+	return false
+}
+
+// Write passes data to the subprocess, restarting it if it exited
+func (e *Exec) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for {
+		if e.closed {
+			return 0, ErrClosed
+		}
+		if e.cmd != nil {
+			n, err := e.stdin.Write(p)
+			if err == nil {
+				return n, nil
+			}
+			// The subprocess stopped reading, most likely because it exited
+			e.wait()
+		}
+		if !e.restart() {
+			if e.err != nil {
+				return 0, fmt.Errorf("%s is no longer running: %w", e.args[0], e.err)
+			}
+			return 0, fmt.Errorf("%s is no longer running", e.args[0])
+		}
+		e.restarts++
+		logger.Info("Restarting %s (%d of %d)", e.args[0], e.restarts, e.opts.MaxRestarts)
+		if err := e.start(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close closes the subprocess's stdin and waits for it to exit, returning
+// its exit error. The *exec.ExitError it wraps carries the exit status.
+func (e *Exec) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.closed && e.cmd != nil {
+		e.wait()
+	}
+	e.closed = true
+	return e.err
+}
@@ -0,0 +1,144 @@
+//go:build !windows
+
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+)
+
+// fifoPollInterval is how often a named pipe without a reader is opened again
+const fifoPollInterval = 100 * time.Millisecond
+
+// FIFO writes to a named pipe. Opening never blocks the way opening a pipe
+// for writing normally does; writes wait until a reader opened it instead,
+// and when the reader goes away the pipe is opened again for the next one,
+// so readers can come and go while the stream continues.
+type FIFO struct {
+	path string
+	// writing serializes writes
+	writing sync.Mutex
+	// mu guards file, which is nil while there is no reader
+	mu     sync.Mutex
+	file   *os.File
+	closed chan struct{}
+	once   sync.Once
+}
+
+// OpenFIFO returns a sink writing to the named pipe at path
+func OpenFIFO(path string) (*FIFO, error) {
+	if !IsFIFO(path) {
+		return nil, fmt.Errorf("%s is not a named pipe", path)
+	}
+	return &FIFO{path: path, closed: make(chan struct{})}, nil
+}
+
+// open waits for a reader and opens the pipe. The open is non-blocking, so
+// it fails with ENXIO until a reader is there and is retried until then.
+func (f *FIFO) open() (*os.File, error) {
+	waiting := false
+	for {
+		file, err := os.OpenFile(f.path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if err == nil {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if f.isClosed() {
+				file.Close()
+				return nil, ErrClosed
+			}
+			f.file = file
+			logger.Info("Reader attached to %s", f.path)
+			return file, nil
+		}
+		if !errors.Is(err, syscall.ENXIO) {
+			return nil, fmt.Errorf("error opening %s: %w", f.path, err)
+		}
+
+		if !waiting {
+			logger.Info("Waiting for a reader on %s", f.path)
+			waiting = true
+		}
+		select {
+		case <-f.closed:
+			return nil, ErrClosed
+		case <-time.After(fifoPollInterval):
+		}
+	}
+}
+
+// detach closes the pipe after its reader went away
+func (f *FIFO) detach(file *os.File) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file.Close()
+	if f.file == file {
+		f.file = nil
+	}
+}
+
+func (f *FIFO) isClosed() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Write writes p to the pipe, waiting for a reader if there is none. If the
+// reader goes away in the middle of p, all of p is written again to the next
+// reader, so readers only ever see whole writes.
+func (f *FIFO) Write(p []byte) (int, error) {
+	f.writing.Lock()
+	defer f.writing.Unlock()
+
+	for {
+		if f.isClosed() {
+			return 0, ErrClosed
+		}
+
+		f.mu.Lock()
+		file := f.file
+		f.mu.Unlock()
+		if file == nil {
+			var err error
+			if file, err = f.open(); err != nil {
+				return 0, err
+			}
+		}
+
+		_, err := file.Write(p)
+		if err == nil {
+			return len(p), nil
+		}
+		if f.isClosed() {
+			return 0, ErrClosed
+		}
+		if !errors.Is(err, syscall.EPIPE) {
+			return 0, fmt.Errorf("error writing to %s: %w", f.path, err)
+		}
+		logger.Info("Reader detached from %s", f.path)
+		f.detach(file)
+	}
+}
+
+// Close stops waiting for readers and closes the pipe, interrupting a write
+// that waits for the reader to catch up
+func (f *FIFO) Close() error {
+	f.once.Do(func() { close(f.closed) })
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,21 @@
+package sink
+
+import "errors"
+
+// FIFO writes to a named pipe, which is not supported on Windows
+type FIFO struct{}
+
+// OpenFIFO always fails on Windows
+func OpenFIFO(path string) (*FIFO, error) {
+	return nil, errors.New("named pipes are not supported on Windows")
+}
+
+// Write always fails on Windows
+func (f *FIFO) Write(p []byte) (int, error) {
+	return 0, ErrClosed
+}
+
+// Close does nothing on Windows
+func (f *FIFO) Close() error {
+	return nil
+}
@@ -0,0 +1,194 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+)
+
+// Roll intervals
+const (
+	RollHourly = "hourly"
+	RollDaily  = "daily"
+)
+
+// Roller writes to a file named by a strftime-style pattern and rolls over
+// to the next file at every hour or day, fsyncing and closing the previous
+// one so log shippers can pick it up as complete. Files are appended to, so
+// a restarted client continues the current file.
+type Roller struct {
+	mu       sync.Mutex
+	pattern  string
+	interval string
+	file     *os.File
+	// until is when the current file is complete
+	until  time.Time
+	timer  *time.Timer
+	closed bool
+	now    func() time.Time
+}
+
+// NewRoller validates a roll interval and a pattern such as
+// app-%Y%m%d%H.log. The first file is opened by the first write.
+func NewRoller(pattern, interval string) (*Roller, error) {
+	if interval != RollHourly && interval != RollDaily {
+		return nil, fmt.Errorf("invalid roll interval %q (expected hourly or daily)", interval)
+	}
+	if !strings.Contains(pattern, "%") {
+		return nil, fmt.Errorf("output %q needs strftime directives such as %%Y%%m%%d to roll", pattern)
+	}
+	if _, err := Strftime(pattern, time.Now()); err != nil {
+		return nil, err
+	}
+	return &Roller{pattern: pattern, interval: interval, now: time.Now}, nil
+}
+
+// Write writes to the file of the current interval, rolling over first if
+// the previous interval ended
+func (r *Roller) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, ErrClosed
+	}
+	now := r.now()
+	if r.file == nil || !now.Before(r.until) {
+		if err := r.roll(now); err != nil {
+			return 0, err
+		}
+	}
+	return r.file.Write(p)
+}
+
+// Close fsyncs and closes the current file
+func (r *Roller) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return r.finish()
+}
+
+// roll completes the current file and opens the one of the interval now
+// falls in
+func (r *Roller) roll(now time.Time) error {
+	if err := r.finish(); err != nil {
+		logger.Error("Failed to complete output file: %v", err)
+	}
+
+	start := r.start(now)
+	path, err := Strftime(r.pattern, start)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating output directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	logger.Info("Writing output to %s", path)
+	r.file = file
+	r.until = r.next(start)
+
+	// Complete the file on time even if no line arrives after the interval
+	r.timer = time.AfterFunc(r.until.Sub(now), r.expire)
+	return nil
+}
+
+// expire completes the current file once its interval ended
+func (r *Roller) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file != nil && !r.now().Before(r.until) {
+		if err := r.finish(); err != nil {
+			logger.Error("Failed to complete output file: %v", err)
+		}
+	}
+}
+
+// finish fsyncs and closes the current file, if one is open
+func (r *Roller) finish() error {
+	if r.file == nil {
+		return nil
+	}
+	file := r.file
+	r.file = nil
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	syncErr := file.Sync()
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if syncErr != nil {
+		return syncErr
+	}
+	logger.Info("Completed output file %s", file.Name())
+	return nil
+}
+
+// start returns the beginning of the interval t falls in
+func (r *Roller) start(t time.Time) time.Time {
+	if r.interval == RollHourly {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// next returns the beginning of the interval after the one starting at start
+func (r *Roller) next(start time.Time) time.Time {
+	if r.interval == RollHourly {
+		return start.Add(time.Hour)
+	}
+	return time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, start.Location())
+}
+
+// Strftime formats t with a strftime-style pattern. It supports %Y, %y, %m,
+// %d, %H, %M, %S, %j (day of the year), %b, %a, %Z and %% for a literal %.
+func Strftime(pattern string, t time.Time) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		if i++; i == len(pattern) {
+			return "", fmt.Errorf("pattern %q ends in %%", pattern)
+		}
+		switch pattern[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'b':
+			b.WriteString(t.Format("Jan"))
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			return "", fmt.Errorf("unsupported directive %%%c in %q", pattern[i], pattern)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,18 @@
+// Package sink provides the destinations a client writes received lines to
+// besides regular files and stdout: subprocesses, named pipes, files whose
+// path is rendered from a template and files rolled hourly or daily.
+package sink
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrClosed is returned for writes to a closed sink
+var ErrClosed = errors.New("sink is closed")
+
+// IsFIFO reports whether path is a named pipe
+func IsFIFO(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
@@ -0,0 +1,466 @@
+package sink
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRestart(t *testing.T) {
+	if policy, err := ParseRestart(""); err != nil || policy != RestartNever {
+		t.Errorf("Expected the default policy to be never, got %q, %v", policy, err)
+	}
+	if _, err := ParseRestart("sometimes"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+
+	dir, err := os.MkdirTemp("", "sink-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("Pipe", func(t *testing.T) {
+		path := filepath.Join(dir, "out.txt")
+		e, err := StartExec("sh -c 'cat > "+path+"'", ExecOptions{})
+		if err != nil {
+			t.Fatalf("StartExec returned error: %v", err)
+		}
+		for _, line := range []string{"one\n", "two\n"} {
+			if _, err := e.Write([]byte(line)); err != nil {
+				t.Fatalf("Write returned error: %v", err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != "one\ntwo\n" {
+			t.Errorf("Expected the subprocess to receive every line, got %q, %v", data, err)
+		}
+		if _, err := e.Write([]byte("three\n")); !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed after Close, got %v", err)
+		}
+	})
+
+	t.Run("ExitStatus", func(t *testing.T) {
+		e, err := StartExec("sh -c 'exit 3'", ExecOptions{})
+		if err != nil {
+			t.Fatalf("StartExec returned error: %v", err)
+		}
+		var exitErr *exec.ExitError
+		if err := e.Close(); !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+			t.Errorf("Expected exit status 3, got %v", err)
+		}
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		e, err := StartExec("sh -c 'exit 1'", ExecOptions{Restart: RestartOnFailure, MaxRestarts: 2})
+		if err != nil {
+			t.Fatalf("StartExec returned error: %v", err)
+		}
+		defer e.Close()
+
+		// Writes only fail once the subprocess has exited
+		deadline := time.Now().Add(5 * time.Second)
+		for err == nil && time.Now().Before(deadline) {
+			_, err = e.Write([]byte("line\n"))
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err == nil {
+			t.Fatal("Expected writes to fail once the restarts are used up")
+		}
+		if e.restarts != 2 {
+			t.Errorf("Expected 2 restarts, got %d", e.restarts)
+		}
+	})
+}
+
+func TestFIFO(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("named pipes are not supported on Windows")
+	}
+
+	dir, err := os.MkdirTemp("", "sink-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "stream")
+	if err := exec.Command("mkfifo", path).Run(); err != nil {
+		t.Skipf("mkfifo is not available: %v", err)
+	}
+	if _, err := OpenFIFO(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a path that is not a named pipe")
+	}
+
+	f, err := OpenFIFO(path)
+	if err != nil {
+		t.Fatalf("OpenFIFO returned error: %v", err)
+	}
+
+	// Every reader receives the lines written while it is attached
+	for _, line := range []string{"first\n", "second\n"} {
+		written := make(chan error, 1)
+		go func() {
+			_, err := f.Write([]byte(line))
+			written <- err
+		}()
+
+		reader, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open the pipe for reading: %v", err)
+		}
+		buf := make([]byte, len(line))
+		if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != line {
+			t.Errorf("Expected to read %q, got %q, %v", line, buf, err)
+		}
+		if err := <-written; err != nil {
+			t.Errorf("Write returned error: %v", err)
+		}
+		reader.Close()
+	}
+
+	// Close interrupts a write waiting for a reader
+	written := make(chan error, 1)
+	go func() {
+		_, err := f.Write([]byte("third\n"))
+		written <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	f.Close()
+	select {
+	case err := <-written:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Close to interrupt the write")
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	start := time.Date(2024, 6, 1, 23, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	fields := Fields{Host: "logs.example.com", Topic: "app.log", Name: "edge-1", Time: start}
+
+	templates := map[string]string{
+		"{{.Host}}/{{.Topic}}/{{.Date}}.log":         "logs.example.com/app.log/2024-06-01.log",
+		"out/{{.Name}}-{{date \"15h04\" .Time}}.log": "out/edge-1-23h30.log",
+		"{{date \"2006/01/02\" (utc .Time)}}/x.log":  "2024/06/01/x.log",
+		"{{sanitize \"a b/c\"}}.log":                 "a_b_c.log",
+		" /var/log/{{.Topic}} ":                      "/var/log/app.log",
+	}
+	for text, want := range templates {
+		tmpl, err := ParseTemplate(text)
+		if err != nil {
+			t.Errorf("ParseTemplate(%q) returned error: %v", text, err)
+			continue
+		}
+		got, err := tmpl.Path(fields)
+		if err != nil {
+			t.Errorf("Path returned error for %q: %v", text, err)
+			continue
+		}
+		if got != filepath.FromSlash(want) {
+			t.Errorf("Expected %s for %q, got %s", want, text, got)
+		}
+	}
+
+	// Fields cannot add directories or climb out of the template's
+	tmpl, err := ParseTemplate("out/{{.Host}}/{{.Topic}}.log")
+	if err != nil {
+		t.Fatalf("ParseTemplate returned error: %v", err)
+	}
+	got, err := tmpl.Path(Fields{Host: "..", Topic: "../../etc/passwd", Time: start})
+	if err != nil {
+		t.Fatalf("Path returned error: %v", err)
+	}
+	if want := filepath.Join("out", "_", "_.._etc_passwd.log"); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	for _, text := range []string{"{{.Missing}}.log", "{{nope}}.log", "{{.Host", "{{if false}}x{{end}}"} {
+		if _, err := ParseTemplate(text); err == nil {
+			t.Errorf("Expected an error for %q", text)
+		}
+	}
+
+	t.Run("Open", func(t *testing.T) {
+		dir := t.TempDir()
+		tmpl, err := ParseTemplate(filepath.ToSlash(dir) + "/{{.Host}}/{{.Date}}.log")
+		if err != nil {
+			t.Fatalf("ParseTemplate returned error: %v", err)
+		}
+		for _, line := range []string{"first\n", "second\n"} {
+			f, err := tmpl.Open(fields)
+			if err != nil {
+				t.Fatalf("Open returned error: %v", err)
+			}
+			f.WriteString(line)
+			f.Close()
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "logs.example.com", "2024-06-01.log"))
+		if err != nil || string(data) != "first\nsecond\n" {
+			t.Errorf("Expected both streams appended to the file, got %q, %v", data, err)
+		}
+	})
+}
+
+func TestSanitize(t *testing.T) {
+	for name, want := range map[string]string{
+		"app.log":          "app.log",
+		"host:8080":        "host_8080",
+		"..":               "_",
+		".hidden":          "hidden",
+		"":                 "_",
+		"logs/ünïcode.txt": "logs__n_code.txt",
+	} {
+		if got := Sanitize(name); got != want {
+			t.Errorf("Expected %q for %q, got %q", want, name, got)
+		}
+	}
+}
+
+func TestStrftime(t *testing.T) {
+	at := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	got, err := Strftime("logs/%Y/%y%m%d-%H%M%S-%j-%b-%a-%Z-100%%.log", at)
+	if err != nil {
+		t.Fatalf("Strftime returned error: %v", err)
+	}
+	if want := "logs/2024/240203-040506-034-Feb-Sat-UTC-100%.log"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	for _, pattern := range []string{"app-%Q.log", "app-%"} {
+		if _, err := Strftime(pattern, at); err == nil {
+			t.Errorf("Expected an error for %q", pattern)
+		}
+	}
+}
+
+func TestRoller(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewRoller(filepath.Join(dir, "app.log"), RollHourly); err == nil {
+		t.Error("Expected an error for a pattern without directives")
+	}
+	if _, err := NewRoller(filepath.Join(dir, "app-%Y.log"), "weekly"); err == nil {
+		t.Error("Expected an error for an unknown interval")
+	}
+
+	for _, tc := range []struct {
+		interval string
+		pattern  string
+		times    []time.Time
+		want     map[string]string
+	}{
+		{
+			interval: RollHourly,
+			pattern:  "hourly/app-%Y%m%d%H.log",
+			times: []time.Time{
+				time.Date(2024, 6, 1, 10, 15, 0, 0, time.UTC),
+				time.Date(2024, 6, 1, 10, 59, 59, 0, time.UTC),
+				time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC),
+				time.Date(2024, 6, 1, 13, 30, 0, 0, time.UTC),
+			},
+			want: map[string]string{
+				"hourly/app-2024060110.log": "0\n1\n",
+				"hourly/app-2024060111.log": "2\n",
+				"hourly/app-2024060113.log": "3\n",
+			},
+		},
+		{
+			interval: RollDaily,
+			pattern:  "daily/%Y-%m-%d.log",
+			times: []time.Time{
+				time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 6, 1, 23, 59, 0, 0, time.UTC),
+				time.Date(2024, 6, 2, 0, 1, 0, 0, time.UTC),
+			},
+			want: map[string]string{
+				"daily/2024-06-01.log": "0\n1\n",
+				"daily/2024-06-02.log": "2\n",
+			},
+		},
+	} {
+		t.Run(tc.interval, func(t *testing.T) {
+			r, err := NewRoller(filepath.Join(dir, tc.pattern), tc.interval)
+			if err != nil {
+				t.Fatalf("NewRoller returned error: %v", err)
+			}
+			var previous *os.File
+			for i, at := range tc.times {
+				r.now = func() time.Time { return at }
+				if _, err := r.Write([]byte(strconv.Itoa(i) + "\n")); err != nil {
+					t.Fatalf("Write returned error: %v", err)
+				}
+				// The completed file was closed
+				if previous != nil && previous != r.file {
+					if _, err := previous.Write([]byte("x")); err == nil {
+						t.Error("Expected the previous file to be closed")
+					}
+				}
+				previous = r.file
+			}
+			if err := r.Close(); err != nil {
+				t.Fatalf("Close returned error: %v", err)
+			}
+			if _, err := r.Write([]byte("late\n")); !errors.Is(err, ErrClosed) {
+				t.Errorf("Expected ErrClosed after Close, got %v", err)
+			}
+			for name, want := range tc.want {
+				data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if err != nil || string(data) != want {
+					t.Errorf("Expected %s to hold %q, got %q, %v", name, want, data, err)
+				}
+			}
+		})
+	}
+
+	t.Run("Expire", func(t *testing.T) {
+		r, err := NewRoller(filepath.Join(dir, "expire-%Y%m%d%H.log"), RollHourly)
+		if err != nil {
+			t.Fatalf("NewRoller returned error: %v", err)
+		}
+		defer r.Close()
+		// The interval ends 100ms after the write
+		end := time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC)
+		var mu sync.Mutex
+		now := end.Add(-100 * time.Millisecond)
+		r.now = func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		}
+		if _, err := r.Write([]byte("line\n")); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+		mu.Lock()
+		now = end
+		mu.Unlock()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			r.mu.Lock()
+			closed := r.file == nil
+			r.mu.Unlock()
+			if closed {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the file to be completed once its interval ended without writes")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+}
+
+// recorder is a writer recording every write, safe to read while written to
+type recorder struct {
+	mu     sync.Mutex
+	data   []byte
+	writes int
+	err    error
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return 0, r.err
+	}
+	r.data = append(r.data, p...)
+	r.writes++
+	return len(p), nil
+}
+
+func (r *recorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return string(r.data)
+}
+
+func TestThrottle(t *testing.T) {
+	t.Run("Rate", func(t *testing.T) {
+		out := &recorder{}
+		th := NewThrottle(out, 10000, 1000)
+		defer th.Close()
+
+		// 3000 bytes at 10000 bytes/s with a 1000 byte buffer make the
+		// writer wait for at least 2000 bytes to be written
+		line := strings.Repeat("x", 99) + "\n"
+		start := time.Now()
+		for range 30 {
+			if _, err := th.Write([]byte(line)); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+			t.Errorf("Expected writes to wait for the buffer, took %v", elapsed)
+		}
+		if err := th.Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+			t.Errorf("Expected 3000 bytes to take about 300ms at 10000 bytes/s, took %v", elapsed)
+		}
+		if got := out.String(); got != strings.Repeat(line, 30) {
+			t.Errorf("Expected every line in order, got %d bytes", len(got))
+		}
+		out.mu.Lock()
+		writes := out.writes
+		out.mu.Unlock()
+		if writes < 6 {
+			t.Errorf("Expected the output to be written in slices of 500 bytes, got %d writes", writes)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		out := &recorder{}
+		th := NewThrottle(out, 100, 1<<20)
+		data := strings.Repeat("y", 10000)
+		if _, err := th.Write([]byte(data)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+
+		// Closing writes the rest right away
+		if err := th.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		if got := out.String(); got != data {
+			t.Errorf("Expected everything written on close, got %d bytes", len(got))
+		}
+		if _, err := th.Write([]byte("z")); !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed after Close, got %v", err)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		failure := errors.New("disk full")
+		out := &recorder{err: failure}
+		th := NewThrottle(out, 100000, 10)
+		defer th.Close()
+
+		th.Write([]byte("first\n"))
+		if err := th.Flush(); !errors.Is(err, failure) {
+			t.Errorf("Expected Flush to return the write error, got %v", err)
+		}
+		if _, err := th.Write([]byte("second\n")); !errors.Is(err, failure) {
+			t.Errorf("Expected later writes to fail, got %v", err)
+		}
+	})
+}
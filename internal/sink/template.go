@@ -0,0 +1,107 @@
+package sink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Fields describe a received stream to an output template
+type Fields struct {
+	// Host is the server's host name
+	Host string
+	// Topic names the stream: the pushed file in daemon mode, the data
+	// channel label otherwise
+	Topic string
+	// Name is the client's name in daemon mode
+	Name string
+	// Time is when the stream started
+	Time time.Time
+}
+
+// templateData is what a template is executed with: the sanitized fields
+// and the start date
+type templateData struct {
+	Fields
+	Date string
+}
+
+// templateFuncs are the functions available in output templates
+var templateFuncs = template.FuncMap{
+	"now":      time.Now,
+	"date":     func(layout string, t time.Time) string { return t.Format(layout) },
+	"utc":      func(t time.Time) time.Time { return t.UTC() },
+	"sanitize": Sanitize,
+}
+
+// Template renders the path a stream is written to, such as
+// {{.Host}}/{{.Topic}}/{{.Date}}.log
+type Template struct {
+	tmpl *template.Template
+}
+
+// ParseTemplate parses an output template and renders it once, so unknown
+// fields and functions are reported before any stream arrives
+func ParseTemplate(text string) (*Template, error) {
+	tmpl, err := template.New("output").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template: %w", err)
+	}
+	t := &Template{tmpl: tmpl}
+	if _, err := t.Path(Fields{Host: "host", Topic: "topic", Name: "name", Time: time.Now()}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Path renders the template for a stream. Host, Topic and Name are
+// sanitized first, so they cannot add directories or climb out of the
+// template's own.
+func (t *Template) Path(f Fields) (string, error) {
+	f.Host, f.Topic, f.Name = Sanitize(f.Host), Sanitize(f.Topic), Sanitize(f.Name)
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, templateData{Fields: f, Date: f.Time.Format("2006-01-02")}); err != nil {
+		return "", fmt.Errorf("invalid output template: %w", err)
+	}
+	path := strings.TrimSpace(buf.String())
+	if path == "" {
+		return "", errors.New("output template rendered an empty path")
+	}
+	return filepath.Clean(filepath.FromSlash(path)), nil
+}
+
+// Open renders the path for a stream, creates its directory and opens the
+// file for appending, so streams that map to the same file accumulate in it
+func (t *Template) Open(f Fields) (*os.File, error) {
+	path, err := t.Path(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating output directory: %w", err)
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// Sanitize turns a name into a single path element: every character but
+// ASCII letters, digits, '-', '_' and '.' becomes '_', and leading dots are
+// dropped so the result is neither hidden nor ".."
+func Sanitize(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+	safe = strings.TrimLeft(safe, ".")
+	if safe == "" {
+		return "_"
+	}
+	return safe
+}
@@ -0,0 +1,140 @@
+package sink
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+)
+
+// throttleTick is how often a throttle passes buffered bytes on, in slices
+// of a tick's worth of its rate
+const throttleTick = 50 * time.Millisecond
+
+// Throttle passes writes on to another writer at a limited rate, in small
+// evenly spaced writes. Bytes written faster than that are buffered up to a
+// limit, beyond which Write blocks until the writer caught up, so a slow
+// device throttles the sender instead of the buffer growing without bound.
+type Throttle struct {
+	w     io.Writer
+	rate  int64
+	limit int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     []byte
+	writing bool
+	err     error
+	closed  bool
+	warned  bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewThrottle writes to w at rate bytes per second, buffering at most limit
+// bytes. Closing the throttle does not close w.
+func NewThrottle(w io.Writer, rate int64, limit int) *Throttle {
+	t := &Throttle{w: w, rate: rate, limit: limit, stop: make(chan struct{}), done: make(chan struct{})}
+	t.cond = sync.NewCond(&t.mu)
+	go t.run()
+	return t
+}
+
+// Write buffers p, waiting while the buffer is too full to take it. It
+// returns the error of an earlier write to the underlying writer, if any.
+func (t *Throttle) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for len(t.buf) > 0 && len(t.buf)+len(p) > t.limit && t.err == nil && !t.closed {
+		if !t.warned {
+			t.warned = true
+			logger.Info("Warning: the output buffer is full, receiving at most %d bytes/s until the output catches up", t.rate)
+		}
+		t.cond.Wait()
+	}
+	if t.closed {
+		return 0, ErrClosed
+	}
+	if t.err != nil {
+		return 0, t.err
+	}
+	t.buf = append(t.buf, p...)
+	return len(p), nil
+}
+
+// Flush waits until every buffered byte was passed on at the throttled rate
+func (t *Throttle) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for (len(t.buf) > 0 || t.writing) && t.err == nil && !t.closed {
+		t.cond.Wait()
+	}
+	return t.err
+}
+
+// Close writes what is still buffered right away, without throttling, and
+// stops the throttle
+func (t *Throttle) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.cond.Broadcast()
+	t.mu.Unlock()
+
+	close(t.stop)
+	<-t.done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.buf) > 0 && t.err == nil {
+		_, t.err = t.w.Write(t.buf)
+		t.buf = nil
+	}
+	return t.err
+}
+
+// run passes a tick's worth of buffered bytes on at every tick. Credit for
+// ticks with nothing to write is not kept, so writes after an idle period
+// are not bursty either.
+func (t *Throttle) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(throttleTick)
+	defer ticker.Stop()
+
+	share := max(t.rate*int64(throttleTick)/int64(time.Second), 1)
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+
+		t.mu.Lock()
+		n := min(int64(len(t.buf)), share)
+		if n == 0 || t.err != nil {
+			t.mu.Unlock()
+			continue
+		}
+		chunk := append([]byte(nil), t.buf[:n]...)
+		t.buf = t.buf[n:]
+		t.writing = true
+		t.cond.Broadcast()
+		t.mu.Unlock()
+
+		_, err := t.w.Write(chunk)
+
+		t.mu.Lock()
+		t.writing = false
+		if err != nil {
+			t.err = err
+		}
+		t.cond.Broadcast()
+		t.mu.Unlock()
+	}
+}
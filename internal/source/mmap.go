@@ -0,0 +1,117 @@
+//go:build linux || darwin
+
+package source
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime/debug"
+
+	"golang.org/x/sys/unix"
+)
+
+// mappedFile reads a file mapped into memory, asking the kernel to page in
+// the next readAhead bytes as the reader advances
+type mappedFile struct {
+	data      []byte
+	pos       int64
+	readAhead int64
+	// advised is the offset up to which read-ahead was requested
+	advised int64
+}
+
+// mapFile maps size bytes of f read-only
+func mapFile(f *os.File, size, readAhead int64) (File, error) {
+	if int64(int(size)) != size {
+		return nil, errors.New("file is too large to map on this platform")
+	}
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	unix.Madvise(data, unix.MADV_SEQUENTIAL)
+	return &mappedFile{data: data, readAhead: readAhead}, nil
+}
+
+func (m *mappedFile) Read(p []byte) (int, error) {
+	n, err := m.ReadAt(p, m.pos)
+	m.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (m *mappedFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if m.data == nil {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	m.advise(off + int64(len(p)))
+
+	// Pages of a file truncated since it was mapped raise SIGBUS, which
+	// would kill the process; it only fails this read instead
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			n, err = 0, ErrTruncated
+		}
+	}()
+	n = copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// advise requests read-ahead once the reader got within half the window of
+// the offset advised before
+func (m *mappedFile) advise(end int64) {
+	if end+m.readAhead/2 < m.advised {
+		return
+	}
+	from := min(end, int64(len(m.data)))
+	to := min(from+m.readAhead, int64(len(m.data)))
+	// Madvise needs a page aligned start
+	from -= from % int64(os.Getpagesize())
+	if from < to {
+		unix.Madvise(m.data[from:to], unix.MADV_WILLNEED)
+	}
+	m.advised = to
+}
+
+func (m *mappedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += m.pos
+	case io.SeekEnd:
+		offset += int64(len(m.data))
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	m.pos = offset
+	return offset, nil
+}
+
+func (m *mappedFile) Size() int64 {
+	return int64(len(m.data))
+}
+
+func (m *mappedFile) Close() error {
+	if m.data == nil {
+		return os.ErrClosed
+	}
+	err := unix.Munmap(m.data)
+	m.data = nil
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package source
+
+import "os"
+
+// mapFile falls back to regular reads where mapping is not supported
+func mapFile(f *os.File, size, readAhead int64) (File, error) {
+	reopened, err := os.Open(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &osFile{File: reopened, size: size}, nil
+}
@@ -0,0 +1,126 @@
+// Package source opens the files the server streams, either with regular
+// reads or memory mapped. Mapping avoids a read system call per buffer on
+// large files and makes random access as cheap as sequential reads.
+package source
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultReadAhead is how far ahead of the reader a mapped file is paged in
+const DefaultReadAhead = 8 << 20
+
+// ErrTruncated is returned by reads of a mapped file that was truncated
+// since it was opened
+var ErrTruncated = errors.New("file was truncated while it was mapped")
+
+// File is an open source file
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	// Size returns the size of the file when it was opened
+	Size() int64
+}
+
+// Options controls how files are opened
+type Options struct {
+	// MMap maps regular files into memory instead of reading them
+	MMap bool
+	// ReadAhead is how many bytes ahead of the reader a mapped file is
+	// paged in; zero uses DefaultReadAhead
+	ReadAhead int64
+}
+
+// Open opens a file for streaming. Files that cannot be mapped, such as
+// empty files, pipes and devices, are always read normally.
+func Open(path string, opts Options) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if !opts.MMap || !info.Mode().IsRegular() || info.Size() == 0 {
+		return &osFile{File: f, size: info.Size()}, nil
+	}
+
+	readAhead := opts.ReadAhead
+	if readAhead <= 0 {
+		readAhead = DefaultReadAhead
+	}
+	mapped, err := mapFile(f, info.Size(), readAhead)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error mapping %s: %w", path, err)
+	}
+	return mapped, nil
+}
+
+// osFile reads a file with regular system calls
+type osFile struct {
+	*os.File
+	size int64
+}
+
+func (f *osFile) Size() int64 {
+	return f.size
+}
+
+// Follow returns a reader that waits for more data at the end of r instead
+// of ending, checking every poll, until more reports there is no reason to
+// wait any longer. Lines appended to a file are read as they are written;
+// partial lines are only returned once completed if read with a scanner.
+func Follow(r io.Reader, poll time.Duration, more func() bool) io.Reader {
+	return &follower{r: r, poll: poll, more: more}
+}
+
+// follower is the reader returned by Follow
+type follower struct {
+	r    io.Reader
+	poll time.Duration
+	more func() bool
+}
+
+func (f *follower) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		if n > 0 || err != io.EOF || !f.more() {
+			return n, err
+		}
+		time.Sleep(f.poll)
+	}
+}
+
+// ParseSize parses a byte count with an optional KiB, MiB or GiB suffix
+func ParseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}, {"B", 1}}
+
+	value := strings.TrimSpace(s)
+	scale := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(value, u.suffix) {
+			value, scale = strings.TrimSpace(strings.TrimSuffix(value, u.suffix)), u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected bytes or a KiB, MiB or GiB suffix)", s)
+	}
+	return n * scale, nil
+}
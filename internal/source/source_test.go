@@ -0,0 +1,163 @@
+package source
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpen(t *testing.T) {
+	dir, err := os.MkdirTemp("", "source-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := strings.Repeat("0123456789abcdef\n", 4096)
+	path := filepath.Join(dir, "data.txt")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	for _, opts := range []Options{{}, {MMap: true}, {MMap: true, ReadAhead: 4096}} {
+		f, err := Open(path, opts)
+		if err != nil {
+			t.Fatalf("Open(%+v) returned error: %v", opts, err)
+		}
+
+		if f.Size() != int64(len(content)) {
+			t.Errorf("Expected size %d, got %d", len(content), f.Size())
+		}
+
+		// Read line by line like the server does
+		scanner := bufio.NewScanner(f)
+		lines := 0
+		for scanner.Scan() {
+			lines++
+		}
+		if err := scanner.Err(); err != nil || lines != 4096 {
+			t.Errorf("Expected 4096 lines with %+v, got %d, %v", opts, lines, err)
+		}
+
+		// Random access and rewinding
+		buf := make([]byte, 5)
+		if _, err := f.ReadAt(buf, 17*100+10); err != nil || string(buf) != "abcde" {
+			t.Errorf("Expected ReadAt to return abcde, got %q, %v", buf, err)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("Seek returned error: %v", err)
+		}
+		if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "01234" {
+			t.Errorf("Expected to read from the start after Seek, got %q, %v", buf, err)
+		}
+		if _, err := f.ReadAt(buf, f.Size()); err != io.EOF {
+			t.Errorf("Expected io.EOF at the end, got %v", err)
+		}
+
+		if err := f.Close(); err != nil {
+			t.Errorf("Close returned error: %v", err)
+		}
+	}
+
+	t.Run("Empty", func(t *testing.T) {
+		empty := filepath.Join(dir, "empty.txt")
+		os.WriteFile(empty, nil, 0644)
+		f, err := Open(empty, Options{MMap: true})
+		if err != nil {
+			t.Fatalf("Expected empty files to open, got %v", err)
+		}
+		defer f.Close()
+		if n, err := f.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("Expected io.EOF, got %d, %v", n, err)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := Open(filepath.Join(dir, "missing.txt"), Options{MMap: true}); !os.IsNotExist(err) {
+			t.Errorf("Expected a not exist error, got %v", err)
+		}
+	})
+}
+
+func TestTruncatedWhileMapped(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("files are only mapped on Linux and macOS")
+	}
+	path := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(path, []byte(strings.Repeat("0123456789abcdef\n", 4096)), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	f, err := Open(path, Options{MMap: true})
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer f.Close()
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("Failed to truncate file: %v", err)
+	}
+	// The read fails instead of crashing the process
+	if _, err := f.ReadAt(make([]byte, 64), 8192); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	valid := map[string]int64{"0": 0, "4096": 4096, "512B": 512, "64KiB": 64 << 10, "8MiB": 8 << 20, "2 GiB": 2 << 30}
+	for s, want := range valid {
+		if got, err := ParseSize(s); err != nil || got != want {
+			t.Errorf("Expected %d for %q, got %d, %v", want, s, got, err)
+		}
+	}
+	for _, s := range []string{"", "MiB", "-1", "8MB", "1.5GiB"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
+
+func TestFollow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(path, Options{})
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer f.Close()
+
+	// Append a line once the first one was read, then stop following
+	var done bool
+	polls := 0
+	scanner := bufio.NewScanner(Follow(f, time.Millisecond, func() bool {
+		polls++
+		if polls == 3 {
+			out, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			out.WriteString("two\n")
+			out.Close()
+		}
+		return !done
+	}))
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		done = len(lines) == 2
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Scan returned error: %v", err)
+	}
+	if strings.Join(lines, ",") != "one,two" {
+		t.Errorf("Expected the appended line to be read, got %v", lines)
+	}
+}
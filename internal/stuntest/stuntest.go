@@ -0,0 +1,44 @@
+// Package stuntest runs a STUN server on localhost for tests, answering
+// every binding request with the address it came from, like a server in
+// front of a network without NAT.
+package stuntest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pion/stun"
+)
+
+// Start starts a STUN server on localhost, closed when the test ends, and
+// returns its address
+func Start(t testing.TB) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+			if err := request.Decode(); err != nil {
+				continue
+			}
+			addr := from.(*net.UDPAddr)
+			response, err := stun.Build(stun.NewTransactionIDSetter(request.TransactionID), stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: addr.IP, Port: addr.Port})
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(response.Raw, from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
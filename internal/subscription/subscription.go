@@ -0,0 +1,170 @@
+package subscription
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+
+	"github.com/developmeh/webrtc-poc/internal/seal"
+)
+
+// label is the purpose the state is sealed for
+const label = "subscription"
+
+// State is what a daemon mode client needs to resubscribe with the same
+// parameters after a restart
+type State struct {
+	Server string `json:"server"`
+	Name   string `json:"name"`
+	Filter string `json:"filter,omitempty"`
+	// Push is the transfer in progress, if any
+	Push *Progress `json:"push,omitempty"`
+}
+
+// Progress records how far a push has been received
+type Progress struct {
+	ID     string `json:"id"`
+	File   string `json:"file"`
+	Offset int    `json:"offset"`
+	// Digest is the saved state of the SHA-256 of the lines received so
+	// far, which the server checks a resumed push against
+	Digest []byte `json:"digest,omitempty"`
+
+	// prefix hashes the lines received so far, nil until first needed
+	prefix hash.Hash
+	// unknown is set when the lines received so far cannot be hashed any
+	// more, as for progress saved without a digest
+	unknown bool
+}
+
+// Add records a received line, without its newline
+func (p *Progress) Add(line string) {
+	if h := p.hash(); h != nil {
+		h.Write([]byte(line))
+		h.Write([]byte{'\n'})
+	}
+	p.Offset++
+}
+
+// Sum returns the hex encoded SHA-256 of the lines received so far, or an
+// empty string if it is not known
+func (p *Progress) Sum() string {
+	h := p.hash()
+	if h == nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Restart forgets the lines received so far, to receive the push again from
+// its first line
+func (p *Progress) Restart() {
+	p.Offset, p.Digest, p.prefix, p.unknown = 0, nil, nil, false
+}
+
+// hash returns the hash of the lines received so far, restoring it from the
+// saved digest, or nil if it is not known
+func (p *Progress) hash() hash.Hash {
+	if p.prefix != nil || p.unknown {
+		return p.prefix
+	}
+	h := sha256.New()
+	if p.Offset > 0 {
+		if len(p.Digest) == 0 || h.(encoding.BinaryUnmarshaler).UnmarshalBinary(p.Digest) != nil {
+			p.unknown = true
+			return nil
+		}
+	}
+	p.prefix = h
+	return h
+}
+
+// snapshot saves the state of the hash into Digest
+func (p *Progress) snapshot() {
+	if p.prefix == nil {
+		return
+	}
+	if digest, err := p.prefix.(encoding.BinaryMarshaler).MarshalBinary(); err == nil {
+		p.Digest = digest
+	}
+}
+
+// DefaultPath returns the default location of the subscription state file
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding config directory: %w", err)
+	}
+	return filepath.Join(dir, "webrtc-poc", "subscription.json"), nil
+}
+
+// Load reads the state saved at path, opening it with key if it was sealed,
+// and returns an empty state if there is none yet. A state saved in the
+// clear is read with a key too, to be sealed when it is saved next.
+func Load(path string, key *seal.Key) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading subscription state: %w", err)
+	}
+	if seal.Sealed(data) {
+		if key == nil {
+			return nil, fmt.Errorf("subscription state %s is encrypted, enable encrypt_state to read it", path)
+		}
+		if data, err = key.Open(label, data); err != nil {
+			return nil, fmt.Errorf("error reading subscription state %s: %w", path, err)
+		}
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing subscription state %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// Save writes the state to path, sealed with key unless it is nil, replacing
+// the previous state atomically so a crash never leaves a truncated file
+// behind
+func (s *State) Save(path string, key *seal.Key) error {
+	if s.Push != nil {
+		s.Push.snapshot()
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding subscription state: %w", err)
+	}
+	if key != nil {
+		data = key.Seal(label, data)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating state directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("error writing subscription state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error writing subscription state: %w", err)
+	}
+	return nil
+}
+
+// Subscribe updates the subscription parameters, forgetting any push in
+// progress if they changed
+func (s *State) Subscribe(server, name, filter string) {
+	if s.Server != server || s.Name != name || s.Filter != filter {
+		s.Push = nil
+	}
+	s.Server, s.Name, s.Filter = server, name, filter
+}
@@ -0,0 +1,154 @@
+package subscription
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/developmeh/webrtc-poc/internal/seal"
+)
+
+func TestLoadAndSave(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "subscription-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "state", "subscription.json")
+
+	t.Run("Missing", func(t *testing.T) {
+		state, err := Load(path, nil)
+		if err != nil {
+			t.Fatalf("Load returned error: %v", err)
+		}
+		if state.Name != "" || state.Push != nil {
+			t.Errorf("Expected an empty state, got %+v", state)
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		state := &State{
+			Server: "http://localhost:8080/offer",
+			Name:   "edge-1",
+			Filter: "^ERROR",
+			Push:   &Progress{ID: "abc", File: "report.txt", Offset: 42},
+		}
+		if err := state.Save(path, nil); err != nil {
+			t.Fatalf("Save returned error: %v", err)
+		}
+
+		loaded, err := Load(path, nil)
+		if err != nil {
+			t.Fatalf("Load returned error: %v", err)
+		}
+		if loaded.Server != state.Server || loaded.Name != state.Name || loaded.Filter != state.Filter {
+			t.Errorf("Expected %+v, got %+v", state, loaded)
+		}
+		if loaded.Push == nil || loaded.Push.ID != state.Push.ID || loaded.Push.File != state.Push.File || loaded.Push.Offset != state.Push.Offset {
+			t.Errorf("Expected push %+v, got %+v", state.Push, loaded.Push)
+		}
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		key, err := seal.New(make([]byte, 32))
+		if err != nil {
+			t.Fatalf("Failed to create key: %v", err)
+		}
+
+		// A state saved in the clear is still read, and sealed once saved
+		if _, err := Load(path, key); err != nil {
+			t.Fatalf("Load returned error: %v", err)
+		}
+		state := &State{Name: "edge-1", Push: &Progress{ID: "abc", File: "report.txt", Offset: 7}}
+		if err := state.Save(path, key); err != nil {
+			t.Fatalf("Save returned error: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read state: %v", err)
+		}
+		if strings.Contains(string(data), "report.txt") || strings.Contains(string(data), "edge-1") {
+			t.Error("Expected the saved state not to reveal its file or name")
+		}
+
+		loaded, err := Load(path, key)
+		if err != nil {
+			t.Fatalf("Load returned error: %v", err)
+		}
+		if loaded.Push == nil || loaded.Push.File != "report.txt" || loaded.Push.Offset != 7 {
+			t.Errorf("Expected push %+v, got %+v", state.Push, loaded.Push)
+		}
+		if _, err := Load(path, nil); err == nil {
+			t.Error("Expected an error reading an encrypted state without a key")
+		}
+		other, _ := seal.New([]byte(strings.Repeat("k", 32)))
+		if _, err := Load(path, other); err == nil {
+			t.Error("Expected an error reading an encrypted state with another key")
+		}
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+			t.Fatalf("Failed to write state: %v", err)
+		}
+		if _, err := Load(path, nil); err == nil {
+			t.Error("Expected an error for a corrupt state file")
+		}
+	})
+}
+
+func TestSubscribe(t *testing.T) {
+	state := &State{Server: "http://a/offer", Name: "edge-1", Push: &Progress{ID: "abc", Offset: 3}}
+
+	// Same parameters keep the push in progress
+	state.Subscribe("http://a/offer", "edge-1", "")
+	if state.Push == nil {
+		t.Error("Expected the push in progress to be kept")
+	}
+
+	// Different parameters start a new subscription
+	state.Subscribe("http://a/offer", "edge-2", "")
+	if state.Push != nil || state.Name != "edge-2" {
+		t.Errorf("Expected a new subscription for edge-2, got %+v", state)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscription.json")
+	want := sha256.Sum256([]byte("one\ntwo\nthree\n"))
+
+	// The hash of the received lines survives saving and loading midway
+	state := &State{Name: "edge-1", Push: &Progress{ID: "abc"}}
+	state.Push.Add("one")
+	state.Push.Add("two")
+	if err := state.Save(path, nil); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+	loaded, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	loaded.Push.Add("three")
+	if got := loaded.Push.Sum(); got != hex.EncodeToString(want[:]) || loaded.Push.Offset != 3 {
+		t.Errorf("Expected the hash of all three lines at offset 3, got %s at %d", got, loaded.Push.Offset)
+	}
+
+	// Progress saved without a digest cannot be checked
+	old := &Progress{ID: "abc", Offset: 5}
+	old.Add("six")
+	if got := old.Sum(); got != "" || old.Offset != 6 {
+		t.Errorf("Expected no hash for progress without a digest, got %q at %d", got, old.Offset)
+	}
+
+	// Restarting starts the hash over
+	old.Restart()
+	old.Add("one")
+	first := sha256.Sum256([]byte("one\n"))
+	if got := old.Sum(); got != hex.EncodeToString(first[:]) || old.Offset != 1 {
+		t.Errorf("Expected the hash of the first line after a restart, got %s at %d", got, old.Offset)
+	}
+}
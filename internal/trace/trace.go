@@ -0,0 +1,239 @@
+// Package trace records what happens on a connection into a trace file: every
+// signaling message, every state transition of the peer connection and the
+// file stream channel, and every control frame, as JSON lines in the order
+// they happened. Each event carries a sequence number, the time since the
+// trace started on the monotonic clock, which orders the events of one peer
+// even if the wall clock jumps, and the wall clock time, which lines up the
+// traces of both peers. Events carry the session they belong to; a client
+// passes its session to the server with the offer, so the server's trace
+// names the client's sessions the same way. The trace is process wide, like
+// the logger, and recording is a no-op until Open is called.
+package trace
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Kinds of events
+const (
+	// Signal is a signaling message: an offer, answer or ICE candidate
+	Signal = "signal"
+	// State is a state transition of the peer connection or a data channel
+	State = "state"
+	// Control is a control frame of the file stream
+	Control = "control"
+)
+
+// Directions of signaling messages and control frames
+const (
+	Send = "send"
+	Recv = "recv"
+)
+
+// Param is the query parameter the client passes its session to the server in
+const Param = "trace"
+
+// Event is one line of a trace
+type Event struct {
+	Seq int64 `json:"seq"`
+	// Mono is the time since the trace started in nanoseconds, on the
+	// monotonic clock
+	Mono    time.Duration `json:"mono"`
+	Time    time.Time     `json:"time"`
+	Peer    string        `json:"peer"`
+	Session string        `json:"session,omitempty"`
+	Kind    string        `json:"kind"`
+	Dir     string        `json:"dir,omitempty"`
+	// Channel is the label of the data channel a control frame was sent on
+	Channel string `json:"channel,omitempty"`
+	// Name is what the event is about: the type of the signaling message,
+	// the state machine that moved or the type of the control frame
+	Name string `json:"name"`
+	// Value is the new state, or a short rendering of the message
+	Value string `json:"value,omitempty"`
+	// Data is the whole signaling message, when it is JSON
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// recorder writes the events of the trace file
+type recorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	enc   *json.Encoder
+	peer  string
+	start time.Time
+	seq   int64
+}
+
+var (
+	mu      sync.RWMutex
+	current *recorder
+	// sessions maps the peer connections and data channels of the traced
+	// sessions to their session
+	sessions sync.Map
+)
+
+// Open starts recording the events of peer into a new trace file at path
+func Open(path, peer string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("error creating trace file: %w", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if current != nil {
+		current.file.Close()
+	}
+	current = &recorder{file: file, enc: json.NewEncoder(file), peer: peer, start: time.Now()}
+	return nil
+}
+
+// Close stops recording and closes the trace file
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		return nil
+	}
+	err := current.file.Close()
+	current = nil
+	return err
+}
+
+// Enabled reports whether a trace is being recorded
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current != nil
+}
+
+// NewSession returns a random session ID
+func NewSession() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Bind attributes the events of a peer connection or data channel to
+// session, until Unbind is called for it
+func Bind(key any, session string) {
+	if Enabled() {
+		sessions.Store(key, session)
+	}
+}
+
+// Unbind forgets the session of a peer connection or data channel
+func Unbind(key any) {
+	sessions.Delete(key)
+}
+
+// SessionOf returns the session a peer connection or data channel is bound
+// to, or an empty string
+func SessionOf(key any) string {
+	session, _ := sessions.Load(key)
+	s, _ := session.(string)
+	return s
+}
+
+// record writes an event, completing its sequence, times and peer
+func record(e Event) {
+	mu.RLock()
+	defer mu.RUnlock()
+	r := current
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.seq++
+	e.Seq, e.Mono, e.Time, e.Peer = r.seq, now.Sub(r.start), now, r.peer
+	r.enc.Encode(e)
+}
+
+// Message records a signaling message sent or received. JSON messages are
+// kept whole; value is a short rendering of the message, if any.
+func Message(session, dir, name, value string, data []byte) {
+	e := Event{Session: session, Kind: Signal, Dir: dir, Name: name, Value: value}
+	if json.Valid(data) {
+		e.Data = json.RawMessage(data)
+	} else if value == "" && len(data) > 0 {
+		e.Value = fmt.Sprintf("%d bytes", len(data))
+	}
+	record(e)
+}
+
+// Description records a session description sent or received, named after
+// its type
+func Description(session, dir string, desc webrtc.SessionDescription) {
+	if !Enabled() {
+		return
+	}
+	data, err := json.Marshal(desc)
+	if err != nil {
+		return
+	}
+	Message(session, dir, desc.Type.String(), "", data)
+}
+
+// Transition records a state machine of a session moving to state
+func Transition(session, name, state string) {
+	record(Event{Session: session, Kind: State, Name: name, Value: state})
+}
+
+// Watch records the ICE gathering and signaling state transitions of pc
+// under its session. The connection and ICE connection state handlers are
+// left to the caller, which records them with Transition.
+func Watch(pc *webrtc.PeerConnection) {
+	if !Enabled() {
+		return
+	}
+	pc.OnICEGatheringStateChange(func(state webrtc.ICEGathererState) {
+		Transition(SessionOf(pc), "gathering", state.String())
+	})
+	pc.OnSignalingStateChange(func(state webrtc.SignalingState) {
+		Transition(SessionOf(pc), "signaling", state.String())
+	})
+}
+
+// Frame records a control frame sent or received on dc
+func Frame(dc *webrtc.DataChannel, dir, name, value string) {
+	if !Enabled() {
+		return
+	}
+	record(Event{Session: SessionOf(dc), Kind: Control, Dir: dir, Channel: dc.Label(), Name: name, Value: value})
+}
+
+// Read parses the events of a trace file. A last line cut short, by a peer
+// that crashed while writing it, is ignored.
+func Read(path string) ([]Event, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	var events []Event
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			if i == len(lines)-1 {
+				break
+			}
+			return nil, fmt.Errorf("error parsing trace %s line %d: %w", path, i+1, err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
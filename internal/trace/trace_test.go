@@ -0,0 +1,149 @@
+package trace
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.trace")
+	if err := Open(path, "client"); err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	t.Cleanup(func() { Close() })
+
+	pc := new(webrtc.PeerConnection)
+	Bind(pc, "abc")
+	defer Unbind(pc)
+	Description(SessionOf(pc), Send, webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "v=0"})
+	Message("abc", Recv, "candidate", "", []byte("not json"))
+	Transition("abc", "connection", "connected")
+	if err := Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	// Nothing is recorded once the trace is closed
+	Transition("abc", "connection", "closed")
+
+	events, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d: %+v", len(events), events)
+	}
+	for i, e := range events {
+		if e.Seq != int64(i+1) || e.Peer != "client" || e.Session != "abc" || e.Time.IsZero() {
+			t.Errorf("Unexpected event %d: %+v", i, e)
+		}
+		if i > 0 && e.Mono < events[i-1].Mono {
+			t.Errorf("Expected monotonic times to grow, got %v after %v", e.Mono, events[i-1].Mono)
+		}
+	}
+	if e := events[0]; e.Kind != Signal || e.Name != "offer" || !bytes.Contains(e.Data, []byte(`"sdp":"v=0"`)) {
+		t.Errorf("Expected the offer kept whole, got %+v", e)
+	}
+	if e := events[1]; e.Value != "8 bytes" || e.Data != nil {
+		t.Errorf("Expected a message that is not JSON summarized, got %+v", e)
+	}
+	if e := events[2]; e.Kind != State || e.Name != "connection" || e.Value != "connected" {
+		t.Errorf("Unexpected transition %+v", e)
+	}
+}
+
+func TestRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.trace")
+	lines := `{"seq":1,"peer":"server","kind":"state","name":"ice","value":"checking"}
+{"seq":2,"peer":"server","kind":"state","na`
+	if err := os.WriteFile(path, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+	events, err := Read(path)
+	if err != nil {
+		t.Fatalf("Expected a last line cut short to be ignored, got %v", err)
+	}
+	if len(events) != 1 || events[0].Value != "checking" {
+		t.Errorf("Unexpected events %+v", events)
+	}
+
+	// Lines cut short anywhere else are an error
+	if err := os.WriteFile(path, []byte(lines+"\n"+lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(path); err == nil {
+		t.Error("Expected an error for a malformed line in the middle")
+	}
+}
+
+func TestSummary(t *testing.T) {
+	for _, tt := range []struct {
+		event Event
+		want  string
+	}{
+		{Event{Kind: State, Name: "connection", Value: "connected"}, "connection: connected"},
+		{Event{Kind: Control, Dir: Send, Name: "fin", Value: "12", Channel: "fileStream"}, "send fin 12 on fileStream"},
+		{Event{Kind: Signal, Dir: Recv, Name: "offer", Data: []byte(`{"type":"offer"}`)}, "recv offer (16 bytes)"},
+		{Event{Kind: Signal, Dir: Send, Name: "candidate", Value: "host udp"}, "send candidate host udp"},
+	} {
+		if got := tt.event.Summary(); got != tt.want {
+			t.Errorf("Expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	server := []Event{{Session: "a"}, {Session: "b"}, {Session: "c"}}
+	client := []Event{{Session: "b"}, {Session: ""}}
+	s, c := Correlate(server, client)
+	if len(s) != 1 || s[0].Session != "b" || len(c) != 1 {
+		t.Errorf("Expected only session b kept, got %+v and %+v", s, c)
+	}
+
+	other := []Event{{Session: "x"}}
+	if s, c := Correlate(server, other); len(s) != 3 || len(c) != 1 {
+		t.Errorf("Expected traces without a shared session kept, got %+v and %+v", s, c)
+	}
+}
+
+func TestView(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	client := []Event{
+		{Time: start, Peer: "client", Kind: Signal, Dir: Send, Name: "offer", Value: "v"},
+		{Time: start.Add(300 * time.Millisecond), Peer: "client", Kind: State, Name: "connection", Value: "connected"},
+	}
+	server := []Event{
+		{Time: start.Add(100 * time.Millisecond), Peer: "server", Kind: Signal, Dir: Recv, Name: "offer", Value: "v"},
+		{Time: start.Add(200 * time.Millisecond), Peer: "server", Kind: State, Name: "connection", Value: "connected"},
+	}
+
+	var out bytes.Buffer
+	View(&out, 22, client, server)
+	got := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	want := []string{
+		"      TIME  CLIENT                 | SERVER",
+		"   +0.000s  send offer v           |",
+		"   +0.100s                         | recv offer v",
+		"   +0.200s                         | connection: connected",
+		"   +0.300s  connection: connected  |",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d lines, got %d:\n%s", len(want), len(got), out.String())
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Line %d: expected %q, got %q", i, want[i], got[i])
+		}
+	}
+
+	// Long events are cut to the column
+	out.Reset()
+	View(&out, 10, client[1:])
+	if !strings.Contains(out.String(), "connect...") {
+		t.Errorf("Expected the event cut to 10 characters, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,138 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Summary renders an event in one line
+func (e Event) Summary() string {
+	switch e.Kind {
+	case State:
+		return e.Name + ": " + e.Value
+	case Control:
+		summary := e.Dir + " " + e.Name
+		if e.Value != "" {
+			summary += " " + e.Value
+		}
+		return summary + " on " + e.Channel
+	default:
+		summary := e.Dir + " " + e.Name
+		if e.Value != "" {
+			summary += " " + e.Value
+		} else if len(e.Data) > 0 {
+			summary += fmt.Sprintf(" (%d bytes)", len(e.Data))
+		}
+		return summary
+	}
+}
+
+// Sessions returns the sessions of events, in the order they first appear
+func Sessions(events []Event) []string {
+	var sessions []string
+	for _, e := range events {
+		if e.Session != "" && !slices.Contains(sessions, e.Session) {
+			sessions = append(sessions, e.Session)
+		}
+	}
+	return sessions
+}
+
+// Filter returns the events of the sessions listed
+func Filter(events []Event, sessions ...string) []Event {
+	var kept []Event
+	for _, e := range events {
+		if slices.Contains(sessions, e.Session) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// Correlate keeps the events of the sessions both traces recorded, so the
+// trace of a server shows only the sessions of the client trace next to it.
+// Traces without a session in common are returned as they are.
+func Correlate(a, b []Event) ([]Event, []Event) {
+	var shared []string
+	others := Sessions(b)
+	for _, session := range Sessions(a) {
+		if slices.Contains(others, session) {
+			shared = append(shared, session)
+		}
+	}
+	if len(shared) == 0 {
+		return a, b
+	}
+	return Filter(a, shared...), Filter(b, shared...)
+}
+
+// row is an event of one of the traces being viewed
+type row struct {
+	column int
+	event  Event
+}
+
+// merge orders the events of several traces by their wall clock time,
+// keeping the order each trace recorded them in
+func merge(traces [][]Event) []row {
+	next := make([]int, len(traces))
+	var rows []row
+	for {
+		column := -1
+		for i, events := range traces {
+			if next[i] == len(events) {
+				continue
+			}
+			if column < 0 || events[next[i]].Time.Before(traces[column][next[column]].Time) {
+				column = i
+			}
+		}
+		if column < 0 {
+			return rows
+		}
+		rows = append(rows, row{column: column, event: traces[column][next[column]]})
+		next[column]++
+	}
+}
+
+// View writes traces side by side, one column of at most width characters
+// per trace, each event on its own line in the order they happened, after
+// the time since the first event
+func View(w io.Writer, width int, traces ...[]Event) {
+	cell := func(text string) string {
+		if len(text) > width {
+			text = text[:max(width-3, 0)] + "..."
+		}
+		return text + strings.Repeat(" ", width-len(text))
+	}
+	line := func(offset string, column int, text string) {
+		cells := make([]string, len(traces))
+		for i := range cells {
+			cells[i] = cell("")
+		}
+		cells[column] = cell(text)
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("%10s  %s", offset, strings.Join(cells, " | ")), " "))
+	}
+
+	// Head every column with the peer that recorded the trace
+	headers := make([]string, len(traces))
+	for i, events := range traces {
+		headers[i] = cell(fmt.Sprintf("trace %d", i+1))
+		if len(events) > 0 {
+			headers[i] = cell(strings.ToUpper(events[0].Peer))
+		}
+	}
+	fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("%10s  %s", "TIME", strings.Join(headers, " | ")), " "))
+
+	rows := merge(traces)
+	var start time.Time
+	for i, r := range rows {
+		if i == 0 {
+			start = r.event.Time
+		}
+		line(fmt.Sprintf("+%.3fs", r.event.Time.Sub(start).Seconds()), r.column, r.event.Summary())
+	}
+}
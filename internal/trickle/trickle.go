@@ -0,0 +1,323 @@
+// Package trickle exchanges ICE candidates over HTTP as they are gathered,
+// so neither peer waits for gathering to complete before sending its offer
+// or answer. The client asks for trickling with its offer, the server answers
+// right away with a session ID, and from then on the client posts its
+// candidates to the candidates endpoint and long-polls it for the server's.
+// Clients probe the endpoint first and fall back to waiting for gathering
+// against servers that do not have it.
+package trickle
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/logger"
+	"github.com/developmeh/webrtc-poc/internal/trace"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// Path is the endpoint candidates are exchanged on, next to /offer
+	Path = "/candidates"
+	// RequestHeader asks the server to answer an offer without waiting for
+	// its candidates
+	RequestHeader = "X-Trickle-ICE"
+	// SessionHeader carries the ID of the session candidates belong to
+	SessionHeader = "X-Trickle-Session"
+	// pollTimeout is how long a poll waits for new candidates
+	pollTimeout = 10 * time.Second
+	// maxFailures is how many requests in a row may fail before the client
+	// stops exchanging candidates
+	maxFailures = 3
+)
+
+// ErrUnknownSession is returned for candidates of a session the server does
+// not know, or no longer does
+var ErrUnknownSession = errors.New("unknown trickle session")
+
+// Batch is a page of the server's candidates
+type Batch struct {
+	Candidates []webrtc.ICECandidateInit `json:"candidates"`
+	// Next is the index to poll from next
+	Next int `json:"next"`
+	// Done is set once the server gathered all its candidates
+	Done bool `json:"done"`
+}
+
+// session is the server side of one peer connection's exchange
+type session struct {
+	pc *webrtc.PeerConnection
+
+	mu         sync.Mutex
+	candidates []webrtc.ICECandidateInit
+	done       bool
+	changed    chan struct{}
+}
+
+// Sessions serves the candidates endpoint for the server's peer connections
+type Sessions struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// NewSessions creates the server side of the exchange, forgetting each
+// session ttl after it started
+func NewSessions(ttl time.Duration) *Sessions {
+	return &Sessions{ttl: ttl, sessions: make(map[string]*session)}
+}
+
+// Add starts collecting the local candidates of pc for its client and returns
+// the session ID to answer with. It must be called before the local
+// description is set.
+func (s *Sessions) Add(pc *webrtc.PeerConnection) string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+
+	sess := &session{pc: pc, changed: make(chan struct{})}
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+		if c == nil {
+			sess.done = true
+		} else {
+			sess.candidates = append(sess.candidates, c.ToJSON())
+		}
+		close(sess.changed)
+		sess.changed = make(chan struct{})
+	})
+
+	s.mu.Lock()
+	s.sessions[id] = sess
+	s.mu.Unlock()
+	time.AfterFunc(s.ttl, func() {
+		s.mu.Lock()
+		delete(s.sessions, id)
+		s.mu.Unlock()
+	})
+	return id
+}
+
+// ServeHTTP answers probes with OPTIONS, adds the client's candidates posted
+// to a session and returns the server's candidates from the index in the
+// from query parameter on, waiting a while for new ones if there are none
+func (s *Sessions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.mu.Lock()
+	sess, ok := s.sessions[r.Header.Get(SessionHeader)]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, ErrUnknownSession.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var candidate webrtc.ICECandidateInit
+		if err := json.NewDecoder(r.Body).Decode(&candidate); err != nil {
+			http.Error(w, "Invalid candidate: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		trace.Message(trace.SessionOf(sess.pc), trace.Recv, "candidate", candidate.Candidate, nil)
+		if err := sess.pc.AddICECandidate(candidate); err != nil {
+			http.Error(w, "Invalid candidate: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		batch := sess.wait(max(from, 0), pollTimeout)
+		for _, candidate := range batch.Candidates {
+			trace.Message(trace.SessionOf(sess.pc), trace.Send, "candidate", candidate.Candidate, nil)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(batch)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// wait returns the candidates from index from on, waiting up to timeout for
+// one if there are none yet and gathering is not done
+func (sess *session) wait(from int, timeout time.Duration) Batch {
+	expired := time.After(timeout)
+	for {
+		sess.mu.Lock()
+		if from < len(sess.candidates) || sess.done {
+			batch := Batch{Next: len(sess.candidates), Done: sess.done}
+			if from < len(sess.candidates) {
+				batch.Candidates = append(batch.Candidates, sess.candidates[from:]...)
+			}
+			sess.mu.Unlock()
+			return batch
+		}
+		changed := sess.changed
+		sess.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-expired:
+			return Batch{Next: from}
+		}
+	}
+}
+
+// Client is the client side of the exchange for one peer connection
+type Client struct {
+	url  string
+	sign func(req *http.Request, body []byte)
+	pc   *webrtc.PeerConnection
+	http *http.Client
+
+	mu      sync.Mutex
+	id      string
+	pending []webrtc.ICECandidateInit
+}
+
+// NewClient prepares trickling the candidates of pc to the candidates
+// endpoint at url, with requests signed by sign. It must be called before
+// the local description is set; candidates are held until Start.
+func NewClient(pc *webrtc.PeerConnection, url string, sign func(req *http.Request, body []byte)) *Client {
+	c := &Client{url: url, sign: sign, pc: pc, http: &http.Client{Timeout: pollTimeout + 10*time.Second}}
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.id == "" {
+			c.pending = append(c.pending, candidate.ToJSON())
+			return
+		}
+		go c.send(c.id, candidate.ToJSON())
+	})
+	return c
+}
+
+// Supported probes whether the server has the candidates endpoint, giving up
+// once ctx ends
+func (c *Client) Supported(ctx context.Context) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodOptions, c.url, nil)
+	if err != nil {
+		return false
+	}
+	c.sign(req, nil)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusNoContent
+}
+
+// Start sends the candidates gathered so far and those still to come to the
+// session id, and adds the server's candidates to the peer connection until
+// the server gathered all of them
+func (c *Client) Start(id string) {
+	c.mu.Lock()
+	c.id = id
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	for _, candidate := range pending {
+		go c.send(id, candidate)
+	}
+	go c.poll(id)
+}
+
+// send posts one of the client's candidates
+func (c *Client) send(id string, candidate webrtc.ICECandidateInit) {
+	body, _ := json.Marshal(candidate)
+	trace.Message(trace.SessionOf(c.pc), trace.Send, "candidate", candidate.Candidate, nil)
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SessionHeader, id)
+	c.sign(req, body)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		logger.Info("Warning: failed to send ICE candidate: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		logger.Info("Warning: server rejected ICE candidate: %s", resp.Status)
+	}
+}
+
+// poll adds the server's candidates to the peer connection as they arrive
+func (c *Client) poll(id string) {
+	from, failures := 0, 0
+	for c.pc.ConnectionState() != webrtc.PeerConnectionStateClosed {
+		batch, err := c.fetch(id, from)
+		if err != nil {
+			failures++
+			if failures >= maxFailures {
+				logger.Info("Warning: stopped receiving the server's ICE candidates: %v", err)
+				return
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+		failures = 0
+
+		for _, candidate := range batch.Candidates {
+			trace.Message(trace.SessionOf(c.pc), trace.Recv, "candidate", candidate.Candidate, nil)
+			if err := c.pc.AddICECandidate(candidate); err != nil {
+				logger.Info("Warning: ignoring the server's ICE candidate %s: %v", candidate.Candidate, err)
+			}
+		}
+		if batch.Done {
+			return
+		}
+		from = batch.Next
+	}
+}
+
+// fetch requests the server's candidates from index from on
+func (c *Client) fetch(id string, from int) (Batch, error) {
+	var batch Batch
+	req, err := http.NewRequest(http.MethodGet, c.url+"?from="+strconv.Itoa(from), nil)
+	if err != nil {
+		return batch, err
+	}
+	req.Header.Set(SessionHeader, id)
+	c.sign(req, nil)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return batch, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return batch, ErrUnknownSession
+	default:
+		return batch, fmt.Errorf("server returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return batch, fmt.Errorf("invalid candidates: %w", err)
+	}
+	return batch, nil
+}
@@ -0,0 +1,133 @@
+package trickle
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// noSign leaves requests as they are
+func noSign(*http.Request, []byte) {}
+
+func TestTrickle(t *testing.T) {
+	sessions := NewSessions(time.Minute)
+	server := httptest.NewServer(sessions)
+	defer server.Close()
+
+	answerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer answerer.Close()
+	offerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer offerer.Close()
+
+	opened := make(chan struct{})
+	channel, err := offerer.CreateDataChannel("fileStream", nil)
+	if err != nil {
+		t.Fatalf("Failed to create channel: %v", err)
+	}
+	channel.OnOpen(func() { close(opened) })
+
+	// The client's offer and the server's answer carry no candidates, only
+	// the exchange over the endpoint connects the peers
+	client := NewClient(offerer, server.URL, noSign)
+	if !client.Supported(context.Background()) {
+		t.Fatal("Expected the endpoint to be detected")
+	}
+	offer, err := offerer.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("CreateOffer returned error: %v", err)
+	}
+	if err := offerer.SetLocalDescription(offer); err != nil {
+		t.Fatalf("SetLocalDescription returned error: %v", err)
+	}
+
+	id := sessions.Add(answerer)
+	if err := answerer.SetRemoteDescription(offer); err != nil {
+		t.Fatalf("SetRemoteDescription returned error: %v", err)
+	}
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("CreateAnswer returned error: %v", err)
+	}
+	if err := answerer.SetLocalDescription(answer); err != nil {
+		t.Fatalf("SetLocalDescription returned error: %v", err)
+	}
+	if err := offerer.SetRemoteDescription(answer); err != nil {
+		t.Fatalf("SetRemoteDescription returned error: %v", err)
+	}
+	client.Start(id)
+
+	select {
+	case <-opened:
+	case <-time.After(15 * time.Second):
+		t.Fatal("Timed out waiting for the peers to connect over trickled candidates")
+	}
+
+	// Every candidate the server gathered was handed out
+	sessions.mu.Lock()
+	sess := sessions.sessions[id]
+	sessions.mu.Unlock()
+	batch := sess.wait(0, 5*time.Second)
+	if len(batch.Candidates) == 0 {
+		t.Error("Expected the server to have gathered candidates")
+	}
+}
+
+func TestWait(t *testing.T) {
+	sess := &session{changed: make(chan struct{})}
+	if batch := sess.wait(0, 10*time.Millisecond); len(batch.Candidates) != 0 || batch.Done || batch.Next != 0 {
+		t.Errorf("Expected an empty batch after the timeout, got %+v", batch)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		sess.mu.Lock()
+		sess.candidates = append(sess.candidates, webrtc.ICECandidateInit{Candidate: "candidate:1"})
+		close(sess.changed)
+		sess.changed = make(chan struct{})
+		sess.mu.Unlock()
+	}()
+	batch := sess.wait(0, 5*time.Second)
+	if len(batch.Candidates) != 1 || batch.Next != 1 || batch.Done {
+		t.Errorf("Expected the new candidate, got %+v", batch)
+	}
+
+	sess.mu.Lock()
+	sess.done = true
+	sess.mu.Unlock()
+	if batch := sess.wait(1, 5*time.Second); !batch.Done || len(batch.Candidates) != 0 {
+		t.Errorf("Expected a done batch without waiting, got %+v", batch)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("Failed to create peer connection: %v", err)
+	}
+	defer pc.Close()
+	if NewClient(pc, server.URL, noSign).Supported(context.Background()) {
+		t.Error("Expected a server without the endpoint to fall back")
+	}
+
+	sessions := NewSessions(time.Minute)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, Path, nil)
+	r.Header.Set(SessionHeader, "unknown")
+	sessions.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown session, got %d", w.Code)
+	}
+}
@@ -0,0 +1,215 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/developmeh/webrtc-poc/internal/sessions"
+)
+
+// Manager is the session manager the server's UI lists and ends sessions
+// through, the same one serving /sessions
+type Manager interface {
+	List() []sessions.Info
+	End(id string) bool
+}
+
+// Server is the terminal UI of a server. The methods of a nil Server do
+// nothing, so callers need not check whether the UI is enabled.
+type Server struct {
+	terminal
+	title   string
+	manager Manager
+
+	// list is the sessions at the last sample, with the bytes per second
+	// each sent since the one before
+	list    []sessions.Info
+	rates   map[string]float64
+	sampled time.Time
+	// selected is the ID of the session selected
+	selected string
+}
+
+// NewServer creates the UI of a server, titled with its address, listing
+// the sessions of manager
+func NewServer(title string, manager Manager) *Server {
+	s := &Server{terminal: terminal{quit: make(chan struct{})}, title: title, manager: manager, rates: make(map[string]float64)}
+	s.sample(time.Now())
+	return s
+}
+
+// Writer returns a writer whose lines are shown as log messages, for the
+// logger while the UI has the terminal
+func (s *Server) Writer() io.Writer {
+	if s == nil {
+		return io.Discard
+	}
+	return logWriter{&s.terminal}
+}
+
+// Quit returns a channel closed once the user quit the UI
+func (s *Server) Quit() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.quit
+}
+
+// Run shows the UI on out, reading keys from in, until the user quits or
+// Close is called. A nil in shows the UI without key bindings.
+func (s *Server) Run(in io.Reader, out io.Writer) error {
+	if s == nil {
+		return nil
+	}
+	return s.run(&serverModel{ui: s, width: 80}, in, out)
+}
+
+// Close closes the UI, giving the terminal back
+func (s *Server) Close() {
+	if s == nil {
+		return
+	}
+	s.close()
+}
+
+// sample lists the sessions and their throughput since the last sample
+func (s *Server) sample(now time.Time) {
+	list := s.manager.List()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := make(map[string]uint64, len(s.list))
+	for _, info := range s.list {
+		before[info.ID] = info.BytesSent
+	}
+	rates := make(map[string]float64, len(list))
+	if elapsed := now.Sub(s.sampled).Seconds(); elapsed > 0 {
+		for _, info := range list {
+			if sent, ok := before[info.ID]; ok && info.BytesSent >= sent {
+				rates[info.ID] = float64(info.BytesSent-sent) / elapsed
+			}
+		}
+	}
+	s.list, s.rates, s.sampled = list, rates, now
+	s.selected = s.selectedID(s.index())
+}
+
+// index returns the position of the selected session in the list, or the
+// first one if it is gone
+func (s *Server) index() int {
+	for i, info := range s.list {
+		if info.ID == s.selected {
+			return i
+		}
+	}
+	return 0
+}
+
+// selectedID returns the ID of the session at position i, clamped to the
+// list
+func (s *Server) selectedID(i int) string {
+	if len(s.list) == 0 {
+		return ""
+	}
+	return s.list[max(0, min(i, len(s.list)-1))].ID
+}
+
+// move moves the selection by delta sessions
+func (s *Server) move(delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.selected = s.selectedID(s.index() + delta)
+}
+
+// end ends the selected session
+func (s *Server) end() {
+	s.mu.Lock()
+	id := s.selected
+	s.mu.Unlock()
+	if id != "" {
+		s.manager.End(id)
+	}
+}
+
+// serverModel is the bubbletea model of the server's UI
+type serverModel struct {
+	ui    *Server
+	width int
+}
+
+// Init implements tea.Model
+func (m *serverModel) Init() tea.Cmd {
+	return doTick()
+}
+
+// Update implements tea.Model
+func (m *serverModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tick:
+		m.ui.sample(time.Time(msg))
+		return m, doTick()
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			m.ui.move(-1)
+		case "down", "j":
+			m.ui.move(1)
+		case "x", "delete":
+			m.ui.end()
+		case "q", "ctrl+c", "esc":
+			m.ui.stop()
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+// View implements tea.Model
+func (m *serverModel) View() string {
+	u := m.ui
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var b strings.Builder
+	line := func(format string, v ...interface{}) {
+		b.WriteString(clip(fmt.Sprintf(format, v...), m.width))
+		b.WriteByte('\n')
+	}
+	line("webrtc-poc server: %s", u.title)
+	line("")
+	line("Sessions:    %d", len(u.list))
+	line("")
+	line("  %-16s  %-12s  %10s  %12s  %10s  %s", "ID", "STATE", "SENT", "THROUGHPUT", "QUEUED", "CLIENT")
+	for _, info := range u.list {
+		cursor := " "
+		if info.ID == u.selected {
+			cursor = ">"
+		}
+		client := info.Identity
+		if client == "" {
+			client = "anonymous"
+		}
+		state := info.State
+		if info.Stalled {
+			state = "stalled"
+		}
+		line("%s %-16s  %-12s  %10s  %10s/s  %10s  %s %s", cursor, info.ID, state, formatBytes(int64(info.BytesSent)), formatBytes(int64(u.rates[info.ID])), formatBytes(int64(info.Buffered)), client, info.File)
+	}
+	if len(u.list) == 0 {
+		line("  no active sessions")
+	}
+	line("")
+	line("Log:")
+	for _, l := range u.logs {
+		line("  %s", l)
+	}
+	line("")
+	b.WriteString(clip("up/down select  x end session  q quit", m.width))
+	return b.String()
+}
@@ -0,0 +1,410 @@
+// Package tui shows live terminal UIs of the client and the server. The
+// client's shows the states of the connection and ICE, the candidate pair
+// the connection runs over, the lines and bytes received with a sparkline
+// of the throughput, the last lines and log messages, and key bindings to
+// pause or quit. The client reports to the UI from any goroutine, and the
+// UI samples what it was told on every tick, so a fast stream does not slow
+// down with the UI. The server's lists its active sessions with their live
+// throughput and queue depth, and ends the one selected.
+//
+// Pausing stops the client from taking lines off the file stream channel.
+// The channel's flow control then holds the server back until the stream
+// resumes, so no line is lost or buffered without bound.
+package tui
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/term"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// tickInterval is how often the UI samples the stream and redraws
+	tickInterval = 500 * time.Millisecond
+	// sparkWidth is the number of throughput samples shown
+	sparkWidth = 40
+	// lastLines and lastLogs are how many lines and log messages are shown
+	lastLines = 8
+	lastLogs  = 4
+)
+
+// sparks are the bars of the sparkline, from lowest to highest
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// terminal is what the client's and the server's UI share: the program
+// drawing them, the log messages they show and how they quit
+type terminal struct {
+	mu      sync.Mutex
+	logs    []string
+	quit    chan struct{}
+	once    sync.Once
+	program *tea.Program
+	closed  bool
+}
+
+// UI is the terminal UI of a client's stream. The methods of a nil UI do
+// nothing, so callers need not check whether the UI is enabled.
+type UI struct {
+	terminal
+	title string
+
+	connection string
+	ice        string
+	route      string
+	lines      int
+	bytes      int64
+	last       []string
+	status     string
+	// counted is the number of lines at the last sample, and samples the
+	// lines per second of every sample
+	counted int
+	sampled time.Time
+	samples []float64
+	rate    float64
+
+	// resumed is closed while the stream is not paused
+	paused  bool
+	resumed chan struct{}
+}
+
+// New creates the UI of a stream, titled with what it receives from
+func New(title string) *UI {
+	resumed := make(chan struct{})
+	close(resumed)
+	return &UI{terminal: terminal{quit: make(chan struct{})}, title: title, resumed: resumed, status: "connecting", sampled: time.Now()}
+}
+
+// State reports the state of the connection ("connection") or ICE ("ice")
+func (u *UI) State(name, value string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch name {
+	case "connection":
+		u.connection = value
+		if value == webrtc.PeerConnectionStateConnected.String() && u.status == "connecting" {
+			u.status = "receiving"
+		}
+	case "ice":
+		u.ice = value
+	}
+}
+
+// Route reports the candidate pair the connection runs over
+func (u *UI) Route(pair *webrtc.ICECandidatePair) {
+	if u == nil || pair == nil || pair.Local == nil || pair.Remote == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.route = fmt.Sprintf("%s %s <-> %s %s (%s)", pair.Local.Typ, hostPort(pair.Local), pair.Remote.Typ, hostPort(pair.Remote), pair.Local.Protocol)
+}
+
+// hostPort returns the address and port of c
+func hostPort(c *webrtc.ICECandidate) string {
+	return net.JoinHostPort(c.Address, strconv.Itoa(int(c.Port)))
+}
+
+// Line reports a line received
+func (u *UI) Line(line string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.lines++
+	u.bytes += int64(len(line))
+	u.last = keepLast(u.last, line, lastLines)
+}
+
+// Finish reports that the stream ended, with how it did
+func (u *UI) Finish(status string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.status = status
+}
+
+// Writer returns a writer whose lines are shown as log messages, for the
+// logger while the UI has the terminal
+func (u *UI) Writer() io.Writer {
+	if u == nil {
+		return io.Discard
+	}
+	return logWriter{&u.terminal}
+}
+
+// logWriter shows the lines written to it as log messages
+type logWriter struct {
+	t *terminal
+}
+
+// Write implements io.Writer
+func (w logWriter) Write(p []byte) (int, error) {
+	w.t.mu.Lock()
+	defer w.t.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.t.logs = keepLast(w.t.logs, line, lastLogs)
+	}
+	return len(p), nil
+}
+
+// keepLast appends s to list, keeping at most n entries
+func keepLast(list []string, s string, n int) []string {
+	list = append(list, s)
+	if len(list) > n {
+		list = append(list[:0], list[len(list)-n:]...)
+	}
+	return list
+}
+
+// Wait blocks while the stream is paused, until it is resumed or the UI
+// quits
+func (u *UI) Wait() {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	resumed := u.resumed
+	u.mu.Unlock()
+	select {
+	case <-resumed:
+	case <-u.quit:
+	}
+}
+
+// Quit returns a channel closed once the user quit the UI
+func (u *UI) Quit() <-chan struct{} {
+	if u == nil {
+		return nil
+	}
+	return u.quit
+}
+
+// togglePause pauses or resumes the stream
+func (u *UI) togglePause() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.paused = !u.paused
+	if u.paused {
+		u.resumed = make(chan struct{})
+	} else {
+		close(u.resumed)
+	}
+}
+
+// sample records the throughput since the last sample
+func (u *UI) sample(now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if elapsed := now.Sub(u.sampled).Seconds(); elapsed > 0 {
+		u.rate = float64(u.lines-u.counted) / elapsed
+		u.samples = append(u.samples, u.rate)
+		if len(u.samples) > sparkWidth {
+			u.samples = u.samples[len(u.samples)-sparkWidth:]
+		}
+	}
+	u.counted, u.sampled = u.lines, now
+}
+
+// IsTerminal reports whether f is a terminal
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(f.Fd())
+}
+
+// Run shows the UI on out, reading keys from in, until the user quits or
+// Close is called. A nil in shows the UI without key bindings. If the UI
+// stops without the user quitting, a paused stream resumes.
+func (u *UI) Run(in io.Reader, out io.Writer) error {
+	if u == nil {
+		return nil
+	}
+	err := u.run(&model{ui: u, width: 80}, in, out)
+	u.mu.Lock()
+	if u.paused {
+		u.paused = false
+		close(u.resumed)
+	}
+	u.mu.Unlock()
+	return err
+}
+
+// Close closes the UI, giving the terminal back
+func (u *UI) Close() {
+	if u == nil {
+		return
+	}
+	u.close()
+}
+
+// run shows m on out, reading keys from in, until the user quits or close
+// is called
+func (t *terminal) run(m tea.Model, in io.Reader, out io.Writer) error {
+	program := tea.NewProgram(m, tea.WithInput(in), tea.WithOutput(out))
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.program = program
+	t.mu.Unlock()
+	_, err := program.Run()
+	return err
+}
+
+// close stops the program, giving the terminal back
+func (t *terminal) close() {
+	t.mu.Lock()
+	t.closed = true
+	program := t.program
+	t.mu.Unlock()
+	if program != nil {
+		program.Quit()
+		program.Wait()
+	}
+}
+
+// stop closes quit once the user quit
+func (t *terminal) stop() {
+	t.once.Do(func() { close(t.quit) })
+}
+
+// tick samples the stream and redraws the UI
+type tick time.Time
+
+func doTick() tea.Cmd {
+	return tea.Tick(tickInterval, func(t time.Time) tea.Msg { return tick(t) })
+}
+
+// model is the bubbletea model of the UI
+type model struct {
+	ui    *UI
+	width int
+}
+
+// Init implements tea.Model
+func (m *model) Init() tea.Cmd {
+	return doTick()
+}
+
+// Update implements tea.Model
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tick:
+		m.ui.sample(time.Time(msg))
+		return m, doTick()
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "p", " ":
+			m.ui.togglePause()
+		case "q", "ctrl+c", "esc":
+			m.ui.stop()
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+// View implements tea.Model
+func (m *model) View() string {
+	u := m.ui
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	status := u.status
+	if u.paused {
+		status = "paused"
+	}
+	var b strings.Builder
+	line := func(format string, v ...interface{}) {
+		b.WriteString(clip(fmt.Sprintf(format, v...), m.width))
+		b.WriteByte('\n')
+	}
+	line("webrtc-poc client: %s", u.title)
+	line("")
+	line("Status:      %s", status)
+	line("Connection:  %s", orNone(u.connection))
+	line("ICE:         %s", orNone(u.ice))
+	line("Route:       %s", orNone(u.route))
+	line("Received:    %d lines, %s, %.1f lines/s", u.lines, formatBytes(u.bytes), u.rate)
+	line("Throughput:  %s", sparkline(u.samples))
+	line("")
+	line("Last lines:")
+	for _, l := range u.last {
+		line("  %s", l)
+	}
+	line("")
+	line("Log:")
+	for _, l := range u.logs {
+		line("  %s", l)
+	}
+	line("")
+	b.WriteString(clip("p pause/resume  q quit", m.width))
+	return b.String()
+}
+
+// sparkline draws samples as bars scaled to the largest of them
+func sparkline(samples []float64) string {
+	peak := 0.0
+	for _, s := range samples {
+		peak = max(peak, s)
+	}
+	var b strings.Builder
+	for _, s := range samples {
+		i := 0
+		if peak > 0 {
+			i = int(s / peak * float64(len(sparks)-1))
+		}
+		b.WriteRune(sparks[i])
+	}
+	return b.String()
+}
+
+// formatBytes formats n bytes with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// clip cuts s to width characters
+func clip(s string, width int) string {
+	r := []rune(s)
+	if width <= 0 || len(r) <= width {
+		return s
+	}
+	if width <= 3 {
+		return string(r[:width])
+	}
+	return string(r[:width-3]) + "..."
+}
+
+// orNone returns s, or a dash if it is empty
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
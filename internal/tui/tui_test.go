@@ -0,0 +1,367 @@
+package tui
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pion/webrtc/v3"
+
+	"github.com/developmeh/webrtc-poc/internal/sessions"
+)
+
+// key returns the message of pressing s
+func key(s string) tea.KeyMsg {
+	switch s {
+	case " ":
+		return tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")}
+	case "esc":
+		return tea.KeyMsg{Type: tea.KeyEsc}
+	case "down":
+		return tea.KeyMsg{Type: tea.KeyDown}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+func TestView(t *testing.T) {
+	u := New("http://localhost:8080/offer")
+	m := &model{ui: u, width: 80}
+	if view := m.View(); !strings.Contains(view, "Status:      connecting") || !strings.Contains(view, "Route:       -") {
+		t.Errorf("Expected a connecting stream without a route, got:\n%s", view)
+	}
+
+	u.State("ice", "connected")
+	u.State("connection", "connected")
+	u.Route(&webrtc.ICECandidatePair{
+		Local:  &webrtc.ICECandidate{Typ: webrtc.ICECandidateTypeHost, Address: "192.168.1.20", Port: 52110, Protocol: webrtc.ICEProtocolUDP},
+		Remote: &webrtc.ICECandidate{Typ: webrtc.ICECandidateTypeSrflx, Address: "203.0.113.7", Port: 60321, Protocol: webrtc.ICEProtocolUDP},
+	})
+	for i := 1; i <= 10; i++ {
+		u.Line(fmt.Sprintf("line %d", i))
+	}
+	fmt.Fprintf(u.Writer(), "[INFO] first\n[INFO] second\n")
+
+	view := m.View()
+	for _, want := range []string{
+		"webrtc-poc client: http://localhost:8080/offer",
+		"Status:      receiving",
+		"Connection:  connected",
+		"ICE:         connected",
+		"Route:       host 192.168.1.20:52110 <-> srflx 203.0.113.7:60321 (udp)",
+		"Received:    10 lines, 61 B",
+		"  line 10",
+		"  [INFO] second",
+		"p pause/resume  q quit",
+	} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected the view to contain %q, got:\n%s", want, view)
+		}
+	}
+	if strings.Contains(view, "  line 2\n") {
+		t.Errorf("Expected only the last %d lines, got:\n%s", lastLines, view)
+	}
+
+	u.Finish("finished, verified")
+	if view := m.View(); !strings.Contains(view, "Status:      finished, verified") {
+		t.Errorf("Expected a finished stream, got:\n%s", view)
+	}
+
+	m.width = 20
+	for _, l := range strings.Split(m.View(), "\n") {
+		if n := len([]rune(l)); n > 20 {
+			t.Errorf("Expected lines of at most 20 characters, got %d: %q", n, l)
+		}
+	}
+}
+
+func TestPause(t *testing.T) {
+	u := New("test")
+	m := &model{ui: u, width: 80}
+
+	waited := make(chan struct{})
+	m.Update(key("p"))
+	if view := m.View(); !strings.Contains(view, "Status:      paused") {
+		t.Errorf("Expected a paused stream, got:\n%s", view)
+	}
+	go func() {
+		u.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("Expected Wait to block while the stream is paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	m.Update(key(" "))
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Wait to return once the stream is resumed")
+	}
+
+	// Quitting ends the wait of a paused stream
+	m.Update(key("p"))
+	waited = make(chan struct{})
+	go func() {
+		u.Wait()
+		close(waited)
+	}()
+	if _, cmd := m.Update(key("q")); cmd == nil {
+		t.Error("Expected q to quit the UI")
+	}
+	select {
+	case <-u.Quit():
+	default:
+		t.Error("Expected Quit to be closed")
+	}
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Wait to return once the UI quit")
+	}
+
+	// Quitting again does not close Quit twice
+	m.Update(key("esc"))
+}
+
+func TestSample(t *testing.T) {
+	u := New("test")
+	start := u.sampled
+	for i := 0; i < 100; i++ {
+		u.Line("x")
+	}
+	u.sample(start.Add(time.Second))
+	for i := 0; i < 50; i++ {
+		u.Line("x")
+	}
+	u.sample(start.Add(2 * time.Second))
+
+	if len(u.samples) != 2 || u.samples[0] != 100 || u.samples[1] != 50 {
+		t.Errorf("Expected samples of 100 and 50 lines/s, got %v", u.samples)
+	}
+	for i := 0; i < 2*sparkWidth; i++ {
+		u.sample(start.Add(time.Duration(3+i) * time.Second))
+	}
+	if len(u.samples) != sparkWidth {
+		t.Errorf("Expected at most %d samples, got %d", sparkWidth, len(u.samples))
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	for _, tt := range []struct {
+		samples []float64
+		want    string
+	}{
+		{nil, ""},
+		{[]float64{0, 0}, "▁▁"},
+		{[]float64{0, 50, 100}, "▁▄█"},
+		{[]float64{10, 20, 40, 80}, "▁▂▄█"},
+	} {
+		if got := sparkline(tt.samples); got != tt.want {
+			t.Errorf("sparkline(%v) = %q, want %q", tt.samples, got, tt.want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for _, tt := range []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	} {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestClip(t *testing.T) {
+	for _, tt := range []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"too long a line", 10, "too lon..."},
+		{"äöüäöü", 5, "äö..."},
+		{"abc", 2, "ab"},
+		{"abc", 0, "abc"},
+	} {
+		if got := clip(tt.s, tt.width); got != tt.want {
+			t.Errorf("clip(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestNil(t *testing.T) {
+	var u *UI
+	u.State("connection", "connected")
+	u.Route(nil)
+	u.Line("line")
+	u.Finish("finished")
+	u.Wait()
+	fmt.Fprintln(u.Writer(), "discarded")
+	if u.Quit() != nil {
+		t.Error("Expected a nil UI to never quit")
+	}
+	if err := u.Run(strings.NewReader(""), nil); err != nil {
+		t.Errorf("Expected a nil UI to run without error, got %v", err)
+	}
+	u.Close()
+}
+
+func TestRun(t *testing.T) {
+	u := New("test")
+	u.togglePause()
+	in, w := io.Pipe()
+	defer w.Close()
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- u.Run(in, &out) }()
+
+	// Wait for the program to start before closing it
+	for i := 0; ; i++ {
+		u.mu.Lock()
+		started := u.program != nil
+		u.mu.Unlock()
+		if started {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Expected the UI to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	u.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected the UI to close without error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Run to return once the UI is closed")
+	}
+	select {
+	case <-u.Quit():
+		t.Error("Expected Quit to stay open when the UI is closed without the user quitting")
+	default:
+	}
+
+	// The stream of a UI that stopped is never held back
+	u.Wait()
+
+	// A closed UI does not start again
+	if err := u.Run(in, &out); err != nil {
+		t.Errorf("Expected a closed UI to return right away, got %v", err)
+	}
+}
+
+// fakeManager is a session manager with settable sessions
+type fakeManager struct {
+	list  []sessions.Info
+	ended []string
+}
+
+func (m *fakeManager) List() []sessions.Info {
+	return append([]sessions.Info(nil), m.list...)
+}
+
+func (m *fakeManager) End(id string) bool {
+	m.ended = append(m.ended, id)
+	return true
+}
+
+func TestServer(t *testing.T) {
+	manager := &fakeManager{}
+	s := NewServer(":8080", manager)
+	m := &serverModel{ui: s, width: 120}
+	if view := m.View(); !strings.Contains(view, "Sessions:    0") || !strings.Contains(view, "no active sessions") {
+		t.Errorf("Expected no sessions, got:\n%s", view)
+	}
+
+	// Throughput is measured between samples
+	start := s.sampled
+	manager.list = []sessions.Info{
+		{ID: "aaaa", State: "connected", Identity: "alice", File: "app.log", Stats: sessions.Stats{BytesSent: 1024}},
+		{ID: "bbbb", State: "connecting", File: "app.log"},
+	}
+	s.sample(start.Add(time.Second))
+	manager.list[0].BytesSent, manager.list[0].Buffered = 3072, 2048
+	s.sample(start.Add(2 * time.Second))
+	fmt.Fprintln(s.Writer(), "[INFO] session started")
+
+	view := m.View()
+	for _, want := range []string{
+		"webrtc-poc server: :8080",
+		"Sessions:    2",
+		"> aaaa",
+		"3.0 KiB",
+		"2.0 KiB/s",
+		"alice app.log",
+		"  bbbb",
+		"anonymous app.log",
+		"  [INFO] session started",
+		"up/down select  x end session  q quit",
+	} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected the view to contain %q, got:\n%s", want, view)
+		}
+	}
+
+	// The selection moves within the list and ends the session selected
+	m.Update(key("j"))
+	m.Update(key("down"))
+	m.Update(key("x"))
+	m.Update(key("k"))
+	m.Update(key("x"))
+	if len(manager.ended) != 2 || manager.ended[0] != "bbbb" || manager.ended[1] != "aaaa" {
+		t.Errorf("Expected bbbb and aaaa to be ended, got %v", manager.ended)
+	}
+
+	// A session that is gone moves the selection to the first one
+	m.Update(key("j"))
+	manager.list = manager.list[:1]
+	s.sample(start.Add(3 * time.Second))
+	if view := m.View(); !strings.Contains(view, "> aaaa") {
+		t.Errorf("Expected the first session to be selected, got:\n%s", view)
+	}
+	manager.list = nil
+	s.sample(start.Add(4 * time.Second))
+	m.Update(key("x"))
+	if len(manager.ended) != 2 {
+		t.Errorf("Expected nothing to be ended without sessions, got %v", manager.ended)
+	}
+
+	if _, cmd := m.Update(key("q")); cmd == nil {
+		t.Error("Expected q to quit the UI")
+	}
+	select {
+	case <-s.Quit():
+	default:
+		t.Error("Expected Quit to be closed")
+	}
+}
+
+func TestServerNil(t *testing.T) {
+	var s *Server
+	fmt.Fprintln(s.Writer(), "discarded")
+	if s.Quit() != nil {
+		t.Error("Expected a nil UI to never quit")
+	}
+	if err := s.Run(strings.NewReader(""), nil); err != nil {
+		t.Errorf("Expected a nil UI to run without error, got %v", err)
+	}
+	s.Close()
+}
@@ -0,0 +1,430 @@
+// Package tunnel multiplexes TCP connections over a single data channel, the
+// way ssh -L forwards ports. The client opens the channel with Protocol as
+// its subprotocol and asks the server to connect a new stream to a target
+// address for every local connection. Every message on the channel is one
+// frame: a type byte, the stream ID as a big endian uint32 and a payload.
+// Each stream has its own flow control window, so a slow connection does not
+// hold up the others.
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Protocol marks the data channel carrying the tunnel
+	Protocol = "webrtc-poc-tunnel"
+	// Label is the label of the tunnel data channel
+	Label = "tunnel"
+
+	// Window is how many bytes a stream may send before the receiver
+	// confirms it read them
+	Window = 256 * 1024
+	// maxPayload is the most data carried by one frame
+	maxPayload = 16 * 1024
+	headerSize = 5
+)
+
+// Frame types
+const (
+	// frameOpen asks to connect a new stream to the address in the payload
+	frameOpen byte = iota + 1
+	// frameOpened confirms a stream is connected
+	frameOpened
+	// frameData carries stream data
+	frameData
+	// frameWindow grants the sender the number of bytes in the payload
+	frameWindow
+	// frameClose ends a stream, with an error message in the payload if it
+	// failed
+	frameClose
+)
+
+var (
+	// ErrClosed is returned for streams of a closed tunnel
+	ErrClosed = errors.New("tunnel closed")
+	// ErrRefused is returned when the peer refused to open a stream, wrapping
+	// the reason it gave, if any
+	ErrRefused = errors.New("stream refused")
+)
+
+// Forward is a local port forwarded to a target address behind the server
+type Forward struct {
+	Listen string
+	Target string
+}
+
+// ParseForward parses an ssh style [BIND:]PORT:HOST:HOSTPORT forward. IPv6
+// addresses are written in brackets.
+func ParseForward(spec string) (Forward, error) {
+	var parts []string
+	for rest := spec; rest != ""; {
+		var part string
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return Forward{}, fmt.Errorf("invalid forward %q: unterminated bracket", spec)
+			}
+			part, rest = rest[1:end], rest[end+1:]
+			if rest != "" && !strings.HasPrefix(rest, ":") {
+				return Forward{}, fmt.Errorf("invalid forward %q", spec)
+			}
+			rest = strings.TrimPrefix(rest, ":")
+		} else {
+			part, rest, _ = strings.Cut(rest, ":")
+		}
+		parts = append(parts, part)
+	}
+
+	var f Forward
+	switch len(parts) {
+	case 3:
+		f = Forward{Listen: net.JoinHostPort("localhost", parts[0]), Target: net.JoinHostPort(parts[1], parts[2])}
+	case 4:
+		f = Forward{Listen: net.JoinHostPort(parts[0], parts[1]), Target: net.JoinHostPort(parts[2], parts[3])}
+	default:
+		return Forward{}, fmt.Errorf("invalid forward %q (expected [BIND:]PORT:HOST:HOSTPORT)", spec)
+	}
+	for _, addr := range []string{f.Listen, f.Target} {
+		_, port, _ := net.SplitHostPort(addr)
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return Forward{}, fmt.Errorf("invalid forward %q: bad port %q", spec, port)
+		}
+	}
+	return f, nil
+}
+
+// Allowed reports whether target matches one of the HOST:PORT patterns,
+// which may use * and the other path.Match wildcards in either part
+func Allowed(target string, patterns []string) bool {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		phost, pport, err := net.SplitHostPort(pattern)
+		if err != nil {
+			continue
+		}
+		hostOK, _ := path.Match(phost, host)
+		portOK, _ := path.Match(pport, port)
+		if hostOK && portOK {
+			return true
+		}
+	}
+	return false
+}
+
+// Mux runs the streams of one tunnel
+type Mux struct {
+	send func([]byte) error
+	dial func(target string) (io.ReadWriteCloser, error)
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	opening map[uint32]chan error
+	nextID  uint32
+	closed  bool
+}
+
+// NewMux returns a mux sending frames with send. dial connects the streams
+// the peer opens; a mux without it refuses them.
+func NewMux(send func([]byte) error, dial func(target string) (io.ReadWriteCloser, error)) *Mux {
+	return &Mux{
+		send:    send,
+		dial:    dial,
+		streams: make(map[uint32]*Stream),
+		opening: make(map[uint32]chan error),
+	}
+}
+
+// sendFrame sends one frame
+func (m *Mux) sendFrame(kind byte, id uint32, payload []byte) error {
+	frame := make([]byte, headerSize+len(payload))
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[1:], id)
+	copy(frame[headerSize:], payload)
+	return m.send(frame)
+}
+
+// Open asks the peer to connect a new stream to target and waits for it
+func (m *Mux) Open(target string) (*Stream, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrClosed
+	}
+	m.nextID++
+	s := newStream(m, m.nextID)
+	result := make(chan error, 1)
+	m.streams[s.id], m.opening[s.id] = s, result
+	m.mu.Unlock()
+
+	if err := m.sendFrame(frameOpen, s.id, []byte(target)); err != nil {
+		m.remove(s.id)
+		return nil, err
+	}
+	if err := <-result; err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// remove forgets a stream
+func (m *Mux) remove(id uint32) {
+	m.mu.Lock()
+	delete(m.streams, id)
+	delete(m.opening, id)
+	m.mu.Unlock()
+}
+
+// Handle processes a frame received from the peer. It never blocks, so it can
+// be called from the channel's message handler.
+func (m *Mux) Handle(frame []byte) {
+	if len(frame) < headerSize {
+		return
+	}
+	kind, id, payload := frame[0], binary.BigEndian.Uint32(frame[1:]), frame[headerSize:]
+
+	if kind == frameOpen {
+		go m.accept(id, string(payload))
+		return
+	}
+
+	m.mu.Lock()
+	s := m.streams[id]
+	result := m.opening[id]
+	if kind == frameOpened || kind == frameClose {
+		delete(m.opening, id)
+	}
+	if kind == frameClose {
+		delete(m.streams, id)
+	}
+	m.mu.Unlock()
+	if s == nil {
+		return
+	}
+
+	switch kind {
+	case frameOpened:
+		if result != nil {
+			result <- nil
+		}
+	case frameData:
+		if !s.push(payload) {
+			// The peer ignored the window
+			m.remove(id)
+			s.fail(errors.New("peer exceeded the flow control window"))
+			m.sendFrame(frameClose, id, []byte("flow control window exceeded"))
+		}
+	case frameWindow:
+		if len(payload) == 4 {
+			s.grant(int(binary.BigEndian.Uint32(payload)))
+		}
+	case frameClose:
+		var err error
+		if len(payload) > 0 {
+			err = errors.New(string(payload))
+		}
+		if result != nil {
+			if err == nil {
+				err = ErrRefused
+			} else {
+				err = fmt.Errorf("%w: %w", ErrRefused, err)
+			}
+			result <- err
+		}
+		s.fail(err)
+	}
+}
+
+// accept connects a stream the peer opened and joins it with the connection
+func (m *Mux) accept(id uint32, target string) {
+	if m.dial == nil {
+		m.sendFrame(frameClose, id, []byte("tunnels are not accepted"))
+		return
+	}
+	conn, err := m.dial(target)
+	if err != nil {
+		m.sendFrame(frameClose, id, []byte(err.Error()))
+		return
+	}
+
+	m.mu.Lock()
+	if m.closed || m.streams[id] != nil {
+		m.mu.Unlock()
+		conn.Close()
+		m.sendFrame(frameClose, id, []byte("stream already open"))
+		return
+	}
+	s := newStream(m, id)
+	m.streams[id] = s
+	m.mu.Unlock()
+
+	if err := m.sendFrame(frameOpened, id, nil); err != nil {
+		m.remove(id)
+		conn.Close()
+		return
+	}
+	Join(s, conn)
+}
+
+// Close ends every stream, for when the channel closed
+func (m *Mux) Close() {
+	m.mu.Lock()
+	m.closed = true
+	streams, opening := m.streams, m.opening
+	m.streams, m.opening = make(map[uint32]*Stream), make(map[uint32]chan error)
+	m.mu.Unlock()
+
+	for _, result := range opening {
+		result <- ErrClosed
+	}
+	for _, s := range streams {
+		s.fail(ErrClosed)
+	}
+}
+
+// Stream is one connection carried by the tunnel
+type Stream struct {
+	mux  *Mux
+	id   uint32
+	mu   sync.Mutex
+	cond *sync.Cond
+	// buf holds received data not read yet
+	buf []byte
+	// window is how much the stream may still send
+	window int
+	// closed is set once either side closed the stream, err if it failed
+	closed bool
+	err    error
+}
+
+func newStream(m *Mux, id uint32) *Stream {
+	s := &Stream{mux: m, id: id, window: Window}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// push queues received data, reporting false if it exceeds the window
+func (s *Stream) push(data []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return true
+	}
+	if len(s.buf)+len(data) > Window {
+		return false
+	}
+	s.buf = append(s.buf, data...)
+	s.cond.Broadcast()
+	return true
+}
+
+// grant lets the stream send n more bytes
+func (s *Stream) grant(n int) {
+	s.mu.Lock()
+	s.window += n
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// fail marks the stream closed by the peer, keeping the data already received
+func (s *Stream) fail(err error) {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed, s.err = true, err
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// Read reads received data, returning io.EOF once the stream closed and
+// everything received was read
+func (s *Stream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	for len(s.buf) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if len(s.buf) == 0 {
+		err := s.err
+		s.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	s.mu.Unlock()
+
+	// Let the peer send as much again
+	var grant [4]byte
+	binary.BigEndian.PutUint32(grant[:], uint32(n))
+	s.mux.sendFrame(frameWindow, s.id, grant[:])
+	return n, nil
+}
+
+// Write sends p, waiting while the stream's window is used up
+func (s *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		s.mu.Lock()
+		for s.window == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		n := min(len(p), s.window, maxPayload)
+		s.window -= n
+		s.mu.Unlock()
+
+		if err := s.mux.sendFrame(frameData, s.id, p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close ends the stream on both sides
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	wasClosed := s.closed
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	s.mux.remove(s.id)
+	if wasClosed {
+		return nil
+	}
+	return s.mux.sendFrame(frameClose, s.id, nil)
+}
+
+// Join copies between a stream and a connection until either closes, then
+// closes both, returning the bytes sent into and received from the stream
+func Join(s *Stream, conn io.ReadWriteCloser) (sent, received int64) {
+	done := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(conn, s)
+		conn.Close()
+		s.Close()
+		done <- n
+	}()
+	sent, _ = io.Copy(s, conn)
+	s.Close()
+	conn.Close()
+	return sent, <-done
+}
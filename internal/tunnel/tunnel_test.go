@@ -0,0 +1,162 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseForward(t *testing.T) {
+	valid := map[string]Forward{
+		"8443:internal:443":             {Listen: "localhost:8443", Target: "internal:443"},
+		"0.0.0.0:8443:internal:443":     {Listen: "0.0.0.0:8443", Target: "internal:443"},
+		"[::1]:8443:[fd00::1]:443":      {Listen: "[::1]:8443", Target: "[fd00::1]:443"},
+		"5432:db.internal.example:5432": {Listen: "localhost:5432", Target: "db.internal.example:5432"},
+	}
+	for spec, want := range valid {
+		got, err := ParseForward(spec)
+		if err != nil {
+			t.Errorf("ParseForward(%q) returned error: %v", spec, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Expected %+v for %q, got %+v", want, spec, got)
+		}
+	}
+
+	for _, spec := range []string{"", "8443", "8443:internal", "x:internal:443", "8443:internal:99999", "[::1:8443:h:1", "a:b:c:d:e"} {
+		if _, err := ParseForward(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	patterns := []string{"internal:443", "*.db.example:5432", "10.0.0.*:*"}
+	for target, want := range map[string]bool{
+		"internal:443":         true,
+		"internal:80":          false,
+		"main.db.example:5432": true,
+		"main.db.example:22":   false,
+		"10.0.0.7:22":          true,
+		"10.0.1.7:22":          false,
+		"not an address":       false,
+	} {
+		if got := Allowed(target, patterns); got != want {
+			t.Errorf("Expected Allowed(%q) to be %v", target, want)
+		}
+	}
+	if Allowed("internal:443", nil) {
+		t.Error("Expected nothing to be allowed without patterns")
+	}
+}
+
+// pair connects two muxes, delivering frames in order like a data channel
+func pair(dial func(string) (io.ReadWriteCloser, error)) (client, server *Mux, stop func()) {
+	toServer, toClient := make(chan []byte, 1024), make(chan []byte, 1024)
+	client = NewMux(func(f []byte) error { toServer <- f; return nil }, nil)
+	server = NewMux(func(f []byte) error { toClient <- f; return nil }, dial)
+	done := make(chan struct{})
+	deliver := func(frames chan []byte, m *Mux) {
+		for {
+			select {
+			case f := <-frames:
+				m.Handle(f)
+			case <-done:
+				return
+			}
+		}
+	}
+	go deliver(toServer, server)
+	go deliver(toClient, client)
+	return client, server, func() { close(done) }
+}
+
+// echo dials an in-memory connection that echoes everything back
+func echo(target string) (io.ReadWriteCloser, error) {
+	if target != "echo:7" {
+		return nil, errors.New("connection refused")
+	}
+	local, remote := net.Pipe()
+	go func() {
+		io.Copy(remote, remote)
+		remote.Close()
+	}()
+	return local, nil
+}
+
+func TestMux(t *testing.T) {
+	client, _, stop := pair(echo)
+	defer stop()
+
+	t.Run("Echo", func(t *testing.T) {
+		// Several times the window, so the transfer depends on window grants
+		data := make([]byte, 4*Window+123)
+		rand.Read(data)
+
+		streams := make([]*Stream, 3)
+		for i := range streams {
+			s, err := client.Open("echo:7")
+			if err != nil {
+				t.Fatalf("Open returned error: %v", err)
+			}
+			streams[i] = s
+		}
+		for _, s := range streams {
+			go s.Write(data)
+		}
+		for _, s := range streams {
+			got := make([]byte, len(data))
+			if _, err := io.ReadFull(s, got); err != nil {
+				t.Fatalf("Failed to read the echo: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Error("Expected the echo to match the data sent")
+			}
+			s.Close()
+		}
+	})
+
+	t.Run("Refused", func(t *testing.T) {
+		if _, err := client.Open("elsewhere:22"); !errors.Is(err, ErrRefused) || !strings.Contains(err.Error(), "connection refused") {
+			t.Errorf("Expected the dial error, got %v", err)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		s, err := client.Open("echo:7")
+		if err != nil {
+			t.Fatalf("Open returned error: %v", err)
+		}
+		read := make(chan error, 1)
+		go func() {
+			_, err := s.Read(make([]byte, 1))
+			read <- err
+		}()
+		client.Close()
+		select {
+		case err := <-read:
+			if !errors.Is(err, ErrClosed) {
+				t.Errorf("Expected ErrClosed, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected Close to end pending reads")
+		}
+		if _, err := client.Open("echo:7"); !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed from a closed mux, got %v", err)
+		}
+	})
+}
+
+func TestRefuseWithoutDial(t *testing.T) {
+	client, _, stop := pair(nil)
+	defer stop()
+	if _, err := client.Open("echo:7"); !errors.Is(err, ErrRefused) {
+		t.Errorf("Expected a mux without dial to refuse streams, got %v", err)
+	}
+}
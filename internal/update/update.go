@@ -0,0 +1,389 @@
+// Package update replaces the running binary with the latest release. A
+// release is the set of archives goreleaser publishes, with a checksums.txt
+// listing their SHA-256 and a checksums.txt.sig naming the release version
+// and signing it together with the checksums with a release identity, an
+// Ed25519 key like the peers' own. Only archives whose checksum is listed in
+// a checksums file signed by the configured identity are installed, so a
+// compromised download location cannot push binaries to the fleet, and since
+// the version is signed too it cannot replay an older signed release to roll
+// the fleet back either. The new binary is written next to the old one and
+// renamed over it, so an interrupted update leaves the old binary in place.
+package update
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/developmeh/webrtc-poc/internal/identity"
+)
+
+const (
+	// ChecksumsFile lists the SHA-256 of every archive of a release
+	ChecksumsFile = "checksums.txt"
+	// SignatureFile signs ChecksumsFile
+	SignatureFile = ChecksumsFile + ".sig"
+	// signingContext separates release signatures from other bindings made
+	// with the same kind of key
+	signingContext = "webrtc-poc-release-v1"
+	// binaryName is the name of the binary in the archives
+	binaryName = "webrtc-poc"
+	// maxDownload bounds every file downloaded
+	maxDownload = 256 << 20
+)
+
+var (
+	// ErrUnsigned is wrapped by the errors of checksum files that are not
+	// signed by the release identity
+	ErrUnsigned = errors.New("release is not signed by the release identity")
+	// ErrChecksum is wrapped by the error of a download whose checksum does
+	// not match the signed one
+	ErrChecksum = errors.New("checksum mismatch")
+	// ErrNoArchive is returned when a release has no archive for a platform
+	ErrNoArchive = errors.New("no archive for this platform")
+	// ErrVersion is wrapped by the errors of versions that are not semantic
+	// versions
+	ErrVersion = errors.New("malformed version")
+)
+
+// manifest returns what the signature of a release signs: its version, then
+// its checksums
+func manifest(version string, checksums []byte) []byte {
+	return append([]byte("version "+version+"\n"), checksums...)
+}
+
+// Sign returns the signature file of the checksums file of version
+func Sign(id *identity.Identity, version string, checksums []byte) ([]byte, error) {
+	if _, err := parseVersion(version); err != nil {
+		return nil, err
+	}
+	binding := id.Bind(signingContext, manifest(version, checksums))
+	return []byte("version " + version + "\n" + base64.StdEncoding.EncodeToString(binding) + "\n"), nil
+}
+
+// Verify checks that signature signs checksums with the release identity key
+// and returns the version it signs them for
+func Verify(checksums, signature []byte, key string) (string, error) {
+	line, encoded, _ := strings.Cut(string(signature), "\n")
+	version, ok := strings.CutPrefix(line, "version ")
+	if !ok {
+		return "", fmt.Errorf("%w: no version in the signature", ErrUnsigned)
+	}
+	if _, err := parseVersion(version); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsigned, err)
+	}
+	binding, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed signature", ErrUnsigned)
+	}
+	signer, err := identity.VerifyBinding(signingContext, manifest(version, checksums), binding)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsigned, err)
+	}
+	if signer != key {
+		return "", fmt.Errorf("%w: signed by %s", ErrUnsigned, signer)
+	}
+	return version, nil
+}
+
+// semver is a parsed semantic version
+type semver struct {
+	core [3]int
+	pre  []string
+}
+
+// parseVersion parses a semantic version like goreleaser's, with or without
+// a leading v; build metadata is ignored
+func parseVersion(version string) (semver, error) {
+	var v semver
+	rest, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), "+")
+	rest, pre, hasPre := strings.Cut(rest, "-")
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("%w %q", ErrVersion, version)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || part != strconv.Itoa(n) {
+			return v, fmt.Errorf("%w %q", ErrVersion, version)
+		}
+		v.core[i] = n
+	}
+	if hasPre {
+		v.pre = strings.Split(pre, ".")
+		for _, id := range v.pre {
+			if id == "" {
+				return v, fmt.Errorf("%w %q", ErrVersion, version)
+			}
+		}
+	}
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 as v sorts before, with or after o
+func (v semver) compare(o semver) int {
+	for i := range v.core {
+		if v.core[i] != o.core[i] {
+			return cmp.Compare(v.core[i], o.core[i])
+		}
+	}
+	// A pre-release sorts before its release
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, aErr := strconv.Atoi(v.pre[i])
+		b, bErr := strconv.Atoi(o.pre[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if a != b {
+				return cmp.Compare(a, b)
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		case v.pre[i] != o.pre[i]:
+			return strings.Compare(v.pre[i], o.pre[i])
+		}
+	}
+	return cmp.Compare(len(v.pre), len(o.pre))
+}
+
+// Newer reports whether release is a newer version than current. Binaries
+// not built by goreleaser, whose version is not a semantic version, are
+// older than every release.
+func Newer(release, current string) bool {
+	r, err := parseVersion(release)
+	if err != nil {
+		return false
+	}
+	c, err := parseVersion(current)
+	if err != nil {
+		return true
+	}
+	return r.compare(c) > 0
+}
+
+// ArchiveName returns the name of the archive goreleaser builds for a
+// platform, following its name_template
+func ArchiveName(goos, goarch string) string {
+	arch := goarch
+	switch goarch {
+	case "amd64":
+		arch = "x86_64"
+	case "386":
+		arch = "i386"
+	}
+	ext := ".tar.gz"
+	if goos == "windows" {
+		ext = ".zip"
+	}
+	return binaryName + "_" + strings.ToUpper(goos[:1]) + goos[1:] + "_" + arch + ext
+}
+
+// ParseChecksums parses a checksums file of "SHA256  NAME" lines into the
+// checksums by name
+func ParseChecksums(data []byte) (map[string]string, error) {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("malformed checksums line %q", scanner.Text())
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return sums, scanner.Err()
+}
+
+// Release is the archive of the latest release for this platform
+type Release struct {
+	// Version is the signed version of the release
+	Version string
+	// Archive is the name of the archive
+	Archive string
+	// URL is where the archive is downloaded from
+	URL string
+	// SHA256 is the signed checksum of the archive
+	SHA256 string
+}
+
+// Latest fetches the checksums of the release at baseURL, checks that they
+// are signed by key and returns the archive for goos and goarch. Only the
+// checksums and their signature are downloaded.
+func Latest(client *http.Client, baseURL, key, goos, goarch string) (Release, error) {
+	base := strings.TrimSuffix(baseURL, "/")
+	checksums, err := fetch(client, base+"/"+ChecksumsFile)
+	if err != nil {
+		return Release{}, err
+	}
+	signature, err := fetch(client, base+"/"+SignatureFile)
+	if err != nil {
+		return Release{}, err
+	}
+	version, err := Verify(checksums, signature, key)
+	if err != nil {
+		return Release{}, err
+	}
+
+	sums, err := ParseChecksums(checksums)
+	if err != nil {
+		return Release{}, err
+	}
+	name := ArchiveName(goos, goarch)
+	sum, ok := sums[name]
+	if !ok {
+		return Release{}, fmt.Errorf("%w: %s", ErrNoArchive, name)
+	}
+	return Release{Version: version, Archive: name, URL: base + "/" + name, SHA256: sum}, nil
+}
+
+// Binary downloads the archive, checks it against the signed checksum and
+// returns the binary in it
+func (r Release) Binary(client *http.Client) ([]byte, error) {
+	archive, err := fetch(client, r.URL)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(archive)
+	if got := hex.EncodeToString(sum[:]); got != r.SHA256 {
+		return nil, fmt.Errorf("%w: %s has SHA-256 %s, the release lists %s", ErrChecksum, r.Archive, got, r.SHA256)
+	}
+	if strings.HasSuffix(r.Archive, ".zip") {
+		return extractZip(archive)
+	}
+	return extractTarGz(archive)
+}
+
+// fetch downloads a file
+func fetch(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownload+1))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %w", url, err)
+	}
+	if len(data) > maxDownload {
+		return nil, fmt.Errorf("error downloading %s: larger than %d bytes", url, maxDownload)
+	}
+	return data, nil
+}
+
+// isBinary reports whether an archive entry is the binary
+func isBinary(name string) bool {
+	base := path.Base(name)
+	return base == binaryName || base == binaryName+".exe"
+}
+
+// extractTarGz returns the binary in a .tar.gz archive
+func extractTarGz(archive []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s in the archive", binaryName)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive: %w", err)
+		}
+		if header.Typeflag == tar.TypeReg && isBinary(header.Name) {
+			return io.ReadAll(io.LimitReader(tr, maxDownload))
+		}
+	}
+}
+
+// extractZip returns the binary in a .zip archive
+func extractZip(archive []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive: %w", err)
+	}
+	for _, f := range zr.File {
+		if f.Mode().IsRegular() && isBinary(f.Name) {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("error reading archive: %w", err)
+			}
+			defer rc.Close()
+			return io.ReadAll(io.LimitReader(rc, maxDownload))
+		}
+	}
+	return nil, fmt.Errorf("no %s in the archive", binaryName)
+}
+
+// Replace atomically replaces the binary at path with binary, keeping its
+// permissions. Windows cannot replace a running binary, so there the old one
+// is moved aside to path.old first and removed by the next update.
+func Replace(path string, binary []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".update-*")
+	if err != nil {
+		return fmt.Errorf("error creating the new binary: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing the new binary: %w", err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing the new binary: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing the new binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing the new binary: %w", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		old := path + ".old"
+		os.Remove(old)
+		if err := os.Rename(path, old); err != nil {
+			return fmt.Errorf("error moving the old binary aside: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing the binary: %w", err)
+	}
+	return nil
+}
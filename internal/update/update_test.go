@@ -0,0 +1,261 @@
+package update
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/developmeh/webrtc-poc/internal/identity"
+)
+
+func newTestIdentity(t *testing.T) *identity.Identity {
+	t.Helper()
+	id, err := identity.LoadOrCreate(filepath.Join(t.TempDir(), "release.key"))
+	if err != nil {
+		t.Fatalf("LoadOrCreate returned error: %v", err)
+	}
+	return id
+}
+
+// tarGz returns a .tar.gz archive laid out like goreleaser's
+func tarGz(t *testing.T, binary []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range map[string][]byte{"README.md": []byte("readme\n"), binaryName: binary} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// release serves version of a release of archives, signed by id, and
+// returns the server and a function listing the files downloaded so far
+func release(t *testing.T, id *identity.Identity, version string, archives map[string][]byte) (*httptest.Server, func() []string) {
+	t.Helper()
+	var checksums bytes.Buffer
+	files := make(map[string][]byte)
+	for name, data := range archives {
+		fmt.Fprintf(&checksums, "%x  %s\n", sha256.Sum256(data), name)
+		files["/"+name] = data
+	}
+	files["/"+ChecksumsFile] = checksums.Bytes()
+	signature, err := Sign(id, version, checksums.Bytes())
+	if err != nil {
+		t.Fatalf("Sign returned error: %v", err)
+	}
+	files["/"+SignatureFile] = signature
+
+	var mu sync.Mutex
+	var downloaded []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		downloaded = append(downloaded, r.URL.Path)
+		mu.Unlock()
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, downloaded...)
+	}
+}
+
+func TestArchiveName(t *testing.T) {
+	for platform, want := range map[[2]string]string{
+		{"linux", "amd64"}:   "webrtc-poc_Linux_x86_64.tar.gz",
+		{"darwin", "arm64"}:  "webrtc-poc_Darwin_arm64.tar.gz",
+		{"windows", "amd64"}: "webrtc-poc_Windows_x86_64.zip",
+	} {
+		if got := ArchiveName(platform[0], platform[1]); got != want {
+			t.Errorf("Expected %s for %v, got %s", want, platform, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	signer := newTestIdentity(t)
+	checksums := []byte("0000  webrtc-poc_Linux_x86_64.tar.gz\n")
+	signature, err := Sign(signer, "1.2.0", checksums)
+	if err != nil {
+		t.Fatalf("Sign returned error: %v", err)
+	}
+
+	if version, err := Verify(checksums, signature, signer.ID()); err != nil || version != "1.2.0" {
+		t.Errorf("Expected version 1.2.0, got %q, %v", version, err)
+	}
+	if _, err := Verify(append(checksums, '\n'), signature, signer.ID()); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected ErrUnsigned for altered checksums, got %v", err)
+	}
+	// The version is signed with the checksums, so an older release cannot
+	// be passed off as a newer one
+	replayed := bytes.Replace(signature, []byte("1.2.0"), []byte("1.3.0"), 1)
+	if _, err := Verify(checksums, replayed, signer.ID()); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected ErrUnsigned for an altered version, got %v", err)
+	}
+	if _, err := Verify(checksums, signature, newTestIdentity(t).ID()); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected ErrUnsigned for another signer, got %v", err)
+	}
+	if _, err := Verify(checksums, []byte("version 1.2.0\nnot base64!"), signer.ID()); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected ErrUnsigned for a malformed signature, got %v", err)
+	}
+	if _, err := Verify(checksums, bytes.SplitN(signature, []byte("\n"), 2)[1], signer.ID()); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected ErrUnsigned for a signature without a version, got %v", err)
+	}
+	if _, err := Sign(signer, "dev", checksums); !errors.Is(err, ErrVersion) {
+		t.Errorf("Expected ErrVersion for a version that is not semantic, got %v", err)
+	}
+}
+
+func TestNewer(t *testing.T) {
+	for _, tt := range []struct {
+		release, current string
+		want             bool
+	}{
+		{"1.2.1", "1.2.0", true},
+		{"1.10.0", "1.9.9", true},
+		{"2.0.0", "v1.9.0", true},
+		{"1.2.0", "1.2.0", false},
+		{"v1.2.0", "1.2.0", false},
+		{"1.1.9", "1.2.0", false},
+		{"1.2.0", "1.2.0-rc.1", true},
+		{"1.2.0-rc.2", "1.2.0-rc.1", true},
+		{"1.2.0-rc.10", "1.2.0-rc.9", true},
+		{"1.2.0-rc.1", "1.2.0", false},
+		{"1.2.1-next", "1.2.0", true},
+		{"1.2.0+build.5", "1.2.0", false},
+		{"1.0.0", "dev", true},
+		{"dev", "1.0.0", false},
+	} {
+		if got := Newer(tt.release, tt.current); got != tt.want {
+			t.Errorf("Newer(%q, %q) = %v, want %v", tt.release, tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	signer := newTestIdentity(t)
+	binary := []byte("#!/bin/sh\necho new\n")
+	linux := ArchiveName("linux", "amd64")
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, _ := zw.Create(binaryName + ".exe")
+	w.Write(binary)
+	zw.Close()
+	windows := ArchiveName("windows", "amd64")
+
+	srv, downloaded := release(t, signer, "1.2.0", map[string][]byte{linux: tarGz(t, binary), windows: zipped.Bytes()})
+
+	t.Run("TarGz", func(t *testing.T) {
+		rel, err := Latest(srv.Client(), srv.URL+"/", signer.ID(), "linux", "amd64")
+		if err != nil {
+			t.Fatalf("Latest returned error: %v", err)
+		}
+		if rel.Version != "1.2.0" || rel.Archive != linux || rel.URL != srv.URL+"/"+linux {
+			t.Errorf("Expected the linux archive, got %+v", rel)
+		}
+		got, err := rel.Binary(srv.Client())
+		if err != nil {
+			t.Fatalf("Binary returned error: %v", err)
+		}
+		if !bytes.Equal(got, binary) {
+			t.Errorf("Expected the binary, got %q", got)
+		}
+	})
+
+	t.Run("MetadataOnly", func(t *testing.T) {
+		before := len(downloaded())
+		if _, err := Latest(srv.Client(), srv.URL, signer.ID(), "linux", "amd64"); err != nil {
+			t.Fatalf("Latest returned error: %v", err)
+		}
+		got := downloaded()[before:]
+		want := []string{"/" + ChecksumsFile, "/" + SignatureFile}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("Expected Latest to download only %v, got %v", want, got)
+		}
+	})
+
+	t.Run("Zip", func(t *testing.T) {
+		rel, err := Latest(srv.Client(), srv.URL, signer.ID(), "windows", "amd64")
+		if err != nil {
+			t.Fatalf("Latest returned error: %v", err)
+		}
+		if got, err := rel.Binary(srv.Client()); err != nil || !bytes.Equal(got, binary) {
+			t.Errorf("Expected the binary, got %q, %v", got, err)
+		}
+	})
+
+	t.Run("Unsigned", func(t *testing.T) {
+		_, err := Latest(srv.Client(), srv.URL, newTestIdentity(t).ID(), "linux", "amd64")
+		if !errors.Is(err, ErrUnsigned) {
+			t.Errorf("Expected ErrUnsigned, got %v", err)
+		}
+	})
+
+	t.Run("NoArchive", func(t *testing.T) {
+		_, err := Latest(srv.Client(), srv.URL, signer.ID(), "darwin", "arm64")
+		if !errors.Is(err, ErrNoArchive) {
+			t.Errorf("Expected ErrNoArchive, got %v", err)
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		rel, err := Latest(srv.Client(), srv.URL, signer.ID(), "linux", "amd64")
+		if err != nil {
+			t.Fatalf("Latest returned error: %v", err)
+		}
+		rel.URL = srv.URL + "/" + windows
+		if _, err := rel.Binary(srv.Client()); !errors.Is(err, ErrChecksum) {
+			t.Errorf("Expected ErrChecksum, got %v", err)
+		}
+	})
+}
+
+func TestReplace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), binaryName)
+	if err := os.WriteFile(path, []byte("old"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := Replace(path, []byte("new")); err != nil {
+		t.Fatalf("Replace returned error: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "new" {
+		t.Errorf("Expected the new binary, got %q, %v", data, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("Expected the mode to be kept, got %v, %v", info.Mode(), err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files left, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,284 @@
+// Package upload receives the files clients upload over their connection.
+// An upload is a data channel the client opens with Protocol as its
+// subprotocol and the file's name as its label. The client sends the file's
+// lines as text messages and a binary share.Result with the number of lines
+// as the last message; the server answers with a Result of its own once the
+// file was accepted, naming the file it was stored as, or rejected. Every upload is written to a quarantine
+// directory first and only moved into the destination directory once every
+// validator accepted it, so the destination never holds a partial or
+// rejected file. Names are sanitized into plain file names, and a Collision
+// policy decides what happens to uploads whose name is already taken. With
+// PerTenant every tenant, the client identity on this server, uploads into
+// its own directory below the destination.
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Protocol marks the data channels that upload a file
+const Protocol = "webrtc-poc-upload"
+
+// Linger is how long either side waits for the lines of a rejected upload
+// to be sent before the channel is closed
+const Linger = 10 * time.Second
+
+var (
+	// ErrDisabled is returned when the server does not accept uploads
+	ErrDisabled = errors.New("server does not accept uploads")
+	// ErrRejected wraps the reason a validator rejected an upload
+	ErrRejected = errors.New("upload rejected")
+	// ErrQuota is wrapped with ErrRejected for uploads over the size limit
+	ErrQuota = errors.New("size limit exceeded")
+	// ErrExists is returned when the destination file already exists
+	ErrExists = errors.New("file already exists")
+	// ErrInvalidName is returned for names that sanitize to nothing usable
+	ErrInvalidName = errors.New("invalid file name")
+	// ErrNoTenant is returned when uploads are kept per tenant and the
+	// client has none
+	ErrNoTenant = errors.New("uploads require a client identity")
+)
+
+// Collision decides what happens to an upload whose name already exists in
+// the destination
+type Collision string
+
+const (
+	// Reject fails the upload with ErrExists and keeps the existing file
+	Reject Collision = "reject"
+	// Overwrite replaces the existing file
+	Overwrite Collision = "overwrite"
+	// Rename stores the upload under the first free name with a numeric
+	// suffix, app-1.log for app.log
+	Rename Collision = "rename"
+)
+
+const (
+	// maxName is the longest file name most file systems accept
+	maxName = 255
+	// maxRenames bounds the suffixes Rename tries
+	maxRenames = 1000
+)
+
+// ParseCollision parses a collision policy, defaulting to Reject
+func ParseCollision(s string) (Collision, error) {
+	switch c := Collision(s); c {
+	case "":
+		return Reject, nil
+	case Reject, Overwrite, Rename:
+		return c, nil
+	}
+	return "", fmt.Errorf("invalid upload collision policy %q (expected reject, overwrite or rename)", s)
+}
+
+// Sanitize turns a client-supplied name into a plain file name. Everything
+// up to the last slash or backslash and control characters are removed, as
+// are leading dots, which would hide the file, and surrounding spaces.
+func Sanitize(name string) (string, error) {
+	clean := name[strings.LastIndexAny(name, `/\`)+1:]
+	clean = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, clean)
+	clean = strings.TrimRight(strings.TrimLeft(clean, ". "), " ")
+	if clean == "" {
+		return "", fmt.Errorf("%w %q", ErrInvalidName, name)
+	}
+	if len(clean) > maxName {
+		return "", fmt.Errorf("%w: longer than %d bytes", ErrInvalidName, maxName)
+	}
+	return clean, nil
+}
+
+// Validator checks a quarantined upload, returning an error wrapping
+// ErrRejected if it must not be accepted
+type Validator func(path string) error
+
+// Pipeline writes uploads to Quarantine, runs the Validators on them and
+// moves the accepted ones into Dest
+type Pipeline struct {
+	Dest       string
+	Quarantine string
+	// Limit is the largest accepted upload in bytes, enforced while it is
+	// written; zero accepts any size
+	Limit      int64
+	Validators []Validator
+	Collision  Collision
+	// PerTenant keeps every tenant's uploads in a directory of Dest named
+	// after the tenant
+	PerTenant bool
+}
+
+// Upload is a file being received into quarantine
+type Upload struct {
+	pipeline *Pipeline
+	name     string
+	dest     string
+	file     *os.File
+	size     int64
+}
+
+// Begin starts receiving the file name, uploaded by tenant, into quarantine
+func (p *Pipeline) Begin(tenant, name string) (*Upload, error) {
+	if p == nil || p.Dest == "" {
+		return nil, ErrDisabled
+	}
+	name, err := Sanitize(name)
+	if err != nil {
+		return nil, err
+	}
+	dir := p.Dest
+	if p.PerTenant {
+		if tenant == "" {
+			return nil, ErrNoTenant
+		}
+		if clean, err := Sanitize(tenant); err != nil || clean != tenant {
+			return nil, fmt.Errorf("invalid tenant %q", tenant)
+		}
+		dir = filepath.Join(p.Dest, tenant)
+	}
+	if err := os.MkdirAll(p.Quarantine, 0700); err != nil {
+		return nil, fmt.Errorf("error creating quarantine directory: %w", err)
+	}
+	file, err := os.CreateTemp(p.Quarantine, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating quarantine file: %w", err)
+	}
+	return &Upload{pipeline: p, name: name, dest: filepath.Join(dir, name), file: file}, nil
+}
+
+// WriteLine appends a line to the upload
+func (u *Upload) WriteLine(line string) error {
+	u.size += int64(len(line)) + 1
+	if limit := u.pipeline.Limit; limit > 0 && u.size > limit {
+		return fmt.Errorf("%w: %w, the limit is %d bytes", ErrRejected, ErrQuota, limit)
+	}
+	if _, err := io.WriteString(u.file, line+"\n"); err != nil {
+		return fmt.Errorf("error writing quarantine file: %w", err)
+	}
+	return nil
+}
+
+// Commit validates the upload and moves it into the destination directory
+// as the collision policy allows, returning its path there. A rejected
+// upload is removed from quarantine.
+func (u *Upload) Commit() (string, error) {
+	path := u.file.Name()
+	defer os.Remove(path)
+	if err := u.file.Sync(); err != nil {
+		u.file.Close()
+		return "", fmt.Errorf("error writing quarantine file: %w", err)
+	}
+	if err := u.file.Close(); err != nil {
+		return "", fmt.Errorf("error writing quarantine file: %w", err)
+	}
+
+	for _, validate := range u.pipeline.Validators {
+		if err := validate(path); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.Chmod(path, 0644); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(u.dest), 0755); err != nil {
+		return "", fmt.Errorf("error creating destination directory: %w", err)
+	}
+	return place(path, u.dest, u.pipeline.Collision)
+}
+
+// Abort discards the upload
+func (u *Upload) Abort() {
+	u.file.Close()
+	os.Remove(u.file.Name())
+}
+
+// place moves src to dst atomically as collision allows and returns where
+// it ended up. Across file systems the file is copied next to dst first and
+// moved from there.
+func place(src, dst string, collision Collision) (string, error) {
+	path, err := move(src, dst, collision)
+	if errors.Is(err, syscall.EXDEV) {
+		var tmp string
+		if tmp, err = copyNextTo(src, dst); err != nil {
+			return "", err
+		}
+		defer os.Remove(tmp)
+		path, err = move(tmp, dst, collision)
+	}
+	if err != nil && err != ErrExists {
+		return "", fmt.Errorf("error moving upload into place: %w", err)
+	}
+	return path, err
+}
+
+// move moves src to dst within a file system. Overwrite renames it over an
+// existing file; otherwise it is hard linked, which only appears once
+// complete and never replaces a file.
+func move(src, dst string, collision Collision) (string, error) {
+	switch collision {
+	case Overwrite:
+		return dst, os.Rename(src, dst)
+	case Rename:
+		ext := filepath.Ext(dst)
+		base := strings.TrimSuffix(dst, ext)
+		path := dst
+		for i := 1; ; i++ {
+			err := link(src, path)
+			if err != ErrExists || i > maxRenames {
+				return path, err
+			}
+			path = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+	}
+	return dst, link(src, dst)
+}
+
+// link hard links src to dst, failing with ErrExists if dst exists
+func link(src, dst string) error {
+	err := os.Link(src, dst)
+	if errors.Is(err, fs.ErrExist) {
+		return ErrExists
+	}
+	return err
+}
+
+// copyNextTo copies src into a temporary file in dst's directory
+func copyNextTo(src, dst string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("error copying upload into place: %w", err)
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(out.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("error copying upload into place: %w", err)
+	}
+	return out.Name(), nil
+}
@@ -0,0 +1,280 @@
+package upload
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receive uploads lines as name through p, without a tenant
+func receive(p *Pipeline, name string, lines ...string) (string, error) {
+	u, err := p.Begin("", name)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range lines {
+		if err := u.WriteLine(line); err != nil {
+			u.Abort()
+			return "", err
+		}
+	}
+	return u.Commit()
+}
+
+// newPipeline returns a pipeline with its directories in a temporary
+// directory
+func newPipeline(t *testing.T) *Pipeline {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "upload-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return &Pipeline{Dest: filepath.Join(dir, "dest"), Quarantine: filepath.Join(dir, "quarantine")}
+}
+
+// quarantined returns the files left in quarantine
+func quarantined(t *testing.T, p *Pipeline) []os.DirEntry {
+	t.Helper()
+	entries, err := os.ReadDir(p.Quarantine)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("Failed to read quarantine: %v", err)
+	}
+	return entries
+}
+
+func TestCommit(t *testing.T) {
+	t.Run("Accepted", func(t *testing.T) {
+		p := newPipeline(t)
+		u, err := p.Begin("", "app.log")
+		if err != nil {
+			t.Fatalf("Begin returned error: %v", err)
+		}
+		u.WriteLine("first")
+
+		// Nothing appears in the destination before the upload is accepted
+		if _, err := os.Stat(filepath.Join(p.Dest, "app.log")); !os.IsNotExist(err) {
+			t.Errorf("Expected no destination file before Commit, got %v", err)
+		}
+		u.WriteLine("second")
+		path, err := u.Commit()
+		if err != nil {
+			t.Fatalf("Commit returned error: %v", err)
+		}
+		if want := filepath.Join(p.Dest, "app.log"); path != want {
+			t.Errorf("Expected %s, got %s", want, path)
+		}
+		data, _ := os.ReadFile(path)
+		if string(data) != "first\nsecond\n" {
+			t.Errorf("Expected both lines, got %q", data)
+		}
+		if left := quarantined(t, p); len(left) != 0 {
+			t.Errorf("Expected an empty quarantine, got %d files", len(left))
+		}
+	})
+
+	t.Run("Existing", func(t *testing.T) {
+		p := newPipeline(t)
+		if _, err := receive(p, "a.txt", "one"); err != nil {
+			t.Fatalf("First upload returned error: %v", err)
+		}
+		if _, err := receive(p, "a.txt", "two"); !errors.Is(err, ErrExists) {
+			t.Errorf("Expected ErrExists, got %v", err)
+		}
+		data, _ := os.ReadFile(filepath.Join(p.Dest, "a.txt"))
+		if string(data) != "one\n" {
+			t.Errorf("Expected the first upload to be kept, got %q", data)
+		}
+	})
+
+	t.Run("Outside destination", func(t *testing.T) {
+		p := newPipeline(t)
+		path, err := receive(p, "../../escape.txt", "line")
+		if err != nil {
+			t.Fatalf("Expected the name to be sanitized, got %v", err)
+		}
+		if want := filepath.Join(p.Dest, "escape.txt"); path != want {
+			t.Errorf("Expected %s, got %s", want, path)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		var p *Pipeline
+		if _, err := p.Begin("", "a.txt"); err != ErrDisabled {
+			t.Errorf("Expected ErrDisabled, got %v", err)
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		p := newPipeline(t)
+		p.Validators = []Validator{func(string) error { return ErrRejected }}
+		if _, err := receive(p, "a.txt", "line"); !errors.Is(err, ErrRejected) {
+			t.Errorf("Expected ErrRejected, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(p.Dest, "a.txt")); !os.IsNotExist(err) {
+			t.Errorf("Expected no destination file, got %v", err)
+		}
+		if left := quarantined(t, p); len(left) != 0 {
+			t.Errorf("Expected the rejected file to be removed, got %d files", len(left))
+		}
+	})
+}
+
+func TestSanitize(t *testing.T) {
+	for name, want := range map[string]string{
+		"app.log":                "app.log",
+		"logs/app.log":           "app.log",
+		"../../etc/passwd":       "passwd",
+		`C:\Users\me\a.txt`:      "a.txt",
+		".quarantine":            "quarantine",
+		"..hidden..":             "hidden..",
+		" spaced name.txt ":      "spaced name.txt",
+		"bell\a\x00\x1b[31m.txt": "bell[31m.txt",
+		"new\nline.txt":          "newline.txt",
+		"bad\xffutf8.txt":        "badutf8.txt",
+		"résumé.pdf":             "résumé.pdf",
+	} {
+		if got, err := Sanitize(name); err != nil || got != want {
+			t.Errorf("Sanitize(%q) = %q, %v, expected %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "logs/", "a/..", " . ", "\x00", strings.Repeat("x", 256)} {
+		if got, err := Sanitize(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Expected Sanitize(%q) to fail, got %q, %v", name, got, err)
+		}
+	}
+}
+
+func TestCollision(t *testing.T) {
+	if c, err := ParseCollision(""); err != nil || c != Reject {
+		t.Errorf("Expected reject by default, got %q, %v", c, err)
+	}
+	if _, err := ParseCollision("append"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+
+	t.Run("Overwrite", func(t *testing.T) {
+		p := newPipeline(t)
+		p.Collision = Overwrite
+		receive(p, "a.txt", "one")
+		path, err := receive(p, "a.txt", "two")
+		if err != nil {
+			t.Fatalf("Expected the upload to overwrite, got %v", err)
+		}
+		if data, _ := os.ReadFile(path); string(data) != "two\n" {
+			t.Errorf("Expected the second upload, got %q", data)
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		p := newPipeline(t)
+		p.Collision = Rename
+		var paths []string
+		for _, line := range []string{"one", "two", "three"} {
+			path, err := receive(p, "app.log", line)
+			if err != nil {
+				t.Fatalf("Expected the upload to be renamed, got %v", err)
+			}
+			paths = append(paths, filepath.Base(path))
+		}
+		if want := []string{"app.log", "app-1.log", "app-2.log"}; strings.Join(paths, " ") != strings.Join(want, " ") {
+			t.Errorf("Expected %v, got %v", want, paths)
+		}
+		if data, _ := os.ReadFile(filepath.Join(p.Dest, "app.log")); string(data) != "one\n" {
+			t.Errorf("Expected the first upload to be kept, got %q", data)
+		}
+	})
+}
+
+func TestPerTenant(t *testing.T) {
+	p := newPipeline(t)
+	p.PerTenant = true
+	for _, tenant := range []string{"alice", "bob"} {
+		u, err := p.Begin(tenant, "a.txt")
+		if err != nil {
+			t.Fatalf("Begin returned error: %v", err)
+		}
+		u.WriteLine(tenant)
+		path, err := u.Commit()
+		if err != nil {
+			t.Fatalf("Commit returned error: %v", err)
+		}
+		if want := filepath.Join(p.Dest, tenant, "a.txt"); path != want {
+			t.Errorf("Expected %s, got %s", want, path)
+		}
+	}
+
+	if _, err := p.Begin("", "a.txt"); err != ErrNoTenant {
+		t.Errorf("Expected ErrNoTenant, got %v", err)
+	}
+	for _, tenant := range []string{"..", "a/b", ".hidden"} {
+		if _, err := p.Begin(tenant, "a.txt"); err == nil {
+			t.Errorf("Expected tenant %q to be rejected", tenant)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	p := newPipeline(t)
+	p.Limit = 10
+	if _, err := receive(p, "small.txt", "12345", "123"); err != nil {
+		t.Errorf("Expected 10 bytes to be accepted, got %v", err)
+	}
+	if _, err := receive(p, "large.txt", "12345", "1234"); !errors.Is(err, ErrRejected) || !errors.Is(err, ErrQuota) {
+		t.Errorf("Expected 11 bytes to be rejected over the quota, got %v", err)
+	}
+	if left := quarantined(t, p); len(left) != 0 {
+		t.Errorf("Expected the aborted upload to be removed, got %d files", len(left))
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	if _, err := ContentTypes([]string{"text/["}); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+
+	validate, err := ContentTypes([]string{"text/*", "application/json"})
+	if err != nil {
+		t.Fatalf("ContentTypes returned error: %v", err)
+	}
+	p := newPipeline(t)
+	p.Validators = []Validator{validate}
+	if _, err := receive(p, "notes.txt", "plain text"); err != nil {
+		t.Errorf("Expected text to be accepted, got %v", err)
+	}
+	if _, err := receive(p, "empty.txt"); err != nil {
+		t.Errorf("Expected an empty file to be accepted, got %v", err)
+	}
+	_, err = receive(p, "image.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "image/png") {
+		t.Errorf("Expected a PNG to be rejected, got %v", err)
+	}
+}
+
+func TestScanner(t *testing.T) {
+	if _, err := Scanner("", time.Second); err == nil {
+		t.Error("Expected an error for an empty command")
+	}
+
+	validate, err := Scanner(`sh -c 'if grep -q EICAR "$0"; then echo "found EICAR"; exit 1; fi'`, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Scanner returned error: %v", err)
+	}
+	p := newPipeline(t)
+	p.Validators = []Validator{validate}
+	if _, err := receive(p, "clean.txt", "hello"); err != nil {
+		t.Errorf("Expected a clean file to be accepted, got %v", err)
+	}
+	_, err = receive(p, "infected.txt", "X5O EICAR test")
+	if !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "found EICAR") {
+		t.Errorf("Expected the scanner's rejection, got %v", err)
+	}
+
+	slow, _ := Scanner("sh -c 'sleep 5'", 100*time.Millisecond)
+	if err := slow(filepath.Join(p.Dest, "clean.txt")); err == nil || errors.Is(err, ErrRejected) {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/cmdline"
+)
+
+// sniffLen is how much of a file content type detection looks at
+const sniffLen = 512
+
+// ContentTypes accepts files whose sniffed content type matches one of the
+// patterns, such as text/plain or text/*
+func ContentTypes(patterns []string) (Validator, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid content type pattern %q: %w", pattern, err)
+		}
+	}
+	return func(name string) error {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		head := make([]byte, sniffLen)
+		n, err := io.ReadFull(file, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		detected, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, detected); ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: content type %s is not allowed", ErrRejected, detected)
+	}, nil
+}
+
+// Scanner runs an external command, such as a virus scanner, with the path
+// of the quarantined file as its last argument and accepts the file if it
+// exits successfully within timeout
+func Scanner(command string, timeout time.Duration) (Validator, error) {
+	args, err := cmdline.Split(command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scanner command: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty scanner command")
+	}
+	return func(name string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		var output bytes.Buffer
+		cmd := exec.CommandContext(ctx, args[0], append(args[1:], name)...)
+		cmd.Stdout, cmd.Stderr = &output, &output
+		// Children of a killed scanner may hold its output open
+		cmd.WaitDelay = time.Second
+		err := cmd.Run()
+		if ctx.Err() != nil {
+			return fmt.Errorf("scanner did not finish within %v", timeout)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			reason, _, _ := strings.Cut(strings.TrimSpace(output.String()), "\n")
+			if reason == "" {
+				reason = exitErr.Error()
+			}
+			return fmt.Errorf("%w by scanner: %s", ErrRejected, reason)
+		}
+		if err != nil {
+			return fmt.Errorf("error running scanner: %w", err)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,102 @@
+// Package webui serves a page that receives the server's file stream in a
+// browser, to try a server without installing the client. The page runs the
+// offer and answer exchange against /offer with the browser's own WebRTC
+// stack, accepts the file stream channel the server announces, or creates it
+// pre-negotiated when the server runs --negotiated-channel, like the client
+// does, lists the lines as they arrive and answers Fin with Ack. It is embedded in
+// the binary, so serving it needs no files next to the server. Pages served
+// by other origins may post offers too once CORS allows their origin.
+package webui
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+//go:embed index.html
+var page string
+
+var tmpl = template.Must(template.New("index").Parse(page))
+
+// Settings are what the page needs to connect like a client
+type Settings struct {
+	ChannelLabel    string `json:"channelLabel"`
+	ChannelID       uint16 `json:"channelId"`
+	ChannelProtocol string `json:"channelProtocol"`
+	// Negotiated is set when the server pre-negotiates the channel with
+	// ChannelID instead of announcing it
+	Negotiated bool `json:"negotiated"`
+	// ICEServers are the URLs of the ICE servers the browser gathers with,
+	// which must not need credentials, since the page is public
+	ICEServers []string `json:"iceServers"`
+	// Token is set when the server requires an auth token, which the page
+	// then asks for
+	Token bool `json:"token"`
+}
+
+// Handler serves the page at / and nothing else
+func Handler(s Settings) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		// The page runs no script but its own
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		tmpl.Execute(w, s)
+	})
+}
+
+// ValidOrigin checks that origin can be allowed to post offers: * for any
+// origin, or a scheme and host like https://example.com
+func ValidOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+		return fmt.Errorf("invalid CORS origin %q: expected * or a scheme and host like https://example.com", origin)
+	}
+	return nil
+}
+
+// CORS lets pages served by origins post to next: it answers their preflight
+// requests and marks its responses readable to them, exposing the headers
+// the server answers offers with. Requests of other origins reach next as
+// they are, so the browser keeps their responses from the page. Without
+// origins next is returned unchanged.
+func CORS(origins []string, next http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return next
+	}
+	all := slices.Contains(origins, "*")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !(all || slices.Contains(origins, origin)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", "Retry-After, X-Peer-Identity")
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "POST")
+			h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
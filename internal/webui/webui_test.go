@@ -0,0 +1,110 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h := Handler(Settings{ChannelLabel: "</script><b>", ChannelID: 7, Negotiated: true, ICEServers: []string{"stun:stun.example.com:3478"}, Token: true})
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Expected an HTML page, got %q", ct)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `"channelId":7`) || !strings.Contains(body, `"negotiated":true`) || !strings.Contains(body, `"stun:stun.example.com:3478"`) || !strings.Contains(body, `"token":true`) {
+		t.Errorf("Expected the settings in the page, got %s", body)
+	}
+	// The label cannot end the script it is embedded in
+	if strings.Contains(body, "</script><b>") {
+		t.Error("Expected the settings to be escaped")
+	}
+
+	for _, tt := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "/other", http.StatusNotFound},
+		{http.MethodPost, "/", http.StatusMethodNotAllowed},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		if rr.Code != tt.code {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.code, rr.Code)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	var reached int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	request := func(h http.Handler, method, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/offer", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+
+	h := CORS([]string{"https://example.com"}, next)
+	rr := request(h, http.MethodOptions, "https://example.com")
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Errorf("Expected the preflight to be answered, got %d %v", rr.Code, rr.Header())
+	}
+	if !strings.Contains(rr.Header().Get("Access-Control-Allow-Headers"), "Authorization") {
+		t.Errorf("Expected the Authorization header to be allowed, got %q", rr.Header().Get("Access-Control-Allow-Headers"))
+	}
+	if reached != 0 {
+		t.Error("Expected the preflight not to reach the handler")
+	}
+
+	rr = request(h, http.MethodPost, "https://example.com")
+	if rr.Code != http.StatusTeapot || rr.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Errorf("Expected the response to be readable by the origin, got %d %v", rr.Code, rr.Header())
+	}
+
+	// Other origins and same origin requests reach the handler unmarked
+	for _, origin := range []string{"https://evil.example", ""} {
+		rr = request(h, http.MethodPost, origin)
+		if rr.Code != http.StatusTeapot || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("Origin %q: expected an unmarked response, got %d %v", origin, rr.Code, rr.Header())
+		}
+	}
+
+	rr = request(CORS([]string{"*"}, next), http.MethodPost, "https://any.example")
+	if rr.Header().Get("Access-Control-Allow-Origin") != "https://any.example" {
+		t.Errorf("Expected * to allow any origin, got %v", rr.Header())
+	}
+	if rr = request(CORS(nil, next), http.MethodOptions, "https://example.com"); rr.Code != http.StatusTeapot {
+		t.Errorf("Expected no CORS handling without origins, got %d", rr.Code)
+	}
+}
+
+func TestValidOrigin(t *testing.T) {
+	for _, origin := range []string{"*", "https://example.com", "http://localhost:3000"} {
+		if err := ValidOrigin(origin); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", origin, err)
+		}
+	}
+	for _, origin := range []string{"", "example.com", "https://example.com/page", "ftp://example.com", "https://user@example.com"} {
+		if err := ValidOrigin(origin); err == nil {
+			t.Errorf("Expected %q to be invalid", origin)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Package mobile wraps packages sender and receiver for Android and iOS apps
+// built with gomobile bind:
+//
+//	gomobile bind -target=android ./pkg/mobile
+//	gomobile bind -target=ios ./pkg/mobile
+//
+// gomobile only binds strings, booleans, numbers, byte slices, pointers to
+// exported structs and interfaces, so the API has no channels, slices,
+// durations or option structs of the wrapped packages. Apps implement
+// ReceiveHandler and SendHandler to be called back with lines, states and
+// progress; callbacks run on Go's goroutines, not the app's main thread.
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/signaling"
+	"github.com/developmeh/webrtc-poc/pkg/receiver"
+	"github.com/developmeh/webrtc-poc/pkg/sender"
+	"github.com/pion/webrtc/v3"
+)
+
+// ErrCanceled is returned by Receive once Cancel was called
+var ErrCanceled = errors.New("canceled")
+
+// ReceiveHandler is called back while a Receiver receives a stream
+type ReceiveHandler interface {
+	// OnLine is called with every line received, in order. An error ends
+	// the stream with it.
+	OnLine(line string) error
+	// OnState is called with every state change of the connection, such as
+	// "connected" or "failed"
+	OnState(state string)
+	// OnProgress is called after every line received
+	OnProgress(lines, bytes int64)
+}
+
+// SendHandler is called back while a Sender sends to receivers, each named
+// by the ID of its connection
+type SendHandler interface {
+	// OnState is called with every state change of a receiver's connection
+	OnState(id, state string)
+	// OnProgress is called after every line sent and once more when the
+	// transfer ended, with done set and err empty unless it failed
+	OnProgress(id string, lines, bytes int64, done bool, err string)
+}
+
+// Result describes a finished stream
+type Result struct {
+	Lines    int64
+	Bytes    int64
+	Sent     int64
+	Cut      int64
+	Verified bool
+	// DurationMillis is how long receiving took
+	DurationMillis int64
+}
+
+// iceServers collects the ICE servers of a Receiver or Sender
+type iceServers []webrtc.ICEServer
+
+func (s *iceServers) add(url, username, credential string) {
+	server := webrtc.ICEServer{URLs: []string{url}}
+	if username != "" || credential != "" {
+		server.Username = username
+		server.Credential = credential
+	}
+	*s = append(*s, server)
+}
+
+// Receiver receives the stream of a webrtc-poc server or Sender
+type Receiver struct {
+	// URL is the sender's offer endpoint, e.g. http://192.168.1.10:8080/offer
+	URL string
+	// Verify asks the sender for a digest of the stream and checks the
+	// lines against it
+	Verify bool
+	// MaxRecordSize asks for lines of at most this many bytes, and
+	// Oversized for what happens to longer ones: truncate, split or abort
+	MaxRecordSize int
+	Oversized     string
+
+	ice    iceServers
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// NewReceiver creates a Receiver of the sender at url
+func NewReceiver(url string) *Receiver {
+	return &Receiver{URL: url}
+}
+
+// AddICEServer adds a STUN or TURN server, e.g. stun:stun.l.google.com:19302,
+// with the credentials of a TURN server or empty strings
+func (r *Receiver) AddICEServer(url, username, credential string) {
+	r.ice.add(url, username, credential)
+}
+
+// Receive connects to the sender and receives its stream, blocking until it
+// ended. Call it off the app's main thread.
+func (r *Receiver) Receive(h ReceiveHandler) (*Result, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mu.Lock()
+	r.cancel = cancel
+	r.mu.Unlock()
+	defer cancel()
+
+	result, err := receiver.Receive(ctx, receiver.Options{
+		URL:           r.URL,
+		ICEServers:    r.ice,
+		MaxRecordSize: r.MaxRecordSize,
+		Oversized:     r.Oversized,
+		Verify:        r.Verify,
+		OnLine:        h.OnLine,
+		OnState:       func(state webrtc.PeerConnectionState) { h.OnState(state.String()) },
+		OnProgress:    func(p receiver.Progress) { h.OnProgress(int64(p.Lines), p.Bytes) },
+	})
+	if errors.Is(err, context.Canceled) {
+		err = ErrCanceled
+	}
+	return &Result{
+		Lines:          int64(result.Lines),
+		Bytes:          result.Bytes,
+		Sent:           int64(result.Sent),
+		Cut:            int64(result.Cut),
+		Verified:       result.Verified,
+		DurationMillis: result.Duration.Milliseconds(),
+	}, err
+}
+
+// Cancel ends a running Receive, which returns ErrCanceled
+func (r *Receiver) Cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// Sender streams a file to receivers: those that post their offer to the
+// endpoint it serves with Listen, and those whose offer the app hands to
+// Answer through its own signaling. Its settings apply from the first
+// Listen or Answer on.
+type Sender struct {
+	// Path is the file streamed to every receiver
+	Path string
+	// DelayMillis is the time waited between lines
+	DelayMillis int64
+
+	h      SendHandler
+	ice    iceServers
+	mu     sync.Mutex
+	snd    *sender.Sender
+	ctx    context.Context
+	cancel context.CancelFunc
+	srv    *http.Server
+	addr   string
+}
+
+// NewSender creates a Sender of the file at path, reporting to h, which may
+// be nil
+func NewSender(path string, h SendHandler) *Sender {
+	return &Sender{Path: path, h: h}
+}
+
+// AddICEServer adds a STUN or TURN server, with the credentials of a TURN
+// server or empty strings
+func (s *Sender) AddICEServer(url, username, credential string) {
+	s.ice.add(url, username, credential)
+}
+
+// start creates the wrapped sender on first use
+func (s *Sender) start() error {
+	if s.snd != nil {
+		return nil
+	}
+	opts := sender.Options{
+		File:       s.Path,
+		Delay:      time.Duration(s.DelayMillis) * time.Millisecond,
+		ICEServers: s.ice,
+	}
+	if h := s.h; h != nil {
+		opts.OnState = func(id string, state webrtc.PeerConnectionState) { h.OnState(id, state.String()) }
+		opts.OnProgress = func(p sender.Progress) {
+			msg := ""
+			if p.Err != nil {
+				msg = p.Err.Error()
+			}
+			h.OnProgress(p.ID, int64(p.Lines), p.Bytes, p.Done, msg)
+		}
+	}
+	snd, err := sender.New(opts)
+	if err != nil {
+		return err
+	}
+	s.snd = snd
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return nil
+}
+
+// Listen serves the offer endpoint at /offer on addr, e.g. :8080, and
+// returns once it is listening
+func (s *Sender) Listen(addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.srv != nil {
+		return errors.New("already listening")
+	}
+	if err := s.start(); err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/offer", s.snd.Handler(s.ctx))
+	s.srv = &http.Server{Handler: mux}
+	s.addr = ln.Addr().String()
+	go s.srv.Serve(ln)
+	return nil
+}
+
+// Addr returns the address Listen serves on, with the port picked for :0
+func (s *Sender) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addr
+}
+
+// Answer answers an offer received through the app's own signaling, a JSON
+// session description, and returns the answer in the same form. The file is
+// streamed to the receiver once it connects.
+func (s *Sender) Answer(offerJSON string) (string, error) {
+	offer, err := signaling.ParseOffer([]byte(offerJSON))
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	err = s.start()
+	snd, ctx := s.snd, s.ctx
+	s.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	answer, err := snd.Answer(ctx, offer)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(answer)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode answer: %w", err)
+	}
+	return string(data), nil
+}
+
+// Stop closes the endpoint and every connection, and waits for the
+// transfers to end
+func (s *Sender) Stop() {
+	s.mu.Lock()
+	snd, srv, cancel := s.snd, s.srv, s.cancel
+	s.snd, s.srv, s.addr = nil, nil, ""
+	s.mu.Unlock()
+	if snd == nil {
+		return
+	}
+	cancel()
+	if srv != nil {
+		srv.Close()
+	}
+	snd.Wait()
+}
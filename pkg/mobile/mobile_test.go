@@ -0,0 +1,197 @@
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/pkg/receiver"
+	"github.com/pion/webrtc/v3"
+)
+
+// lines collects what a Receiver reports
+type lines struct {
+	mu       sync.Mutex
+	lines    []string
+	states   []string
+	progress int64
+	fail     error
+}
+
+func (l *lines) OnLine(line string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, line)
+	return l.fail
+}
+
+func (l *lines) OnState(state string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.states = append(l.states, state)
+}
+
+func (l *lines) OnProgress(lines, bytes int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.progress = lines
+}
+
+// transfers collects what a Sender reports
+type transfers struct {
+	mu   sync.Mutex
+	done map[string]string
+}
+
+func (t *transfers) OnState(id, state string) {}
+
+func (t *transfers) OnProgress(id string, lines, bytes int64, done bool, err string) {
+	if !done {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done[id] = fmt.Sprintf("%d lines %s", lines, err)
+}
+
+// writeFile writes n lines to a file and returns its path and content
+func writeFile(t *testing.T, n int) (string, string) {
+	t.Helper()
+	var content strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	path := filepath.Join(t.TempDir(), "in.txt")
+	if err := os.WriteFile(path, []byte(content.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, content.String()
+}
+
+func TestListenReceive(t *testing.T) {
+	path, content := writeFile(t, 30)
+	sent := &transfers{done: make(map[string]string)}
+	s := NewSender(path, sent)
+	defer s.Stop()
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen returned error: %v", err)
+	}
+	if err := s.Listen("127.0.0.1:0"); err == nil {
+		t.Error("Expected a second Listen to fail")
+	}
+
+	r := NewReceiver("http://" + s.Addr() + "/offer")
+	r.Verify = true
+	got := &lines{}
+	result, err := r.Receive(got)
+	if err != nil {
+		t.Fatalf("Receive returned error: %v", err)
+	}
+	if strings.Join(got.lines, "\n")+"\n" != content || got.progress != 30 {
+		t.Errorf("Expected every line with progress, got %d lines and progress %d", len(got.lines), got.progress)
+	}
+	if result.Lines != 30 || result.Sent != 30 || !result.Verified {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	// States keep arriving as the connection closes
+	got.mu.Lock()
+	if len(got.states) == 0 || got.states[0] != "connecting" {
+		t.Errorf("Expected the states as strings, got %q", got.states)
+	}
+	got.mu.Unlock()
+
+	// The sender reports the acknowledged transfer
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sent.mu.Lock()
+		n := len(sent.done)
+		sent.mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent.mu.Lock()
+	defer sent.mu.Unlock()
+	for _, done := range sent.done {
+		if done != "30 lines " {
+			t.Errorf("Expected a finished transfer of 30 lines, got %q", done)
+		}
+	}
+	if len(sent.done) != 1 {
+		t.Errorf("Expected one finished transfer, got %v", sent.done)
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	path, content := writeFile(t, 5)
+	s := NewSender(path, nil)
+	defer s.Stop()
+
+	if _, err := s.Answer(`{"type":"answer"}`); err == nil {
+		t.Error("Expected an invalid offer to fail")
+	}
+
+	// The app carries the offer and answer as JSON strings
+	var out strings.Builder
+	_, err := receiver.Receive(t.Context(), receiver.Options{
+		Output: &out,
+		Signal: func(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+			offerJSON, _ := json.Marshal(offer)
+			answerJSON, err := s.Answer(string(offerJSON))
+			if err != nil {
+				return webrtc.SessionDescription{}, err
+			}
+			var answer webrtc.SessionDescription
+			return answer, json.Unmarshal([]byte(answerJSON), &answer)
+		},
+	})
+	if err != nil || out.String() != content {
+		t.Errorf("Expected the file through Answer, got %q, %v", out.String(), err)
+	}
+}
+
+func TestReceiveCancel(t *testing.T) {
+	path, _ := writeFile(t, 5)
+	s := NewSender(path, nil)
+	s.DelayMillis = time.Hour.Milliseconds()
+	defer s.Stop()
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen returned error: %v", err)
+	}
+
+	r := NewReceiver("http://" + s.Addr() + "/offer")
+	got := &lines{}
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Receive(got)
+		done <- err
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got.mu.Lock()
+		n := len(got.lines)
+		got.mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.Cancel()
+	if err := <-done; !errors.Is(err, ErrCanceled) {
+		t.Errorf("Expected ErrCanceled, got %v", err)
+	}
+
+	// A failing callback ends the stream with its error
+	stop := errors.New("stop")
+	if _, err := r.Receive(&lines{fail: stop}); !errors.Is(err, stop) {
+		t.Errorf("Expected the callback's error, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package receiver
+
+import (
+	"context"
+	"iter"
+)
+
+// lineBuffer is how many lines are held for a consumer that ranges slower
+// than they arrive, before the stream waits for it
+const lineBuffer = 256
+
+// Lines receives the stream like Receive and yields every line, in order.
+// If the stream fails, its error is yielded last with an empty line.
+// Breaking out of the loop ends the stream and closes the connection before
+// the loop returns. Lines replaces the OnLine callback of opts.
+func Lines(ctx context.Context, opts Options) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for lines, err := range Chunks(ctx, opts) {
+			if err != nil {
+				yield("", err)
+				return
+			}
+			for _, line := range lines {
+				if !yield(line, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Chunks receives the stream like Receive and yields the lines in batches:
+// every line that arrived since the previous batch was yielded, so slow
+// consumers take larger batches instead of falling behind. If the stream
+// fails, its error is yielded last with a nil batch. Breaking out of the
+// loop ends the stream and closes the connection before the loop returns.
+// Chunks replaces the OnLine callback of opts.
+func Chunks(ctx context.Context, opts Options) iter.Seq2[[]string, error] {
+	return func(yield func([]string, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		lines := make(chan string, lineBuffer)
+		opts.OnLine = func(line string) error {
+			select {
+			case lines <- line:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		var err error
+		go func() {
+			defer close(done)
+			_, err = Receive(ctx, opts)
+		}()
+		// The connection is closed once the loop returns, however it ends
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		for {
+			var batch []string
+			select {
+			case line := <-lines:
+				batch = append(batch, line)
+			case <-done:
+				// The lines received before the stream ended come first
+				for len(lines) > 0 {
+					batch = append(batch, <-lines)
+				}
+				if len(batch) > 0 && !yield(batch, nil) {
+					return
+				}
+				if err != nil {
+					yield(nil, err)
+				}
+				return
+			}
+			for len(lines) > 0 {
+				batch = append(batch, <-lines)
+			}
+			if !yield(batch, nil) {
+				return
+			}
+		}
+	}
+}
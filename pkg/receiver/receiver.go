@@ -0,0 +1,364 @@
+// Package receiver receives the lines of a file streamed over WebRTC, for Go
+// programs that embed the receiving half of webrtc-poc instead of running
+// the CLI. Receive offers a connection to a webrtc-poc server, or a sender of
+// package sender, over HTTP or a signaling function of the caller's, passes
+// every line to a callback or writer in order and acknowledges the end of
+// the stream, with the webrtc-poc client's implementation.
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/client"
+	"github.com/developmeh/webrtc-poc/internal/peer"
+	"github.com/developmeh/webrtc-poc/internal/records"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// DefaultChannelLabel is the label of the file stream channel
+	DefaultChannelLabel = "fileStream"
+
+	// ackLinger is how long the sender is given to close the channel after
+	// the Ack, before the connection is closed anyway
+	ackLinger = 5 * time.Second
+)
+
+// Policies the sender applies to lines longer than the maximum record size
+const (
+	// Truncate cuts them to the maximum size, ending in a marker
+	Truncate = records.Truncate
+	// Split sends them as several lines of at most the maximum size
+	Split = records.Split
+	// Abort ends the stream
+	Abort = records.Abort
+)
+
+var (
+	// ErrIncomplete is returned when the stream closed before the sender
+	// finished it
+	ErrIncomplete = errors.New("stream closed before the sender finished it")
+	// ErrFailed is returned when the connection failed
+	ErrFailed = errors.New("connection failed")
+	// ErrTooLong is returned when the sender aborted the stream at a line
+	// longer than the maximum record size
+	ErrTooLong = client.ErrTooLong
+	// ErrDigest is returned when the lines received do not match the digest
+	// of the lines sent
+	ErrDigest = errors.New("lines received do not match the digest of the stream")
+)
+
+// Options configure Receive
+type Options struct {
+	// URL is the offer endpoint of the sender, e.g.
+	// http://localhost:8080/offer
+	URL string
+	// Client posts the offer to URL (default http.DefaultClient)
+	Client *http.Client
+	// Signal, if set, delivers the offer and returns the sender's answer
+	// instead of posting it to URL, e.g. the Answer method of a
+	// sender.Sender
+	Signal func(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error)
+
+	// API creates the peer connection, for custom setting engines; nil uses
+	// one that only connects directly when ICEServers is empty
+	API *webrtc.API
+	// ICEServers are the STUN and TURN servers of the peer connection
+	ICEServers []webrtc.ICEServer
+	// Negotiated pre-negotiates the file stream channel, named by
+	// ChannelLabel and ChannelID, which must match the sender's (default
+	// fileStream and 0), instead of accepting the channel the sender
+	// announces
+	ChannelLabel string
+	ChannelID    uint16
+	Negotiated   bool
+
+	// MaxRecordSize asks the sender for lines of at most this many bytes,
+	// and Oversized for what happens to longer ones: Truncate, Split or
+	// Abort (default the sender's). Only offers posted to URL carry them.
+	MaxRecordSize int
+	Oversized     string
+	// Verify asks the sender for a digest of the stream and checks the
+	// lines received against it
+	Verify bool
+
+	// OnLine, if set, is called with every line received, in order. An
+	// error ends the stream with it. It is not called for the chunks of
+	// files the sender streams as raw bytes, like block devices.
+	OnLine func(line string) error
+	// Output, if set, receives every line followed by a newline, and the
+	// chunks of raw bytes as they are
+	Output io.Writer
+	// OnPeerConnection, if set, is called with the connection once it was
+	// created, before the offer is made, to add handlers of its own
+	OnPeerConnection func(pc *webrtc.PeerConnection)
+	// OnState, if set, is called with every state change of the connection
+	OnState func(state webrtc.PeerConnectionState)
+	// OnProgress, if set, is called after every line received
+	OnProgress func(Progress)
+}
+
+// Progress reports how much of the stream was received
+type Progress struct {
+	Lines int
+	Bytes int64
+}
+
+// Result describes a finished stream
+type Result struct {
+	// Lines and Bytes count what was received, and Sent is the number of
+	// lines the sender sent
+	Lines int
+	Bytes int64
+	Sent  int
+	// Cut counts the lines the sender truncated or split
+	Cut int
+	// Verified is set if the lines received matched the sender's digest
+	Verified bool
+	Duration time.Duration
+}
+
+// Receive connects to the sender and receives its stream until the sender
+// finished it, the connection failed or ctx was canceled
+func Receive(ctx context.Context, opts Options) (Result, error) {
+	if opts.URL == "" && opts.Signal == nil {
+		return Result{}, errors.New("no URL or signaling function to reach the sender")
+	}
+	terms := records.Terms{Policy: opts.Oversized, MaxSize: opts.MaxRecordSize}
+	if opts.MaxRecordSize != 0 || opts.Oversized != "" {
+		if err := terms.Validate(); err != nil {
+			return Result{}, err
+		}
+	}
+	if opts.ChannelLabel == "" {
+		opts.ChannelLabel = DefaultChannelLabel
+	}
+
+	s := &stream{opts: &opts, digest: sha256.New(), failed: make(chan struct{}, 1), closed: make(chan struct{})}
+	cfg := client.Config{
+		ServerURL:  opts.URL,
+		API:        opts.API,
+		ICEServers: opts.ICEServers,
+		Channel: peer.Channel{
+			Label:      opts.ChannelLabel,
+			Negotiated: opts.Negotiated,
+			ID:         opts.ChannelID,
+		},
+		Records:     terms,
+		Credentials: client.Credentials{HTTPClient: opts.Client},
+	}
+	if opts.Signal != nil {
+		cfg.Credentials.Signal = s.signal
+	}
+	hooks := client.Hooks{
+		Connection: opts.OnPeerConnection,
+		State:      s.state,
+		Raw:        func() { s.raw.Store(true) },
+		Cut:        s.cut,
+		Fin:        s.fin,
+		Aborted:    s.abort,
+		Closed:     func() { s.closeOnce.Do(func() { close(s.closed) }) },
+	}
+	if opts.Verify {
+		hooks.Digest = s.sum
+	}
+
+	start := time.Now()
+	lines := make(chan string)
+	pc, err := client.Connect(ctx, cfg, lines, s.failed, hooks)
+	if err != nil {
+		return Result{}, err
+	}
+	defer pc.Close()
+
+	err = s.receive(ctx, lines)
+	// Lines still arriving are dropped until the connection closed
+	go func() {
+		for range lines {
+		}
+	}()
+	s.mu.Lock()
+	result, finished := s.result, s.finished
+	s.mu.Unlock()
+	if finished {
+		// Closing the connection right away could drop the Ack, so the
+		// sender closes the channel once it got it
+		select {
+		case <-s.closed:
+		case <-time.After(ackLinger):
+		case <-ctx.Done():
+		}
+	}
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+// stream follows the file stream as the client receives it
+type stream struct {
+	opts   *Options
+	digest hash.Hash
+	// raw is set once the sender streams chunks of raw bytes
+	raw       atomic.Bool
+	failed    chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu sync.Mutex
+	// sent is the digest the sender sent, if any
+	sent []byte
+	// finished is set once the sender finished the stream, and err once it
+	// aborted it
+	finished bool
+	err      error
+	result   Result
+}
+
+// signal delivers the offer with the caller's signaling function
+func (s *stream) signal(ctx context.Context, offerJSON []byte) ([]byte, error) {
+	var offer webrtc.SessionDescription
+	if err := json.Unmarshal(offerJSON, &offer); err != nil {
+		return nil, err
+	}
+	answer, err := s.opts.Signal(ctx, offer)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(answer)
+}
+
+// state reports the connection's states, ending the stream once it failed
+func (s *stream) state(state webrtc.PeerConnectionState) {
+	if s.opts.OnState != nil {
+		s.opts.OnState(state)
+	}
+	if state == webrtc.PeerConnectionStateFailed {
+		select {
+		case s.failed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sum keeps the digest the sender sent
+func (s *stream) sum(sum []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = sum
+}
+
+// cut counts a line the sender truncated or split
+func (s *stream) cut() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.result.Cut++
+}
+
+// fin notes that the sender finished the stream after sent lines
+func (s *stream) fin(sent int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished = true
+	s.result.Sent = sent
+}
+
+// abort notes why the sender ended the stream early
+func (s *stream) abort(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// receive hands every line to the caller until the stream ended, the
+// connection failed or ctx was canceled
+func (s *stream) receive(ctx context.Context, lines <-chan string) error {
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return s.end()
+			}
+			if err := s.line(line); err != nil {
+				return err
+			}
+		case <-s.failed:
+			return ErrFailed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// line passes a line to the caller, or writes a chunk of raw bytes as it is
+func (s *stream) line(line string) error {
+	if s.raw.Load() {
+		io.WriteString(s.digest, line)
+		if s.opts.Output != nil {
+			if _, err := io.WriteString(s.opts.Output, line); err != nil {
+				return fmt.Errorf("failed to write chunk: %w", err)
+			}
+		}
+		s.received(len(line))
+		return nil
+	}
+
+	io.WriteString(s.digest, line)
+	s.digest.Write([]byte{'\n'})
+	if s.opts.OnLine != nil {
+		if err := s.opts.OnLine(line); err != nil {
+			return err
+		}
+	}
+	if s.opts.Output != nil {
+		if _, err := io.WriteString(s.opts.Output, line+"\n"); err != nil {
+			return fmt.Errorf("failed to write line: %w", err)
+		}
+	}
+	s.received(len(line))
+	return nil
+}
+
+// received counts a line or chunk of n bytes
+func (s *stream) received(n int) {
+	s.mu.Lock()
+	s.result.Lines++
+	s.result.Bytes += int64(n)
+	progress := Progress{Lines: s.result.Lines, Bytes: s.result.Bytes}
+	s.mu.Unlock()
+	if s.opts.OnProgress != nil {
+		s.opts.OnProgress(progress)
+	}
+}
+
+// end checks the stream once it ended: the sender must have finished it,
+// with every line it sent received and matching its digest
+func (s *stream) end() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if !s.finished {
+		return ErrIncomplete
+	}
+	if s.sent != nil {
+		if !bytes.Equal(s.sent, s.digest.Sum(nil)) {
+			return ErrDigest
+		}
+		s.result.Verified = true
+	}
+	if s.result.Lines != s.result.Sent {
+		return fmt.Errorf("received %d of the %d lines sent: %w", s.result.Lines, s.result.Sent, ErrIncomplete)
+	}
+	return nil
+}
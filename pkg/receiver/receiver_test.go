@@ -0,0 +1,249 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/pkg/sender"
+)
+
+// newSender returns a sender of content, served over HTTP
+func newSender(t *testing.T, content string, opts sender.Options) (*sender.Sender, string) {
+	t.Helper()
+	opts.Open = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(content)), nil }
+	s, err := sender.New(opts)
+	if err != nil {
+		t.Fatalf("sender.New returned error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := httptest.NewServer(s.Handler(ctx))
+	t.Cleanup(func() {
+		srv.Close()
+		cancel()
+		s.Wait()
+	})
+	return s, srv.URL + "/offer"
+}
+
+func TestReceive(t *testing.T) {
+	var content strings.Builder
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	var mu sync.Mutex
+	var done []sender.Progress
+	_, url := newSender(t, content.String(), sender.Options{OnProgress: func(p sender.Progress) {
+		if p.Done {
+			mu.Lock()
+			done = append(done, p)
+			mu.Unlock()
+		}
+	}})
+
+	var out bytes.Buffer
+	var progress []Progress
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := Receive(ctx, Options{
+		URL:        url,
+		Output:     &out,
+		Verify:     true,
+		OnProgress: func(p Progress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatalf("Receive returned error: %v", err)
+	}
+	if out.String() != content.String() {
+		t.Errorf("Expected the file written, got %q", out.String())
+	}
+	if result.Lines != 50 || result.Sent != 50 || !result.Verified {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if len(progress) != 50 || progress[49].Lines != 50 {
+		t.Errorf("Expected progress after every line, got %d reports", len(progress))
+	}
+
+	// The sender reports the transfer once it was acknowledged
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(done)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(done) != 1 || done[0].Err != nil || done[0].Received != 50 {
+		t.Errorf("Expected the sender to report one acknowledged transfer, got %+v", done)
+	}
+}
+
+func TestReceiveRecords(t *testing.T) {
+	long := strings.Repeat("a", 150)
+	_, url := newSender(t, "short\n"+long+"\nafter\n", sender.Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var lines []string
+	result, err := Receive(ctx, Options{
+		URL:           url,
+		MaxRecordSize: 64,
+		Oversized:     Split,
+		OnLine:        func(line string) error { lines = append(lines, line); return nil },
+	})
+	if err != nil {
+		t.Fatalf("Receive returned error: %v", err)
+	}
+	if len(lines) != 5 || lines[1] != long[:64] || lines[4] != "after" || result.Cut != 1 {
+		t.Errorf("Expected the long line split in three, got %q and %+v", lines, result)
+	}
+
+	// The sender's terms abort the stream at the long line
+	_, url = newSender(t, "short\n"+long+"\nafter\n", sender.Options{MaxRecordSize: 100})
+	if _, err := Receive(ctx, Options{URL: url}); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Expected ErrTooLong, got %v", err)
+	}
+}
+
+func TestReceiveSignal(t *testing.T) {
+	s, _ := newSender(t, "one\ntwo\n", sender.Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var out bytes.Buffer
+	result, err := Receive(ctx, Options{Signal: s.Answer, Output: &out})
+	if err != nil || out.String() != "one\ntwo\n" || result.Lines != 2 {
+		t.Errorf("Expected both lines, got %q, %+v, %v", out.String(), result, err)
+	}
+}
+
+func TestReceiveNegotiated(t *testing.T) {
+	s, _ := newSender(t, "one\ntwo\n", sender.Options{Negotiated: true, ChannelID: 5})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var out bytes.Buffer
+	result, err := Receive(ctx, Options{Signal: s.Answer, Output: &out, Negotiated: true, ChannelID: 5})
+	if err != nil || out.String() != "one\ntwo\n" || result.Sent != 2 {
+		t.Errorf("Expected both lines over the pre-negotiated channel, got %q, %+v, %v", out.String(), result, err)
+	}
+}
+
+func TestReceiveCanceled(t *testing.T) {
+	_, url := newSender(t, "one\ntwo\nthree\n", sender.Options{Delay: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := errors.New("stop")
+
+	_, err := Receive(ctx, Options{URL: url, OnLine: func(string) error { cancel(); return nil }})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the canceled context, got %v", err)
+	}
+
+	// A failing callback ends the stream with its error
+	_, err = Receive(context.Background(), Options{URL: url, OnLine: func(string) error { return stop }})
+	if !errors.Is(err, stop) {
+		t.Errorf("Expected the callback's error, got %v", err)
+	}
+
+	if _, err := Receive(context.Background(), Options{}); err == nil {
+		t.Error("Expected an error without a way to reach the sender")
+	}
+}
+
+func TestLines(t *testing.T) {
+	var content strings.Builder
+	for i := 1; i <= 500; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	_, url := newSender(t, content.String(), sender.Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var got strings.Builder
+	for line, err := range Lines(ctx, Options{URL: url, Verify: true}) {
+		if err != nil {
+			t.Fatalf("Lines yielded error: %v", err)
+		}
+		got.WriteString(line + "\n")
+	}
+	if got.String() != content.String() {
+		t.Errorf("Expected every line in order, got %d bytes", got.Len())
+	}
+
+	// Breaking out of the loop ends the stream
+	n := 0
+	for _, err := range Lines(ctx, Options{URL: url}) {
+		if err != nil {
+			t.Fatalf("Lines yielded error: %v", err)
+		}
+		if n++; n == 10 {
+			break
+		}
+	}
+	if n != 10 {
+		t.Errorf("Expected to stop after 10 lines, got %d", n)
+	}
+
+	// A stream that fails yields its error last
+	var errs []error
+	for _, err := range Lines(ctx, Options{}) {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || errs[0] == nil {
+		t.Errorf("Expected a single error, got %v", errs)
+	}
+}
+
+func TestChunks(t *testing.T) {
+	var content strings.Builder
+	for i := 1; i <= 200; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	_, url := newSender(t, content.String(), sender.Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A slow consumer takes the lines that arrived meanwhile in one batch
+	var got strings.Builder
+	batches, largest := 0, 0
+	for lines, err := range Chunks(ctx, Options{URL: url}) {
+		if err != nil {
+			t.Fatalf("Chunks yielded error: %v", err)
+		}
+		for _, line := range lines {
+			got.WriteString(line + "\n")
+		}
+		batches++
+		largest = max(largest, len(lines))
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got.String() != content.String() {
+		t.Errorf("Expected every line in order, got %d bytes", got.Len())
+	}
+	if largest < 2 || batches >= 200 {
+		t.Errorf("Expected lines to be batched, got %d batches of at most %d", batches, largest)
+	}
+
+	// The error of a failing stream follows the lines received before it
+	_, url = newSender(t, "short\n"+strings.Repeat("a", 150)+"\n", sender.Options{MaxRecordSize: 100})
+	var lines []string
+	var last error
+	for batch, err := range Chunks(ctx, Options{URL: url}) {
+		lines = append(lines, batch...)
+		last = err
+	}
+	if len(lines) != 1 || !errors.Is(last, ErrTooLong) {
+		t.Errorf("Expected the first line and ErrTooLong, got %q, %v", lines, last)
+	}
+}
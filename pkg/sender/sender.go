@@ -0,0 +1,280 @@
+// Package sender streams a file line by line to receivers over WebRTC, for
+// Go programs that embed the serving half of webrtc-poc instead of running
+// the CLI. A Sender answers the offers receivers post to its HTTP handler,
+// or hands it through Answer, and streams the file over the file stream
+// channel of each connection with the webrtc-poc server's implementation,
+// so the webrtc-poc client and package receiver both receive from it. Each
+// transfer ends with the receiver acknowledging every line, and the
+// connection is closed once it ended, failed or its context was canceled.
+package sender
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/developmeh/webrtc-poc/internal/peer"
+	"github.com/developmeh/webrtc-poc/internal/records"
+	"github.com/developmeh/webrtc-poc/internal/server"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// DefaultChannelLabel is the label of the file stream channel
+	DefaultChannelLabel = "fileStream"
+	// DefaultConnectTimeout is how long a receiver has to connect
+	DefaultConnectTimeout = 30 * time.Second
+	// DefaultFinishTimeout is how long a receiver has to acknowledge the end
+	// of the stream
+	DefaultFinishTimeout = 5 * time.Second
+)
+
+// Policies for lines longer than the maximum record size
+const (
+	// Truncate cuts them to the maximum size, ending in a marker
+	Truncate = records.Truncate
+	// Split sends them as several lines of at most the maximum size
+	Split = records.Split
+	// Abort ends the stream
+	Abort = records.Abort
+)
+
+// ErrNotConnected is the error of a transfer whose receiver did not connect
+// within the connect timeout
+var ErrNotConnected = errors.New("receiver did not connect in time")
+
+// Options configure a Sender
+type Options struct {
+	// File is the path of the file streamed to every receiver
+	File string
+	// Open, if set, opens what is streamed to a receiver instead of File
+	Open func() (io.ReadCloser, error)
+	// Delay is the time waited between lines
+	Delay time.Duration
+	// Pace, if set, is called for every transfer and returns what paces it
+	// instead of Delay: a function taking the bytes of every line sent and
+	// returning how long to wait before the next one
+	Pace func() func(n int) time.Duration
+
+	// API creates the peer connections, for custom setting engines; nil uses
+	// one that only connects directly when ICEServers is empty
+	API *webrtc.API
+	// ICEServers are the STUN and TURN servers of the peer connections
+	ICEServers []webrtc.ICEServer
+	// ChannelLabel names the file stream channel the sender announces to
+	// every receiver (default fileStream). With Negotiated, the channel is
+	// pre-negotiated with ChannelID instead, which must match the
+	// receiver's.
+	ChannelLabel string
+	ChannelID    uint16
+	Negotiated   bool
+
+	// MaxRecordSize is the largest line sent as it is, in bytes, and
+	// Oversized what happens to longer ones: Truncate, Split or Abort
+	// (default 64 KiB and Abort). Receivers may ask for smaller records or
+	// another policy with their offer.
+	MaxRecordSize int
+	Oversized     string
+
+	// ConnectTimeout and FinishTimeout bound how long receivers have to
+	// connect and to acknowledge the end of the stream (default
+	// DefaultConnectTimeout and DefaultFinishTimeout)
+	ConnectTimeout time.Duration
+	FinishTimeout  time.Duration
+
+	// OnPeerConnection, if set, is called with the connection of every
+	// receiver once it was created, before the offer is answered, to add
+	// handlers of its own
+	OnPeerConnection func(id string, pc *webrtc.PeerConnection)
+	// OnState, if set, is called with every state change of a receiver's
+	// connection
+	OnState func(id string, state webrtc.PeerConnectionState)
+	// OnProgress, if set, is called after every line sent and once more
+	// when a transfer ended
+	OnProgress func(Progress)
+}
+
+// Progress reports how far the transfer to one receiver got
+type Progress struct {
+	// ID identifies the receiver's connection
+	ID string
+	// Lines and Bytes count what was sent so far
+	Lines int
+	Bytes int64
+	// Done is set once the transfer ended, with Err if it failed and the
+	// number of lines the receiver acknowledged in Received
+	Done     bool
+	Received int
+	Err      error
+}
+
+// Sender answers offers and streams the file to every receiver
+type Sender struct {
+	opts Options
+	srv  *server.Server
+
+	// connections follows every connection until it was closed and its
+	// transfer reported done
+	mu          sync.Mutex
+	connections map[string]*connection
+	wg          sync.WaitGroup
+}
+
+// connection is how far the connection of a receiver got
+type connection struct {
+	connected, closed, done bool
+}
+
+// New creates a sender, checking its options
+func New(opts Options) (*Sender, error) {
+	if opts.File == "" && opts.Open == nil {
+		return nil, errors.New("no file to send")
+	}
+	terms := records.Terms{Policy: opts.Oversized, MaxSize: opts.MaxRecordSize}
+	if err := terms.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.ChannelLabel == "" {
+		opts.ChannelLabel = DefaultChannelLabel
+	}
+	if opts.ConnectTimeout == 0 {
+		opts.ConnectTimeout = DefaultConnectTimeout
+	}
+	if opts.FinishTimeout == 0 {
+		opts.FinishTimeout = DefaultFinishTimeout
+	}
+	if opts.Open == nil {
+		file := opts.File
+		opts.Open = func() (io.ReadCloser, error) { return os.Open(file) }
+	}
+	pace := opts.Pace
+	if pace == nil {
+		delay := opts.Delay
+		pace = func() func(int) time.Duration {
+			return func(int) time.Duration { return delay }
+		}
+	}
+
+	s := &Sender{opts: opts, connections: make(map[string]*connection)}
+	srv, err := server.New(server.Config{
+		File:       opts.File,
+		Open:       opts.Open,
+		Pace:       pace,
+		API:        opts.API,
+		ICEServers: opts.ICEServers,
+		Channel: peer.Channel{
+			Label:      opts.ChannelLabel,
+			Negotiated: opts.Negotiated,
+			ID:         opts.ChannelID,
+		},
+		Records:       terms,
+		IdleTimeout:   opts.ConnectTimeout,
+		FinishTimeout: opts.FinishTimeout,
+		OnConnection:  s.connection,
+		OnState:       s.state,
+		OnProgress:    s.progress,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.srv = srv
+	return s, nil
+}
+
+// Answer answers a receiver's offer and streams the file to it once it
+// connects. ctx bounds the whole transfer: canceling it closes the
+// connection.
+func (s *Sender) Answer(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	var answer webrtc.SessionDescription
+	answerJSON, err := s.srv.AnswerContext(ctx, offer)
+	if err != nil {
+		return answer, err
+	}
+	if err := json.Unmarshal(answerJSON, &answer); err != nil {
+		return answer, fmt.Errorf("failed to parse answer: %w", err)
+	}
+	return answer, nil
+}
+
+// Handler returns an HTTP handler answering the offers receivers POST as a
+// JSON session description, with the answer in the same form. Offers that
+// are not application/json, too large or not a parsable offer are answered
+// with a JSON error carrying a code, like the webrtc-poc server does. The offer's
+// query may ask for smaller records with max_record, another policy with
+// oversized and a digest of the stream with verify, like the webrtc-poc
+// client does. ctx bounds the transfers the handler starts.
+func (s *Sender) Handler(ctx context.Context) http.Handler {
+	return s.srv.OfferHandler(ctx)
+}
+
+// Wait blocks until every transfer started so far ended and its connection
+// was closed
+func (s *Sender) Wait() {
+	s.srv.Wait()
+	s.wg.Wait()
+}
+
+// connection tracks the connection of a receiver until it is closed
+func (s *Sender) connection(id string, pc *webrtc.PeerConnection) {
+	s.mu.Lock()
+	s.connections[id] = &connection{}
+	s.mu.Unlock()
+	s.wg.Add(1)
+	if s.opts.OnPeerConnection != nil {
+		s.opts.OnPeerConnection(id, pc)
+	}
+}
+
+// state notes which receivers connected and which connections closed
+func (s *Sender) state(id string, state webrtc.PeerConnectionState) {
+	if s.opts.OnState != nil {
+		s.opts.OnState(id, state)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.connections[id]
+	if !ok {
+		return
+	}
+	switch state {
+	case webrtc.PeerConnectionStateConnected:
+		c.connected = true
+	case webrtc.PeerConnectionStateClosed:
+		c.closed = true
+		s.forget(id, c)
+		s.wg.Done()
+	}
+}
+
+// progress reports a transfer, ending with ErrNotConnected if its receiver
+// never connected
+func (s *Sender) progress(p server.Progress) {
+	if p.Done {
+		s.mu.Lock()
+		if c, ok := s.connections[p.ID]; ok {
+			if p.Err != nil && !c.connected {
+				p.Err = ErrNotConnected
+			}
+			c.done = true
+			s.forget(p.ID, c)
+		}
+		s.mu.Unlock()
+	}
+	if s.opts.OnProgress != nil {
+		s.opts.OnProgress(Progress(p))
+	}
+}
+
+// forget drops a connection that was closed and whose transfer was reported
+// done. s.mu must be held.
+func (s *Sender) forget(id string, c *connection) {
+	if c.closed && c.done {
+		delete(s.connections, id)
+	}
+}
@@ -0,0 +1,68 @@
+package sender
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	if _, err := New(Options{}); err == nil {
+		t.Error("Expected an error without a file")
+	}
+	if _, err := New(Options{File: "f", Oversized: "drop"}); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+	if _, err := New(Options{File: "f", MaxRecordSize: 10}); err == nil {
+		t.Error("Expected an error for a maximum record size below the minimum")
+	}
+
+	s, err := New(Options{File: "f"})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	if s.opts.ChannelLabel != DefaultChannelLabel || s.opts.ConnectTimeout != DefaultConnectTimeout || s.opts.FinishTimeout != DefaultFinishTimeout {
+		t.Errorf("Expected the defaults filled in, got %+v", s.opts)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s, err := New(Options{Open: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("line\n")), nil }})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	handler := s.Handler(context.Background())
+
+	const jsonType = "application/json"
+	for _, tt := range []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"Method", http.MethodGet, "/offer", "", "", http.StatusMethodNotAllowed},
+		{"MaxRecord", http.MethodPost, "/offer?max_record=big", jsonType, "{}", http.StatusBadRequest},
+		{"Terms", http.MethodPost, "/offer?oversized=drop", jsonType, "{}", http.StatusBadRequest},
+		{"Offer", http.MethodPost, "/offer", jsonType, "not json", http.StatusBadRequest},
+		{"ContentType", http.MethodPost, "/offer", "text/plain", `{"type":"offer","sdp":"v=0"}`, http.StatusUnsupportedMediaType},
+		{"SDP", http.MethodPost, "/offer", jsonType, `{"type":"offer","sdp":"v=0"}`, http.StatusBadRequest},
+		{"Description", http.MethodPost, "/offer", jsonType, `{"type":"offer","sdp":"v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"}`, http.StatusInternalServerError},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+	// The connection of the offer that could not be answered was closed
+	s.Wait()
+}